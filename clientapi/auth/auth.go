@@ -81,6 +81,15 @@ func VerifyUserFromRequest(
 			}
 		}
 	}
+	if res.Expired {
+		return nil, &util.JSONResponse{
+			Code: http.StatusUnauthorized,
+			JSON: SoftLogoutError{
+				MatrixError: spec.UnknownToken("Access token has expired"),
+				SoftLogout:  true,
+			},
+		}
+	}
 	if res.Device == nil {
 		return nil, &util.JSONResponse{
 			Code: http.StatusUnauthorized,
@@ -90,6 +99,31 @@ func VerifyUserFromRequest(
 	return res.Device, nil
 }
 
+// VerifyAccountNotLocked returns an M_USER_LOCKED error if the account of the device
+// is locked, or nil otherwise. Locked users are soft logged out, so that they can
+// use the same device again once the account is unlocked.
+func VerifyAccountNotLocked(device *api.Device) *util.JSONResponse {
+	if !device.AccountLocked {
+		return nil
+	}
+	return &util.JSONResponse{
+		Code: http.StatusUnauthorized,
+		JSON: SoftLogoutError{
+			MatrixError: spec.MatrixError{ErrCode: "M_USER_LOCKED", Err: "This account has been locked"},
+			SoftLogout:  true,
+		},
+	}
+}
+
+// SoftLogoutError is an M_UNKNOWN_TOKEN error telling the client that its session
+// is still valid and the access token can be refreshed, or the user can log in again
+// with the same device.
+// https://spec.matrix.org/v1.7/client-server-api/#soft-logout
+type SoftLogoutError struct {
+	spec.MatrixError
+	SoftLogout bool `json:"soft_logout"`
+}
+
 // GenerateAccessToken creates a new access token. Returns an error if failed to generate
 // random bytes.
 func GenerateAccessToken() (string, error) {
@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+func TestVerifyAccountNotLocked(t *testing.T) {
+	if res := VerifyAccountNotLocked(&api.Device{UserID: "@alice:test"}); res != nil {
+		t.Fatalf("expected no error for an unlocked account, got %+v", res)
+	}
+	res := VerifyAccountNotLocked(&api.Device{UserID: "@alice:test", AccountLocked: true})
+	if res == nil || res.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a locked account, got %+v", res)
+	}
+	jsonErr, ok := res.JSON.(SoftLogoutError)
+	if !ok || jsonErr.ErrCode != "M_USER_LOCKED" || !jsonErr.SoftLogout {
+		t.Fatalf("expected a soft logout M_USER_LOCKED error, got %+v", res.JSON)
+	}
+}
@@ -11,6 +11,7 @@ const (
 	LoginTypeRecaptcha          = "m.login.recaptcha"
 	LoginTypeApplicationService = "m.login.application_service"
 	LoginTypeToken              = "m.login.token"
+	LoginTypeSSO                = "m.login.sso"
 	LoginTypeJwt                = "org.matrix.login.jwt"
 	LoginTypeEmail              = "m.login.email.identity"
 )
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/util"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksMinRefreshInterval limits how often the key set is fetched again
+	// when tokens are signed by unknown keys.
+	jwksMinRefreshInterval = time.Minute
+	// jwksMaxSize is the maximum size of a fetched key set.
+	jwksMaxSize = 1024 * 1024
+)
+
+// JWTKeySet provides the public keys JWT logins are verified with. Keys are read
+// from the JSON Web Key Set configured in the JwtConfig and cached, or if none is
+// configured, the static secret key is used.
+type JWTKeySet struct {
+	cfg    *config.JwtConfig
+	client *http.Client
+	now    func() time.Time
+	// fetches makes concurrent logins share a single fetch of the key set.
+	fetches singleflight.Group
+	// mu protects the fields below. It is not held while fetching, so logins
+	// with known keys aren't blocked by a slow identity provider.
+	mu        sync.Mutex
+	keys      map[string]*jwk
+	fetchedAt time.Time
+	triedAt   time.Time
+}
+
+// jwk is a public key of a JSON Web Key Set.
+type jwk struct {
+	key crypto.PublicKey
+	// The algorithm the key must be used with, if the key set specifies one.
+	alg string
+}
+
+func NewJWTKeySet(cfg *config.JwtConfig) *JWTKeySet {
+	return &JWTKeySet{
+		cfg:    cfg,
+		client: &http.Client{Timeout: time.Second * 30},
+		now:    time.Now,
+	}
+}
+
+// Key returns the key with the given key ID and algorithm. If the key set contains
+// a single key, it is used for tokens without a key ID.
+func (s *JWTKeySet) Key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	if s.cfg.JWKSURL == "" && s.cfg.JWKSFile == "" {
+		if s.cfg.SecretKey == nil {
+			return nil, fmt.Errorf("no key configured for JWT login")
+		}
+		return s.cfg.SecretKey, nil
+	}
+
+	now := s.now()
+	s.mu.Lock()
+	stale := s.keys == nil || now.Sub(s.fetchedAt) >= s.cfg.JWKSRefreshInterval
+	s.mu.Unlock()
+	if stale {
+		s.refresh(ctx, now)
+	}
+	s.mu.Lock()
+	k := s.lookup(kid)
+	// The key may have been rotated since the key set was fetched.
+	rotated := k == nil && now.Sub(s.triedAt) >= jwksMinRefreshInterval
+	s.mu.Unlock()
+	if rotated {
+		s.refresh(ctx, now)
+		s.mu.Lock()
+		k = s.lookup(kid)
+		s.mu.Unlock()
+	}
+	if k == nil {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if k.alg != "" && k.alg != alg {
+		return nil, fmt.Errorf("key %q must be used with %s, not %s", kid, k.alg, alg)
+	}
+	return k.key, nil
+}
+
+// lookup must be called with mu held.
+func (s *JWTKeySet) lookup(kid string) *jwk {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k
+		}
+	}
+	return s.keys[kid]
+}
+
+// refresh fetches the key set, waiting for a fetch which is already in progress
+// instead of starting another one. Keys of a previous fetch are kept on failure,
+// so an unavailable identity provider doesn't prevent logins.
+func (s *JWTKeySet) refresh(ctx context.Context, now time.Time) {
+	_, _, _ = s.fetches.Do("jwks", func() (interface{}, error) {
+		s.mu.Lock()
+		s.triedAt = now
+		s.mu.Unlock()
+		keys, err := s.fetch(ctx)
+		if err != nil {
+			util.GetLogger(ctx).WithError(err).Error("Failed to fetch JWKS for JWT login")
+			return nil, nil
+		}
+		s.mu.Lock()
+		s.keys = keys
+		s.fetchedAt = now
+		s.mu.Unlock()
+		return nil, nil
+	})
+}
+
+func (s *JWTKeySet) fetch(ctx context.Context) (map[string]*jwk, error) {
+	var data []byte
+	if s.cfg.JWKSFile != "" {
+		var err error
+		if data, err = os.ReadFile(string(s.cfg.JWKSFile)); err != nil {
+			return nil, err
+		}
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.JWKSURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close() // nolint: errcheck
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(res.Body, jwksMaxSize)); err != nil {
+			return nil, err
+		}
+	}
+	return parseJWKS(data)
+}
+
+// parseJWKS parses the signing keys of a JSON Web Key Set (RFC 7517). Keys of
+// unsupported types are skipped.
+func parseJWKS(data []byte) (map[string]*jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	keys := make(map[string]*jwk, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch k.Kty {
+		case "RSA":
+			key, err = parseRSAJWK(k.N, k.E)
+		case "EC":
+			key, err = parseECJWK(k.Crv, k.X, k.Y)
+		case "OKP":
+			key, err = parseOKPJWK(k.Crv, k.X)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &jwk{key: key, alg: k.Alg}
+	}
+	return keys, nil
+}
+
+func parseRSAJWK(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(eb)
+	if len(nb) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid RSA key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(exponent.Int64())}, nil
+}
+
+func parseECJWK(crv, x, y string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate: %w", err)
+	}
+	yb, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y coordinate: %w", err)
+	}
+	key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}
+	if !curve.IsOnCurve(key.X, key.Y) {
+		return nil, fmt.Errorf("point is not on curve %s", crv)
+	}
+	return key, nil
+}
+
+func parseOKPJWK(crv, x string) (ed25519.PublicKey, error) {
+	if crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil || len(xb) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 key")
+	}
+	return ed25519.PublicKey(xb), nil
+}
@@ -42,6 +42,7 @@ func LoginFromJSONReader(
 	userAPI UserInternalAPIForLogin,
 	cfg *config.ClientAPI,
 	rt *ratelimit.RtFailedLogin,
+	jwtKeys *JWTKeySet,
 ) (*Login, LoginCleanupFunc, *util.JSONResponse) {
 	reqBytes, err := io.ReadAll(req.Body)
 	if err != nil {
@@ -93,8 +94,16 @@ func LoginFromJSONReader(
 			Token:  token,
 		}
 	case authtypes.LoginTypeJwt:
+		if !cfg.JwtConfig.Enabled || jwtKeys == nil {
+			return nil, nil, &util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("unhandled login type: " + header.Type),
+			}
+		}
 		typ = &LoginTypeTokenJwt{
-			Config: cfg,
+			UserAPI: useraccountAPI,
+			Config:  cfg,
+			Keys:    jwtKeys,
 		}
 	default:
 		err := util.JSONResponse{
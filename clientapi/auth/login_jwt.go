@@ -2,21 +2,30 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/setup/config"
+	uapi "github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/gomatrixserverlib/spec"
 	"github.com/matrix-org/util"
 )
 
 // LoginTypeToken describes how to authenticate with a login token.
 type LoginTypeTokenJwt struct {
-	// UserAPI uapi.LoginTokenInternalAPI
-	Config *config.ClientAPI
+	UserAPI uapi.ClientUserAPI
+	Config  *config.ClientAPI
+	Keys    *JWTKeySet
 }
 
 // Name implements Type.
@@ -25,7 +34,16 @@ func (t *LoginTypeTokenJwt) Name() string {
 }
 
 type Claims struct {
-	jwt.StandardClaims
+	jwt.RegisteredClaims
+	// All claims of the token, to look up the claims configured in JwtClaims.
+	Extra map[string]interface{} `json:"-"`
+}
+
+func (c *Claims) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &c.RegisteredClaims); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &c.Extra)
 }
 
 const mIdUser = "m.id.user"
@@ -44,12 +62,17 @@ func (t *LoginTypeTokenJwt) LoginFromJSON(ctx context.Context, reqBytes []byte)
 			JSON: spec.Forbidden("Token field for JWT is missing"),
 		}
 	}
+	cfg := &t.Config.JwtConfig
+	validMethods := config.JwtAlgorithms
+	if cfg.Algorithm != "" {
+		validMethods = []string{cfg.Algorithm}
+	}
+	// The claims are validated by validateClaims, as the parser doesn't allow for clock skew.
+	parser := jwt.NewParser(jwt.WithValidMethods(validMethods), jwt.WithoutClaimsValidation())
 	c := &Claims{}
-	token, err := jwt.ParseWithClaims(r.Token, c, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodEd25519); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
-		}
-		return t.Config.JwtConfig.SecretKey, nil
+	token, err := parser.ParseWithClaims(r.Token, c, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return t.Keys.Key(ctx, kid, token.Method.Alg())
 	})
 
 	if err != nil {
@@ -67,8 +90,190 @@ func (t *LoginTypeTokenJwt) LoginFromJSON(ctx context.Context, reqBytes []byte)
 		}
 	}
 
+	if err = validateClaims(c, cfg, time.Now()); err != nil {
+		util.GetLogger(ctx).WithError(err).Warn("JWT claims are invalid")
+		return nil, nil, &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.Forbidden("Invalid JWT: " + err.Error()),
+		}
+	}
+
 	r.Login.Identifier.User = c.Subject
 	r.Login.Identifier.Type = mIdUser
 
+	if errRes := t.syncAccount(ctx, &r.Login, c); errRes != nil {
+		return nil, nil, errRes
+	}
+
 	return &r.Login, func(context.Context, *util.JSONResponse) {}, nil
 }
+
+// syncAccount creates the account of the subject if auto provisioning is enabled, and
+// updates the account type and profile from the claims configured in JwtClaims. If the
+// profile changed, it is set as the ProfileUpdate of the login.
+func (t *LoginTypeTokenJwt) syncAccount(ctx context.Context, login *Login, c *Claims) *util.JSONResponse {
+	cfg := &t.Config.JwtConfig
+	if !cfg.AutoProvision && cfg.Claims == (config.JwtClaims{}) {
+		return nil
+	}
+	localpart, domain, err := userutil.ParseUsernameParam(c.Subject, t.Config.Matrix)
+	if err != nil {
+		return &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.InvalidUsername(err.Error()),
+		}
+	}
+	if !t.Config.Matrix.IsLocalServerName(domain) {
+		return &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.InvalidUsername("The server name is not known."),
+		}
+	}
+
+	isAdmin, syncAdmin := jwtAdminClaim(c, &cfg.Claims)
+	accountType := uapi.AccountTypeUser
+	if isAdmin {
+		accountType = uapi.AccountTypeAdmin
+	}
+
+	var existing uapi.QueryAccountByLocalpartResponse
+	err = t.UserAPI.QueryAccountByLocalpart(ctx, &uapi.QueryAccountByLocalpartRequest{
+		Localpart:  localpart,
+		ServerName: domain,
+	}, &existing)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if !cfg.AutoProvision {
+			return nil
+		}
+		if err = internal.ValidateUsername(localpart, domain); err != nil {
+			return &util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: spec.InvalidUsername(err.Error()),
+			}
+		}
+		// The account has no password, so it can only log in with a token.
+		var created uapi.PerformAccountCreationResponse
+		if err = t.UserAPI.PerformAccountCreation(ctx, &uapi.PerformAccountCreationRequest{
+			Localpart:   localpart,
+			ServerName:  domain,
+			AccountType: accountType,
+			OnConflict:  uapi.ConflictAbort,
+		}, &created); err != nil {
+			util.GetLogger(ctx).WithError(err).Error("userAPI.PerformAccountCreation failed")
+			return &util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		util.GetLogger(ctx).WithField("user_id", created.Account.UserID).Info("Created account for JWT login")
+	case err != nil:
+		util.GetLogger(ctx).WithError(err).Error("userAPI.QueryAccountByLocalpart failed")
+		return &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	case syncAdmin && existing.Account.AccountType != accountType &&
+		(existing.Account.AccountType == uapi.AccountTypeUser || existing.Account.AccountType == uapi.AccountTypeAdmin):
+		if err = t.UserAPI.PerformAccountTypeUpdate(ctx, &uapi.PerformAccountTypeUpdateRequest{
+			Localpart:   localpart,
+			ServerName:  domain,
+			AccountType: accountType,
+		}); err != nil {
+			util.GetLogger(ctx).WithError(err).Error("userAPI.PerformAccountTypeUpdate failed")
+			return &util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+	}
+
+	var profile *authtypes.Profile
+	var changed bool
+	if displayName, ok := c.Extra[cfg.Claims.DisplayName].(string); ok && cfg.Claims.DisplayName != "" && displayName != "" {
+		p, updated, err := t.UserAPI.SetDisplayName(ctx, localpart, domain, displayName)
+		if err != nil {
+			util.GetLogger(ctx).WithError(err).Error("userAPI.SetDisplayName failed")
+			return &util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		profile, changed = p, changed || updated
+	}
+	if avatarURL, ok := c.Extra[cfg.Claims.AvatarURL].(string); ok && cfg.Claims.AvatarURL != "" && avatarURL != "" {
+		if !strings.HasPrefix(avatarURL, "mxc://") {
+			util.GetLogger(ctx).WithField("avatar_url", avatarURL).Warn("Ignoring JWT avatar claim which isn't an mxc:// URI")
+		} else {
+			p, updated, err := t.UserAPI.SetAvatarURL(ctx, localpart, domain, avatarURL)
+			if err != nil {
+				util.GetLogger(ctx).WithError(err).Error("userAPI.SetAvatarURL failed")
+				return &util.JSONResponse{
+					Code: http.StatusInternalServerError,
+					JSON: spec.InternalServerError{},
+				}
+			}
+			profile, changed = p, changed || updated
+		}
+	}
+	if changed {
+		login.ProfileUpdate = profile
+	}
+	return nil
+}
+
+// jwtAdminClaim returns whether the claims make the user an admin, and whether
+// the admin claim is configured and present at all.
+func jwtAdminClaim(c *Claims, claims *config.JwtClaims) (isAdmin bool, present bool) {
+	if claims.Admin == "" {
+		return false, false
+	}
+	value, ok := c.Extra[claims.Admin]
+	if !ok {
+		return false, false
+	}
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case string:
+		return claims.AdminValue != "" && v == claims.AdminValue, true
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && claims.AdminValue != "" && s == claims.AdminValue {
+				return true, true
+			}
+		}
+		return false, true
+	}
+	return false, true
+}
+
+// validateClaims checks the registered claims of a token against the config. The
+// configured clock skew is allowed for the time based claims.
+func validateClaims(c *Claims, cfg *config.JwtConfig, now time.Time) error {
+	if !c.VerifyExpiresAt(now.Add(-cfg.ClockSkew), true) {
+		return fmt.Errorf("token is expired or has no expiry")
+	}
+	if !c.VerifyNotBefore(now.Add(cfg.ClockSkew), false) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if !c.VerifyIssuedAt(now.Add(cfg.ClockSkew), false) {
+		return fmt.Errorf("token is issued in the future")
+	}
+	if cfg.Issuer != "" && !c.VerifyIssuer(cfg.Issuer, true) {
+		return fmt.Errorf("unexpected issuer")
+	}
+	if len(cfg.Audiences) > 0 {
+		valid := false
+		for _, aud := range cfg.Audiences {
+			valid = valid || c.VerifyAudience(aud, true)
+		}
+		if !valid {
+			return fmt.Errorf("unexpected audience")
+		}
+	}
+	if c.Subject == "" {
+		return fmt.Errorf("token has no subject")
+	}
+	return nil
+}
@@ -0,0 +1,477 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	"github.com/matrix-org/dendrite/setup/config"
+	uapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib/fclient"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+		"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(key.X.FillBytes(make([]byte, 32))), "y": b64(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %s", err)
+	}
+	return s
+}
+
+func jwtLogin(cfg *config.ClientAPI, keys *JWTKeySet, token string) (string, int) {
+	typ := &LoginTypeTokenJwt{Config: cfg, Keys: keys}
+	login, _, errRes := typ.LoginFromJSON(context.Background(), []byte(fmt.Sprintf(`{"type":"org.matrix.login.jwt","token":%q}`, token)))
+	if errRes != nil {
+		return "", errRes.Code
+	}
+	return login.Identifier.User, http.StatusOK
+}
+
+func TestLoginTypeTokenJwtJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rotated atomic.Bool
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		keys := []map[string]string{rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("ec", &ecKey.PublicKey)}
+		if rotated.Load() {
+			keys = append(keys, ecJWK("rotated", &rotatedKey.PublicKey))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer srv.Close()
+
+	cfg := &config.ClientAPI{}
+	cfg.JwtConfig.Defaults()
+	cfg.JwtConfig.Enabled = true
+	cfg.JwtConfig.JWKSURL = srv.URL
+	cfg.JwtConfig.Issuer = "https://idp.example.com"
+	cfg.JwtConfig.Audiences = []string{"dendrite"}
+	keys := NewJWTKeySet(&cfg.JwtConfig)
+	now := time.Now()
+	keys.now = func() time.Time { return now }
+
+	claims := jwt.RegisteredClaims{
+		Subject:   "alice",
+		Issuer:    "https://idp.example.com",
+		Audience:  jwt.ClaimStrings{"dendrite"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	user, code := jwtLogin(cfg, keys, signJWT(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims))
+	if code != http.StatusOK || user != "alice" {
+		t.Fatalf("RS256 login failed: %d", code)
+	}
+	if _, code = jwtLogin(cfg, keys, signJWT(t, jwt.SigningMethodES256, "ec", ecKey, claims)); code != http.StatusOK {
+		t.Fatalf("ES256 login failed: %d", code)
+	}
+	// The key set is cached.
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("expected 1 fetch, got %d", n)
+	}
+
+	// The key of the kid must be used.
+	if _, code = jwtLogin(cfg, keys, signJWT(t, jwt.SigningMethodES256, "rsa", ecKey, claims)); code != http.StatusForbidden {
+		t.Fatalf("expected token with wrong kid to be rejected, got %d", code)
+	}
+	// Symmetric algorithms are never accepted.
+	if _, code = jwtLogin(cfg, keys, signJWT(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), claims)); code != http.StatusForbidden {
+		t.Fatalf("expected HS256 token to be rejected, got %d", code)
+	}
+
+	// An unknown kid fetches the key set again, but not more than once a minute.
+	rotated.Store(true)
+	if _, code = jwtLogin(cfg, keys, signJWT(t, jwt.SigningMethodES256, "rotated", rotatedKey, claims)); code != http.StatusForbidden {
+		t.Fatalf("expected unknown kid to be rejected within a minute of the last fetch, got %d", code)
+	}
+	now = now.Add(jwksMinRefreshInterval)
+	if _, code = jwtLogin(cfg, keys, signJWT(t, jwt.SigningMethodES256, "rotated", rotatedKey, claims)); code != http.StatusOK {
+		t.Fatalf("expected rotated key to be fetched, got %d", code)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("expected 2 fetches, got %d", n)
+	}
+
+	// Only the configured algorithm is accepted.
+	cfg.JwtConfig.Algorithm = "RS256"
+	if _, code = jwtLogin(cfg, keys, signJWT(t, jwt.SigningMethodES256, "ec", ecKey, claims)); code != http.StatusForbidden {
+		t.Fatalf("expected ES256 token to be rejected, got %d", code)
+	}
+}
+
+func TestJWTKeySetFetchDoesNotBlockKnownKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var slow atomic.Bool
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			entered <- struct{}{}
+			<-release
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{ecJWK("ec", &ecKey.PublicKey)}})
+	}))
+	defer srv.Close()
+
+	cfg := &config.ClientAPI{}
+	cfg.JwtConfig.Defaults()
+	cfg.JwtConfig.Enabled = true
+	cfg.JwtConfig.JWKSURL = srv.URL
+	keys := NewJWTKeySet(&cfg.JwtConfig)
+	now := time.Now()
+	keys.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	if _, err = keys.Key(ctx, "ec", "ES256"); err != nil {
+		t.Fatalf("failed to fetch the key set: %s", err)
+	}
+
+	// An unknown kid fetches the key set again, which takes a while.
+	now = now.Add(jwksMinRefreshInterval)
+	slow.Store(true)
+	unknownErr := make(chan error)
+	go func() {
+		_, err := keys.Key(ctx, "unknown", "ES256")
+		unknownErr <- err
+	}()
+	<-entered
+
+	// Known keys are still available in the meantime.
+	knownErr := make(chan error)
+	go func() {
+		_, err := keys.Key(ctx, "ec", "ES256")
+		knownErr <- err
+	}()
+	select {
+	case err = <-knownErr:
+		if err != nil {
+			t.Fatalf("failed to get known key: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("getting a known key was blocked by the fetch")
+	}
+
+	close(release)
+	if err = <-unknownErr; err == nil {
+		t.Fatalf("expected unknown kid to be rejected")
+	}
+}
+
+func TestLoginTypeTokenJwtStaticKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ClientAPI{}
+	cfg.JwtConfig.Defaults()
+	cfg.JwtConfig.Enabled = true
+	cfg.JwtConfig.SecretKey = pub
+	keys := NewJWTKeySet(&cfg.JwtConfig)
+
+	token := signJWT(t, jwt.SigningMethodEdDSA, "", priv, jwt.RegisteredClaims{
+		Subject:   "alice",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	if user, code := jwtLogin(cfg, keys, token); code != http.StatusOK || user != "alice" {
+		t.Fatalf("EdDSA login failed: %d", code)
+	}
+}
+
+func TestValidateJwtClaims(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cfg := &config.JwtConfig{
+		Issuer:    "https://idp.example.com",
+		Audiences: []string{"dendrite", "other"},
+		ClockSkew: time.Minute,
+	}
+	valid := func() *Claims {
+		return &Claims{RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "alice",
+			Issuer:    "https://idp.example.com",
+			Audience:  jwt.ClaimStrings{"other"},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		}}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Claims)
+		valid  bool
+	}{
+		{name: "valid", modify: func(c *Claims) {}, valid: true},
+		{name: "expired within skew", modify: func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-30 * time.Second)) }, valid: true},
+		{name: "expired", modify: func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-2 * time.Minute)) }},
+		{name: "no expiry", modify: func(c *Claims) { c.ExpiresAt = nil }},
+		{name: "not before within skew", modify: func(c *Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(30 * time.Second)) }, valid: true},
+		{name: "not before", modify: func(c *Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(2 * time.Minute)) }},
+		{name: "issued in the future", modify: func(c *Claims) { c.IssuedAt = jwt.NewNumericDate(now.Add(2 * time.Minute)) }},
+		{name: "wrong issuer", modify: func(c *Claims) { c.Issuer = "https://evil.example.com" }},
+		{name: "wrong audience", modify: func(c *Claims) { c.Audience = jwt.ClaimStrings{"evil"} }},
+		{name: "no audience", modify: func(c *Claims) { c.Audience = nil }},
+		{name: "no subject", modify: func(c *Claims) { c.Subject = "" }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := valid()
+			tc.modify(c)
+			err := validateClaims(c, cfg, now)
+			if tc.valid && err != nil {
+				t.Fatalf("expected claims to be valid, got %s", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf("expected claims to be invalid")
+			}
+		})
+	}
+}
+
+func TestLoginFromJSONReaderJwtDisabled(t *testing.T) {
+	cfg := &config.ClientAPI{Matrix: &config.Global{}}
+	req := httptest.NewRequest(http.MethodPost, "/_matrix/client/v3/login", strings.NewReader(`{"type":"org.matrix.login.jwt","token":"x"}`))
+	_, _, errRes := LoginFromJSONReader(req, nil, nil, cfg, nil, NewJWTKeySet(&cfg.JwtConfig))
+	if errRes == nil || errRes.Code != http.StatusBadRequest {
+		t.Fatalf("expected JWT login to be rejected when disabled, got %+v", errRes)
+	}
+	if e, ok := errRes.JSON.(spec.MatrixError); !ok || e.ErrCode != spec.ErrorInvalidParam {
+		t.Fatalf("unexpected error: %+v", errRes.JSON)
+	}
+}
+
+type fakeJwtUserAPI struct {
+	uapi.ClientUserAPI
+	accounts map[string]*uapi.Account
+	profiles map[string]*authtypes.Profile
+}
+
+func (ua *fakeJwtUserAPI) QueryAccountByLocalpart(ctx context.Context, req *uapi.QueryAccountByLocalpartRequest, res *uapi.QueryAccountByLocalpartResponse) error {
+	acc, ok := ua.accounts[req.Localpart]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	res.Account = acc
+	return nil
+}
+
+func (ua *fakeJwtUserAPI) PerformAccountCreation(ctx context.Context, req *uapi.PerformAccountCreationRequest, res *uapi.PerformAccountCreationResponse) error {
+	res.Account = &uapi.Account{
+		UserID:      fmt.Sprintf("@%s:%s", req.Localpart, req.ServerName),
+		Localpart:   req.Localpart,
+		ServerName:  req.ServerName,
+		AccountType: req.AccountType,
+	}
+	res.AccountCreated = true
+	ua.accounts[req.Localpart] = res.Account
+	ua.profiles[req.Localpart] = &authtypes.Profile{Localpart: req.Localpart, ServerName: string(req.ServerName)}
+	return nil
+}
+
+func (ua *fakeJwtUserAPI) PerformAccountTypeUpdate(ctx context.Context, req *uapi.PerformAccountTypeUpdateRequest) error {
+	ua.accounts[req.Localpart].AccountType = req.AccountType
+	return nil
+}
+
+func (ua *fakeJwtUserAPI) SetDisplayName(ctx context.Context, localpart string, serverName spec.ServerName, displayName string) (*authtypes.Profile, bool, error) {
+	p := ua.profiles[localpart]
+	changed := p.DisplayName != displayName
+	p.DisplayName = displayName
+	return p, changed, nil
+}
+
+func (ua *fakeJwtUserAPI) SetAvatarURL(ctx context.Context, localpart string, serverName spec.ServerName, avatarURL string) (*authtypes.Profile, bool, error) {
+	p := ua.profiles[localpart]
+	changed := p.AvatarURL != avatarURL
+	p.AvatarURL = avatarURL
+	return p, changed, nil
+}
+
+func TestLoginTypeTokenJwtProvisioning(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ClientAPI{
+		Matrix: &config.Global{
+			SigningIdentity: fclient.SigningIdentity{ServerName: "example.com"},
+		},
+	}
+	cfg.JwtConfig.Defaults()
+	cfg.JwtConfig.Enabled = true
+	cfg.JwtConfig.SecretKey = pub
+	cfg.JwtConfig.Claims = config.JwtClaims{
+		DisplayName: "name",
+		AvatarURL:   "picture",
+		Admin:       "roles",
+		AdminValue:  "matrix-admin",
+	}
+	userAPI := &fakeJwtUserAPI{
+		accounts: map[string]*uapi.Account{},
+		profiles: map[string]*authtypes.Profile{},
+	}
+	typ := &LoginTypeTokenJwt{UserAPI: userAPI, Config: cfg, Keys: NewJWTKeySet(&cfg.JwtConfig)}
+
+	login := func(claims jwt.MapClaims) (*Login, int) {
+		t.Helper()
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(priv)
+		if err != nil {
+			t.Fatalf("failed to sign token: %s", err)
+		}
+		l, _, errRes := typ.LoginFromJSON(context.Background(), []byte(fmt.Sprintf(`{"type":"org.matrix.login.jwt","token":%q}`, token)))
+		if errRes != nil {
+			return nil, errRes.Code
+		}
+		return l, http.StatusOK
+	}
+
+	// Accounts are only created if auto provisioning is enabled.
+	if _, code := login(jwt.MapClaims{"sub": "alice"}); code != http.StatusOK {
+		t.Fatalf("login failed: %d", code)
+	}
+	if _, ok := userAPI.accounts["alice"]; ok {
+		t.Fatalf("account was created without auto provisioning")
+	}
+
+	cfg.JwtConfig.AutoProvision = true
+	l, code := login(jwt.MapClaims{
+		"sub":     "alice",
+		"name":    "Alice",
+		"picture": "mxc://example.com/alice",
+		"roles":   []string{"user", "matrix-admin"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("login failed: %d", code)
+	}
+	acc, ok := userAPI.accounts["alice"]
+	if !ok {
+		t.Fatalf("account was not created")
+	}
+	if acc.AccountType != uapi.AccountTypeAdmin {
+		t.Fatalf("expected admin account, got %d", acc.AccountType)
+	}
+	if l.ProfileUpdate == nil || l.ProfileUpdate.DisplayName != "Alice" || l.ProfileUpdate.AvatarURL != "mxc://example.com/alice" {
+		t.Fatalf("unexpected profile update: %+v", l.ProfileUpdate)
+	}
+
+	// An unchanged profile isn't updated, but the admin role is revoked.
+	l, code = login(jwt.MapClaims{
+		"sub":     "alice",
+		"name":    "Alice",
+		"picture": "https://example.com/alice.png",
+		"roles":   []string{"user"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("login failed: %d", code)
+	}
+	if l.ProfileUpdate != nil {
+		t.Fatalf("unexpected profile update: %+v", l.ProfileUpdate)
+	}
+	if acc.AccountType != uapi.AccountTypeUser {
+		t.Fatalf("expected user account, got %d", acc.AccountType)
+	}
+	if userAPI.profiles["alice"].AvatarURL != "mxc://example.com/alice" {
+		t.Fatalf("avatar was changed to a non-mxc URI")
+	}
+
+	// A missing admin claim leaves the account type unchanged.
+	acc.AccountType = uapi.AccountTypeAdmin
+	if _, code = login(jwt.MapClaims{"sub": "alice"}); code != http.StatusOK {
+		t.Fatalf("login failed: %d", code)
+	}
+	if acc.AccountType != uapi.AccountTypeAdmin {
+		t.Fatalf("account type changed without an admin claim")
+	}
+
+	// Subjects of other servers and invalid localparts are rejected.
+	if _, code = login(jwt.MapClaims{"sub": "@bob:other.com"}); code != http.StatusForbidden {
+		t.Fatalf("expected remote subject to be rejected, got %d", code)
+	}
+	if _, code = login(jwt.MapClaims{"sub": "Bob!"}); code != http.StatusForbidden {
+		t.Fatalf("expected invalid localpart to be rejected, got %d", code)
+	}
+}
+
+func TestJwtAdminClaim(t *testing.T) {
+	claims := &config.JwtClaims{Admin: "admin", AdminValue: "yes"}
+	tests := []struct {
+		value       interface{}
+		wantAdmin   bool
+		wantPresent bool
+	}{
+		{value: nil},
+		{value: true, wantAdmin: true, wantPresent: true},
+		{value: false, wantPresent: true},
+		{value: "yes", wantAdmin: true, wantPresent: true},
+		{value: "no", wantPresent: true},
+		{value: []interface{}{"no", "yes"}, wantAdmin: true, wantPresent: true},
+		{value: []interface{}{"no"}, wantPresent: true},
+		{value: 1.0, wantPresent: true},
+	}
+	for _, tc := range tests {
+		c := &Claims{Extra: map[string]interface{}{}}
+		if tc.value != nil {
+			c.Extra["admin"] = tc.value
+		}
+		isAdmin, present := jwtAdminClaim(c, claims)
+		if isAdmin != tc.wantAdmin || present != tc.wantPresent {
+			t.Errorf("claim %v: got admin=%v present=%v, want admin=%v present=%v", tc.value, isAdmin, present, tc.wantAdmin, tc.wantPresent)
+		}
+	}
+}
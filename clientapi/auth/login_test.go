@@ -127,7 +127,7 @@ func TestLoginFromJSONReader(t *testing.T) {
 				req.Header.Add("Authorization", "Bearer "+tst.Token)
 			}
 
-			login, cleanup, jsonErr := LoginFromJSONReader(req, &userAPI, &userAPI, cfg, nil)
+			login, cleanup, jsonErr := LoginFromJSONReader(req, &userAPI, &userAPI, cfg, nil, nil)
 			if jsonErr != nil {
 				t.Fatalf("LoginFromJSONReader failed: %+v", jsonErr)
 			}
@@ -270,7 +270,7 @@ func TestBadLoginFromJSONReader(t *testing.T) {
 				req.Header.Add("Authorization", "Bearer "+tst.Token)
 			}
 
-			_, cleanup, errRes := LoginFromJSONReader(req, &userAPI, &userAPI, cfg, nil)
+			_, cleanup, errRes := LoginFromJSONReader(req, &userAPI, &userAPI, cfg, nil, nil)
 			if errRes == nil {
 				cleanup(ctx, nil)
 				t.Fatalf("LoginFromJSONReader err: got %+v, want code %q", errRes, tst.WantErrCode)
@@ -345,7 +345,7 @@ func (t *LoginTypePassword) getOrCreateAccount(ctx context.Context, localpart st
 	}, &existing)
 
 	if err == nil {
-		return existing.Account, nil
+		return t.syncLdapAccountType(ctx, existing.Account, admin)
 	}
 	if err != sql.ErrNoRows {
 		return nil, &util.JSONResponse{
@@ -381,3 +381,33 @@ func (t *LoginTypePassword) getOrCreateAccount(ctx context.Context, localpart st
 	}
 	return created.Account, nil
 }
+
+// syncLdapAccountType updates the account type of an existing account, which may have
+// been created by the LDAP sync, to the admin group membership of the user.
+func (t *LoginTypePassword) syncLdapAccountType(ctx context.Context, account *api.Account, admin bool) (*api.Account, *util.JSONResponse) {
+	if t.Config.Ldap.AdminGroupDn == "" {
+		return account, nil
+	}
+	accountType := api.AccountTypeUser
+	if admin {
+		accountType = api.AccountTypeAdmin
+	}
+	if account.AccountType == accountType ||
+		(account.AccountType != api.AccountTypeUser && account.AccountType != api.AccountTypeAdmin) {
+		return account, nil
+	}
+	err := t.UserApi.PerformAccountTypeUpdate(ctx, &api.PerformAccountTypeUpdateRequest{
+		Localpart:   account.Localpart,
+		ServerName:  account.ServerName,
+		AccountType: accountType,
+	})
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("userApi.PerformAccountTypeUpdate failed")
+		return nil, &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	account.AccountType = accountType
+	return account, nil
+}
@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+const (
+	// SSOSessionLifetime is how long a user has to log in at the identity provider.
+	SSOSessionLifetime = 10 * time.Minute
+	// ssoDiscoveryLifetime is how long a discovery document is cached.
+	ssoDiscoveryLifetime = time.Hour
+	// ssoMaxResponseSize is the maximum size of a response of an identity provider.
+	ssoMaxResponseSize = 1024 * 1024
+	// ssoMaxSessions is the maximum number of logins in progress.
+	ssoMaxSessions = 10000
+)
+
+// ErrSSOSessionLimit is returned when too many logins are in progress.
+var ErrSSOSessionLimit = errors.New("too many SSO logins in progress")
+
+// SSO implements the OpenID Connect authorization code flow with the identity
+// providers configured in the SSO config. A session is kept in memory between
+// redirecting the user to the provider and the callback.
+type SSO struct {
+	cfg       *config.SSO
+	client    *http.Client
+	now       func() time.Time
+	providers map[string]*oidcProvider
+	mu        sync.Mutex
+	sessions  map[string]*ssoSession
+}
+
+// SSOUser is a user authenticated by an identity provider.
+type SSOUser struct {
+	// The ID of the identity provider.
+	IDPID string
+	// The subject identifying the user at the identity provider.
+	Subject string
+	// The localpart and display name claimed by the provider, if any.
+	Localpart   string
+	DisplayName string
+	// The URL the client asked to be redirected to after login.
+	RedirectURL string
+}
+
+type ssoSession struct {
+	idpID        string
+	nonce        string
+	codeVerifier string
+	redirectURL  string
+	expires      time.Time
+}
+
+type oidcProvider struct {
+	cfg       *config.IdentityProvider
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	fetchedAt time.Time
+	keys      *JWTKeySet
+}
+
+// oidcDiscovery is the part of an OpenID Connect discovery document we use.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewSSO(cfg *config.SSO) *SSO {
+	s := &SSO{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: time.Second * 30},
+		now:       time.Now,
+		providers: make(map[string]*oidcProvider, len(cfg.Providers)),
+		sessions:  make(map[string]*ssoSession),
+	}
+	for i := range cfg.Providers {
+		s.providers[cfg.Providers[i].ID] = &oidcProvider{cfg: &cfg.Providers[i]}
+	}
+	return s
+}
+
+// Provider returns the identity provider with the given ID, or the first one if
+// the ID is empty. Returns nil if there is no such provider.
+func (s *SSO) Provider(idpID string) *config.IdentityProvider {
+	if idpID == "" && len(s.cfg.Providers) > 0 {
+		return &s.cfg.Providers[0]
+	}
+	if p, ok := s.providers[idpID]; ok {
+		return p.cfg
+	}
+	return nil
+}
+
+// IsValidRedirect returns whether the URL can be used as the redirectUrl of a login.
+// Custom schemes are allowed for native apps, but not schemes which run code.
+func IsValidRedirect(redirectURL string) bool {
+	u, err := url.Parse(redirectURL)
+	if err != nil || u.Scheme == "" {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "javascript", "data", "vbscript":
+		return false
+	}
+	return true
+}
+
+// IsAllowedRedirect returns whether clients may be redirected to the URL after login
+// without asking the user first, i.e. whether it is in the client whitelist.
+func (s *SSO) IsAllowedRedirect(redirectURL string) bool {
+	if !IsValidRedirect(redirectURL) {
+		return false
+	}
+	for _, prefix := range s.cfg.ClientWhitelist {
+		if strings.HasPrefix(redirectURL, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationURL starts a login with the identity provider. It returns the URL
+// the user is redirected to, and the state identifying the session in the callback.
+func (s *SSO) AuthorizationURL(ctx context.Context, idpID, redirectURL string) (authURL, state string, err error) {
+	p, ok := s.providers[idpID]
+	if !ok {
+		return "", "", fmt.Errorf("unknown identity provider %q", idpID)
+	}
+	disc, err := s.discover(ctx, p)
+	if err != nil {
+		return "", "", err
+	}
+	session := &ssoSession{
+		idpID:       idpID,
+		redirectURL: redirectURL,
+		expires:     s.now().Add(SSOSessionLifetime),
+	}
+	if state, err = GenerateAccessToken(); err != nil {
+		return "", "", err
+	}
+	if session.nonce, err = GenerateAccessToken(); err != nil {
+		return "", "", err
+	}
+	if session.codeVerifier, err = GenerateAccessToken(); err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(session.codeVerifier))
+
+	u, err := url.Parse(disc.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", s.cfg.CallbackURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", session.nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for k, v := range s.sessions {
+		if now.After(v.expires) {
+			delete(s.sessions, k)
+		}
+	}
+	if len(s.sessions) >= ssoMaxSessions {
+		return "", "", ErrSSOSessionLimit
+	}
+	s.sessions[state] = session
+	return u.String(), state, nil
+}
+
+// Callback completes the login of the session identified by the state, by
+// exchanging the authorization code for an ID token. A session can only be
+// completed once.
+func (s *SSO) Callback(ctx context.Context, state, code string) (*SSOUser, error) {
+	s.mu.Lock()
+	session, ok := s.sessions[state]
+	delete(s.sessions, state)
+	s.mu.Unlock()
+	if !ok || s.now().After(session.expires) {
+		return nil, fmt.Errorf("unknown or expired SSO session")
+	}
+	p := s.providers[session.idpID]
+	disc, err := s.discover(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.exchangeCode(ctx, p, disc, code, session.codeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	claims, err := s.verifyIDToken(ctx, p, disc, tokens.IDToken, session.nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if disc.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		if err = s.mergeUserinfo(ctx, disc, tokens.AccessToken, claims); err != nil {
+			return nil, fmt.Errorf("failed to get user info: %w", err)
+		}
+	}
+
+	user := &SSOUser{
+		IDPID:       session.idpID,
+		Subject:     claimString(claims.Extra, p.cfg.Claims.Subject),
+		Localpart:   claimString(claims.Extra, p.cfg.Claims.Localpart),
+		DisplayName: claimString(claims.Extra, p.cfg.Claims.DisplayName),
+		RedirectURL: session.redirectURL,
+	}
+	if user.Subject == "" {
+		return nil, fmt.Errorf("missing subject claim %q", p.cfg.Claims.Subject)
+	}
+	return user, nil
+}
+
+// discover returns the discovery document of the provider. A cached document is
+// used if fetching it fails.
+func (s *SSO) discover(ctx context.Context, p *oidcProvider) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := s.now()
+	if p.discovery != nil && now.Sub(p.fetchedAt) < ssoDiscoveryLifetime {
+		return p.discovery, nil
+	}
+	var disc oidcDiscovery
+	err := s.getJSON(ctx, p.cfg.DiscoveryURL, "", &disc)
+	if err == nil && (disc.Issuer == "" || disc.AuthorizationEndpoint == "" || disc.TokenEndpoint == "" || disc.JWKSURI == "") {
+		err = fmt.Errorf("incomplete discovery document")
+	}
+	if err != nil {
+		if p.discovery != nil {
+			return p.discovery, nil
+		}
+		return nil, fmt.Errorf("failed to discover identity provider %q: %w", p.cfg.ID, err)
+	}
+	if p.keys == nil || p.discovery.JWKSURI != disc.JWKSURI {
+		p.keys = NewJWTKeySet(&config.JwtConfig{
+			JWKSURL:             disc.JWKSURI,
+			JWKSRefreshInterval: ssoDiscoveryLifetime,
+		})
+	}
+	p.discovery = &disc
+	p.fetchedAt = now
+	return p.discovery, nil
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+}
+
+func (s *SSO) exchangeCode(ctx context.Context, p *oidcProvider, disc *oidcDiscovery, code, codeVerifier string) (*oidcTokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.cfg.CallbackURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	var tokens oidcTokenResponse
+	if err = s.doJSON(req, &tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("no ID token returned")
+	}
+	return &tokens, nil
+}
+
+func (s *SSO) verifyIDToken(ctx context.Context, p *oidcProvider, disc *oidcDiscovery, idToken, nonce string) (*Claims, error) {
+	p.mu.Lock()
+	keys := p.keys
+	p.mu.Unlock()
+	// The claims are validated by validateClaims, as the parser doesn't allow for clock skew.
+	parser := jwt.NewParser(jwt.WithValidMethods(config.JwtAlgorithms), jwt.WithoutClaimsValidation())
+	c := &Claims{}
+	token, err := parser.ParseWithClaims(idToken, c, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys.Key(ctx, kid, token.Method.Alg())
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	if err = validateClaims(c, &config.JwtConfig{
+		Issuer:    disc.Issuer,
+		Audiences: []string{p.cfg.ClientID},
+		ClockSkew: time.Minute,
+	}, s.now()); err != nil {
+		return nil, err
+	}
+	if n, _ := c.Extra["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+	return c, nil
+}
+
+// mergeUserinfo adds the claims of the user info endpoint which are missing from the ID token.
+func (s *SSO) mergeUserinfo(ctx context.Context, disc *oidcDiscovery, accessToken string, c *Claims) error {
+	var userinfo map[string]interface{}
+	if err := s.getJSON(ctx, disc.UserinfoEndpoint, accessToken, &userinfo); err != nil {
+		return err
+	}
+	if sub, _ := userinfo["sub"].(string); sub != c.Subject {
+		return fmt.Errorf("subject mismatch")
+	}
+	for k, v := range userinfo {
+		if _, ok := c.Extra[k]; !ok {
+			c.Extra[k] = v
+		}
+	}
+	return nil
+}
+
+func (s *SSO) getJSON(ctx context.Context, u, accessToken string, res interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return s.doJSON(req, res)
+}
+
+func (s *SSO) doJSON(req *http.Request, res interface{}) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	body, err := io.ReadAll(io.LimitReader(resp.Body, ssoMaxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, req.URL.Host)
+	}
+	return json.Unmarshal(body, res)
+}
+
+// claimString returns the claim as a string. Numeric claims are formatted.
+func claimString(claims map[string]interface{}, name string) string {
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	}
+	return ""
+}
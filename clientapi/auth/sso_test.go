@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// fakeOIDCProvider is an identity provider which issues a code for every
+// authorization request, and an ID token with the given claims for it.
+type fakeOIDCProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	// The nonce and code challenge of the last authorization request.
+	nonce     string
+	challenge string
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeOIDCProvider{key: key}
+	mux := http.NewServeMux()
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"userinfo_endpoint":      p.URL + "/userinfo",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"keys": []interface{}{rsaJWK("1", &key.PublicKey)}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || id != "dendrite" || secret != "secret" || r.PostFormValue("code") != "code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss": p.URL, "aud": "dendrite", "sub": "alice-id", "nonce": p.nonce,
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "1"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]string{"access_token": "access", "id_token": idToken})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, map[string]string{"sub": "alice-id", "preferred_username": "alice", "name": "Alice"})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize records the parameters of the authorization request.
+func (p *fakeOIDCProvider) authorize(t *testing.T, authURL string) url.Values {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	p.nonce = q.Get("nonce")
+	p.challenge = q.Get("code_challenge")
+	return q
+}
+
+func newTestSSO(t *testing.T, provider *fakeOIDCProvider) *SSO {
+	t.Helper()
+	cfg := &config.SSO{
+		Enabled:         true,
+		CallbackURL:     "https://matrix.test/_matrix/client/v3/login/sso/callback",
+		ClientWhitelist: []string{"https://client.test/"},
+		Providers: []config.IdentityProvider{{
+			ID:           "test",
+			DiscoveryURL: provider.URL + "/.well-known/openid-configuration",
+			ClientID:     "dendrite",
+			ClientSecret: "secret",
+		}},
+	}
+	cfg.Providers[0].Defaults()
+	return NewSSO(cfg)
+}
+
+func TestSSOLogin(t *testing.T) {
+	ctx := context.Background()
+	provider := newFakeOIDCProvider(t)
+	sso := newTestSSO(t, provider)
+
+	if !sso.IsAllowedRedirect("https://client.test/app") || sso.IsAllowedRedirect("https://evil.test/") {
+		t.Fatalf("client whitelist not applied")
+	}
+	if sso.Provider("") == nil || sso.Provider("test") == nil || sso.Provider("unknown") != nil {
+		t.Fatalf("unexpected provider lookup result")
+	}
+
+	authURL, state, err := sso.AuthorizationURL(ctx, "test", "https://client.test/app")
+	if err != nil {
+		t.Fatalf("failed to start login: %s", err)
+	}
+	q := provider.authorize(t, authURL)
+	if q.Get("state") != state || q.Get("client_id") != "dendrite" || q.Get("code_challenge_method") != "S256" ||
+		q.Get("redirect_uri") != "https://matrix.test/_matrix/client/v3/login/sso/callback" {
+		t.Fatalf("unexpected authorization request: %s", authURL)
+	}
+
+	user, err := sso.Callback(ctx, state, "code")
+	if err != nil {
+		t.Fatalf("failed to complete login: %s", err)
+	}
+	want := SSOUser{IDPID: "test", Subject: "alice-id", Localpart: "alice", DisplayName: "Alice", RedirectURL: "https://client.test/app"}
+	if *user != want {
+		t.Fatalf("got user %+v, want %+v", *user, want)
+	}
+
+	// sessions can only be completed once
+	if _, err = sso.Callback(ctx, state, "code"); err == nil {
+		t.Fatalf("session was completed twice")
+	}
+}
+
+func TestSSORedirects(t *testing.T) {
+	sso := NewSSO(&config.SSO{})
+	for _, u := range []string{"https://client.test/app", "io.element.app:/callback"} {
+		if !IsValidRedirect(u) || sso.IsAllowedRedirect(u) {
+			t.Fatalf("%s: not valid, or allowed without a client whitelist", u)
+		}
+	}
+	for _, u := range []string{"", "/relative", "javascript:alert(1)", "data:text/html,hi"} {
+		if IsValidRedirect(u) {
+			t.Fatalf("%s: invalid URL accepted", u)
+		}
+	}
+}
+
+func TestSSOSessionLimit(t *testing.T) {
+	ctx := context.Background()
+	provider := newFakeOIDCProvider(t)
+	sso := newTestSSO(t, provider)
+
+	for i := 0; i < ssoMaxSessions; i++ {
+		if _, _, err := sso.AuthorizationURL(ctx, "test", "https://client.test/app"); err != nil {
+			t.Fatalf("failed to start login: %s", err)
+		}
+	}
+	if _, _, err := sso.AuthorizationURL(ctx, "test", "https://client.test/app"); !errors.Is(err, ErrSSOSessionLimit) {
+		t.Fatalf("expected session limit to be reached, got %v", err)
+	}
+
+	// expired sessions make room for new ones
+	sso.now = func() time.Time { return time.Now().Add(SSOSessionLifetime + time.Minute) }
+	if _, _, err := sso.AuthorizationURL(ctx, "test", "https://client.test/app"); err != nil {
+		t.Fatalf("failed to start login after sessions expired: %s", err)
+	}
+}
+
+func TestSSOLoginFailures(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		code   string
+		state  func(state string) string
+	}{
+		{name: "unknown state", state: func(string) string { return "unknown" }},
+		{name: "invalid code", code: "invalid"},
+		{name: "wrong nonce", claims: jwt.MapClaims{"nonce": "other"}},
+		{name: "wrong audience", claims: jwt.MapClaims{"aud": "other"}},
+		{name: "wrong issuer", claims: jwt.MapClaims{"iss": "https://other.test"}},
+		{name: "expired token", claims: jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{name: "subject mismatch with user info", claims: jwt.MapClaims{"sub": "bob-id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeOIDCProvider(t)
+			provider.claims = tt.claims
+			sso := newTestSSO(t, provider)
+
+			authURL, state, err := sso.AuthorizationURL(ctx, "test", "https://client.test/app")
+			if err != nil {
+				t.Fatalf("failed to start login: %s", err)
+			}
+			provider.authorize(t, authURL)
+			if tt.state != nil {
+				state = tt.state(state)
+			}
+			code := tt.code
+			if code == "" {
+				code = "code"
+			}
+			if _, err = sso.Callback(ctx, state, code); err == nil {
+				t.Fatalf("expected login to fail")
+			}
+		})
+	}
+}
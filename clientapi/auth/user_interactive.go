@@ -20,6 +20,7 @@ import (
 	"net/http"
 	"sync"
 
+	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/gomatrixserverlib/spec"
@@ -72,6 +73,13 @@ type Login struct {
 	// Thus a pointer is needed to differentiate between the two
 	InitialDisplayName *string `json:"initial_device_display_name"`
 	DeviceID           *string `json:"device_id"`
+
+	// Whether the client supports refresh tokens.
+	RefreshToken bool `json:"refresh_token"`
+
+	// ProfileUpdate is set if the login changed the profile of the user, which
+	// must be sent to the rooms the user is in.
+	ProfileUpdate *authtypes.Profile `json:"-"`
 }
 
 // Username returns the user localpart/user_id in this request, if it exists.
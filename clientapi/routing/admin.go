@@ -457,6 +457,31 @@ func AdminMarkAsStale(req *http.Request, cfg *config.ClientAPI, keyAPI api.Clien
 	}
 }
 
+// AdminLDAPSync syncs accounts with the LDAP directory. Unless dry_run=false is given,
+// the changes are only reported.
+func AdminLDAPSync(req *http.Request, userAPI api.ClientUserAPI) util.JSONResponse {
+	dryRun := req.URL.Query().Get("dry_run") != "false"
+	var res api.PerformLDAPSyncResponse
+	err := userAPI.PerformLDAPSync(req.Context(), &api.PerformLDAPSyncRequest{DryRun: dryRun}, &res)
+	switch {
+	case errors.Is(err, api.ErrLDAPSyncDisabled):
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.Unknown(err.Error()),
+		}
+	case err != nil:
+		logrus.WithError(err).Error("Failed to sync LDAP directory")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.Unknown(fmt.Sprintf("Failed to sync LDAP directory: %s", err)),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
 func AdminDownloadState(req *http.Request, device *api.Device, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
 	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
 	if err != nil {
@@ -0,0 +1,328 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+
+	clientutil "github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/internal/httputil"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// maxAdminListLimit is the most entries the admin list endpoints return per page.
+const maxAdminListLimit = 1000
+
+type adminRoom struct {
+	RoomID             string `json:"room_id"`
+	Name               string `json:"name,omitempty"`
+	CanonicalAlias     string `json:"canonical_alias,omitempty"`
+	Version            string `json:"version"`
+	JoinedMembers      int64  `json:"joined_members"`
+	JoinedLocalMembers int64  `json:"joined_local_members"`
+	Events             int64  `json:"events"`
+	Blocked            bool   `json:"blocked"`
+}
+
+func newAdminRoom(room *roomserverAPI.AdminRoom) adminRoom {
+	return adminRoom{
+		RoomID:             room.RoomID,
+		Name:               room.Name,
+		CanonicalAlias:     room.CanonicalAlias,
+		Version:            string(room.RoomVersion),
+		JoinedMembers:      room.JoinedMembers,
+		JoinedLocalMembers: room.JoinedLocalMembers,
+		Events:             room.Events,
+		Blocked:            room.Blocked,
+	}
+}
+
+type adminRoomDetails struct {
+	adminRoom
+	JoinedRemoteMembers int64  `json:"joined_remote_members"`
+	Topic               string `json:"topic,omitempty"`
+	Creator             string `json:"creator,omitempty"`
+	JoinRules           string `json:"join_rules,omitempty"`
+	GuestAccess         string `json:"guest_access,omitempty"`
+	HistoryVisibility   string `json:"history_visibility,omitempty"`
+	Encryption          string `json:"encryption,omitempty"`
+	Federatable         bool   `json:"federatable"`
+	Public              bool   `json:"public"`
+	StateEvents         int    `json:"state_events"`
+}
+
+// AdminListRooms implements GET /admin/rooms
+func AdminListRooms(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	query := req.URL.Query()
+	roomsReq := roomserverAPI.QueryAdminRoomsRequest{
+		OrderBy: roomserverAPI.AdminRoomsOrderByName,
+		Limit:   100,
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if roomsReq.From, err = strconv.ParseUint(from, 10, 64); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("from must be a non-negative integer"),
+			}
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if roomsReq.Limit, err = strconv.ParseUint(limit, 10, 64); err != nil || roomsReq.Limit == 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("limit must be a positive integer"),
+			}
+		}
+		if roomsReq.Limit > maxAdminListLimit {
+			roomsReq.Limit = maxAdminListLimit
+		}
+	}
+	switch orderBy := roomserverAPI.AdminRoomsOrder(query.Get("order_by")); orderBy {
+	case "":
+	case roomserverAPI.AdminRoomsOrderByName, roomserverAPI.AdminRoomsOrderByJoinedMembers, roomserverAPI.AdminRoomsOrderByEvents:
+		roomsReq.OrderBy = orderBy
+	default:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("order_by must be one of name, joined_members or events"),
+		}
+	}
+	switch query.Get("dir") {
+	case "", "f":
+	case "b":
+		roomsReq.Backwards = true
+	default:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("dir must be f or b"),
+		}
+	}
+
+	rooms, total, err := rsAPI.QueryAdminRooms(req.Context(), &roomsReq)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryAdminRooms failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	res := struct {
+		Rooms     []adminRoom `json:"rooms"`
+		Total     int64       `json:"total"`
+		NextToken *uint64     `json:"next_token,omitempty"`
+	}{
+		Rooms: make([]adminRoom, 0, len(rooms)),
+		Total: total,
+	}
+	for i := range rooms {
+		res.Rooms = append(res.Rooms, newAdminRoom(&rooms[i]))
+	}
+	if next := roomsReq.From + uint64(len(rooms)); next < uint64(total) {
+		res.NextToken = &next
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// AdminGetRoom implements GET /admin/rooms/{roomID}
+func AdminGetRoom(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	room, _, resErr := adminLookupRoom(req, rsAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: adminRoomDetails{
+			adminRoom:           newAdminRoom(room),
+			JoinedRemoteMembers: room.JoinedMembers - room.JoinedLocalMembers,
+			Topic:               room.Topic,
+			Creator:             room.Creator,
+			JoinRules:           room.JoinRules,
+			GuestAccess:         room.GuestAccess,
+			HistoryVisibility:   room.HistoryVisibility,
+			Encryption:          room.Encryption,
+			Federatable:         room.Federatable,
+			Public:              room.Public,
+			StateEvents:         room.StateEvents,
+		},
+	}
+}
+
+// AdminGetRoomMembers implements GET /admin/rooms/{roomID}/members, which
+// returns the user IDs of the users joined to the room.
+func AdminGetRoomMembers(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	room, state, resErr := adminLookupRoom(req, rsAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	roomID, err := spec.NewRoomID(room.RoomID)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	members := []string{}
+	for _, ev := range state {
+		if ev.Type() != spec.MRoomMember || ev.StateKey() == nil {
+			continue
+		}
+		if membership, err := ev.Membership(); err != nil || membership != spec.Join {
+			continue
+		}
+		userID, err := rsAPI.QueryUserIDForSender(req.Context(), *roomID, spec.SenderID(*ev.StateKey()))
+		if err != nil || userID == nil {
+			util.GetLogger(req.Context()).WithError(err).WithField("state_key", *ev.StateKey()).Warn("rsAPI.QueryUserIDForSender failed")
+			continue
+		}
+		members = append(members, userID.String())
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"members": members,
+			"total":   len(members),
+		},
+	}
+}
+
+// AdminGetRoomState implements GET /admin/rooms/{roomID}/state
+func AdminGetRoomState(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	_, state, resErr := adminLookupRoom(req, rsAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	events := make([]json.RawMessage, 0, len(state))
+	for _, ev := range state {
+		events = append(events, ev.JSON())
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"state": events,
+		},
+	}
+}
+
+// AdminRoomBlock implements GET and PUT /admin/rooms/{roomID}/block. Rooms can
+// be blocked before the server knows about them.
+func AdminRoomBlock(req *http.Request, device *userapi.Device, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	roomID, err := spec.NewRoomID(vars["roomID"])
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("Invalid room ID."),
+		}
+	}
+
+	if req.Method == http.MethodPut {
+		var r struct {
+			Block *bool `json:"block"`
+		}
+		if resErr := clientutil.UnmarshalJSONRequest(req, &r); resErr != nil {
+			return *resErr
+		}
+		if r.Block == nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.BadJSON("block is required."),
+			}
+		}
+		if err = rsAPI.PerformAdminBlockRoom(req.Context(), roomID.String(), device.UserID, *r.Block); err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("rsAPI.PerformAdminBlockRoom failed")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+	}
+
+	blocked, err := rsAPI.QueryAdminBlockedRoom(req.Context(), roomID.String())
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryAdminBlockedRoom failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: newAdminRoomBlock(blocked),
+	}
+}
+
+type adminRoomBlock struct {
+	Block     bool   `json:"block"`
+	UserID    string `json:"user_id,omitempty"`
+	BlockedTS int64  `json:"blocked_ts,omitempty"`
+}
+
+func newAdminRoomBlock(blocked *types.BlockedRoom) adminRoomBlock {
+	if blocked == nil {
+		return adminRoomBlock{}
+	}
+	return adminRoomBlock{
+		Block:     true,
+		UserID:    blocked.BlockedBy,
+		BlockedTS: int64(blocked.BlockedTS),
+	}
+}
+
+// adminLookupRoom returns the details and current state of the room in the request path.
+func adminLookupRoom(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) (*roomserverAPI.AdminRoom, []*types.HeaderedEvent, *util.JSONResponse) {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		resErr := util.ErrorResponse(err)
+		return nil, nil, &resErr
+	}
+	if _, err = spec.NewRoomID(vars["roomID"]); err != nil {
+		return nil, nil, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("Invalid room ID."),
+		}
+	}
+	room, state, err := rsAPI.QueryAdminRoom(req.Context(), vars["roomID"])
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryAdminRoom failed")
+		return nil, nil, &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if room == nil {
+		return nil, nil, &util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound("Room not found."),
+		}
+	}
+	return room, state, nil
+}
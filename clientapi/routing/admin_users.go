@@ -0,0 +1,307 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	clientutil "github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/internal/httputil"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+type adminUser struct {
+	UserID       string `json:"user_id"`
+	Admin        bool   `json:"admin"`
+	Guest        bool   `json:"guest"`
+	AppServiceID string `json:"appservice_id,omitempty"`
+	CreationTS   int64  `json:"creation_ts"`
+	Deactivated  bool   `json:"deactivated"`
+	Locked       bool   `json:"locked"`
+	ShadowBanned bool   `json:"shadow_banned"`
+}
+
+func newAdminUser(acc *userapi.Account) adminUser {
+	return adminUser{
+		UserID:       acc.UserID,
+		Admin:        acc.AccountType == userapi.AccountTypeAdmin,
+		Guest:        acc.AccountType == userapi.AccountTypeGuest,
+		AppServiceID: acc.AppServiceID,
+		CreationTS:   acc.CreatedTS,
+		Deactivated:  acc.Deactivated,
+		Locked:       acc.Locked,
+		ShadowBanned: acc.ShadowBanned,
+	}
+}
+
+type adminUserDevice struct {
+	DeviceID    string `json:"device_id"`
+	DisplayName string `json:"display_name,omitempty"`
+	LastSeenIP  string `json:"last_seen_ip,omitempty"`
+	LastSeenTS  int64  `json:"last_seen_ts,omitempty"`
+	UserAgent   string `json:"user_agent,omitempty"`
+}
+
+type adminUserDetails struct {
+	adminUser
+	DisplayName string               `json:"display_name,omitempty"`
+	AvatarURL   string               `json:"avatar_url,omitempty"`
+	Devices     []adminUserDevice    `json:"devices"`
+	Rooms       []string             `json:"rooms"`
+	ThreePIDs   []authtypes.ThreePID `json:"threepids"`
+}
+
+// AdminListUsers implements GET /admin/users
+func AdminListUsers(req *http.Request, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	query := req.URL.Query()
+	usersReq := userapi.QueryAdminAccountsRequest{
+		Name:  query.Get("name"),
+		Limit: 100,
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if usersReq.From, err = strconv.ParseUint(from, 10, 64); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("from must be a non-negative integer"),
+			}
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if usersReq.Limit, err = strconv.ParseUint(limit, 10, 64); err != nil || usersReq.Limit == 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("limit must be a positive integer"),
+			}
+		}
+		if usersReq.Limit > maxAdminListLimit {
+			usersReq.Limit = maxAdminListLimit
+		}
+	}
+	for param, filter := range map[string]**bool{
+		"admin":       &usersReq.Admin,
+		"deactivated": &usersReq.Deactivated,
+	} {
+		if value := query.Get(param); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return util.JSONResponse{
+					Code: http.StatusBadRequest,
+					JSON: spec.InvalidParam(param + " must be true or false"),
+				}
+			}
+			*filter = &parsed
+		}
+	}
+
+	accounts, total, err := userAPI.QueryAdminAccounts(req.Context(), &usersReq)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryAdminAccounts failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	res := struct {
+		Users     []adminUser `json:"users"`
+		Total     int64       `json:"total"`
+		NextToken *uint64     `json:"next_token,omitempty"`
+	}{
+		Users: make([]adminUser, 0, len(accounts)),
+		Total: total,
+	}
+	for i := range accounts {
+		res.Users = append(res.Users, newAdminUser(&accounts[i]))
+	}
+	if next := usersReq.From + uint64(len(accounts)); next < uint64(total) {
+		res.NextToken = &next
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// AdminGetUser implements GET /admin/users/{userID}
+func AdminGetUser(
+	req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI, rsAPI roomserverAPI.ClientRoomserverAPI,
+) util.JSONResponse {
+	acc, resErr := adminLookupAccount(req, cfg, userAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	res := adminUserDetails{
+		adminUser: newAdminUser(acc),
+		Devices:   []adminUserDevice{},
+		Rooms:     []string{},
+		ThreePIDs: []authtypes.ThreePID{},
+	}
+
+	profile, err := userAPI.QueryProfile(req.Context(), acc.UserID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryProfile failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	res.DisplayName, res.AvatarURL = profile.DisplayName, profile.AvatarURL
+
+	var devicesRes userapi.QueryDevicesResponse
+	if err = userAPI.QueryDevices(req.Context(), &userapi.QueryDevicesRequest{UserID: acc.UserID}, &devicesRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryDevices failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	for _, dev := range devicesRes.Devices {
+		res.Devices = append(res.Devices, adminUserDevice{
+			DeviceID:    dev.ID,
+			DisplayName: dev.DisplayName,
+			LastSeenIP:  dev.LastSeenIP,
+			LastSeenTS:  dev.LastSeenTS,
+			UserAgent:   dev.UserAgent,
+		})
+	}
+
+	userID, err := spec.NewUserID(acc.UserID, true)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	roomIDs, err := rsAPI.QueryRoomsForUser(req.Context(), *userID, spec.Join)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryRoomsForUser failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	for _, roomID := range roomIDs {
+		res.Rooms = append(res.Rooms, roomID.String())
+	}
+
+	var threePIDsRes userapi.QueryThreePIDsForLocalpartResponse
+	if err = userAPI.QueryThreePIDsForLocalpart(req.Context(), &userapi.QueryThreePIDsForLocalpartRequest{
+		Localpart:  acc.Localpart,
+		ServerName: acc.ServerName,
+	}, &threePIDsRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryThreePIDsForLocalpart failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	res.ThreePIDs = append(res.ThreePIDs, threePIDsRes.ThreePIDs...)
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// AdminUpdateUser implements PUT /admin/users/{userID}, which locks/unlocks and
+// shadow-bans/unbans an account. Flags which aren't in the request are left unchanged.
+func AdminUpdateUser(req *http.Request, cfg *config.ClientAPI, device *userapi.Device, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	acc, resErr := adminLookupAccount(req, cfg, userAPI)
+	if resErr != nil {
+		return *resErr
+	}
+	var r struct {
+		Locked       *bool `json:"locked"`
+		ShadowBanned *bool `json:"shadow_banned"`
+	}
+	if resErr = clientutil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if acc.UserID == device.UserID && r.Locked != nil && *r.Locked {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("You can't lock your own account."),
+		}
+	}
+
+	if err := userAPI.PerformAdminAccountUpdate(req.Context(), &userapi.PerformAdminAccountUpdateRequest{
+		Localpart:    acc.Localpart,
+		ServerName:   acc.ServerName,
+		Locked:       r.Locked,
+		ShadowBanned: r.ShadowBanned,
+	}); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformAdminAccountUpdate failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if r.Locked != nil {
+		acc.Locked = *r.Locked
+	}
+	if r.ShadowBanned != nil {
+		acc.ShadowBanned = *r.ShadowBanned
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: newAdminUser(acc),
+	}
+}
+
+// adminLookupAccount returns the local account of the user in the request path.
+func adminLookupAccount(req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI) (*userapi.Account, *util.JSONResponse) {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		resErr := util.ErrorResponse(err)
+		return nil, &resErr
+	}
+	localpart, serverName, err := cfg.Matrix.SplitLocalID('@', vars["userID"])
+	if err != nil {
+		return nil, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam(err.Error()),
+		}
+	}
+	var res userapi.QueryAccountByLocalpartResponse
+	err = userAPI.QueryAccountByLocalpart(req.Context(), &userapi.QueryAccountByLocalpartRequest{
+		Localpart:  localpart,
+		ServerName: serverName,
+	}, &res)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, &util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound("User does not exist"),
+		}
+	case err != nil:
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryAccountByLocalpart failed")
+		return nil, &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return res.Account, nil
+}
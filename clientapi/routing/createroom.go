@@ -205,8 +205,14 @@ func createRoom(
 	keyID := cfg.Matrix.KeyID
 	privateKey := cfg.Matrix.PrivateKey
 
+	// Invites of shadow-banned users are dropped.
+	invitedUsers := createRequest.Invite
+	if device.AccountShadowBanned {
+		invitedUsers = nil
+	}
+
 	req := roomserverAPI.PerformCreateRoomRequest{
-		InvitedUsers:              createRequest.Invite,
+		InvitedUsers:              invitedUsers,
 		RoomName:                  createRequest.Name,
 		Visibility:                createRequest.Visibility,
 		Topic:                     createRequest.Topic,
@@ -0,0 +1,46 @@
+package routing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+)
+
+type knockRoomserverAPI struct {
+	roomserverAPI.ClientRoomserverAPI
+	req *roomserverAPI.PerformKnockRequest
+}
+
+func (r *knockRoomserverAPI) PerformKnock(ctx context.Context, req *roomserverAPI.PerformKnockRequest) (string, error) {
+	r.req = req
+	return req.RoomIDOrAlias, nil
+}
+
+type knockProfileAPI struct {
+	api.ClientUserAPI
+}
+
+func (p *knockProfileAPI) QueryProfile(ctx context.Context, userID string) (*authtypes.Profile, error) {
+	return &authtypes.Profile{}, nil
+}
+
+func TestKnockServerNames(t *testing.T) {
+	rsAPI := &knockRoomserverAPI{}
+	device := &api.Device{UserID: "@alice:test"}
+	req := httptest.NewRequest(http.MethodPost, "/knock/!room:remote?via=one&via=two&server_name=three", nil)
+	res := KnockRoomByIDOrAlias(req, device, rsAPI, &knockProfileAPI{}, "!room:remote")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the knock to succeed, got %d: %+v", res.Code, res.JSON)
+	}
+	want := []spec.ServerName{"one", "two", "three"}
+	if !reflect.DeepEqual(rsAPI.req.ServerNames, want) {
+		t.Fatalf("got server names %v, want %v", rsAPI.req.ServerNames, want)
+	}
+}
@@ -17,11 +17,13 @@ package routing
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/matrix-org/dendrite/clientapi/auth"
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/clientapi/ratelimit"
 	"github.com/matrix-org/dendrite/clientapi/userutil"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/gomatrixserverlib/spec"
@@ -29,10 +31,12 @@ import (
 )
 
 type loginResponse struct {
-	UserID      string          `json:"user_id"`
-	AccessToken string          `json:"access_token"`
-	HomeServer  spec.ServerName `json:"home_server"`
-	DeviceID    string          `json:"device_id"`
+	UserID       string          `json:"user_id"`
+	AccessToken  string          `json:"access_token"`
+	HomeServer   spec.ServerName `json:"home_server"`
+	DeviceID     string          `json:"device_id"`
+	RefreshToken string          `json:"refresh_token,omitempty"`
+	ExpiresInMS  int64           `json:"expires_in_ms,omitempty"`
 }
 
 type flows struct {
@@ -40,20 +44,32 @@ type flows struct {
 }
 
 type flow struct {
-	Type string `json:"type"`
+	Type              string             `json:"type"`
+	IdentityProviders []identityProvider `json:"identity_providers,omitempty"`
 }
 
 // Login implements GET and POST /login
 func Login(
 	req *http.Request, userAPI userapi.ClientUserAPI,
+	rsAPI roomserverAPI.ClientRoomserverAPI,
 	cfg *config.ClientAPI,
 	rt *ratelimit.RtFailedLogin,
+	jwtKeys *auth.JWTKeySet,
 ) util.JSONResponse {
 	if req.Method == http.MethodGet {
 		loginFlows := []flow{{Type: authtypes.LoginTypePassword}}
 		if len(cfg.Derived.ApplicationServices) > 0 {
 			loginFlows = append(loginFlows, flow{Type: authtypes.LoginTypeApplicationService})
 		}
+		if cfg.JwtConfig.Enabled {
+			loginFlows = append(loginFlows, flow{Type: authtypes.LoginTypeJwt})
+		}
+		if cfg.SSO.Enabled {
+			loginFlows = append(loginFlows,
+				flow{Type: authtypes.LoginTypeSSO, IdentityProviders: ssoIdentityProviders(&cfg.SSO)},
+				flow{Type: authtypes.LoginTypeToken},
+			)
+		}
 		// TODO: support other forms of login, depending on config options
 		return util.JSONResponse{
 			Code: http.StatusOK,
@@ -62,7 +78,7 @@ func Login(
 			},
 		}
 	} else if req.Method == http.MethodPost {
-		login, cleanup, authErr := auth.LoginFromJSONReader(req, userAPI, userAPI, cfg, rt)
+		login, cleanup, authErr := auth.LoginFromJSONReader(req, userAPI, userAPI, cfg, rt, jwtKeys)
 		if authErr != nil {
 			return *authErr
 		}
@@ -80,6 +96,14 @@ func Login(
 		// make a device/access token
 		authErr2 := completeAuth(req.Context(), cfg.Matrix, userAPI, login, req.RemoteAddr, req.UserAgent())
 		cleanup(req.Context(), &authErr2)
+		if res, ok := authErr2.JSON.(loginResponse); ok && login.ProfileUpdate != nil {
+			// The login changed the profile, e.g. from JWT claims, so update the
+			// membership events of the rooms the user is in.
+			device := &userapi.Device{UserID: res.UserID}
+			if _, err := updateProfile(req.Context(), rsAPI, device, login.ProfileUpdate, res.UserID, time.Now()); err != nil {
+				util.GetLogger(req.Context()).WithError(err).Error("Failed to update profile in rooms after login")
+			}
+		}
 		return authErr2
 	}
 	return util.JSONResponse{
@@ -110,6 +134,11 @@ func completeAuth(
 		}
 	}
 
+	refreshToken, errRes := generateRefreshToken(ctx, login.RefreshToken)
+	if errRes != nil {
+		return *errRes
+	}
+
 	var performRes userapi.PerformDeviceCreationResponse
 	err = userAPI.PerformDeviceCreation(ctx, &userapi.PerformDeviceCreationRequest{
 		DeviceDisplayName: login.InitialDisplayName,
@@ -119,6 +148,7 @@ func completeAuth(
 		ServerName:        serverName,
 		IPAddr:            ipAddr,
 		UserAgent:         userAgent,
+		RefreshToken:      refreshToken,
 	}, &performRes)
 	if err != nil {
 		return util.JSONResponse{
@@ -130,10 +160,12 @@ func completeAuth(
 	return util.JSONResponse{
 		Code: http.StatusOK,
 		JSON: loginResponse{
-			UserID:      performRes.Device.UserID,
-			AccessToken: performRes.Device.AccessToken,
-			HomeServer:  serverName,
-			DeviceID:    performRes.Device.ID,
+			UserID:       performRes.Device.UserID,
+			AccessToken:  performRes.Device.AccessToken,
+			HomeServer:   serverName,
+			DeviceID:     performRes.Device.ID,
+			RefreshToken: refreshToken,
+			ExpiresInMS:  expiresInMS(performRes.Device),
 		},
 	}
 }
@@ -194,5 +194,91 @@ func TestLogin(t *testing.T) {
 				}
 			})
 		}
+
+		t.Run("refresh tokens are issued and rotated", func(t *testing.T) {
+			req := test.NewRequest(t, http.MethodPost, "/_matrix/client/v3/login", test.WithJSONBody(t, map[string]interface{}{
+				"type": authtypes.LoginTypePassword,
+				"identifier": map[string]interface{}{
+					"type": "m.id.user",
+					"user": bobUser.ID,
+				},
+				"password":      password,
+				"refresh_token": true,
+			}))
+			rec := httptest.NewRecorder()
+			routers.Client.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("failed to login: %s", rec.Body.String())
+			}
+			loginResp := loginResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &loginResp); err != nil {
+				t.Fatal(err)
+			}
+			if loginResp.RefreshToken == "" || loginResp.ExpiresInMS <= 0 {
+				t.Fatalf("expected refresh token and expiry: %s", rec.Body.String())
+			}
+
+			refresh := func(refreshToken string) (*httptest.ResponseRecorder, refreshResponse) {
+				req := test.NewRequest(t, http.MethodPost, "/_matrix/client/v3/refresh", test.WithJSONBody(t, map[string]interface{}{
+					"refresh_token": refreshToken,
+				}))
+				rec := httptest.NewRecorder()
+				routers.Client.ServeHTTP(rec, req)
+				resp := refreshResponse{}
+				if rec.Code == http.StatusOK {
+					if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return rec, resp
+			}
+			whoami := func(accessToken string) *httptest.ResponseRecorder {
+				req := test.NewRequest(t, http.MethodGet, "/_matrix/client/v3/account/whoami")
+				req.Header.Set("Authorization", "Bearer "+accessToken)
+				rec := httptest.NewRecorder()
+				routers.Client.ServeHTTP(rec, req)
+				return rec
+			}
+
+			rec, refreshResp := refresh(loginResp.RefreshToken)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("failed to refresh: %s", rec.Body.String())
+			}
+			if refreshResp.AccessToken == loginResp.AccessToken || refreshResp.RefreshToken == loginResp.RefreshToken {
+				t.Fatalf("expected tokens to be rotated: %s", rec.Body.String())
+			}
+			if rec, _ = refresh(loginResp.RefreshToken); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected old refresh token to be rejected, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if rec = whoami(loginResp.AccessToken); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected old access token to be rejected, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if rec = whoami(refreshResp.AccessToken); rec.Code != http.StatusOK {
+				t.Fatalf("expected new access token to be accepted, got %d: %s", rec.Code, rec.Body.String())
+			}
+
+			// expired access tokens are rejected with a soft logout
+			cfg.UserAPI.RefreshableAccessTokenLifetimeMS = -1000
+			defer func() {
+				cfg.UserAPI.RefreshableAccessTokenLifetimeMS = config.DefaultRefreshableAccessTokenLifetimeMS
+			}()
+			if rec, refreshResp = refresh(refreshResp.RefreshToken); rec.Code != http.StatusOK {
+				t.Fatalf("failed to refresh: %s", rec.Body.String())
+			}
+			rec = whoami(refreshResp.AccessToken)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected expired access token to be rejected, got %d: %s", rec.Code, rec.Body.String())
+			}
+			errResp := struct {
+				ErrCode    string `json:"errcode"`
+				SoftLogout bool   `json:"soft_logout"`
+			}{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil {
+				t.Fatal(err)
+			}
+			if errResp.ErrCode != "M_UNKNOWN_TOKEN" || !errResp.SoftLogout {
+				t.Fatalf("expected soft logout error: %s", rec.Body.String())
+			}
+		})
 	})
 }
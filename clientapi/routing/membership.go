@@ -323,6 +323,14 @@ func SendInvite(
 		return *errRes
 	}
 
+	// Invites of shadow-banned users are dropped.
+	if device.AccountShadowBanned {
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: struct{}{},
+		}
+	}
+
 	// We already received the return value, so no need to check for an error here.
 	response, _ := sendInvite(req.Context(), profileAPI, device, roomID, body.UserID, body.Reason, cfg, rsAPI, asAPI, evTime)
 	return response
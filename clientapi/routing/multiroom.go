@@ -1,39 +1,107 @@
 package routing
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 
 	"github.com/matrix-org/dendrite/clientapi/producers"
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/httputil"
+	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/userapi/api"
-	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/gomatrixserverlib/spec"
 	"github.com/matrix-org/util"
 	log "github.com/sirupsen/logrus"
 )
 
-func PostMultiroom(
-	req *http.Request,
-	device *api.Device,
-	producer *producers.SyncAPIProducer,
-	dataType string,
-) util.JSONResponse {
-	b, err := io.ReadAll(req.Body)
+// MultiRoomLimits enforces the multiroom data type settings of the sync API.
+type MultiRoomLimits struct {
+	validator  *internal.MultiRoomValidator
+	rateLimits map[string]*httputil.RateLimits
+}
+
+func NewMultiRoomLimits(cfg *config.MultiRoom) (*MultiRoomLimits, error) {
+	validator, err := internal.NewMultiRoomValidator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	l := &MultiRoomLimits{
+		validator:  validator,
+		rateLimits: make(map[string]*httputil.RateLimits),
+	}
+	for i := range cfg.DataTypes {
+		dataType := &cfg.DataTypes[i]
+		if dataType.RateLimiting.Enabled {
+			l.rateLimits[dataType.Type] = httputil.NewRateLimits(&dataType.RateLimiting)
+		}
+	}
+	return l, nil
+}
+
+// validate reads the payload of the request and checks it against the settings of
+// the data type. Returns the canonical payload if it is accepted.
+func (l *MultiRoomLimits) validate(req *http.Request, device *api.Device, dataType string) ([]byte, *util.JSONResponse) {
+	if err := l.validator.CheckType(dataType); err != nil {
+		return nil, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam(fmt.Sprintf("Unknown multiroom data type %q.", dataType)),
+		}
+	}
+
+	if rateLimits, ok := l.rateLimits[dataType]; ok {
+		// Rate limits are per user rather than per device.
+		if r := rateLimits.Limit(req, &api.Device{UserID: device.UserID, AccountType: device.AccountType}); r != nil {
+			return nil, r
+		}
+	}
+
+	// Don't read more of the body than is needed to tell that it is too large.
+	body := io.Reader(req.Body)
+	if maxSize := l.validator.MaxSize(dataType); maxSize > 0 {
+		body = io.LimitReader(req.Body, maxSize+1)
+	}
+	b, err := io.ReadAll(body)
 	if err != nil {
 		log.WithError(err).Errorf("failed to read request body")
-		return util.JSONResponse{
+		return nil, &util.JSONResponse{
 			Code: http.StatusInternalServerError,
 			JSON: spec.InternalServerError{},
 		}
 	}
-	canonicalB, err := gomatrixserverlib.CanonicalJSON(b)
-	if err != nil {
-		return util.JSONResponse{
+
+	canonicalB, err := l.validator.Validate(dataType, b)
+	switch {
+	case errors.Is(err, internal.ErrMultiRoomTooLarge):
+		return nil, &util.JSONResponse{
+			Code: http.StatusRequestEntityTooLarge,
+			JSON: spec.MatrixError{
+				ErrCode: "M_TOO_LARGE",
+				Err:     fmt.Sprintf("The payload is larger than the maximum allowed size of %d bytes.", l.validator.MaxSize(dataType)),
+			},
+		}
+	case err != nil:
+		return nil, &util.JSONResponse{
 			Code: http.StatusBadRequest,
-			JSON: spec.BadJSON("The request body is not valid canonical JSON." + err.Error()),
+			JSON: spec.BadJSON(err.Error()),
 		}
 	}
-	err = producer.SendMultiroom(req.Context(), device.UserID, dataType, canonicalB)
+	return canonicalB, nil
+}
+
+func PostMultiroom(
+	req *http.Request,
+	device *api.Device,
+	producer *producers.SyncAPIProducer,
+	limits *MultiRoomLimits,
+	dataType string,
+) util.JSONResponse {
+	canonicalB, resErr := limits.validate(req, device, dataType)
+	if resErr != nil {
+		return *resErr
+	}
+	err := producer.SendMultiroom(req.Context(), device.UserID, dataType, canonicalB)
 	if err != nil {
 		log.WithError(err).Errorf("failed to send multiroomcast")
 		return util.JSONResponse{
@@ -0,0 +1,93 @@
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	uapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"gotest.tools/v3/assert"
+)
+
+func TestMultiRoomLimits(t *testing.T) {
+	cfg := &config.MultiRoom{
+		Strict:  true,
+		MaxSize: 64,
+		DataTypes: []config.MultiRoomDataType{
+			{
+				Type:   "connect.multiroom.location",
+				Schema: `{"type":"object","required":["geo_uri"],"properties":{"geo_uri":{"type":"string"}}}`,
+			},
+			{
+				Type:    "connect.multiroom.large",
+				MaxSize: 128,
+			},
+			{
+				Type: "connect.multiroom.limited",
+				RateLimiting: config.RateLimiting{
+					Enabled:   true,
+					Threshold: 1,
+					CooloffMS: 60000,
+				},
+			},
+		},
+	}
+	limits, err := NewMultiRoomLimits(cfg)
+	assert.NilError(t, err)
+
+	device := &uapi.Device{UserID: "@alice:test", ID: "ALICE", AccountType: uapi.AccountTypeUser}
+	validate := func(dataType, body string) ([]byte, int, string) {
+		req := httptest.NewRequest(http.MethodPost, "/multiroom/"+dataType, strings.NewReader(body))
+		b, res := limits.validate(req, device, dataType)
+		if res == nil {
+			return b, http.StatusOK, ""
+		}
+		switch e := res.JSON.(type) {
+		case spec.MatrixError:
+			return nil, res.Code, string(e.ErrCode)
+		case spec.LimitExceededError:
+			return nil, res.Code, string(e.ErrCode)
+		}
+		return nil, res.Code, ""
+	}
+
+	b, code, _ := validate("connect.multiroom.location", `{ "geo_uri": "geo:1,2" }`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"geo_uri":"geo:1,2"}`, string(b))
+
+	_, code, errCode := validate("connect.multiroom.location", `{"geo_uri":1}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "M_BAD_JSON", errCode)
+
+	_, code, errCode = validate("connect.multiroom.location", `not json`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "M_BAD_JSON", errCode)
+
+	_, code, errCode = validate("connect.multiroom.location", `{"geo_uri":"`+strings.Repeat("1", 64)+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, "M_TOO_LARGE", errCode)
+
+	// The data type can raise the global size limit.
+	_, code, _ = validate("connect.multiroom.large", `{"data":"`+strings.Repeat("1", 64)+`"}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	_, code, errCode = validate("connect.multiroom.unknown", `{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "M_INVALID_PARAM", errCode)
+
+	_, code, _ = validate("connect.multiroom.limited", `{}`)
+	assert.Equal(t, http.StatusOK, code)
+	// The rate limit applies to the user, not only the device.
+	device = &uapi.Device{UserID: "@alice:test", ID: "OTHER", AccountType: uapi.AccountTypeUser}
+	_, code, errCode = validate("connect.multiroom.limited", `{}`)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "M_LIMIT_EXCEEDED", errCode)
+
+	// Unknown data types are accepted when not in strict mode.
+	cfg.Strict = false
+	_, code, _ = validate("connect.multiroom.unknown", `{}`)
+	assert.Equal(t, http.StatusOK, code)
+}
@@ -17,9 +17,12 @@ package routing
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/matrix-org/dendrite/clientapi/httputil"
 	"github.com/matrix-org/dendrite/clientapi/producers"
 	"github.com/matrix-org/gomatrixserverlib/spec"
 
@@ -28,23 +31,55 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+type receiptRequest struct {
+	ThreadID string `json:"thread_id"`
+}
+
 func SetReceipt(req *http.Request, userAPI userapi.ClientUserAPI, syncProducer *producers.SyncAPIProducer, device *userapi.Device, roomID, receiptType, eventID string) util.JSONResponse {
+	// The request body is optional, older clients send nothing at all.
+	var r receiptRequest
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if len(body) > 0 {
+		if resErr := httputil.UnmarshalJSON(body, &r); resErr != nil {
+			return *resErr
+		}
+	}
+	if r.ThreadID != "" && r.ThreadID != "main" && !strings.HasPrefix(r.ThreadID, "$") {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("thread_id must be 'main' or an event ID"),
+		}
+	}
+
 	timestamp := spec.AsTimestamp(time.Now())
 	logrus.WithFields(logrus.Fields{
 		"roomID":      roomID,
 		"receiptType": receiptType,
 		"eventID":     eventID,
+		"threadID":    r.ThreadID,
 		"userId":      device.UserID,
 		"timestamp":   timestamp,
 	}).Debug("Setting receipt")
 
 	switch receiptType {
 	case "m.read", "m.read.private":
-		if err := syncProducer.SendReceipt(req.Context(), device.UserID, roomID, eventID, receiptType, timestamp); err != nil {
+		if err := syncProducer.SendReceipt(req.Context(), device.UserID, roomID, eventID, receiptType, r.ThreadID, timestamp); err != nil {
 			return util.ErrorResponse(err)
 		}
 
 	case "m.fully_read":
+		if r.ThreadID != "" {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("m.fully_read markers can't be threaded"),
+			}
+		}
 		data, err := json.Marshal(fullyReadEvent{EventID: eventID})
 		if err != nil {
 			return util.JSONResponse{
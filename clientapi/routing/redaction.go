@@ -92,6 +92,18 @@ func SendRedaction(
 		}
 	}
 
+	// Redactions of shadow-banned users are dropped.
+	if device.AccountShadowBanned {
+		res := util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: redactionResponse{EventID: fakeEventID()},
+		}
+		if txnID != nil {
+			txnCache.AddTransaction(device.AccessToken, *txnID, req.URL, &res)
+		}
+		return res
+	}
+
 	ev := roomserverAPI.GetEvent(req.Context(), rsAPI, roomID, eventID)
 	if ev == nil {
 		return util.JSONResponse{
@@ -0,0 +1,124 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/dendrite/clientapi/auth"
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type refreshResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresInMS  int64  `json:"expires_in_ms,omitempty"`
+}
+
+// Refresh implements POST /refresh. The access and refresh token of the device
+// are replaced. The previous refresh token stays valid until the new access or
+// refresh token is used, so that clients which didn't receive the response can retry.
+// https://spec.matrix.org/v1.7/client-server-api/#post_matrixclientv3refresh
+func Refresh(req *http.Request, userAPI api.ClientUserAPI) util.JSONResponse {
+	var r refreshRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if r.RefreshToken == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.MissingParam("Missing refresh_token"),
+		}
+	}
+
+	accessToken, err := auth.GenerateAccessToken()
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("auth.GenerateAccessToken failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	refreshToken, errRes := generateRefreshToken(req.Context(), true)
+	if errRes != nil {
+		return *errRes
+	}
+
+	var res api.PerformTokenRefreshResponse
+	err = userAPI.PerformTokenRefresh(req.Context(), &api.PerformTokenRefreshRequest{
+		RefreshToken:    r.RefreshToken,
+		NewAccessToken:  accessToken,
+		NewRefreshToken: refreshToken,
+	}, &res)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformTokenRefresh failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if res.Device == nil {
+		return util.JSONResponse{
+			Code: http.StatusUnauthorized,
+			JSON: spec.UnknownToken("Unknown refresh token"),
+		}
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: refreshResponse{
+			AccessToken:  res.Device.AccessToken,
+			RefreshToken: refreshToken,
+			ExpiresInMS:  expiresInMS(res.Device),
+		},
+	}
+}
+
+// generateRefreshToken returns a new refresh token if the client requested one.
+func generateRefreshToken(ctx context.Context, requested bool) (string, *util.JSONResponse) {
+	if !requested {
+		return "", nil
+	}
+	token, err := auth.GenerateAccessToken()
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("auth.GenerateAccessToken failed")
+		return "", &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return token, nil
+}
+
+// expiresInMS returns the remaining lifetime of the access token of the device,
+// or 0 if it never expires.
+func expiresInMS(dev *api.Device) int64 {
+	if dev.AccessTokenExpiresTS == 0 {
+		return 0
+	}
+	if expiresIn := time.Until(time.UnixMilli(dev.AccessTokenExpiresTS)).Milliseconds(); expiresIn > 0 {
+		return expiresIn
+	}
+	return 1
+}
@@ -222,6 +222,9 @@ type registerRequest struct {
 	// Prevent this user from logging in
 	InhibitLogin eventutil.WeakBoolean `json:"inhibit_login"`
 
+	// Whether the client supports refresh tokens
+	RefreshToken bool `json:"refresh_token"`
+
 	// Application Services place Type in the root of their registration
 	// request, whereas clients place it in the authDict struct.
 	Type authtypes.LoginType `json:"type"`
@@ -263,9 +266,11 @@ func newUserInteractiveResponse(
 
 // https://spec.matrix.org/v1.7/client-server-api/#post_matrixclientv3register
 type registerResponse struct {
-	UserID      string `json:"user_id"`
-	AccessToken string `json:"access_token,omitempty"`
-	DeviceID    string `json:"device_id,omitempty"`
+	UserID       string `json:"user_id"`
+	AccessToken  string `json:"access_token,omitempty"`
+	DeviceID     string `json:"device_id,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresInMS  int64  `json:"expires_in_ms,omitempty"`
 }
 
 // recaptchaResponse represents the HTTP response from a Google Recaptcha server
@@ -626,6 +631,10 @@ func handleGuestRegistration(
 			JSON: spec.Unknown("Failed to generate access token"),
 		}
 	}
+	refreshToken, errRes := generateRefreshToken(req.Context(), r.RefreshToken)
+	if errRes != nil {
+		return *errRes
+	}
 	//we don't allow guests to specify their own device_id
 	var devRes userapi.PerformDeviceCreationResponse
 	err = userAPI.PerformDeviceCreation(req.Context(), &userapi.PerformDeviceCreationRequest{
@@ -636,6 +645,7 @@ func handleGuestRegistration(
 		IPAddr:            req.RemoteAddr,
 		UserAgent:         req.UserAgent(),
 		FromRegistration:  true,
+		RefreshToken:      refreshToken,
 	}, &devRes)
 	if err != nil {
 		return util.JSONResponse{
@@ -646,9 +656,11 @@ func handleGuestRegistration(
 	return util.JSONResponse{
 		Code: http.StatusOK,
 		JSON: registerResponse{
-			UserID:      devRes.Device.UserID,
-			AccessToken: devRes.Device.AccessToken,
-			DeviceID:    devRes.Device.ID,
+			UserID:       devRes.Device.UserID,
+			AccessToken:  devRes.Device.AccessToken,
+			DeviceID:     devRes.Device.ID,
+			RefreshToken: refreshToken,
+			ExpiresInMS:  expiresInMS(devRes.Device),
 		},
 	}
 }
@@ -829,7 +841,7 @@ func handleApplicationServiceRegistration(
 		// application service registration is entirely separate.
 		return completeRegistration(
 			req.Context(), userAPI, r.Username, r.ServerName, "", r.Password, appserviceID, req.RemoteAddr, req.UserAgent(), r.Auth.Session,
-			r.InhibitLogin, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeAppService, &authtypes.ThreePID{
+			r.InhibitLogin, r.RefreshToken, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeAppService, &authtypes.ThreePID{
 				Address:     r.Email,
 				Medium:      "email",
 				AddedAt:     time.Now().Unix(),
@@ -842,7 +854,7 @@ func handleApplicationServiceRegistration(
 	// application service registration is entirely separate.
 	return completeRegistration(
 		req.Context(), userAPI, r.Username, r.ServerName, "", "", appserviceID, req.RemoteAddr, req.UserAgent(), r.Auth.Session,
-		r.InhibitLogin, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeAppService, nil,
+		r.InhibitLogin, r.RefreshToken, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeAppService, nil,
 	)
 }
 
@@ -862,7 +874,7 @@ func checkAndCompleteFlow(
 		// This flow was completed, registration can continue
 		return completeRegistration(
 			req.Context(), userAPI, r.Username, r.ServerName, "", r.Password, "", req.RemoteAddr, req.UserAgent(), sessionID,
-			r.InhibitLogin, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeUser, threePid,
+			r.InhibitLogin, r.RefreshToken, r.InitialDisplayName, r.DeviceID, userapi.AccountTypeUser, threePid,
 		)
 	}
 	sessions.addParams(sessionID, r)
@@ -886,7 +898,7 @@ func completeRegistration(
 	userAPI userapi.ClientUserAPI,
 	username string, serverName spec.ServerName, displayName string,
 	password, appserviceID, ipAddr, userAgent, sessionID string,
-	inhibitLogin eventutil.WeakBoolean,
+	inhibitLogin eventutil.WeakBoolean, refreshToken bool,
 	deviceDisplayName, deviceID *string,
 	accType userapi.AccountType,
 	threePid *authtypes.ThreePID,
@@ -964,6 +976,11 @@ func completeRegistration(
 		}
 	}
 
+	refreshTokenString, errRes := generateRefreshToken(ctx, refreshToken)
+	if errRes != nil {
+		return *errRes
+	}
+
 	if displayName != "" {
 		_, _, err = userAPI.SetDisplayName(ctx, username, serverName, displayName)
 		if err != nil {
@@ -984,6 +1001,7 @@ func completeRegistration(
 		IPAddr:            ipAddr,
 		UserAgent:         userAgent,
 		FromRegistration:  true,
+		RefreshToken:      refreshTokenString,
 	}, &devRes)
 	if err != nil {
 		return util.JSONResponse{
@@ -993,9 +1011,11 @@ func completeRegistration(
 	}
 
 	result := registerResponse{
-		UserID:      devRes.Device.UserID,
-		AccessToken: devRes.Device.AccessToken,
-		DeviceID:    devRes.Device.ID,
+		UserID:       devRes.Device.UserID,
+		AccessToken:  devRes.Device.AccessToken,
+		DeviceID:     devRes.Device.ID,
+		RefreshToken: refreshTokenString,
+		ExpiresInMS:  expiresInMS(devRes.Device),
 	}
 	sessions.addCompletedRegistration(sessionID, result)
 
@@ -1165,5 +1185,5 @@ func handleSharedSecretRegistration(cfg *config.ClientAPI, userAPI userapi.Clien
 	if ssrr.Admin {
 		accType = userapi.AccountTypeAdmin
 	}
-	return completeRegistration(req.Context(), userAPI, ssrr.User, cfg.Matrix.ServerName, ssrr.DisplayName, ssrr.Password, "", req.RemoteAddr, req.UserAgent(), "", false, &ssrr.User, &deviceID, accType, nil)
+	return completeRegistration(req.Context(), userAPI, ssrr.User, cfg.Matrix.ServerName, ssrr.DisplayName, ssrr.Password, "", req.RemoteAddr, req.UserAgent(), "", false, false, &ssrr.User, &deviceID, accType, nil)
 }
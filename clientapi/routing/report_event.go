@@ -0,0 +1,375 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+
+	appserviceAPI "github.com/matrix-org/dendrite/appservice/api"
+	clientutil "github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/internal/httputil"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+type reportEventRequest struct {
+	Reason string `json:"reason"`
+	Score  *int64 `json:"score"`
+}
+
+// reportNoticeQueueSize is how many reports can wait to be sent as server notices.
+const reportNoticeQueueSize = 100
+
+// reportNotifier sends the server notices about new reports in the background, so
+// that reporting an event doesn't wait for a notice to be sent to every user.
+type reportNotifier struct {
+	cfg     *config.ClientAPI
+	userAPI userapi.ClientUserAPI
+	rsAPI   roomserverAPI.ClientRoomserverAPI
+	asAPI   appserviceAPI.AppServiceInternalAPI
+	sender  *userapi.Device
+	notices chan map[string]interface{}
+}
+
+// newReportNotifier returns nil if there is nobody to notify about reports.
+func newReportNotifier(
+	cfg *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI roomserverAPI.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	sender *userapi.Device,
+) *reportNotifier {
+	if sender == nil || len(cfg.ContentReports.NotifyUsers) == 0 {
+		return nil
+	}
+	n := &reportNotifier{
+		cfg:     cfg,
+		userAPI: userAPI,
+		rsAPI:   rsAPI,
+		asAPI:   asAPI,
+		sender:  sender,
+		notices: make(chan map[string]interface{}, reportNoticeQueueSize),
+	}
+	go n.run()
+	return n
+}
+
+// notify queues the notice about a report. The notice is dropped if too many are
+// waiting already, as admins can still find the report in the moderation queue.
+func (n *reportNotifier) notify(reportID int64, content map[string]interface{}) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.notices <- content:
+	default:
+		logrus.Warnf("Too many pending report notices, not notifying about report %d", reportID)
+	}
+}
+
+func (n *reportNotifier) run() {
+	for content := range n.notices {
+		for _, notifyUser := range n.cfg.ContentReports.NotifyUsers {
+			notifyUserID, err := spec.NewUserID(notifyUser, true)
+			if err != nil {
+				continue
+			}
+			res := sendServerNotice(
+				context.Background(), *notifyUserID, content,
+				&n.cfg.Matrix.ServerNotices, n.cfg, n.userAPI, n.rsAPI, n.asAPI,
+				n.sender, nil, n.sender.UserDomain(),
+			)
+			if res.Code != http.StatusOK {
+				logrus.WithField("user_id", notifyUser).Warnf("Failed to notify user about report: %+v", res.JSON)
+			}
+		}
+	}
+}
+
+// ReportEvent implements POST /rooms/{roomID}/report/{eventID}
+func ReportEvent(
+	req *http.Request,
+	device *userapi.Device,
+	roomID, eventID string,
+	rsAPI roomserverAPI.ClientRoomserverAPI,
+	notifier *reportNotifier,
+) util.JSONResponse {
+	var r reportEventRequest
+	if resErr := clientutil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if r.Score != nil && (*r.Score < -100 || *r.Score > 0) {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("The score must be between -100 and 0."),
+		}
+	}
+
+	userID, err := spec.NewUserID(device.UserID, true)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("Device UserID is invalid"),
+		}
+	}
+
+	// Users may only report events in rooms they are in, and the event has
+	// to be in the room, so that reports can't be used to probe rooms.
+	notFound := util.JSONResponse{
+		Code: http.StatusNotFound,
+		JSON: spec.NotFound("Unable to find event in this room."),
+	}
+	membershipRes := roomserverAPI.QueryMembershipForUserResponse{}
+	if err = rsAPI.QueryMembershipForUser(req.Context(), &roomserverAPI.QueryMembershipForUserRequest{
+		RoomID: roomID,
+		UserID: *userID,
+	}, &membershipRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryMembershipForUser failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if !membershipRes.IsInRoom {
+		return notFound
+	}
+	eventsRes := roomserverAPI.QueryEventsByIDResponse{}
+	if err = rsAPI.QueryEventsByID(req.Context(), &roomserverAPI.QueryEventsByIDRequest{
+		RoomID:   roomID,
+		EventIDs: []string{eventID},
+	}, &eventsRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryEventsByID failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if len(eventsRes.Events) != 1 || eventsRes.Events[0].RoomID().String() != roomID {
+		return notFound
+	}
+
+	reportID, err := rsAPI.InsertReportedEvent(req.Context(), roomID, eventID, device.UserID, r.Reason, r.Score)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.InsertReportedEvent failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	notifier.notify(reportID, map[string]interface{}{
+		"msgtype": "m.text",
+		"body": fmt.Sprintf(
+			"%s reported event %s in room %s (report %d): %s",
+			device.UserID, eventID, roomID, reportID, r.Reason,
+		),
+	})
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+type eventReport struct {
+	ID         int64          `json:"id"`
+	RoomID     string         `json:"room_id"`
+	EventID    string         `json:"event_id"`
+	UserID     string         `json:"user_id"`
+	Reason     string         `json:"reason"`
+	Score      *int64         `json:"score"`
+	ReceivedTS spec.Timestamp `json:"received_ts"`
+	ResolvedBy string         `json:"resolved_by,omitempty"`
+	ResolvedTS spec.Timestamp `json:"resolved_ts,omitempty"`
+	// EventJSON is only set when a single report is requested.
+	EventJSON json.RawMessage `json:"event_json,omitempty"`
+}
+
+func newEventReport(report *types.ReportedEvent) eventReport {
+	return eventReport{
+		ID:         report.ID,
+		RoomID:     report.RoomID,
+		EventID:    report.EventID,
+		UserID:     report.ReportingUserID,
+		Reason:     report.Reason,
+		Score:      report.Score,
+		ReceivedTS: report.ReceivedTS,
+		ResolvedBy: report.ResolvedBy,
+		ResolvedTS: report.ResolvedTS,
+	}
+}
+
+// AdminListEventReports implements GET /admin/eventReports
+func AdminListEventReports(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	query := req.URL.Query()
+	reportsReq := roomserverAPI.QueryAdminEventReportsRequest{
+		RoomID:    query.Get("room_id"),
+		UserID:    query.Get("user_id"),
+		Limit:     100,
+		Backwards: query.Get("dir") != "f",
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if reportsReq.From, err = strconv.ParseUint(from, 10, 64); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("from must be a non-negative integer"),
+			}
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if reportsReq.Limit, err = strconv.ParseUint(limit, 10, 64); err != nil || reportsReq.Limit == 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("limit must be a positive integer"),
+			}
+		}
+		if reportsReq.Limit > maxAdminListLimit {
+			reportsReq.Limit = maxAdminListLimit
+		}
+	}
+	if resolved := query.Get("resolved"); resolved != "" {
+		value, err := strconv.ParseBool(resolved)
+		if err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("resolved must be true or false"),
+			}
+		}
+		reportsReq.Resolved = &value
+	}
+
+	reports, total, err := rsAPI.QueryAdminEventReports(req.Context(), &reportsReq)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryAdminEventReports failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	res := struct {
+		EventReports []eventReport `json:"event_reports"`
+		Total        int64         `json:"total"`
+		NextToken    *uint64       `json:"next_token,omitempty"`
+	}{
+		EventReports: make([]eventReport, 0, len(reports)),
+		Total:        total,
+	}
+	for i := range reports {
+		res.EventReports = append(res.EventReports, newEventReport(&reports[i]))
+	}
+	if next := reportsReq.From + uint64(len(reports)); next < uint64(total) {
+		res.NextToken = &next
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// AdminGetEventReport implements GET /admin/eventReports/{reportID}
+func AdminGetEventReport(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	reportID, resErr := parseReportID(req)
+	if resErr != nil {
+		return *resErr
+	}
+	report, event, err := rsAPI.QueryAdminEventReport(req.Context(), reportID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryAdminEventReport failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if report == nil {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound("Event report not found."),
+		}
+	}
+	res := newEventReport(report)
+	if event != nil {
+		res.EventJSON = event.JSON()
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// AdminResolveEventReport implements POST /admin/eventReports/{reportID}/resolve
+func AdminResolveEventReport(req *http.Request, device *userapi.Device, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	reportID, resErr := parseReportID(req)
+	if resErr != nil {
+		return *resErr
+	}
+	resolved, err := rsAPI.PerformAdminResolveEventReport(req.Context(), reportID, device.UserID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.PerformAdminResolveEventReport failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if !resolved {
+		// Either the report doesn't exist or it was resolved already.
+		report, _, err := rsAPI.QueryAdminEventReport(req.Context(), reportID)
+		if err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryAdminEventReport failed")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		if report == nil {
+			return util.JSONResponse{
+				Code: http.StatusNotFound,
+				JSON: spec.NotFound("Event report not found."),
+			}
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+func parseReportID(req *http.Request) (int64, *util.JSONResponse) {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		resErr := util.ErrorResponse(err)
+		return 0, &resErr
+	}
+	reportID, err := strconv.ParseInt(vars["reportID"], 10, 64)
+	if err != nil {
+		return 0, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("The report ID must be an integer."),
+		}
+	}
+	return reportID, nil
+}
@@ -0,0 +1,29 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+func TestReportNotifier(t *testing.T) {
+	cfg := &config.ClientAPI{}
+	if n := newReportNotifier(cfg, nil, nil, nil, &userapi.Device{}); n != nil {
+		t.Fatalf("expected no notifier without users to notify")
+	}
+	cfg.ContentReports.NotifyUsers = []string{"@admin:test"}
+	if n := newReportNotifier(cfg, nil, nil, nil, nil); n != nil {
+		t.Fatalf("expected no notifier without server notices")
+	}
+	var n *reportNotifier
+	n.notify(1, map[string]interface{}{})
+
+	// Reporting doesn't block when the notices can't keep up.
+	n = &reportNotifier{notices: make(chan map[string]interface{}, 1)}
+	n.notify(1, map[string]interface{}{"body": "1"})
+	n.notify(2, map[string]interface{}{"body": "2"})
+	if len(n.notices) != 1 || (<-n.notices)["body"] != "1" {
+		t.Fatalf("expected only the first notice to be queued")
+	}
+}
@@ -91,11 +91,21 @@ func Setup(
 
 	rateLimits := httputil.NewRateLimits(&cfg.RateLimiting)
 	rateLimitsFailedLogin := ratelimit.NewRtFailedLogin(&cfg.RtFailedLogin)
+	jwtKeys := auth.NewJWTKeySet(&cfg.JwtConfig)
+	sso := auth.NewSSO(&cfg.SSO)
+	multiRoomLimits, err := NewMultiRoomLimits(&dendriteCfg.SyncAPI.MultiRoom)
+	if err != nil {
+		logrus.WithError(err).Panic("failed to set up multiroom limits")
+	}
 	userInteractiveAuth := auth.NewUserInteractive(userAPI, cfg)
 
 	unstableFeatures := map[string]bool{
 		"org.matrix.e2e_cross_signing": true,
 		"org.matrix.msc2285.stable":    true,
+		// Native sliding sync, served by the sync API
+		"org.matrix.simplified_msc3575": true,
+		// Authenticated media, served by the media API
+		"org.matrix.msc3916.stable": true,
 	}
 	for _, msc := range cfg.MSCs.MSCs {
 		unstableFeatures["org.matrix."+msc] = true
@@ -252,10 +262,83 @@ func Setup(
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
 
+	dendriteAdminRouter.Handle("/admin/eventReports",
+		httputil.MakeAdminAPI("admin_event_reports", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminListEventReports(req, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/eventReports/{reportID}",
+		httputil.MakeAdminAPI("admin_event_report", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminGetEventReport(req, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/eventReports/{reportID}/resolve",
+		httputil.MakeAdminAPI("admin_resolve_event_report", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminResolveEventReport(req, device, rsAPI)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/users",
+		httputil.MakeAdminAPI("admin_list_users", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminListUsers(req, userAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/users/{userID}",
+		httputil.MakeAdminAPI("admin_user", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			switch req.Method {
+			case http.MethodPut:
+				return AdminUpdateUser(req, cfg, device, userAPI)
+			default:
+				return AdminGetUser(req, cfg, userAPI, rsAPI)
+			}
+		}),
+	).Methods(http.MethodGet, http.MethodPut, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/rooms",
+		httputil.MakeAdminAPI("admin_list_rooms", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminListRooms(req, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/rooms/{roomID}",
+		httputil.MakeAdminAPI("admin_room", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminGetRoom(req, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/rooms/{roomID}/members",
+		httputil.MakeAdminAPI("admin_room_members", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminGetRoomMembers(req, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/rooms/{roomID}/state",
+		httputil.MakeAdminAPI("admin_room_state", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminGetRoomState(req, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/rooms/{roomID}/block",
+		httputil.MakeAdminAPI("admin_room_block", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminRoomBlock(req, device, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodPut, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/ldap/sync",
+		httputil.MakeAdminAPI("admin_ldap_sync", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminLDAPSync(req, userAPI)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
 	// server notifications
+	var serverNotificationSender *userapi.Device
 	if cfg.Matrix.ServerNotices.Enabled {
 		logrus.Info("Enabling server notices at /_synapse/admin/v1/send_server_notice")
-		serverNotificationSender, err := getSenderDevice(context.Background(), rsAPI, userAPI, cfg)
+		var err error
+		serverNotificationSender, err = getSenderDevice(context.Background(), rsAPI, userAPI, cfg)
 		if err != nil {
 			logrus.WithError(err).Fatal("unable to get account for sending sending server notices")
 		}
@@ -296,6 +379,7 @@ func Setup(
 			}),
 		).Methods(http.MethodPost, http.MethodOptions)
 	}
+	reportNotifier := newReportNotifier(cfg, userAPI, rsAPI, asAPI, serverNotificationSender)
 
 	// You can't just do PathPrefix("/(r0|v3)") because regexps only apply when inside named path variables.
 	// So make a named path variable called 'apiversion' (which we will never read in handlers) and then do
@@ -337,6 +421,21 @@ func Setup(
 		}, httputil.WithAllowGuests()),
 	).Methods(http.MethodPost, http.MethodOptions)
 
+	v3mux.Handle("/knock/{roomIDOrAlias}",
+		httputil.MakeAuthAPI("knock", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			if r := rateLimits.Limit(req, device); r != nil {
+				return *r
+			}
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return KnockRoomByIDOrAlias(
+				req, device, rsAPI, userAPI, vars["roomIDOrAlias"],
+			)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
 	if mscCfg.Enabled("msc2753") {
 		v3mux.Handle("/peek/{roomIDOrAlias}",
 			httputil.MakeAuthAPI(spec.Peek, userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -537,6 +636,16 @@ func Setup(
 		}, httputil.WithAllowGuests()),
 	).Methods(http.MethodGet, http.MethodOptions)
 
+	v1mux.Handle("/rooms/{roomID}/timestamp_to_event",
+		httputil.MakeAuthAPI("timestamp_to_event", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return TimestampToEvent(req, device, rsAPI, vars["roomID"])
+		}, httputil.WithAllowGuests()),
+	).Methods(http.MethodGet, http.MethodOptions)
+
 	v3mux.Handle("/register", httputil.MakeExternalAPI("register", func(req *http.Request) util.JSONResponse {
 		if r := rateLimits.Limit(req, nil); r != nil {
 			return *r
@@ -551,7 +660,7 @@ func Setup(
 				return util.ErrorResponse(err)
 			}
 			dataType := vars["dataType"]
-			return PostMultiroom(req, device, syncProducer, dataType)
+			return PostMultiroom(req, device, syncProducer, multiRoomLimits, dataType)
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
 
@@ -640,13 +749,13 @@ func Setup(
 	v3mux.Handle("/logout",
 		httputil.MakeAuthAPI("logout", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			return Logout(req, userAPI, device)
-		}),
+		}, httputil.WithAllowLocked()),
 	).Methods(http.MethodPost, http.MethodOptions)
 
 	v3mux.Handle("/logout/all",
 		httputil.MakeAuthAPI("logout", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			return LogoutAll(req, userAPI, device)
-		}),
+		}, httputil.WithAllowLocked()),
 	).Methods(http.MethodPost, http.MethodOptions)
 
 	v3mux.Handle("/rooms/{roomID}/typing/{userID}",
@@ -661,6 +770,18 @@ func Setup(
 			return SendTyping(req, device, vars["roomID"], vars["userID"], rsAPI, syncProducer)
 		}),
 	).Methods(http.MethodPut, http.MethodOptions)
+	v3mux.Handle("/rooms/{roomID}/report/{eventID}",
+		httputil.MakeAuthAPI("rooms_report", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			if r := rateLimits.Limit(req, device); r != nil {
+				return *r
+			}
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return ReportEvent(req, device, vars["roomID"], vars["eventID"], rsAPI, reportNotifier)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/redact/{eventID}",
 		httputil.MakeAuthAPI("rooms_redact", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
@@ -740,10 +861,19 @@ func Setup(
 			if r := rateLimits.Limit(req, nil); r != nil {
 				return *r
 			}
-			return Login(req, userAPI, cfg, rateLimitsFailedLogin)
+			return Login(req, userAPI, rsAPI, cfg, rateLimitsFailedLogin, jwtKeys)
 		}),
 	).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
 
+	v3mux.Handle("/refresh",
+		httputil.MakeExternalAPI("refresh", func(req *http.Request) util.JSONResponse {
+			if r := rateLimits.Limit(req, nil); r != nil {
+				return *r
+			}
+			return Refresh(req, userAPI)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
 	v3mux.Handle("/auth/{authType}/fallback/web",
 		httputil.MakeHTMLAPI("auth_fallback", enableMetrics, func(w http.ResponseWriter, req *http.Request) {
 			vars := mux.Vars(req)
@@ -751,6 +881,25 @@ func Setup(
 		}),
 	).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
 
+	v3mux.Handle("/login/sso/redirect",
+		httputil.MakeHTMLAPI("login_sso_redirect", enableMetrics, func(w http.ResponseWriter, req *http.Request) {
+			SSORedirect(w, req, "", cfg, sso, rateLimits)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	v3mux.Handle("/login/sso/redirect/{idpID}",
+		httputil.MakeHTMLAPI("login_sso_redirect", enableMetrics, func(w http.ResponseWriter, req *http.Request) {
+			vars := mux.Vars(req)
+			SSORedirect(w, req, vars["idpID"], cfg, sso, rateLimits)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	v3mux.Handle("/login/sso/callback",
+		httputil.MakeHTMLAPI("login_sso_callback", enableMetrics, func(w http.ResponseWriter, req *http.Request) {
+			SSOCallback(w, req, cfg, userAPI, sso)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
 	// Push rules
 
 	v3mux.Handle("/pushrules",
@@ -93,6 +93,18 @@ func SendEvent(
 		}
 	}
 
+	// Events of shadow-banned users are dropped, apart from changes to their own membership.
+	if device.AccountShadowBanned && (eventType != spec.MRoomMember || stateKey == nil || *stateKey != device.UserID) {
+		res := util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: sendEventResponse{fakeEventID()},
+		}
+		if txnID != nil {
+			txnCache.AddTransaction(device.AccessToken, *txnID, req.URL, &res)
+		}
+		return res
+	}
+
 	// Translate user ID state keys to room keys in pseudo ID rooms
 	if roomVersion == gomatrixserverlib.RoomVersionPseudoIDs && stateKey != nil {
 		parsedRoomID, innerErr := spec.NewRoomID(roomID)
@@ -262,6 +274,12 @@ func SendEvent(
 	return res
 }
 
+// fakeEventID returns a random event ID, which is given to shadow-banned users
+// so that they can't tell that their events are being dropped.
+func fakeEventID() string {
+	return "$" + util.RandomString(43)
+}
+
 func updatePowerLevels(req *http.Request, r map[string]interface{}, roomID string, rsAPI api.ClientRoomserverAPI) error {
 	users, ok := r["users"]
 	if !ok {
@@ -79,7 +79,6 @@ func SendServerNotice(
 		}
 	}
 
-	ctx := req.Context()
 	var r sendServerNoticeRequest
 	resErr := httputil.UnmarshalJSONRequest(req, &r)
 	if resErr != nil {
@@ -102,13 +101,52 @@ func SendServerNotice(
 		}
 	}
 
+	var txnAndSessionID *api.TransactionID
+	if txnID != nil {
+		txnAndSessionID = &api.TransactionID{
+			TransactionID: *txnID,
+			SessionID:     device.SessionID,
+		}
+	}
+
+	res := sendServerNotice(
+		req.Context(), *userID, map[string]interface{}{
+			"body":    r.Content.Body,
+			"msgtype": r.Content.MsgType,
+		},
+		cfgNotices, cfgClient, userAPI, rsAPI, asAPI,
+		senderDevice, txnAndSessionID, device.UserDomain(),
+	)
+	// Add response to transactionsCache
+	if txnID != nil && res.Code == http.StatusOK {
+		txnCache.AddTransaction(device.AccessToken, *txnID, req.URL, &res)
+	}
+	return res
+}
+
+// sendServerNotice sends a message with the given content to the server notice room
+// of the user, creating the room or inviting the user again if needed.
+// nolint:gocyclo
+func sendServerNotice(
+	ctx context.Context,
+	userID spec.UserID,
+	content map[string]interface{},
+	cfgNotices *config.ServerNotices,
+	cfgClient *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+	txnAndSessionID *api.TransactionID,
+	origin spec.ServerName,
+) util.JSONResponse {
 	// get rooms for specified user
 	allUserRooms := []spec.RoomID{}
 	// Get rooms the user is either joined, invited or has left.
 	for _, membership := range []string{"join", "invite", "leave"} {
-		userRooms, queryErr := rsAPI.QueryRoomsForUser(ctx, *userID, membership)
+		userRooms, queryErr := rsAPI.QueryRoomsForUser(ctx, userID, membership)
 		if queryErr != nil {
-			return util.ErrorResponse(err)
+			return util.ErrorResponse(queryErr)
 		}
 		allUserRooms = append(allUserRooms, userRooms...)
 	}
@@ -148,7 +186,7 @@ func SendServerNotice(
 	// create a new room for the user
 	if len(commonRooms) == 0 {
 		powerLevelContent := eventutil.InitialPowerLevelsContent(senderUserID.String())
-		powerLevelContent.Users[r.UserID] = -10 // taken from Synapse
+		powerLevelContent.Users[userID.String()] = -10 // taken from Synapse
 		pl, err := json.Marshal(powerLevelContent)
 		if err != nil {
 			return util.ErrorResponse(err)
@@ -160,7 +198,7 @@ func SendServerNotice(
 			return util.ErrorResponse(err)
 		}
 		crReq := createRoomRequest{
-			Invite:                    []string{r.UserID},
+			Invite:                    []string{userID.String()},
 			Name:                      cfgNotices.RoomName,
 			Visibility:                "private",
 			Preset:                    spec.PresetPrivateChat,
@@ -181,7 +219,7 @@ func SendServerNotice(
 					Order: 1.0,
 				},
 			}}
-			if err = saveTagData(req, r.UserID, roomID, userAPI, serverAlertTag); err != nil {
+			if err = saveTagData(ctx, userID.String(), roomID, userAPI, serverAlertTag); err != nil {
 				util.GetLogger(ctx).WithError(err).Error("saveTagData failed")
 				return util.JSONResponse{
 					Code: http.StatusInternalServerError,
@@ -195,17 +233,9 @@ func SendServerNotice(
 		}
 	} else {
 		// we've found a room in common, check the membership
-		deviceUserID, err := spec.NewUserID(r.UserID, true)
-		if err != nil {
-			return util.JSONResponse{
-				Code: http.StatusForbidden,
-				JSON: spec.Forbidden("userID doesn't have power level to change visibility"),
-			}
-		}
-
 		roomID = commonRooms[0].String()
 		membershipRes := api.QueryMembershipForUserResponse{}
-		err = rsAPI.QueryMembershipForUser(ctx, &api.QueryMembershipForUserRequest{UserID: *deviceUserID, RoomID: roomID}, &membershipRes)
+		err = rsAPI.QueryMembershipForUser(ctx, &api.QueryMembershipForUserRequest{UserID: userID, RoomID: roomID}, &membershipRes)
 		if err != nil {
 			util.GetLogger(ctx).WithError(err).Error("unable to query membership for user")
 			return util.JSONResponse{
@@ -215,7 +245,7 @@ func SendServerNotice(
 		}
 		if !membershipRes.IsInRoom {
 			// re-invite the user
-			res, err := sendInvite(ctx, userAPI, senderDevice, roomID, r.UserID, "Server notice room", cfgClient, rsAPI, asAPI, time.Now())
+			res, err := sendInvite(ctx, userAPI, senderDevice, roomID, userID.String(), "Server notice room", cfgClient, rsAPI, asAPI, time.Now())
 			if err != nil {
 				return res
 			}
@@ -224,25 +254,13 @@ func SendServerNotice(
 
 	startedGeneratingEvent := time.Now()
 
-	request := map[string]interface{}{
-		"body":    r.Content.Body,
-		"msgtype": r.Content.MsgType,
-	}
-	e, resErr := generateSendEvent(ctx, request, senderDevice, roomID, "m.room.message", nil, rsAPI, time.Now())
+	e, resErr := generateSendEvent(ctx, content, senderDevice, roomID, "m.room.message", nil, rsAPI, time.Now())
 	if resErr != nil {
 		logrus.Errorf("failed to send message: %+v", resErr)
 		return *resErr
 	}
 	timeToGenerateEvent := time.Since(startedGeneratingEvent)
 
-	var txnAndSessionID *api.TransactionID
-	if txnID != nil {
-		txnAndSessionID = &api.TransactionID{
-			TransactionID: *txnID,
-			SessionID:     device.SessionID,
-		}
-	}
-
 	// pass the new event to the roomserver and receive the correct event ID
 	// event ID in case of duplicate transaction is discarded
 	startedSubmittingEvent := time.Now()
@@ -252,7 +270,7 @@ func SendServerNotice(
 		[]*types.HeaderedEvent{
 			{PDU: e},
 		},
-		device.UserDomain(),
+		origin,
 		cfgClient.Matrix.ServerName,
 		cfgClient.Matrix.ServerName,
 		txnAndSessionID,
@@ -271,21 +289,15 @@ func SendServerNotice(
 	}).Info("Sent event to roomserver")
 	timeToSubmitEvent := time.Since(startedSubmittingEvent)
 
-	res := util.JSONResponse{
-		Code: http.StatusOK,
-		JSON: sendEventResponse{e.EventID()},
-	}
-	// Add response to transactionsCache
-	if txnID != nil {
-		txnCache.AddTransaction(device.AccessToken, *txnID, req.URL, &res)
-	}
-
 	// Take a note of how long it took to generate the event vs submit
 	// it to the roomserver.
 	sendEventDuration.With(prometheus.Labels{"action": "build"}).Observe(float64(timeToGenerateEvent.Milliseconds()))
 	sendEventDuration.With(prometheus.Labels{"action": "submit"}).Observe(float64(timeToSubmitEvent.Milliseconds()))
 
-	return res
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: sendEventResponse{e.EventID()},
+	}
 }
 
 func (r sendServerNoticeRequest) valid() (ok bool) {
@@ -0,0 +1,310 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/matrix-org/dendrite/clientapi/auth"
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/httputil"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+)
+
+// ssoStateCookie binds an SSO session to the browser which started it.
+const ssoStateCookie = "dendrite_sso_state"
+
+// ssoConfirmTemplate asks the user whether to continue to a client which isn't in
+// the client whitelist, as the client is sent a login token for the account.
+const ssoConfirmTemplate = `
+<html>
+<head>
+<title>Continue to your client</title>
+<meta name='viewport' content='width=device-width, initial-scale=1,
+    user-scalable=no, minimum-scale=1.0, maximum-scale=1.0'>
+</head>
+<body>
+    <div>
+        <p>You are about to log in as {{.UserID}} at {{.Host}}.</p>
+        <p>Only continue if you trust this application and started the login yourself.</p>
+        <p><a href="{{.URL}}">Continue to {{.Host}}</a></p>
+    </div>
+</body>
+</html>
+`
+
+type identityProvider struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Icon  string `json:"icon,omitempty"`
+	Brand string `json:"brand,omitempty"`
+}
+
+// SSORedirect implements GET /login/sso/redirect and /login/sso/redirect/{idpID}.
+// The user is redirected to the identity provider, which redirects back to SSOCallback.
+func SSORedirect(
+	w http.ResponseWriter, req *http.Request, idpID string,
+	cfg *config.ClientAPI, sso *auth.SSO, rateLimits *httputil.RateLimits,
+) {
+	if !cfg.SSO.Enabled {
+		writeHTTPMessage(w, req, "SSO login is disabled on this Homeserver", http.StatusNotFound)
+		return
+	}
+	if r := rateLimits.Limit(req, nil); r != nil {
+		writeHTTPMessage(w, req, "Too many requests", r.Code)
+		return
+	}
+	redirectURL := req.URL.Query().Get("redirectUrl")
+	if redirectURL == "" {
+		writeHTTPMessage(w, req, "Missing redirectUrl", http.StatusBadRequest)
+		return
+	}
+	if !auth.IsValidRedirect(redirectURL) {
+		writeHTTPMessage(w, req, "The redirectUrl is not allowed", http.StatusBadRequest)
+		return
+	}
+	idp := sso.Provider(idpID)
+	if idp == nil {
+		writeHTTPMessage(w, req, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	authURL, state, err := sso.AuthorizationURL(req.Context(), idp.ID, redirectURL)
+	if errors.Is(err, auth.ErrSSOSessionLimit) {
+		util.GetLogger(req.Context()).WithError(err).Warn("Failed to start SSO login")
+		writeHTTPMessage(w, req, "Too many logins in progress, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).WithField("idp_id", idp.ID).Error("Failed to start SSO login")
+		writeHTTPMessage(w, req, "The identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, newSSOStateCookie(&cfg.SSO, state, int(auth.SSOSessionLifetime.Seconds())))
+	http.Redirect(w, req, authURL, http.StatusFound)
+}
+
+// SSOCallback implements GET /login/sso/callback. The account linked to the user of
+// the identity provider is looked up, or linked or created on the first login, and the
+// client is redirected back with a login token for m.login.token. The user is asked
+// to confirm the redirect if the client isn't in the client whitelist.
+func SSOCallback(
+	w http.ResponseWriter, req *http.Request,
+	cfg *config.ClientAPI, userAPI userapi.ClientUserAPI, sso *auth.SSO,
+) {
+	if !cfg.SSO.Enabled {
+		writeHTTPMessage(w, req, "SSO login is disabled on this Homeserver", http.StatusNotFound)
+		return
+	}
+	query := req.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		util.GetLogger(req.Context()).WithField("error", errCode).WithField("error_description", query.Get("error_description")).Warn("SSO login failed at the identity provider")
+		writeHTTPMessage(w, req, "Login failed at the identity provider", http.StatusForbidden)
+		return
+	}
+	state := query.Get("state")
+	cookie, err := req.Cookie(ssoStateCookie)
+	if state == "" || err != nil || cookie.Value != state {
+		writeHTTPMessage(w, req, "The login session is unknown or was started in a different browser", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, newSSOStateCookie(&cfg.SSO, "", -1))
+
+	user, err := sso.Callback(req.Context(), state, query.Get("code"))
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Warn("SSO login failed")
+		writeHTTPMessage(w, req, "Login failed", http.StatusForbidden)
+		return
+	}
+	userID, errRes := ssoAccount(req.Context(), cfg, userAPI, sso.Provider(user.IDPID), user)
+	if errRes != nil {
+		msg := "Login failed"
+		if matrixErr, ok := errRes.JSON.(spec.MatrixError); ok {
+			msg = matrixErr.Err
+		}
+		writeHTTPMessage(w, req, msg, errRes.Code)
+		return
+	}
+
+	var tokenRes userapi.PerformLoginTokenCreationResponse
+	if err = userAPI.PerformLoginTokenCreation(req.Context(), &userapi.PerformLoginTokenCreationRequest{
+		Data: userapi.LoginTokenData{UserID: userID},
+	}, &tokenRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformLoginTokenCreation failed")
+		writeHTTPMessage(w, req, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	redirect, err := url.Parse(user.RedirectURL)
+	if err != nil {
+		writeHTTPMessage(w, req, "Invalid redirectUrl", http.StatusBadRequest)
+		return
+	}
+	q := redirect.Query()
+	q.Set("loginToken", tokenRes.Metadata.Token)
+	redirect.RawQuery = q.Encode()
+	if sso.IsAllowedRedirect(user.RedirectURL) {
+		http.Redirect(w, req, redirect.String(), http.StatusFound)
+		return
+	}
+	host := redirect.Host
+	if host == "" {
+		host = redirect.Scheme
+	}
+	t := template.Must(template.New("sso_confirm").Parse(ssoConfirmTemplate))
+	// The URL was checked by auth.IsValidRedirect, so it may use the custom scheme of a native app.
+	if err = t.Execute(w, map[string]interface{}{
+		"UserID": userID,
+		"Host":   host,
+		"URL":    template.URL(redirect.String()), // nolint: gosec
+	}); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("Failed to render SSO confirmation page")
+	}
+}
+
+// newSSOStateCookie returns the cookie holding the state of the login started by the
+// browser. It is only sent to the callback endpoint, and not readable by scripts.
+func newSSOStateCookie(cfg *config.SSO, state string, maxAge int) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if callback, err := url.Parse(cfg.CallbackURL); err == nil {
+		cookie.Path = callback.Path
+		cookie.Secure = callback.Scheme == "https"
+	}
+	return cookie
+}
+
+// ssoAccount returns the user ID of the account linked to the user of the identity provider.
+// On the first login of the user, the account with the claimed localpart is linked if
+// allow_existing_users is set, or created if auto_provision is set.
+func ssoAccount(
+	ctx context.Context, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI,
+	idp *config.IdentityProvider, user *auth.SSOUser,
+) (string, *util.JSONResponse) {
+	var linked userapi.QueryLocalpartForSSOIdentityResponse
+	if err := userAPI.QueryLocalpartForSSOIdentity(ctx, &userapi.QueryLocalpartForSSOIdentityRequest{
+		IDPID:   idp.ID,
+		Subject: user.Subject,
+	}, &linked); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("userAPI.QueryLocalpartForSSOIdentity failed")
+		return "", &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if linked.Localpart != "" {
+		return userutil.MakeUserID(linked.Localpart, linked.ServerName), nil
+	}
+
+	localpart := strings.ToLower(user.Localpart)
+	serverName := cfg.Matrix.ServerName
+	if localpart == "" {
+		return "", &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.Forbidden("The identity provider didn't provide a username"),
+		}
+	}
+	if err := internal.ValidateUsername(localpart, serverName); err != nil {
+		return "", &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.InvalidUsername(err.Error()),
+		}
+	}
+
+	var existing userapi.QueryAccountByLocalpartResponse
+	err := userAPI.QueryAccountByLocalpart(ctx, &userapi.QueryAccountByLocalpartRequest{
+		Localpart:  localpart,
+		ServerName: serverName,
+	}, &existing)
+	switch {
+	case err == nil:
+		if !idp.AllowExistingUsers {
+			return "", &util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: spec.UserInUse("The username is already taken by an account which isn't linked to the identity provider"),
+			}
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		if !idp.AutoProvision {
+			return "", &util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: spec.Forbidden("There is no account for this user"),
+			}
+		}
+		// The account has no password, so it can only log in with SSO.
+		var created userapi.PerformAccountCreationResponse
+		if err = userAPI.PerformAccountCreation(ctx, &userapi.PerformAccountCreationRequest{
+			Localpart:   localpart,
+			ServerName:  serverName,
+			AccountType: userapi.AccountTypeUser,
+			OnConflict:  userapi.ConflictAbort,
+		}, &created); err != nil {
+			util.GetLogger(ctx).WithError(err).Error("userAPI.PerformAccountCreation failed")
+			return "", &util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		if user.DisplayName != "" {
+			if _, _, err = userAPI.SetDisplayName(ctx, localpart, serverName, user.DisplayName); err != nil {
+				util.GetLogger(ctx).WithError(err).Error("userAPI.SetDisplayName failed")
+			}
+		}
+		util.GetLogger(ctx).WithField("user_id", created.Account.UserID).WithField("idp_id", idp.ID).Info("Created account for SSO login")
+	default:
+		util.GetLogger(ctx).WithError(err).Error("userAPI.QueryAccountByLocalpart failed")
+		return "", &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	if err = userAPI.PerformSaveSSOIdentityAssociation(ctx, &userapi.PerformSaveSSOIdentityAssociationRequest{
+		IDPID:      idp.ID,
+		Subject:    user.Subject,
+		Localpart:  localpart,
+		ServerName: serverName,
+	}, &struct{}{}); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("userAPI.PerformSaveSSOIdentityAssociation failed")
+		return "", &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return userutil.MakeUserID(localpart, serverName), nil
+}
+
+// ssoIdentityProviders returns the identity providers advertised in the m.login.sso login flow.
+func ssoIdentityProviders(cfg *config.SSO) []identityProvider {
+	idps := make([]identityProvider, 0, len(cfg.Providers))
+	for _, p := range cfg.Providers {
+		idps = append(idps, identityProvider{ID: p.ID, Name: p.Name, Icon: p.Icon, Brand: p.Brand})
+	}
+	return idps
+}
@@ -0,0 +1,82 @@
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/matrix-org/dendrite/clientapi/auth"
+	"github.com/matrix-org/dendrite/internal/httputil"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+func TestSSOStateCookie(t *testing.T) {
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 "https://idp.test",
+			"authorization_endpoint": "https://idp.test/authorize",
+			"token_endpoint":         "https://idp.test/token",
+			"jwks_uri":               "https://idp.test/jwks",
+		})
+	}))
+	t.Cleanup(idp.Close)
+
+	cfg := &config.ClientAPI{}
+	cfg.SSO = config.SSO{
+		Enabled:     true,
+		CallbackURL: "https://matrix.test/_matrix/client/v3/login/sso/callback",
+		Providers: []config.IdentityProvider{{
+			ID:           "test",
+			DiscoveryURL: idp.URL,
+			ClientID:     "dendrite",
+		}},
+	}
+	cfg.SSO.Providers[0].Defaults()
+	sso := auth.NewSSO(&cfg.SSO)
+	rateLimits := httputil.NewRateLimits(&config.RateLimiting{})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/login/sso/redirect?redirectUrl="+url.QueryEscape("https://client.test/"), nil)
+	SSORedirect(rec, req, "", cfg, sso, rateLimits)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected a redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := location.Query().Get("state")
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != state || !cookies[0].HttpOnly || !cookies[0].Secure ||
+		cookies[0].SameSite != http.SameSiteLaxMode || cookies[0].Path != "/_matrix/client/v3/login/sso/callback" {
+		t.Fatalf("unexpected state cookie: %+v", cookies)
+	}
+
+	// The callback is refused in a browser which didn't start the login.
+	for name, cookie := range map[string]*http.Cookie{
+		"no cookie":    nil,
+		"other cookie": {Name: ssoStateCookie, Value: "other"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/login/sso/callback?code=code&state="+url.QueryEscape(state), nil)
+			if cookie != nil {
+				req.AddCookie(cookie)
+			}
+			SSOCallback(rec, req, cfg, nil, sso)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected the callback to be refused, got %d", rec.Code)
+			}
+		})
+	}
+
+	// redirects to URLs which run code are refused
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/login/sso/redirect?redirectUrl="+url.QueryEscape("javascript:alert(1)"), nil)
+	SSORedirect(rec, req, "", cfg, sso, rateLimits)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected the redirectUrl to be refused, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+// TimestampToEvent implements GET /_matrix/client/v1/rooms/{roomID}/timestamp_to_event,
+// returning the event closest to the given timestamp in the given direction.
+func TimestampToEvent(
+	req *http.Request, device *userapi.Device, rsAPI api.ClientRoomserverAPI, roomIDStr string,
+) util.JSONResponse {
+	roomID, err := spec.NewRoomID(roomIDStr)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid room ID"),
+		}
+	}
+	ts, err := strconv.ParseUint(req.URL.Query().Get("ts"), 10, 64)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("ts must be a timestamp in milliseconds"),
+		}
+	}
+	dir := req.URL.Query().Get("dir")
+	if dir != "f" && dir != "b" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("dir must be one of 'f' or 'b'"),
+		}
+	}
+
+	if resErr := canSeeRoomTimeline(req, device, rsAPI, roomID); resErr != nil {
+		return *resErr
+	}
+
+	res, err := rsAPI.QueryTimestampToEvent(req.Context(), *roomID, spec.Timestamp(ts), dir == "b", true)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryTimestampToEvent failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if res == nil {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound(fmt.Sprintf("Unable to find event from %d in direction %s", ts, dir)),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// canSeeRoomTimeline returns an error response unless the user is joined to
+// the room or the room is world readable.
+func canSeeRoomTimeline(
+	req *http.Request, device *userapi.Device, rsAPI api.ClientRoomserverAPI, roomID *spec.RoomID,
+) *util.JSONResponse {
+	userID, err := spec.NewUserID(device.UserID, true)
+	if err != nil {
+		return &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.Unknown("Device UserID is invalid"),
+		}
+	}
+	var membershipRes api.QueryMembershipForUserResponse
+	if err = rsAPI.QueryMembershipForUser(req.Context(), &api.QueryMembershipForUserRequest{
+		RoomID: roomID.String(),
+		UserID: *userID,
+	}, &membershipRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryMembershipForUser failed")
+		return &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if membershipRes.IsInRoom {
+		return nil
+	}
+
+	stateRes := api.QueryLatestEventsAndStateResponse{}
+	if err = rsAPI.QueryLatestEventsAndState(req.Context(), &api.QueryLatestEventsAndStateRequest{
+		RoomID: roomID.String(),
+		StateToFetch: []gomatrixserverlib.StateKeyTuple{
+			{EventType: spec.MRoomHistoryVisibility, StateKey: ""},
+		},
+	}, &stateRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryLatestEventsAndState failed")
+		return &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	for _, ev := range stateRes.StateEvents {
+		if ev.Type() != spec.MRoomHistoryVisibility {
+			continue
+		}
+		content := map[string]string{}
+		if err = json.Unmarshal(ev.Content(), &content); err == nil && content["history_visibility"] == "world_readable" {
+			return nil
+		}
+	}
+	return &util.JSONResponse{
+		Code: http.StatusForbidden,
+		JSON: spec.Forbidden("You are not allowed to view this room."),
+	}
+}
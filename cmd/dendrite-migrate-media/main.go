@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
+	"github.com/matrix-org/dendrite/setup"
+	"github.com/sirupsen/logrus"
+)
+
+// This is a utility for moving the files of the media repository between
+// storage backends, e.g. from the local filesystem into an S3-compatible
+// object store. Both backends are configured in the media_api section of the
+// config file. Files which already exist in the destination are skipped, so
+// it is safe to run it again if it is interrupted.
+//
+// Usage: ./dendrite-migrate-media --config dendrite.yaml --from filesystem --to s3 [--delete]
+
+var from = flag.String("from", "filesystem", "the storage backend to copy media from (filesystem or s3)")
+var to = flag.String("to", "s3", "the storage backend to copy media to (filesystem or s3)")
+var deleteSource = flag.Bool("delete", false, "delete the files from the source backend once they have been copied")
+var verbose = flag.Bool("verbose", false, "log every file which is copied")
+
+func main() {
+	cfg := setup.ParseFlags(true)
+	if *verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	if *from == *to {
+		fmt.Fprintln(os.Stderr, "The source and destination backends must be different")
+		os.Exit(1)
+	}
+
+	source, err := mediastore.NewMediaStore(&cfg.MediaAPI, *from)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to set up source media store")
+	}
+	destination, err := mediastore.NewMediaStore(&cfg.MediaAPI, *to)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to set up destination media store")
+	}
+
+	logger := logrus.WithFields(logrus.Fields{
+		"from": *from,
+		"to":   *to,
+	})
+	logger.Info("Migrating media")
+	copied, err := mediastore.Migrate(context.Background(), source, destination, *deleteSource, logger)
+	if err != nil {
+		logger.WithError(err).WithField("copied", copied).Fatal("Failed to migrate media")
+	}
+	logger.WithField("copied", copied).Info("Migrated media")
+	fmt.Printf("Copied %d files from %s to %s\n", copied, *from, *to)
+	if cfg.MediaAPI.Storage.Backend != *to {
+		fmt.Printf("Remember to set media_api.storage.backend to %q in your config file\n", *to)
+	}
+}
@@ -58,6 +58,8 @@ type RoomserverFederationAPI interface {
 	PerformDirectoryLookup(ctx context.Context, request *PerformDirectoryLookupRequest, response *PerformDirectoryLookupResponse) error
 	// Handle an instruction to make_join & send_join with a remote server.
 	PerformJoin(ctx context.Context, request *PerformJoinRequest, response *PerformJoinResponse)
+	// Handle an instruction to make_knock & send_knock with a remote server.
+	PerformKnock(ctx context.Context, request *PerformKnockRequest, response *PerformKnockResponse) error
 	// Handle an instruction to make_leave & send_leave with a remote server.
 	PerformLeave(ctx context.Context, request *PerformLeaveRequest, response *PerformLeaveResponse) error
 	// Handle sending an invite to a remote server.
@@ -76,6 +78,8 @@ type RoomserverFederationAPI interface {
 	LookupMissingEvents(ctx context.Context, origin, s spec.ServerName, roomID string, missing fclient.MissingEvents, roomVersion gomatrixserverlib.RoomVersion) (res fclient.RespMissingEvents, err error)
 
 	RoomHierarchies(ctx context.Context, origin, dst spec.ServerName, roomID string, suggestedOnly bool) (res fclient.RoomHierarchyResponse, err error)
+	// Ask a remote server for the event closest to the given timestamp in the given direction ("f" or "b").
+	TimestampToEvent(ctx context.Context, origin, s spec.ServerName, roomID string, ts spec.Timestamp, dir string) (res RespTimestampToEvent, err error)
 }
 
 type P2PFederationAPI interface {
@@ -183,6 +187,23 @@ type PerformOutboundPeekResponse struct {
 	LastError *gomatrix.HTTPError
 }
 
+type PerformKnockRequest struct {
+	RoomID      string                 `json:"room_id"`
+	UserID      string                 `json:"user_id"`
+	ServerNames types.ServerNames      `json:"server_names"`
+	Content     map[string]interface{} `json:"content"`
+}
+
+type PerformKnockResponse struct {
+	// The knock event as sent to the remote server.
+	Event       gomatrixserverlib.PDU
+	RoomVersion gomatrixserverlib.RoomVersion
+	// The stripped state of the room, as returned by the remote server.
+	KnockRoomState []gomatrixserverlib.InviteStrippedState
+	// The server the knock was sent through.
+	KnockedVia spec.ServerName
+}
+
 type PerformLeaveRequest struct {
 	RoomID      string            `json:"room_id"`
 	UserID      string            `json:"user_id"`
@@ -202,6 +223,12 @@ type PerformInviteResponse struct {
 	Event *rstypes.HeaderedEvent `json:"event"`
 }
 
+// RespTimestampToEvent is the response to a /timestamp_to_event request.
+type RespTimestampToEvent struct {
+	EventID        string         `json:"event_id"`
+	OriginServerTS spec.Timestamp `json:"origin_server_ts"`
+}
+
 // QueryJoinedHostServerNamesInRoomRequest is a request to QueryJoinedHostServerNames
 type QueryJoinedHostServerNamesInRoomRequest struct {
 	RoomID             string `json:"room_id"`
@@ -0,0 +1,137 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/matrix-org/dendrite/federationapi/queue"
+	"github.com/matrix-org/dendrite/federationapi/storage"
+	fedTypes "github.com/matrix-org/dendrite/federationapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/dendrite/setup/process"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+// OutputMultiRoomConsumer consumes multiroom data that originates in the syncapi.
+type OutputMultiRoomConsumer struct {
+	ctx               context.Context
+	jetstream         nats.JetStreamContext
+	durable           string
+	db                storage.Database
+	queues            *queue.OutgoingQueues
+	isLocalServerName func(spec.ServerName) bool
+	topic             string
+}
+
+// NewOutputMultiRoomConsumer creates a new OutputMultiRoomConsumer. Call Start() to begin consuming multiroom data.
+func NewOutputMultiRoomConsumer(
+	process *process.ProcessContext,
+	cfg *config.FederationAPI,
+	js nats.JetStreamContext,
+	queues *queue.OutgoingQueues,
+	store storage.Database,
+) *OutputMultiRoomConsumer {
+	return &OutputMultiRoomConsumer{
+		ctx:               process.Context(),
+		jetstream:         js,
+		queues:            queues,
+		db:                store,
+		isLocalServerName: cfg.Matrix.IsLocalServerName,
+		durable:           cfg.Matrix.JetStream.Durable("FederationAPIMultiRoomConsumer"),
+		topic:             cfg.Matrix.JetStream.Prefixed(jetstream.OutputMultiRoomFederation),
+	}
+}
+
+// Start consuming from the syncapi
+func (t *OutputMultiRoomConsumer) Start() error {
+	return jetstream.JetStreamConsumer(
+		t.ctx, t.jetstream, t.topic, t.durable, 1, t.onMessage,
+		nats.DeliverAll(), nats.ManualAck(),
+	)
+}
+
+// onMessage is called in response to multiroom data received from the syncapi.
+// The data is sent to every server joined to one of the rooms it is visible in,
+// each server only learns about the rooms it participates in.
+func (t *OutputMultiRoomConsumer) onMessage(ctx context.Context, msgs []*nats.Msg) bool {
+	msg := msgs[0] // Guaranteed to exist if onMessage is called
+	userID := msg.Header.Get(jetstream.UserID)
+	dataType := msg.Header.Get("type")
+	roomIDs := msg.Header.Values(jetstream.RoomID)
+
+	// only send multiroom data which originated from us
+	_, serverName, err := gomatrixserverlib.SplitID('@', userID)
+	if err != nil {
+		log.WithError(err).WithField("user_id", userID).Error("failed to extract domain from multiroom data sender")
+		return true
+	}
+	if !t.isLocalServerName(serverName) {
+		return true
+	}
+
+	ts, err := strconv.ParseUint(msg.Header.Get("origin_server_ts"), 10, 64)
+	if err != nil {
+		// If the message was invalid, log it and move on to the next message in the stream
+		log.WithError(err).Errorf("EDU output log: message parse failure")
+		sentry.CaptureException(err)
+		return true
+	}
+
+	roomsByServer := map[spec.ServerName][]string{}
+	for _, roomID := range roomIDs {
+		joined, err := t.db.GetJoinedHosts(ctx, roomID)
+		if err != nil {
+			log.WithError(err).WithField("room_id", roomID).Error("failed to get joined hosts for room")
+			return false
+		}
+		for _, host := range joined {
+			if t.isLocalServerName(host.ServerName) {
+				continue
+			}
+			roomsByServer[host.ServerName] = append(roomsByServer[host.ServerName], roomID)
+		}
+	}
+
+	for destination, rooms := range roomsByServer {
+		edu := &gomatrixserverlib.EDU{
+			Type:   fedTypes.MMultiRoom,
+			Origin: string(serverName),
+		}
+		if edu.Content, err = json.Marshal(fedTypes.MultiRoomEDU{
+			UserID:         userID,
+			Type:           dataType,
+			RoomIDs:        rooms,
+			Content:        msg.Data,
+			OriginServerTs: spec.Timestamp(ts),
+		}); err != nil {
+			log.WithError(err).Error("failed to marshal EDU JSON")
+			return true
+		}
+		if err = t.queues.SendEDU(edu, serverName, []spec.ServerName{destination}); err != nil {
+			log.WithError(err).WithField("destination", destination).Error("failed to send EDU")
+			return false
+		}
+	}
+
+	return true
+}
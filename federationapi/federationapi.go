@@ -65,6 +65,7 @@ func AddPublicRoutes(
 		TopicPresenceEvent:     cfg.Matrix.JetStream.Prefixed(jetstream.OutputPresenceEvent),
 		TopicDeviceListUpdate:  cfg.Matrix.JetStream.Prefixed(jetstream.InputDeviceListUpdate),
 		TopicSigningKeyUpdate:  cfg.Matrix.JetStream.Prefixed(jetstream.InputSigningKeyUpdate),
+		TopicMultiRoomCast:     cfg.Matrix.JetStream.Prefixed(jetstream.OutputMultiRoomCast),
 		Config:                 cfg,
 		UserAPI:                userAPI,
 	}
@@ -168,6 +169,13 @@ func NewInternalAPI(
 		logrus.WithError(err).Panic("failed to start presence consumer")
 	}
 
+	multiRoomConsumer := consumers.NewOutputMultiRoomConsumer(
+		processContext, cfg, js, queues, federationDB,
+	)
+	if err = multiRoomConsumer.Start(); err != nil {
+		logrus.WithError(err).Panic("failed to start multiroom consumer")
+	}
+
 	var cleanExpiredEDUs func()
 	cleanExpiredEDUs = func() {
 		logrus.Infof("Cleaning expired EDUs")
@@ -2,11 +2,16 @@ package internal
 
 import (
 	"context"
+	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/gomatrixserverlib/fclient"
 	"github.com/matrix-org/gomatrixserverlib/spec"
+
+	"github.com/matrix-org/dendrite/federationapi/api"
 )
 
 const defaultTimeout = time.Second * 30
@@ -207,3 +212,33 @@ func (a *FederationInternalAPI) RoomHierarchies(
 	}
 	return ires.(fclient.RoomHierarchyResponse), nil
 }
+
+func (a *FederationInternalAPI) TimestampToEvent(
+	ctx context.Context, origin, s spec.ServerName, roomID string, ts spec.Timestamp, dir string,
+) (res api.RespTimestampToEvent, err error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+	ires, err := a.doRequestIfNotBlacklisted(s, func() (interface{}, error) {
+		identity, err := a.cfg.Matrix.SigningIdentityFor(origin)
+		if err != nil {
+			return nil, err
+		}
+		path := "/_matrix/federation/v1/timestamp_to_event/" + url.PathEscape(roomID) +
+			"?ts=" + strconv.FormatUint(uint64(ts), 10) + "&dir=" + url.QueryEscape(dir)
+		req := fclient.NewFederationRequest(http.MethodGet, origin, s, path)
+		if err = req.Sign(identity.ServerName, identity.KeyID, identity.PrivateKey); err != nil {
+			return nil, err
+		}
+		httpReq, err := req.HTTPRequest()
+		if err != nil {
+			return nil, err
+		}
+		var resp api.RespTimestampToEvent
+		err = a.federation.DoRequestAndParseResponse(ctx, httpReq, &resp)
+		return resp, err
+	})
+	if err != nil {
+		return res, err
+	}
+	return ires.(api.RespTimestampToEvent), nil
+}
@@ -542,6 +542,142 @@ func (r *FederationInternalAPI) PerformLeave(
 	)
 }
 
+// PerformKnock implements api.FederationInternalAPI
+func (r *FederationInternalAPI) PerformKnock(
+	ctx context.Context,
+	request *api.PerformKnockRequest,
+	response *api.PerformKnockResponse,
+) error {
+	userID, err := spec.NewUserID(request.UserID, true)
+	if err != nil {
+		return err
+	}
+
+	// Rooms with pseudo IDs aren't supported, as the knock is signed by the
+	// server key. The remote server rejects room versions without knocking.
+	var supportedVersions []gomatrixserverlib.RoomVersion
+	for v := range version.SupportedRoomVersions() {
+		if v != gomatrixserverlib.RoomVersionPseudoIDs {
+			supportedVersions = append(supportedVersions, v)
+		}
+	}
+
+	// Deduplicate the server names we were provided but keep the ordering
+	// as this encodes useful information about which servers are most likely
+	// to respond.
+	seenSet := make(map[spec.ServerName]bool)
+	var uniqueList []spec.ServerName
+	for _, srv := range request.ServerNames {
+		if seenSet[srv] || r.cfg.Matrix.IsLocalServerName(srv) {
+			continue
+		}
+		seenSet[srv] = true
+		uniqueList = append(uniqueList, srv)
+	}
+	request.ServerNames = uniqueList
+
+	// Try each server that we were provided until we land on one that
+	// successfully completes the make-knock send-knock dance.
+	var lastErr error
+	for _, serverName := range request.ServerNames {
+		if !r.shouldAttemptDirectFederation(serverName) {
+			continue
+		}
+		if lastErr = r.performKnockUsingServer(ctx, request, response, *userID, serverName, supportedVersions); lastErr != nil {
+			logrus.WithError(lastErr).WithFields(logrus.Fields{
+				"server_name": serverName,
+				"room_id":     request.RoomID,
+			}).Warnf("Failed to knock on room through server")
+			continue
+		}
+		response.KnockedVia = serverName
+		return nil
+	}
+
+	// If we reach here then we didn't complete a knock for some reason.
+	// Pass the error of the remote server on, so that it can be proxied to the client.
+	var httpErr gomatrix.HTTPError
+	if errors.As(lastErr, &httpErr) {
+		httpErr.Message = string(httpErr.Contents)
+		return &httpErr
+	}
+	return fmt.Errorf(
+		"failed to knock on room %q through %d server(s): %w",
+		request.RoomID, len(request.ServerNames), lastErr,
+	)
+}
+
+func (r *FederationInternalAPI) performKnockUsingServer(
+	ctx context.Context,
+	request *api.PerformKnockRequest,
+	response *api.PerformKnockResponse,
+	userID spec.UserID,
+	serverName spec.ServerName,
+	supportedVersions []gomatrixserverlib.RoomVersion,
+) error {
+	respMakeKnock, err := r.federation.MakeKnock(
+		ctx, userID.Domain(), serverName, request.RoomID, request.UserID, supportedVersions,
+	)
+	if err != nil {
+		r.statistics.ForServer(serverName).Failure()
+		return fmt.Errorf("r.federation.MakeKnock: %w", err)
+	}
+
+	// Work out if we support the room version that has been supplied in
+	// the make_knock response.
+	verImpl, err := version.SupportedRoomVersion(respMakeKnock.RoomVersion)
+	if err != nil {
+		return err
+	}
+	if respMakeKnock.RoomVersion == gomatrixserverlib.RoomVersionPseudoIDs {
+		return fmt.Errorf("knocking on rooms with room version %q is not supported", respMakeKnock.RoomVersion)
+	}
+
+	// Set all the fields to be what they should be, this should be a no-op
+	// but it's possible that the remote server returned us something "odd"
+	senderID := request.UserID
+	respMakeKnock.KnockEvent.Type = spec.MRoomMember
+	respMakeKnock.KnockEvent.SenderID = senderID
+	respMakeKnock.KnockEvent.StateKey = &senderID
+	respMakeKnock.KnockEvent.RoomID = request.RoomID
+	respMakeKnock.KnockEvent.Redacts = ""
+	content := map[string]interface{}{}
+	for k, v := range request.Content {
+		content[k] = v
+	}
+	content["membership"] = spec.Knock
+	if err = respMakeKnock.KnockEvent.SetContent(content); err != nil {
+		return fmt.Errorf("respMakeKnock.KnockEvent.SetContent: %w", err)
+	}
+	if err = respMakeKnock.KnockEvent.SetUnsigned(struct{}{}); err != nil {
+		return fmt.Errorf("respMakeKnock.KnockEvent.SetUnsigned: %w", err)
+	}
+
+	// Build the knock event.
+	event, err := verImpl.NewEventBuilderFromProtoEvent(&respMakeKnock.KnockEvent).Build(
+		time.Now(),
+		userID.Domain(),
+		r.cfg.Matrix.KeyID,
+		r.cfg.Matrix.PrivateKey,
+	)
+	if err != nil {
+		return fmt.Errorf("respMakeKnock.KnockEvent.Build: %w", err)
+	}
+
+	// Try to perform a send_knock using the newly built event.
+	respSendKnock, err := r.federation.SendKnock(ctx, userID.Domain(), serverName, event)
+	if err != nil {
+		r.statistics.ForServer(serverName).Failure()
+		return fmt.Errorf("r.federation.SendKnock: %w", err)
+	}
+	r.statistics.ForServer(serverName).Success(statistics.SendDirect)
+
+	response.Event = event
+	response.RoomVersion = respMakeKnock.RoomVersion
+	response.KnockRoomState = respSendKnock.KnockRoomState
+	return nil
+}
+
 // SendInvite implements api.FederationInternalAPI
 func (r *FederationInternalAPI) SendInvite(
 	ctx context.Context,
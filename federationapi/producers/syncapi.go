@@ -40,6 +40,7 @@ type SyncAPIProducer struct {
 	TopicPresenceEvent     string
 	TopicDeviceListUpdate  string
 	TopicSigningKeyUpdate  string
+	TopicMultiRoomCast     string
 	JetStream              nats.JetStreamContext
 	Config                 *config.FederationAPI
 	UserAPI                userapi.FederationUserAPI
@@ -47,7 +48,7 @@ type SyncAPIProducer struct {
 
 func (p *SyncAPIProducer) SendReceipt(
 	ctx context.Context,
-	userID, roomID, eventID, receiptType string, timestamp spec.Timestamp,
+	userID, roomID, eventID, receiptType, threadID string, timestamp spec.Timestamp,
 ) error {
 	m := &nats.Msg{
 		Subject: p.TopicReceiptEvent,
@@ -57,6 +58,7 @@ func (p *SyncAPIProducer) SendReceipt(
 	m.Header.Set(jetstream.RoomID, roomID)
 	m.Header.Set(jetstream.EventID, eventID)
 	m.Header.Set("type", receiptType)
+	m.Header.Set("thread_id", threadID)
 	m.Header.Set("timestamp", fmt.Sprintf("%d", timestamp))
 
 	log.WithFields(log.Fields{}).Tracef("Producing to topic '%s'", p.TopicReceiptEvent)
@@ -186,3 +188,17 @@ func (p *SyncAPIProducer) SendSigningKeyUpdate(
 	_, err = p.JetStream.PublishMsg(m, nats.Context(ctx))
 	return err
 }
+
+func (p *SyncAPIProducer) SendMultiRoom(
+	ctx context.Context, userID, dataType string, data []byte, timestamp spec.Timestamp,
+) error {
+	m := nats.NewMsg(p.TopicMultiRoomCast)
+	m.Header.Set(jetstream.UserID, userID)
+	m.Header.Set("type", dataType)
+	m.Header.Set("timestamp", fmt.Sprintf("%d", timestamp))
+	m.Data = data
+
+	log.Debugf("Sending multiroom data")
+	_, err := p.JetStream.PublishMsg(m, nats.Context(ctx))
+	return err
+}
@@ -64,6 +64,7 @@ func Send(
 	federation fclient.FederationClient,
 	mu *internal.MutexByRoom,
 	producer *producers.SyncAPIProducer,
+	multiRoom *internal.MultiRoomValidator,
 ) util.JSONResponse {
 	// First we should check if this origin has already submitted this
 	// txn ID to us. If they have and the txnIDs map contains an entry,
@@ -124,6 +125,7 @@ func Send(
 		mu,
 		producer,
 		cfg.Matrix.Presence.EnableInbound,
+		multiRoom,
 		txnEvents.PDUs,
 		txnEvents.EDUs,
 		request.Origin(),
@@ -0,0 +1,101 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/matrix-org/gomatrixserverlib/fclient"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+)
+
+// TimestampToEvent implements GET /_matrix/federation/v1/timestamp_to_event/{roomID},
+// returning the event closest to the given timestamp in the given direction.
+// Only the local timeline is consulted, other servers are never asked.
+func TimestampToEvent(
+	httpReq *http.Request,
+	request *fclient.FederationRequest,
+	rsAPI api.FederationRoomserverAPI,
+	roomIDStr string,
+) util.JSONResponse {
+	roomID, err := spec.NewRoomID(roomIDStr)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid room ID"),
+		}
+	}
+	ts, err := strconv.ParseUint(httpReq.URL.Query().Get("ts"), 10, 64)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("ts must be a timestamp in milliseconds"),
+		}
+	}
+	dir := httpReq.URL.Query().Get("dir")
+	if dir != "f" && dir != "b" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("dir must be one of 'f' or 'b'"),
+		}
+	}
+
+	if resErr := ErrorIfLocalServerNotInRoom(httpReq.Context(), rsAPI, roomIDStr); resErr != nil {
+		return *resErr
+	}
+
+	// Only servers in the room may look at its timeline.
+	var joinedRes api.QueryServerJoinedToRoomResponse
+	if err = rsAPI.QueryServerJoinedToRoom(httpReq.Context(), &api.QueryServerJoinedToRoomRequest{
+		ServerName: request.Origin(),
+		RoomID:     roomIDStr,
+	}, &joinedRes); err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("rsAPI.QueryServerJoinedToRoom failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if !joinedRes.IsInRoom {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.Forbidden("The requesting server is not in the room"),
+		}
+	}
+
+	res, err := rsAPI.QueryTimestampToEvent(httpReq.Context(), *roomID, spec.Timestamp(ts), dir == "b", false)
+	if err != nil {
+		util.GetLogger(httpReq.Context()).WithError(err).Error("rsAPI.QueryTimestampToEvent failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if res == nil {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound(fmt.Sprintf("Unable to find event from %d in direction %s", ts, dir)),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
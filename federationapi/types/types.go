@@ -14,10 +14,16 @@
 
 package types
 
-import "github.com/matrix-org/gomatrixserverlib/spec"
+import (
+	"encoding/json"
+
+	"github.com/matrix-org/gomatrixserverlib/spec"
+)
 
 const MSigningKeyUpdate = "m.signing_key_update" // TODO: move to gomatrixserverlib
 
+const MMultiRoom = "connect.multiroom"
+
 // A JoinedHost is a server that is joined to a matrix room.
 type JoinedHost struct {
 	// The MemberEventID of a m.room.member join event.
@@ -62,7 +68,8 @@ type FederationReceiptData struct {
 }
 
 type ReceiptTS struct {
-	TS spec.Timestamp `json:"ts"`
+	TS       spec.Timestamp `json:"ts"`
+	ThreadID string         `json:"thread_id,omitempty"`
 }
 
 type Presence struct {
@@ -76,3 +83,13 @@ type PresenceContent struct {
 	StatusMsg       *string `json:"status_msg,omitempty"`
 	UserID          string  `json:"user_id"`
 }
+
+// MultiRoomEDU carries the multiroom data of a user to the servers which share
+// a room with it, RoomIDs contains the shared rooms the data is visible in.
+type MultiRoomEDU struct {
+	UserID         string          `json:"user_id"`
+	Type           string          `json:"type"`
+	RoomIDs        []string        `json:"room_ids"`
+	Content        json.RawMessage `json:"content"`
+	OriginServerTs spec.Timestamp  `json:"origin_server_ts"`
+}
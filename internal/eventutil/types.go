@@ -49,6 +49,17 @@ type NotificationData struct {
 	// UnreadNotificationCount is the total number of unread
 	// notifications.
 	UnreadNotificationCount int `json:"unread_notification_count"`
+
+	// Threads contains the unread notification counts per thread root.
+	// These are included in the room-wide counts above.
+	Threads map[string]*ThreadNotificationData `json:"threads,omitempty"`
+}
+
+// ThreadNotificationData contains statistics about notifications in
+// a single thread.
+type ThreadNotificationData struct {
+	UnreadHighlightCount    int `json:"unread_highlight_count"`
+	UnreadNotificationCount int `json:"unread_notification_count"`
 }
 
 // UserProfile is a struct containing all known user profile data
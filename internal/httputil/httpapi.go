@@ -43,7 +43,8 @@ type BasicAuth struct {
 }
 
 type AuthAPIOpts struct {
-	GuestAccessAllowed bool
+	GuestAccessAllowed  bool
+	LockedAccessAllowed bool
 }
 
 // AuthAPIOption is an option to MakeAuthAPI to add additional checks (e.g. guest access) to verify
@@ -57,6 +58,13 @@ func WithAllowGuests() AuthAPIOption {
 	}
 }
 
+// WithAllowLocked allows users with a locked account to access this endpoint, e.g. to log out.
+func WithAllowLocked() AuthAPIOption {
+	return func(opts *AuthAPIOpts) {
+		opts.LockedAccessAllowed = true
+	}
+}
+
 // MakeAuthAPI turns a util.JSONRequestHandler function into an http.Handler which authenticates the request.
 func MakeAuthAPI(
 	metricsName string, userAPI userapi.QueryAcccessTokenAPI,
@@ -107,6 +115,12 @@ func MakeAuthAPI(
 			}
 		}
 
+		if !opts.LockedAccessAllowed {
+			if lockedRes := auth.VerifyAccountNotLocked(device); lockedRes != nil {
+				return *lockedRes
+			}
+		}
+
 		jsonRes := f(req, device)
 		// do not log 4xx as errors as they are client fails, not server fails
 		if hub != nil && jsonRes.Code >= 500 {
@@ -0,0 +1,106 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const (
+	// How far in the past the timestamp of remote multiroom data may be.
+	multiRoomMaxAge = time.Hour
+	// How far in the future the timestamp of remote multiroom data may be.
+	multiRoomMaxClockSkew = 5 * time.Minute
+)
+
+var (
+	ErrMultiRoomUnknownType = errors.New("unknown multiroom data type")
+	ErrMultiRoomTooLarge    = errors.New("multiroom payload is too large")
+	ErrMultiRoomBadJSON     = errors.New("invalid multiroom payload")
+)
+
+// MultiRoomValidator checks multiroom payloads against the data type settings of
+// the sync API, for payloads sent by local clients as well as by remote servers.
+type MultiRoomValidator struct {
+	cfg     *config.MultiRoom
+	schemas map[string]*jsonschema.Schema
+}
+
+func NewMultiRoomValidator(cfg *config.MultiRoom) (*MultiRoomValidator, error) {
+	v := &MultiRoomValidator{
+		cfg:     cfg,
+		schemas: make(map[string]*jsonschema.Schema),
+	}
+	for i := range cfg.DataTypes {
+		dataType := &cfg.DataTypes[i]
+		if dataType.Schema == "" {
+			continue
+		}
+		schema, err := dataType.CompileSchema()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile schema for multiroom data type %q: %w", dataType.Type, err)
+		}
+		v.schemas[dataType.Type] = schema
+	}
+	return v, nil
+}
+
+// CheckType returns ErrMultiRoomUnknownType if the data type isn't accepted.
+func (v *MultiRoomValidator) CheckType(dataType string) error {
+	if v.cfg.Strict && v.cfg.DataType(dataType) == nil {
+		return fmt.Errorf("%w: %q", ErrMultiRoomUnknownType, dataType)
+	}
+	return nil
+}
+
+// MaxSize returns the maximum size of a payload of the data type, zero if unlimited.
+func (v *MultiRoomValidator) MaxSize(dataType string) int64 {
+	return v.cfg.MaxSizeFor(dataType)
+}
+
+// Validate checks the data type, size and schema of the payload. Returns the
+// canonical payload if it is accepted.
+func (v *MultiRoomValidator) Validate(dataType string, payload []byte) ([]byte, error) {
+	if err := v.CheckType(dataType); err != nil {
+		return nil, err
+	}
+	if maxSize := v.MaxSize(dataType); maxSize > 0 && int64(len(payload)) > maxSize {
+		return nil, fmt.Errorf("%w: the maximum allowed size is %d bytes", ErrMultiRoomTooLarge, maxSize)
+	}
+	canonical, err := gomatrixserverlib.CanonicalJSON(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not valid canonical JSON: %s", ErrMultiRoomBadJSON, err)
+	}
+	if schema, ok := v.schemas[dataType]; ok {
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(canonical))
+		decoder.UseNumber()
+		if err = decoder.Decode(&value); err == nil {
+			err = schema.Validate(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: does not match the schema of %q: %s", ErrMultiRoomBadJSON, dataType, err)
+		}
+	}
+	return canonical, nil
+}
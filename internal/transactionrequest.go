@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/matrix-org/dendrite/federationapi/producers"
@@ -64,6 +65,7 @@ type TxnReq struct {
 	roomsMu                *MutexByRoom
 	producer               *producers.SyncAPIProducer
 	inboundPresenceEnabled bool
+	multiRoom              *MultiRoomValidator
 }
 
 func NewTxnReq(
@@ -74,6 +76,7 @@ func NewTxnReq(
 	roomsMu *MutexByRoom,
 	producer *producers.SyncAPIProducer,
 	inboundPresenceEnabled bool,
+	multiRoom *MultiRoomValidator,
 	pdus []json.RawMessage,
 	edus []gomatrixserverlib.EDU,
 	origin spec.ServerName,
@@ -88,6 +91,7 @@ func NewTxnReq(
 		roomsMu:                roomsMu,
 		producer:               producer,
 		inboundPresenceEnabled: inboundPresenceEnabled,
+		multiRoom:              multiRoom,
 	}
 
 	t.PDUs = pdus
@@ -286,7 +290,7 @@ func (t *TxnReq) processEDUs(ctx context.Context) {
 						util.GetLogger(ctx).Debugf("Dropping receipt event where sender domain (%q) doesn't match origin (%q)", domain, t.Origin)
 						continue
 					}
-					if err := t.processReceiptEvent(ctx, userID, roomID, "m.read", mread.Data.TS, mread.EventIDs); err != nil {
+					if err := t.processReceiptEvent(ctx, userID, roomID, "m.read", mread.Data.ThreadID, mread.Data.TS, mread.EventIDs); err != nil {
 						util.GetLogger(ctx).WithError(err).WithFields(logrus.Fields{
 							"sender":  t.Origin,
 							"user_id": userID,
@@ -308,6 +312,10 @@ func (t *TxnReq) processEDUs(ctx context.Context) {
 					logrus.WithError(err).Errorf("Failed to process presence update")
 				}
 			}
+		case types.MMultiRoom:
+			if err := t.processMultiRoom(ctx, e); err != nil {
+				logrus.WithError(err).Errorf("Failed to process multiroom data")
+			}
 		default:
 			util.GetLogger(ctx).WithField("type", e.Type).Debug("Unhandled EDU")
 		}
@@ -339,9 +347,65 @@ func (t *TxnReq) processPresence(ctx context.Context, e gomatrixserverlib.EDU) e
 	return nil
 }
 
+// processMultiRoom handles connect.multiroom events. The data is only accepted if it is
+// valid for its data type, the user belongs to the origin and is joined to at least one
+// of the rooms it was sent for.
+func (t *TxnReq) processMultiRoom(ctx context.Context, e gomatrixserverlib.EDU) error {
+	var payload types.MultiRoomEDU
+	if err := json.Unmarshal(e.Content, &payload); err != nil {
+		return err
+	}
+	userID, err := spec.NewUserID(payload.UserID, true)
+	if err != nil {
+		return nil
+	} else if userID.Domain() == t.ourServerName {
+		return nil
+	} else if userID.Domain() != t.Origin {
+		return nil
+	}
+	if payload.Type == "" || len(payload.RoomIDs) == 0 {
+		return nil
+	}
+	content, err := t.multiRoom.Validate(payload.Type, payload.Content)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).WithField("user_id", payload.UserID).Debug("Dropping invalid multiroom data")
+		return nil
+	}
+	joinedRooms, err := t.rsAPI.QueryRoomsForUser(ctx, *userID, spec.Join)
+	if err != nil {
+		return err
+	}
+	joined := false
+	for _, roomID := range joinedRooms {
+		for _, sharedRoomID := range payload.RoomIDs {
+			if roomID.String() == sharedRoomID {
+				joined = true
+				break
+			}
+		}
+	}
+	if !joined {
+		util.GetLogger(ctx).WithField("user_id", payload.UserID).Debug("Dropping multiroom data for user not joined to any of the rooms")
+		return nil
+	}
+	return t.producer.SendMultiRoom(ctx, payload.UserID, payload.Type, content, clampMultiRoomTimestamp(payload.OriginServerTs, time.Now()))
+}
+
+// clampMultiRoomTimestamp keeps the timestamp of remote multiroom data within a range
+// around our own clock, so that remote servers can't reorder or expire the history.
+func clampMultiRoomTimestamp(ts spec.Timestamp, now time.Time) spec.Timestamp {
+	if earliest := spec.AsTimestamp(now.Add(-multiRoomMaxAge)); ts < earliest {
+		return earliest
+	}
+	if latest := spec.AsTimestamp(now.Add(multiRoomMaxClockSkew)); ts > latest {
+		return latest
+	}
+	return ts
+}
+
 // processReceiptEvent sends receipt events to JetStream
 func (t *TxnReq) processReceiptEvent(ctx context.Context,
-	userID, roomID, receiptType string,
+	userID, roomID, receiptType, threadID string,
 	timestamp spec.Timestamp,
 	eventIDs []string,
 ) error {
@@ -354,7 +418,7 @@ func (t *TxnReq) processReceiptEvent(ctx context.Context,
 	}
 	// store every event
 	for _, eventID := range eventIDs {
-		if err := t.producer.SendReceipt(ctx, userID, roomID, eventID, receiptType, timestamp); err != nil {
+		if err := t.producer.SendReceipt(ctx, userID, roomID, eventID, receiptType, threadID, timestamp); err != nil {
 			return fmt.Errorf("unable to set receipt event: %w", err)
 		}
 	}
@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -97,6 +98,14 @@ func (r *FakeRsAPI) QueryServerBannedFromRoom(
 	return nil
 }
 
+func (r *FakeRsAPI) QueryRoomsForUser(ctx context.Context, userID spec.UserID, desiredMembership string) ([]spec.RoomID, error) {
+	roomID, err := spec.NewRoomID("!roomid:kaer.morhen")
+	if err != nil {
+		return nil, err
+	}
+	return []spec.RoomID{*roomID}, nil
+}
+
 func (r *FakeRsAPI) InputRoomEvents(
 	ctx context.Context,
 	req *rsAPI.InputRoomEventsRequest,
@@ -105,7 +114,7 @@ func (r *FakeRsAPI) InputRoomEvents(
 }
 
 func TestEmptyTransactionRequest(t *testing.T) {
-	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", nil, nil, nil, false, []json.RawMessage{}, []gomatrixserverlib.EDU{}, "", "", "")
+	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", nil, nil, nil, false, nil, []json.RawMessage{}, []gomatrixserverlib.EDU{}, "", "", "")
 	txnRes, jsonRes := txn.ProcessTransaction(context.Background())
 
 	assert.Nil(t, jsonRes)
@@ -114,7 +123,7 @@ func TestEmptyTransactionRequest(t *testing.T) {
 
 func TestProcessTransactionRequestPDU(t *testing.T) {
 	keyRing := &test.NopJSONVerifier{}
-	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", keyRing, nil, nil, false, []json.RawMessage{testEvent}, []gomatrixserverlib.EDU{}, "", "", "")
+	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", keyRing, nil, nil, false, nil, []json.RawMessage{testEvent}, []gomatrixserverlib.EDU{}, "", "", "")
 	txnRes, jsonRes := txn.ProcessTransaction(context.Background())
 
 	assert.Nil(t, jsonRes)
@@ -126,7 +135,7 @@ func TestProcessTransactionRequestPDU(t *testing.T) {
 
 func TestProcessTransactionRequestPDUs(t *testing.T) {
 	keyRing := &test.NopJSONVerifier{}
-	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", keyRing, nil, nil, false, append(testData, testEvent), []gomatrixserverlib.EDU{}, "", "", "")
+	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", keyRing, nil, nil, false, nil, append(testData, testEvent), []gomatrixserverlib.EDU{}, "", "", "")
 	txnRes, jsonRes := txn.ProcessTransaction(context.Background())
 
 	assert.Nil(t, jsonRes)
@@ -140,7 +149,7 @@ func TestProcessTransactionRequestBadPDU(t *testing.T) {
 	pdu := json.RawMessage("{\"room_id\":\"asdf\"}")
 	pdu2 := json.RawMessage("\"roomid\":\"asdf\"")
 	keyRing := &test.NopJSONVerifier{}
-	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", keyRing, nil, nil, false, []json.RawMessage{pdu, pdu2, testEvent}, []gomatrixserverlib.EDU{}, "", "", "")
+	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", keyRing, nil, nil, false, nil, []json.RawMessage{pdu, pdu2, testEvent}, []gomatrixserverlib.EDU{}, "", "", "")
 	txnRes, jsonRes := txn.ProcessTransaction(context.Background())
 
 	assert.Nil(t, jsonRes)
@@ -152,7 +161,7 @@ func TestProcessTransactionRequestBadPDU(t *testing.T) {
 
 func TestProcessTransactionRequestPDUQueryFailure(t *testing.T) {
 	keyRing := &test.NopJSONVerifier{}
-	txn := NewTxnReq(&FakeRsAPI{shouldFailQuery: true}, nil, "ourserver", keyRing, nil, nil, false, []json.RawMessage{testEvent}, []gomatrixserverlib.EDU{}, "", "", "")
+	txn := NewTxnReq(&FakeRsAPI{shouldFailQuery: true}, nil, "ourserver", keyRing, nil, nil, false, nil, []json.RawMessage{testEvent}, []gomatrixserverlib.EDU{}, "", "", "")
 	txnRes, jsonRes := txn.ProcessTransaction(context.Background())
 
 	assert.Nil(t, jsonRes)
@@ -161,7 +170,7 @@ func TestProcessTransactionRequestPDUQueryFailure(t *testing.T) {
 
 func TestProcessTransactionRequestPDUBannedFromRoom(t *testing.T) {
 	keyRing := &test.NopJSONVerifier{}
-	txn := NewTxnReq(&FakeRsAPI{bannedFromRoom: true}, nil, "ourserver", keyRing, nil, nil, false, []json.RawMessage{testEvent}, []gomatrixserverlib.EDU{}, "", "", "")
+	txn := NewTxnReq(&FakeRsAPI{bannedFromRoom: true}, nil, "ourserver", keyRing, nil, nil, false, nil, []json.RawMessage{testEvent}, []gomatrixserverlib.EDU{}, "", "", "")
 	txnRes, jsonRes := txn.ProcessTransaction(context.Background())
 
 	assert.Nil(t, jsonRes)
@@ -173,7 +182,7 @@ func TestProcessTransactionRequestPDUBannedFromRoom(t *testing.T) {
 
 func TestProcessTransactionRequestPDUInvalidSignature(t *testing.T) {
 	keyRing := &test.NopJSONVerifier{}
-	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", keyRing, nil, nil, false, []json.RawMessage{invalidSignatures}, []gomatrixserverlib.EDU{}, "", "", "")
+	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", keyRing, nil, nil, false, nil, []json.RawMessage{invalidSignatures}, []gomatrixserverlib.EDU{}, "", "", "")
 	txnRes, jsonRes := txn.ProcessTransaction(context.Background())
 
 	assert.Nil(t, jsonRes)
@@ -200,11 +209,13 @@ func createTransactionWithEDU(ctx *process.ProcessContext, edus []gomatrixserver
 		TopicPresenceEvent:     cfg.Global.JetStream.Prefixed(jetstream.OutputPresenceEvent),
 		TopicDeviceListUpdate:  cfg.Global.JetStream.Prefixed(jetstream.InputDeviceListUpdate),
 		TopicSigningKeyUpdate:  cfg.Global.JetStream.Prefixed(jetstream.InputSigningKeyUpdate),
+		TopicMultiRoomCast:     cfg.Global.JetStream.Prefixed(jetstream.OutputMultiRoomCast),
 		Config:                 &cfg.FederationAPI,
 		UserAPI:                nil,
 	}
 	keyRing := &test.NopJSONVerifier{}
-	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", keyRing, nil, producer, true, []json.RawMessage{}, edus, "kaer.morhen", "", "ourserver")
+	multiRoom, _ := NewMultiRoomValidator(&cfg.SyncAPI.MultiRoom)
+	txn := NewTxnReq(&FakeRsAPI{}, nil, "ourserver", keyRing, nil, producer, true, multiRoom, []json.RawMessage{}, edus, "kaer.morhen", "", "ourserver")
 	return txn, js, cfg
 }
 
@@ -601,6 +612,88 @@ func TestProcessTransactionRequestEDUPresence(t *testing.T) {
 	poll.WaitOn(t, check, poll.WithTimeout(2*time.Second), poll.WithDelay(10*time.Millisecond))
 }
 
+func TestProcessTransactionRequestEDUMultiRoom(t *testing.T) {
+	var err error
+	userID := "@john:kaer.morhen"
+	dataType := "m.location"
+	content := json.RawMessage(`{"lat":1.5,"lon":2.5}`)
+	edu := gomatrixserverlib.EDU{Type: "connect.multiroom"}
+	if edu.Content, err = json.Marshal(map[string]interface{}{
+		"user_id":          userID,
+		"type":             dataType,
+		"room_ids":         []string{"!roomid:kaer.morhen"},
+		"content":          content,
+		"origin_server_ts": 5000,
+	}); err != nil {
+		t.Errorf("failed to marshal EDU JSON")
+	}
+	notJoinedEDU := gomatrixserverlib.EDU{Type: "connect.multiroom"}
+	if notJoinedEDU.Content, err = json.Marshal(map[string]interface{}{
+		"user_id":  userID,
+		"type":     "m.not_joined",
+		"room_ids": []string{"!otherroom:kaer.morhen"},
+		"content":  content,
+	}); err != nil {
+		t.Errorf("failed to marshal EDU JSON")
+	}
+	wrongOriginEDU := gomatrixserverlib.EDU{Type: "connect.multiroom"}
+	if wrongOriginEDU.Content, err = json.Marshal(map[string]interface{}{
+		"user_id":  "@john:white.orchard",
+		"type":     "m.wrong_origin",
+		"room_ids": []string{"!roomid:kaer.morhen"},
+		"content":  content,
+	}); err != nil {
+		t.Errorf("failed to marshal EDU JSON")
+	}
+	tooLargeEDU := gomatrixserverlib.EDU{Type: "connect.multiroom"}
+	if tooLargeEDU.Content, err = json.Marshal(map[string]interface{}{
+		"user_id":  userID,
+		"type":     "m.too_large",
+		"room_ids": []string{"!roomid:kaer.morhen"},
+		"content":  map[string]string{"data": strings.Repeat("a", 64*1024)},
+	}); err != nil {
+		t.Errorf("failed to marshal EDU JSON")
+	}
+	edus := []gomatrixserverlib.EDU{notJoinedEDU, wrongOriginEDU, tooLargeEDU, edu}
+
+	ctx := process.NewProcessContext()
+	defer ctx.ShutdownDendrite()
+	txn, js, cfg := createTransactionWithEDU(ctx, edus)
+	received := atomic.NewBool(false)
+	onMessage := func(ctx context.Context, msgs []*nats.Msg) bool {
+		msg := msgs[0] // Guaranteed to exist if onMessage is called
+
+		assert.Equal(t, userID, msg.Header.Get(jetstream.UserID))
+		assert.Equal(t, dataType, msg.Header.Get("type"))
+		assert.JSONEq(t, string(content), string(msg.Data))
+		// The timestamp of the origin is clamped to the last hour.
+		ts, err := strconv.ParseInt(msg.Header.Get("timestamp"), 10, 64)
+		assert.Nil(t, err)
+		assert.InDelta(t, time.Now().Add(-time.Hour).UnixMilli(), ts, float64(time.Minute.Milliseconds()))
+
+		received.Store(true)
+		return true
+	}
+	err = jetstream.JetStreamConsumer(
+		ctx.Context(), js, cfg.Global.JetStream.Prefixed(jetstream.OutputMultiRoomCast),
+		cfg.Global.JetStream.Durable("TestMultiRoom"), 1,
+		onMessage, nats.DeliverAll(), nats.ManualAck(),
+	)
+	assert.Nil(t, err)
+
+	txnRes, jsonRes := txn.ProcessTransaction(ctx.Context())
+	assert.Nil(t, jsonRes)
+	assert.Zero(t, len(txnRes.PDUs))
+
+	check := func(log poll.LogT) poll.Result {
+		if received.Load() {
+			return poll.Success()
+		}
+		return poll.Continue("waiting for events to be processed")
+	}
+	poll.WaitOn(t, check, poll.WithTimeout(2*time.Second), poll.WithDelay(10*time.Millisecond))
+}
+
 func TestProcessTransactionRequestEDUUnhandled(t *testing.T) {
 	var err error
 	edu := gomatrixserverlib.EDU{Type: "m.unhandled"}
@@ -732,6 +825,7 @@ func mustCreateTransaction(rsAPI rsAPI.FederationRoomserverAPI, pdus []json.RawM
 		NewMutexByRoom(),
 		nil,
 		false,
+		nil,
 		pdus,
 		nil,
 		testOrigin,
@@ -807,3 +901,19 @@ func TestTransactionFailAuthChecks(t *testing.T) {
 	// expect message to be sent to the roomserver
 	assertInputRoomEvents(t, rsAPI.inputRoomEvents, []*rstypes.HeaderedEvent{testEvents[len(testEvents)-1]})
 }
+
+func TestClampMultiRoomTimestamp(t *testing.T) {
+	now := time.Now()
+	for name, tc := range map[string]struct {
+		ts   time.Time
+		want time.Time
+	}{
+		"recent":    {ts: now.Add(-time.Minute), want: now.Add(-time.Minute)},
+		"too old":   {ts: now.Add(-24 * time.Hour), want: now.Add(-multiRoomMaxAge)},
+		"in future": {ts: now.Add(time.Hour), want: now.Add(multiRoomMaxClockSkew)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, spec.AsTimestamp(tc.want), clampMultiRoomTimestamp(spec.AsTimestamp(tc.ts), now))
+		})
+	}
+}
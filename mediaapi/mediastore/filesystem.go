@@ -0,0 +1,182 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediastore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// tmpDirName is the directory under the base path which temporary files are
+// written to before they are stored, see fileutils.WriteTempFile.
+const tmpDirName = "tmp"
+
+// filesystemStore stores media files under a local directory.
+type filesystemStore struct {
+	absBasePath string
+}
+
+// NewFilesystemStore returns a store which keeps media files under the given absolute path.
+func NewFilesystemStore(absBasePath config.Path) MediaStore {
+	return &filesystemStore{absBasePath: string(absBasePath)}
+}
+
+// path returns the absolute path of the file with the key.
+func (s *filesystemStore) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	filePath, err := filepath.Abs(filepath.Join(s.absBasePath, filepath.FromSlash(key)))
+	if err != nil {
+		return "", fmt.Errorf("unable to construct file path: %w", err)
+	}
+	// check if the absolute base path is a prefix of the absolute file path
+	// if so, no directory escape has occurred and the file path is valid
+	if !strings.HasPrefix(filePath, s.absBasePath) {
+		return "", fmt.Errorf("invalid file path (not within base path %v): %v", s.absBasePath, filePath)
+	}
+	return filePath, nil
+}
+
+func (s *filesystemStore) Put(ctx context.Context, key string, src io.Reader, size int64) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(filePath), 0770); err != nil {
+		return fmt.Errorf("failed to make directory: %w", err)
+	}
+	// Write to a temporary file first, so that readers never see a partially written file.
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name()) // nolint: errcheck
+	written, err := io.Copy(tmpFile, src)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("wrote %d bytes, expected %d", written, size)
+	}
+	if err = os.Rename(tmpFile.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	return nil
+}
+
+func (s *filesystemStore) moveFile(ctx context.Context, key, srcPath string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(filePath), 0770); err != nil {
+		return fmt.Errorf("failed to make directory: %w", err)
+	}
+	if err = os.Rename(srcPath, filePath); err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	return nil
+}
+
+func (s *filesystemStore) Open(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close() // nolint: errcheck
+		return nil, 0, err
+	}
+	return file, stat.Size(), nil
+}
+
+func (s *filesystemStore) Stat(ctx context.Context, key string) (int64, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// Delete removes the file, along with any directories which are left empty.
+func (s *filesystemStore) Delete(ctx context.Context, key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(filePath); dir != s.absBasePath && strings.HasPrefix(dir, s.absBasePath); dir = filepath.Dir(dir) {
+		// Removing a directory which isn't empty fails, which is what we want.
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *filesystemStore) Walk(ctx context.Context, fn func(key string, size int64) error) error {
+	return filepath.WalkDir(s.absBasePath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == s.absBasePath {
+				return nil
+			}
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.absBasePath, filePath)
+		if err != nil {
+			return err
+		}
+		// Skip temporary files, which aren't part of the store.
+		if rel == tmpDirName || strings.HasPrefix(entry.Name(), ".tmp-") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), info.Size())
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mediastore contains the backends which media files are stored in.
+package mediastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// MediaStore stores the files of the media repository. Files are identified by
+// their key, a slash-separated path relative to the root of the store.
+type MediaStore interface {
+	// Put stores the contents of src under the key, replacing any existing file.
+	Put(ctx context.Context, key string, src io.Reader, size int64) error
+	// Open returns the contents of the file with the key, along with its size.
+	// Returns an error matching fs.ErrNotExist if there is no such file.
+	Open(ctx context.Context, key string) (io.ReadCloser, int64, error)
+	// Stat returns the size of the file with the key.
+	// Returns an error matching fs.ErrNotExist if there is no such file.
+	Stat(ctx context.Context, key string) (int64, error)
+	// Delete removes the file with the key. Removing a file which doesn't exist isn't an error.
+	Delete(ctx context.Context, key string) error
+	// Walk calls fn for every file in the store.
+	Walk(ctx context.Context, fn func(key string, size int64) error) error
+}
+
+// fileMover is implemented by stores which can take ownership of a local file
+// more efficiently than by copying it.
+type fileMover interface {
+	moveFile(ctx context.Context, key, srcPath string) error
+}
+
+// NewMediaStore returns the media store configured by the given backend.
+func NewMediaStore(cfg *config.MediaAPI, backend string) (MediaStore, error) {
+	switch backend {
+	case config.MediaStorageBackendFilesystem:
+		return NewFilesystemStore(cfg.AbsBasePath), nil
+	case config.MediaStorageBackendS3:
+		return NewS3Store(&cfg.Storage.S3)
+	default:
+		return nil, fmt.Errorf("unknown media storage backend %q", backend)
+	}
+}
+
+// MediaKey returns the key of a media file from its Base64Hash.
+// 3 directories are used for more manageable browsing and the remainder is used as the directory name.
+// For example, if Base64Hash is 'qwerty', the key will be 'q/w/erty/file'.
+func MediaKey(base64Hash types.Base64Hash) (string, error) {
+	if len(base64Hash) < 3 {
+		return "", fmt.Errorf("invalid key (Base64Hash too short - min 3 characters): %q", base64Hash)
+	}
+	if len(base64Hash) > 255 {
+		return "", fmt.Errorf("invalid key (Base64Hash too long - max 255 characters): %q", base64Hash)
+	}
+	key := path.Join(
+		string(base64Hash[0:1]),
+		string(base64Hash[1:2]),
+		string(base64Hash[2:]),
+		"file",
+	)
+	// Base64Hash is URL-safe base64, so this can only happen if the hash
+	// didn't come from us.
+	if !fs.ValidPath(key) || strings.Count(key, "/") != 3 {
+		return "", fmt.Errorf("invalid key (Base64Hash contains path elements): %q", base64Hash)
+	}
+	return key, nil
+}
+
+// StoreFile moves the local file at srcPath into the store under the key.
+// The local file should not be used afterwards.
+func StoreFile(ctx context.Context, store MediaStore, key, srcPath string) error {
+	if mover, ok := store.(fileMover); ok {
+		return mover.moveFile(ctx, key, srcPath)
+	}
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close() // nolint: errcheck
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key, file, stat.Size())
+}
+
+// Migrate copies every file from one store to another, skipping files which
+// already exist in the destination with the same size. If deleteSource is set,
+// the copied files are then removed from the source store.
+// Returns the number of files which were copied.
+func Migrate(ctx context.Context, from, to MediaStore, deleteSource bool, logger *log.Entry) (int, error) {
+	copied := 0
+	var migrated []string
+	err := from.Walk(ctx, func(key string, size int64) error {
+		existingSize, err := to.Stat(ctx, key)
+		switch {
+		case err == nil && existingSize == size:
+			logger.WithField("key", key).Debug("File was migrated previously")
+		case err == nil || errors.Is(err, fs.ErrNotExist):
+			if err = copyFile(ctx, from, to, key); err != nil {
+				return fmt.Errorf("failed to copy %q: %w", key, err)
+			}
+			copied++
+			logger.WithField("key", key).Debug("Migrated file")
+		default:
+			return fmt.Errorf("failed to stat %q: %w", key, err)
+		}
+		migrated = append(migrated, key)
+		return nil
+	})
+	if err != nil {
+		return copied, err
+	}
+	if deleteSource {
+		for _, key := range migrated {
+			if err = from.Delete(ctx, key); err != nil {
+				return copied, fmt.Errorf("failed to delete %q: %w", key, err)
+			}
+		}
+	}
+	return copied, nil
+}
+
+func copyFile(ctx context.Context, from, to MediaStore, key string) error {
+	src, size, err := from.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer src.Close() // nolint: errcheck
+	return to.Put(ctx, key, src, size)
+}
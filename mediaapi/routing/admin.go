@@ -0,0 +1,371 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+)
+
+type adminPurgeMediaRequest struct {
+	// Media which expired before this time is purged.
+	BeforeTS spec.Timestamp `json:"before_ts"`
+	// Whether to purge cached remote media which hasn't been accessed since before_ts.
+	Remote bool `json:"remote"`
+	// Whether to purge media uploaded to this server, according to local_media_policy.
+	Local            bool   `json:"local"`
+	LocalMediaPolicy string `json:"local_media_policy"`
+}
+
+type adminPurgeMediaResponse struct {
+	Remote *PurgeResult `json:"remote,omitempty"`
+	Local  *PurgeResult `json:"local,omitempty"`
+}
+
+// AdminPurgeMedia implements POST /_dendrite/admin/media/purge
+func AdminPurgeMedia(req *http.Request, cfg *config.MediaAPI, purger *MediaPurger) util.JSONResponse {
+	request := adminPurgeMediaRequest{
+		Remote:           true,
+		LocalMediaPolicy: cfg.Retention.LocalMediaPolicy,
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.BadJSON("Failed to decode request body: " + err.Error()),
+		}
+	}
+	if request.BeforeTS <= 0 {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.MissingParam("before_ts must be a positive timestamp"),
+		}
+	}
+	if request.LocalMediaPolicy != config.LocalMediaPolicyAge && request.LocalMediaPolicy != config.LocalMediaPolicyLastAccess {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("local_media_policy must be one of age or last_access"),
+		}
+	}
+
+	logger := util.GetLogger(req.Context()).WithField("before_ts", request.BeforeTS)
+	var res adminPurgeMediaResponse
+	if request.Remote {
+		purged, err := purger.PurgeRemoteMedia(req.Context(), request.BeforeTS)
+		if err != nil {
+			logger.WithError(err).Error("Failed to purge remote media")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		logger.WithField("media", purged.DeletedMedia).Warn("Purged remote media")
+		res.Remote = &purged
+	}
+	if request.Local {
+		purged, err := purger.PurgeLocalMedia(req.Context(), request.BeforeTS, request.LocalMediaPolicy)
+		if err != nil {
+			logger.WithError(err).Error("Failed to purge local media")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		logger.WithField("media", purged.DeletedMedia).Warn("Purged local media")
+		res.Local = &purged
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+type adminMedia struct {
+	MediaID       types.MediaID       `json:"media_id"`
+	Origin        spec.ServerName     `json:"origin"`
+	ContentType   types.ContentType   `json:"content_type"`
+	FileSizeBytes types.FileSizeBytes `json:"size"`
+	UploadName    types.Filename      `json:"upload_name,omitempty"`
+	CreationTS    spec.Timestamp      `json:"created_ts"`
+	LastAccessTS  spec.Timestamp      `json:"last_access_ts"`
+	QuarantinedBy types.MatrixUserID  `json:"quarantined_by,omitempty"`
+}
+
+// AdminListUserMedia implements GET /_dendrite/admin/users/{userID}/media
+func AdminListUserMedia(req *http.Request, db storage.Database, userID string) util.JSONResponse {
+	if _, err := spec.NewUserID(userID, true); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid user ID"),
+		}
+	}
+	from, limit, resErr := parsePagination(req)
+	if resErr != nil {
+		return *resErr
+	}
+
+	uploads, total, err := db.GetMediaByUser(req.Context(), types.MatrixUserID(userID), from, limit)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.GetMediaByUser failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	res := struct {
+		Media     []adminMedia `json:"media"`
+		Total     int          `json:"total"`
+		NextToken *int         `json:"next_token,omitempty"`
+	}{
+		Media: make([]adminMedia, 0, len(uploads)),
+		Total: total,
+	}
+	for _, m := range uploads {
+		res.Media = append(res.Media, adminMedia{
+			MediaID:       m.MediaID,
+			Origin:        m.Origin,
+			ContentType:   m.ContentType,
+			FileSizeBytes: m.FileSizeBytes,
+			UploadName:    m.UploadName,
+			CreationTS:    m.CreationTimestamp,
+			LastAccessTS:  m.LastAccessTimestamp,
+			QuarantinedBy: m.QuarantinedBy,
+		})
+	}
+	if next := from + len(uploads); next < total {
+		res.NextToken = &next
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// parsePagination returns the from and limit query parameters of a paginated admin endpoint.
+func parsePagination(req *http.Request) (from, limit int, resErr *util.JSONResponse) {
+	query := req.URL.Query()
+	from, limit = 0, 100
+	var err error
+	if value := query.Get("from"); value != "" {
+		if from, err = strconv.Atoi(value); err != nil || from < 0 {
+			return 0, 0, &util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("from must be a non-negative integer"),
+			}
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			return 0, 0, &util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("limit must be a positive integer"),
+			}
+		}
+	}
+	return from, limit, nil
+}
+
+type adminMediaUsage struct {
+	UserID     types.MatrixUserID  `json:"user_id"`
+	UsedBytes  types.FileSizeBytes `json:"used_bytes"`
+	QuotaBytes types.FileSizeBytes `json:"quota_bytes"`
+	// Whether the quota was set for the user, rather than being the default quota.
+	CustomQuota bool `json:"custom_quota"`
+}
+
+func newAdminMediaUsage(cfg *config.MediaAPI, usage *types.MediaUsage) adminMediaUsage {
+	return adminMediaUsage{
+		UserID:      usage.UserID,
+		UsedBytes:   usage.UsedBytes,
+		QuotaBytes:  usage.Quota(types.FileSizeBytes(cfg.DefaultUserQuotaBytes)),
+		CustomQuota: usage.QuotaBytes != nil,
+	}
+}
+
+// AdminListMediaUsage implements GET /_dendrite/admin/media/usage
+func AdminListMediaUsage(req *http.Request, cfg *config.MediaAPI, db storage.Database) util.JSONResponse {
+	from, limit, resErr := parsePagination(req)
+	if resErr != nil {
+		return *resErr
+	}
+
+	usages, total, err := db.GetLargestMediaUsage(req.Context(), from, limit)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.GetLargestMediaUsage failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	res := struct {
+		Users     []adminMediaUsage `json:"users"`
+		Total     int               `json:"total"`
+		NextToken *int              `json:"next_token,omitempty"`
+	}{
+		Users: make([]adminMediaUsage, 0, len(usages)),
+		Total: total,
+	}
+	for _, usage := range usages {
+		res.Users = append(res.Users, newAdminMediaUsage(cfg, usage))
+	}
+	if next := from + len(usages); next < total {
+		res.NextToken = &next
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// AdminGetUserMediaQuota implements GET /_dendrite/admin/users/{userID}/media/quota
+func AdminGetUserMediaQuota(req *http.Request, cfg *config.MediaAPI, db storage.Database, userID string) util.JSONResponse {
+	if _, err := spec.NewUserID(userID, true); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid user ID"),
+		}
+	}
+	return mediaUsageResponse(req, cfg, db, types.MatrixUserID(userID))
+}
+
+// AdminSetUserMediaQuota implements PUT and DELETE /_dendrite/admin/users/{userID}/media/quota
+// PUT sets the quota of the user, while DELETE resets it to the default quota.
+func AdminSetUserMediaQuota(req *http.Request, cfg *config.MediaAPI, db storage.Database, userID string) util.JSONResponse {
+	parsedUserID, err := spec.NewUserID(userID, true)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid user ID"),
+		}
+	}
+	if !cfg.Matrix.IsLocalServerName(parsedUserID.Domain()) {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("user must be local to this server"),
+		}
+	}
+
+	var quota *types.FileSizeBytes
+	if req.Method == http.MethodPut {
+		var request struct {
+			QuotaBytes *types.FileSizeBytes `json:"quota_bytes"`
+		}
+		if err = json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.BadJSON("Failed to decode request body: " + err.Error()),
+			}
+		}
+		if request.QuotaBytes == nil || *request.QuotaBytes < 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("quota_bytes must be a non-negative integer"),
+			}
+		}
+		quota = request.QuotaBytes
+	}
+
+	if err = db.SetMediaQuota(req.Context(), types.MatrixUserID(userID), quota); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.SetMediaQuota failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return mediaUsageResponse(req, cfg, db, types.MatrixUserID(userID))
+}
+
+func mediaUsageResponse(req *http.Request, cfg *config.MediaAPI, db storage.Database, userID types.MatrixUserID) util.JSONResponse {
+	usage, err := db.GetMediaUsage(req.Context(), userID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("db.GetMediaUsage failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: newAdminMediaUsage(cfg, usage),
+	}
+}
+
+// AdminQuarantineMedia implements POST /_dendrite/admin/media/{serverName}/{mediaID}/quarantine
+func AdminQuarantineMedia(req *http.Request, device *userapi.Device, purger *MediaPurger, serverName, mediaID string) util.JSONResponse {
+	if serverName == "" || !mediaIDRegex.MatchString(mediaID) {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid media ID"),
+		}
+	}
+	res, err := purger.QuarantineMedia(req.Context(), types.MediaID(mediaID), spec.ServerName(serverName), types.MatrixUserID(device.UserID))
+	return quarantineResponse(req, res, err)
+}
+
+// AdminQuarantineUserMedia implements POST /_dendrite/admin/users/{userID}/media/quarantine
+func AdminQuarantineUserMedia(req *http.Request, device *userapi.Device, purger *MediaPurger, userID string) util.JSONResponse {
+	if _, err := spec.NewUserID(userID, true); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid user ID"),
+		}
+	}
+	res, err := purger.QuarantineUserMedia(req.Context(), types.MatrixUserID(userID), types.MatrixUserID(device.UserID))
+	return quarantineResponse(req, res, err)
+}
+
+// AdminQuarantineRoomMedia implements POST /_dendrite/admin/rooms/{roomID}/media/quarantine
+func AdminQuarantineRoomMedia(
+	req *http.Request, device *userapi.Device, rsAPI roomserverAPI.MediaRoomserverAPI, purger *MediaPurger, roomID string,
+) util.JSONResponse {
+	if _, err := spec.NewRoomID(roomID); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid room ID"),
+		}
+	}
+	uris, err := rsAPI.QueryAdminRoomMediaURIs(req.Context(), roomID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryAdminRoomMediaURIs failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	res, err := purger.QuarantineMediaURIs(req.Context(), uris, types.MatrixUserID(device.UserID))
+	return quarantineResponse(req, res, err)
+}
+
+func quarantineResponse(req *http.Request, res QuarantineResult, err error) util.JSONResponse {
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("Failed to quarantine media")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
@@ -23,15 +23,15 @@ import (
 	"mime"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 
 	"github.com/matrix-org/dendrite/mediaapi/fileutils"
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/thumbnailer"
 	"github.com/matrix-org/dendrite/mediaapi/types"
@@ -45,6 +45,10 @@ import (
 
 const mediaIDCharacters = "A-Za-z0-9_=-"
 
+// lastAccessGranularity is how outdated the last access time of media may be,
+// to avoid writing to the database on every download.
+const lastAccessGranularity = time.Hour
+
 // Note: unfortunately regex.MustCompile() cannot be assigned to a const
 var mediaIDRegex = regexp.MustCompile("^[" + mediaIDCharacters + "]+$")
 
@@ -61,6 +65,8 @@ type downloadRequest struct {
 	ThumbnailSize      types.ThumbnailSize
 	Logger             *log.Entry
 	DownloadFilename   string
+	// Whether the request was made using the authenticated media endpoints (MSC3916).
+	Authenticated bool
 }
 
 // Taken from: https://github.com/matrix-org/synapse/blob/c3627d0f99ed5a23479305dc2bd0e71ca25ce2b1/synapse/media/_base.py#L53C1-L84
@@ -110,10 +116,12 @@ func Download(
 	mediaID types.MediaID,
 	cfg *config.MediaAPI,
 	db storage.Database,
+	store mediastore.MediaStore,
 	client *fclient.Client,
 	activeRemoteRequests *types.ActiveRemoteRequests,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
 	isThumbnailRequest bool,
+	authenticated bool,
 	customFilename string,
 ) {
 	dReq := &downloadRequest{
@@ -127,6 +135,7 @@ func Download(
 			"MediaID": mediaID,
 		}),
 		DownloadFilename: customFilename,
+		Authenticated:    authenticated,
 	}
 
 	if dReq.IsThumbnailRequest {
@@ -157,14 +166,14 @@ func Download(
 	}
 
 	metadata, err := dReq.doDownload(
-		req.Context(), w, cfg, db, client,
+		req.Context(), w, cfg, db, store, client,
 		activeRemoteRequests, activeThumbnailGeneration,
 	)
 	if err != nil {
 		// If we bubbled up a os.PathError, e.g. no such file or directory, don't send
 		// it to the client, be more generic.
 		var perr *fs.PathError
-		if errors.As(err, &perr) {
+		if errors.As(err, &perr) || errors.Is(err, fs.ErrNotExist) {
 			dReq.Logger.WithError(err).Error("failed to open file")
 			dReq.jsonErrorResponse(w, util.JSONResponse{
 				Code: http.StatusNotFound,
@@ -251,6 +260,7 @@ func (r *downloadRequest) doDownload(
 	w http.ResponseWriter,
 	cfg *config.MediaAPI,
 	db storage.Database,
+	store mediastore.MediaStore,
 	client *fclient.Client,
 	activeRemoteRequests *types.ActiveRemoteRequests,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
@@ -267,9 +277,14 @@ func (r *downloadRequest) doDownload(
 			// If we do not have a record and the origin is local, the file is not found
 			return nil, nil
 		}
+		if cfg.FreezeUnauthenticatedMedia && !r.Authenticated {
+			// Remote media which isn't cached yet would only be served on the authenticated
+			// media endpoints once fetched, so don't fetch it at all.
+			return nil, nil
+		}
 		// If we do not have a record and the origin is remote, we need to fetch it and respond with that file
 		resErr := r.getRemoteFile(
-			ctx, client, cfg, db, activeRemoteRequests, activeThumbnailGeneration,
+			ctx, client, cfg, db, store, activeRemoteRequests, activeThumbnailGeneration,
 		)
 		if resErr != nil {
 			return nil, resErr
@@ -277,53 +292,73 @@ func (r *downloadRequest) doDownload(
 	} else {
 		// If we have a record, we can respond from the local file
 		r.MediaMetadata = mediaMetadata
+		r.updateLastAccess(ctx, db)
+	}
+	if r.MediaMetadata.QuarantinedBy != "" {
+		// Quarantined media is treated as if it doesn't exist
+		r.Logger.Debug("Media is quarantined")
+		return nil, nil
+	}
+	if r.MediaMetadata.Authenticated && !r.Authenticated {
+		r.Logger.Debug("Media can only be downloaded using the authenticated media endpoints")
+		return nil, nil
 	}
-	return r.respondFromLocalFile(
-		ctx, w, cfg.AbsBasePath, activeThumbnailGeneration,
+	return r.respondFromStoredFile(
+		ctx, w, store, activeThumbnailGeneration,
 		cfg.MaxThumbnailGenerators, db,
 		cfg.DynamicThumbnails, cfg.ThumbnailSizes,
 	)
 }
 
-// respondFromLocalFile reads a file from local storage and writes it to the http.ResponseWriter
+// updateLastAccess records that the media was accessed, so that it doesn't expire.
+// The database is only updated if the last access is older than lastAccessGranularity.
+func (r *downloadRequest) updateLastAccess(ctx context.Context, db storage.Database) {
+	now := time.Now()
+	if now.Sub(r.MediaMetadata.LastAccessTimestamp.Time()) < lastAccessGranularity {
+		return
+	}
+	r.MediaMetadata.LastAccessTimestamp = spec.AsTimestamp(now)
+	err := db.UpdateMediaLastAccess(ctx, r.MediaMetadata.MediaID, r.MediaMetadata.Origin, r.MediaMetadata.LastAccessTimestamp)
+	if err != nil {
+		r.Logger.WithError(err).Warn("Failed to update the last access time of media")
+	}
+}
+
+// respondFromStoredFile reads a file from the media store and writes it to the http.ResponseWriter
 // If no file was found then returns nil, nil
-func (r *downloadRequest) respondFromLocalFile(
+func (r *downloadRequest) respondFromStoredFile(
 	ctx context.Context,
 	w http.ResponseWriter,
-	absBasePath config.Path,
+	store mediastore.MediaStore,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
 	maxThumbnailGenerators int,
 	db storage.Database,
 	dynamicThumbnails bool,
 	thumbnailSizes []config.ThumbnailSize,
 ) (*types.MediaMetadata, error) {
-	filePath, err := fileutils.GetPathFromBase64Hash(r.MediaMetadata.Base64Hash, absBasePath)
-	if err != nil {
-		return nil, fmt.Errorf("fileutils.GetPathFromBase64Hash: %w", err)
-	}
-	file, err := os.Open(filePath)
-	defer file.Close() // nolint: errcheck, staticcheck, megacheck
+	fileKey, err := mediastore.MediaKey(r.MediaMetadata.Base64Hash)
 	if err != nil {
-		return nil, fmt.Errorf("os.Open: %w", err)
+		return nil, fmt.Errorf("mediastore.MediaKey: %w", err)
 	}
-	stat, err := file.Stat()
+	file, size, err := store.Open(ctx, fileKey)
 	if err != nil {
-		return nil, fmt.Errorf("file.Stat: %w", err)
+		return nil, fmt.Errorf("store.Open: %w", err)
 	}
+	defer file.Close() // nolint: errcheck
 
-	if r.MediaMetadata.FileSizeBytes > 0 && int64(r.MediaMetadata.FileSizeBytes) != stat.Size() {
+	if r.MediaMetadata.FileSizeBytes > 0 && int64(r.MediaMetadata.FileSizeBytes) != size {
 		r.Logger.WithFields(log.Fields{
 			"fileSizeDatabase": r.MediaMetadata.FileSizeBytes,
-			"fileSizeDisk":     stat.Size(),
-		}).Warn("File size in database and on-disk differ.")
-		return nil, errors.New("file size in database and on-disk differ")
+			"fileSizeStore":    size,
+		}).Warn("File size in database and media store differ.")
+		return nil, errors.New("file size in database and media store differ")
 	}
 
-	var responseFile *os.File
+	var responseFile io.Reader
 	var responseMetadata *types.MediaMetadata
 	if r.IsThumbnailRequest {
 		thumbFile, thumbMetadata, resErr := r.getThumbnailFile(
-			ctx, types.Path(filePath), activeThumbnailGeneration, maxThumbnailGenerators,
+			ctx, store, fileKey, activeThumbnailGeneration, maxThumbnailGenerators,
 			db, dynamicThumbnails, thumbnailSizes,
 		)
 		if thumbFile != nil {
@@ -442,19 +477,20 @@ func (r *downloadRequest) addDownloadFilenameToHeaders(
 // If no thumbnail was found then returns nil, nil, nil
 func (r *downloadRequest) getThumbnailFile(
 	ctx context.Context,
-	filePath types.Path,
+	store mediastore.MediaStore,
+	fileKey string,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
 	maxThumbnailGenerators int,
 	db storage.Database,
 	dynamicThumbnails bool,
 	thumbnailSizes []config.ThumbnailSize,
-) (*os.File, *types.ThumbnailMetadata, error) {
+) (io.ReadCloser, *types.ThumbnailMetadata, error) {
 	var thumbnail *types.ThumbnailMetadata
 	var err error
 
 	if dynamicThumbnails {
 		thumbnail, err = r.generateThumbnail(
-			ctx, filePath, r.ThumbnailSize, activeThumbnailGeneration,
+			ctx, store, fileKey, r.ThumbnailSize, activeThumbnailGeneration,
 			maxThumbnailGenerators, db,
 		)
 		if err != nil {
@@ -485,7 +521,7 @@ func (r *downloadRequest) getThumbnailFile(
 				"ResizeMethod": thumbnailSize.ResizeMethod,
 			}).Debug("Pre-generating thumbnail for immediate response.")
 			thumbnail, err = r.generateThumbnail(
-				ctx, filePath, *thumbnailSize, activeThumbnailGeneration,
+				ctx, store, fileKey, *thumbnailSize, activeThumbnailGeneration,
 				maxThumbnailGenerators, db,
 			)
 			if err != nil {
@@ -503,27 +539,22 @@ func (r *downloadRequest) getThumbnailFile(
 		"FileSizeBytes": thumbnail.MediaMetadata.FileSizeBytes,
 		"ContentType":   thumbnail.MediaMetadata.ContentType,
 	})
-	thumbPath := string(thumbnailer.GetThumbnailPath(types.Path(filePath), thumbnail.ThumbnailSize))
-	thumbFile, err := os.Open(string(thumbPath))
+	thumbKey := thumbnailer.GetThumbnailKey(fileKey, thumbnail.ThumbnailSize)
+	thumbFile, thumbSize, err := store.Open(ctx, thumbKey)
 	if err != nil {
-		thumbFile.Close() // nolint: errcheck
-		return nil, nil, fmt.Errorf("os.Open: %w", err)
-	}
-	thumbStat, err := thumbFile.Stat()
-	if err != nil {
-		thumbFile.Close() // nolint: errcheck
-		return nil, nil, fmt.Errorf("thumbFile.Stat: %w", err)
+		return nil, nil, fmt.Errorf("store.Open: %w", err)
 	}
-	if types.FileSizeBytes(thumbStat.Size()) != thumbnail.MediaMetadata.FileSizeBytes {
+	if types.FileSizeBytes(thumbSize) != thumbnail.MediaMetadata.FileSizeBytes {
 		thumbFile.Close() // nolint: errcheck
-		return nil, nil, errors.New("thumbnail file sizes on disk and in database differ")
+		return nil, nil, errors.New("thumbnail file sizes in media store and in database differ")
 	}
 	return thumbFile, thumbnail, nil
 }
 
 func (r *downloadRequest) generateThumbnail(
 	ctx context.Context,
-	filePath types.Path,
+	store mediastore.MediaStore,
+	fileKey string,
 	thumbnailSize types.ThumbnailSize,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
 	maxThumbnailGenerators int,
@@ -535,7 +566,7 @@ func (r *downloadRequest) generateThumbnail(
 		"ResizeMethod": thumbnailSize.ResizeMethod,
 	})
 	busy, err := thumbnailer.GenerateThumbnail(
-		ctx, filePath, thumbnailSize, r.MediaMetadata,
+		ctx, store, fileKey, thumbnailSize, r.MediaMetadata,
 		activeThumbnailGeneration, maxThumbnailGenerators, db, r.Logger,
 	)
 	if err != nil {
@@ -564,6 +595,7 @@ func (r *downloadRequest) getRemoteFile(
 	client *fclient.Client,
 	cfg *config.MediaAPI,
 	db storage.Database,
+	store mediastore.MediaStore,
 	activeRemoteRequests *types.ActiveRemoteRequests,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
 ) (errorResponse error) {
@@ -596,9 +628,10 @@ func (r *downloadRequest) getRemoteFile(
 
 		if mediaMetadata == nil {
 			// If we do not have a record, we need to fetch the remote file first and then respond from the local file
+			r.MediaMetadata.Authenticated = cfg.FreezeUnauthenticatedMedia
 			err := r.fetchRemoteFileAndStoreMetadata(
-				ctx, client,
-				cfg.AbsBasePath, cfg.MaxFileSizeBytes, db,
+				ctx, client, cfg.Matrix,
+				cfg.AbsBasePath, cfg.MaxFileSizeBytes, db, store,
 				cfg.ThumbnailSizes, activeThumbnailGeneration,
 				cfg.MaxThumbnailGenerators,
 			)
@@ -664,20 +697,36 @@ func (r *downloadRequest) broadcastMediaMetadata(activeRemoteRequests *types.Act
 func (r *downloadRequest) fetchRemoteFileAndStoreMetadata(
 	ctx context.Context,
 	client *fclient.Client,
+	matrixCfg *config.Global,
 	absBasePath config.Path,
 	maxFileSizeBytes config.FileSizeBytes,
 	db storage.Database,
+	store mediastore.MediaStore,
 	thumbnailSizes []config.ThumbnailSize,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
 	maxThumbnailGenerators int,
 ) error {
-	finalPath, duplicate, err := r.fetchRemoteFile(
-		ctx, client, absBasePath, maxFileSizeBytes,
+	finalKey, duplicate, err := r.fetchRemoteFile(
+		ctx, client, matrixCfg, absBasePath, maxFileSizeBytes, store,
 	)
 	if err != nil {
 		return err
 	}
 
+	// If the file was quarantined under a different media ID, this media is quarantined too.
+	existingMetadata, err := db.GetMediaMetadataByHash(ctx, r.MediaMetadata.Base64Hash, r.MediaMetadata.Origin)
+	if err != nil {
+		return fmt.Errorf("db.GetMediaMetadataByHash: %w", err)
+	}
+	if existingMetadata != nil && existingMetadata.QuarantinedBy != "" {
+		r.Logger.WithField("Base64Hash", r.MediaMetadata.Base64Hash).Warn("Fetched remote media which is quarantined")
+		r.MediaMetadata.QuarantinedBy = existingMetadata.QuarantinedBy
+		if err = store.Delete(ctx, finalKey); err != nil {
+			r.Logger.WithError(err).WithField("key", finalKey).Warn("Failed to remove file")
+		}
+		return db.StoreMediaMetadata(ctx, r.MediaMetadata)
+	}
+
 	r.Logger.WithFields(log.Fields{
 		"Base64Hash":    r.MediaMetadata.Base64Hash,
 		"UploadName":    r.MediaMetadata.UploadName,
@@ -691,8 +740,9 @@ func (r *downloadRequest) fetchRemoteFileAndStoreMetadata(
 		// there is valid metadata in the database for that file. As such we only
 		// remove the file if it is not a duplicate.
 		if !duplicate {
-			finalDir := filepath.Dir(string(finalPath))
-			fileutils.RemoveDir(types.Path(finalDir), r.Logger)
+			if err = store.Delete(ctx, finalKey); err != nil {
+				r.Logger.WithError(err).WithField("key", finalKey).Warn("Failed to remove file")
+			}
 		}
 		// NOTE: It should really not be possible to fail the uniqueness test here so
 		// there is no need to handle that separately
@@ -701,7 +751,7 @@ func (r *downloadRequest) fetchRemoteFileAndStoreMetadata(
 
 	go func() {
 		busy, err := thumbnailer.GenerateThumbnails(
-			context.Background(), finalPath, thumbnailSizes, r.MediaMetadata,
+			context.Background(), store, finalKey, thumbnailSizes, r.MediaMetadata,
 			activeThumbnailGeneration, maxThumbnailGenerators, db, r.Logger,
 		)
 		if err != nil {
@@ -762,13 +812,15 @@ func (r *downloadRequest) GetContentLengthAndReader(contentLengthHeader string,
 func (r *downloadRequest) fetchRemoteFile(
 	ctx context.Context,
 	client *fclient.Client,
+	matrixCfg *config.Global,
 	absBasePath config.Path,
 	maxFileSizeBytes config.FileSizeBytes,
-) (types.Path, bool, error) {
+	store mediastore.MediaStore,
+) (string, bool, error) {
 	r.Logger.Debug("Fetching remote file")
 
 	// create request for remote file
-	resp, err := client.CreateMediaDownloadRequest(ctx, r.MediaMetadata.Origin, string(r.MediaMetadata.MediaID))
+	resp, err := r.requestRemoteFile(ctx, client, matrixCfg)
 	if err != nil || (resp != nil && resp.StatusCode != http.StatusOK) {
 		if resp != nil && resp.StatusCode == http.StatusNotFound {
 			return "", false, fmt.Errorf("File with media ID %q does not exist on %s", r.MediaMetadata.MediaID, r.MediaMetadata.Origin)
@@ -832,16 +884,16 @@ func (r *downloadRequest) fetchRemoteFile(
 	r.MediaMetadata.Base64Hash = hash
 
 	// The database is the source of truth so we need to have moved the file first
-	finalPath, duplicate, err := fileutils.MoveFileWithHashCheck(tmpDir, r.MediaMetadata, absBasePath, r.Logger)
+	finalKey, duplicate, err := fileutils.MoveFileWithHashCheck(ctx, tmpDir, r.MediaMetadata, store, r.Logger)
 	if err != nil {
 		return "", false, fmt.Errorf("fileutils.MoveFileWithHashCheck: %w", err)
 	}
 	if duplicate {
-		r.Logger.WithField("dst", finalPath).Trace("File was stored previously - discarding duplicate")
+		r.Logger.WithField("dst", finalKey).Trace("File was stored previously - discarding duplicate")
 		// Continue on to store the metadata in the database
 	}
 
-	return types.Path(finalPath), duplicate, nil
+	return finalKey, duplicate, nil
 }
 
 // contentDispositionFor returns the Content-Disposition for a given
@@ -0,0 +1,286 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/matrix-org/dendrite/internal/httputil"
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/gomatrixserverlib/fclient"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+)
+
+const federationMediaDownloadPath = "/_matrix/federation/v1/media/download/"
+
+// makeFederationDownloadAPI returns the handler of the federation download or thumbnail
+// endpoint (MSC3916). Only media uploaded to this server is served, in a multipart response.
+func makeFederationDownloadAPI(
+	name string,
+	cfg *config.MediaAPI,
+	keys gomatrixserverlib.JSONVerifier,
+	db storage.Database,
+	store mediastore.MediaStore,
+	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		req = util.RequestWithLogging(req)
+
+		// Content-Type will be overridden in case of returning file data, else we respond with JSON-formatted errors
+		w.Header().Set("Content-Type", "application/json")
+
+		fedReq, resErr := fclient.VerifyHTTPRequest(
+			req, time.Now(), cfg.Matrix.ServerName, cfg.Matrix.IsLocalServerName, keys,
+		)
+		if fedReq == nil {
+			w.WriteHeader(resErr.Code)
+			if err := json.NewEncoder(w).Encode(resErr.JSON); err != nil {
+				util.GetLogger(req.Context()).WithError(err).Error("Failed to encode JSON response")
+			}
+			return
+		}
+
+		vars, _ := httputil.URLDecodeMapValues(mux.Vars(req))
+		mw := newMultipartResponseWriter(w)
+		defer func() {
+			if err := mw.Close(); err != nil {
+				util.GetLogger(req.Context()).WithError(err).Error("Failed to finish multipart response")
+			}
+		}()
+
+		// Remote media is never fetched for other servers, so no client is needed.
+		Download(
+			mw,
+			req,
+			cfg.Matrix.ServerName,
+			types.MediaID(vars["mediaId"]),
+			cfg,
+			db,
+			store,
+			nil,
+			nil,
+			activeThumbnailGeneration,
+			name == "thumbnail",
+			true,
+			"",
+		)
+	}
+}
+
+// multipartResponseWriter wraps the file written by Download in the multipart/mixed
+// response of the federation media endpoints. The first part holds the metadata of the
+// media as JSON, which is currently always empty, and the second part holds the file.
+// Error responses are written as they are.
+type multipartResponseWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	writer      *multipart.Writer
+	part        io.Writer
+}
+
+func newMultipartResponseWriter(w http.ResponseWriter) *multipartResponseWriter {
+	return &multipartResponseWriter{
+		w:      w,
+		header: w.Header().Clone(),
+	}
+}
+
+func (m *multipartResponseWriter) Header() http.Header {
+	return m.header
+}
+
+func (m *multipartResponseWriter) WriteHeader(code int) {
+	if m.wroteHeader {
+		return
+	}
+	m.wroteHeader = true
+	if code != http.StatusOK {
+		for k, v := range m.header {
+			m.w.Header()[k] = v
+		}
+		m.w.WriteHeader(code)
+		return
+	}
+
+	m.writer = multipart.NewWriter(m.w)
+	m.w.Header().Set("Content-Type", "multipart/mixed; boundary="+m.writer.Boundary())
+	m.w.WriteHeader(code)
+}
+
+func (m *multipartResponseWriter) Write(b []byte) (int, error) {
+	if !m.wroteHeader {
+		m.WriteHeader(http.StatusOK)
+	}
+	if m.writer == nil {
+		return m.w.Write(b)
+	}
+	if m.part == nil {
+		if err := m.createParts(); err != nil {
+			return 0, err
+		}
+	}
+	return m.part.Write(b)
+}
+
+func (m *multipartResponseWriter) createParts() error {
+	metadata, err := m.writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"application/json"},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = metadata.Write([]byte("{}")); err != nil {
+		return err
+	}
+	header := textproto.MIMEHeader{}
+	for _, k := range []string{"Content-Type", "Content-Disposition"} {
+		if v := m.header.Get(k); v != "" {
+			header.Set(k, v)
+		}
+	}
+	m.part, err = m.writer.CreatePart(header)
+	return err
+}
+
+// Close writes the end of the multipart response, including the parts if the file was empty.
+func (m *multipartResponseWriter) Close() error {
+	if !m.wroteHeader {
+		m.WriteHeader(http.StatusOK)
+	}
+	if m.writer == nil {
+		return nil
+	}
+	if m.part == nil {
+		if err := m.createParts(); err != nil {
+			return err
+		}
+	}
+	return m.writer.Close()
+}
+
+// requestRemoteFile requests the file from the federation download endpoint (MSC3916) of the
+// remote server, falling back to the unauthenticated media endpoint if the remote server
+// doesn't support it. The headers and body of a successful response are those of the file.
+func (r *downloadRequest) requestRemoteFile(
+	ctx context.Context,
+	client *fclient.Client,
+	matrixCfg *config.Global,
+) (*http.Response, error) {
+	fedReq := fclient.NewFederationRequest(
+		http.MethodGet, matrixCfg.ServerName, r.MediaMetadata.Origin,
+		federationMediaDownloadPath+url.PathEscape(string(r.MediaMetadata.MediaID)),
+	)
+	if err := fedReq.Sign(matrixCfg.ServerName, matrixCfg.KeyID, matrixCfg.PrivateKey); err != nil {
+		return nil, fmt.Errorf("fedReq.Sign: %w", err)
+	}
+	req, err := fedReq.HTTPRequest()
+	if err != nil {
+		return nil, fmt.Errorf("fedReq.HTTPRequest: %w", err)
+	}
+	resp, err := client.DoHTTPRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if isUnrecognisedEndpoint(resp) {
+		r.Logger.Debug("Remote server doesn't support authenticated media, falling back to the unauthenticated endpoint")
+		return client.CreateMediaDownloadRequest(ctx, r.MediaMetadata.Origin, string(r.MediaMetadata.MediaID))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	part, err := readMultipartFile(resp)
+	if err != nil {
+		resp.Body.Close() // nolint: errcheck
+		return nil, err
+	}
+	if location := part.Header.Get("Location"); location != "" {
+		// Redirects aren't followed, as they could point anywhere. The unauthenticated
+		// endpoint is expected to serve the file directly instead.
+		resp.Body.Close() // nolint: errcheck
+		r.Logger.WithField("location", location).Debug("Remote server redirected the download, falling back to the unauthenticated endpoint")
+		return client.CreateMediaDownloadRequest(ctx, r.MediaMetadata.Origin, string(r.MediaMetadata.MediaID))
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Type":        part.Header.Values("Content-Type"),
+			"Content-Disposition": part.Header.Values("Content-Disposition"),
+		},
+		Body: struct {
+			io.Reader
+			io.Closer
+		}{part, resp.Body},
+	}, nil
+}
+
+// readMultipartFile skips the metadata of a multipart response of the federation media
+// endpoints and returns the part holding the file.
+func readMultipartFile(resp *http.Response) (*multipart.Part, error) {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("mime.ParseMediaType: %w", err)
+	}
+	if mediaType != "multipart/mixed" {
+		return nil, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	if _, err = reader.NextPart(); err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	part, err := reader.NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return part, nil
+}
+
+// isUnrecognisedEndpoint returns whether the response is that of a server which doesn't know
+// the requested endpoint. The body of the response is closed if so.
+func isUnrecognisedEndpoint(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusMethodNotAllowed {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return false
+	}
+	var matrixErr spec.MatrixError
+	if err = json.Unmarshal(body, &matrixErr); err == nil && matrixErr.ErrCode != spec.ErrorUnrecognized {
+		// A Matrix error other than M_UNRECOGNIZED, e.g. M_NOT_FOUND for unknown media.
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return false
+	}
+	resp.Body.Close() // nolint: errcheck
+	return true
+}
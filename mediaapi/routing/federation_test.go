@@ -0,0 +1,284 @@
+package routing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/gomatrixserverlib/fclient"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMultipartResponseWriter(t *testing.T) {
+	t.Run("file", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mw := newMultipartResponseWriter(rec)
+		mw.Header().Set("Content-Type", "text/plain")
+		mw.Header().Set("Content-Disposition", "inline; filename=hello.txt")
+		if _, err := mw.Write([]byte("hello world")); err != nil {
+			t.Fatal(err)
+		}
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := rec.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code %d", resp.StatusCode)
+		}
+		part, err := readMultipartFile(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := part.Header.Get("Content-Type"); got != "text/plain" {
+			t.Errorf("unexpected content type %q", got)
+		}
+		if got := part.Header.Get("Content-Disposition"); got != "inline; filename=hello.txt" {
+			t.Errorf("unexpected content disposition %q", got)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "hello world" {
+			t.Errorf("unexpected body %q", body)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mw := newMultipartResponseWriter(rec)
+		mw.Header().Set("Content-Type", "application/json")
+		mw.WriteHeader(http.StatusNotFound)
+		if _, err := mw.Write([]byte(`{"errcode":"M_NOT_FOUND"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status code %d", rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("unexpected content type %q", got)
+		}
+		if got := rec.Body.String(); got != `{"errcode":"M_NOT_FOUND"}` {
+			t.Errorf("unexpected body %q", got)
+		}
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRequestRemoteFile(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matrixCfg := &config.Global{}
+	matrixCfg.ServerName = "local"
+	matrixCfg.KeyID = "ed25519:auto"
+	matrixCfg.PrivateKey = privateKey
+
+	serveFile := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Disposition", "attachment; filename=remote.txt")
+		w.Write([]byte("remote file")) // nolint: errcheck
+	}
+
+	testCases := []struct {
+		name           string
+		federation     func(w http.ResponseWriter)
+		wantLegacy     bool
+		wantStatusCode int
+	}{
+		{
+			name: "multipart",
+			federation: func(w http.ResponseWriter) {
+				mw := newMultipartResponseWriter(w)
+				serveFile(mw)
+				mw.Close() // nolint: errcheck
+			},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name: "unrecognised endpoint",
+			federation: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errcode":"M_UNRECOGNIZED","error":"Unrecognized request"}`)) // nolint: errcheck
+			},
+			wantLegacy:     true,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name: "redirect",
+			federation: func(w http.ResponseWriter) {
+				writer := multipart.NewWriter(w)
+				w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+				metadata, _ := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+				metadata.Write([]byte("{}"))                                                     // nolint: errcheck
+				writer.CreatePart(textproto.MIMEHeader{"Location": {"https://cdn.remote/file"}}) // nolint: errcheck
+				writer.Close()                                                                   // nolint: errcheck
+			},
+			wantLegacy:     true,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name: "media not found",
+			federation: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"Not found"}`)) // nolint: errcheck
+			},
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			usedLegacy := false
+			client := fclient.NewClient(fclient.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				rec := httptest.NewRecorder()
+				switch {
+				case req.URL.Path == "/_matrix/federation/v1/media/download/media":
+					if !strings.HasPrefix(req.Header.Get("Authorization"), "X-Matrix ") {
+						t.Errorf("federation request isn't signed")
+					}
+					tc.federation(rec)
+				case req.URL.Path == "/_matrix/media/v3/download/remote/media":
+					usedLegacy = true
+					serveFile(rec)
+				default:
+					t.Errorf("unexpected request to %s", req.URL)
+					rec.WriteHeader(http.StatusNotFound)
+				}
+				return rec.Result(), nil
+			})))
+
+			r := &downloadRequest{
+				MediaMetadata: &types.MediaMetadata{MediaID: "media", Origin: "remote"},
+				Logger:        log.WithField("mediaapi", "test"),
+			}
+			resp, err := r.requestRemoteFile(context.Background(), client, matrixCfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close() // nolint: errcheck
+			if usedLegacy != tc.wantLegacy {
+				t.Errorf("expected legacy endpoint to be used: %v, got %v", tc.wantLegacy, usedLegacy)
+			}
+			if resp.StatusCode != tc.wantStatusCode {
+				t.Fatalf("unexpected status code %d", resp.StatusCode)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			if got := resp.Header.Get("Content-Disposition"); got != "attachment; filename=remote.txt" {
+				t.Errorf("unexpected content disposition %q", got)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != "remote file" {
+				t.Errorf("unexpected body %q", body)
+			}
+		})
+	}
+}
+
+func TestFreezeUnauthenticatedMedia(t *testing.T) {
+	basePath, err := os.MkdirTemp("", "mediaapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath) // nolint: errcheck
+	cfg := &config.MediaAPI{
+		Matrix:      &config.Global{},
+		BasePath:    config.Path(basePath),
+		AbsBasePath: config.Path(basePath),
+	}
+	cfg.Matrix.ServerName = "test"
+
+	connStr, closeDB := test.PrepareDBConnectionString(t, test.DBTypeSQLite)
+	defer closeDB()
+	cm := sqlutil.NewConnectionManager(nil, config.DatabaseOptions{})
+	db, err := storage.NewMediaAPIDatasource(cm, &config.DatabaseOptions{ConnectionString: config.DataSource(connStr)})
+	if err != nil {
+		t.Fatalf("failed to open media database: %s", err)
+	}
+	store := mediastore.NewFilesystemStore(cfg.AbsBasePath)
+	activeThumbnailGeneration := &types.ActiveThumbnailGeneration{
+		PathToResult: map[string]*types.ThumbnailGenerationResult{},
+	}
+	ctx := context.Background()
+	logger := log.WithField("mediaapi", "test")
+
+	upload := func(t *testing.T, content string) types.MediaID {
+		t.Helper()
+		r := &uploadRequest{
+			MediaMetadata: &types.MediaMetadata{
+				Origin: "test", UserID: "@alice:test", UploadName: "upload",
+				FileSizeBytes: types.FileSizeBytes(len(content)),
+				Authenticated: cfg.FreezeUnauthenticatedMedia,
+			},
+			Logger: logger,
+		}
+		if resErr := r.doUpload(ctx, strings.NewReader(content), cfg, db, store, activeThumbnailGeneration); resErr != nil {
+			t.Fatalf("failed to upload media: %+v", resErr)
+		}
+		return r.MediaMetadata.MediaID
+	}
+	download := func(t *testing.T, origin spec.ServerName, mediaID types.MediaID, authenticated bool) bool {
+		t.Helper()
+		r := &downloadRequest{
+			MediaMetadata: &types.MediaMetadata{MediaID: mediaID, Origin: origin},
+			Logger:        logger,
+			Authenticated: authenticated,
+		}
+		// No client is given, as remote media must not be fetched.
+		metadata, err := r.doDownload(ctx, httptest.NewRecorder(), cfg, db, store, nil, nil, activeThumbnailGeneration)
+		if err != nil {
+			t.Fatalf("failed to download media: %s", err)
+		}
+		return metadata != nil
+	}
+
+	unfrozen := upload(t, "before freeze")
+	cfg.FreezeUnauthenticatedMedia = true
+	frozen := upload(t, "after freeze")
+	frozenDuplicate := upload(t, "before freeze")
+
+	if !download(t, "test", unfrozen, false) || !download(t, "test", unfrozen, true) {
+		t.Errorf("expected media uploaded before the freeze to be downloadable from all endpoints")
+	}
+	if download(t, "test", frozen, false) {
+		t.Errorf("expected media uploaded after the freeze not to be downloadable from unauthenticated endpoints")
+	}
+	if !download(t, "test", frozen, true) {
+		t.Errorf("expected media uploaded after the freeze to be downloadable from authenticated endpoints")
+	}
+	if download(t, "test", frozenDuplicate, false) {
+		t.Errorf("expected a file uploaded again after the freeze not to be downloadable from unauthenticated endpoints")
+	}
+	if download(t, "remote", "uncached", false) {
+		t.Errorf("expected uncached remote media not to be downloadable from unauthenticated endpoints")
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/thumbnailer"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/process"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/sirupsen/logrus"
+)
+
+// purgeBatchSize is how many media are purged per database query.
+const purgeBatchSize = 100
+
+// PurgeResult is the outcome of purging media.
+type PurgeResult struct {
+	// The number of media removed from the database.
+	DeletedMedia int `json:"deleted_media"`
+	// The number of files, including thumbnails, removed from the media store.
+	DeletedFiles int `json:"deleted_files"`
+	// The size of the removed files.
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+func (r *PurgeResult) add(other PurgeResult) {
+	r.DeletedMedia += other.DeletedMedia
+	r.DeletedFiles += other.DeletedFiles
+	r.FreedBytes += other.FreedBytes
+}
+
+// MediaPurger removes expired media, along with its thumbnails, from the
+// database and the media store.
+type MediaPurger struct {
+	Cfg   *config.MediaAPI
+	DB    storage.Database
+	Store mediastore.MediaStore
+	// Only one purge runs at a time.
+	mu sync.Mutex
+}
+
+// Start purges the media which expired according to the configured retention
+// every purge interval, until the process shuts down.
+func (p *MediaPurger) Start(processCtx *process.ProcessContext) {
+	retention := &p.Cfg.Retention
+	if !retention.Enabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(retention.PurgeInterval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			var res PurgeResult
+			var err error
+			if retention.RemoteMediaLifetime > 0 {
+				res, err = p.PurgeRemoteMedia(processCtx.Context(), spec.AsTimestamp(now.Add(-retention.RemoteMediaLifetime)))
+				if err != nil {
+					logrus.WithError(err).Error("Failed to purge remote media")
+				} else if res.DeletedMedia > 0 {
+					logrus.WithFields(logrus.Fields{
+						"media": res.DeletedMedia,
+						"files": res.DeletedFiles,
+						"bytes": res.FreedBytes,
+					}).Info("Purged expired remote media")
+				}
+			}
+			if retention.LocalMediaLifetime > 0 {
+				res, err = p.PurgeLocalMedia(processCtx.Context(), spec.AsTimestamp(now.Add(-retention.LocalMediaLifetime)), retention.LocalMediaPolicy)
+				if err != nil {
+					logrus.WithError(err).Error("Failed to purge local media")
+				} else if res.DeletedMedia > 0 {
+					logrus.WithFields(logrus.Fields{
+						"media": res.DeletedMedia,
+						"files": res.DeletedFiles,
+						"bytes": res.FreedBytes,
+					}).Info("Purged expired local media")
+				}
+			}
+			select {
+			case <-processCtx.WaitForShutdown():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// PurgeRemoteMedia removes the cached media from other servers which hasn't been
+// accessed since the given time. It is fetched again when it is next requested.
+func (p *MediaPurger) PurgeRemoteMedia(ctx context.Context, before spec.Timestamp) (PurgeResult, error) {
+	return p.purge(ctx, func() ([]*types.MediaMetadata, error) {
+		return p.DB.GetRemoteMediaAccessedBefore(ctx, p.localServerNames(), before, purgeBatchSize)
+	})
+}
+
+// PurgeLocalMedia removes the media uploaded to this server before the given time.
+// With the last access policy, only media which also hasn't been accessed since is removed.
+// Media is removed even if it is still used, e.g. as an avatar.
+func (p *MediaPurger) PurgeLocalMedia(ctx context.Context, before spec.Timestamp, policy string) (PurgeResult, error) {
+	switch policy {
+	case config.LocalMediaPolicyAge:
+		return p.purge(ctx, func() ([]*types.MediaMetadata, error) {
+			return p.DB.GetLocalMediaCreatedBefore(ctx, p.localServerNames(), before, purgeBatchSize)
+		})
+	case config.LocalMediaPolicyLastAccess:
+		return p.purge(ctx, func() ([]*types.MediaMetadata, error) {
+			return p.DB.GetLocalMediaAccessedBefore(ctx, p.localServerNames(), before, purgeBatchSize)
+		})
+	default:
+		return PurgeResult{}, fmt.Errorf("unknown local media policy %q", policy)
+	}
+}
+
+// localServerNames returns the server name and the names of all virtual hosts,
+// since media uploaded to any of them is local.
+func (p *MediaPurger) localServerNames() []spec.ServerName {
+	serverNames := []spec.ServerName{p.Cfg.Matrix.ServerName}
+	for _, v := range p.Cfg.Matrix.VirtualHosts {
+		serverNames = append(serverNames, v.ServerName)
+	}
+	return serverNames
+}
+
+// purge removes batches of media returned by selectBatch until there is none left.
+func (p *MediaPurger) purge(ctx context.Context, selectBatch func() ([]*types.MediaMetadata, error)) (PurgeResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var res PurgeResult
+	for {
+		batch, err := selectBatch()
+		if err != nil {
+			return res, fmt.Errorf("failed to select media: %w", err)
+		}
+		for _, mediaMetadata := range batch {
+			purged, err := p.purgeMedia(ctx, mediaMetadata)
+			res.add(purged)
+			if err != nil {
+				return res, err
+			}
+		}
+		if len(batch) < purgeBatchSize {
+			return res, nil
+		}
+	}
+}
+
+// purgeMedia removes the metadata of media, and then its file and thumbnails unless
+// they are still used by other media with the same hash.
+func (p *MediaPurger) purgeMedia(ctx context.Context, mediaMetadata *types.MediaMetadata) (PurgeResult, error) {
+	logger := logrus.WithFields(logrus.Fields{
+		"media_id": mediaMetadata.MediaID,
+		"origin":   mediaMetadata.Origin,
+	})
+	thumbnails, fileInUse, err := p.DB.DeleteMedia(ctx, mediaMetadata)
+	if err != nil {
+		return PurgeResult{}, fmt.Errorf("failed to delete media %s/%s: %w", mediaMetadata.Origin, mediaMetadata.MediaID, err)
+	}
+	res := PurgeResult{DeletedMedia: 1}
+	if fileInUse {
+		logger.Debug("Purged media, file is still in use")
+		return res, nil
+	}
+	deleted, err := p.deleteFiles(ctx, mediaMetadata, thumbnails)
+	res.add(deleted)
+	if err != nil {
+		return res, err
+	}
+	logger.WithField("files", res.DeletedFiles).Debug("Purged media")
+	return res, nil
+}
+
+// deleteFiles removes the file of the media from the media store, along with the given
+// thumbnails and any thumbnails of the pre-generated sizes.
+func (p *MediaPurger) deleteFiles(
+	ctx context.Context, mediaMetadata *types.MediaMetadata, thumbnails []*types.ThumbnailMetadata,
+) (PurgeResult, error) {
+	var res PurgeResult
+	key, err := mediastore.MediaKey(mediaMetadata.Base64Hash)
+	if err != nil {
+		return res, err
+	}
+	files := map[string]int64{key: int64(mediaMetadata.FileSizeBytes)}
+	// Thumbnails of the pre-generated sizes may have been generated for other media
+	// with the same hash which was purged earlier.
+	for _, size := range p.Cfg.ThumbnailSizes {
+		files[thumbnailer.GetThumbnailKey(key, types.ThumbnailSize(size))] = -1
+	}
+	for _, thumbnail := range thumbnails {
+		files[thumbnailer.GetThumbnailKey(key, thumbnail.ThumbnailSize)] = int64(thumbnail.MediaMetadata.FileSizeBytes)
+	}
+	for fileKey, size := range files {
+		if size < 0 {
+			size, err = p.Store.Stat(ctx, fileKey)
+			if errors.Is(err, fs.ErrNotExist) {
+				// The thumbnail was never generated.
+				continue
+			} else if err != nil {
+				return res, fmt.Errorf("failed to stat file %q: %w", fileKey, err)
+			}
+		}
+		if err = p.Store.Delete(ctx, fileKey); err != nil {
+			return res, fmt.Errorf("failed to delete file %q: %w", fileKey, err)
+		}
+		res.DeletedFiles++
+		res.FreedBytes += size
+	}
+	return res, nil
+}
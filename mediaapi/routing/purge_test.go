@@ -0,0 +1,143 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/thumbnailer"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+)
+
+func TestMediaPurger(t *testing.T) {
+	basePath, err := os.MkdirTemp("", "mediaapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath) // nolint: errcheck
+	cfg := &config.MediaAPI{
+		Matrix:      &config.Global{},
+		BasePath:    config.Path(basePath),
+		AbsBasePath: config.Path(basePath),
+		ThumbnailSizes: []config.ThumbnailSize{
+			{Width: 32, Height: 32, ResizeMethod: types.Crop},
+		},
+	}
+	cfg.Matrix.ServerName = "test"
+	cfg.Retention.Defaults()
+
+	connStr, closeDB := test.PrepareDBConnectionString(t, test.DBTypeSQLite)
+	defer closeDB()
+	cm := sqlutil.NewConnectionManager(nil, config.DatabaseOptions{})
+	db, err := storage.NewMediaAPIDatasource(cm, &config.DatabaseOptions{ConnectionString: config.DataSource(connStr)})
+	if err != nil {
+		t.Fatalf("failed to open media database: %s", err)
+	}
+	store := mediastore.NewFilesystemStore(cfg.AbsBasePath)
+	purger := &MediaPurger{Cfg: cfg, DB: db, Store: store}
+	ctx := context.Background()
+
+	// storeMedia stores the metadata of media and its file, along with a thumbnail
+	// of the configured size, and returns the key of the file.
+	storeMedia := func(t *testing.T, mediaID types.MediaID, origin spec.ServerName, hash types.Base64Hash) string {
+		t.Helper()
+		if err = db.StoreMediaMetadata(ctx, &types.MediaMetadata{
+			MediaID: mediaID, Origin: origin, Base64Hash: hash, FileSizeBytes: 4,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		key, err := mediastore.MediaKey(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = store.Put(ctx, key, strings.NewReader("file"), 4); err != nil {
+			t.Fatal(err)
+		}
+		thumbnailKey := thumbnailer.GetThumbnailKey(key, types.ThumbnailSize(cfg.ThumbnailSizes[0]))
+		if err = store.Put(ctx, thumbnailKey, strings.NewReader("thumb"), 5); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	exists := func(t *testing.T, key string) bool {
+		t.Helper()
+		_, err := store.Stat(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return true
+	}
+
+	t.Run("files are kept while still in use", func(t *testing.T) {
+		sharedKey := storeMedia(t, "remote_shared", "remote", "c2hhcmVkaGFzaA==")
+		storeMedia(t, "local_shared", "test", "c2hhcmVkaGFzaA==")
+		remoteKey := storeMedia(t, "remote", "remote", "cmVtb3RlaGFzaA==")
+
+		res, err := purger.PurgeRemoteMedia(ctx, spec.AsTimestamp(time.Now().Add(time.Minute)))
+		if err != nil {
+			t.Fatalf("failed to purge remote media: %s", err)
+		}
+		if res.DeletedMedia != 2 || res.DeletedFiles != 2 || res.FreedBytes != 9 {
+			t.Fatalf("unexpected purge result %+v", res)
+		}
+		if exists(t, remoteKey) {
+			t.Fatalf("expected remote file to be deleted")
+		}
+		if !exists(t, sharedKey) {
+			t.Fatalf("expected file shared with local media to be kept")
+		}
+
+		res, err = purger.PurgeLocalMedia(ctx, spec.AsTimestamp(time.Now().Add(time.Minute)), config.LocalMediaPolicyAge)
+		if err != nil {
+			t.Fatalf("failed to purge local media: %s", err)
+		}
+		if res.DeletedMedia != 1 || res.DeletedFiles != 2 {
+			t.Fatalf("unexpected purge result %+v", res)
+		}
+		if exists(t, sharedKey) {
+			t.Fatalf("expected shared file to be deleted")
+		}
+	})
+
+	t.Run("recently accessed media is kept", func(t *testing.T) {
+		key := storeMedia(t, "accessed", "remote", "YWNjZXNzZWRoYXNo")
+		if err = db.UpdateMediaLastAccess(ctx, "accessed", "remote", spec.AsTimestamp(time.Now().Add(time.Hour))); err != nil {
+			t.Fatal(err)
+		}
+		res, err := purger.PurgeRemoteMedia(ctx, spec.AsTimestamp(time.Now().Add(time.Minute)))
+		if err != nil {
+			t.Fatalf("failed to purge remote media: %s", err)
+		}
+		if res.DeletedMedia != 0 || !exists(t, key) {
+			t.Fatalf("expected accessed media to be kept, got %+v", res)
+		}
+	})
+
+	t.Run("admin endpoint validates the request", func(t *testing.T) {
+		for body, wantCode := range map[string]int{
+			`not json`: http.StatusBadRequest,
+			`{}`:       http.StatusBadRequest,
+			`{"before_ts": 1, "local": true, "local_media_policy": "unknown"}`: http.StatusBadRequest,
+			`{"before_ts": 1, "local": true}`:                                  http.StatusOK,
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/admin/media/purge", strings.NewReader(body))
+			if res := AdminPurgeMedia(req, cfg, purger); res.Code != wantCode {
+				t.Fatalf("expected %d for %s, got %d: %+v", wantCode, body, res.Code, res.JSON)
+			}
+		}
+	})
+}
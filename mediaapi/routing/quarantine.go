@@ -0,0 +1,138 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/sirupsen/logrus"
+)
+
+// QuarantineResult is the outcome of quarantining media.
+type QuarantineResult struct {
+	// The number of media which were quarantined.
+	QuarantinedMedia int `json:"num_quarantined"`
+	// The number of files, including thumbnails, removed from the media store.
+	DeletedFiles int `json:"deleted_files"`
+	// The size of the removed files.
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+func (r *QuarantineResult) add(other QuarantineResult) {
+	r.QuarantinedMedia += other.QuarantinedMedia
+	r.DeletedFiles += other.DeletedFiles
+	r.FreedBytes += other.FreedBytes
+}
+
+// QuarantineMedia quarantines the media, along with all other media with the same content,
+// and deletes its file and thumbnails. Quarantined media can't be downloaded, and the same
+// file can't be uploaded or fetched from a remote server again. Media which isn't known yet
+// is quarantined too, so that it won't be fetched in the future.
+func (p *MediaPurger) QuarantineMedia(
+	ctx context.Context, mediaID types.MediaID, mediaOrigin spec.ServerName, quarantinedBy types.MatrixUserID,
+) (QuarantineResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	quarantined, thumbnails, err := p.DB.QuarantineMedia(ctx, mediaID, mediaOrigin, quarantinedBy)
+	if err != nil {
+		return QuarantineResult{}, fmt.Errorf("failed to quarantine media %s/%s: %w", mediaOrigin, mediaID, err)
+	}
+	res := QuarantineResult{QuarantinedMedia: len(quarantined)}
+	if len(quarantined) == 0 {
+		return res, nil
+	}
+	// All quarantined media are stored in the same file.
+	deleted, err := p.deleteFiles(ctx, quarantined[0], thumbnails)
+	res.DeletedFiles, res.FreedBytes = deleted.DeletedFiles, deleted.FreedBytes
+	if err != nil {
+		return res, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"media_id":       mediaID,
+		"origin":         mediaOrigin,
+		"quarantined_by": quarantinedBy,
+		"media":          res.QuarantinedMedia,
+		"files":          res.DeletedFiles,
+	}).Info("Quarantined media")
+	return res, nil
+}
+
+// QuarantineUserMedia quarantines all media uploaded by the user.
+func (p *MediaPurger) QuarantineUserMedia(
+	ctx context.Context, userID types.MatrixUserID, quarantinedBy types.MatrixUserID,
+) (QuarantineResult, error) {
+	var uploads []*types.MediaMetadata
+	for {
+		batch, _, err := p.DB.GetMediaByUser(ctx, userID, len(uploads), purgeBatchSize)
+		if err != nil {
+			return QuarantineResult{}, fmt.Errorf("failed to select media of %s: %w", userID, err)
+		}
+		uploads = append(uploads, batch...)
+		if len(batch) < purgeBatchSize {
+			break
+		}
+	}
+	var res QuarantineResult
+	for _, mediaMetadata := range uploads {
+		if mediaMetadata.QuarantinedBy != "" {
+			continue
+		}
+		quarantined, err := p.QuarantineMedia(ctx, mediaMetadata.MediaID, mediaMetadata.Origin, quarantinedBy)
+		res.add(quarantined)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// QuarantineMediaURIs quarantines the media with the given mxc:// URIs, e.g. all media
+// referenced in a room. Invalid URIs are skipped.
+func (p *MediaPurger) QuarantineMediaURIs(
+	ctx context.Context, uris []string, quarantinedBy types.MatrixUserID,
+) (QuarantineResult, error) {
+	var res QuarantineResult
+	for _, uri := range uris {
+		mediaOrigin, mediaID, ok := parseMediaURI(uri)
+		if !ok {
+			logrus.WithField("uri", uri).Debug("Skipping invalid media URI")
+			continue
+		}
+		quarantined, err := p.QuarantineMedia(ctx, mediaID, mediaOrigin, quarantinedBy)
+		res.add(quarantined)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// parseMediaURI returns the origin and media ID of an mxc:// URI.
+func parseMediaURI(uri string) (spec.ServerName, types.MediaID, bool) {
+	serverAndID, ok := strings.CutPrefix(uri, "mxc://")
+	if !ok {
+		return "", "", false
+	}
+	serverName, mediaID, ok := strings.Cut(serverAndID, "/")
+	if !ok || serverName == "" || !mediaIDRegex.MatchString(mediaID) {
+		return "", "", false
+	}
+	return spec.ServerName(serverName), types.MediaID(mediaID), true
+}
@@ -0,0 +1,131 @@
+package routing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestQuarantineMedia(t *testing.T) {
+	basePath, err := os.MkdirTemp("", "mediaapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath) // nolint: errcheck
+	cfg := &config.MediaAPI{
+		Matrix:      &config.Global{},
+		BasePath:    config.Path(basePath),
+		AbsBasePath: config.Path(basePath),
+	}
+	cfg.Matrix.ServerName = "test"
+
+	connStr, closeDB := test.PrepareDBConnectionString(t, test.DBTypeSQLite)
+	defer closeDB()
+	cm := sqlutil.NewConnectionManager(nil, config.DatabaseOptions{})
+	db, err := storage.NewMediaAPIDatasource(cm, &config.DatabaseOptions{ConnectionString: config.DataSource(connStr)})
+	if err != nil {
+		t.Fatalf("failed to open media database: %s", err)
+	}
+	store := mediastore.NewFilesystemStore(cfg.AbsBasePath)
+	purger := &MediaPurger{Cfg: cfg, DB: db, Store: store}
+	activeThumbnailGeneration := &types.ActiveThumbnailGeneration{
+		PathToResult: map[string]*types.ThumbnailGenerationResult{},
+	}
+	ctx := context.Background()
+	logger := log.WithField("mediaapi", "test")
+
+	upload := func(t *testing.T, content string) (*types.MediaMetadata, *util.JSONResponse) {
+		t.Helper()
+		r := &uploadRequest{
+			MediaMetadata: &types.MediaMetadata{Origin: "test", UserID: "@alice:test", UploadName: "upload"},
+			Logger:        logger,
+		}
+		return r.MediaMetadata, r.doUpload(ctx, strings.NewReader(content), cfg, db, store, activeThumbnailGeneration)
+	}
+	download := func(t *testing.T, mediaID types.MediaID) *types.MediaMetadata {
+		t.Helper()
+		r := &downloadRequest{
+			MediaMetadata: &types.MediaMetadata{MediaID: mediaID, Origin: "test"},
+			Logger:        logger,
+		}
+		metadata, err := r.doDownload(ctx, httptest.NewRecorder(), cfg, db, store, nil, nil, activeThumbnailGeneration)
+		if err != nil {
+			t.Fatalf("failed to download media: %s", err)
+		}
+		return metadata
+	}
+
+	mediaMetadata, resErr := upload(t, "abusive")
+	if resErr != nil {
+		t.Fatalf("failed to upload media: %+v", resErr)
+	}
+	if download(t, mediaMetadata.MediaID) == nil {
+		t.Fatalf("expected media to be downloadable")
+	}
+	key, err := mediastore.MediaKey(mediaMetadata.Base64Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/media/test/"+string(mediaMetadata.MediaID)+"/quarantine", nil)
+	res := AdminQuarantineMedia(req, &userapi.Device{UserID: "@admin:test"}, purger, "test", string(mediaMetadata.MediaID))
+	if res.Code != http.StatusOK {
+		t.Fatalf("failed to quarantine media: %+v", res.JSON)
+	}
+	if got := res.JSON.(QuarantineResult); got.QuarantinedMedia != 1 || got.DeletedFiles != 1 {
+		t.Fatalf("unexpected quarantine result %+v", got)
+	}
+	if _, err = store.Stat(ctx, key); err == nil {
+		t.Fatalf("expected file to be deleted")
+	}
+	if download(t, mediaMetadata.MediaID) != nil {
+		t.Fatalf("expected quarantined media not to be downloadable")
+	}
+	if _, resErr = upload(t, "abusive"); resErr == nil || resErr.Code != http.StatusForbidden {
+		t.Fatalf("expected quarantined media to be rejected, got %+v", resErr)
+	}
+
+	t.Run("quarantines media of a user", func(t *testing.T) {
+		if _, resErr = upload(t, "more abuse"); resErr != nil {
+			t.Fatalf("failed to upload media: %+v", resErr)
+		}
+		res, err := purger.QuarantineUserMedia(ctx, "@alice:test", "@admin:test")
+		if err != nil {
+			t.Fatalf("failed to quarantine media: %s", err)
+		}
+		if res.QuarantinedMedia != 1 {
+			t.Fatalf("expected 1 quarantined media, got %+v", res)
+		}
+	})
+
+	t.Run("quarantines media by URI", func(t *testing.T) {
+		res, err := purger.QuarantineMediaURIs(ctx, []string{"mxc://remote/someMedia", "https://example.com", "mxc://remote/../"}, "@admin:test")
+		if err != nil {
+			t.Fatalf("failed to quarantine media: %s", err)
+		}
+		if res.QuarantinedMedia != 0 {
+			t.Fatalf("expected no quarantined files, got %+v", res)
+		}
+		mediaMetadata, err := db.GetMediaMetadata(ctx, "someMedia", spec.ServerName("remote"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mediaMetadata == nil || mediaMetadata.QuarantinedBy != "@admin:test" {
+			t.Fatalf("expected unknown remote media to be quarantined, got %+v", mediaMetadata)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMediaQuota(t *testing.T) {
+	basePath, err := os.MkdirTemp("", "mediaapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath) // nolint: errcheck
+	cfg := &config.MediaAPI{
+		Matrix:                &config.Global{},
+		BasePath:              config.Path(basePath),
+		AbsBasePath:           config.Path(basePath),
+		DefaultUserQuotaBytes: 10,
+	}
+	cfg.Matrix.ServerName = "test"
+
+	connStr, closeDB := test.PrepareDBConnectionString(t, test.DBTypeSQLite)
+	defer closeDB()
+	cm := sqlutil.NewConnectionManager(nil, config.DatabaseOptions{})
+	db, err := storage.NewMediaAPIDatasource(cm, &config.DatabaseOptions{ConnectionString: config.DataSource(connStr)})
+	if err != nil {
+		t.Fatalf("failed to open media database: %s", err)
+	}
+	store := mediastore.NewFilesystemStore(cfg.AbsBasePath)
+	activeThumbnailGeneration := &types.ActiveThumbnailGeneration{
+		PathToResult: map[string]*types.ThumbnailGenerationResult{},
+	}
+	ctx := context.Background()
+	logger := log.WithField("mediaapi", "test")
+
+	upload := func(t *testing.T, userID types.MatrixUserID, content string) int {
+		t.Helper()
+		r := &uploadRequest{
+			MediaMetadata: &types.MediaMetadata{
+				Origin: "test", UserID: userID, UploadName: "upload",
+				FileSizeBytes: types.FileSizeBytes(len(content)),
+			},
+			Logger: logger,
+		}
+		if resErr := r.doUpload(ctx, strings.NewReader(content), cfg, db, store, activeThumbnailGeneration); resErr != nil {
+			return resErr.Code
+		}
+		return http.StatusOK
+	}
+	usage := func(t *testing.T) adminMediaUsage {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/admin/users/@alice:test/media/quota", nil)
+		res := AdminGetUserMediaQuota(req, cfg, db, "@alice:test")
+		if res.Code != http.StatusOK {
+			t.Fatalf("failed to get media quota: %+v", res.JSON)
+		}
+		return res.JSON.(adminMediaUsage)
+	}
+
+	if code := upload(t, "@alice:test", "12345"); code != http.StatusOK {
+		t.Fatalf("expected upload within the quota to succeed, got %d", code)
+	}
+	if code := upload(t, "@alice:test", "678901"); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected upload exceeding the quota to fail, got %d", code)
+	}
+	if code := upload(t, "@bob:test", "678901"); code != http.StatusOK {
+		t.Fatalf("expected upload of another user to succeed, got %d", code)
+	}
+	if got := usage(t); got.UsedBytes != 5 || got.QuotaBytes != 10 || got.CustomQuota {
+		t.Fatalf("unexpected media usage %+v", got)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/users/@alice:test/media/quota", strings.NewReader(`{"quota_bytes": 20}`))
+	if res := AdminSetUserMediaQuota(req, cfg, db, "@alice:test"); res.Code != http.StatusOK {
+		t.Fatalf("failed to set media quota: %+v", res.JSON)
+	}
+	if got := usage(t); got.QuotaBytes != 20 || !got.CustomQuota {
+		t.Fatalf("unexpected media usage %+v", got)
+	}
+	if code := upload(t, "@alice:test", "678901"); code != http.StatusOK {
+		t.Fatalf("expected upload within the custom quota to succeed, got %d", code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/media/usage?limit=1", nil)
+	res := AdminListMediaUsage(req, cfg, db)
+	if res.Code != http.StatusOK {
+		t.Fatalf("failed to list media usage: %+v", res.JSON)
+	}
+	data, err := json.Marshal(res.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		Users     []adminMediaUsage `json:"users"`
+		Total     int               `json:"total"`
+		NextToken *int              `json:"next_token"`
+	}
+	if err = json.Unmarshal(data, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Users) != 1 || list.Users[0].UserID != "@alice:test" || list.Users[0].UsedBytes != 11 {
+		t.Fatalf("expected alice to be the largest uploader, got %+v", list.Users)
+	}
+	if list.Total != 2 || list.NextToken == nil || *list.NextToken != 1 {
+		t.Fatalf("unexpected pagination %+v", list)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/admin/users/@alice:test/media/quota", nil)
+	if res = AdminSetUserMediaQuota(req, cfg, db, "@alice:test"); res.Code != http.StatusOK {
+		t.Fatalf("failed to reset media quota: %+v", res.JSON)
+	}
+	if got := usage(t); got.UsedBytes != 11 || got.QuotaBytes != 10 || got.CustomQuota {
+		t.Fatalf("unexpected media usage %+v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/admin/users/@alice:remote/media/quota", strings.NewReader(`{"quota_bytes": 20}`))
+	if res = AdminSetUserMediaQuota(req, cfg, db, "@alice:remote"); res.Code != http.StatusBadRequest {
+		t.Fatalf("expected setting the quota of a remote user to fail, got %d", res.Code)
+	}
+}
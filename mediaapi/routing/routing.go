@@ -20,11 +20,15 @@ import (
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/matrix-org/dendrite/clientapi/auth"
 	"github.com/matrix-org/dendrite/internal/httputil"
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
+	roomserverAPI "github.com/matrix-org/dendrite/roomserver/api"
 	"github.com/matrix-org/dendrite/setup/config"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/gomatrixserverlib/fclient"
 	"github.com/matrix-org/gomatrixserverlib/spec"
 	"github.com/matrix-org/util"
@@ -45,15 +49,23 @@ type configResponse struct {
 // applied:
 // nolint: gocyclo
 func Setup(
-	publicAPIMux *mux.Router,
+	routers httputil.Routers,
 	cfg *config.Dendrite,
 	db storage.Database,
+	store mediastore.MediaStore,
+	purger *MediaPurger,
 	userAPI userapi.MediaUserAPI,
+	rsAPI roomserverAPI.MediaRoomserverAPI,
 	client *fclient.Client,
+	keys gomatrixserverlib.JSONVerifier,
 ) {
 	rateLimits := httputil.NewRateLimits(&cfg.ClientAPI.RateLimiting)
 
-	v3mux := publicAPIMux.PathPrefix("/{apiversion:(?:r0|v1|v3)}/").Subrouter()
+	v3mux := routers.Media.PathPrefix("/{apiversion:(?:r0|v1|v3)}/").Subrouter()
+	// The authenticated media endpoints (MSC3916) are part of the client and federation APIs.
+	v1mux := routers.Client.PathPrefix("/v1/media/").Subrouter()
+	v1fedmux := routers.Federation.PathPrefix("/v1/media/").Subrouter()
+	dendriteAdminRouter := routers.DendriteAdmin
 
 	activeThumbnailGeneration := &types.ActiveThumbnailGeneration{
 		PathToResult: map[string]*types.ThumbnailGenerationResult{},
@@ -65,7 +77,7 @@ func Setup(
 			if r := rateLimits.Limit(req, dev); r != nil {
 				return *r
 			}
-			return Upload(req, &cfg.MediaAPI, dev, db, activeThumbnailGeneration)
+			return Upload(req, &cfg.MediaAPI, dev, db, store, activeThumbnailGeneration)
 		},
 	)
 
@@ -85,34 +97,131 @@ func Setup(
 
 	v3mux.Handle("/upload", uploadHandler).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/config", configHandler).Methods(http.MethodGet, http.MethodOptions)
+	v1mux.Handle("/config", configHandler).Methods(http.MethodGet, http.MethodOptions)
+
+	if cfg.MediaAPI.URLPreviews.Enabled {
+		urlPreviewClient := newURLPreviewClient(&cfg.MediaAPI.URLPreviews)
+		previewHandler := httputil.MakeAuthAPI("preview_url", userAPI, func(req *http.Request, dev *userapi.Device) util.JSONResponse {
+			if r := rateLimits.Limit(req, dev); r != nil {
+				return *r
+			}
+			return PreviewURL(req, &cfg.MediaAPI, dev, db, store, urlPreviewClient, activeThumbnailGeneration)
+		})
+		v3mux.Handle("/preview_url", previewHandler).Methods(http.MethodGet, http.MethodOptions)
+		v1mux.Handle("/preview_url", previewHandler).Methods(http.MethodGet, http.MethodOptions)
+	}
 
 	activeRemoteRequests := &types.ActiveRemoteRequests{
 		MXCToResult: map[string]*types.RemoteRequestResult{},
 	}
 
-	downloadHandler := makeDownloadAPI("download", &cfg.MediaAPI, rateLimits, db, client, activeRemoteRequests, activeThumbnailGeneration)
+	downloadHandler := makeDownloadAPI("download", &cfg.MediaAPI, rateLimits, db, store, client, nil, activeRemoteRequests, activeThumbnailGeneration)
 	v3mux.Handle("/download/{serverName}/{mediaId}", downloadHandler).Methods(http.MethodGet, http.MethodOptions)
 	v3mux.Handle("/download/{serverName}/{mediaId}/{downloadName}", downloadHandler).Methods(http.MethodGet, http.MethodOptions)
 
 	v3mux.Handle("/thumbnail/{serverName}/{mediaId}",
-		makeDownloadAPI("thumbnail", &cfg.MediaAPI, rateLimits, db, client, activeRemoteRequests, activeThumbnailGeneration),
+		makeDownloadAPI("thumbnail", &cfg.MediaAPI, rateLimits, db, store, client, nil, activeRemoteRequests, activeThumbnailGeneration),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	authenticatedDownloadHandler := makeDownloadAPI("download", &cfg.MediaAPI, rateLimits, db, store, client, userAPI, activeRemoteRequests, activeThumbnailGeneration)
+	v1mux.Handle("/download/{serverName}/{mediaId}", authenticatedDownloadHandler).Methods(http.MethodGet, http.MethodOptions)
+	v1mux.Handle("/download/{serverName}/{mediaId}/{downloadName}", authenticatedDownloadHandler).Methods(http.MethodGet, http.MethodOptions)
+
+	v1mux.Handle("/thumbnail/{serverName}/{mediaId}",
+		makeDownloadAPI("thumbnail", &cfg.MediaAPI, rateLimits, db, store, client, userAPI, activeRemoteRequests, activeThumbnailGeneration),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	v1fedmux.Handle("/download/{mediaId}",
+		makeFederationDownloadAPI("download", &cfg.MediaAPI, keys, db, store, activeThumbnailGeneration),
+	).Methods(http.MethodGet)
+	v1fedmux.Handle("/thumbnail/{mediaId}",
+		makeFederationDownloadAPI("thumbnail", &cfg.MediaAPI, keys, db, store, activeThumbnailGeneration),
+	).Methods(http.MethodGet)
+
+	dendriteAdminRouter.Handle("/admin/media/purge",
+		httputil.MakeAdminAPI("admin_media_purge", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminPurgeMedia(req, &cfg.MediaAPI, purger)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/media/{serverName}/{mediaId}/quarantine",
+		httputil.MakeAdminAPI("admin_media_quarantine", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminQuarantineMedia(req, device, purger, vars["serverName"], vars["mediaId"])
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/users/{userID}/media",
+		httputil.MakeAdminAPI("admin_list_user_media", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminListUserMedia(req, db, vars["userID"])
+		}),
 	).Methods(http.MethodGet, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/media/usage",
+		httputil.MakeAdminAPI("admin_list_media_usage", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminListMediaUsage(req, &cfg.MediaAPI, db)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/users/{userID}/media/quota",
+		httputil.MakeAdminAPI("admin_user_media_quota", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			if req.Method == http.MethodGet {
+				return AdminGetUserMediaQuota(req, &cfg.MediaAPI, db, vars["userID"])
+			}
+			return AdminSetUserMediaQuota(req, &cfg.MediaAPI, db, vars["userID"])
+		}),
+	).Methods(http.MethodGet, http.MethodPut, http.MethodDelete, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/users/{userID}/media/quarantine",
+		httputil.MakeAdminAPI("admin_quarantine_user_media", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminQuarantineUserMedia(req, device, purger, vars["userID"])
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+	dendriteAdminRouter.Handle("/admin/rooms/{roomID}/media/quarantine",
+		httputil.MakeAdminAPI("admin_quarantine_room_media", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return AdminQuarantineRoomMedia(req, device, rsAPI, purger, vars["roomID"])
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
 }
 
+// makeDownloadAPI returns the handler of a download or thumbnail endpoint. If a userAPI is
+// given, requests must be authenticated with an access token, as on the authenticated media
+// endpoints (MSC3916).
 func makeDownloadAPI(
 	name string,
 	cfg *config.MediaAPI,
 	rateLimits *httputil.RateLimits,
 	db storage.Database,
+	store mediastore.MediaStore,
 	client *fclient.Client,
+	userAPI userapi.QueryAcccessTokenAPI,
 	activeRemoteRequests *types.ActiveRemoteRequests,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
 ) http.HandlerFunc {
+	authenticated := userAPI != nil
+	metricsName := name
+	if authenticated {
+		metricsName = "authenticated_" + name
+	}
 	var counterVec *prometheus.CounterVec
 	if cfg.Matrix.Metrics.Enabled {
 		counterVec = promauto.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: name,
+				Name: metricsName,
 				Help: "Total number of media_api requests for either thumbnails or full downloads",
 			},
 			[]string{"code"},
@@ -127,10 +236,26 @@ func makeDownloadAPI(
 		// Content-Type will be overridden in case of returning file data, else we respond with JSON-formatted errors
 		w.Header().Set("Content-Type", "application/json")
 
+		var device *userapi.Device
+		if authenticated {
+			var resErr *util.JSONResponse
+			device, resErr = auth.VerifyUserFromRequest(req, userAPI)
+			if resErr == nil {
+				resErr = auth.VerifyAccountNotLocked(device)
+			}
+			if resErr != nil {
+				w.WriteHeader(resErr.Code)
+				if err := json.NewEncoder(w).Encode(resErr.JSON); err != nil {
+					util.GetLogger(req.Context()).WithError(err).Error("Failed to encode JSON response")
+				}
+				return
+			}
+		}
+
 		// Ratelimit requests
 		// NOTSPEC: The spec says everything at /media/ should be rate limited, but this causes issues with thumbnails (#2243)
 		if name != "thumbnail" {
-			if r := rateLimits.Limit(req, nil); r != nil {
+			if r := rateLimits.Limit(req, device); r != nil {
 				if err := json.NewEncoder(w).Encode(r); err != nil {
 					w.WriteHeader(http.StatusInternalServerError)
 					return
@@ -163,10 +288,12 @@ func makeDownloadAPI(
 			types.MediaID(vars["mediaId"]),
 			cfg,
 			db,
+			store,
 			client,
 			activeRemoteRequests,
 			activeThumbnailGeneration,
 			name == "thumbnail",
+			authenticated,
 			vars["downloadName"],
 		)
 	}
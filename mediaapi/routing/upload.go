@@ -22,11 +22,10 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"path"
 	"strings"
 
 	"github.com/matrix-org/dendrite/mediaapi/fileutils"
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/thumbnailer"
 	"github.com/matrix-org/dendrite/mediaapi/types"
@@ -57,13 +56,13 @@ type uploadResponse struct {
 // This implementation supports a configurable maximum file size limit in bytes. If a user tries to upload more than this, they will receive an error that their upload is too large.
 // Uploaded files are processed piece-wise to avoid DoS attacks which would starve the server of memory.
 // TODO: We should time out requests if they have not received any data within a configured timeout period.
-func Upload(req *http.Request, cfg *config.MediaAPI, dev *userapi.Device, db storage.Database, activeThumbnailGeneration *types.ActiveThumbnailGeneration) util.JSONResponse {
+func Upload(req *http.Request, cfg *config.MediaAPI, dev *userapi.Device, db storage.Database, store mediastore.MediaStore, activeThumbnailGeneration *types.ActiveThumbnailGeneration) util.JSONResponse {
 	r, resErr := parseAndValidateRequest(req, cfg, dev)
 	if resErr != nil {
 		return *resErr
 	}
 
-	if resErr = r.doUpload(req.Context(), req.Body, cfg, db, activeThumbnailGeneration); resErr != nil {
+	if resErr = r.doUpload(req.Context(), req.Body, cfg, db, store, activeThumbnailGeneration); resErr != nil {
 		return *resErr
 	}
 
@@ -86,6 +85,7 @@ func parseAndValidateRequest(req *http.Request, cfg *config.MediaAPI, dev *usera
 			ContentType:   types.ContentType(req.Header.Get("Content-Type")),
 			UploadName:    types.Filename(url.PathEscape(req.FormValue("filename"))),
 			UserID:        types.MatrixUserID(dev.UserID),
+			Authenticated: cfg.FreezeUnauthenticatedMedia,
 		},
 		Logger: util.GetLogger(req.Context()).WithField("Origin", cfg.Matrix.ServerName),
 	}
@@ -129,6 +129,7 @@ func (r *uploadRequest) doUpload(
 	reqReader io.Reader,
 	cfg *config.MediaAPI,
 	db storage.Database,
+	store mediastore.MediaStore,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
 ) *util.JSONResponse {
 	r.Logger.WithFields(log.Fields{
@@ -189,6 +190,19 @@ func (r *uploadRequest) doUpload(
 			JSON: spec.InternalServerError{},
 		}
 	}
+	if existingMetadata != nil && existingMetadata.QuarantinedBy != "" {
+		// The file was taken down, so it must not be uploaded again.
+		fileutils.RemoveDir(tmpDir, r.Logger)
+		r.Logger.WithField("Base64Hash", hash).Warn("Rejected upload of quarantined media")
+		return &util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.Forbidden("This media has been quarantined"),
+		}
+	}
+	if resErr := r.checkQuota(ctx, cfg, db, bytesWritten); resErr != nil {
+		fileutils.RemoveDir(tmpDir, r.Logger)
+		return resErr
+	}
 	if existingMetadata != nil {
 		// The file already exists, delete the uploaded temporary file.
 		defer fileutils.RemoveDir(tmpDir, r.Logger)
@@ -212,6 +226,7 @@ func (r *uploadRequest) doUpload(
 			UploadName:        r.MediaMetadata.UploadName,
 			Base64Hash:        hash,
 			UserID:            r.MediaMetadata.UserID,
+			Authenticated:     r.MediaMetadata.Authenticated,
 		}
 	} else {
 		// The file doesn't exist. Update the request metadata.
@@ -237,11 +252,44 @@ func (r *uploadRequest) doUpload(
 	}).Info("File uploaded")
 
 	return r.storeFileAndMetadata(
-		ctx, tmpDir, cfg.AbsBasePath, db, cfg.ThumbnailSizes,
+		ctx, tmpDir, store, db, cfg.ThumbnailSizes,
 		activeThumbnailGeneration, cfg.MaxThumbnailGenerators,
 	)
 }
 
+// checkQuota returns an error response if storing a file of the given size would
+// exceed the media quota of the uploader.
+func (r *uploadRequest) checkQuota(
+	ctx context.Context, cfg *config.MediaAPI, db storage.Database, size types.FileSizeBytes,
+) *util.JSONResponse {
+	if r.MediaMetadata.UserID == "" {
+		return nil
+	}
+	usage, err := db.GetMediaUsage(ctx, r.MediaMetadata.UserID)
+	if err != nil {
+		r.Logger.WithError(err).Error("Error querying the media usage of the user.")
+		return &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	quota := usage.Quota(types.FileSizeBytes(cfg.DefaultUserQuotaBytes))
+	if quota > 0 && usage.UsedBytes+size > quota {
+		r.Logger.WithFields(log.Fields{
+			"UsedBytes":  usage.UsedBytes,
+			"QuotaBytes": quota,
+		}).Info("Rejected upload exceeding the media quota of the user")
+		return &util.JSONResponse{
+			Code: http.StatusRequestEntityTooLarge,
+			JSON: spec.MatrixError{
+				ErrCode: "M_TOO_LARGE",
+				Err:     fmt.Sprintf("Uploading this file would exceed your media quota (%v bytes).", quota),
+			},
+		}
+	}
+	return nil
+}
+
 func requestEntityTooLargeJSONResponse(maxFileSizeBytes config.FileSizeBytes) *util.JSONResponse {
 	return &util.JSONResponse{
 		Code: http.StatusRequestEntityTooLarge,
@@ -277,21 +325,21 @@ func (r *uploadRequest) Validate(maxFileSizeBytes config.FileSizeBytes) *util.JS
 	return nil
 }
 
-// storeFileAndMetadata moves the temporary file to its final path based on metadata and stores the metadata in the database
-// See getPathFromMediaMetadata in fileutils for details of the final path.
+// storeFileAndMetadata moves the temporary file into the media store based on metadata and stores the metadata in the database
+// See mediastore.MediaKey for details of the key the file is stored under.
 // The order of operations is important as it avoids metadata entering the database before the file
 // is ready, and if we fail to move the file, it never gets added to the database.
 // Returns a util.JSONResponse error and cleans up directories in case of error.
 func (r *uploadRequest) storeFileAndMetadata(
 	ctx context.Context,
 	tmpDir types.Path,
-	absBasePath config.Path,
+	store mediastore.MediaStore,
 	db storage.Database,
 	thumbnailSizes []config.ThumbnailSize,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
 	maxThumbnailGenerators int,
 ) *util.JSONResponse {
-	finalPath, duplicate, err := fileutils.MoveFileWithHashCheck(tmpDir, r.MediaMetadata, absBasePath, r.Logger)
+	finalKey, duplicate, err := fileutils.MoveFileWithHashCheck(ctx, tmpDir, r.MediaMetadata, store, r.Logger)
 	if err != nil {
 		r.Logger.WithError(err).Error("Failed to move file.")
 		return &util.JSONResponse{
@@ -300,7 +348,7 @@ func (r *uploadRequest) storeFileAndMetadata(
 		}
 	}
 	if duplicate {
-		r.Logger.WithField("dst", finalPath).Info("File was stored previously - discarding duplicate")
+		r.Logger.WithField("dst", finalKey).Info("File was stored previously - discarding duplicate")
 	}
 
 	if err = db.StoreMediaMetadata(ctx, r.MediaMetadata); err != nil {
@@ -309,7 +357,9 @@ func (r *uploadRequest) storeFileAndMetadata(
 		// there is valid metadata in the database for that file. As such we only
 		// remove the file if it is not a duplicate.
 		if !duplicate {
-			fileutils.RemoveDir(types.Path(path.Dir(string(finalPath))), r.Logger)
+			if err = store.Delete(ctx, finalKey); err != nil {
+				r.Logger.WithError(err).WithField("key", finalKey).Warn("Failed to remove file")
+			}
 		}
 		return &util.JSONResponse{
 			Code: http.StatusBadRequest,
@@ -318,7 +368,7 @@ func (r *uploadRequest) storeFileAndMetadata(
 	}
 
 	go func() {
-		file, err := os.Open(string(finalPath))
+		file, _, err := store.Open(context.Background(), finalKey)
 		if err != nil {
 			r.Logger.WithError(err).Error("unable to open file")
 			return
@@ -326,20 +376,20 @@ func (r *uploadRequest) storeFileAndMetadata(
 		defer file.Close() // nolint: errcheck
 		// http.DetectContentType only needs 512 bytes
 		buf := make([]byte, 512)
-		_, err = file.Read(buf)
-		if err != nil {
+		n, err := io.ReadFull(file, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
 			r.Logger.WithError(err).Error("unable to read file")
 			return
 		}
 		// Check if we need to generate thumbnails
-		fileType := http.DetectContentType(buf)
+		fileType := http.DetectContentType(buf[:n])
 		if !strings.HasPrefix(fileType, "image") {
 			r.Logger.WithField("contentType", fileType).Debugf("uploaded file is not an image or can not be thumbnailed, not generating thumbnails")
 			return
 		}
 
 		busy, err := thumbnailer.GenerateThumbnails(
-			context.Background(), finalPath, thumbnailSizes, r.MediaMetadata,
+			context.Background(), store, finalKey, thumbnailSizes, r.MediaMetadata,
 			activeThumbnailGeneration, maxThumbnailGenerators, db, r.Logger,
 		)
 		if err != nil {
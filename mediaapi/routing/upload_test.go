@@ -11,6 +11,7 @@ import (
 
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/mediaapi/fileutils"
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -28,6 +29,7 @@ func Test_uploadRequest_doUpload(t *testing.T) {
 		reqReader                 io.Reader
 		cfg                       *config.MediaAPI
 		db                        storage.Database
+		store                     mediastore.MediaStore
 		activeThumbnailGeneration *types.ActiveThumbnailGeneration
 	}
 
@@ -60,6 +62,7 @@ func Test_uploadRequest_doUpload(t *testing.T) {
 	if err != nil {
 		t.Errorf("error opening mediaapi database: %v", err)
 	}
+	store := mediastore.NewFilesystemStore(config.Path(testdataPath))
 
 	tests := []struct {
 		name   string
@@ -74,6 +77,7 @@ func Test_uploadRequest_doUpload(t *testing.T) {
 				reqReader: strings.NewReader("test"),
 				cfg:       cfg,
 				db:        db,
+				store:     store,
 			},
 			fields: fields{
 				Logger: logger,
@@ -91,6 +95,7 @@ func Test_uploadRequest_doUpload(t *testing.T) {
 				reqReader: strings.NewReader("testtest"),
 				cfg:       cfg,
 				db:        db,
+				store:     store,
 			},
 			fields: fields{
 				Logger: logger,
@@ -108,6 +113,7 @@ func Test_uploadRequest_doUpload(t *testing.T) {
 				reqReader: strings.NewReader("test test test"),
 				cfg:       cfg,
 				db:        db,
+				store:     store,
 			},
 			fields: fields{
 				Logger: logger,
@@ -129,7 +135,8 @@ func Test_uploadRequest_doUpload(t *testing.T) {
 					AbsBasePath:       config.Path(testdataPath),
 					DynamicThumbnails: false,
 				},
-				db: db,
+				db:    db,
+				store: store,
 			},
 			fields: fields{
 				Logger: logger,
@@ -146,7 +153,7 @@ func Test_uploadRequest_doUpload(t *testing.T) {
 				MediaMetadata: tt.fields.MediaMetadata,
 				Logger:        tt.fields.Logger,
 			}
-			if got := r.doUpload(tt.args.ctx, tt.args.reqReader, tt.args.cfg, tt.args.db, tt.args.activeThumbnailGeneration); !reflect.DeepEqual(got, tt.want) {
+			if got := r.doUpload(tt.args.ctx, tt.args.reqReader, tt.args.cfg, tt.args.db, tt.args.store, tt.args.activeThumbnailGeneration); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("doUpload() = %+v, want %+v", got, tt.want)
 			}
 		})
@@ -0,0 +1,399 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // Register the GIF decoder for image sizes
+	_ "image/jpeg" // Register the JPEG decoder for image sizes
+	_ "image/png"  // Register the PNG decoder for image sizes
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// errDeniedIP is returned when connecting to an IP address in the denied IP ranges.
+type errDeniedIP struct {
+	IP string
+}
+
+func (e errDeniedIP) Error() string {
+	return fmt.Sprintf("IP address %s is in a denied IP range", e.IP)
+}
+
+// newURLPreviewClient returns the HTTP client used to fetch pages and images for
+// previews. The addresses are checked when connecting rather than when resolving
+// the URL, so that redirects or DNS changes can't be used to reach denied IPs.
+func newURLPreviewClient(cfg *config.URLPreviews) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return errDeniedIP{IP: host}
+			}
+			for _, ipNet := range cfg.DeniedIPNets {
+				if ipNet.Contains(ip) {
+					return errDeniedIP{IP: host}
+				}
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: cfg.Timeout,
+		// Proxies aren't used, as they would connect to denied IPs for us.
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: cfg.Timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     time.Minute,
+		},
+	}
+}
+
+// PreviewURL implements GET /preview_url
+// The preview is taken from the OpenGraph metadata of the page, falling back to
+// the HTML title and description. Images are stored in the media repository.
+// https://spec.matrix.org/v1.8/client-server-api/#get_matrixmediav3preview_url
+func PreviewURL(
+	req *http.Request,
+	cfg *config.MediaAPI,
+	dev *userapi.Device,
+	db storage.Database,
+	store mediastore.MediaStore,
+	client *http.Client,
+	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
+) util.JSONResponse {
+	pageURL := req.URL.Query().Get("url")
+	if pageURL == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.MissingParam("Missing url parameter"),
+		}
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("url must be an absolute http or https URL"),
+		}
+	}
+	now := spec.AsTimestamp(time.Now())
+	ts := now
+	if tsParam := req.URL.Query().Get("ts"); tsParam != "" {
+		parsed, parseErr := strconv.ParseInt(tsParam, 10, 64)
+		if parseErr != nil || parsed < 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("ts must be a timestamp in milliseconds"),
+			}
+		}
+		if spec.Timestamp(parsed) < now {
+			ts = spec.Timestamp(parsed)
+		}
+	}
+
+	logger := util.GetLogger(req.Context()).WithField("url", pageURL)
+	if preview, cacheErr := getCachedURLPreview(req.Context(), db, pageURL, ts, now, cfg.URLPreviews.CacheLifetime); cacheErr != nil {
+		logger.WithError(cacheErr).Error("Failed to query the URL preview cache")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	} else if preview != nil {
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: preview.OpenGraph,
+		}
+	}
+
+	og, err := fetchURLPreview(req.Context(), u, cfg, dev, db, store, client, activeThumbnailGeneration, logger)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to preview URL")
+		var deniedErr errDeniedIP
+		if errors.As(err, &deniedErr) {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: spec.Forbidden("URL previews of this address are not allowed"),
+			}
+		}
+		return util.JSONResponse{
+			Code: http.StatusBadGateway,
+			JSON: spec.Unknown("Failed to preview URL"),
+		}
+	}
+	ogJSON, err := json.Marshal(og)
+	if err != nil {
+		logger.WithError(err).Error("Failed to marshal URL preview")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if err = db.StoreURLPreview(req.Context(), &types.URLPreview{
+		URL:       pageURL,
+		Timestamp: now,
+		OpenGraph: ogJSON,
+	}); err != nil {
+		logger.WithError(err).Warn("Failed to cache URL preview")
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: json.RawMessage(ogJSON),
+	}
+}
+
+// getCachedURLPreview returns the preview of the URL which was current at the
+// requested time, or else a preview which is still current now. Returns nil if
+// the URL needs to be fetched again.
+func getCachedURLPreview(
+	ctx context.Context, db storage.Database,
+	pageURL string, ts, now spec.Timestamp, lifetime time.Duration,
+) (*types.URLPreview, error) {
+	lifetimeMS := spec.Timestamp(lifetime.Milliseconds())
+	times := []spec.Timestamp{now}
+	if ts != now {
+		times = []spec.Timestamp{ts, now}
+	}
+	for _, at := range times {
+		preview, err := db.GetURLPreview(ctx, pageURL, at)
+		if err != nil {
+			return nil, err
+		}
+		if preview != nil && preview.Timestamp+lifetimeMS >= at {
+			return preview, nil
+		}
+	}
+	return nil, nil
+}
+
+// fetchURLPreview downloads the page and returns its OpenGraph metadata.
+func fetchURLPreview(
+	ctx context.Context,
+	u *url.URL,
+	cfg *config.MediaAPI,
+	dev *userapi.Device,
+	db storage.Database,
+	store mediastore.MediaStore,
+	client *http.Client,
+	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
+	logger *log.Entry,
+) (map[string]interface{}, error) {
+	resp, err := fetchURL(ctx, client, u, &cfg.URLPreviews)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	og := map[string]interface{}{}
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		if err = storePreviewImage(ctx, resp, og, cfg, dev, db, store, activeThumbnailGeneration, logger); err != nil {
+			return nil, err
+		}
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		body, err := charset.NewReader(io.LimitReader(resp.Body, int64(cfg.URLPreviews.MaxPageSizeBytes)), contentType)
+		if err != nil {
+			return nil, fmt.Errorf("charset.NewReader: %w", err)
+		}
+		og = parseOpenGraph(body)
+		imageURL, ok := og["og:image"].(string)
+		if !ok || imageURL == "" {
+			break
+		}
+		// Pages without an image still get a preview, so failing to store the
+		// image doesn't fail the preview. The image properties are set again
+		// once the image is stored.
+		for property := range og {
+			if strings.HasPrefix(property, "og:image") {
+				delete(og, property)
+			}
+		}
+		imageU, err := resp.Request.URL.Parse(imageURL)
+		if err != nil || (imageU.Scheme != "http" && imageU.Scheme != "https") {
+			break
+		}
+		imageResp, err := fetchURL(ctx, client, imageU, &cfg.URLPreviews)
+		if err != nil {
+			logger.WithError(err).WithField("image_url", imageU.String()).Warn("Failed to fetch preview image")
+			break
+		}
+		defer imageResp.Body.Close() // nolint: errcheck
+		if err = storePreviewImage(ctx, imageResp, og, cfg, dev, db, store, activeThumbnailGeneration, logger); err != nil {
+			logger.WithError(err).WithField("image_url", imageU.String()).Warn("Failed to store preview image")
+		}
+	}
+	if _, ok := og["og:url"]; !ok {
+		og["og:url"] = resp.Request.URL.String()
+	}
+	return og, nil
+}
+
+func fetchURL(ctx context.Context, client *http.Client, u *url.URL, cfg *config.URLPreviews) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", cfg.UserAgent)
+	req.Header.Set("Accept", "text/html, application/xhtml+xml, image/*;q=0.9, */*;q=0.8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// storePreviewImage stores the image in the response in the media repository,
+// generating thumbnails for it like for uploads, and adds it to the preview.
+func storePreviewImage(
+	ctx context.Context,
+	resp *http.Response,
+	og map[string]interface{},
+	cfg *config.MediaAPI,
+	dev *userapi.Device,
+	db storage.Database,
+	store mediastore.MediaStore,
+	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
+	logger *log.Entry,
+) error {
+	maxSize := int64(cfg.URLPreviews.MaxPageSizeBytes)
+	if cfg.MaxFileSizeBytes > 0 && int64(cfg.MaxFileSizeBytes) < maxSize {
+		maxSize = int64(cfg.MaxFileSizeBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return fmt.Errorf("io.ReadAll: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return fmt.Errorf("image is larger than %d bytes", maxSize)
+	}
+
+	r := &uploadRequest{
+		MediaMetadata: &types.MediaMetadata{
+			Origin:        cfg.Matrix.ServerName,
+			FileSizeBytes: types.FileSizeBytes(len(data)),
+			ContentType:   types.ContentType(resp.Header.Get("Content-Type")),
+			UploadName:    types.Filename(url.PathEscape(path.Base(resp.Request.URL.Path))),
+			UserID:        types.MatrixUserID(dev.UserID),
+			Authenticated: cfg.FreezeUnauthenticatedMedia,
+		},
+		Logger: logger.WithField("Origin", cfg.Matrix.ServerName),
+	}
+	if resErr := r.doUpload(ctx, bytes.NewReader(data), cfg, db, store, activeThumbnailGeneration); resErr != nil {
+		return fmt.Errorf("failed to store image: %+v", resErr.JSON)
+	}
+
+	og["og:image"] = fmt.Sprintf("mxc://%s/%s", cfg.Matrix.ServerName, r.MediaMetadata.MediaID)
+	og["og:image:type"] = string(r.MediaMetadata.ContentType)
+	og["matrix:image:size"] = len(data)
+	if imageConfig, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		og["og:image:width"] = imageConfig.Width
+		og["og:image:height"] = imageConfig.Height
+	}
+	return nil
+}
+
+// parseOpenGraph returns the OpenGraph metadata in the head of the HTML page,
+// using the title and description of the page if they aren't set.
+func parseOpenGraph(body io.Reader) map[string]interface{} {
+	og := map[string]interface{}{}
+	var title, description string
+	tokenizer := html.NewTokenizer(body)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return withFallbacks(og, title, description)
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "head" {
+				return withFallbacks(og, title, description)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			switch string(name) {
+			case "body":
+				return withFallbacks(og, title, description)
+			case "title":
+				if title == "" && tokenizer.Next() == html.TextToken {
+					title = strings.TrimSpace(string(tokenizer.Text()))
+				}
+			case "meta":
+				var property, content string
+				for hasAttr {
+					var key, value []byte
+					key, value, hasAttr = tokenizer.TagAttr()
+					switch string(key) {
+					case "property", "name":
+						property = strings.ToLower(string(value))
+					case "content":
+						content = strings.TrimSpace(string(value))
+					}
+				}
+				switch {
+				case content == "":
+				case strings.HasPrefix(property, "og:"):
+					if _, ok := og[property]; !ok {
+						og[property] = content
+					}
+				case property == "description" && description == "":
+					description = content
+				}
+			}
+		}
+	}
+}
+
+func withFallbacks(og map[string]interface{}, title, description string) map[string]interface{} {
+	if _, ok := og["og:title"]; !ok && title != "" {
+		og["og:title"] = title
+	}
+	if _, ok := og["og:description"]; !ok && description != "" {
+		og["og:description"] = description
+	}
+	return og
+}
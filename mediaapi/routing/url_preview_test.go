@@ -0,0 +1,186 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
+	"github.com/matrix-org/dendrite/mediaapi/storage"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseOpenGraph(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want map[string]interface{}
+	}{
+		{
+			name: "OpenGraph properties",
+			page: `<html><head>
+				<meta property="og:title" content="OpenGraph title">
+				<meta property="og:description" content="OpenGraph description" />
+				<meta property="og:title" content="ignored duplicate">
+				<title>HTML title</title>
+			</head><body><meta property="og:site_name" content="ignored in body"></body></html>`,
+			want: map[string]interface{}{
+				"og:title":       "OpenGraph title",
+				"og:description": "OpenGraph description",
+			},
+		},
+		{
+			name: "HTML fallbacks",
+			page: `<html><head>
+				<title> HTML title </title>
+				<meta name="description" content="HTML description">
+				<meta name="og:site_name" content="Site">
+			</head></html>`,
+			want: map[string]interface{}{
+				"og:title":       "HTML title",
+				"og:description": "HTML description",
+				"og:site_name":   "Site",
+			},
+		},
+		{
+			name: "no metadata",
+			page: `not even html`,
+			want: map[string]interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseOpenGraph(strings.NewReader(tt.page)))
+		})
+	}
+}
+
+func TestPreviewURL(t *testing.T) {
+	var pageRequests atomic.Int32
+	var imageBuf bytes.Buffer
+	if err := png.Encode(&imageBuf, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		pageRequests.Add(1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<html><head>
+			<title>Test page</title>
+			<meta property="og:image" content="/image.png">
+			<meta property="og:image:width" content="1000">
+		</head></html>`))
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(imageBuf.Bytes())
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	basePath, err := os.MkdirTemp("", "mediaapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath) // nolint: errcheck
+	cfg := &config.MediaAPI{
+		Matrix:           &config.Global{},
+		BasePath:         config.Path(basePath),
+		AbsBasePath:      config.Path(basePath),
+		MaxFileSizeBytes: config.DefaultMaxFileSizeBytes,
+	}
+	cfg.Matrix.ServerName = "test"
+	cfg.URLPreviews.Defaults()
+	cfg.URLPreviews.Enabled = true
+	cfg.URLPreviews.DeniedIPRanges = nil
+
+	connStr, closeDB := test.PrepareDBConnectionString(t, test.DBTypeSQLite)
+	defer closeDB()
+	cm := sqlutil.NewConnectionManager(nil, config.DatabaseOptions{})
+	db, err := storage.NewMediaAPIDatasource(cm, &config.DatabaseOptions{ConnectionString: config.DataSource(connStr)})
+	if err != nil {
+		t.Fatalf("failed to open media database: %s", err)
+	}
+	store := mediastore.NewFilesystemStore(cfg.AbsBasePath)
+	activeThumbnailGeneration := &types.ActiveThumbnailGeneration{
+		PathToResult: map[string]*types.ThumbnailGenerationResult{},
+	}
+	dev := &userapi.Device{UserID: "@alice:test"}
+
+	preview := func(t *testing.T, client *http.Client, pageURL string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/preview_url?url="+url.QueryEscape(pageURL), nil)
+		res := PreviewURL(req, cfg, dev, db, store, client, activeThumbnailGeneration)
+		og := map[string]interface{}{}
+		body, err := json.Marshal(res.JSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = json.Unmarshal(body, &og); err != nil {
+			t.Fatal(err)
+		}
+		return res.Code, og
+	}
+
+	t.Run("page is previewed and cached", func(t *testing.T) {
+		client := newURLPreviewClient(&cfg.URLPreviews)
+		code, og := preview(t, client, srv.URL+"/page")
+		if code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %v", code, og)
+		}
+		assert.Equal(t, "Test page", og["og:title"])
+		assert.Equal(t, srv.URL+"/page", og["og:url"])
+		assert.Equal(t, "image/png", og["og:image:type"])
+		assert.Equal(t, float64(3), og["og:image:width"])
+		assert.Equal(t, float64(2), og["og:image:height"])
+		assert.Equal(t, float64(imageBuf.Len()), og["matrix:image:size"])
+		mxc, _ := og["og:image"].(string)
+		if !strings.HasPrefix(mxc, "mxc://test/") {
+			t.Fatalf("expected the image to be stored, got %q", mxc)
+		}
+		metadata, err := db.GetMediaMetadata(context.Background(), types.MediaID(strings.TrimPrefix(mxc, "mxc://test/")), "test")
+		if err != nil || metadata == nil {
+			t.Fatalf("expected the image metadata to be stored: %v", err)
+		}
+
+		_, cached := preview(t, client, srv.URL+"/page")
+		assert.Equal(t, og, cached)
+		assert.Equal(t, int32(1), pageRequests.Load(), "expected the page to be fetched once")
+	})
+
+	t.Run("failed requests are not previewed", func(t *testing.T) {
+		client := newURLPreviewClient(&cfg.URLPreviews)
+		code, _ := preview(t, client, srv.URL+"/missing")
+		assert.Equal(t, http.StatusBadGateway, code)
+		code, _ = preview(t, client, "ftp://example.com/file")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("denied IP ranges are not fetched", func(t *testing.T) {
+		deniedCfg := cfg.URLPreviews
+		_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+		_, loopback6, _ := net.ParseCIDR("::1/128")
+		deniedCfg.DeniedIPNets = []*net.IPNet{loopback, loopback6}
+		deniedCfg.Timeout = time.Second
+		client := newURLPreviewClient(&deniedCfg)
+		code, _ := preview(t, client, srv.URL+"/missing")
+		assert.Equal(t, http.StatusForbidden, code)
+	})
+}
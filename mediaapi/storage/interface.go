@@ -24,12 +24,21 @@ import (
 type Database interface {
 	MediaRepository
 	Thumbnails
+	URLPreviews
+	MediaUsage
 }
 
 type MediaRepository interface {
 	StoreMediaMetadata(ctx context.Context, mediaMetadata *types.MediaMetadata) error
 	GetMediaMetadata(ctx context.Context, mediaID types.MediaID, mediaOrigin spec.ServerName) (*types.MediaMetadata, error)
 	GetMediaMetadataByHash(ctx context.Context, mediaHash types.Base64Hash, mediaOrigin spec.ServerName) (*types.MediaMetadata, error)
+	UpdateMediaLastAccess(ctx context.Context, mediaID types.MediaID, mediaOrigin spec.ServerName, ts spec.Timestamp) error
+	GetRemoteMediaAccessedBefore(ctx context.Context, localServerNames []spec.ServerName, ts spec.Timestamp, limit int) ([]*types.MediaMetadata, error)
+	GetLocalMediaAccessedBefore(ctx context.Context, localServerNames []spec.ServerName, ts spec.Timestamp, limit int) ([]*types.MediaMetadata, error)
+	GetLocalMediaCreatedBefore(ctx context.Context, localServerNames []spec.ServerName, ts spec.Timestamp, limit int) ([]*types.MediaMetadata, error)
+	DeleteMedia(ctx context.Context, mediaMetadata *types.MediaMetadata) (thumbnails []*types.ThumbnailMetadata, fileInUse bool, err error)
+	QuarantineMedia(ctx context.Context, mediaID types.MediaID, mediaOrigin spec.ServerName, quarantinedBy types.MatrixUserID) (quarantined []*types.MediaMetadata, thumbnails []*types.ThumbnailMetadata, err error)
+	GetMediaByUser(ctx context.Context, userID types.MatrixUserID, from, limit int) (media []*types.MediaMetadata, total int, err error)
 }
 
 type Thumbnails interface {
@@ -37,3 +46,14 @@ type Thumbnails interface {
 	GetThumbnail(ctx context.Context, mediaID types.MediaID, mediaOrigin spec.ServerName, width, height int, resizeMethod string) (*types.ThumbnailMetadata, error)
 	GetThumbnails(ctx context.Context, mediaID types.MediaID, mediaOrigin spec.ServerName) ([]*types.ThumbnailMetadata, error)
 }
+
+type MediaUsage interface {
+	GetMediaUsage(ctx context.Context, userID types.MatrixUserID) (*types.MediaUsage, error)
+	GetLargestMediaUsage(ctx context.Context, from, limit int) (usage []*types.MediaUsage, total int, err error)
+	SetMediaQuota(ctx context.Context, userID types.MatrixUserID, quota *types.FileSizeBytes) error
+}
+
+type URLPreviews interface {
+	StoreURLPreview(ctx context.Context, preview *types.URLPreview) error
+	GetURLPreview(ctx context.Context, url string, ts spec.Timestamp) (*types.URLPreview, error)
+}
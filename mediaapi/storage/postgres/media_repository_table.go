@@ -20,7 +20,11 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/lib/pq"
+
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/storage/postgres/deltas"
 	"github.com/matrix-org/dendrite/mediaapi/storage/tables"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/gomatrixserverlib/spec"
@@ -47,28 +51,92 @@ CREATE TABLE IF NOT EXISTS mediaapi_media_repository (
     -- Alternate RFC 4648 unpadded base64 encoding string representation of a SHA-256 hash sum of the file data.
     base64hash TEXT NOT NULL,
     -- The user who uploaded the file. Should be a Matrix user ID.
-    user_id TEXT NOT NULL
+    user_id TEXT NOT NULL,
+    -- When the media was last downloaded or thumbnailed in UNIX epoch ms, used to expire media.
+    last_access_ts BIGINT NOT NULL DEFAULT 0,
+    -- The user who quarantined the media, or empty if it isn't quarantined.
+    quarantined_by TEXT NOT NULL DEFAULT '',
+    -- Whether the media can only be downloaded using the authenticated media endpoints.
+    authenticated BOOLEAN NOT NULL DEFAULT FALSE
 );
 CREATE UNIQUE INDEX IF NOT EXISTS mediaapi_media_repository_index ON mediaapi_media_repository (media_id, media_origin);
+CREATE INDEX IF NOT EXISTS mediaapi_media_repository_user_id_idx ON mediaapi_media_repository (user_id);
 `
 
 const insertMediaSQL = `
-INSERT INTO mediaapi_media_repository (media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+INSERT INTO mediaapi_media_repository (media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, last_access_ts, quarantined_by, authenticated)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $5, $9, $10)
 `
 
 const selectMediaSQL = `
-SELECT content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id FROM mediaapi_media_repository WHERE media_id = $1 AND media_origin = $2
+SELECT content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, last_access_ts, quarantined_by, authenticated FROM mediaapi_media_repository WHERE media_id = $1 AND media_origin = $2
 `
 
+// Quarantined media with the same hash from any origin is preferred, so that
+// quarantined files can't be uploaded or fetched again under a different media ID.
 const selectMediaByHashSQL = `
-SELECT content_type, file_size_bytes, creation_ts, upload_name, media_id, user_id FROM mediaapi_media_repository WHERE base64hash = $1 AND media_origin = $2
+SELECT content_type, file_size_bytes, creation_ts, upload_name, media_id, media_origin, user_id, last_access_ts, quarantined_by, authenticated FROM mediaapi_media_repository
+    WHERE base64hash = $1 AND (media_origin = $2 OR quarantined_by != '') ORDER BY quarantined_by DESC LIMIT 1
+`
+
+const updateMediaLastAccessSQL = `
+UPDATE mediaapi_media_repository SET last_access_ts = $1 WHERE media_id = $2 AND media_origin = $3
+`
+
+const selectRemoteMediaAccessedBeforeSQL = `
+SELECT media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, last_access_ts, quarantined_by, authenticated FROM mediaapi_media_repository
+    WHERE media_origin != ALL($1) AND last_access_ts < $2 AND quarantined_by = '' ORDER BY last_access_ts ASC LIMIT $3
+`
+
+const selectLocalMediaAccessedBeforeSQL = `
+SELECT media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, last_access_ts, quarantined_by, authenticated FROM mediaapi_media_repository
+    WHERE media_origin = ANY($1) AND last_access_ts < $2 AND quarantined_by = '' ORDER BY last_access_ts ASC LIMIT $3
+`
+
+const selectLocalMediaCreatedBeforeSQL = `
+SELECT media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, last_access_ts, quarantined_by, authenticated FROM mediaapi_media_repository
+    WHERE media_origin = ANY($1) AND creation_ts < $2 AND quarantined_by = '' ORDER BY creation_ts ASC LIMIT $3
+`
+
+const selectMediaCountByHashSQL = `
+SELECT COUNT(*) FROM mediaapi_media_repository WHERE base64hash = $1
+`
+
+const selectMediaByHashAnyOriginSQL = `
+SELECT media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, last_access_ts, quarantined_by, authenticated FROM mediaapi_media_repository WHERE base64hash = $1
+`
+
+const selectMediaByUserSQL = `
+SELECT media_id, media_origin, content_type, file_size_bytes, creation_ts, upload_name, base64hash, user_id, last_access_ts, quarantined_by, authenticated FROM mediaapi_media_repository
+    WHERE user_id = $1 ORDER BY creation_ts DESC, media_id ASC LIMIT $2 OFFSET $3
+`
+
+const selectMediaCountByUserSQL = `
+SELECT COUNT(*) FROM mediaapi_media_repository WHERE user_id = $1
+`
+
+const updateMediaQuarantinedByHashSQL = `
+UPDATE mediaapi_media_repository SET quarantined_by = $1 WHERE base64hash = $2 AND quarantined_by = ''
+`
+
+const deleteMediaSQL = `
+DELETE FROM mediaapi_media_repository WHERE media_id = $1 AND media_origin = $2
 `
 
 type mediaStatements struct {
-	insertMediaStmt       *sql.Stmt
-	selectMediaStmt       *sql.Stmt
-	selectMediaByHashStmt *sql.Stmt
+	insertMediaStmt                     *sql.Stmt
+	selectMediaStmt                     *sql.Stmt
+	selectMediaByHashStmt               *sql.Stmt
+	updateMediaLastAccessStmt           *sql.Stmt
+	selectRemoteMediaAccessedBeforeStmt *sql.Stmt
+	selectLocalMediaAccessedBeforeStmt  *sql.Stmt
+	selectLocalMediaCreatedBeforeStmt   *sql.Stmt
+	selectMediaCountByHashStmt          *sql.Stmt
+	selectMediaByHashAnyOriginStmt      *sql.Stmt
+	selectMediaByUserStmt               *sql.Stmt
+	selectMediaCountByUserStmt          *sql.Stmt
+	updateMediaQuarantinedByHashStmt    *sql.Stmt
+	deleteMediaStmt                     *sql.Stmt
 }
 
 func NewPostgresMediaRepositoryTable(db *sql.DB) (tables.MediaRepository, error) {
@@ -77,11 +145,35 @@ func NewPostgresMediaRepositoryTable(db *sql.DB) (tables.MediaRepository, error)
 	if err != nil {
 		return nil, err
 	}
+	m := sqlutil.NewMigrator(db)
+	m.AddMigrations(sqlutil.Migration{
+		Version: "mediaapi: add last access timestamp",
+		Up:      deltas.UpMediaLastAccess,
+	}, sqlutil.Migration{
+		Version: "mediaapi: add quarantined by",
+		Up:      deltas.UpMediaQuarantine,
+	}, sqlutil.Migration{
+		Version: "mediaapi: add authenticated",
+		Up:      deltas.UpMediaAuthenticated,
+	})
+	if err = m.Up(context.Background()); err != nil {
+		return nil, err
+	}
 
 	return s, sqlutil.StatementList{
 		{&s.insertMediaStmt, insertMediaSQL},
 		{&s.selectMediaStmt, selectMediaSQL},
 		{&s.selectMediaByHashStmt, selectMediaByHashSQL},
+		{&s.updateMediaLastAccessStmt, updateMediaLastAccessSQL},
+		{&s.selectRemoteMediaAccessedBeforeStmt, selectRemoteMediaAccessedBeforeSQL},
+		{&s.selectLocalMediaAccessedBeforeStmt, selectLocalMediaAccessedBeforeSQL},
+		{&s.selectLocalMediaCreatedBeforeStmt, selectLocalMediaCreatedBeforeSQL},
+		{&s.selectMediaCountByHashStmt, selectMediaCountByHashSQL},
+		{&s.selectMediaByHashAnyOriginStmt, selectMediaByHashAnyOriginSQL},
+		{&s.selectMediaByUserStmt, selectMediaByUserSQL},
+		{&s.selectMediaCountByUserStmt, selectMediaCountByUserSQL},
+		{&s.updateMediaQuarantinedByHashStmt, updateMediaQuarantinedByHashSQL},
+		{&s.deleteMediaStmt, deleteMediaSQL},
 	}.Prepare(db)
 }
 
@@ -89,6 +181,7 @@ func (s *mediaStatements) InsertMedia(
 	ctx context.Context, txn *sql.Tx, mediaMetadata *types.MediaMetadata,
 ) error {
 	mediaMetadata.CreationTimestamp = spec.AsTimestamp(time.Now())
+	mediaMetadata.LastAccessTimestamp = mediaMetadata.CreationTimestamp
 	_, err := sqlutil.TxStmtContext(ctx, txn, s.insertMediaStmt).ExecContext(
 		ctx,
 		mediaMetadata.MediaID,
@@ -99,6 +192,8 @@ func (s *mediaStatements) InsertMedia(
 		mediaMetadata.UploadName,
 		mediaMetadata.Base64Hash,
 		mediaMetadata.UserID,
+		mediaMetadata.QuarantinedBy,
+		mediaMetadata.Authenticated,
 	)
 	return err
 }
@@ -119,6 +214,9 @@ func (s *mediaStatements) SelectMedia(
 		&mediaMetadata.UploadName,
 		&mediaMetadata.Base64Hash,
 		&mediaMetadata.UserID,
+		&mediaMetadata.LastAccessTimestamp,
+		&mediaMetadata.QuarantinedBy,
+		&mediaMetadata.Authenticated,
 	)
 	return &mediaMetadata, err
 }
@@ -138,7 +236,116 @@ func (s *mediaStatements) SelectMediaByHash(
 		&mediaMetadata.CreationTimestamp,
 		&mediaMetadata.UploadName,
 		&mediaMetadata.MediaID,
+		&mediaMetadata.Origin,
 		&mediaMetadata.UserID,
+		&mediaMetadata.LastAccessTimestamp,
+		&mediaMetadata.QuarantinedBy,
+		&mediaMetadata.Authenticated,
 	)
 	return &mediaMetadata, err
 }
+
+func (s *mediaStatements) UpdateMediaLastAccess(
+	ctx context.Context, txn *sql.Tx, mediaID types.MediaID, mediaOrigin spec.ServerName, ts spec.Timestamp,
+) error {
+	_, err := sqlutil.TxStmtContext(ctx, txn, s.updateMediaLastAccessStmt).ExecContext(ctx, ts, mediaID, mediaOrigin)
+	return err
+}
+
+func (s *mediaStatements) SelectRemoteMediaAccessedBefore(
+	ctx context.Context, txn *sql.Tx, localServerNames []spec.ServerName, ts spec.Timestamp, limit int,
+) ([]*types.MediaMetadata, error) {
+	return s.selectMediaList(ctx, txn, s.selectRemoteMediaAccessedBeforeStmt, serverNamesArray(localServerNames), ts, limit)
+}
+
+func (s *mediaStatements) SelectLocalMediaAccessedBefore(
+	ctx context.Context, txn *sql.Tx, localServerNames []spec.ServerName, ts spec.Timestamp, limit int,
+) ([]*types.MediaMetadata, error) {
+	return s.selectMediaList(ctx, txn, s.selectLocalMediaAccessedBeforeStmt, serverNamesArray(localServerNames), ts, limit)
+}
+
+func (s *mediaStatements) SelectLocalMediaCreatedBefore(
+	ctx context.Context, txn *sql.Tx, localServerNames []spec.ServerName, ts spec.Timestamp, limit int,
+) ([]*types.MediaMetadata, error) {
+	return s.selectMediaList(ctx, txn, s.selectLocalMediaCreatedBeforeStmt, serverNamesArray(localServerNames), ts, limit)
+}
+
+func serverNamesArray(serverNames []spec.ServerName) pq.StringArray {
+	names := make(pq.StringArray, len(serverNames))
+	for i, serverName := range serverNames {
+		names[i] = string(serverName)
+	}
+	return names
+}
+
+func (s *mediaStatements) selectMediaList(
+	ctx context.Context, txn *sql.Tx, stmt *sql.Stmt, params ...interface{},
+) ([]*types.MediaMetadata, error) {
+	rows, err := sqlutil.TxStmtContext(ctx, txn, stmt).QueryContext(ctx, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectMediaList: rows.close() failed")
+
+	var media []*types.MediaMetadata
+	for rows.Next() {
+		var mediaMetadata types.MediaMetadata
+		if err = rows.Scan(
+			&mediaMetadata.MediaID,
+			&mediaMetadata.Origin,
+			&mediaMetadata.ContentType,
+			&mediaMetadata.FileSizeBytes,
+			&mediaMetadata.CreationTimestamp,
+			&mediaMetadata.UploadName,
+			&mediaMetadata.Base64Hash,
+			&mediaMetadata.UserID,
+			&mediaMetadata.LastAccessTimestamp,
+			&mediaMetadata.QuarantinedBy,
+			&mediaMetadata.Authenticated,
+		); err != nil {
+			return nil, err
+		}
+		media = append(media, &mediaMetadata)
+	}
+	return media, rows.Err()
+}
+
+func (s *mediaStatements) SelectMediaCountByHash(
+	ctx context.Context, txn *sql.Tx, mediaHash types.Base64Hash,
+) (count int, err error) {
+	err = sqlutil.TxStmtContext(ctx, txn, s.selectMediaCountByHashStmt).QueryRowContext(ctx, mediaHash).Scan(&count)
+	return
+}
+
+func (s *mediaStatements) SelectMediaByHashAnyOrigin(
+	ctx context.Context, txn *sql.Tx, mediaHash types.Base64Hash,
+) ([]*types.MediaMetadata, error) {
+	return s.selectMediaList(ctx, txn, s.selectMediaByHashAnyOriginStmt, mediaHash)
+}
+
+func (s *mediaStatements) SelectMediaByUser(
+	ctx context.Context, txn *sql.Tx, userID types.MatrixUserID, from, limit int,
+) ([]*types.MediaMetadata, error) {
+	return s.selectMediaList(ctx, txn, s.selectMediaByUserStmt, userID, limit, from)
+}
+
+func (s *mediaStatements) SelectMediaCountByUser(
+	ctx context.Context, txn *sql.Tx, userID types.MatrixUserID,
+) (count int, err error) {
+	err = sqlutil.TxStmtContext(ctx, txn, s.selectMediaCountByUserStmt).QueryRowContext(ctx, userID).Scan(&count)
+	return
+}
+
+func (s *mediaStatements) UpdateMediaQuarantinedByHash(
+	ctx context.Context, txn *sql.Tx, mediaHash types.Base64Hash, quarantinedBy types.MatrixUserID,
+) error {
+	_, err := sqlutil.TxStmtContext(ctx, txn, s.updateMediaQuarantinedByHashStmt).ExecContext(ctx, quarantinedBy, mediaHash)
+	return err
+}
+
+func (s *mediaStatements) DeleteMedia(
+	ctx context.Context, txn *sql.Tx, mediaID types.MediaID, mediaOrigin spec.ServerName,
+) error {
+	_, err := sqlutil.TxStmtContext(ctx, txn, s.deleteMediaStmt).ExecContext(ctx, mediaID, mediaOrigin)
+	return err
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/storage/postgres/deltas"
+	"github.com/matrix-org/dendrite/mediaapi/storage/tables"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+)
+
+const mediaUsageSchema = `
+-- The mediaapi_user_media_usage table holds how much media each local user uploaded,
+-- and the quota of users whose quota differs from the default.
+CREATE TABLE IF NOT EXISTS mediaapi_user_media_usage (
+    -- The user who uploaded the media. Should be a Matrix user ID.
+    user_id TEXT NOT NULL PRIMARY KEY,
+    -- The total size in bytes of the media uploaded by the user.
+    used_bytes BIGINT NOT NULL DEFAULT 0,
+    -- The quota of the user in bytes, or NULL if the default quota applies. 0 means unlimited.
+    quota_bytes BIGINT
+);
+CREATE INDEX IF NOT EXISTS mediaapi_user_media_usage_used_bytes_idx ON mediaapi_user_media_usage (used_bytes);
+`
+
+const upsertUsedBytesSQL = `
+INSERT INTO mediaapi_user_media_usage (user_id, used_bytes) VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE SET used_bytes = mediaapi_user_media_usage.used_bytes + $2
+`
+
+const upsertQuotaSQL = `
+INSERT INTO mediaapi_user_media_usage (user_id, quota_bytes) VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE SET quota_bytes = $2
+`
+
+const selectUsageSQL = `
+SELECT used_bytes, quota_bytes FROM mediaapi_user_media_usage WHERE user_id = $1
+`
+
+const selectLargestUsageSQL = `
+SELECT user_id, used_bytes, quota_bytes FROM mediaapi_user_media_usage
+    WHERE used_bytes > 0 ORDER BY used_bytes DESC, user_id ASC LIMIT $1 OFFSET $2
+`
+
+const selectUsageCountSQL = `
+SELECT COUNT(*) FROM mediaapi_user_media_usage WHERE used_bytes > 0
+`
+
+type mediaUsageStatements struct {
+	upsertUsedBytesStmt    *sql.Stmt
+	upsertQuotaStmt        *sql.Stmt
+	selectUsageStmt        *sql.Stmt
+	selectLargestUsageStmt *sql.Stmt
+	selectUsageCountStmt   *sql.Stmt
+}
+
+func NewPostgresMediaUsageTable(db *sql.DB) (tables.MediaUsage, error) {
+	s := &mediaUsageStatements{}
+	_, err := db.Exec(mediaUsageSchema)
+	if err != nil {
+		return nil, err
+	}
+	m := sqlutil.NewMigrator(db)
+	m.AddMigrations(sqlutil.Migration{
+		Version: "mediaapi: compute user media usage",
+		Up:      deltas.UpMediaUsage,
+	})
+	if err = m.Up(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return s, sqlutil.StatementList{
+		{&s.upsertUsedBytesStmt, upsertUsedBytesSQL},
+		{&s.upsertQuotaStmt, upsertQuotaSQL},
+		{&s.selectUsageStmt, selectUsageSQL},
+		{&s.selectLargestUsageStmt, selectLargestUsageSQL},
+		{&s.selectUsageCountStmt, selectUsageCountSQL},
+	}.Prepare(db)
+}
+
+func (s *mediaUsageStatements) UpsertUsedBytes(
+	ctx context.Context, txn *sql.Tx, userID types.MatrixUserID, bytes types.FileSizeBytes,
+) error {
+	_, err := sqlutil.TxStmtContext(ctx, txn, s.upsertUsedBytesStmt).ExecContext(ctx, userID, bytes)
+	return err
+}
+
+func (s *mediaUsageStatements) UpsertQuota(
+	ctx context.Context, txn *sql.Tx, userID types.MatrixUserID, quota *types.FileSizeBytes,
+) error {
+	_, err := sqlutil.TxStmtContext(ctx, txn, s.upsertQuotaStmt).ExecContext(ctx, userID, quota)
+	return err
+}
+
+func (s *mediaUsageStatements) SelectUsage(
+	ctx context.Context, txn *sql.Tx, userID types.MatrixUserID,
+) (*types.MediaUsage, error) {
+	usage := types.MediaUsage{UserID: userID}
+	var quota sql.NullInt64
+	err := sqlutil.TxStmtContext(ctx, txn, s.selectUsageStmt).QueryRowContext(ctx, userID).Scan(
+		&usage.UsedBytes, &quota,
+	)
+	if quota.Valid {
+		usage.QuotaBytes = (*types.FileSizeBytes)(&quota.Int64)
+	}
+	return &usage, err
+}
+
+func (s *mediaUsageStatements) SelectLargestUsage(
+	ctx context.Context, txn *sql.Tx, from, limit int,
+) ([]*types.MediaUsage, error) {
+	rows, err := sqlutil.TxStmtContext(ctx, txn, s.selectLargestUsageStmt).QueryContext(ctx, limit, from)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectLargestUsage: rows.close() failed")
+
+	var usages []*types.MediaUsage
+	for rows.Next() {
+		var usage types.MediaUsage
+		var quota sql.NullInt64
+		if err = rows.Scan(&usage.UserID, &usage.UsedBytes, &quota); err != nil {
+			return nil, err
+		}
+		if quota.Valid {
+			usage.QuotaBytes = (*types.FileSizeBytes)(&quota.Int64)
+		}
+		usages = append(usages, &usage)
+	}
+	return usages, rows.Err()
+}
+
+func (s *mediaUsageStatements) SelectUsageCount(ctx context.Context, txn *sql.Tx) (count int, err error) {
+	err = sqlutil.TxStmtContext(ctx, txn, s.selectUsageCountStmt).QueryRowContext(ctx).Scan(&count)
+	return
+}
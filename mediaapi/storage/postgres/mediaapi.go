@@ -37,9 +37,19 @@ func NewDatabase(conMan *sqlutil.Connections, dbProperties *config.DatabaseOptio
 	if err != nil {
 		return nil, err
 	}
+	urlPreviews, err := NewPostgresURLPreviewsTable(db)
+	if err != nil {
+		return nil, err
+	}
+	mediaUsage, err := NewPostgresMediaUsageTable(db)
+	if err != nil {
+		return nil, err
+	}
 	return &shared.Database{
 		MediaRepository: mediaRepo,
 		Thumbnails:      thumbnails,
+		URLPreviews:     urlPreviews,
+		MediaUsage:      mediaUsage,
 		DB:              db,
 		Writer:          writer,
 	}, nil
@@ -0,0 +1,85 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/mediaapi/storage/tables"
+	"github.com/matrix-org/dendrite/mediaapi/types"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+)
+
+const urlPreviewsSchema = `
+-- The mediaapi_url_previews table caches the previews of URLs requested by clients.
+CREATE TABLE IF NOT EXISTS mediaapi_url_previews (
+    -- The URL which was previewed.
+    url TEXT NOT NULL,
+    -- When the URL was fetched in UNIX epoch ms.
+    ts BIGINT NOT NULL,
+    -- The preview as returned to clients, as JSON.
+    og TEXT NOT NULL,
+    PRIMARY KEY (url, ts)
+);
+`
+
+const insertURLPreviewSQL = `
+INSERT INTO mediaapi_url_previews (url, ts, og) VALUES ($1, $2, $3)
+    ON CONFLICT (url, ts) DO UPDATE SET og = $3
+`
+
+// Note: this selects the newest preview fetched at or before the given time
+const selectURLPreviewSQL = `
+SELECT ts, og FROM mediaapi_url_previews WHERE url = $1 AND ts <= $2 ORDER BY ts DESC LIMIT 1
+`
+
+type urlPreviewsStatements struct {
+	insertURLPreviewStmt *sql.Stmt
+	selectURLPreviewStmt *sql.Stmt
+}
+
+func NewPostgresURLPreviewsTable(db *sql.DB) (tables.URLPreviews, error) {
+	s := &urlPreviewsStatements{}
+	_, err := db.Exec(urlPreviewsSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, sqlutil.StatementList{
+		{&s.insertURLPreviewStmt, insertURLPreviewSQL},
+		{&s.selectURLPreviewStmt, selectURLPreviewSQL},
+	}.Prepare(db)
+}
+
+func (s *urlPreviewsStatements) InsertURLPreview(ctx context.Context, txn *sql.Tx, preview *types.URLPreview) error {
+	_, err := sqlutil.TxStmtContext(ctx, txn, s.insertURLPreviewStmt).ExecContext(
+		ctx, preview.URL, preview.Timestamp, string(preview.OpenGraph),
+	)
+	return err
+}
+
+func (s *urlPreviewsStatements) SelectURLPreview(
+	ctx context.Context, txn *sql.Tx, url string, ts spec.Timestamp,
+) (*types.URLPreview, error) {
+	preview := types.URLPreview{URL: url}
+	var og string
+	err := sqlutil.TxStmtContext(ctx, txn, s.selectURLPreviewStmt).QueryRowContext(
+		ctx, url, ts,
+	).Scan(&preview.Timestamp, &og)
+	preview.OpenGraph = []byte(og)
+	return &preview, err
+}
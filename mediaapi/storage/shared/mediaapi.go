@@ -29,13 +29,22 @@ type Database struct {
 	Writer          sqlutil.Writer
 	MediaRepository tables.MediaRepository
 	Thumbnails      tables.Thumbnails
+	URLPreviews     tables.URLPreviews
+	MediaUsage      tables.MediaUsage
 }
 
-// StoreMediaMetadata inserts the metadata about the uploaded media into the database.
+// StoreMediaMetadata inserts the metadata about the uploaded media into the database,
+// and adds its size to the media usage of the uploader.
 // Returns an error if the combination of MediaID and Origin are not unique in the table.
 func (d Database) StoreMediaMetadata(ctx context.Context, mediaMetadata *types.MediaMetadata) error {
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		return d.MediaRepository.InsertMedia(ctx, txn, mediaMetadata)
+		if err := d.MediaRepository.InsertMedia(ctx, txn, mediaMetadata); err != nil {
+			return err
+		}
+		if mediaMetadata.UserID == "" {
+			return nil
+		}
+		return d.MediaUsage.UpsertUsedBytes(ctx, txn, mediaMetadata.UserID, mediaMetadata.FileSizeBytes)
 	})
 }
 
@@ -52,6 +61,7 @@ func (d Database) GetMediaMetadata(ctx context.Context, mediaID types.MediaID, m
 
 // GetMediaMetadataByHash returns metadata about media stored on this server.
 // The media could have been uploaded to this server or fetched from another server and cached here.
+// If media with the same hash was quarantined, that media is returned regardless of its origin.
 // Returns nil metadata if there is no metadata associated with this media.
 func (d Database) GetMediaMetadataByHash(ctx context.Context, mediaHash types.Base64Hash, mediaOrigin spec.ServerName) (*types.MediaMetadata, error) {
 	mediaMetadata, err := d.MediaRepository.SelectMediaByHash(ctx, nil, mediaHash, mediaOrigin)
@@ -61,6 +71,144 @@ func (d Database) GetMediaMetadataByHash(ctx context.Context, mediaHash types.Ba
 	return mediaMetadata, err
 }
 
+// UpdateMediaLastAccess records when media was last downloaded or thumbnailed.
+func (d Database) UpdateMediaLastAccess(ctx context.Context, mediaID types.MediaID, mediaOrigin spec.ServerName, ts spec.Timestamp) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.MediaRepository.UpdateMediaLastAccess(ctx, txn, mediaID, mediaOrigin, ts)
+	})
+}
+
+// GetRemoteMediaAccessedBefore returns up to limit media from servers not in localServerNames which
+// hasn't been accessed since the given time.
+func (d Database) GetRemoteMediaAccessedBefore(ctx context.Context, localServerNames []spec.ServerName, ts spec.Timestamp, limit int) ([]*types.MediaMetadata, error) {
+	return d.MediaRepository.SelectRemoteMediaAccessedBefore(ctx, nil, localServerNames, ts, limit)
+}
+
+// GetLocalMediaAccessedBefore returns up to limit media uploaded to one of localServerNames which
+// hasn't been accessed since the given time.
+func (d Database) GetLocalMediaAccessedBefore(ctx context.Context, localServerNames []spec.ServerName, ts spec.Timestamp, limit int) ([]*types.MediaMetadata, error) {
+	return d.MediaRepository.SelectLocalMediaAccessedBefore(ctx, nil, localServerNames, ts, limit)
+}
+
+// GetLocalMediaCreatedBefore returns up to limit media uploaded to one of localServerNames before the given time.
+func (d Database) GetLocalMediaCreatedBefore(ctx context.Context, localServerNames []spec.ServerName, ts spec.Timestamp, limit int) ([]*types.MediaMetadata, error) {
+	return d.MediaRepository.SelectLocalMediaCreatedBefore(ctx, nil, localServerNames, ts, limit)
+}
+
+// DeleteMedia removes the metadata of media and its thumbnails, and subtracts its size from the media usage
+// of the uploader. Returns the removed thumbnails, and whether the file of the media is still used by other
+// media with the same hash, in which case it must be kept.
+func (d Database) DeleteMedia(ctx context.Context, mediaMetadata *types.MediaMetadata) (thumbnails []*types.ThumbnailMetadata, fileInUse bool, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		stored, err := d.MediaRepository.SelectMedia(ctx, txn, mediaMetadata.MediaID, mediaMetadata.Origin)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil && stored.UserID != "" {
+			if err = d.MediaUsage.UpsertUsedBytes(ctx, txn, stored.UserID, -stored.FileSizeBytes); err != nil {
+				return err
+			}
+		}
+		thumbnails, err = d.Thumbnails.SelectThumbnails(ctx, txn, mediaMetadata.MediaID, mediaMetadata.Origin)
+		if err != nil {
+			return err
+		}
+		if err = d.Thumbnails.DeleteThumbnails(ctx, txn, mediaMetadata.MediaID, mediaMetadata.Origin); err != nil {
+			return err
+		}
+		if err = d.MediaRepository.DeleteMedia(ctx, txn, mediaMetadata.MediaID, mediaMetadata.Origin); err != nil {
+			return err
+		}
+		count, err := d.MediaRepository.SelectMediaCountByHash(ctx, txn, mediaMetadata.Base64Hash)
+		fileInUse = count > 0
+		return err
+	})
+	return
+}
+
+// QuarantineMedia quarantines the given media, along with all other media which is stored in the same file.
+// Returns the newly quarantined media and their thumbnails, whose metadata is removed. If the media is unknown,
+// it is stored as quarantined, so that it won't be fetched from the remote server in the future.
+func (d Database) QuarantineMedia(
+	ctx context.Context, mediaID types.MediaID, mediaOrigin spec.ServerName, quarantinedBy types.MatrixUserID,
+) (quarantined []*types.MediaMetadata, thumbnails []*types.ThumbnailMetadata, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		mediaMetadata, err := d.MediaRepository.SelectMedia(ctx, txn, mediaID, mediaOrigin)
+		if err == sql.ErrNoRows {
+			return d.MediaRepository.InsertMedia(ctx, txn, &types.MediaMetadata{
+				MediaID:       mediaID,
+				Origin:        mediaOrigin,
+				QuarantinedBy: quarantinedBy,
+			})
+		} else if err != nil {
+			return err
+		}
+		if mediaMetadata.Base64Hash == "" {
+			// Already quarantined before it was known.
+			return nil
+		}
+		media, err := d.MediaRepository.SelectMediaByHashAnyOrigin(ctx, txn, mediaMetadata.Base64Hash)
+		if err != nil {
+			return err
+		}
+		for _, m := range media {
+			if m.QuarantinedBy != "" {
+				continue
+			}
+			mediaThumbnails, err := d.Thumbnails.SelectThumbnails(ctx, txn, m.MediaID, m.Origin)
+			if err != nil {
+				return err
+			}
+			if err = d.Thumbnails.DeleteThumbnails(ctx, txn, m.MediaID, m.Origin); err != nil {
+				return err
+			}
+			m.QuarantinedBy = quarantinedBy
+			quarantined = append(quarantined, m)
+			thumbnails = append(thumbnails, mediaThumbnails...)
+		}
+		return d.MediaRepository.UpdateMediaQuarantinedByHash(ctx, txn, mediaMetadata.Base64Hash, quarantinedBy)
+	})
+	return
+}
+
+// GetMediaByUser returns up to limit media uploaded by the given user, most recent first,
+// along with the total number of media uploaded by the user.
+func (d Database) GetMediaByUser(ctx context.Context, userID types.MatrixUserID, from, limit int) (media []*types.MediaMetadata, total int, err error) {
+	media, err = d.MediaRepository.SelectMediaByUser(ctx, nil, userID, from, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err = d.MediaRepository.SelectMediaCountByUser(ctx, nil, userID)
+	return media, total, err
+}
+
+// GetMediaUsage returns how much media the given user uploaded, and their quota.
+func (d Database) GetMediaUsage(ctx context.Context, userID types.MatrixUserID) (*types.MediaUsage, error) {
+	usage, err := d.MediaUsage.SelectUsage(ctx, nil, userID)
+	if err == sql.ErrNoRows {
+		return &types.MediaUsage{UserID: userID}, nil
+	}
+	return usage, err
+}
+
+// GetLargestMediaUsage returns up to limit users who uploaded the most media, largest first,
+// along with the total number of users who uploaded media.
+func (d Database) GetLargestMediaUsage(ctx context.Context, from, limit int) (usage []*types.MediaUsage, total int, err error) {
+	usage, err = d.MediaUsage.SelectLargestUsage(ctx, nil, from, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err = d.MediaUsage.SelectUsageCount(ctx, nil)
+	return usage, total, err
+}
+
+// SetMediaQuota sets the media quota of the given user, or resets it to the default quota if nil.
+func (d Database) SetMediaQuota(ctx context.Context, userID types.MatrixUserID, quota *types.FileSizeBytes) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.MediaUsage.UpsertQuota(ctx, txn, userID, quota)
+	})
+}
+
 // StoreThumbnail inserts the metadata about the thumbnail into the database.
 // Returns an error if the combination of MediaID and Origin are not unique in the table.
 func (d Database) StoreThumbnail(ctx context.Context, thumbnailMetadata *types.ThumbnailMetadata) error {
@@ -96,3 +244,24 @@ func (d Database) GetThumbnails(ctx context.Context, mediaID types.MediaID, medi
 	}
 	return metadatas, err
 }
+
+// StoreURLPreview inserts a preview of a URL into the database, replacing any
+// preview of the URL fetched at the same time.
+func (d Database) StoreURLPreview(ctx context.Context, preview *types.URLPreview) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.URLPreviews.InsertURLPreview(ctx, txn, preview)
+	})
+}
+
+// GetURLPreview returns the newest preview of a URL fetched at or before the given time.
+// Returns nil if there is no such preview.
+func (d Database) GetURLPreview(ctx context.Context, url string, ts spec.Timestamp) (*types.URLPreview, error) {
+	preview, err := d.URLPreviews.SelectURLPreview(ctx, nil, url, ts)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return preview, nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+func UpMediaLastAccess(ctx context.Context, tx *sql.Tx) error {
+	// SQLite doesn't have "if not exists" for columns, so check if the column exists first.
+	// If the query doesn't return an error, the table was created with the new column.
+	if rows, err := tx.QueryContext(ctx, "SELECT last_access_ts FROM mediaapi_media_repository LIMIT 1"); err == nil {
+		return rows.Close()
+	}
+	_, err := tx.ExecContext(ctx, `
+ALTER TABLE mediaapi_media_repository ADD COLUMN last_access_ts INTEGER NOT NULL DEFAULT 0;
+UPDATE mediaapi_media_repository SET last_access_ts = creation_ts;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
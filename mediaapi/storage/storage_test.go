@@ -4,12 +4,14 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/gomatrixserverlib/spec"
 )
 
 func mustCreateDatabase(t *testing.T, dbType test.DBType) (storage.Database, func()) {
@@ -37,6 +39,7 @@ func TestMediaRepository(t *testing.T) {
 				UploadName:    "upload test",
 				Base64Hash:    "dGVzdGluZw==",
 				UserID:        "@alice:localhost",
+				Authenticated: true,
 			}
 			if err := db.StoreMediaMetadata(ctx, metadata); err != nil {
 				t.Fatalf("unable to store media metadata: %v", err)
@@ -143,3 +146,355 @@ func TestThumbnailsStorage(t *testing.T) {
 		})
 	})
 }
+
+func TestURLPreviewsStorage(t *testing.T) {
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+		t.Run("can insert url previews & query them by time", func(t *testing.T) {
+			previews := []*types.URLPreview{
+				{URL: "https://example.com", Timestamp: 10, OpenGraph: []byte(`{"og:title":"old"}`)},
+				{URL: "https://example.com", Timestamp: 20, OpenGraph: []byte(`{"og:title":"new"}`)},
+				{URL: "https://example.org", Timestamp: 15, OpenGraph: []byte(`{}`)},
+			}
+			for _, preview := range previews {
+				if err := db.StoreURLPreview(ctx, preview); err != nil {
+					t.Fatalf("unable to store url preview: %v", err)
+				}
+			}
+			for _, tc := range []struct {
+				ts   int64
+				want *types.URLPreview
+			}{
+				{ts: 5, want: nil},
+				{ts: 10, want: previews[0]},
+				{ts: 19, want: previews[0]},
+				{ts: 100, want: previews[1]},
+			} {
+				gotPreview, err := db.GetURLPreview(ctx, "https://example.com", spec.Timestamp(tc.ts))
+				if err != nil {
+					t.Fatalf("unable to query url preview: %v", err)
+				}
+				if !reflect.DeepEqual(tc.want, gotPreview) {
+					t.Fatalf("expected url preview %+v at %d, got %+v", tc.want, tc.ts, gotPreview)
+				}
+			}
+			// previews fetched again at the same time are replaced
+			replaced := &types.URLPreview{URL: "https://example.org", Timestamp: 15, OpenGraph: []byte(`{"og:title":"replaced"}`)}
+			if err := db.StoreURLPreview(ctx, replaced); err != nil {
+				t.Fatalf("unable to store url preview: %v", err)
+			}
+			gotPreview, err := db.GetURLPreview(ctx, replaced.URL, replaced.Timestamp)
+			if err != nil {
+				t.Fatalf("unable to query url preview: %v", err)
+			}
+			if !reflect.DeepEqual(replaced, gotPreview) {
+				t.Fatalf("expected url preview %+v, got %+v", replaced, gotPreview)
+			}
+		})
+	})
+}
+
+func TestMediaRetentionStorage(t *testing.T) {
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+		media := []*types.MediaMetadata{
+			{MediaID: "local_accessed", Origin: "localhost", Base64Hash: "aGFzaDE=", UserID: "@alice:localhost"},
+			{MediaID: "local_unaccessed", Origin: "localhost", Base64Hash: "aGFzaDI=", UserID: "@alice:localhost"},
+			{MediaID: "vhost_unaccessed", Origin: "vhost", Base64Hash: "aGFzaDQ=", UserID: "@alice:vhost"},
+			{MediaID: "remote_unaccessed", Origin: "remote", Base64Hash: "aGFzaDE="},
+			{MediaID: "remote_accessed", Origin: "remote", Base64Hash: "aGFzaDM="},
+		}
+		for _, m := range media {
+			if err := db.StoreMediaMetadata(ctx, m); err != nil {
+				t.Fatalf("unable to store media metadata: %v", err)
+			}
+		}
+		mediaIDs := func(media []*types.MediaMetadata) []types.MediaID {
+			ids := []types.MediaID{}
+			for _, m := range media {
+				ids = append(ids, m.MediaID)
+			}
+			return ids
+		}
+
+		t.Run("last access defaults to creation time", func(t *testing.T) {
+			gotMetadata, err := db.GetMediaMetadata(ctx, "remote_unaccessed", "remote")
+			if err != nil {
+				t.Fatalf("unable to query media metadata: %v", err)
+			}
+			if gotMetadata.LastAccessTimestamp != gotMetadata.CreationTimestamp {
+				t.Fatalf("expected last access %d, got %d", gotMetadata.CreationTimestamp, gotMetadata.LastAccessTimestamp)
+			}
+		})
+
+		t.Run("can query expired media", func(t *testing.T) {
+			// everything was created just now, so move the access time forward for some media
+			cutoff := spec.AsTimestamp(time.Now().Add(time.Minute))
+			for _, m := range []*types.MediaMetadata{media[0], media[4]} {
+				if err := db.UpdateMediaLastAccess(ctx, m.MediaID, m.Origin, cutoff+1); err != nil {
+					t.Fatalf("unable to update last access: %v", err)
+				}
+			}
+			// media of a virtual host is local, and accessed after the other unaccessed local media
+			if err := db.UpdateMediaLastAccess(ctx, media[2].MediaID, media[2].Origin, cutoff-1); err != nil {
+				t.Fatalf("unable to update last access: %v", err)
+			}
+			localServerNames := []spec.ServerName{"localhost", "vhost"}
+			remote, err := db.GetRemoteMediaAccessedBefore(ctx, localServerNames, cutoff, 10)
+			if err != nil {
+				t.Fatalf("unable to query remote media: %v", err)
+			}
+			if got, want := mediaIDs(remote), []types.MediaID{"remote_unaccessed"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected remote media %v, got %v", want, got)
+			}
+			accessed, err := db.GetLocalMediaAccessedBefore(ctx, localServerNames, cutoff, 10)
+			if err != nil {
+				t.Fatalf("unable to query local media: %v", err)
+			}
+			if got, want := mediaIDs(accessed), []types.MediaID{"local_unaccessed", "vhost_unaccessed"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected unaccessed local media %v, got %v", want, got)
+			}
+			created, err := db.GetLocalMediaCreatedBefore(ctx, localServerNames, cutoff, 10)
+			if err != nil {
+				t.Fatalf("unable to query local media: %v", err)
+			}
+			if len(created) != 3 {
+				t.Fatalf("expected 3 local media, got %v", mediaIDs(created))
+			}
+			created, err = db.GetLocalMediaCreatedBefore(ctx, localServerNames, media[0].CreationTimestamp, 10)
+			if err != nil {
+				t.Fatalf("unable to query local media: %v", err)
+			}
+			if len(created) != 0 {
+				t.Fatalf("expected no local media, got %v", mediaIDs(created))
+			}
+		})
+
+		t.Run("can delete media", func(t *testing.T) {
+			thumbnail := &types.ThumbnailMetadata{
+				MediaMetadata: &types.MediaMetadata{MediaID: "remote_unaccessed", Origin: "remote", ContentType: "image/png", FileSizeBytes: 5},
+				ThumbnailSize: types.ThumbnailSize{Width: 32, Height: 32, ResizeMethod: types.Crop},
+			}
+			if err := db.StoreThumbnail(ctx, thumbnail); err != nil {
+				t.Fatalf("unable to store thumbnail: %v", err)
+			}
+			// local_accessed has the same hash, so the file is still in use
+			thumbnails, fileInUse, err := db.DeleteMedia(ctx, media[3])
+			if err != nil {
+				t.Fatalf("unable to delete media: %v", err)
+			}
+			if len(thumbnails) != 1 || !fileInUse {
+				t.Fatalf("expected 1 thumbnail and the file in use, got %d thumbnails, in use %v", len(thumbnails), fileInUse)
+			}
+			gotMetadata, err := db.GetMediaMetadata(ctx, "remote_unaccessed", "remote")
+			if err != nil {
+				t.Fatalf("unable to query media metadata: %v", err)
+			}
+			if gotMetadata != nil {
+				t.Fatalf("expected media to be deleted, got %+v", gotMetadata)
+			}
+			gotThumbnails, err := db.GetThumbnails(ctx, "remote_unaccessed", "remote")
+			if err != nil {
+				t.Fatalf("unable to query thumbnails: %v", err)
+			}
+			if len(gotThumbnails) != 0 {
+				t.Fatalf("expected thumbnails to be deleted, got %d", len(gotThumbnails))
+			}
+			_, fileInUse, err = db.DeleteMedia(ctx, media[0])
+			if err != nil {
+				t.Fatalf("unable to delete media: %v", err)
+			}
+			if fileInUse {
+				t.Fatalf("expected the file to be unused")
+			}
+		})
+	})
+}
+
+func TestMediaQuarantineStorage(t *testing.T) {
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+		media := []*types.MediaMetadata{
+			{MediaID: "upload1", Origin: "localhost", Base64Hash: "YmFk", UserID: "@alice:localhost"},
+			{MediaID: "upload2", Origin: "localhost", Base64Hash: "Z29vZA==", UserID: "@alice:localhost"},
+			{MediaID: "cached", Origin: "remote", Base64Hash: "YmFk"},
+		}
+		for _, m := range media {
+			if err := db.StoreMediaMetadata(ctx, m); err != nil {
+				t.Fatalf("unable to store media metadata: %v", err)
+			}
+		}
+		if err := db.StoreThumbnail(ctx, &types.ThumbnailMetadata{
+			MediaMetadata: &types.MediaMetadata{MediaID: "cached", Origin: "remote", ContentType: "image/png", FileSizeBytes: 5},
+			ThumbnailSize: types.ThumbnailSize{Width: 32, Height: 32, ResizeMethod: types.Crop},
+		}); err != nil {
+			t.Fatalf("unable to store thumbnail: %v", err)
+		}
+
+		t.Run("can list media of a user", func(t *testing.T) {
+			uploads, total, err := db.GetMediaByUser(ctx, "@alice:localhost", 1, 10)
+			if err != nil {
+				t.Fatalf("unable to query media by user: %v", err)
+			}
+			if total != 2 || len(uploads) != 1 {
+				t.Fatalf("expected 1 of 2 uploads, got %d of %d", len(uploads), total)
+			}
+		})
+
+		t.Run("quarantines all media with the same hash", func(t *testing.T) {
+			quarantined, thumbnails, err := db.QuarantineMedia(ctx, "upload1", "localhost", "@admin:localhost")
+			if err != nil {
+				t.Fatalf("unable to quarantine media: %v", err)
+			}
+			if len(quarantined) != 2 || len(thumbnails) != 1 {
+				t.Fatalf("expected 2 quarantined media and 1 thumbnail, got %d and %d", len(quarantined), len(thumbnails))
+			}
+			gotMetadata, err := db.GetMediaMetadata(ctx, "cached", "remote")
+			if err != nil {
+				t.Fatalf("unable to query media metadata: %v", err)
+			}
+			if gotMetadata.QuarantinedBy != "@admin:localhost" {
+				t.Fatalf("expected media to be quarantined, got %+v", gotMetadata)
+			}
+			// uploads from any origin find the quarantined media
+			gotMetadata, err = db.GetMediaMetadataByHash(ctx, "YmFk", "elsewhere")
+			if err != nil {
+				t.Fatalf("unable to query media metadata by hash: %v", err)
+			}
+			if gotMetadata == nil || gotMetadata.QuarantinedBy == "" {
+				t.Fatalf("expected quarantined media, got %+v", gotMetadata)
+			}
+			// quarantining again is a no-op
+			quarantined, _, err = db.QuarantineMedia(ctx, "cached", "remote", "@admin:localhost")
+			if err != nil {
+				t.Fatalf("unable to quarantine media: %v", err)
+			}
+			if len(quarantined) != 0 {
+				t.Fatalf("expected no newly quarantined media, got %d", len(quarantined))
+			}
+			// quarantined media doesn't expire
+			remote, err := db.GetRemoteMediaAccessedBefore(ctx, []spec.ServerName{"localhost"}, spec.AsTimestamp(time.Now().Add(time.Hour)), 10)
+			if err != nil {
+				t.Fatalf("unable to query remote media: %v", err)
+			}
+			if len(remote) != 0 {
+				t.Fatalf("expected no expired remote media, got %d", len(remote))
+			}
+		})
+
+		t.Run("quarantines unknown media", func(t *testing.T) {
+			quarantined, _, err := db.QuarantineMedia(ctx, "unknown", "remote", "@admin:localhost")
+			if err != nil {
+				t.Fatalf("unable to quarantine media: %v", err)
+			}
+			if len(quarantined) != 0 {
+				t.Fatalf("expected no quarantined files, got %d", len(quarantined))
+			}
+			gotMetadata, err := db.GetMediaMetadata(ctx, "unknown", "remote")
+			if err != nil {
+				t.Fatalf("unable to query media metadata: %v", err)
+			}
+			if gotMetadata == nil || gotMetadata.QuarantinedBy != "@admin:localhost" {
+				t.Fatalf("expected media to be quarantined, got %+v", gotMetadata)
+			}
+		})
+	})
+}
+
+func TestMediaUsageStorage(t *testing.T) {
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateDatabase(t, dbType)
+		defer close()
+		ctx := context.Background()
+		media := []*types.MediaMetadata{
+			{MediaID: "upload1", Origin: "localhost", Base64Hash: "MQ==", FileSizeBytes: 10, UserID: "@alice:localhost"},
+			{MediaID: "upload2", Origin: "localhost", Base64Hash: "Mg==", FileSizeBytes: 20, UserID: "@alice:localhost"},
+			{MediaID: "upload3", Origin: "localhost", Base64Hash: "Mw==", FileSizeBytes: 5, UserID: "@bob:localhost"},
+			{MediaID: "cached", Origin: "remote", Base64Hash: "NA==", FileSizeBytes: 100},
+		}
+		for _, m := range media {
+			if err := db.StoreMediaMetadata(ctx, m); err != nil {
+				t.Fatalf("unable to store media metadata: %v", err)
+			}
+		}
+
+		t.Run("accounts for uploaded media", func(t *testing.T) {
+			usage, total, err := db.GetLargestMediaUsage(ctx, 0, 10)
+			if err != nil {
+				t.Fatalf("unable to query media usage: %v", err)
+			}
+			if total != 2 || len(usage) != 2 {
+				t.Fatalf("expected 2 users, got %d of %d", len(usage), total)
+			}
+			if usage[0].UserID != "@alice:localhost" || usage[0].UsedBytes != 30 || usage[1].UsedBytes != 5 {
+				t.Fatalf("unexpected media usage %+v, %+v", usage[0], usage[1])
+			}
+			noUsage, err := db.GetMediaUsage(ctx, "@charlie:localhost")
+			if err != nil {
+				t.Fatalf("unable to query media usage: %v", err)
+			}
+			if noUsage.UsedBytes != 0 || noUsage.QuotaBytes != nil {
+				t.Fatalf("expected no media usage, got %+v", noUsage)
+			}
+		})
+
+		t.Run("subtracts deleted media", func(t *testing.T) {
+			if _, _, err := db.DeleteMedia(ctx, media[1]); err != nil {
+				t.Fatalf("unable to delete media: %v", err)
+			}
+			// deleting again doesn't subtract twice
+			if _, _, err := db.DeleteMedia(ctx, media[1]); err != nil {
+				t.Fatalf("unable to delete media: %v", err)
+			}
+			usage, err := db.GetMediaUsage(ctx, "@alice:localhost")
+			if err != nil {
+				t.Fatalf("unable to query media usage: %v", err)
+			}
+			if usage.UsedBytes != 10 {
+				t.Fatalf("expected 10 used bytes, got %d", usage.UsedBytes)
+			}
+		})
+
+		t.Run("can set and reset quotas", func(t *testing.T) {
+			quota := types.FileSizeBytes(1000)
+			if err := db.SetMediaQuota(ctx, "@alice:localhost", &quota); err != nil {
+				t.Fatalf("unable to set media quota: %v", err)
+			}
+			// users who haven't uploaded anything yet can have a quota
+			if err := db.SetMediaQuota(ctx, "@charlie:localhost", &quota); err != nil {
+				t.Fatalf("unable to set media quota: %v", err)
+			}
+			usage, err := db.GetMediaUsage(ctx, "@alice:localhost")
+			if err != nil {
+				t.Fatalf("unable to query media usage: %v", err)
+			}
+			if usage.UsedBytes != 10 || usage.Quota(50) != 1000 {
+				t.Fatalf("unexpected media usage %+v", usage)
+			}
+			if err = db.SetMediaQuota(ctx, "@alice:localhost", nil); err != nil {
+				t.Fatalf("unable to reset media quota: %v", err)
+			}
+			usage, err = db.GetMediaUsage(ctx, "@alice:localhost")
+			if err != nil {
+				t.Fatalf("unable to query media usage: %v", err)
+			}
+			if usage.QuotaBytes != nil || usage.Quota(50) != 50 {
+				t.Fatalf("expected default quota, got %+v", usage)
+			}
+			// users without media aren't listed
+			_, total, err := db.GetLargestMediaUsage(ctx, 0, 10)
+			if err != nil {
+				t.Fatalf("unable to query media usage: %v", err)
+			}
+			if total != 2 {
+				t.Fatalf("expected 2 users, got %d", total)
+			}
+		})
+	})
+}
@@ -34,6 +34,7 @@ type Thumbnails interface {
 		ctx context.Context, txn *sql.Tx, mediaID types.MediaID,
 		mediaOrigin spec.ServerName,
 	) ([]*types.ThumbnailMetadata, error)
+	DeleteThumbnails(ctx context.Context, txn *sql.Tx, mediaID types.MediaID, mediaOrigin spec.ServerName) error
 }
 
 type MediaRepository interface {
@@ -43,4 +44,38 @@ type MediaRepository interface {
 		ctx context.Context, txn *sql.Tx,
 		mediaHash types.Base64Hash, mediaOrigin spec.ServerName,
 	) (*types.MediaMetadata, error)
+	UpdateMediaLastAccess(ctx context.Context, txn *sql.Tx, mediaID types.MediaID, mediaOrigin spec.ServerName, ts spec.Timestamp) error
+	// SelectRemoteMediaAccessedBefore returns media from servers not in localServerNames which was last accessed before the given time, least recently accessed first.
+	SelectRemoteMediaAccessedBefore(ctx context.Context, txn *sql.Tx, localServerNames []spec.ServerName, ts spec.Timestamp, limit int) ([]*types.MediaMetadata, error)
+	// SelectLocalMediaAccessedBefore returns media uploaded to one of localServerNames which was last accessed before the given time, least recently accessed first.
+	SelectLocalMediaAccessedBefore(ctx context.Context, txn *sql.Tx, localServerNames []spec.ServerName, ts spec.Timestamp, limit int) ([]*types.MediaMetadata, error)
+	// SelectLocalMediaCreatedBefore returns media uploaded to one of localServerNames before the given time, oldest first.
+	SelectLocalMediaCreatedBefore(ctx context.Context, txn *sql.Tx, localServerNames []spec.ServerName, ts spec.Timestamp, limit int) ([]*types.MediaMetadata, error)
+	// SelectMediaCountByHash returns how many media of any origin are stored in the file with the given hash.
+	SelectMediaCountByHash(ctx context.Context, txn *sql.Tx, mediaHash types.Base64Hash) (int, error)
+	// SelectMediaByHashAnyOrigin returns all media of any origin which are stored in the file with the given hash.
+	SelectMediaByHashAnyOrigin(ctx context.Context, txn *sql.Tx, mediaHash types.Base64Hash) ([]*types.MediaMetadata, error)
+	// SelectMediaByUser returns the media uploaded by the given user, most recent first.
+	SelectMediaByUser(ctx context.Context, txn *sql.Tx, userID types.MatrixUserID, from, limit int) ([]*types.MediaMetadata, error)
+	SelectMediaCountByUser(ctx context.Context, txn *sql.Tx, userID types.MatrixUserID) (int, error)
+	// UpdateMediaQuarantinedByHash quarantines all media which are stored in the file with the given hash.
+	UpdateMediaQuarantinedByHash(ctx context.Context, txn *sql.Tx, mediaHash types.Base64Hash, quarantinedBy types.MatrixUserID) error
+	DeleteMedia(ctx context.Context, txn *sql.Tx, mediaID types.MediaID, mediaOrigin spec.ServerName) error
+}
+
+type MediaUsage interface {
+	// UpsertUsedBytes adds the given number of bytes, which may be negative, to the media usage of the user.
+	UpsertUsedBytes(ctx context.Context, txn *sql.Tx, userID types.MatrixUserID, bytes types.FileSizeBytes) error
+	// UpsertQuota sets the quota of the user, or resets it to the default quota if nil.
+	UpsertQuota(ctx context.Context, txn *sql.Tx, userID types.MatrixUserID, quota *types.FileSizeBytes) error
+	SelectUsage(ctx context.Context, txn *sql.Tx, userID types.MatrixUserID) (*types.MediaUsage, error)
+	// SelectLargestUsage returns the media usage of the users who uploaded the most media, largest first.
+	SelectLargestUsage(ctx context.Context, txn *sql.Tx, from, limit int) ([]*types.MediaUsage, error)
+	SelectUsageCount(ctx context.Context, txn *sql.Tx) (int, error)
+}
+
+type URLPreviews interface {
+	InsertURLPreview(ctx context.Context, txn *sql.Tx, preview *types.URLPreview) error
+	// SelectURLPreview returns the newest preview of the URL fetched at or before the given time.
+	SelectURLPreview(ctx context.Context, txn *sql.Tx, url string, ts spec.Timestamp) (*types.URLPreview, error)
 }
@@ -16,12 +16,14 @@ package thumbnailer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"math"
-	"os"
-	"path/filepath"
+	"path"
 	"sync"
 
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -39,13 +41,12 @@ type thumbnailFitness struct {
 // thumbnailTemplate is the filename template for thumbnails
 const thumbnailTemplate = "thumbnail-%vx%v-%v"
 
-// GetThumbnailPath returns the path to a thumbnail given the absolute src path and thumbnail size configuration
-func GetThumbnailPath(src types.Path, config types.ThumbnailSize) types.Path {
-	srcDir := filepath.Dir(string(src))
-	return types.Path(filepath.Join(
-		srcDir,
+// GetThumbnailKey returns the media store key of a thumbnail given the src key and thumbnail size configuration
+func GetThumbnailKey(src string, config types.ThumbnailSize) string {
+	return path.Join(
+		path.Dir(src),
 		fmt.Sprintf(thumbnailTemplate, config.Width, config.Height, config.ResizeMethod),
-	))
+	)
 }
 
 // SelectThumbnail compares the (potentially) available thumbnails with the desired thumbnail and returns the best match
@@ -89,11 +90,11 @@ func SelectThumbnail(desired types.ThumbnailSize, thumbnails []*types.ThumbnailM
 }
 
 // getActiveThumbnailGeneration checks for active thumbnail generation
-func getActiveThumbnailGeneration(dst types.Path, _ types.ThumbnailSize, activeThumbnailGeneration *types.ActiveThumbnailGeneration, maxThumbnailGenerators int, logger *log.Entry) (isActive bool, busy bool, errorReturn error) {
+func getActiveThumbnailGeneration(dst string, _ types.ThumbnailSize, activeThumbnailGeneration *types.ActiveThumbnailGeneration, maxThumbnailGenerators int, logger *log.Entry) (isActive bool, busy bool, errorReturn error) {
 	// Check if there is active thumbnail generation.
 	activeThumbnailGeneration.Lock()
 	defer activeThumbnailGeneration.Unlock()
-	if activeThumbnailGenerationResult, ok := activeThumbnailGeneration.PathToResult[string(dst)]; ok {
+	if activeThumbnailGenerationResult, ok := activeThumbnailGeneration.PathToResult[dst]; ok {
 		logger.Info("Waiting for another goroutine to generate the thumbnail.")
 
 		// NOTE: Wait unlocks and locks again internally. There is still a deferred Unlock() that will unlock this.
@@ -110,7 +111,7 @@ func getActiveThumbnailGeneration(dst types.Path, _ types.ThumbnailSize, activeT
 	}
 
 	// No active thumbnail generation so create one
-	activeThumbnailGeneration.PathToResult[string(dst)] = &types.ThumbnailGenerationResult{
+	activeThumbnailGeneration.PathToResult[dst] = &types.ThumbnailGenerationResult{
 		Cond: &sync.Cond{L: activeThumbnailGeneration},
 	}
 
@@ -119,10 +120,10 @@ func getActiveThumbnailGeneration(dst types.Path, _ types.ThumbnailSize, activeT
 
 // broadcastGeneration broadcasts that thumbnail generation completed and the error to all waiting goroutines
 // Note: This should only be called by the owner of the activeThumbnailGenerationResult
-func broadcastGeneration(dst types.Path, activeThumbnailGeneration *types.ActiveThumbnailGeneration, _ types.ThumbnailSize, errorReturn error, logger *log.Entry) {
+func broadcastGeneration(dst string, activeThumbnailGeneration *types.ActiveThumbnailGeneration, _ types.ThumbnailSize, errorReturn error, logger *log.Entry) {
 	activeThumbnailGeneration.Lock()
 	defer activeThumbnailGeneration.Unlock()
-	if activeThumbnailGenerationResult, ok := activeThumbnailGeneration.PathToResult[string(dst)]; ok {
+	if activeThumbnailGenerationResult, ok := activeThumbnailGeneration.PathToResult[dst]; ok {
 		logger.Info("Signalling other goroutines waiting for this goroutine to generate the thumbnail.")
 		// Note: errorReturn is a named return value error that is signalled from here to waiting goroutines
 		activeThumbnailGenerationResult.Err = errorReturn
@@ -133,7 +134,8 @@ func broadcastGeneration(dst types.Path, activeThumbnailGeneration *types.Active
 
 func isThumbnailExists(
 	ctx context.Context,
-	dst types.Path,
+	store mediastore.MediaStore,
+	dst string,
 	config types.ThumbnailSize,
 	mediaMetadata *types.MediaMetadata,
 	db storage.Database,
@@ -150,11 +152,12 @@ func isThumbnailExists(
 	if thumbnailMetadata != nil {
 		return true, nil
 	}
-	// Note: The double-negative is intentional as os.IsExist(err) != !os.IsNotExist(err).
-	// The functions are error checkers to be used in different cases.
-	if _, err = os.Stat(string(dst)); !os.IsNotExist(err) {
+	if _, err = store.Stat(ctx, dst); err == nil {
 		// Thumbnail exists
 		return true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		logger.WithError(err).Error("Failed to check media store for thumbnail.")
+		return false, err
 	}
 	return false, nil
 }
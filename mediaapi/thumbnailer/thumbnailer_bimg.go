@@ -18,10 +18,12 @@
 package thumbnailer
 
 import (
+	"bytes"
 	"context"
-	"os"
+	"io"
 	"time"
 
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -32,7 +34,8 @@ import (
 // GenerateThumbnails generates the configured thumbnail sizes for the source file
 func GenerateThumbnails(
 	ctx context.Context,
-	src types.Path,
+	store mediastore.MediaStore,
+	src string,
 	configs []config.ThumbnailSize,
 	mediaMetadata *types.MediaMetadata,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
@@ -40,7 +43,7 @@ func GenerateThumbnails(
 	db storage.Database,
 	logger *log.Entry,
 ) (busy bool, errorReturn error) {
-	buffer, err := bimg.Read(string(src))
+	buffer, err := readFile(ctx, store, src)
 	if err != nil {
 		logger.WithError(err).WithField("src", src).Error("Failed to read src file")
 		return false, err
@@ -49,7 +52,7 @@ func GenerateThumbnails(
 	for _, config := range configs {
 		// Note: createThumbnail does locking based on activeThumbnailGeneration
 		busy, err = createThumbnail(
-			ctx, src, img, types.ThumbnailSize(config), mediaMetadata, activeThumbnailGeneration,
+			ctx, store, src, img, types.ThumbnailSize(config), mediaMetadata, activeThumbnailGeneration,
 			maxThumbnailGenerators, db, logger,
 		)
 		if err != nil {
@@ -66,7 +69,8 @@ func GenerateThumbnails(
 // GenerateThumbnail generates the configured thumbnail size for the source file
 func GenerateThumbnail(
 	ctx context.Context,
-	src types.Path,
+	store mediastore.MediaStore,
+	src string,
 	config types.ThumbnailSize,
 	mediaMetadata *types.MediaMetadata,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
@@ -74,7 +78,7 @@ func GenerateThumbnail(
 	db storage.Database,
 	logger *log.Entry,
 ) (busy bool, errorReturn error) {
-	buffer, err := bimg.Read(string(src))
+	buffer, err := readFile(ctx, store, src)
 	if err != nil {
 		logger.WithError(err).WithFields(log.Fields{
 			"src": src,
@@ -84,7 +88,7 @@ func GenerateThumbnail(
 	img := bimg.NewImage(buffer)
 	// Note: createThumbnail does locking based on activeThumbnailGeneration
 	busy, err = createThumbnail(
-		ctx, src, img, config, mediaMetadata, activeThumbnailGeneration,
+		ctx, store, src, img, config, mediaMetadata, activeThumbnailGeneration,
 		maxThumbnailGenerators, db, logger,
 	)
 	if err != nil {
@@ -103,7 +107,8 @@ func GenerateThumbnail(
 // Thumbnail generation is only done once for each non-existing thumbnail.
 func createThumbnail(
 	ctx context.Context,
-	src types.Path,
+	store mediastore.MediaStore,
+	src string,
 	img *bimg.Image,
 	config types.ThumbnailSize,
 	mediaMetadata *types.MediaMetadata,
@@ -123,7 +128,7 @@ func createThumbnail(
 		return false, nil
 	}
 
-	dst := GetThumbnailPath(src, config)
+	dst := GetThumbnailKey(src, config)
 
 	// Note: getActiveThumbnailGeneration uses mutexes and conditions from activeThumbnailGeneration
 	isActive, busy, err := getActiveThumbnailGeneration(dst, config, activeThumbnailGeneration, maxThumbnailGenerators, logger)
@@ -147,13 +152,13 @@ func createThumbnail(
 		}()
 	}
 
-	exists, err := isThumbnailExists(ctx, dst, config, mediaMetadata, db, logger)
+	exists, err := isThumbnailExists(ctx, store, dst, config, mediaMetadata, db, logger)
 	if err != nil || exists {
 		return false, err
 	}
 
 	start := time.Now()
-	width, height, err := resize(dst, img, config.Width, config.Height, config.ResizeMethod == "crop", logger)
+	width, height, err := resize(ctx, store, dst, img, config.Width, config.Height, config.ResizeMethod == "crop", logger)
 	if err != nil {
 		return false, err
 	}
@@ -163,7 +168,7 @@ func createThumbnail(
 		"processTime":  time.Now().Sub(start),
 	}).Info("Generated thumbnail")
 
-	stat, err := os.Stat(string(dst))
+	size, err := store.Stat(ctx, dst)
 	if err != nil {
 		return false, err
 	}
@@ -174,7 +179,7 @@ func createThumbnail(
 			Origin:  mediaMetadata.Origin,
 			// Note: the code currently always creates a JPEG thumbnail
 			ContentType:   types.ContentType("image/jpeg"),
-			FileSizeBytes: types.FileSizeBytes(stat.Size()),
+			FileSizeBytes: types.FileSizeBytes(size),
 		},
 		ThumbnailSize: types.ThumbnailSize{
 			Width:        config.Width,
@@ -195,6 +200,15 @@ func createThumbnail(
 	return false, nil
 }
 
+func readFile(ctx context.Context, store mediastore.MediaStore, src string) ([]byte, error) {
+	file, _, err := store.Open(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // nolint: errcheck
+	return io.ReadAll(file)
+}
+
 func isLargerThanOriginal(config types.ThumbnailSize, img *bimg.Image) bool {
 	imgSize, err := img.Size()
 	if err == nil && config.Width >= imgSize.Width && config.Height >= imgSize.Height {
@@ -206,7 +220,7 @@ func isLargerThanOriginal(config types.ThumbnailSize, img *bimg.Image) bool {
 // resize scales an image to fit within the provided width and height
 // If the source aspect ratio is different to the target dimensions, one edge will be smaller than requested
 // If crop is set to true, the image will be scaled to fill the width and height with any excess being cropped off
-func resize(dst types.Path, inImage *bimg.Image, w, h int, crop bool, logger *log.Entry) (int, int, error) {
+func resize(ctx context.Context, store mediastore.MediaStore, dst string, inImage *bimg.Image, w, h int, crop bool, logger *log.Entry) (int, int, error) {
 	inSize, err := inImage.Size()
 	if err != nil {
 		return -1, -1, err
@@ -240,7 +254,7 @@ func resize(dst types.Path, inImage *bimg.Image, w, h int, crop bool, logger *lo
 		return -1, -1, err
 	}
 
-	if err = bimg.Write(string(dst), newImage); err != nil {
+	if err = store.Put(ctx, dst, bytes.NewReader(newImage), int64(len(newImage))); err != nil {
 		logger.WithError(err).Error("Failed to resize image")
 		return -1, -1, err
 	}
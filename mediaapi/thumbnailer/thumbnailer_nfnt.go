@@ -18,6 +18,7 @@
 package thumbnailer
 
 import (
+	"bytes"
 	"context"
 	"image"
 	"image/draw"
@@ -32,9 +33,9 @@ import (
 	// Imported for webp codec
 	_ "golang.org/x/image/webp"
 
-	"os"
 	"time"
 
+	"github.com/matrix-org/dendrite/mediaapi/mediastore"
 	"github.com/matrix-org/dendrite/mediaapi/storage"
 	"github.com/matrix-org/dendrite/mediaapi/types"
 	"github.com/matrix-org/dendrite/setup/config"
@@ -45,7 +46,8 @@ import (
 // GenerateThumbnails generates the configured thumbnail sizes for the source file
 func GenerateThumbnails(
 	ctx context.Context,
-	src types.Path,
+	store mediastore.MediaStore,
+	src string,
 	configs []config.ThumbnailSize,
 	mediaMetadata *types.MediaMetadata,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
@@ -53,7 +55,7 @@ func GenerateThumbnails(
 	db storage.Database,
 	logger *log.Entry,
 ) (busy bool, errorReturn error) {
-	img, err := readFile(string(src))
+	img, err := readFile(ctx, store, src)
 	if err != nil {
 		logger.WithError(err).WithField("src", src).Error("Failed to read src file")
 		return false, err
@@ -61,7 +63,7 @@ func GenerateThumbnails(
 	for _, singleConfig := range configs {
 		// Note: createThumbnail does locking based on activeThumbnailGeneration
 		busy, err = createThumbnail(
-			ctx, src, img, types.ThumbnailSize(singleConfig), mediaMetadata,
+			ctx, store, src, img, types.ThumbnailSize(singleConfig), mediaMetadata,
 			activeThumbnailGeneration, maxThumbnailGenerators, db, logger,
 		)
 		if err != nil {
@@ -78,7 +80,8 @@ func GenerateThumbnails(
 // GenerateThumbnail generates the configured thumbnail size for the source file
 func GenerateThumbnail(
 	ctx context.Context,
-	src types.Path,
+	store mediastore.MediaStore,
+	src string,
 	config types.ThumbnailSize,
 	mediaMetadata *types.MediaMetadata,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
@@ -86,7 +89,7 @@ func GenerateThumbnail(
 	db storage.Database,
 	logger *log.Entry,
 ) (busy bool, errorReturn error) {
-	img, err := readFile(string(src))
+	img, err := readFile(ctx, store, src)
 	if err != nil {
 		logger.WithError(err).WithFields(log.Fields{
 			"src": src,
@@ -95,7 +98,7 @@ func GenerateThumbnail(
 	}
 	// Note: createThumbnail does locking based on activeThumbnailGeneration
 	busy, err = createThumbnail(
-		ctx, src, img, config, mediaMetadata, activeThumbnailGeneration,
+		ctx, store, src, img, config, mediaMetadata, activeThumbnailGeneration,
 		maxThumbnailGenerators, db, logger,
 	)
 	if err != nil {
@@ -110,8 +113,8 @@ func GenerateThumbnail(
 	return false, nil
 }
 
-func readFile(src string) (image.Image, error) {
-	file, err := os.Open(src)
+func readFile(ctx context.Context, store mediastore.MediaStore, src string) (image.Image, error) {
+	file, _, err := store.Open(ctx, src)
 	if err != nil {
 		return nil, err
 	}
@@ -125,23 +128,23 @@ func readFile(src string) (image.Image, error) {
 	return img, nil
 }
 
-func writeFile(img image.Image, dst string) (err error) {
-	out, err := os.Create(dst)
+func writeFile(ctx context.Context, store mediastore.MediaStore, img image.Image, dst string) error {
+	var out bytes.Buffer
+	err := jpeg.Encode(&out, img, &jpeg.Options{
+		Quality: 85,
+	})
 	if err != nil {
 		return err
 	}
-	defer (func() { err = out.Close() })()
-
-	return jpeg.Encode(out, img, &jpeg.Options{
-		Quality: 85,
-	})
+	return store.Put(ctx, dst, bytes.NewReader(out.Bytes()), int64(out.Len()))
 }
 
 // createThumbnail checks if the thumbnail exists, and if not, generates it
 // Thumbnail generation is only done once for each non-existing thumbnail.
 func createThumbnail(
 	ctx context.Context,
-	src types.Path,
+	store mediastore.MediaStore,
+	src string,
 	img image.Image,
 	config types.ThumbnailSize,
 	mediaMetadata *types.MediaMetadata,
@@ -161,7 +164,7 @@ func createThumbnail(
 		return false, nil
 	}
 
-	dst := GetThumbnailPath(src, config)
+	dst := GetThumbnailKey(src, config)
 
 	// Note: getActiveThumbnailGeneration uses mutexes and conditions from activeThumbnailGeneration
 	isActive, busy, err := getActiveThumbnailGeneration(dst, config, activeThumbnailGeneration, maxThumbnailGenerators, logger)
@@ -185,13 +188,13 @@ func createThumbnail(
 		}()
 	}
 
-	exists, err := isThumbnailExists(ctx, dst, config, mediaMetadata, db, logger)
+	exists, err := isThumbnailExists(ctx, store, dst, config, mediaMetadata, db, logger)
 	if err != nil || exists {
 		return false, err
 	}
 
 	start := time.Now()
-	width, height, err := adjustSize(dst, img, config.Width, config.Height, config.ResizeMethod == types.Crop, logger)
+	width, height, err := adjustSize(ctx, store, dst, img, config.Width, config.Height, config.ResizeMethod == types.Crop, logger)
 	if err != nil {
 		return false, err
 	}
@@ -201,7 +204,7 @@ func createThumbnail(
 		"processTime":  time.Since(start),
 	}).Info("Generated thumbnail")
 
-	stat, err := os.Stat(string(dst))
+	size, err := store.Stat(ctx, dst)
 	if err != nil {
 		return false, err
 	}
@@ -212,7 +215,7 @@ func createThumbnail(
 			Origin:  mediaMetadata.Origin,
 			// Note: the code currently always creates a JPEG thumbnail
 			ContentType:   types.ContentType("image/jpeg"),
-			FileSizeBytes: types.FileSizeBytes(stat.Size()),
+			FileSizeBytes: types.FileSizeBytes(size),
 		},
 		ThumbnailSize: types.ThumbnailSize{
 			Width:        config.Width,
@@ -236,7 +239,7 @@ func createThumbnail(
 // adjustSize scales an image to fit within the provided width and height
 // If the source aspect ratio is different to the target dimensions, one edge will be smaller than requested
 // If crop is set to true, the image will be scaled to fill the width and height with any excess being cropped off
-func adjustSize(dst types.Path, img image.Image, w, h int, crop bool, logger *log.Entry) (int, int, error) {
+func adjustSize(ctx context.Context, store mediastore.MediaStore, dst string, img image.Image, w, h int, crop bool, logger *log.Entry) (int, int, error) {
 	var out image.Image
 	var err error
 	if crop {
@@ -267,7 +270,7 @@ func adjustSize(dst types.Path, img image.Image, w, h int, crop bool, logger *lo
 		out = resize.Thumbnail(uint(w), uint(h), img, resize.Lanczos3)
 	}
 
-	if err = writeFile(out, string(dst)); err != nil {
+	if err = writeFile(ctx, store, out, dst); err != nil {
 		logger.WithError(err).Error("Failed to encode and write image")
 		return -1, -1, err
 	}
@@ -15,6 +15,7 @@
 package types
 
 import (
+	"encoding/json"
 	"sync"
 
 	"github.com/matrix-org/dendrite/setup/config"
@@ -55,6 +56,40 @@ type MediaMetadata struct {
 	UploadName        Filename
 	Base64Hash        Base64Hash
 	UserID            MatrixUserID
+	// When the media was last downloaded or thumbnailed, or fetched from a remote server.
+	LastAccessTimestamp spec.Timestamp
+	// The user who quarantined the media, or empty if it isn't quarantined. The file of
+	// quarantined media is deleted, and it can't be downloaded or uploaded again.
+	QuarantinedBy MatrixUserID
+	// Whether the media can only be downloaded using the authenticated media
+	// endpoints (MSC3916), because it was uploaded or cached after unauthenticated
+	// media was frozen.
+	Authenticated bool
+}
+
+// URLPreview is a cached preview of a URL
+type URLPreview struct {
+	URL string
+	// When the URL was fetched
+	Timestamp spec.Timestamp
+	// The OpenGraph metadata of the URL, as returned by /preview_url
+	OpenGraph json.RawMessage
+}
+
+// MediaUsage is how much media a user uploaded to this server
+type MediaUsage struct {
+	UserID    MatrixUserID
+	UsedBytes FileSizeBytes
+	// The quota of the user, or nil if the default quota applies. A quota of 0 means unlimited.
+	QuotaBytes *FileSizeBytes
+}
+
+// Quota returns the quota of the user, given the default quota. A quota of 0 means unlimited.
+func (u *MediaUsage) Quota(defaultQuota FileSizeBytes) FileSizeBytes {
+	if u.QuotaBytes != nil {
+		return *u.QuotaBytes
+	}
+	return defaultQuota
 }
 
 // RemoteRequestResult is used for broadcasting the result of a request for a remote file to routines waiting on the condition
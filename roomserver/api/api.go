@@ -70,6 +70,7 @@ type RoomserverInternalAPI interface {
 	QuerySenderIDAPI
 	UserRoomPrivateKeyCreator
 	DefaultRoomVersionAPI
+	MediaRoomserverAPI
 
 	// needed to avoid chicken and egg scenario when setting up the
 	// interdependencies between the roomserver and other input APIs
@@ -88,6 +89,12 @@ type RoomserverInternalAPI interface {
 	) error
 }
 
+// MediaRoomserverAPI is used by the media API to find the media used in rooms.
+type MediaRoomserverAPI interface {
+	// QueryAdminRoomMediaURIs returns the mxc:// URIs of all media referenced by events in the room.
+	QueryAdminRoomMediaURIs(ctx context.Context, roomID string) ([]string, error)
+}
+
 type UserRoomPrivateKeyCreator interface {
 	// GetOrCreateUserRoomPrivateKey gets the user room key for the specified user. If no key exists yet, a new one is created.
 	GetOrCreateUserRoomPrivateKey(ctx context.Context, userID spec.UserID, roomID spec.RoomID) (ed25519.PrivateKey, error)
@@ -141,6 +148,13 @@ type QueryRoomHierarchyAPI interface {
 	QueryNextRoomHierarchyPage(ctx context.Context, walker RoomHierarchyWalker, limit int) ([]fclient.RoomHierarchyRoom, *RoomHierarchyWalker, error)
 }
 
+type QueryTimestampToEventAPI interface {
+	// QueryTimestampToEvent finds the event closest to the given timestamp in the given direction,
+	// asking other servers in the room if allowRemote is set and the local timeline has a gap.
+	// Returns nil if no event could be found.
+	QueryTimestampToEvent(ctx context.Context, roomID spec.RoomID, ts spec.Timestamp, backwards, allowRemote bool) (*fsAPI.RespTimestampToEvent, error)
+}
+
 type QueryMembershipAPI interface {
 	QueryMembershipForSenderID(ctx context.Context, roomID spec.RoomID, senderID spec.SenderID, res *QueryMembershipForUserResponse) error
 	QueryMembershipForUser(ctx context.Context, req *QueryMembershipForUserRequest, res *QueryMembershipForUserResponse) error
@@ -163,6 +177,9 @@ type SyncRoomserverAPI interface {
 	QueryBulkStateContentAPI
 	QuerySenderIDAPI
 	QueryMembershipAPI
+	// Used to send geofence events on behalf of local users.
+	InputRoomEventsAPI
+	SigningIdentityFor(ctx context.Context, roomID spec.RoomID, senderID spec.UserID) (fclient.SigningIdentity, error)
 	// QuerySharedUsers returns a list of users who share at least 1 room in common with the given user.
 	QuerySharedUsers(ctx context.Context, req *QuerySharedUsersRequest, res *QuerySharedUsersResponse) error
 	// QueryEventsByID queries a list of events by event ID for one room. If no room is specified, it will try to determine
@@ -219,6 +236,7 @@ type ClientRoomserverAPI interface {
 	QuerySenderIDAPI
 	UserRoomPrivateKeyCreator
 	QueryRoomHierarchyAPI
+	QueryTimestampToEventAPI
 	DefaultRoomVersionAPI
 	QueryMembershipForUser(ctx context.Context, req *QueryMembershipForUserRequest, res *QueryMembershipForUserResponse) error
 	QueryMembershipsForRoom(ctx context.Context, req *QueryMembershipsForRoomRequest, res *QueryMembershipsForRoomResponse) error
@@ -239,10 +257,31 @@ type ClientRoomserverAPI interface {
 	PerformAdminEvacuateUser(ctx context.Context, userID string) (affected []string, err error)
 	PerformAdminPurgeRoom(ctx context.Context, roomID string) error
 	PerformAdminDownloadState(ctx context.Context, roomID, userID string, serverName spec.ServerName) error
+	// InsertReportedEvent stores a report of an event by a local user, returning the ID of the report.
+	InsertReportedEvent(ctx context.Context, roomID, eventID, reportingUserID, reason string, score *int64) (int64, error)
+	// QueryAdminEventReports returns the reports matching the request, along with the total number of matching reports.
+	QueryAdminEventReports(ctx context.Context, req *QueryAdminEventReportsRequest) ([]types.ReportedEvent, int64, error)
+	// QueryAdminEventReport returns the report with the given ID and the reported event, if known.
+	// Returns a nil report if there is no report with the ID.
+	QueryAdminEventReport(ctx context.Context, reportID int64) (*types.ReportedEvent, *types.HeaderedEvent, error)
+	// PerformAdminResolveEventReport marks a report as resolved by the given admin.
+	// Returns false if there is no unresolved report with the ID.
+	PerformAdminResolveEventReport(ctx context.Context, reportID int64, resolvedBy string) (bool, error)
+	// QueryAdminRooms returns the rooms that the server has state for, along with the total number of rooms.
+	QueryAdminRooms(ctx context.Context, req *QueryAdminRoomsRequest) ([]AdminRoom, int64, error)
+	// QueryAdminRoom returns the details and the current state of the room.
+	// Returns a nil room if the server has no state for it.
+	QueryAdminRoom(ctx context.Context, roomID string) (*AdminRoom, []*types.HeaderedEvent, error)
+	// QueryAdminBlockedRoom returns the blocked room with the given ID, or nil if the room isn't blocked.
+	QueryAdminBlockedRoom(ctx context.Context, roomID string) (*types.BlockedRoom, error)
+	// PerformAdminBlockRoom blocks or unblocks local users from joining the room.
+	PerformAdminBlockRoom(ctx context.Context, roomID, blockedBy string, block bool) error
 	PerformPeek(ctx context.Context, req *PerformPeekRequest) (roomID string, err error)
 	PerformUnpeek(ctx context.Context, roomID, userID, deviceID string) error
 	PerformInvite(ctx context.Context, req *PerformInviteRequest) error
 	PerformJoin(ctx context.Context, req *PerformJoinRequest) (roomID string, joinedVia spec.ServerName, err error)
+	// PerformKnock knocks on a room, over federation if the server isn't in the room.
+	PerformKnock(ctx context.Context, req *PerformKnockRequest) (roomID string, err error)
 	PerformLeave(ctx context.Context, req *PerformLeaveRequest, res *PerformLeaveResponse) error
 	PerformPublish(ctx context.Context, req *PerformPublishRequest) error
 	// PerformForget forgets a rooms history for a specific user
@@ -271,6 +310,7 @@ type UserRoomserverAPI interface {
 	QueryMembershipsForRoom(ctx context.Context, req *QueryMembershipsForRoomRequest, res *QueryMembershipsForRoomResponse) error
 	PerformAdminEvacuateUser(ctx context.Context, userID string) (affected []string, err error)
 	PerformJoin(ctx context.Context, req *PerformJoinRequest) (roomID string, joinedVia spec.ServerName, err error)
+	PerformLeave(ctx context.Context, req *PerformLeaveRequest, res *PerformLeaveResponse) error
 	JoinedUserCount(ctx context.Context, roomID string) (int, error)
 }
 
@@ -282,6 +322,7 @@ type FederationRoomserverAPI interface {
 	QuerySenderIDAPI
 	QueryRoomHierarchyAPI
 	QueryMembershipAPI
+	QueryTimestampToEventAPI
 	UserRoomPrivateKeyCreator
 	AssignRoomNID(ctx context.Context, roomID spec.RoomID, roomVersion gomatrixserverlib.RoomVersion) (roomNID types.RoomNID, err error)
 	SigningIdentityFor(ctx context.Context, roomID spec.RoomID, senderID spec.UserID) (fclient.SigningIdentity, error)
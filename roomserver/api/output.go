@@ -32,6 +32,10 @@ const (
 	OutputTypeNewInviteEvent OutputType = "new_invite_event"
 	// OutputTypeRetireInviteEvent indicates that the event is an OutputRetireInviteEvent
 	OutputTypeRetireInviteEvent OutputType = "retire_invite_event"
+	// OutputTypeNewKnockEvent indicates that the event is an OutputNewKnockEvent
+	OutputTypeNewKnockEvent OutputType = "new_knock_event"
+	// OutputTypeRetireKnockEvent indicates that the event is an OutputRetireKnockEvent
+	OutputTypeRetireKnockEvent OutputType = "retire_knock_event"
 	// OutputTypeRedactedEvent indicates that the event is an OutputRedactedEvent
 	//
 	// This event is emitted when a redaction has been 'validated' (meaning both the redaction and the event to redact are known).
@@ -74,6 +78,10 @@ type OutputEvent struct {
 	NewInviteEvent *OutputNewInviteEvent `json:"new_invite_event,omitempty"`
 	// The content of event with type OutputTypeRetireInviteEvent
 	RetireInviteEvent *OutputRetireInviteEvent `json:"retire_invite_event,omitempty"`
+	// The content of event with type OutputTypeNewKnockEvent
+	NewKnockEvent *OutputNewKnockEvent `json:"new_knock_event,omitempty"`
+	// The content of event with type OutputTypeRetireKnockEvent
+	RetireKnockEvent *OutputRetireKnockEvent `json:"retire_knock_event,omitempty"`
 	// The content of event with type OutputTypeRedactedEvent
 	RedactedEvent *OutputRedactedEvent `json:"redacted_event,omitempty"`
 	// The content of event with type OutputTypeNewPeek
@@ -228,6 +236,25 @@ type OutputRetireInviteEvent struct {
 	Membership string
 }
 
+// An OutputNewKnockEvent is written whenever a local user knocks on a room.
+// Like invites, knocks can be outside of a room the server is in, so they are
+// tracked separately from the room events themselves.
+type OutputNewKnockEvent struct {
+	// The "m.room.member" knock event. The stripped state of the room is in
+	// the "knock_room_state" key of the unsigned section.
+	Event *types.HeaderedEvent `json:"event"`
+}
+
+// An OutputRetireKnockEvent is written whenever the knock of a local user is
+// no longer active, because it was accepted, rejected or rescinded.
+type OutputRetireKnockEvent struct {
+	RoomID string `json:"room_id"`
+	UserID string `json:"user_id"`
+	// The "membership" of the user after retiring the knock. One of "join",
+	// "leave" or "ban".
+	Membership string `json:"membership"`
+}
+
 // An OutputRedactedEvent is written whenever a redaction has been /validated/.
 // Downstream components MUST redact the given event ID if they have stored the
 // event JSON. It is guaranteed that this event ID has been seen before.
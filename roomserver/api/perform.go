@@ -39,6 +39,13 @@ type PerformJoinRequest struct {
 	Unsigned      map[string]interface{} `json:"unsigned"`
 }
 
+type PerformKnockRequest struct {
+	RoomIDOrAlias string                 `json:"room_id_or_alias"`
+	UserID        string                 `json:"user_id"`
+	Content       map[string]interface{} `json:"content"`
+	ServerNames   []spec.ServerName      `json:"server_names"`
+}
+
 type PerformLeaveRequest struct {
 	RoomID string
 	Leaver spec.UserID
@@ -555,3 +555,53 @@ func (s RoomSet) Copy() RoomSet {
 	}
 	return copied
 }
+
+// QueryAdminEventReportsRequest is a request to QueryAdminEventReports.
+// The room ID and user ID filters are ignored if empty.
+type QueryAdminEventReportsRequest struct {
+	RoomID string
+	// UserID is the user who reported the events.
+	UserID string
+	// Resolved only returns resolved or unresolved reports if set.
+	Resolved  *bool
+	From      uint64
+	Limit     uint64
+	Backwards bool
+}
+
+// AdminRoomsOrder is the order in which QueryAdminRooms returns rooms.
+type AdminRoomsOrder string
+
+const (
+	AdminRoomsOrderByName          AdminRoomsOrder = "name"
+	AdminRoomsOrderByJoinedMembers AdminRoomsOrder = "joined_members"
+	AdminRoomsOrderByEvents        AdminRoomsOrder = "events"
+)
+
+// QueryAdminRoomsRequest is a request to QueryAdminRooms.
+type QueryAdminRoomsRequest struct {
+	// OrderBy defaults to AdminRoomsOrderByName if empty.
+	OrderBy   AdminRoomsOrder
+	Backwards bool
+	From      uint64
+	Limit     uint64
+}
+
+// AdminRoom is a room as returned by the admin API. The fields below the
+// canonical alias are only populated by QueryAdminRoom.
+type AdminRoom struct {
+	types.RoomStats
+	Name           string
+	CanonicalAlias string
+	Topic          string
+	// Creator is the user ID of the user who created the room, if known.
+	Creator           string
+	JoinRules         string
+	GuestAccess       string
+	HistoryVisibility string
+	// Encryption is the encryption algorithm of the room, or empty if the room isn't encrypted.
+	Encryption  string
+	Federatable bool
+	Public      bool
+	StateEvents int
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"crypto/ed25519"
+	"time"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/matrix-org/gomatrixserverlib"
@@ -35,6 +36,7 @@ type RoomserverInternalAPI struct {
 	*query.Queryer
 	*perform.Inviter
 	*perform.Joiner
+	*perform.Knocker
 	*perform.Peeker
 	*perform.InboundPeeker
 	*perform.Unpeeker
@@ -148,6 +150,14 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.RoomserverFederatio
 		Inputer: r.Inputer,
 		Queryer: r.Queryer,
 	}
+	r.Knocker = &perform.Knocker{
+		Cfg:     &r.Cfg.RoomServer,
+		DB:      r.DB,
+		FSAPI:   r.fsAPI,
+		RSAPI:   r,
+		Inputer: r.Inputer,
+		Queryer: r.Queryer,
+	}
 	r.Peeker = &perform.Peeker{
 		ServerName: r.ServerName,
 		Cfg:        &r.Cfg.RoomServer,
@@ -255,6 +265,18 @@ func (r *RoomserverInternalAPI) PerformInvite(
 	return r.Inviter.PerformInvite(ctx, req)
 }
 
+func (r *RoomserverInternalAPI) PerformKnock(
+	ctx context.Context,
+	req *api.PerformKnockRequest,
+) (string, error) {
+	roomID, outputEvents, err := r.Knocker.PerformKnock(ctx, req)
+	if err != nil {
+		sentry.CaptureException(err)
+		return "", err
+	}
+	return roomID, r.OutputProducer.ProduceRoomEvents(roomID, outputEvents)
+}
+
 func (r *RoomserverInternalAPI) PerformLeave(
 	ctx context.Context,
 	req *api.PerformLeaveRequest,
@@ -308,6 +330,19 @@ func (r *RoomserverInternalAPI) StoreUserRoomPublicKey(ctx context.Context, send
 	return err
 }
 
+func (r *RoomserverInternalAPI) InsertReportedEvent(
+	ctx context.Context, roomID, eventID, reportingUserID, reason string, score *int64,
+) (int64, error) {
+	return r.DB.InsertReportedEvent(ctx, &types.ReportedEvent{
+		RoomID:          roomID,
+		EventID:         eventID,
+		ReportingUserID: reportingUserID,
+		Reason:          reason,
+		Score:           score,
+		ReceivedTS:      spec.AsTimestamp(time.Now()),
+	})
+}
+
 func (r *RoomserverInternalAPI) SigningIdentityFor(ctx context.Context, roomID spec.RoomID, senderID spec.UserID) (fclient.SigningIdentity, error) {
 	roomVersion, ok := r.Cache.GetRoomVersion(roomID.String())
 	if !ok {
@@ -120,6 +120,23 @@ func (r *Inputer) updateMembership(
 		return nil, mu.Delete()
 	}
 
+	// A knock by a local user is answered by them joining, leaving or being
+	// banned, so consumers are told the knock is no longer pending. Invites
+	// replace the knock in the consumers by themselves.
+	if targetLocal && mu.IsKnock() {
+		switch newMembership {
+		case spec.Join, spec.Leave, spec.Ban:
+			updates = append(updates, api.OutputEvent{
+				Type: api.OutputTypeRetireKnockEvent,
+				RetireKnockEvent: &api.OutputRetireKnockEvent{
+					RoomID:     add.RoomID().String(),
+					UserID:     *add.StateKey(),
+					Membership: newMembership,
+				},
+			})
+		}
+	}
+
 	switch newMembership {
 	case spec.Invite:
 		return helpers.UpdateToInviteMembership(mu, add, updates, updater.RoomVersion())
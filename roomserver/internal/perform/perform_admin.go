@@ -222,6 +222,25 @@ func (r *Admin) PerformAdminPurgeRoom(
 	})
 }
 
+// PerformAdminResolveEventReport marks a report as resolved by the given admin.
+func (r *Admin) PerformAdminResolveEventReport(
+	ctx context.Context,
+	reportID int64, resolvedBy string,
+) (bool, error) {
+	return r.DB.ResolveReportedEvent(ctx, reportID, resolvedBy)
+}
+
+// PerformAdminBlockRoom blocks or unblocks local users from joining the room.
+func (r *Admin) PerformAdminBlockRoom(
+	ctx context.Context,
+	roomID, blockedBy string, block bool,
+) error {
+	if _, err := spec.NewRoomID(roomID); err != nil {
+		return api.ErrInvalidID{Err: fmt.Errorf("room ID %q is invalid: %w", roomID, err)}
+	}
+	return r.DB.SetRoomBlocked(ctx, roomID, blockedBy, block)
+}
+
 func (r *Admin) PerformAdminDownloadState(
 	ctx context.Context,
 	roomID, userID string, serverName spec.ServerName,
@@ -170,6 +170,15 @@ func (r *Joiner) performJoinRoomByID(
 		return "", "", rsAPI.ErrInvalidID{Err: fmt.Errorf("room ID %q is invalid: %w", req.RoomIDOrAlias, err)}
 	}
 
+	// Local users can't join rooms which have been blocked by an admin.
+	blockedRoom, err := r.DB.GetBlockedRoom(ctx, roomID.String())
+	if err != nil {
+		return "", "", fmt.Errorf("r.DB.GetBlockedRoom: %w", err)
+	}
+	if blockedRoom != nil {
+		return "", "", rsAPI.ErrNotAllowed{Err: fmt.Errorf("this room has been blocked on this server")}
+	}
+
 	// If the server name in the room ID isn't ours then it's a
 	// possible candidate for finding the room via federation. Add
 	// it to the list of servers to try.
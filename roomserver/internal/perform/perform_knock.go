@@ -0,0 +1,273 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perform
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/sirupsen/logrus"
+
+	fsAPI "github.com/matrix-org/dendrite/federationapi/api"
+	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/internal/input"
+	"github.com/matrix-org/dendrite/roomserver/internal/query"
+	"github.com/matrix-org/dendrite/roomserver/storage"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+type Knocker struct {
+	Cfg   *config.RoomServer
+	FSAPI fsAPI.RoomserverFederationAPI
+	RSAPI api.RoomserverInternalAPI
+	DB    storage.Database
+
+	Inputer *input.Inputer
+	Queryer *query.Queryer
+}
+
+// PerformKnock knocks on a room. If the server is in the room, the knock event is
+// sent into the room directly, otherwise it is sent over federation.
+func (r *Knocker) PerformKnock(
+	ctx context.Context,
+	req *api.PerformKnockRequest,
+) (string, []api.OutputEvent, error) {
+	logger := logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"room_id": req.RoomIDOrAlias,
+		"user_id": req.UserID,
+		"servers": req.ServerNames,
+	})
+	logger.Info("User requested to knock on room")
+	roomID, outputEvents, err := r.performKnock(ctx, req)
+	if err != nil {
+		logger.WithError(err).Error("Failed to knock on room")
+		return "", nil, err
+	}
+	logger.Info("User knocked on room successfully")
+	return roomID, outputEvents, nil
+}
+
+func (r *Knocker) performKnock(
+	ctx context.Context,
+	req *api.PerformKnockRequest,
+) (string, []api.OutputEvent, error) {
+	userID, err := spec.NewUserID(req.UserID, true)
+	if err != nil {
+		return "", nil, api.ErrInvalidID{Err: fmt.Errorf("supplied user ID %q in incorrect format", req.UserID)}
+	}
+	if !r.Cfg.Matrix.IsLocalServerName(userID.Domain()) {
+		return "", nil, api.ErrInvalidID{Err: fmt.Errorf("user %q does not belong to this homeserver", req.UserID)}
+	}
+	if strings.HasPrefix(req.RoomIDOrAlias, "#") {
+		if err = r.resolveAlias(ctx, req); err != nil {
+			return "", nil, err
+		}
+	}
+	roomID, err := spec.NewRoomID(req.RoomIDOrAlias)
+	if err != nil {
+		return "", nil, api.ErrInvalidID{Err: fmt.Errorf("room ID %q is invalid: %w", req.RoomIDOrAlias, err)}
+	}
+
+	// Don't try to knock through ourselves, but do try the server of the room ID.
+	serverNames := make([]spec.ServerName, 0, len(req.ServerNames)+1)
+	for _, serverName := range req.ServerNames {
+		if !r.Cfg.Matrix.IsLocalServerName(serverName) {
+			serverNames = append(serverNames, serverName)
+		}
+	}
+	if !r.Cfg.Matrix.IsLocalServerName(roomID.Domain()) {
+		serverNames = append(serverNames, roomID.Domain())
+	}
+	req.ServerNames = serverNames
+
+	if req.Content == nil {
+		req.Content = map[string]interface{}{}
+	}
+	req.Content["membership"] = spec.Knock
+
+	inRoomRes := &api.QueryServerJoinedToRoomResponse{}
+	if err = r.Queryer.QueryServerJoinedToRoom(ctx, &api.QueryServerJoinedToRoomRequest{
+		RoomID: roomID.String(),
+	}, inRoomRes); err != nil {
+		return "", nil, fmt.Errorf("r.Queryer.QueryServerJoinedToRoom: %w", err)
+	}
+	if inRoomRes.RoomExists && inRoomRes.IsInRoom {
+		return r.performLocalKnock(ctx, req, *roomID, *userID, inRoomRes.RoomVersion)
+	}
+	if len(req.ServerNames) == 0 {
+		return "", nil, eventutil.ErrRoomNoExists{}
+	}
+	return r.performFederatedKnock(ctx, req, *roomID, *userID)
+}
+
+// resolveAlias replaces the alias in the request with the room ID it points to,
+// and adds the servers to knock through if the alias belongs to another server.
+func (r *Knocker) resolveAlias(ctx context.Context, req *api.PerformKnockRequest) error {
+	_, domain, err := gomatrixserverlib.SplitID('#', req.RoomIDOrAlias)
+	if err != nil {
+		return api.ErrInvalidID{Err: fmt.Errorf("alias %q is not in the correct format", req.RoomIDOrAlias)}
+	}
+	var roomID string
+	if r.Cfg.Matrix.IsLocalServerName(domain) {
+		res := api.GetRoomIDForAliasResponse{}
+		if err = r.RSAPI.GetRoomIDForAlias(ctx, &api.GetRoomIDForAliasRequest{
+			Alias:              req.RoomIDOrAlias,
+			IncludeAppservices: true,
+		}, &res); err != nil {
+			return fmt.Errorf("lookup room alias %q failed: %w", req.RoomIDOrAlias, err)
+		}
+		roomID = res.RoomID
+	} else {
+		res := fsAPI.PerformDirectoryLookupResponse{}
+		if err = r.FSAPI.PerformDirectoryLookup(ctx, &fsAPI.PerformDirectoryLookupRequest{
+			RoomAlias:  req.RoomIDOrAlias,
+			ServerName: domain,
+		}, &res); err != nil {
+			return fmt.Errorf("looking up alias %q over federation failed: %w", req.RoomIDOrAlias, err)
+		}
+		roomID = res.RoomID
+		req.ServerNames = append(req.ServerNames, domain)
+		req.ServerNames = append(req.ServerNames, res.ServerNames...)
+	}
+	if roomID == "" {
+		return eventutil.ErrRoomNoExists{}
+	}
+	req.RoomIDOrAlias = roomID
+	return nil
+}
+
+// performLocalKnock sends the knock into a room the server is in.
+func (r *Knocker) performLocalKnock(
+	ctx context.Context, req *api.PerformKnockRequest,
+	roomID spec.RoomID, userID spec.UserID, roomVersion gomatrixserverlib.RoomVersion,
+) (string, []api.OutputEvent, error) {
+	if roomVersion == gomatrixserverlib.RoomVersionPseudoIDs {
+		return "", nil, api.ErrNotAllowed{Err: fmt.Errorf("knocking on rooms with room version %q is not supported", roomVersion)}
+	}
+	senderID := userID.String()
+	proto := gomatrixserverlib.ProtoEvent{
+		Type:     spec.MRoomMember,
+		SenderID: senderID,
+		StateKey: &senderID,
+		RoomID:   roomID.String(),
+	}
+	if err := proto.SetContent(req.Content); err != nil {
+		return "", nil, fmt.Errorf("proto.SetContent: %w", err)
+	}
+	if err := proto.SetUnsigned(struct{}{}); err != nil {
+		return "", nil, fmt.Errorf("proto.SetUnsigned: %w", err)
+	}
+	identity, err := r.RSAPI.SigningIdentityFor(ctx, roomID, userID)
+	if err != nil {
+		return "", nil, fmt.Errorf("SigningIdentityFor: %w", err)
+	}
+	event, err := eventutil.QueryAndBuildEvent(ctx, &proto, &identity, time.Now(), r.RSAPI, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("eventutil.QueryAndBuildEvent: %w", err)
+	}
+
+	inputRes := api.InputRoomEventsResponse{}
+	r.Inputer.InputRoomEvents(ctx, &api.InputRoomEventsRequest{
+		InputRoomEvents: []api.InputRoomEvent{
+			{
+				Kind:         api.KindNew,
+				Event:        event,
+				Origin:       userID.Domain(),
+				SendAsServer: string(userID.Domain()),
+			},
+		},
+	}, &inputRes)
+	if err = inputRes.Err(); err != nil {
+		return "", nil, api.ErrNotAllowed{Err: err}
+	}
+
+	strippedState, err := gomatrixserverlib.GenerateStrippedState(ctx, roomID, &QueryState{r.DB, r.RSAPI})
+	if err != nil {
+		return "", nil, fmt.Errorf("gomatrixserverlib.GenerateStrippedState: %w", err)
+	}
+	output, err := knockOutputEvent(event.PDU, strippedState)
+	if err != nil {
+		return "", nil, err
+	}
+	return roomID.String(), []api.OutputEvent{output}, nil
+}
+
+// performFederatedKnock sends the knock through a server in the room, and remembers
+// the knock so that it can be rescinded later.
+func (r *Knocker) performFederatedKnock(
+	ctx context.Context, req *api.PerformKnockRequest,
+	roomID spec.RoomID, userID spec.UserID,
+) (string, []api.OutputEvent, error) {
+	fedRes := fsAPI.PerformKnockResponse{}
+	if err := r.FSAPI.PerformKnock(ctx, &fsAPI.PerformKnockRequest{
+		RoomID:      roomID.String(),
+		UserID:      userID.String(),
+		ServerNames: req.ServerNames,
+		Content:     req.Content,
+	}, &fedRes); err != nil {
+		return "", nil, err
+	}
+
+	updater, err := r.DB.MembershipUpdater(ctx, roomID.String(), userID.String(), true, fedRes.RoomVersion)
+	if err != nil {
+		return "", nil, fmt.Errorf("r.DB.MembershipUpdater: %w", err)
+	}
+	if _, _, err = updater.Update(tables.MembershipStateKnock, &types.Event{PDU: fedRes.Event}); err != nil {
+		_ = updater.Rollback()
+		return "", nil, fmt.Errorf("updater.Update: %w", err)
+	}
+	if err = updater.SetKnockedVia(fedRes.KnockedVia); err != nil {
+		_ = updater.Rollback()
+		return "", nil, fmt.Errorf("updater.SetKnockedVia: %w", err)
+	}
+	if err = updater.Commit(); err != nil {
+		return "", nil, fmt.Errorf("updater.Commit: %w", err)
+	}
+
+	output, err := knockOutputEvent(fedRes.Event, fedRes.KnockRoomState)
+	if err != nil {
+		return "", nil, err
+	}
+	return roomID.String(), []api.OutputEvent{output}, nil
+}
+
+// knockOutputEvent returns the output event telling downstream components about
+// the knock, with the stripped state of the room in the unsigned section.
+func knockOutputEvent(
+	event gomatrixserverlib.PDU, strippedState []gomatrixserverlib.InviteStrippedState,
+) (api.OutputEvent, error) {
+	if strippedState == nil {
+		strippedState = []gomatrixserverlib.InviteStrippedState{}
+	}
+	knockEvent, err := event.SetUnsigned(map[string]interface{}{
+		"knock_room_state": strippedState,
+	})
+	if err != nil {
+		return api.OutputEvent{}, fmt.Errorf("event.SetUnsigned: %w", err)
+	}
+	return api.OutputEvent{
+		Type: api.OutputTypeNewKnockEvent,
+		NewKnockEvent: &api.OutputNewKnockEvent{
+			Event: &types.HeaderedEvent{PDU: knockEvent},
+		},
+	}, nil
+}
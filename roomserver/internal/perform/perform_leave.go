@@ -34,6 +34,7 @@ import (
 	"github.com/matrix-org/dendrite/roomserver/internal/helpers"
 	"github.com/matrix-org/dendrite/roomserver/internal/input"
 	"github.com/matrix-org/dendrite/roomserver/storage"
+	"github.com/matrix-org/dendrite/roomserver/storage/shared"
 	"github.com/matrix-org/dendrite/setup/config"
 	userapi "github.com/matrix-org/dendrite/userapi/api"
 )
@@ -136,6 +137,26 @@ func (r *Leaver) performLeaveRoomByID(
 		}
 	}
 
+	// If there's a knock outstanding for a room that we aren't in then
+	// rescind it over federation.
+	info, err := r.DB.RoomInfo(ctx, req.RoomID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get room info: %w", err)
+	}
+	if info != nil && info.IsStub() {
+		var updater *shared.MembershipUpdater
+		updater, err = r.DB.MembershipUpdater(ctx, req.RoomID, string(*leaver), true, info.RoomVersion)
+		if err != nil {
+			return nil, fmt.Errorf("r.DB.MembershipUpdater: %w", err)
+		}
+		if updater.IsKnock() {
+			return r.performFederatedRescindKnock(ctx, req, *roomID, updater)
+		}
+		if err = updater.Rollback(); err != nil {
+			return nil, fmt.Errorf("updater.Rollback: %w", err)
+		}
+	}
+
 	// There's no invite pending, so first of all we want to find out
 	// if the room exists and if the user is actually in it.
 	latestReq := api.QueryLatestEventsAndStateRequest{
@@ -163,7 +184,7 @@ func (r *Leaver) performLeaveRoomByID(
 	if err != nil {
 		return nil, fmt.Errorf("error getting membership: %w", err)
 	}
-	if membership != spec.Join && membership != spec.Invite {
+	if membership != spec.Join && membership != spec.Invite && membership != spec.Knock {
 		return nil, fmt.Errorf("user %q is not joined to the room (membership is %q)", req.Leaver.String(), membership)
 	}
 
@@ -281,3 +302,56 @@ func (r *Leaver) performFederatedRejectInvite(
 		},
 	}, nil
 }
+
+func (r *Leaver) performFederatedRescindKnock(
+	ctx context.Context,
+	req *api.PerformLeaveRequest,
+	roomID spec.RoomID,
+	updater *shared.MembershipUpdater,
+) ([]api.OutputEvent, error) {
+	// Rescind the knock through the server it was sent through, as that server is
+	// known to be in the room, falling back to the server of the room ID.
+	var serverNames []spec.ServerName
+	knockedVia, err := updater.KnockedVia()
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Errorf("failed to get the server the knock was sent through")
+	} else if knockedVia != "" {
+		serverNames = append(serverNames, knockedVia)
+	}
+	if knockedVia != roomID.Domain() && !r.Cfg.Matrix.IsLocalServerName(roomID.Domain()) {
+		serverNames = append(serverNames, roomID.Domain())
+	}
+
+	// Ask the federation sender to perform a federated leave for us.
+	leaveReq := fsAPI.PerformLeaveRequest{
+		RoomID:      req.RoomID,
+		UserID:      req.Leaver.String(),
+		ServerNames: serverNames,
+	}
+	leaveRes := fsAPI.PerformLeaveResponse{}
+	if err = r.FSAPI.PerformLeave(ctx, &leaveReq, &leaveRes); err != nil {
+		// As with rejecting invites, failures should never stop us from
+		// telling the sync API that the knock was rescinded.
+		util.GetLogger(ctx).WithError(err).Errorf("failed to PerformLeave, still rescinding knock")
+	}
+
+	if err = updater.Delete(); err != nil {
+		util.GetLogger(ctx).WithError(err).Errorf("failed to delete membership, still rescinding knock")
+		if err = updater.Rollback(); err != nil {
+			util.GetLogger(ctx).WithError(err).Errorf("failed to rollback deleting membership, still rescinding knock")
+		}
+	} else if err = updater.Commit(); err != nil {
+		util.GetLogger(ctx).WithError(err).Errorf("failed to commit deleting membership, still rescinding knock")
+	}
+
+	return []api.OutputEvent{
+		{
+			Type: api.OutputTypeRetireKnockEvent,
+			RetireKnockEvent: &api.OutputRetireKnockEvent{
+				RoomID:     req.RoomID,
+				UserID:     req.Leaver.String(),
+				Membership: spec.Leave,
+			},
+		},
+	}, nil
+}
@@ -1099,3 +1099,27 @@ func (r *Queryer) QueryUserIDForSender(ctx context.Context, roomID spec.RoomID,
 
 	return nil, nil
 }
+
+// QueryAdminEventReports returns the reports matching the request, along with the total number of matching reports.
+func (r *Queryer) QueryAdminEventReports(ctx context.Context, req *api.QueryAdminEventReportsRequest) ([]types.ReportedEvent, int64, error) {
+	return r.DB.GetReportedEvents(ctx, req.RoomID, req.UserID, req.Resolved, req.From, req.Limit, req.Backwards)
+}
+
+// QueryAdminEventReport returns the report with the given ID and the reported event, if known.
+func (r *Queryer) QueryAdminEventReport(ctx context.Context, reportID int64) (*types.ReportedEvent, *types.HeaderedEvent, error) {
+	report, err := r.DB.GetReportedEvent(ctx, reportID)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	roomInfo, err := r.DB.RoomInfo(ctx, report.RoomID)
+	if err != nil || roomInfo == nil {
+		return report, nil, err
+	}
+	events, err := r.DB.EventsFromIDs(ctx, roomInfo, []string{report.EventID})
+	if err != nil || len(events) == 0 {
+		return report, nil, err
+	}
+	return report, &types.HeaderedEvent{PDU: events[0].PDU}, nil
+}
@@ -0,0 +1,271 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/tidwall/gjson"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/internal/helpers"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/roomserver/types"
+)
+
+// QueryAdminRooms returns the rooms that the server has state for, along with the total number of rooms.
+// Only the value the rooms are sorted by is looked up for every room, the stats and names are only
+// looked up for the requested page.
+func (r *Queryer) QueryAdminRooms(ctx context.Context, req *api.QueryAdminRoomsRequest) ([]api.AdminRoom, int64, error) {
+	roomIDs, err := r.DB.GetKnownRooms(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := int64(len(roomIDs))
+	if len(roomIDs) == 0 {
+		return []api.AdminRoom{}, total, nil
+	}
+
+	rooms := make(map[string]*api.AdminRoom, len(roomIDs))
+	for _, roomID := range roomIDs {
+		rooms[roomID] = &api.AdminRoom{RoomStats: types.RoomStats{RoomID: roomID}}
+	}
+	switch req.OrderBy {
+	case api.AdminRoomsOrderByJoinedMembers:
+		counts, err := r.DB.GetJoinedMemberCounts(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		for roomID, count := range counts {
+			if room, ok := rooms[roomID]; ok {
+				room.JoinedMembers = count
+			}
+		}
+	case api.AdminRoomsOrderByEvents:
+		counts, err := r.DB.GetEventCounts(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		for roomID, count := range counts {
+			if room, ok := rooms[roomID]; ok {
+				room.Events = count
+			}
+		}
+	default:
+		if err = r.populateAdminRoomNames(ctx, roomIDs, rooms); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	result := make([]api.AdminRoom, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		result = append(result, *rooms[roomID])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := &result[i], &result[j]
+		if req.Backwards {
+			a, b = b, a
+		}
+		switch req.OrderBy {
+		case api.AdminRoomsOrderByJoinedMembers:
+			if a.JoinedMembers != b.JoinedMembers {
+				return a.JoinedMembers < b.JoinedMembers
+			}
+		case api.AdminRoomsOrderByEvents:
+			if a.Events != b.Events {
+				return a.Events < b.Events
+			}
+		default:
+			if nameA, nameB := strings.ToLower(a.Name), strings.ToLower(b.Name); nameA != nameB {
+				return nameA < nameB
+			}
+		}
+		return a.RoomID < b.RoomID
+	})
+
+	if req.From >= uint64(len(result)) {
+		return []api.AdminRoom{}, total, nil
+	}
+	result = result[req.From:]
+	if req.Limit < uint64(len(result)) {
+		result = result[:req.Limit]
+	}
+
+	page := make(map[string]*api.AdminRoom, len(result))
+	pageRoomIDs := make([]string, 0, len(result))
+	for i := range result {
+		page[result[i].RoomID] = &result[i]
+		pageRoomIDs = append(pageRoomIDs, result[i].RoomID)
+	}
+	stats, err := r.DB.GetRoomStats(ctx, pageRoomIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range stats {
+		page[stats[i].RoomID].RoomStats = stats[i]
+	}
+	if req.OrderBy == api.AdminRoomsOrderByJoinedMembers || req.OrderBy == api.AdminRoomsOrderByEvents {
+		if err = r.populateAdminRoomNames(ctx, pageRoomIDs, page); err != nil {
+			return nil, 0, err
+		}
+	}
+	return result, total, nil
+}
+
+// populateAdminRoomNames sets the names and canonical aliases of the rooms.
+func (r *Queryer) populateAdminRoomNames(ctx context.Context, roomIDs []string, rooms map[string]*api.AdminRoom) error {
+	stateContent, err := r.DB.GetBulkStateContent(ctx, roomIDs, []gomatrixserverlib.StateKeyTuple{
+		{EventType: spec.MRoomName, StateKey: ""},
+		{EventType: spec.MRoomCanonicalAlias, StateKey: ""},
+	}, false)
+	if err != nil {
+		return err
+	}
+	for _, ev := range stateContent {
+		room, ok := rooms[ev.RoomID]
+		if !ok {
+			continue
+		}
+		switch ev.EventType {
+		case spec.MRoomName:
+			room.Name = ev.ContentValue
+		case spec.MRoomCanonicalAlias:
+			room.CanonicalAlias = ev.ContentValue
+		}
+	}
+	return nil
+}
+
+// QueryAdminRoom returns the details and the current state of the room.
+func (r *Queryer) QueryAdminRoom(ctx context.Context, roomID string) (*api.AdminRoom, []*types.HeaderedEvent, error) {
+	validRoomID, err := spec.NewRoomID(roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats, err := r.DB.GetRoomStatsForRoom(ctx, roomID)
+	if err != nil || stats == nil {
+		return nil, nil, err
+	}
+	stateRes := &api.QueryLatestEventsAndStateResponse{}
+	if err = helpers.QueryLatestEventsAndState(ctx, r.DB, r, &api.QueryLatestEventsAndStateRequest{
+		RoomID: roomID,
+	}, stateRes); err != nil {
+		return nil, nil, err
+	}
+
+	room := &api.AdminRoom{
+		RoomStats:   *stats,
+		Federatable: true,
+		StateEvents: len(stateRes.StateEvents),
+	}
+	for _, ev := range stateRes.StateEvents {
+		if !ev.StateKeyEquals("") {
+			continue
+		}
+		switch ev.Type() {
+		case spec.MRoomCreate:
+			if federate := gjson.GetBytes(ev.Content(), "m\\.federate"); federate.Exists() {
+				room.Federatable = federate.Bool()
+			}
+			creator, err := r.QueryUserIDForSender(ctx, *validRoomID, ev.SenderID())
+			if err != nil {
+				return nil, nil, err
+			}
+			if creator != nil {
+				room.Creator = creator.String()
+			}
+		case spec.MRoomName:
+			room.Name = tables.ExtractContentValue(ev)
+		case spec.MRoomCanonicalAlias:
+			room.CanonicalAlias = tables.ExtractContentValue(ev)
+		case "m.room.topic":
+			room.Topic = tables.ExtractContentValue(ev)
+		case spec.MRoomJoinRules:
+			room.JoinRules = tables.ExtractContentValue(ev)
+		case spec.MRoomGuestAccess:
+			room.GuestAccess = tables.ExtractContentValue(ev)
+		case spec.MRoomHistoryVisibility:
+			room.HistoryVisibility = tables.ExtractContentValue(ev)
+		case "m.room.encryption":
+			room.Encryption = gjson.GetBytes(ev.Content(), "algorithm").Str
+		}
+	}
+
+	room.Public, err = r.DB.GetPublishedRoom(ctx, roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return room, stateRes.StateEvents, nil
+}
+
+// QueryAdminBlockedRoom returns the blocked room with the given ID, or nil if the room isn't blocked.
+func (r *Queryer) QueryAdminBlockedRoom(ctx context.Context, roomID string) (*types.BlockedRoom, error) {
+	return r.DB.GetBlockedRoom(ctx, roomID)
+}
+
+// mediaURIsBatchSize is how many events are searched for media at a time.
+const mediaURIsBatchSize = 500
+
+// QueryAdminRoomMediaURIs returns the mxc:// URIs of all media referenced by events in the room.
+// Returns no URIs if the server has no events for the room.
+func (r *Queryer) QueryAdminRoomMediaURIs(ctx context.Context, roomID string) ([]string, error) {
+	roomInfo, err := r.DB.RoomInfo(ctx, roomID)
+	if err != nil || roomInfo == nil {
+		return nil, err
+	}
+	found := map[string]struct{}{}
+	var afterEventNID types.EventNID
+	for {
+		eventNIDs, err := r.DB.EventNIDsForRoom(ctx, roomInfo.RoomNID, afterEventNID, mediaURIsBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(eventNIDs) == 0 {
+			break
+		}
+		events, err := r.DB.Events(ctx, roomInfo.RoomVersion, eventNIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range events {
+			collectMediaURIs(gjson.ParseBytes(ev.Content()), found)
+		}
+		afterEventNID = eventNIDs[len(eventNIDs)-1]
+	}
+	uris := make([]string, 0, len(found))
+	for uri := range found {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris, nil
+}
+
+// collectMediaURIs adds all mxc:// URIs in the value, e.g. the url of an m.image
+// message or the avatar_url of a member, to the found URIs.
+func collectMediaURIs(value gjson.Result, found map[string]struct{}) {
+	switch {
+	case value.IsObject() || value.IsArray():
+		value.ForEach(func(_, v gjson.Result) bool {
+			collectMediaURIs(v, found)
+			return true
+		})
+	case value.Type == gjson.String && strings.HasPrefix(value.Str, "mxc://"):
+		found[value.Str] = struct{}{}
+	}
+}
@@ -17,6 +17,7 @@ package query
 import (
 	"context"
 	"encoding/json"
+	"reflect"
 	"testing"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 	"github.com/matrix-org/dendrite/test"
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/tidwall/gjson"
 )
 
 // used to implement RoomserverInternalAPIEventDB to test getAuthChain
@@ -186,3 +188,24 @@ func TestCurrentEventIsNil(t *testing.T) {
 		}
 	})
 }
+
+func TestCollectMediaURIs(t *testing.T) {
+	found := map[string]struct{}{}
+	for _, content := range []string{
+		`{"msgtype":"m.image","body":"see mxc://example.com/other","url":"mxc://example.com/image","info":{"thumbnail_url":"mxc://example.com/thumb"}}`,
+		`{"membership":"join","avatar_url":"mxc://remote.com/avatar"}`,
+		`{"msgtype":"m.file","file":{"url":"mxc://example.com/encrypted"}}`,
+		`{"images":[{"url":"mxc://example.com/image"}]}`,
+	} {
+		collectMediaURIs(gjson.Parse(content), found)
+	}
+	want := map[string]struct{}{
+		"mxc://example.com/image":     {},
+		"mxc://example.com/thumb":     {},
+		"mxc://remote.com/avatar":     {},
+		"mxc://example.com/encrypted": {},
+	}
+	if !reflect.DeepEqual(want, found) {
+		t.Fatalf("expected media URIs %v, got %v", want, found)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+
+	fsAPI "github.com/matrix-org/dendrite/federationapi/api"
+)
+
+// QueryTimestampToEvent finds the event closest to the given timestamp in the
+// given direction. If the local timeline has no such event, or the event found
+// is next to a gap in the timeline, and allowRemote is set, other servers in the
+// room are asked as well and the closest of the results is returned.
+// Returns nil if no event could be found.
+func (r *Queryer) QueryTimestampToEvent(
+	ctx context.Context, roomID spec.RoomID, ts spec.Timestamp, backwards, allowRemote bool,
+) (*fsAPI.RespTimestampToEvent, error) {
+	roomInfo, err := r.QueryRoomInfo(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("r.QueryRoomInfo: %w", err)
+	}
+	if roomInfo == nil || roomInfo.IsStub() {
+		return nil, nil
+	}
+
+	var local *fsAPI.RespTimestampToEvent
+	nextToGap := true
+	eventID, originServerTS, err := r.DB.EventByTimestamp(ctx, roomInfo.RoomNID, ts, backwards)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return nil, fmt.Errorf("r.DB.EventByTimestamp: %w", err)
+	default:
+		local = &fsAPI.RespTimestampToEvent{EventID: eventID, OriginServerTS: originServerTS}
+		events, err := r.DB.EventsFromIDs(ctx, roomInfo, []string{eventID})
+		if err != nil {
+			return nil, fmt.Errorf("r.DB.EventsFromIDs: %w", err)
+		}
+		if len(events) == 1 {
+			_, missingPrev, err := r.DB.MissingAuthPrevEvents(ctx, events[0].PDU)
+			if err != nil {
+				return nil, fmt.Errorf("r.DB.MissingAuthPrevEvents: %w", err)
+			}
+			nextToGap = len(missingPrev) > 0
+		}
+	}
+
+	if !nextToGap || !allowRemote || r.FSAPI == nil {
+		return local, nil
+	}
+
+	remote := r.remoteTimestampToEvent(ctx, roomID, roomInfo.RoomVersion, ts, backwards)
+	if remote == nil {
+		return local, nil
+	}
+	if local == nil || timestampDistance(ts, remote.OriginServerTS) < timestampDistance(ts, local.OriginServerTS) {
+		return remote, nil
+	}
+	return local, nil
+}
+
+// remoteTimestampToEvent asks the other servers in the room for the event closest
+// to the timestamp, returning the first answer which turns out to be a valid event.
+func (r *Queryer) remoteTimestampToEvent(
+	ctx context.Context, roomID spec.RoomID, roomVersion gomatrixserverlib.RoomVersion, ts spec.Timestamp, backwards bool,
+) *fsAPI.RespTimestampToEvent {
+	var queryRes fsAPI.QueryJoinedHostServerNamesInRoomResponse
+	if err := r.FSAPI.QueryJoinedHostServerNamesInRoom(ctx, &fsAPI.QueryJoinedHostServerNamesInRoomRequest{
+		RoomID:             roomID.String(),
+		ExcludeSelf:        true,
+		ExcludeBlacklisted: true,
+	}, &queryRes); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("failed to QueryJoinedHostServerNamesInRoom")
+		return nil
+	}
+
+	dir := "f"
+	if backwards {
+		dir = "b"
+	}
+	for _, serverName := range queryRes.ServerNames {
+		if r.IsLocalServerName(serverName) {
+			continue
+		}
+		res, err := r.FSAPI.TimestampToEvent(ctx, r.Cfg.Global.ServerName, serverName, roomID.String(), ts, dir)
+		if err != nil {
+			util.GetLogger(ctx).WithError(err).Debugf("failed to query timestamp_to_event on %s", serverName)
+			continue
+		}
+		if res.EventID == "" {
+			continue
+		}
+		event, err := r.fetchRemoteEvent(ctx, serverName, roomID, roomVersion, res.EventID)
+		if err != nil {
+			util.GetLogger(ctx).WithError(err).Warnf("ignoring timestamp_to_event result %s from %s", res.EventID, serverName)
+			continue
+		}
+		return &fsAPI.RespTimestampToEvent{EventID: event.EventID(), OriginServerTS: event.OriginServerTS()}
+	}
+	return nil
+}
+
+// fetchRemoteEvent fetches the event from the server and checks that it is a validly
+// signed event of the room, so that servers can't point clients at arbitrary events.
+func (r *Queryer) fetchRemoteEvent(
+	ctx context.Context, serverName spec.ServerName, roomID spec.RoomID, roomVersion gomatrixserverlib.RoomVersion, eventID string,
+) (gomatrixserverlib.PDU, error) {
+	verImpl, err := gomatrixserverlib.GetRoomVersion(roomVersion)
+	if err != nil {
+		return nil, err
+	}
+	txn, err := r.FSAPI.GetEvent(ctx, r.Cfg.Global.ServerName, serverName, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("r.FSAPI.GetEvent: %w", err)
+	}
+	if len(txn.PDUs) == 0 {
+		return nil, fmt.Errorf("server returned no event")
+	}
+	event, err := verImpl.NewEventFromUntrustedJSON(txn.PDUs[0])
+	if err != nil {
+		return nil, fmt.Errorf("verImpl.NewEventFromUntrustedJSON: %w", err)
+	}
+	if event.EventID() != eventID {
+		return nil, fmt.Errorf("server returned event %s instead", event.EventID())
+	}
+	if event.RoomID().String() != roomID.String() {
+		return nil, fmt.Errorf("event belongs to room %s", event.RoomID().String())
+	}
+	if err = gomatrixserverlib.VerifyEventSignatures(ctx, event, r.FSAPI.KeyRing(), func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+		return r.QueryUserIDForSender(ctx, roomID, senderID)
+	}); err != nil {
+		return nil, fmt.Errorf("gomatrixserverlib.VerifyEventSignatures: %w", err)
+	}
+	return event, nil
+}
+
+func timestampDistance(a, b spec.Timestamp) spec.Timestamp {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
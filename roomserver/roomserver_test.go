@@ -3,10 +3,13 @@ package roomserver_test
 import (
 	"context"
 	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
 
+	fsAPI "github.com/matrix-org/dendrite/federationapi/api"
 	"github.com/matrix-org/dendrite/federationapi/statistics"
 	"github.com/matrix-org/dendrite/internal/caching"
 	"github.com/matrix-org/dendrite/internal/eventutil"
@@ -1194,6 +1197,139 @@ func TestStateReset(t *testing.T) {
 	})
 }
 
+func TestKnock(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	charlie := test.NewUser(t)
+	ctx := context.Background()
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		natsInstance := jetstream.NATSInstance{}
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		rsAPI.SetFederationAPI(nil, nil)
+
+		publicRoom := test.NewRoom(t, alice, test.RoomVersion(gomatrixserverlib.RoomVersionV10))
+		knockRoom := test.NewRoom(t, alice, test.RoomVersion(gomatrixserverlib.RoomVersionV10))
+		knockRoom.CreateAndInsert(t, alice, spec.MRoomJoinRules, map[string]any{"join_rule": spec.KnockRestricted, "allow": []any{}}, test.WithStateKey(""))
+		knockRoom.CreateAndInsert(t, alice, spec.MRoomMember, map[string]any{"membership": spec.Ban}, test.WithStateKey(charlie.ID))
+		for _, room := range []*test.Room{publicRoom, knockRoom} {
+			if err := api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", "test", nil, false); err != nil {
+				t.Fatalf("failed to send events: %v", err)
+			}
+		}
+
+		membership := func(roomID, userID string) string {
+			ev := api.GetStateEvent(ctx, rsAPI, roomID, gomatrixserverlib.StateKeyTuple{EventType: spec.MRoomMember, StateKey: userID})
+			if ev == nil {
+				return ""
+			}
+			m, _ := ev.Membership()
+			return m
+		}
+
+		// The join rules of the public room don't allow knocking.
+		if _, err := rsAPI.PerformKnock(ctx, &api.PerformKnockRequest{RoomIDOrAlias: publicRoom.ID, UserID: bob.ID}); err == nil {
+			t.Fatalf("expected knocking on the public room to fail")
+		}
+		// Banned users can't knock.
+		if _, err := rsAPI.PerformKnock(ctx, &api.PerformKnockRequest{RoomIDOrAlias: knockRoom.ID, UserID: charlie.ID}); err == nil {
+			t.Fatalf("expected knocking as a banned user to fail")
+		}
+
+		roomID, err := rsAPI.PerformKnock(ctx, &api.PerformKnockRequest{
+			RoomIDOrAlias: knockRoom.ID,
+			UserID:        bob.ID,
+			Content:       map[string]interface{}{"reason": "let me in"},
+		})
+		if err != nil {
+			t.Fatalf("failed to knock: %v", err)
+		}
+		if roomID != knockRoom.ID {
+			t.Fatalf("got room ID %s, want %s", roomID, knockRoom.ID)
+		}
+		if m := membership(knockRoom.ID, bob.ID); m != spec.Knock {
+			t.Fatalf("got membership %q, want knock", m)
+		}
+
+		// The knock can be rescinded by leaving the room.
+		bobUserID, err := spec.NewUserID(bob.ID, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = rsAPI.PerformLeave(ctx, &api.PerformLeaveRequest{RoomID: knockRoom.ID, Leaver: *bobUserID}, &api.PerformLeaveResponse{}); err != nil {
+			t.Fatalf("failed to rescind knock: %v", err)
+		}
+		if m := membership(knockRoom.ID, bob.ID); m != spec.Leave {
+			t.Fatalf("got membership %q, want leave", m)
+		}
+	})
+}
+
+type fakeKnockFederationAPI struct {
+	fsAPI.RoomserverFederationAPI
+	knock        gomatrixserverlib.PDU
+	knockedVia   spec.ServerName
+	leaveServers []spec.ServerName
+}
+
+func (f *fakeKnockFederationAPI) PerformKnock(ctx context.Context, req *fsAPI.PerformKnockRequest, res *fsAPI.PerformKnockResponse) error {
+	res.Event = f.knock
+	res.RoomVersion = f.knock.Version()
+	res.KnockedVia = f.knockedVia
+	return nil
+}
+
+func (f *fakeKnockFederationAPI) PerformLeave(ctx context.Context, req *fsAPI.PerformLeaveRequest, res *fsAPI.PerformLeaveResponse) error {
+	f.leaveServers = req.ServerNames
+	return nil
+}
+
+func TestKnockOverFederation(t *testing.T) {
+	_, privKey, _ := ed25519.GenerateKey(nil)
+	alice := test.NewUser(t, test.WithSigningServer("remote", "ed25519:remote", privKey))
+	bob := test.NewUser(t)
+	ctx := context.Background()
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		natsInstance := jetstream.NATSInstance{}
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+
+		room := test.NewRoom(t, alice, test.RoomVersion(gomatrixserverlib.RoomVersionV10))
+		room.CreateAndInsert(t, alice, spec.MRoomJoinRules, map[string]any{"join_rule": spec.KnockRestricted, "allow": []any{}}, test.WithStateKey(""))
+		knock := room.CreateEvent(t, bob, spec.MRoomMember, map[string]any{"membership": spec.Knock}, test.WithStateKey(bob.ID))
+		fedAPI := &fakeKnockFederationAPI{knock: knock.PDU, knockedVia: "via.server"}
+		rsAPI.SetFederationAPI(fedAPI, nil)
+
+		if _, err := rsAPI.PerformKnock(ctx, &api.PerformKnockRequest{
+			RoomIDOrAlias: room.ID,
+			UserID:        bob.ID,
+			ServerNames:   []spec.ServerName{"via.server"},
+		}); err != nil {
+			t.Fatalf("failed to knock: %v", err)
+		}
+
+		// The knock is rescinded through the server it was sent through first.
+		bobUserID, err := spec.NewUserID(bob.ID, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = rsAPI.PerformLeave(ctx, &api.PerformLeaveRequest{RoomID: room.ID, Leaver: *bobUserID}, &api.PerformLeaveResponse{}); err != nil {
+			t.Fatalf("failed to rescind knock: %v", err)
+		}
+		assert.Equal(t, []spec.ServerName{"via.server", "remote"}, fedAPI.leaveServers)
+	})
+}
+
 func TestNewServerACLs(t *testing.T) {
 	alice := test.NewUser(t)
 	roomWithACL := test.NewRoom(t, alice)
@@ -1284,3 +1420,311 @@ func TestRoomConsumerRecreation(t *testing.T) {
 	wantAckWait := input.MaximumMissingProcessingTime + (time.Second * 10)
 	assert.Equal(t, wantAckWait, info.Config.AckWait)
 }
+
+func TestTimestampToEvent(t *testing.T) {
+	alice := test.NewUser(t)
+	room := test.NewRoom(t, alice)
+	ctx := context.Background()
+
+	// Create some messages well after the room creation events.
+	base := time.Now().Add(time.Hour)
+	var messages []*types.HeaderedEvent
+	for i := 0; i < 3; i++ {
+		messages = append(messages, room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{
+			"body": fmt.Sprintf("message %d", i),
+		}, test.WithTimestamp(base.Add(time.Duration(i)*time.Minute))))
+	}
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		natsInstance := jetstream.NATSInstance{}
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		rsAPI.SetFederationAPI(nil, nil)
+
+		if err := api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+		roomID, err := spec.NewRoomID(room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ts := spec.AsTimestamp(base.Add(30 * time.Second))
+		testCases := []struct {
+			name      string
+			ts        spec.Timestamp
+			backwards bool
+			want      *types.HeaderedEvent
+		}{
+			{name: "forwards", ts: ts, want: messages[1]},
+			{name: "backwards", ts: ts, backwards: true, want: messages[0]},
+			{name: "exact match", ts: messages[2].OriginServerTS(), want: messages[2]},
+			{name: "nothing after the last event", ts: messages[2].OriginServerTS() + 1},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				res, err := rsAPI.QueryTimestampToEvent(ctx, *roomID, tc.ts, tc.backwards, true)
+				if err != nil {
+					t.Fatalf("failed to query timestamp_to_event: %v", err)
+				}
+				if tc.want == nil {
+					if res != nil {
+						t.Fatalf("expected no event, got %s", res.EventID)
+					}
+					return
+				}
+				if res == nil {
+					t.Fatalf("expected event %s, got none", tc.want.EventID())
+				}
+				if res.EventID != tc.want.EventID() || res.OriginServerTS != tc.want.OriginServerTS() {
+					t.Fatalf("got event %s at %d, want %s at %d", res.EventID, res.OriginServerTS, tc.want.EventID(), tc.want.OriginServerTS())
+				}
+			})
+		}
+
+		// Unknown rooms don't return an event.
+		unknownRoomID, err := spec.NewRoomID("!unknown:test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := rsAPI.QueryTimestampToEvent(ctx, *unknownRoomID, ts, false, true)
+		if err != nil || res != nil {
+			t.Fatalf("expected no event and no error for an unknown room, got %v, %v", res, err)
+		}
+	})
+}
+
+type fakeTimestampFederationAPI struct {
+	fsAPI.RoomserverFederationAPI
+	event   *types.HeaderedEvent
+	keyRing *gomatrixserverlib.KeyRing
+}
+
+func (f *fakeTimestampFederationAPI) QueryJoinedHostServerNamesInRoom(ctx context.Context, req *fsAPI.QueryJoinedHostServerNamesInRoomRequest, res *fsAPI.QueryJoinedHostServerNamesInRoomResponse) error {
+	res.ServerNames = []spec.ServerName{"remote"}
+	return nil
+}
+
+func (f *fakeTimestampFederationAPI) TimestampToEvent(ctx context.Context, origin, s spec.ServerName, roomID string, ts spec.Timestamp, dir string) (fsAPI.RespTimestampToEvent, error) {
+	// Claim a timestamp which differs from the event, to check that the event's own is used.
+	return fsAPI.RespTimestampToEvent{EventID: f.event.EventID(), OriginServerTS: ts}, nil
+}
+
+func (f *fakeTimestampFederationAPI) GetEvent(ctx context.Context, origin, s spec.ServerName, eventID string) (gomatrixserverlib.Transaction, error) {
+	return gomatrixserverlib.Transaction{PDUs: []json.RawMessage{f.event.JSON()}}, nil
+}
+
+func (f *fakeTimestampFederationAPI) KeyRing() *gomatrixserverlib.KeyRing {
+	return f.keyRing
+}
+
+// fakeKeyDatabase only knows the key of a single server.
+type fakeKeyDatabase struct {
+	serverName spec.ServerName
+	keyID      gomatrixserverlib.KeyID
+	publicKey  ed25519.PublicKey
+}
+
+func (f *fakeKeyDatabase) FetcherName() string {
+	return "fakeKeyDatabase"
+}
+
+func (f *fakeKeyDatabase) FetchKeys(ctx context.Context, requests map[gomatrixserverlib.PublicKeyLookupRequest]spec.Timestamp) (map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.PublicKeyLookupResult, error) {
+	results := map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.PublicKeyLookupResult{}
+	for req := range requests {
+		if req.ServerName != f.serverName || req.KeyID != f.keyID {
+			continue
+		}
+		results[req] = gomatrixserverlib.PublicKeyLookupResult{
+			VerifyKey:    gomatrixserverlib.VerifyKey{Key: spec.Base64Bytes(f.publicKey)},
+			ExpiredTS:    gomatrixserverlib.PublicKeyNotExpired,
+			ValidUntilTS: spec.AsTimestamp(time.Now().Add(time.Hour)),
+		}
+	}
+	return results, nil
+}
+
+func (f *fakeKeyDatabase) StoreKeys(ctx context.Context, results map[gomatrixserverlib.PublicKeyLookupRequest]gomatrixserverlib.PublicKeyLookupResult) error {
+	return nil
+}
+
+func TestRemoteTimestampToEvent(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t, test.WithSigningServer("remote", "ed25519:remote", test.PrivateKeyA))
+	// charlie claims to be signed with the same key as bob, but uses a different one.
+	charlie := test.NewUser(t, test.WithSigningServer("remote", "ed25519:remote", test.PrivateKeyB))
+	room := test.NewRoom(t, alice)
+	room.CreateAndInsert(t, bob, spec.MRoomMember, map[string]interface{}{"membership": spec.Join}, test.WithStateKey(bob.ID))
+	room.CreateAndInsert(t, charlie, spec.MRoomMember, map[string]interface{}{"membership": spec.Join}, test.WithStateKey(charlie.ID))
+	otherRoom := test.NewRoom(t, bob)
+	ctx := context.Background()
+
+	// The remote events are newer than anything we know about locally.
+	remoteTime := time.Now().Add(time.Hour)
+	validEvent := room.CreateEvent(t, bob, "m.room.message", map[string]interface{}{"body": "valid"}, test.WithTimestamp(remoteTime))
+	badSignature := room.CreateEvent(t, charlie, "m.room.message", map[string]interface{}{"body": "bad signature"}, test.WithTimestamp(remoteTime))
+	wrongRoom := otherRoom.CreateEvent(t, bob, "m.room.message", map[string]interface{}{"body": "wrong room"}, test.WithTimestamp(remoteTime))
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		natsInstance := jetstream.NATSInstance{}
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		fedAPI := &fakeTimestampFederationAPI{
+			keyRing: &gomatrixserverlib.KeyRing{
+				KeyDatabase: &fakeKeyDatabase{
+					serverName: "remote",
+					keyID:      "ed25519:remote",
+					publicKey:  test.PrivateKeyA.Public().(ed25519.PublicKey),
+				},
+			},
+		}
+		rsAPI.SetFederationAPI(fedAPI, fedAPI.keyRing)
+
+		if err := api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+		roomID, err := spec.NewRoomID(room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testCases := []struct {
+			name    string
+			event   *types.HeaderedEvent
+			wantErr bool
+		}{
+			{name: "valid event is returned", event: validEvent},
+			{name: "event with a bad signature is ignored", event: badSignature, wantErr: true},
+			{name: "event from another room is ignored", event: wrongRoom, wantErr: true},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				fedAPI.event = tc.event
+				res, err := rsAPI.QueryTimestampToEvent(ctx, *roomID, spec.AsTimestamp(remoteTime.Add(-time.Minute)), false, true)
+				if err != nil {
+					t.Fatalf("failed to query timestamp_to_event: %v", err)
+				}
+				if tc.wantErr {
+					if res != nil {
+						t.Fatalf("expected no event, got %s", res.EventID)
+					}
+					return
+				}
+				if res == nil {
+					t.Fatalf("expected event %s, got none", tc.event.EventID())
+				}
+				if res.EventID != tc.event.EventID() || res.OriginServerTS != tc.event.OriginServerTS() {
+					t.Fatalf("got event %s at %d, want %s at %d", res.EventID, res.OriginServerTS, tc.event.EventID(), tc.event.OriginServerTS())
+				}
+			})
+		}
+	})
+}
+
+func TestAdminRooms(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	ctx := context.Background()
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		natsInstance := jetstream.NATSInstance{}
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		rsAPI.SetFederationAPI(nil, nil)
+
+		// The named room has more events, the unnamed room has more members.
+		namedRoom := test.NewRoom(t, alice)
+		namedRoom.CreateAndInsert(t, alice, spec.MRoomName, map[string]interface{}{"name": "Named room"}, test.WithStateKey(""))
+		for i := 0; i < 3; i++ {
+			namedRoom.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "hello"})
+		}
+		unnamedRoom := test.NewRoom(t, alice)
+		unnamedRoom.CreateAndInsert(t, bob, spec.MRoomMember, map[string]interface{}{"membership": spec.Join}, test.WithStateKey(bob.ID))
+		for _, room := range []*test.Room{namedRoom, unnamedRoom} {
+			if err := api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", "test", nil, false); err != nil {
+				t.Fatalf("failed to send events: %v", err)
+			}
+		}
+
+		testCases := []struct {
+			name string
+			req  api.QueryAdminRoomsRequest
+			want []string
+		}{
+			{name: "by name", req: api.QueryAdminRoomsRequest{Limit: 10}, want: []string{unnamedRoom.ID, namedRoom.ID}},
+			{name: "by name backwards", req: api.QueryAdminRoomsRequest{Limit: 10, Backwards: true}, want: []string{namedRoom.ID, unnamedRoom.ID}},
+			{name: "by joined members", req: api.QueryAdminRoomsRequest{OrderBy: api.AdminRoomsOrderByJoinedMembers, Limit: 10}, want: []string{namedRoom.ID, unnamedRoom.ID}},
+			{name: "by events", req: api.QueryAdminRoomsRequest{OrderBy: api.AdminRoomsOrderByEvents, Limit: 10, Backwards: true}, want: []string{namedRoom.ID, unnamedRoom.ID}},
+			{name: "paginated", req: api.QueryAdminRoomsRequest{From: 1, Limit: 1}, want: []string{namedRoom.ID}},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				rooms, total, err := rsAPI.QueryAdminRooms(ctx, &tc.req)
+				if err != nil {
+					t.Fatalf("failed to query rooms: %v", err)
+				}
+				if total != 2 {
+					t.Fatalf("got total %d, want 2", total)
+				}
+				var got []string
+				for _, room := range rooms {
+					got = append(got, room.RoomID)
+					// The stats and names are looked up for the page, whatever the rooms are sorted by.
+					if room.RoomVersion == "" || room.JoinedMembers == 0 || room.Events == 0 {
+						t.Fatalf("expected stats for room %s, got %+v", room.RoomID, room)
+					}
+					if room.RoomID == namedRoom.ID && room.Name != "Named room" {
+						t.Fatalf("expected the name of room %s, got %q", room.RoomID, room.Name)
+					}
+				}
+				if !reflect.DeepEqual(got, tc.want) {
+					t.Fatalf("got rooms %v, want %v", got, tc.want)
+				}
+			})
+		}
+
+		room, state, err := rsAPI.QueryAdminRoom(ctx, unnamedRoom.ID)
+		if err != nil || room == nil {
+			t.Fatalf("failed to query room: %v", err)
+		}
+		if room.Creator != alice.ID || room.JoinedMembers != 2 || room.JoinedLocalMembers != 2 || room.StateEvents != len(state) {
+			t.Fatalf("unexpected room details %+v", room)
+		}
+		if room, _, err = rsAPI.QueryAdminRoom(ctx, "!unknown:test"); err != nil || room != nil {
+			t.Fatalf("expected no room and no error for an unknown room, got %v, %v", room, err)
+		}
+
+		// Local users can't join blocked rooms until they are unblocked.
+		joinReq := &api.PerformJoinRequest{RoomIDOrAlias: namedRoom.ID, UserID: bob.ID}
+		if err = rsAPI.PerformAdminBlockRoom(ctx, namedRoom.ID, alice.ID, true); err != nil {
+			t.Fatalf("failed to block room: %v", err)
+		}
+		blocked, err := rsAPI.QueryAdminBlockedRoom(ctx, namedRoom.ID)
+		if err != nil || blocked == nil || blocked.BlockedBy != alice.ID {
+			t.Fatalf("expected the room to be blocked by alice, got %+v, %v", blocked, err)
+		}
+		if _, _, err = rsAPI.PerformJoin(ctx, joinReq); err == nil {
+			t.Fatalf("expected joining a blocked room to fail")
+		} else if _, ok := err.(api.ErrNotAllowed); !ok {
+			t.Fatalf("expected joining a blocked room to be forbidden, got %v", err)
+		}
+		if err = rsAPI.PerformAdminBlockRoom(ctx, namedRoom.ID, alice.ID, false); err != nil {
+			t.Fatalf("failed to unblock room: %v", err)
+		}
+		if _, _, err = rsAPI.PerformJoin(ctx, joinReq); err != nil {
+			t.Fatalf("failed to join unblocked room: %v", err)
+		}
+	})
+}
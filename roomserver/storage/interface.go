@@ -102,6 +102,11 @@ type Database interface {
 	// If this returns an error then no further action is required.
 	// IsEventRejected returns true if the event is known and rejected.
 	IsEventRejected(ctx context.Context, roomNID types.RoomNID, eventID string) (rejected bool, err error)
+	// EventByTimestamp returns the closest event to the timestamp in the given direction.
+	// Returns sql.ErrNoRows if there is no such event.
+	EventByTimestamp(ctx context.Context, roomNID types.RoomNID, timestamp spec.Timestamp, backwards bool) (eventID string, originServerTS spec.Timestamp, err error)
+	// EventNIDsForRoom returns up to limit event NIDs in the room which are greater than afterEventNID, in ascending order.
+	EventNIDsForRoom(ctx context.Context, roomNID types.RoomNID, afterEventNID types.EventNID, limit int) ([]types.EventNID, error)
 	GetRoomUpdater(ctx context.Context, roomInfo *types.RoomInfo) (*shared.RoomUpdater, error)
 	// Look up event references for the latest events in the room and the current state snapshot.
 	// Returns the latest events, the current state and the maximum depth of the latest events plus 1.
@@ -149,6 +154,27 @@ type Database interface {
 	GetPublishedRooms(ctx context.Context, networkID string, includeAllNetworks bool) ([]string, error)
 	// Returns whether a given room is published or not.
 	GetPublishedRoom(ctx context.Context, roomID string) (bool, error)
+	// InsertReportedEvent stores a report of an event by a local user, returning the ID of the report.
+	InsertReportedEvent(ctx context.Context, report *types.ReportedEvent) (int64, error)
+	// GetReportedEvents returns the reports matching the given room ID, reporting user ID and resolved
+	// state, which are ignored if empty or nil, along with the total number of matching reports.
+	GetReportedEvents(ctx context.Context, roomID, userID string, resolved *bool, from, limit uint64, backwards bool) ([]types.ReportedEvent, int64, error)
+	// GetReportedEvent returns the report with the given ID, or sql.ErrNoRows if there is none.
+	GetReportedEvent(ctx context.Context, reportID int64) (*types.ReportedEvent, error)
+	// ResolveReportedEvent marks a report as resolved by the given admin. Returns false if there is no unresolved report with the ID.
+	ResolveReportedEvent(ctx context.Context, reportID int64, resolvedBy string) (bool, error)
+	// SetRoomBlocked blocks or unblocks local users from joining the room.
+	SetRoomBlocked(ctx context.Context, roomID, blockedBy string, blocked bool) error
+	// GetBlockedRoom returns the blocked room with the given ID, or nil if the room isn't blocked.
+	GetBlockedRoom(ctx context.Context, roomID string) (*types.BlockedRoom, error)
+	// GetRoomStats returns the member and event counts of the given rooms, skipping those that the server has no state for.
+	GetRoomStats(ctx context.Context, roomIDs []string) ([]types.RoomStats, error)
+	// GetJoinedMemberCounts returns the number of joined members of every room that the server has state for.
+	GetJoinedMemberCounts(ctx context.Context) (map[string]int64, error)
+	// GetEventCounts returns the number of events of every room that the server has state for.
+	GetEventCounts(ctx context.Context) (map[string]int64, error)
+	// GetRoomStatsForRoom returns the member and event counts of the room, or nil if the server has no state for it.
+	GetRoomStatsForRoom(ctx context.Context, roomID string) (*types.RoomStats, error)
 
 	// TODO: factor out - from currentstateserver
 
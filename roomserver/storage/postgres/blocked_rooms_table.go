@@ -0,0 +1,94 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/roomserver/types"
+)
+
+const blockedRoomsSchema = `
+-- Stores rooms which local users are prevented from joining
+CREATE TABLE IF NOT EXISTS roomserver_blocked_rooms (
+    -- The room ID of the blocked room
+    room_id TEXT NOT NULL PRIMARY KEY,
+    -- The admin who blocked the room
+    blocked_by TEXT NOT NULL,
+    -- When the room was blocked
+    blocked_ts BIGINT NOT NULL
+);
+`
+
+const insertBlockedRoomSQL = "" +
+	"INSERT INTO roomserver_blocked_rooms (room_id, blocked_by, blocked_ts) VALUES ($1, $2, $3)" +
+	" ON CONFLICT (room_id) DO UPDATE SET blocked_by = $2, blocked_ts = $3"
+
+const deleteBlockedRoomSQL = "" +
+	"DELETE FROM roomserver_blocked_rooms WHERE room_id = $1"
+
+const selectBlockedRoomSQL = "" +
+	"SELECT room_id, blocked_by, blocked_ts FROM roomserver_blocked_rooms WHERE room_id = $1"
+
+type blockedRoomsStatements struct {
+	insertBlockedRoomStmt *sql.Stmt
+	deleteBlockedRoomStmt *sql.Stmt
+	selectBlockedRoomStmt *sql.Stmt
+}
+
+func CreateBlockedRoomsTable(db *sql.DB) error {
+	_, err := db.Exec(blockedRoomsSchema)
+	return err
+}
+
+func PrepareBlockedRoomsTable(db *sql.DB) (tables.BlockedRooms, error) {
+	s := &blockedRoomsStatements{}
+
+	return s, sqlutil.StatementList{
+		{&s.insertBlockedRoomStmt, insertBlockedRoomSQL},
+		{&s.deleteBlockedRoomStmt, deleteBlockedRoomSQL},
+		{&s.selectBlockedRoomStmt, selectBlockedRoomSQL},
+	}.Prepare(db)
+}
+
+func (s *blockedRoomsStatements) InsertBlockedRoom(
+	ctx context.Context, txn *sql.Tx, room *types.BlockedRoom,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.insertBlockedRoomStmt)
+	_, err := stmt.ExecContext(ctx, room.RoomID, room.BlockedBy, room.BlockedTS)
+	return err
+}
+
+func (s *blockedRoomsStatements) DeleteBlockedRoom(
+	ctx context.Context, txn *sql.Tx, roomID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deleteBlockedRoomStmt)
+	_, err := stmt.ExecContext(ctx, roomID)
+	return err
+}
+
+func (s *blockedRoomsStatements) SelectBlockedRoom(
+	ctx context.Context, txn *sql.Tx, roomID string,
+) (*types.BlockedRoom, error) {
+	var room types.BlockedRoom
+	stmt := sqlutil.TxStmt(txn, s.selectBlockedRoomStmt)
+	if err := stmt.QueryRowContext(ctx, roomID).Scan(&room.RoomID, &room.BlockedBy, &room.BlockedTS); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// UpAddEventOriginServerTS adds the origin_server_ts column to the events table, fills
+// it in from the stored event JSON and indexes it for timestamp_to_event lookups.
+func UpAddEventOriginServerTS(ctx context.Context, tx *sql.Tx) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_name = 'roomserver_events' AND column_name = 'origin_server_ts'
+	)`).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check for column: %w", err)
+	}
+	if !exists {
+		_, err = tx.ExecContext(ctx, `ALTER TABLE roomserver_events ADD COLUMN origin_server_ts BIGINT NOT NULL DEFAULT 0;
+UPDATE roomserver_events AS e SET origin_server_ts = COALESCE((j.event_json::jsonb->>'origin_server_ts')::BIGINT, 0)
+	FROM roomserver_event_json AS j WHERE j.event_nid = e.event_nid;`)
+		if err != nil {
+			return fmt.Errorf("failed to execute upgrade: %w", err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS roomserver_events_origin_server_ts_idx ON roomserver_events (room_nid, origin_server_ts);`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownAddEventOriginServerTS(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DROP INDEX IF EXISTS roomserver_events_origin_server_ts_idx;
+ALTER TABLE roomserver_events DROP COLUMN IF EXISTS origin_server_ts;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/matrix-org/dendrite/roomserver/storage/postgres/deltas"
 	"github.com/matrix-org/dendrite/roomserver/storage/tables"
 	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/gomatrixserverlib/spec"
 )
 
 const eventsSchema = `
@@ -63,7 +64,9 @@ CREATE TABLE IF NOT EXISTS roomserver_events (
     event_id TEXT NOT NULL CONSTRAINT roomserver_event_id_unique UNIQUE,
     -- A list of numeric IDs for events that can authenticate this event.
 	auth_event_nids BIGINT[] NOT NULL,
-	is_rejected BOOLEAN NOT NULL DEFAULT FALSE
+	is_rejected BOOLEAN NOT NULL DEFAULT FALSE,
+	-- The origin_server_ts of the event, used to find events by timestamp.
+	origin_server_ts BIGINT NOT NULL DEFAULT 0
 );
 
 -- Create an index which helps in resolving membership events (event_type_nid = 5) - (used for history visibility)
@@ -71,8 +74,8 @@ CREATE INDEX IF NOT EXISTS roomserver_events_memberships_idx ON roomserver_event
 `
 
 const insertEventSQL = "" +
-	"INSERT INTO roomserver_events AS e (room_nid, event_type_nid, event_state_key_nid, event_id, auth_event_nids, depth, is_rejected)" +
-	" VALUES ($1, $2, $3, $4, $5, $6, $7)" +
+	"INSERT INTO roomserver_events AS e (room_nid, event_type_nid, event_state_key_nid, event_id, auth_event_nids, depth, is_rejected, origin_server_ts)" +
+	" VALUES ($1, $2, $3, $4, $5, $6, $7, $8)" +
 	" ON CONFLICT ON CONSTRAINT roomserver_event_id_unique DO UPDATE" +
 	" SET is_rejected = $7 WHERE e.event_id = $4 AND e.is_rejected = TRUE" +
 	" RETURNING event_nid, state_snapshot_nid"
@@ -144,6 +147,22 @@ const selectMaxEventDepthSQL = "" +
 const selectRoomNIDsForEventNIDsSQL = "" +
 	"SELECT event_nid, room_nid FROM roomserver_events WHERE event_nid = ANY($1)"
 
+// Find the closest event to a timestamp in either direction. Rejected events
+// and outliers aren't part of the timeline, so they are skipped.
+const selectEventByTimestampForwardSQL = "" +
+	"SELECT event_id, origin_server_ts FROM roomserver_events" +
+	" WHERE room_nid = $1 AND origin_server_ts >= $2 AND is_rejected = FALSE AND state_snapshot_nid != 0" +
+	" ORDER BY origin_server_ts ASC, event_nid ASC LIMIT 1"
+
+const selectEventByTimestampBackwardSQL = "" +
+	"SELECT event_id, origin_server_ts FROM roomserver_events" +
+	" WHERE room_nid = $1 AND origin_server_ts <= $2 AND is_rejected = FALSE AND state_snapshot_nid != 0" +
+	" ORDER BY origin_server_ts DESC, event_nid DESC LIMIT 1"
+
+// Page through the events in a room in the order they were stored.
+const selectEventNIDsForRoomSQL = "" +
+	"SELECT event_nid FROM roomserver_events WHERE room_nid = $1 AND event_nid > $2 ORDER BY event_nid ASC LIMIT $3"
+
 const selectEventRejectedSQL = "" +
 	"SELECT is_rejected FROM roomserver_events WHERE room_nid = $1 AND event_id = $2"
 
@@ -166,6 +185,9 @@ type eventStatements struct {
 	selectMaxEventDepthStmt                       *sql.Stmt
 	selectRoomNIDsForEventNIDsStmt                *sql.Stmt
 	selectEventRejectedStmt                       *sql.Stmt
+	selectEventByTimestampForwardStmt             *sql.Stmt
+	selectEventByTimestampBackwardStmt            *sql.Stmt
+	selectEventNIDsForRoomStmt                    *sql.Stmt
 }
 
 func CreateEventsTable(db *sql.DB) error {
@@ -180,6 +202,10 @@ func CreateEventsTable(db *sql.DB) error {
 			Version: "roomserver: drop column reference_sha from roomserver_events",
 			Up:      deltas.UpDropEventReferenceSHAEvents,
 		},
+		{
+			Version: "roomserver: add origin_server_ts to roomserver_events",
+			Up:      deltas.UpAddEventOriginServerTS,
+		},
 	}...)
 	return m.Up(context.Background())
 }
@@ -206,6 +232,9 @@ func PrepareEventsTable(db *sql.DB) (tables.Events, error) {
 		{&s.selectMaxEventDepthStmt, selectMaxEventDepthSQL},
 		{&s.selectRoomNIDsForEventNIDsStmt, selectRoomNIDsForEventNIDsSQL},
 		{&s.selectEventRejectedStmt, selectEventRejectedSQL},
+		{&s.selectEventByTimestampForwardStmt, selectEventByTimestampForwardSQL},
+		{&s.selectEventByTimestampBackwardStmt, selectEventByTimestampBackwardSQL},
+		{&s.selectEventNIDsForRoomStmt, selectEventNIDsForRoomSQL},
 	}.Prepare(db)
 }
 
@@ -218,6 +247,7 @@ func (s *eventStatements) InsertEvent(
 	eventID string,
 	authEventNIDs []types.EventNID,
 	depth int64,
+	originServerTS spec.Timestamp,
 	isRejected bool,
 ) (types.EventNID, types.StateSnapshotNID, error) {
 	var eventNID int64
@@ -226,7 +256,7 @@ func (s *eventStatements) InsertEvent(
 	err := stmt.QueryRowContext(
 		ctx, int64(roomNID), int64(eventTypeNID), int64(eventStateKeyNID),
 		eventID, eventNIDsAsArray(authEventNIDs), depth,
-		isRejected,
+		isRejected, originServerTS,
 	).Scan(&eventNID, &stateNID)
 	return types.EventNID(eventNID), types.StateSnapshotNID(stateNID), err
 }
@@ -582,3 +612,34 @@ func (s *eventStatements) SelectEventRejected(
 	err = stmt.QueryRowContext(ctx, roomNID, eventID).Scan(&rejected)
 	return
 }
+
+func (s *eventStatements) SelectEventByTimestamp(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, timestamp spec.Timestamp, backwards bool,
+) (eventID string, originServerTS spec.Timestamp, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectEventByTimestampForwardStmt)
+	if backwards {
+		stmt = sqlutil.TxStmt(txn, s.selectEventByTimestampBackwardStmt)
+	}
+	err = stmt.QueryRowContext(ctx, roomNID, timestamp).Scan(&eventID, &originServerTS)
+	return
+}
+
+func (s *eventStatements) SelectEventNIDsForRoom(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, afterEventNID types.EventNID, limit int,
+) ([]types.EventNID, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectEventNIDsForRoomStmt)
+	rows, err := stmt.QueryContext(ctx, roomNID, afterEventNID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectEventNIDsForRoom: rows.close() failed")
+	var eventNIDs []types.EventNID
+	var eventNID types.EventNID
+	for rows.Next() {
+		if err = rows.Scan(&eventNID); err != nil {
+			return nil, err
+		}
+		eventNIDs = append(eventNIDs, eventNID)
+	}
+	return eventNIDs, rows.Err()
+}
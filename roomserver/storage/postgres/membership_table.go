@@ -63,6 +63,9 @@ CREATE TABLE IF NOT EXISTS roomserver_membership (
 	-- room joins.
 	target_local BOOLEAN NOT NULL DEFAULT false,
 	forgotten BOOLEAN NOT NULL DEFAULT FALSE,
+	-- The server a pending knock of a local user was sent through, so that the
+	-- knock can be rescinded through the same server.
+	knocked_via TEXT NOT NULL DEFAULT '',
 	UNIQUE (room_nid, target_nid)
 );
 `
@@ -158,6 +161,12 @@ const selectServerInRoomSQL = "" +
 	" JOIN roomserver_event_state_keys ON roomserver_membership.target_nid = roomserver_event_state_keys.event_state_key_nid" +
 	" WHERE membership_nid = $1 AND room_nid = $2 AND event_state_key LIKE '%:' || $3 LIMIT 1"
 
+const updateKnockedViaSQL = "" +
+	"UPDATE roomserver_membership SET knocked_via = $3 WHERE room_nid = $1 AND target_nid = $2"
+
+const selectKnockedViaSQL = "" +
+	"SELECT knocked_via FROM roomserver_membership WHERE room_nid = $1 AND target_nid = $2"
+
 const selectJoinedUsersSQL = `
 SELECT DISTINCT target_nid
 FROM roomserver_membership m
@@ -182,6 +191,8 @@ type membershipStatements struct {
 	selectServerInRoomStmt                          *sql.Stmt
 	deleteMembershipStmt                            *sql.Stmt
 	selectJoinedUsersStmt                           *sql.Stmt
+	updateKnockedViaStmt                            *sql.Stmt
+	selectKnockedViaStmt                            *sql.Stmt
 }
 
 func CreateMembershipTable(db *sql.DB) error {
@@ -193,6 +204,9 @@ func CreateMembershipTable(db *sql.DB) error {
 	m.AddMigrations(sqlutil.Migration{
 		Version: "roomserver: add forgotten column",
 		Up:      deltas.UpAddForgottenColumn,
+	}, sqlutil.Migration{
+		Version: "roomserver: add knocked_via column",
+		Up:      deltas.UpAddKnockedViaColumn,
 	})
 	return m.Up(context.Background())
 }
@@ -218,6 +232,8 @@ func PrepareMembershipTable(db *sql.DB) (tables.Membership, error) {
 		{&s.selectServerInRoomStmt, selectServerInRoomSQL},
 		{&s.deleteMembershipStmt, deleteMembershipSQL},
 		{&s.selectJoinedUsersStmt, selectJoinedUsersSQL},
+		{&s.updateKnockedViaStmt, updateKnockedViaSQL},
+		{&s.selectKnockedViaStmt, selectKnockedViaSQL},
 	}.Prepare(db)
 }
 
@@ -473,3 +489,23 @@ func (s *membershipStatements) DeleteMembership(
 	)
 	return err
 }
+
+func (s *membershipStatements) UpdateKnockedVia(
+	ctx context.Context, txn *sql.Tx,
+	roomNID types.RoomNID, targetUserNID types.EventStateKeyNID, serverName spec.ServerName,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.updateKnockedViaStmt).ExecContext(
+		ctx, roomNID, targetUserNID, serverName,
+	)
+	return err
+}
+
+func (s *membershipStatements) SelectKnockedVia(
+	ctx context.Context, txn *sql.Tx,
+	roomNID types.RoomNID, targetUserNID types.EventStateKeyNID,
+) (serverName spec.ServerName, err error) {
+	err = sqlutil.TxStmt(txn, s.selectKnockedViaStmt).QueryRowContext(
+		ctx, roomNID, targetUserNID,
+	).Scan(&serverName)
+	return
+}
@@ -0,0 +1,193 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+)
+
+const reportedEventsSchema = `
+-- Stores events reported by local users
+CREATE TABLE IF NOT EXISTS roomserver_reported_events (
+    id BIGSERIAL PRIMARY KEY,
+    -- The room ID of the reported event
+    room_id TEXT NOT NULL,
+    -- The event ID of the reported event
+    event_id TEXT NOT NULL,
+    -- The user ID of the user who reported the event
+    reporting_user_id TEXT NOT NULL,
+    -- The reason given by the user, if any
+    reason TEXT NOT NULL DEFAULT '',
+    -- The score given by the user from -100 to 0, if any
+    score BIGINT,
+    -- When the report was received
+    received_ts BIGINT NOT NULL,
+    -- The admin who resolved the report, or empty if unresolved
+    resolved_by TEXT NOT NULL DEFAULT '',
+    -- When the report was resolved, or 0 if unresolved
+    resolved_ts BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS roomserver_reported_events_room_id_idx ON roomserver_reported_events (room_id);
+CREATE INDEX IF NOT EXISTS roomserver_reported_events_reporting_user_id_idx ON roomserver_reported_events (reporting_user_id);
+`
+
+const insertReportedEventSQL = "" +
+	"INSERT INTO roomserver_reported_events (room_id, event_id, reporting_user_id, reason, score, received_ts)" +
+	" VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+
+// The filters are ignored if empty; the resolved filter is one of the reportsResolved* constants.
+const reportedEventsFilterSQL = "" +
+	" WHERE ($1 = '' OR room_id = $1) AND ($2 = '' OR reporting_user_id = $2)" +
+	" AND ($3 = 0 OR ($3 = 1 AND resolved_ts = 0) OR ($3 = 2 AND resolved_ts <> 0))"
+
+const reportedEventsColumns = "" +
+	"SELECT id, room_id, event_id, reporting_user_id, reason, score, received_ts, resolved_by, resolved_ts" +
+	" FROM roomserver_reported_events"
+
+const selectReportedEventsSQL = reportedEventsColumns + reportedEventsFilterSQL +
+	" ORDER BY id ASC LIMIT $4 OFFSET $5"
+
+const selectReportedEventsBackwardsSQL = reportedEventsColumns + reportedEventsFilterSQL +
+	" ORDER BY id DESC LIMIT $4 OFFSET $5"
+
+const selectReportedEventsCountSQL = "" +
+	"SELECT COUNT(*) FROM roomserver_reported_events" + reportedEventsFilterSQL
+
+const selectReportedEventSQL = reportedEventsColumns + " WHERE id = $1"
+
+const updateReportedEventResolvedSQL = "" +
+	"UPDATE roomserver_reported_events SET resolved_by = $1, resolved_ts = $2 WHERE id = $3 AND resolved_ts = 0"
+
+const (
+	reportsResolvedAny = iota
+	reportsResolvedNo
+	reportsResolvedYes
+)
+
+type reportedEventsStatements struct {
+	insertReportedEventStmt           *sql.Stmt
+	selectReportedEventsStmt          *sql.Stmt
+	selectReportedEventsBackwardsStmt *sql.Stmt
+	selectReportedEventsCountStmt     *sql.Stmt
+	selectReportedEventStmt           *sql.Stmt
+	updateReportedEventResolvedStmt   *sql.Stmt
+}
+
+func CreateReportedEventsTable(db *sql.DB) error {
+	_, err := db.Exec(reportedEventsSchema)
+	return err
+}
+
+func PrepareReportedEventsTable(db *sql.DB) (tables.ReportedEvents, error) {
+	s := &reportedEventsStatements{}
+
+	return s, sqlutil.StatementList{
+		{&s.insertReportedEventStmt, insertReportedEventSQL},
+		{&s.selectReportedEventsStmt, selectReportedEventsSQL},
+		{&s.selectReportedEventsBackwardsStmt, selectReportedEventsBackwardsSQL},
+		{&s.selectReportedEventsCountStmt, selectReportedEventsCountSQL},
+		{&s.selectReportedEventStmt, selectReportedEventSQL},
+		{&s.updateReportedEventResolvedStmt, updateReportedEventResolvedSQL},
+	}.Prepare(db)
+}
+
+func (s *reportedEventsStatements) InsertReportedEvent(
+	ctx context.Context, txn *sql.Tx, report *types.ReportedEvent,
+) (reportID int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.insertReportedEventStmt)
+	err = stmt.QueryRowContext(
+		ctx, report.RoomID, report.EventID, report.ReportingUserID, report.Reason, report.Score, report.ReceivedTS,
+	).Scan(&reportID)
+	return
+}
+
+func (s *reportedEventsStatements) SelectReportedEvents(
+	ctx context.Context, txn *sql.Tx, roomID, userID string, resolved *bool, from, limit uint64, backwards bool,
+) ([]types.ReportedEvent, int64, error) {
+	resolvedFilter := reportsResolvedAny
+	if resolved != nil {
+		resolvedFilter = reportsResolvedNo
+		if *resolved {
+			resolvedFilter = reportsResolvedYes
+		}
+	}
+
+	var total int64
+	countStmt := sqlutil.TxStmt(txn, s.selectReportedEventsCountStmt)
+	if err := countStmt.QueryRowContext(ctx, roomID, userID, resolvedFilter).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	stmt := sqlutil.TxStmt(txn, s.selectReportedEventsStmt)
+	if backwards {
+		stmt = sqlutil.TxStmt(txn, s.selectReportedEventsBackwardsStmt)
+	}
+	rows, err := stmt.QueryContext(ctx, roomID, userID, resolvedFilter, limit, from)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectReportedEvents: rows.close() failed")
+
+	var reports []types.ReportedEvent
+	for rows.Next() {
+		report, err := scanReportedEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		reports = append(reports, *report)
+	}
+	return reports, total, rows.Err()
+}
+
+func (s *reportedEventsStatements) SelectReportedEvent(
+	ctx context.Context, txn *sql.Tx, reportID int64,
+) (*types.ReportedEvent, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectReportedEventStmt)
+	return scanReportedEvent(stmt.QueryRowContext(ctx, reportID))
+}
+
+func (s *reportedEventsStatements) UpdateReportedEventResolved(
+	ctx context.Context, txn *sql.Tx, reportID int64, resolvedBy string, resolvedTS spec.Timestamp,
+) (bool, error) {
+	stmt := sqlutil.TxStmt(txn, s.updateReportedEventResolvedStmt)
+	res, err := stmt.ExecContext(ctx, resolvedBy, resolvedTS, reportID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func scanReportedEvent(row interface{ Scan(...interface{}) error }) (*types.ReportedEvent, error) {
+	var report types.ReportedEvent
+	var score sql.NullInt64
+	if err := row.Scan(
+		&report.ID, &report.RoomID, &report.EventID, &report.ReportingUserID, &report.Reason,
+		&score, &report.ReceivedTS, &report.ResolvedBy, &report.ResolvedTS,
+	); err != nil {
+		return nil, err
+	}
+	if score.Valid {
+		report.Score = &score.Int64
+	}
+	return &report, nil
+}
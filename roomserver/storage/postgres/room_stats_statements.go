@@ -0,0 +1,145 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/roomserver/types"
+)
+
+// Only rooms with latest events are included, like in selectRoomIDsSQL. The counts
+// are expensive for large rooms, so they are only selected for specific rooms.
+var roomStatsColumns = "" +
+	"SELECT r.room_id, r.room_version," +
+	" (SELECT COUNT(*) FROM roomserver_membership m WHERE m.room_nid = r.room_nid" +
+	"  AND m.membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) + ")," +
+	" (SELECT COUNT(*) FROM roomserver_membership m WHERE m.room_nid = r.room_nid" +
+	"  AND m.membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) + " AND m.target_local)," +
+	" (SELECT COUNT(*) FROM roomserver_events e WHERE e.room_nid = r.room_nid)," +
+	" EXISTS (SELECT 1 FROM roomserver_blocked_rooms b WHERE b.room_id = r.room_id)" +
+	" FROM roomserver_rooms r WHERE array_length(r.latest_event_nids, 1) > 0"
+
+var selectRoomStatsSQL = roomStatsColumns + " AND r.room_id = ANY($1)"
+
+var selectRoomStatsForRoomSQL = roomStatsColumns + " AND r.room_id = $1"
+
+var selectJoinedMemberCountsSQL = "" +
+	"SELECT r.room_id, COUNT(*) FROM roomserver_rooms r" +
+	" JOIN roomserver_membership m ON m.room_nid = r.room_nid" +
+	" WHERE array_length(r.latest_event_nids, 1) > 0" +
+	" AND m.membership_nid = " + fmt.Sprintf("%d", tables.MembershipStateJoin) +
+	" GROUP BY r.room_id"
+
+const selectEventCountsSQL = "" +
+	"SELECT r.room_id, COUNT(*) FROM roomserver_rooms r" +
+	" JOIN roomserver_events e ON e.room_nid = r.room_nid" +
+	" WHERE array_length(r.latest_event_nids, 1) > 0" +
+	" GROUP BY r.room_id"
+
+type roomStatsStatements struct {
+	selectRoomStatsStmt          *sql.Stmt
+	selectRoomStatsForRoomStmt   *sql.Stmt
+	selectJoinedMemberCountsStmt *sql.Stmt
+	selectEventCountsStmt        *sql.Stmt
+}
+
+func PrepareRoomStatsStatements(db *sql.DB) (tables.RoomStats, error) {
+	s := &roomStatsStatements{}
+
+	return s, sqlutil.StatementList{
+		{&s.selectRoomStatsStmt, selectRoomStatsSQL},
+		{&s.selectRoomStatsForRoomStmt, selectRoomStatsForRoomSQL},
+		{&s.selectJoinedMemberCountsStmt, selectJoinedMemberCountsSQL},
+		{&s.selectEventCountsStmt, selectEventCountsSQL},
+	}.Prepare(db)
+}
+
+func (s *roomStatsStatements) SelectRoomStats(
+	ctx context.Context, txn *sql.Tx, roomIDs []string,
+) ([]types.RoomStats, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectRoomStatsStmt)
+	rows, err := stmt.QueryContext(ctx, pq.StringArray(roomIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectRoomStats: rows.close() failed")
+
+	stats := make([]types.RoomStats, 0, len(roomIDs))
+	for rows.Next() {
+		roomStats, err := scanRoomStats(rows)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, *roomStats)
+	}
+	return stats, rows.Err()
+}
+
+func (s *roomStatsStatements) SelectRoomStatsForRoom(
+	ctx context.Context, txn *sql.Tx, roomID string,
+) (*types.RoomStats, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectRoomStatsForRoomStmt)
+	return scanRoomStats(stmt.QueryRowContext(ctx, roomID))
+}
+
+func (s *roomStatsStatements) SelectJoinedMemberCounts(
+	ctx context.Context, txn *sql.Tx,
+) (map[string]int64, error) {
+	return selectRoomCounts(ctx, sqlutil.TxStmt(txn, s.selectJoinedMemberCountsStmt))
+}
+
+func (s *roomStatsStatements) SelectEventCounts(
+	ctx context.Context, txn *sql.Tx,
+) (map[string]int64, error) {
+	return selectRoomCounts(ctx, sqlutil.TxStmt(txn, s.selectEventCountsStmt))
+}
+
+func selectRoomCounts(ctx context.Context, stmt *sql.Stmt) (map[string]int64, error) {
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectRoomCounts: rows.close() failed")
+
+	counts := map[string]int64{}
+	var roomID string
+	var count int64
+	for rows.Next() {
+		if err = rows.Scan(&roomID, &count); err != nil {
+			return nil, err
+		}
+		counts[roomID] = count
+	}
+	return counts, rows.Err()
+}
+
+func scanRoomStats(row interface{ Scan(...interface{}) error }) (*types.RoomStats, error) {
+	var stats types.RoomStats
+	if err := row.Scan(
+		&stats.RoomID, &stats.RoomVersion, &stats.JoinedMembers, &stats.JoinedLocalMembers,
+		&stats.Events, &stats.Blocked,
+	); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/matrix-org/dendrite/roomserver/storage/tables"
 	"github.com/matrix-org/dendrite/roomserver/types"
 	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/gomatrixserverlib/spec"
 )
 
 type MembershipUpdater struct {
@@ -97,6 +98,18 @@ func (u *MembershipUpdater) Delete() error {
 	return u.d.MembershipTable.DeleteMembership(u.ctx, u.txn, u.roomNID, u.targetUserNID)
 }
 
+// SetKnockedVia records the server the knock of the user was sent through.
+func (u *MembershipUpdater) SetKnockedVia(serverName spec.ServerName) error {
+	return u.d.Writer.Do(u.d.DB, u.txn, func(txn *sql.Tx) error {
+		return u.d.MembershipTable.UpdateKnockedVia(u.ctx, txn, u.roomNID, u.targetUserNID, serverName)
+	})
+}
+
+// KnockedVia returns the server the knock of the user was sent through, if known.
+func (u *MembershipUpdater) KnockedVia() (spec.ServerName, error) {
+	return u.d.MembershipTable.SelectKnockedVia(u.ctx, u.txn, u.roomNID, u.targetUserNID)
+}
+
 func (u *MembershipUpdater) Update(newMembership tables.MembershipState, event *types.Event) (bool, []string, error) {
 	var inserted bool    // Did the query result in a membership change?
 	var retired []string // Did we retire any updates in the process?
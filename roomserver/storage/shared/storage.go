@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/matrix-org/dendrite/internal/eventutil"
 	"github.com/matrix-org/dendrite/roomserver/api"
@@ -36,18 +37,21 @@ const redactionsArePermanent = true
 type Database struct {
 	DB *sql.DB
 	EventDatabase
-	Cache              caching.RoomServerCaches
-	Writer             sqlutil.Writer
-	RoomsTable         tables.Rooms
-	StateSnapshotTable tables.StateSnapshot
-	StateBlockTable    tables.StateBlock
-	RoomAliasesTable   tables.RoomAliases
-	InvitesTable       tables.Invites
-	MembershipTable    tables.Membership
-	PublishedTable     tables.Published
-	Purge              tables.Purge
-	UserRoomKeyTable   tables.UserRoomKeys
-	GetRoomUpdaterFn   func(ctx context.Context, roomInfo *types.RoomInfo) (*RoomUpdater, error)
+	Cache               caching.RoomServerCaches
+	Writer              sqlutil.Writer
+	RoomsTable          tables.Rooms
+	StateSnapshotTable  tables.StateSnapshot
+	StateBlockTable     tables.StateBlock
+	RoomAliasesTable    tables.RoomAliases
+	InvitesTable        tables.Invites
+	MembershipTable     tables.Membership
+	PublishedTable      tables.Published
+	Purge               tables.Purge
+	UserRoomKeyTable    tables.UserRoomKeys
+	ReportedEventsTable tables.ReportedEvents
+	BlockedRoomsTable   tables.BlockedRooms
+	RoomStats           tables.RoomStats
+	GetRoomUpdaterFn    func(ctx context.Context, roomInfo *types.RoomInfo) (*RoomUpdater, error)
 }
 
 // EventDatabase contains all tables needed to work with events
@@ -663,6 +667,14 @@ func (d *Database) IsEventRejected(ctx context.Context, roomNID types.RoomNID, e
 	return d.EventsTable.SelectEventRejected(ctx, nil, roomNID, eventID)
 }
 
+func (d *Database) EventByTimestamp(ctx context.Context, roomNID types.RoomNID, timestamp spec.Timestamp, backwards bool) (string, spec.Timestamp, error) {
+	return d.EventsTable.SelectEventByTimestamp(ctx, nil, roomNID, timestamp, backwards)
+}
+
+func (d *Database) EventNIDsForRoom(ctx context.Context, roomNID types.RoomNID, afterEventNID types.EventNID, limit int) ([]types.EventNID, error) {
+	return d.EventsTable.SelectEventNIDsForRoom(ctx, nil, roomNID, afterEventNID, limit)
+}
+
 func (d *Database) AssignRoomNID(ctx context.Context, roomID spec.RoomID, roomVersion gomatrixserverlib.RoomVersion) (roomNID types.RoomNID, err error) {
 	// This should already be checked, let's check it anyway.
 	_, err = gomatrixserverlib.GetRoomVersion(roomVersion)
@@ -787,6 +799,7 @@ func (d *EventDatabase) StoreEvent(
 			event.EventID(),
 			authEventNIDs,
 			event.Depth(),
+			event.OriginServerTS(),
 			isRejected,
 		); err != nil {
 			if err == sql.ErrNoRows {
@@ -849,6 +862,73 @@ func (d *Database) PublishRoom(ctx context.Context, roomID, appserviceID, networ
 	})
 }
 
+func (d *Database) InsertReportedEvent(ctx context.Context, report *types.ReportedEvent) (reportID int64, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		reportID, err = d.ReportedEventsTable.InsertReportedEvent(ctx, txn, report)
+		return err
+	})
+	return
+}
+
+func (d *Database) GetReportedEvents(
+	ctx context.Context, roomID, userID string, resolved *bool, from, limit uint64, backwards bool,
+) ([]types.ReportedEvent, int64, error) {
+	return d.ReportedEventsTable.SelectReportedEvents(ctx, nil, roomID, userID, resolved, from, limit, backwards)
+}
+
+func (d *Database) GetReportedEvent(ctx context.Context, reportID int64) (*types.ReportedEvent, error) {
+	return d.ReportedEventsTable.SelectReportedEvent(ctx, nil, reportID)
+}
+
+func (d *Database) ResolveReportedEvent(ctx context.Context, reportID int64, resolvedBy string) (resolved bool, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		resolved, err = d.ReportedEventsTable.UpdateReportedEventResolved(ctx, txn, reportID, resolvedBy, spec.AsTimestamp(time.Now()))
+		return err
+	})
+	return
+}
+
+func (d *Database) SetRoomBlocked(ctx context.Context, roomID, blockedBy string, blocked bool) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		if !blocked {
+			return d.BlockedRoomsTable.DeleteBlockedRoom(ctx, txn, roomID)
+		}
+		return d.BlockedRoomsTable.InsertBlockedRoom(ctx, txn, &types.BlockedRoom{
+			RoomID:    roomID,
+			BlockedBy: blockedBy,
+			BlockedTS: spec.AsTimestamp(time.Now()),
+		})
+	})
+}
+
+func (d *Database) GetBlockedRoom(ctx context.Context, roomID string) (*types.BlockedRoom, error) {
+	room, err := d.BlockedRoomsTable.SelectBlockedRoom(ctx, nil, roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return room, err
+}
+
+func (d *Database) GetRoomStats(ctx context.Context, roomIDs []string) ([]types.RoomStats, error) {
+	return d.RoomStats.SelectRoomStats(ctx, nil, roomIDs)
+}
+
+func (d *Database) GetJoinedMemberCounts(ctx context.Context) (map[string]int64, error) {
+	return d.RoomStats.SelectJoinedMemberCounts(ctx, nil)
+}
+
+func (d *Database) GetEventCounts(ctx context.Context) (map[string]int64, error) {
+	return d.RoomStats.SelectEventCounts(ctx, nil)
+}
+
+func (d *Database) GetRoomStatsForRoom(ctx context.Context, roomID string) (*types.RoomStats, error) {
+	stats, err := d.RoomStats.SelectRoomStatsForRoom(ctx, nil, roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return stats, err
+}
+
 func (d *Database) GetPublishedRoom(ctx context.Context, roomID string) (bool, error) {
 	return d.PublishedTable.SelectPublishedFromRoomID(ctx, nil, roomID)
 }
@@ -29,6 +29,7 @@ import (
 	"github.com/matrix-org/dendrite/roomserver/storage/sqlite3/deltas"
 	"github.com/matrix-org/dendrite/roomserver/storage/tables"
 	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/gomatrixserverlib/spec"
 )
 
 const eventsSchema = `
@@ -42,13 +43,14 @@ const eventsSchema = `
     depth INTEGER NOT NULL,
     event_id TEXT NOT NULL UNIQUE,
 	auth_event_nids TEXT NOT NULL DEFAULT '[]',
-	is_rejected BOOLEAN NOT NULL DEFAULT FALSE
+	is_rejected BOOLEAN NOT NULL DEFAULT FALSE,
+	origin_server_ts INTEGER NOT NULL DEFAULT 0
   );
 `
 
 const insertEventSQL = `
-	INSERT INTO roomserver_events (room_nid, event_type_nid, event_state_key_nid, event_id, auth_event_nids, depth, is_rejected)
-	  VALUES ($1, $2, $3, $4, $5, $6, $7)
+	INSERT INTO roomserver_events (room_nid, event_type_nid, event_state_key_nid, event_id, auth_event_nids, depth, is_rejected, origin_server_ts)
+	  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	  ON CONFLICT DO UPDATE
 	  SET is_rejected = $7 WHERE is_rejected = 1
 	  RETURNING event_nid, state_snapshot_nid;
@@ -117,6 +119,22 @@ const selectMaxEventDepthSQL = "" +
 const selectRoomNIDsForEventNIDsSQL = "" +
 	"SELECT event_nid, room_nid FROM roomserver_events WHERE event_nid IN ($1)"
 
+// Find the closest event to a timestamp in either direction. Rejected events
+// and outliers aren't part of the timeline, so they are skipped.
+const selectEventByTimestampForwardSQL = "" +
+	"SELECT event_id, origin_server_ts FROM roomserver_events" +
+	" WHERE room_nid = $1 AND origin_server_ts >= $2 AND is_rejected = FALSE AND state_snapshot_nid != 0" +
+	" ORDER BY origin_server_ts ASC, event_nid ASC LIMIT 1"
+
+const selectEventByTimestampBackwardSQL = "" +
+	"SELECT event_id, origin_server_ts FROM roomserver_events" +
+	" WHERE room_nid = $1 AND origin_server_ts <= $2 AND is_rejected = FALSE AND state_snapshot_nid != 0" +
+	" ORDER BY origin_server_ts DESC, event_nid DESC LIMIT 1"
+
+// Page through the events in a room in the order they were stored.
+const selectEventNIDsForRoomSQL = "" +
+	"SELECT event_nid FROM roomserver_events WHERE room_nid = $1 AND event_nid > $2 ORDER BY event_nid ASC LIMIT $3"
+
 const selectEventRejectedSQL = "" +
 	"SELECT is_rejected FROM roomserver_events WHERE room_nid = $1 AND event_id = $2"
 
@@ -135,6 +153,9 @@ type eventStatements struct {
 	bulkSelectStateAtEventAndReferenceStmt        *sql.Stmt
 	bulkSelectEventIDStmt                         *sql.Stmt
 	selectEventRejectedStmt                       *sql.Stmt
+	selectEventByTimestampForwardStmt             *sql.Stmt
+	selectEventByTimestampBackwardStmt            *sql.Stmt
+	selectEventNIDsForRoomStmt                    *sql.Stmt
 	//bulkSelectEventNIDStmt               *sql.Stmt
 	//bulkSelectUnsentEventNIDStmt         *sql.Stmt
 	//selectRoomNIDsForEventNIDsStmt       *sql.Stmt
@@ -146,27 +167,30 @@ func CreateEventsTable(db *sql.DB) error {
 		return err
 	}
 
+	m := sqlutil.NewMigrator(db)
+
 	// check if the column exists
 	var cName string
 	migrationName := "roomserver: drop column reference_sha from roomserver_events"
 	err = db.QueryRowContext(context.Background(), `SELECT p.name FROM sqlite_master AS m JOIN pragma_table_info(m.name) AS p WHERE m.name = 'roomserver_events' AND p.name = 'reference_sha256'`).Scan(&cName)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) { // migration was already executed, as the column was removed
-			if err = sqlutil.InsertMigration(context.Background(), db, migrationName); err != nil {
-				return fmt.Errorf("unable to manually insert migration '%s': %w", migrationName, err)
-			}
-			return nil
+	switch {
+	case errors.Is(err, sql.ErrNoRows): // migration was already executed, as the column was removed
+		if err = sqlutil.InsertMigration(context.Background(), db, migrationName); err != nil {
+			return fmt.Errorf("unable to manually insert migration '%s': %w", migrationName, err)
 		}
+	case err != nil:
 		return err
-	}
-
-	m := sqlutil.NewMigrator(db)
-	m.AddMigrations([]sqlutil.Migration{
-		{
+	default:
+		m.AddMigrations(sqlutil.Migration{
 			Version: migrationName,
 			Up:      deltas.UpDropEventReferenceSHA,
-		},
-	}...)
+		})
+	}
+
+	m.AddMigrations(sqlutil.Migration{
+		Version: "roomserver: add origin_server_ts to roomserver_events",
+		Up:      deltas.UpAddEventOriginServerTS,
+	})
 	return m.Up(context.Background())
 }
 
@@ -192,6 +216,9 @@ func PrepareEventsTable(db *sql.DB) (tables.Events, error) {
 		//{&s.bulkSelectUnsentEventNIDStmt, bulkSelectUnsentEventNIDSQL},
 		//{&s.selectRoomNIDForEventNIDStmt, selectRoomNIDForEventNIDSQL},
 		{&s.selectEventRejectedStmt, selectEventRejectedSQL},
+		{&s.selectEventByTimestampForwardStmt, selectEventByTimestampForwardSQL},
+		{&s.selectEventByTimestampBackwardStmt, selectEventByTimestampBackwardSQL},
+		{&s.selectEventNIDsForRoomStmt, selectEventNIDsForRoomSQL},
 	}.Prepare(db)
 }
 
@@ -204,6 +231,7 @@ func (s *eventStatements) InsertEvent(
 	eventID string,
 	authEventNIDs []types.EventNID,
 	depth int64,
+	originServerTS spec.Timestamp,
 	isRejected bool,
 ) (types.EventNID, types.StateSnapshotNID, error) {
 	// attempt to insert: the last_row_id is the event NID
@@ -212,7 +240,7 @@ func (s *eventStatements) InsertEvent(
 	insertStmt := sqlutil.TxStmt(txn, s.insertEventStmt)
 	err := insertStmt.QueryRowContext(
 		ctx, int64(roomNID), int64(eventTypeNID), int64(eventStateKeyNID),
-		eventID, eventNIDsAsArray(authEventNIDs), depth, isRejected,
+		eventID, eventNIDsAsArray(authEventNIDs), depth, isRejected, originServerTS,
 	).Scan(&eventNID, &stateNID)
 	return types.EventNID(eventNID), types.StateSnapshotNID(stateNID), err
 }
@@ -682,3 +710,34 @@ func (s *eventStatements) SelectEventRejected(
 	err = stmt.QueryRowContext(ctx, roomNID, eventID).Scan(&rejected)
 	return
 }
+
+func (s *eventStatements) SelectEventByTimestamp(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, timestamp spec.Timestamp, backwards bool,
+) (eventID string, originServerTS spec.Timestamp, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectEventByTimestampForwardStmt)
+	if backwards {
+		stmt = sqlutil.TxStmt(txn, s.selectEventByTimestampBackwardStmt)
+	}
+	err = stmt.QueryRowContext(ctx, roomNID, timestamp).Scan(&eventID, &originServerTS)
+	return
+}
+
+func (s *eventStatements) SelectEventNIDsForRoom(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, afterEventNID types.EventNID, limit int,
+) ([]types.EventNID, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectEventNIDsForRoomStmt)
+	rows, err := stmt.QueryContext(ctx, roomNID, afterEventNID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectEventNIDsForRoom: rows.close() failed")
+	var eventNIDs []types.EventNID
+	var eventNID types.EventNID
+	for rows.Next() {
+		if err = rows.Scan(&eventNID); err != nil {
+			return nil, err
+		}
+		eventNIDs = append(eventNIDs, eventNID)
+	}
+	return eventNIDs, rows.Err()
+}
@@ -38,6 +38,7 @@ const membershipSchema = `
 		event_nid INTEGER NOT NULL DEFAULT 0,
 		target_local BOOLEAN NOT NULL DEFAULT false,
 		forgotten BOOLEAN NOT NULL DEFAULT false,
+		knocked_via TEXT NOT NULL DEFAULT '',
 		UNIQUE (room_nid, target_nid)
 	);
 `
@@ -133,6 +134,12 @@ const selectServerInRoomSQL = "" +
 const deleteMembershipSQL = "" +
 	"DELETE FROM roomserver_membership WHERE room_nid = $1 AND target_nid = $2"
 
+const updateKnockedViaSQL = "" +
+	"UPDATE roomserver_membership SET knocked_via = $1 WHERE room_nid = $2 AND target_nid = $3"
+
+const selectKnockedViaSQL = "" +
+	"SELECT knocked_via FROM roomserver_membership WHERE room_nid = $1 AND target_nid = $2"
+
 const selectJoinedUsersSQL = `
 SELECT DISTINCT target_nid
 FROM roomserver_membership m
@@ -155,6 +162,8 @@ type membershipStatements struct {
 	selectLocalServerInRoomStmt                     *sql.Stmt
 	selectServerInRoomStmt                          *sql.Stmt
 	deleteMembershipStmt                            *sql.Stmt
+	updateKnockedViaStmt                            *sql.Stmt
+	selectKnockedViaStmt                            *sql.Stmt
 	// selectJoinedUsersStmt                           *sql.Stmt // Prepared at runtime
 }
 
@@ -167,6 +176,9 @@ func CreateMembershipTable(db *sql.DB) error {
 	m.AddMigrations(sqlutil.Migration{
 		Version: "roomserver: add forgotten column",
 		Up:      deltas.UpAddForgottenColumn,
+	}, sqlutil.Migration{
+		Version: "roomserver: add knocked_via column",
+		Up:      deltas.UpAddKnockedViaColumn,
 	})
 	return m.Up(context.Background())
 }
@@ -191,6 +203,8 @@ func PrepareMembershipTable(db *sql.DB) (tables.Membership, error) {
 		{&s.selectLocalServerInRoomStmt, selectLocalServerInRoomSQL},
 		{&s.selectServerInRoomStmt, selectServerInRoomSQL},
 		{&s.deleteMembershipStmt, deleteMembershipSQL},
+		{&s.updateKnockedViaStmt, updateKnockedViaSQL},
+		{&s.selectKnockedViaStmt, selectKnockedViaSQL},
 	}.Prepare(db)
 }
 
@@ -458,3 +472,23 @@ func (s *membershipStatements) SelectJoinedUsers(
 
 	return result, rows.Err()
 }
+
+func (s *membershipStatements) UpdateKnockedVia(
+	ctx context.Context, txn *sql.Tx,
+	roomNID types.RoomNID, targetUserNID types.EventStateKeyNID, serverName spec.ServerName,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.updateKnockedViaStmt).ExecContext(
+		ctx, serverName, roomNID, targetUserNID,
+	)
+	return err
+}
+
+func (s *membershipStatements) SelectKnockedVia(
+	ctx context.Context, txn *sql.Tx,
+	roomNID types.RoomNID, targetUserNID types.EventStateKeyNID,
+) (serverName spec.ServerName, err error) {
+	err = sqlutil.TxStmt(txn, s.selectKnockedViaStmt).QueryRowContext(
+		ctx, roomNID, targetUserNID,
+	).Scan(&serverName)
+	return
+}
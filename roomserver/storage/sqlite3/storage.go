@@ -141,6 +141,12 @@ func (d *Database) create(db *sql.DB) error {
 	if err := CreateUserRoomKeysTable(db); err != nil {
 		return err
 	}
+	if err := CreateReportedEventsTable(db); err != nil {
+		return err
+	}
+	if err := CreateBlockedRoomsTable(db); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -206,6 +212,18 @@ func (d *Database) prepare(db *sql.DB, writer sqlutil.Writer, cache caching.Room
 	if err != nil {
 		return err
 	}
+	reportedEvents, err := PrepareReportedEventsTable(db)
+	if err != nil {
+		return err
+	}
+	blockedRooms, err := PrepareBlockedRoomsTable(db)
+	if err != nil {
+		return err
+	}
+	roomStats, err := PrepareRoomStatsStatements(db)
+	if err != nil {
+		return err
+	}
 
 	d.Database = shared.Database{
 		DB: db,
@@ -220,18 +238,21 @@ func (d *Database) prepare(db *sql.DB, writer sqlutil.Writer, cache caching.Room
 			PrevEventsTable:     prevEvents,
 			RedactionsTable:     redactions,
 		},
-		Cache:              cache,
-		Writer:             writer,
-		RoomsTable:         rooms,
-		StateBlockTable:    stateBlock,
-		StateSnapshotTable: stateSnapshot,
-		RoomAliasesTable:   roomAliases,
-		InvitesTable:       invites,
-		MembershipTable:    membership,
-		PublishedTable:     published,
-		GetRoomUpdaterFn:   d.GetRoomUpdater,
-		Purge:              purge,
-		UserRoomKeyTable:   userRoomKeys,
+		Cache:               cache,
+		Writer:              writer,
+		RoomsTable:          rooms,
+		StateBlockTable:     stateBlock,
+		StateSnapshotTable:  stateSnapshot,
+		RoomAliasesTable:    roomAliases,
+		InvitesTable:        invites,
+		MembershipTable:     membership,
+		PublishedTable:      published,
+		GetRoomUpdaterFn:    d.GetRoomUpdater,
+		Purge:               purge,
+		UserRoomKeyTable:    userRoomKeys,
+		ReportedEventsTable: reportedEvents,
+		BlockedRoomsTable:   blockedRooms,
+		RoomStats:           roomStats,
 	}
 	return nil
 }
@@ -0,0 +1,70 @@
+package tables_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/postgres"
+	"github.com/matrix-org/dendrite/roomserver/storage/sqlite3"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+)
+
+func mustCreateBlockedRoomsTable(t *testing.T, dbType test.DBType) (tab tables.BlockedRooms, close func()) {
+	t.Helper()
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	}, sqlutil.NewExclusiveWriter())
+	assert.NoError(t, err)
+	switch dbType {
+	case test.DBTypePostgres:
+		err = postgres.CreateBlockedRoomsTable(db)
+		assert.NoError(t, err)
+		tab, err = postgres.PrepareBlockedRoomsTable(db)
+	case test.DBTypeSQLite:
+		err = sqlite3.CreateBlockedRoomsTable(db)
+		assert.NoError(t, err)
+		tab, err = sqlite3.PrepareBlockedRoomsTable(db)
+	}
+	assert.NoError(t, err)
+
+	return tab, close
+}
+
+func TestBlockedRoomsTable(t *testing.T) {
+	ctx := context.Background()
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	room := test.NewRoom(t, alice)
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		tab, close := mustCreateBlockedRoomsTable(t, dbType)
+		defer close()
+
+		_, err := tab.SelectBlockedRoom(ctx, nil, room.ID)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		// Blocking the room again updates who blocked it.
+		for _, blocked := range []types.BlockedRoom{
+			{RoomID: room.ID, BlockedBy: alice.ID, BlockedTS: 1},
+			{RoomID: room.ID, BlockedBy: bob.ID, BlockedTS: 2},
+		} {
+			blocked := blocked
+			assert.NoError(t, tab.InsertBlockedRoom(ctx, nil, &blocked))
+			got, err := tab.SelectBlockedRoom(ctx, nil, room.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, &blocked, got)
+		}
+
+		assert.NoError(t, tab.DeleteBlockedRoom(ctx, nil, room.ID))
+		_, err = tab.SelectBlockedRoom(ctx, nil, room.ID)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
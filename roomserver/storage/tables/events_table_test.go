@@ -2,6 +2,7 @@ package tables_test
 
 import (
 	"context"
+	"database/sql"
 	"testing"
 
 	"github.com/matrix-org/dendrite/internal/sqlutil"
@@ -11,6 +12,7 @@ import (
 	"github.com/matrix-org/dendrite/roomserver/types"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/test"
+	"github.com/matrix-org/gomatrixserverlib/spec"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -49,7 +51,7 @@ func Test_EventsTable(t *testing.T) {
 		wantStateAtEvent := make([]types.StateAtEvent, 0, len(room.Events()))
 		wantStateAtEventAndRefs := make([]types.StateAtEventAndReference, 0, len(room.Events()))
 		for _, ev := range room.Events() {
-			eventNID, snapNID, err := tab.InsertEvent(ctx, nil, 1, 1, 1, ev.EventID(), nil, ev.Depth(), false)
+			eventNID, snapNID, err := tab.InsertEvent(ctx, nil, 1, 1, 1, ev.EventID(), nil, ev.Depth(), ev.OriginServerTS(), false)
 			assert.NoError(t, err)
 			gotEventNID, gotSnapNID, err := tab.SelectEvent(ctx, nil, ev.EventID())
 			assert.NoError(t, err)
@@ -147,3 +149,76 @@ func Test_EventsTable(t *testing.T) {
 		assert.Equal(t, int64(len(room.Events())+1), maxDepth)
 	})
 }
+
+func Test_EventsTableSelectEventByTimestamp(t *testing.T) {
+	alice := test.NewUser(t)
+	room := test.NewRoom(t, alice)
+	ctx := context.Background()
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		tab, close := mustCreateEventsTable(t, dbType)
+		defer close()
+
+		// Give the events of the room well known timestamps. The fourth event
+		// is an outlier without state, so it must never be returned.
+		events := room.Events()[:4]
+		timestamps := []spec.Timestamp{1000, 2000, 3000, 2500}
+		for i, ev := range events {
+			eventNID, _, err := tab.InsertEvent(ctx, nil, 1, 1, 1, ev.EventID(), nil, ev.Depth(), timestamps[i], false)
+			assert.NoError(t, err)
+			if i < 3 {
+				assert.NoError(t, tab.UpdateEventState(ctx, nil, eventNID, 1))
+			}
+		}
+
+		eventID, ts, err := tab.SelectEventByTimestamp(ctx, nil, 1, 1500, false)
+		assert.NoError(t, err)
+		assert.Equal(t, events[1].EventID(), eventID)
+		assert.Equal(t, spec.Timestamp(2000), ts)
+
+		eventID, _, err = tab.SelectEventByTimestamp(ctx, nil, 1, 2900, true)
+		assert.NoError(t, err)
+		assert.Equal(t, events[1].EventID(), eventID)
+
+		eventID, _, err = tab.SelectEventByTimestamp(ctx, nil, 1, 3000, false)
+		assert.NoError(t, err)
+		assert.Equal(t, events[2].EventID(), eventID)
+
+		_, _, err = tab.SelectEventByTimestamp(ctx, nil, 1, 3001, false)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		_, _, err = tab.SelectEventByTimestamp(ctx, nil, 2, 1500, false)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func Test_EventsTableSelectEventNIDsForRoom(t *testing.T) {
+	alice := test.NewUser(t)
+	room := test.NewRoom(t, alice)
+	ctx := context.Background()
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		tab, close := mustCreateEventsTable(t, dbType)
+		defer close()
+
+		var wantNIDs []types.EventNID
+		for i, ev := range room.Events()[:4] {
+			// The last event is in a different room.
+			roomNID := types.RoomNID(1)
+			if i == 3 {
+				roomNID = 2
+			}
+			eventNID, _, err := tab.InsertEvent(ctx, nil, roomNID, 1, 1, ev.EventID(), nil, ev.Depth(), 0, false)
+			assert.NoError(t, err)
+			if roomNID == 1 {
+				wantNIDs = append(wantNIDs, eventNID)
+			}
+		}
+
+		eventNIDs, err := tab.SelectEventNIDsForRoom(ctx, nil, 1, 0, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, wantNIDs[:2], eventNIDs)
+
+		eventNIDs, err = tab.SelectEventNIDsForRoom(ctx, nil, 1, eventNIDs[1], 2)
+		assert.NoError(t, err)
+		assert.Equal(t, wantNIDs[2:], eventNIDs)
+	})
+}
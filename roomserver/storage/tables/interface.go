@@ -43,7 +43,7 @@ type Events interface {
 	InsertEvent(
 		ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, eventTypeNID types.EventTypeNID,
 		eventStateKeyNID types.EventStateKeyNID, eventID string,
-		authEventNIDs []types.EventNID, depth int64, isRejected bool,
+		authEventNIDs []types.EventNID, depth int64, originServerTS spec.Timestamp, isRejected bool,
 	) (types.EventNID, types.StateSnapshotNID, error)
 	SelectEvent(ctx context.Context, txn *sql.Tx, eventID string) (types.EventNID, types.StateSnapshotNID, error)
 	BulkSelectSnapshotsFromEventIDs(ctx context.Context, txn *sql.Tx, eventIDs []string) (map[types.StateSnapshotNID][]string, error)
@@ -69,6 +69,11 @@ type Events interface {
 	SelectMaxEventDepth(ctx context.Context, txn *sql.Tx, eventNIDs []types.EventNID) (int64, error)
 	SelectRoomNIDsForEventNIDs(ctx context.Context, txn *sql.Tx, eventNIDs []types.EventNID) (roomNIDs map[types.EventNID]types.RoomNID, err error)
 	SelectEventRejected(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, eventID string) (rejected bool, err error)
+	// SelectEventByTimestamp returns the closest event to the timestamp in the given direction,
+	// ignoring rejected events and outliers. Returns sql.ErrNoRows if there is no such event.
+	SelectEventByTimestamp(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, timestamp spec.Timestamp, backwards bool) (eventID string, originServerTS spec.Timestamp, err error)
+	// SelectEventNIDsForRoom returns up to limit event NIDs in the room which are greater than afterEventNID, in ascending order.
+	SelectEventNIDsForRoom(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, afterEventNID types.EventNID, limit int) ([]types.EventNID, error)
 }
 
 type Rooms interface {
@@ -151,6 +156,9 @@ type Membership interface {
 	SelectServerInRoom(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, serverName spec.ServerName) (bool, error)
 	DeleteMembership(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, targetUserNID types.EventStateKeyNID) error
 	SelectJoinedUsers(ctx context.Context, txn *sql.Tx, targetUserNIDs []types.EventStateKeyNID) ([]types.EventStateKeyNID, error)
+	// UpdateKnockedVia records the server a knock of the user was sent through.
+	UpdateKnockedVia(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, targetUserNID types.EventStateKeyNID, serverName spec.ServerName) error
+	SelectKnockedVia(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, targetUserNID types.EventStateKeyNID) (spec.ServerName, error)
 }
 
 type Published interface {
@@ -202,6 +210,40 @@ type UserRoomKeys interface {
 	SelectAllPublicKeysForUser(ctx context.Context, txn *sql.Tx, userNID types.EventStateKeyNID) (map[types.RoomNID]ed25519.PublicKey, error)
 }
 
+type ReportedEvents interface {
+	InsertReportedEvent(ctx context.Context, txn *sql.Tx, report *types.ReportedEvent) (int64, error)
+	// SelectReportedEvents returns the reports matching the given room ID, reporting user ID and resolved
+	// state, which are ignored if empty or nil, along with the total number of matching reports.
+	SelectReportedEvents(
+		ctx context.Context, txn *sql.Tx, roomID, userID string, resolved *bool, from, limit uint64, backwards bool,
+	) ([]types.ReportedEvent, int64, error)
+	// SelectReportedEvent returns the report with the given ID, or sql.ErrNoRows if there is none.
+	SelectReportedEvent(ctx context.Context, txn *sql.Tx, reportID int64) (*types.ReportedEvent, error)
+	// UpdateReportedEventResolved marks the report as resolved, returning false if there is no unresolved report with the ID.
+	UpdateReportedEventResolved(ctx context.Context, txn *sql.Tx, reportID int64, resolvedBy string, resolvedTS spec.Timestamp) (bool, error)
+}
+
+type BlockedRooms interface {
+	// InsertBlockedRoom blocks the room, updating who blocked it if it was already blocked.
+	InsertBlockedRoom(ctx context.Context, txn *sql.Tx, room *types.BlockedRoom) error
+	DeleteBlockedRoom(ctx context.Context, txn *sql.Tx, roomID string) error
+	// SelectBlockedRoom returns the blocked room with the given ID, or sql.ErrNoRows if the room isn't blocked.
+	SelectBlockedRoom(ctx context.Context, txn *sql.Tx, roomID string) (*types.BlockedRoom, error)
+}
+
+// RoomStats counts the members and events of rooms across the other tables.
+type RoomStats interface {
+	// SelectRoomStats returns the stats of the given rooms, skipping those that the server has no state for.
+	SelectRoomStats(ctx context.Context, txn *sql.Tx, roomIDs []string) ([]types.RoomStats, error)
+	// SelectRoomStatsForRoom returns the stats of a single room, or sql.ErrNoRows if the server has no state for it.
+	SelectRoomStatsForRoom(ctx context.Context, txn *sql.Tx, roomID string) (*types.RoomStats, error)
+	// SelectJoinedMemberCounts returns the number of joined members of every room that the server has state for.
+	// Rooms without joined members are omitted.
+	SelectJoinedMemberCounts(ctx context.Context, txn *sql.Tx) (map[string]int64, error)
+	// SelectEventCounts returns the number of events of every room that the server has state for.
+	SelectEventCounts(ctx context.Context, txn *sql.Tx) (map[string]int64, error)
+}
+
 // StrippedEvent represents a stripped event for returning extracted content values.
 type StrippedEvent struct {
 	RoomID       string
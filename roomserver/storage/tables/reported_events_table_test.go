@@ -0,0 +1,119 @@
+package tables_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/storage/postgres"
+	"github.com/matrix-org/dendrite/roomserver/storage/sqlite3"
+	"github.com/matrix-org/dendrite/roomserver/storage/tables"
+	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/test"
+)
+
+func mustCreateReportedEventsTable(t *testing.T, dbType test.DBType) (tab tables.ReportedEvents, close func()) {
+	t.Helper()
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	}, sqlutil.NewExclusiveWriter())
+	assert.NoError(t, err)
+	switch dbType {
+	case test.DBTypePostgres:
+		err = postgres.CreateReportedEventsTable(db)
+		assert.NoError(t, err)
+		tab, err = postgres.PrepareReportedEventsTable(db)
+	case test.DBTypeSQLite:
+		err = sqlite3.CreateReportedEventsTable(db)
+		assert.NoError(t, err)
+		tab, err = sqlite3.PrepareReportedEventsTable(db)
+	}
+	assert.NoError(t, err)
+
+	return tab, close
+}
+
+func TestReportedEventsTable(t *testing.T) {
+	ctx := context.Background()
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	room1 := test.NewRoom(t, alice)
+	room2 := test.NewRoom(t, alice)
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		tab, close := mustCreateReportedEventsTable(t, dbType)
+		defer close()
+
+		score := int64(-100)
+		reports := []types.ReportedEvent{
+			{RoomID: room1.ID, EventID: "$event1", ReportingUserID: alice.ID, Reason: "spam", Score: &score, ReceivedTS: 1},
+			{RoomID: room1.ID, EventID: "$event2", ReportingUserID: bob.ID, ReceivedTS: 2},
+			{RoomID: room2.ID, EventID: "$event3", ReportingUserID: alice.ID, Reason: "abuse", ReceivedTS: 3},
+		}
+		for i := range reports {
+			id, err := tab.InsertReportedEvent(ctx, nil, &reports[i])
+			assert.NoError(t, err)
+			reports[i].ID = id
+		}
+
+		// Get a single report
+		report, err := tab.SelectReportedEvent(ctx, nil, reports[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, reports[0], *report)
+		_, err = tab.SelectReportedEvent(ctx, nil, 1000)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		// List all reports, newest first
+		got, total, err := tab.SelectReportedEvents(ctx, nil, "", "", nil, 0, 10, true)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), total)
+		assert.Equal(t, []types.ReportedEvent{reports[2], reports[1], reports[0]}, got)
+
+		// Paginate oldest first
+		got, total, err = tab.SelectReportedEvents(ctx, nil, "", "", nil, 1, 1, false)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), total)
+		assert.Equal(t, []types.ReportedEvent{reports[1]}, got)
+
+		// Filter by room and reporting user
+		got, total, err = tab.SelectReportedEvents(ctx, nil, room1.ID, "", nil, 0, 10, false)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+		assert.Equal(t, []types.ReportedEvent{reports[0], reports[1]}, got)
+		got, total, err = tab.SelectReportedEvents(ctx, nil, room1.ID, alice.ID, nil, 0, 10, false)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+		assert.Equal(t, []types.ReportedEvent{reports[0]}, got)
+
+		// Resolve a report, which can only happen once
+		resolved, err := tab.UpdateReportedEventResolved(ctx, nil, reports[1].ID, alice.ID, 10)
+		assert.NoError(t, err)
+		assert.True(t, resolved)
+		resolved, err = tab.UpdateReportedEventResolved(ctx, nil, reports[1].ID, bob.ID, 11)
+		assert.NoError(t, err)
+		assert.False(t, resolved)
+		resolved, err = tab.UpdateReportedEventResolved(ctx, nil, 1000, alice.ID, 11)
+		assert.NoError(t, err)
+		assert.False(t, resolved)
+		reports[1].ResolvedBy = alice.ID
+		reports[1].ResolvedTS = spec.Timestamp(10)
+
+		// Filter by resolution
+		isResolved := true
+		got, total, err = tab.SelectReportedEvents(ctx, nil, "", "", &isResolved, 0, 10, false)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+		assert.Equal(t, []types.ReportedEvent{reports[1]}, got)
+		isResolved = false
+		got, total, err = tab.SelectReportedEvents(ctx, nil, "", "", &isResolved, 0, 10, false)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+		assert.Equal(t, []types.ReportedEvent{reports[0], reports[2]}, got)
+	})
+}
@@ -339,6 +339,40 @@ func (r *RoomInfo) CopyFrom(r2 *RoomInfo) {
 
 var ErrorInvalidRoomInfo = fmt.Errorf("room info is invalid")
 
+// ReportedEvent is a report of an event by a local user.
+type ReportedEvent struct {
+	ID              int64
+	RoomID          string
+	EventID         string
+	ReportingUserID string
+	Reason          string
+	// Score is how offensive the reporting user rated the event, from -100
+	// (most offensive) to 0. It is nil if the user didn't rate it.
+	Score      *int64
+	ReceivedTS spec.Timestamp
+	// ResolvedBy is the admin who resolved the report, or empty if the
+	// report hasn't been resolved.
+	ResolvedBy string
+	ResolvedTS spec.Timestamp
+}
+
+// BlockedRoom is a room which local users have been prevented from joining by an admin.
+type BlockedRoom struct {
+	RoomID    string
+	BlockedBy string
+	BlockedTS spec.Timestamp
+}
+
+// RoomStats contains the member and event counts of a room, as used by the admin API.
+type RoomStats struct {
+	RoomID             string
+	RoomVersion        gomatrixserverlib.RoomVersion
+	JoinedMembers      int64
+	JoinedLocalMembers int64
+	Events             int64
+	Blocked            bool
+}
+
 // Struct to represent a device or a server name.
 //
 // May be used to designate a caller for functions that can be called
@@ -16,10 +16,10 @@ package config
 
 import (
 	"bytes"
-	"crypto/x509"
 	"encoding/pem"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -255,14 +255,10 @@ func loadConfig(
 			return nil, fmt.Errorf("either specify a 'private_key' path or supply both 'public_key' and 'key_id'")
 		}
 	}
-	if c.ClientAPI.JwtConfig.Enabled {
-		pubPki, _ := pem.Decode([]byte(c.ClientAPI.JwtConfig.Secret))
-		var pub interface{}
-		pub, err = x509.ParsePKIXPublicKey(pubPki.Bytes)
-		if err != nil {
+	if c.ClientAPI.JwtConfig.Enabled && c.ClientAPI.JwtConfig.Secret != "" {
+		if err = c.ClientAPI.JwtConfig.ParseSecret(); err != nil {
 			return nil, err
 		}
-		c.ClientAPI.JwtConfig.SecretKey = pub.(ed25519.PublicKey)
 	}
 
 	c.MediaAPI.AbsBasePath = Path(absPath(basePath, c.MediaAPI.BasePath))
@@ -368,6 +364,7 @@ func (c *Dendrite) Wiring() {
 	c.ClientAPI.Derived = &c.Derived
 	c.AppServiceAPI.Derived = &c.Derived
 	c.ClientAPI.MSCs = &c.MSCs
+	c.UserAPI.Ldap = &c.ClientAPI.Ldap
 }
 
 // Error returns a string detailing how many errors were contained within a
@@ -398,6 +395,19 @@ func checkNotEmpty(configErrs *ConfigErrors, key, value string) {
 	}
 }
 
+// checkURL verifies the given value is an absolute http(s) URL in the configuration.
+// If it is not, adds an error to the list.
+func checkURL(configErrs *ConfigErrors, key, value string) {
+	if value == "" {
+		checkNotEmpty(configErrs, key, value)
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		configErrs.Add(fmt.Sprintf("invalid URL for config key %q: %s", key, value))
+	}
+}
+
 // checkPositive verifies the given value is positive (zero included)
 // in the configuration. If it is not, adds an error to the list.
 func checkPositive(configErrs *ConfigErrors, key string, value int64) {
@@ -1,11 +1,15 @@
 package config
 
 import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"time"
 
+	"github.com/matrix-org/gomatrixserverlib"
+
 	"github.com/matrix-org/dendrite/clientapi/ratelimit"
-	"golang.org/x/crypto/ed25519"
 )
 
 type ClientAPI struct {
@@ -66,15 +70,214 @@ type ClientAPI struct {
 	JwtConfig JwtConfig `yaml:"jwt_config"`
 
 	Ldap Ldap `yaml:"ldap"`
+
+	// Login with OpenID Connect identity providers.
+	SSO SSO `yaml:"sso"`
+
+	// Options for reports of events by users.
+	ContentReports ContentReports `yaml:"content_reports"`
+}
+
+// JwtAlgorithms are the signing algorithms accepted for JWT login.
+var JwtAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
 }
 
 type JwtConfig struct {
-	Enabled   bool   `yaml:"enabled"`
+	Enabled bool `yaml:"enabled"`
+	// The signing algorithm tokens must use, e.g. RS256. All algorithms
+	// in JwtAlgorithms are accepted if empty.
 	Algorithm string `yaml:"algorithm"`
-	Issuer    string `yaml:"issuer"`
-	Secret    string `yaml:"secret"`
-	SecretKey ed25519.PublicKey
+	// The expected "iss" claim. Not checked if empty.
+	Issuer string `yaml:"issuer"`
+	// A PEM encoded RSA, ECDSA or Ed25519 public key to verify tokens with.
+	// Ignored if a JWKS is configured.
+	Secret    string           `yaml:"secret"`
+	SecretKey crypto.PublicKey `yaml:"-"`
+	// The "aud" claim must contain one of the audiences. Not checked if empty.
 	Audiences []string `yaml:"audiences"`
+	// The URL of a JSON Web Key Set to verify tokens with. Keys are selected
+	// by the "kid" header of the token.
+	JWKSURL string `yaml:"jwks_url"`
+	// The path to a JSON Web Key Set file, as an alternative to jwks_url.
+	JWKSFile Path `yaml:"jwks_file"`
+	// How often the JSON Web Key Set is fetched again. It is also fetched
+	// again when a token is signed by an unknown key.
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval"`
+	// The clock skew allowed when checking the "exp", "nbf" and "iat" claims.
+	ClockSkew time.Duration `yaml:"clock_skew"`
+	// Whether to create an account on the first login of a subject without one.
+	AutoProvision bool `yaml:"auto_provision"`
+	// Claims synced to the account on each login.
+	Claims JwtClaims `yaml:"claims"`
+}
+
+// JwtClaims maps claims of a token to the account of the user. Claims that
+// aren't configured, or are missing from a token, aren't synced.
+type JwtClaims struct {
+	// The claim holding the display name of the user.
+	DisplayName string `yaml:"displayname"`
+	// The claim holding the avatar of the user, an mxc:// URI.
+	AvatarURL string `yaml:"avatar_url"`
+	// The claim determining whether the user is an admin. The user is an admin
+	// if the claim is true, or if AdminValue is set and the claim is equal to
+	// or a list containing AdminValue.
+	Admin      string `yaml:"admin"`
+	AdminValue string `yaml:"admin_value"`
+}
+
+func (c *JwtConfig) Defaults() {
+	c.JWKSRefreshInterval = time.Hour
+	c.ClockSkew = time.Minute
+}
+
+func (c *JwtConfig) Verify(configErrs *ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	if c.Secret == "" && c.JWKSURL == "" && c.JWKSFile == "" {
+		configErrs.Add("client_api.jwt_config requires one of secret, jwks_url or jwks_file")
+	}
+	if c.JWKSURL != "" && c.JWKSFile != "" {
+		configErrs.Add("client_api.jwt_config.jwks_url and client_api.jwt_config.jwks_file are mutually exclusive")
+	}
+	if c.Algorithm != "" {
+		known := false
+		for _, alg := range JwtAlgorithms {
+			known = known || alg == c.Algorithm
+		}
+		if !known {
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %s", "client_api.jwt_config.algorithm", c.Algorithm))
+		}
+	}
+	checkPositive(configErrs, "client_api.jwt_config.jwks_refresh_interval", int64(c.JWKSRefreshInterval))
+	if c.ClockSkew < 0 {
+		configErrs.Add(fmt.Sprintf("invalid value for config key %q: %s", "client_api.jwt_config.clock_skew", c.ClockSkew))
+	}
+}
+
+// ParseSecret parses the PEM encoded public key in Secret into SecretKey.
+func (c *JwtConfig) ParseSecret() error {
+	block, _ := pem.Decode([]byte(c.Secret))
+	if block == nil {
+		return fmt.Errorf("client_api.jwt_config.secret is not a PEM encoded public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("client_api.jwt_config.secret: %w", err)
+	}
+	c.SecretKey = pub
+	return nil
+}
+
+// SSO configures login with OpenID Connect identity providers. Clients are
+// redirected to the provider, and log in with an m.login.token afterwards.
+type SSO struct {
+	Enabled bool `yaml:"enabled"`
+	// The URL of the callback endpoint, as registered with the identity
+	// providers, e.g. https://matrix.example.com/_matrix/client/v3/login/sso/callback
+	CallbackURL string `yaml:"callback_url"`
+	// The URL prefixes clients are redirected to after login without asking the
+	// user. The user has to confirm the redirect to any other client.
+	ClientWhitelist []string `yaml:"client_whitelist"`
+	// The identity providers. The first one is used if a client doesn't pick one.
+	Providers []IdentityProvider `yaml:"providers"`
+}
+
+// IdentityProvider is an OpenID Connect identity provider.
+type IdentityProvider struct {
+	// The ID of the provider, used in URLs and to link accounts. It must not
+	// change once users logged in with the provider.
+	ID string `yaml:"id"`
+	// The name, mxc:// icon and brand of the provider shown by clients.
+	Name  string `yaml:"name"`
+	Icon  string `yaml:"icon"`
+	Brand string `yaml:"brand"`
+	// The URL of the OpenID Connect discovery document, usually
+	// <issuer>/.well-known/openid-configuration.
+	DiscoveryURL string `yaml:"discovery_url"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// The scopes requested. Defaults to openid, profile and email.
+	Scopes []string `yaml:"scopes"`
+	// The claims mapped to the account.
+	Claims SSOClaims `yaml:"claims"`
+	// Whether to create an account on the first login of a user without one.
+	AutoProvision bool `yaml:"auto_provision"`
+	// Whether the first login of a user links the existing account with the
+	// localpart of the user. Otherwise the login fails if the localpart is taken.
+	// Requires the localpart claim to be set explicitly.
+	AllowExistingUsers bool `yaml:"allow_existing_users"`
+}
+
+// UnmarshalYAML sets the defaults of the provider once it is parsed, as the
+// providers aren't known when the defaults of the config are set.
+func (c *IdentityProvider) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain IdentityProvider
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	c.Defaults()
+	return nil
+}
+
+// SSOClaims maps claims of the ID token or user info of a provider to the
+// account of the user.
+type SSOClaims struct {
+	// The claim identifying the user. Defaults to "sub".
+	Subject string `yaml:"subject"`
+	// The claim holding the localpart of the account created or linked on the
+	// first login. Defaults to "preferred_username", unless allow_existing_users
+	// is set: users can often change that claim, and so take over any account.
+	Localpart string `yaml:"localpart"`
+	// The claim holding the display name set on the account created on the
+	// first login. Defaults to "name".
+	DisplayName string `yaml:"displayname"`
+}
+
+func (c *SSO) Verify(configErrs *ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	checkURL(configErrs, "client_api.sso.callback_url", c.CallbackURL)
+	if len(c.Providers) == 0 {
+		configErrs.Add("client_api.sso requires at least one provider")
+	}
+	ids := make(map[string]bool, len(c.Providers))
+	for i := range c.Providers {
+		p := &c.Providers[i]
+		checkNotEmpty(configErrs, "client_api.sso.providers.id", p.ID)
+		if ids[p.ID] {
+			configErrs.Add(fmt.Sprintf("duplicate client_api.sso provider ID %q", p.ID))
+		}
+		ids[p.ID] = true
+		checkURL(configErrs, "client_api.sso.providers.discovery_url", p.DiscoveryURL)
+		checkNotEmpty(configErrs, "client_api.sso.providers.client_id", p.ClientID)
+		if p.AllowExistingUsers {
+			checkNotEmpty(configErrs, "client_api.sso.providers.claims.localpart", p.Claims.Localpart)
+		}
+	}
+}
+
+func (c *IdentityProvider) Defaults() {
+	if c.Name == "" {
+		c.Name = c.ID
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "profile", "email"}
+	}
+	if c.Claims.Subject == "" {
+		c.Claims.Subject = "sub"
+	}
+	if c.Claims.Localpart == "" && !c.AllowExistingUsers {
+		c.Claims.Localpart = "preferred_username"
+	}
+	if c.Claims.DisplayName == "" {
+		c.Claims.DisplayName = "name"
+	}
 }
 
 type Ldap struct {
@@ -92,6 +295,28 @@ type Ldap struct {
 	AdminGroupAttribute string `yaml:"admin_group_attribute"`
 }
 
+type ContentReports struct {
+	// Local users who receive a server notice for each new report.
+	// Requires server notices to be enabled.
+	NotifyUsers []string `yaml:"notify_users"`
+}
+
+func (c *ContentReports) Verify(configErrs *ConfigErrors, global *Global) {
+	if len(c.NotifyUsers) == 0 {
+		return
+	}
+	if global == nil || !global.ServerNotices.Enabled {
+		configErrs.Add("client_api.content_reports.notify_users requires server notices to be enabled")
+		return
+	}
+	for _, userID := range c.NotifyUsers {
+		_, domain, err := gomatrixserverlib.SplitID('@', userID)
+		if err != nil || !global.IsLocalServerName(domain) {
+			configErrs.Add(fmt.Sprintf("invalid local user ID %q for config key %q", userID, "client_api.content_reports.notify_users"))
+		}
+	}
+}
+
 func (c *ClientAPI) Defaults(_ DefaultOpts) {
 	c.RegistrationSharedSecret = ""
 	c.RegistrationRequiresToken = false
@@ -103,11 +328,15 @@ func (c *ClientAPI) Defaults(_ DefaultOpts) {
 	c.RegistrationDisabled = true
 	c.OpenRegistrationWithoutVerificationEnabled = false
 	c.RateLimiting.Defaults()
+	c.JwtConfig.Defaults()
 }
 
 func (c *ClientAPI) Verify(configErrs *ConfigErrors) {
 	c.TURN.Verify(configErrs)
 	c.RateLimiting.Verify(configErrs)
+	c.JwtConfig.Verify(configErrs)
+	c.SSO.Verify(configErrs)
+	c.ContentReports.Verify(configErrs, c.Matrix)
 	if c.RecaptchaEnabled {
 		if c.RecaptchaSiteVerifyAPI == "" {
 			c.RecaptchaSiteVerifyAPI = "https://www.google.com/recaptcha/api/siteverify"
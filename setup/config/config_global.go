@@ -49,7 +49,8 @@ type Global struct {
 	WellKnownClientName string `yaml:"well_known_client_name"`
 
 	// The server name to delegate sliding sync communications to, with optional port.
-	// Requires `well_known_client_name` to also be configured.
+	// Requires `well_known_client_name` to also be configured. Not needed for clients
+	// which support the native simplified sliding sync endpoint.
 	WellKnownSlidingSyncProxy string `yaml:"well_known_sliding_sync_proxy"`
 
 	// Disables federation. Dendrite will not be able to make any outbound HTTP requests
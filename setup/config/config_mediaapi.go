@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"time"
 )
 
 type MediaAPI struct {
@@ -17,11 +19,18 @@ type MediaAPI struct {
 	// The absolute base path to where media files will be stored.
 	AbsBasePath Path `yaml:"-"`
 
+	// Where media files are stored. Temporary files are always written to the base path.
+	Storage MediaStorage `yaml:"storage"`
+
 	// The maximum file size in bytes that is allowed to be stored on this server.
 	// Note: if max_file_size_bytes is set to 0, the size is unlimited.
 	// Note: if max_file_size_bytes is not set, it will default to 10485760 (10MB)
 	MaxFileSizeBytes FileSizeBytes `yaml:"max_file_size_bytes,omitempty"`
 
+	// The total size of media each user may upload, unless overridden for the user.
+	// Note: if default_user_quota_bytes is 0 or not set, the size is unlimited.
+	DefaultUserQuotaBytes FileSizeBytes `yaml:"default_user_quota_bytes"`
+
 	// Whether to dynamically generate thumbnails on-the-fly if the requested resolution is not already generated
 	DynamicThumbnails bool `yaml:"dynamic_thumbnails"`
 
@@ -30,6 +39,175 @@ type MediaAPI struct {
 
 	// A list of thumbnail sizes to be pre-generated for downloaded remote / uploaded content
 	ThumbnailSizes []ThumbnailSize `yaml:"thumbnail_sizes"`
+
+	// Options for generating previews of URLs
+	URLPreviews URLPreviews `yaml:"url_previews"`
+
+	// How long media is kept for
+	Retention MediaRetention `yaml:"retention"`
+
+	// Whether media uploaded or cached from now on can only be downloaded using the
+	// authenticated media endpoints (MSC3916), rather than the legacy /_matrix/media ones.
+	FreezeUnauthenticatedMedia bool `yaml:"freeze_unauthenticated_media"`
+}
+
+const (
+	MediaStorageBackendFilesystem = "filesystem"
+	MediaStorageBackendS3         = "s3"
+)
+
+type MediaStorage struct {
+	// The backend which media files are stored in, either "filesystem" or "s3".
+	// The filesystem backend stores files under the base path.
+	Backend string `yaml:"backend"`
+	// The S3-compatible object store used by the "s3" backend.
+	S3 MediaStorageS3 `yaml:"s3"`
+}
+
+type MediaStorageS3 struct {
+	// The URL of the object store, e.g. https://s3.eu-west-1.amazonaws.com.
+	Endpoint string `yaml:"endpoint"`
+	// The region used to sign requests.
+	Region string `yaml:"region"`
+	// The bucket which media files are stored in.
+	Bucket string `yaml:"bucket"`
+	// A prefix added to the key of every file, e.g. "media/".
+	Prefix string `yaml:"prefix"`
+	// The credentials used to sign requests.
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// Whether to put the bucket in the path of requests rather than in the
+	// host name. Most self-hosted object stores need this.
+	ForcePathStyle bool `yaml:"force_path_style"`
+}
+
+func (c *MediaStorage) Defaults() {
+	c.Backend = MediaStorageBackendFilesystem
+	c.S3.Region = "us-east-1"
+}
+
+func (c *MediaStorage) Verify(configErrs *ConfigErrors) {
+	switch c.Backend {
+	case MediaStorageBackendFilesystem:
+	case MediaStorageBackendS3:
+		checkNotEmpty(configErrs, "media_api.storage.s3.endpoint", c.S3.Endpoint)
+		checkNotEmpty(configErrs, "media_api.storage.s3.region", c.S3.Region)
+		checkNotEmpty(configErrs, "media_api.storage.s3.bucket", c.S3.Bucket)
+	default:
+		configErrs.Add(fmt.Sprintf("invalid value %q for config key %q, must be %q or %q", c.Backend, "media_api.storage.backend", MediaStorageBackendFilesystem, MediaStorageBackendS3))
+	}
+}
+
+const (
+	LocalMediaPolicyAge        = "age"
+	LocalMediaPolicyLastAccess = "last_access"
+)
+
+type MediaRetention struct {
+	// How long remote media is cached for after it was last accessed.
+	// Remote media is cached forever if zero.
+	RemoteMediaLifetime time.Duration `yaml:"remote_media_lifetime"`
+	// How long media uploaded to this server is kept for. Local media is kept
+	// forever if zero.
+	LocalMediaLifetime time.Duration `yaml:"local_media_lifetime"`
+	// Whether local media expires by "age", counting from when it was uploaded,
+	// or by "last_access", once it also hasn't been downloaded or thumbnailed for
+	// the lifetime. Neither policy checks whether the media is still used in rooms
+	// or profiles.
+	LocalMediaPolicy string `yaml:"local_media_policy"`
+	// How often expired media is purged.
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+func (c *MediaRetention) Defaults() {
+	c.LocalMediaPolicy = LocalMediaPolicyLastAccess
+	c.PurgeInterval = time.Hour
+}
+
+func (c *MediaRetention) Verify(configErrs *ConfigErrors) {
+	checkPositive(configErrs, "media_api.retention.remote_media_lifetime", int64(c.RemoteMediaLifetime))
+	checkPositive(configErrs, "media_api.retention.local_media_lifetime", int64(c.LocalMediaLifetime))
+	if c.LocalMediaPolicy != LocalMediaPolicyAge && c.LocalMediaPolicy != LocalMediaPolicyLastAccess {
+		configErrs.Add(fmt.Sprintf("invalid value %q for config key %q, must be %q or %q", c.LocalMediaPolicy, "media_api.retention.local_media_policy", LocalMediaPolicyAge, LocalMediaPolicyLastAccess))
+	}
+	if c.Enabled() && c.PurgeInterval <= 0 {
+		configErrs.Add(fmt.Sprintf("invalid value for config key %q: %s", "media_api.retention.purge_interval", c.PurgeInterval))
+	}
+}
+
+// Enabled returns whether any media expires.
+func (c *MediaRetention) Enabled() bool {
+	return c.RemoteMediaLifetime > 0 || c.LocalMediaLifetime > 0
+}
+
+type URLPreviews struct {
+	// Whether to generate previews of URLs for clients.
+	Enabled bool `yaml:"enabled"`
+	// The maximum size of a page or image which is downloaded for a preview.
+	MaxPageSizeBytes FileSizeBytes `yaml:"max_page_size_bytes"`
+	// How long to wait for a page or image to be downloaded.
+	Timeout time.Duration `yaml:"timeout"`
+	// How long previews are cached for before the URL is fetched again.
+	CacheLifetime time.Duration `yaml:"cache_lifetime"`
+	// The IP ranges, in CIDR notation, which previews are never fetched from.
+	DeniedIPRanges []string `yaml:"denied_ip_ranges"`
+	// The parsed DeniedIPRanges.
+	DeniedIPNets []*net.IPNet `yaml:"-"`
+	// The User-Agent sent when fetching pages.
+	UserAgent string `yaml:"user_agent"`
+}
+
+// DefaultDeniedIPRanges are the private, loopback, link-local and otherwise
+// reserved IP ranges, which URL previews are not fetched from by default.
+var DefaultDeniedIPRanges = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.88.99.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"2001:db8::/32",
+	"fc00::/7",
+	"fe80::/10",
+	"fec0::/10",
+	"ff00::/8",
+}
+
+func (c *URLPreviews) Defaults() {
+	c.MaxPageSizeBytes = FileSizeBytes(10485760)
+	c.Timeout = 10 * time.Second
+	c.CacheLifetime = time.Hour
+	c.DeniedIPRanges = DefaultDeniedIPRanges
+	c.UserAgent = "Dendrite URL preview"
+}
+
+func (c *URLPreviews) Verify(configErrs *ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	checkPositive(configErrs, "media_api.url_previews.max_page_size_bytes", int64(c.MaxPageSizeBytes))
+	checkPositive(configErrs, "media_api.url_previews.timeout", int64(c.Timeout))
+	c.DeniedIPNets = c.DeniedIPNets[:0]
+	for i, ipRange := range c.DeniedIPRanges {
+		_, ipNet, err := net.ParseCIDR(ipRange)
+		if err != nil {
+			configErrs.Add(fmt.Sprintf("invalid IP range %q for config key %q", ipRange, fmt.Sprintf("media_api.url_previews.denied_ip_ranges[%d]", i)))
+			continue
+		}
+		c.DeniedIPNets = append(c.DeniedIPNets, ipNet)
+	}
 }
 
 // DefaultMaxFileSizeBytes defines the default file size allowed in transfers
@@ -38,6 +216,9 @@ var DefaultMaxFileSizeBytes = FileSizeBytes(10485760)
 func (c *MediaAPI) Defaults(opts DefaultOpts) {
 	c.MaxFileSizeBytes = DefaultMaxFileSizeBytes
 	c.MaxThumbnailGenerators = 10
+	c.Storage.Defaults()
+	c.URLPreviews.Defaults()
+	c.Retention.Defaults()
 	if opts.Generate {
 		c.ThumbnailSizes = []ThumbnailSize{
 			{
@@ -66,12 +247,16 @@ func (c *MediaAPI) Defaults(opts DefaultOpts) {
 func (c *MediaAPI) Verify(configErrs *ConfigErrors) {
 	checkNotEmpty(configErrs, "media_api.base_path", string(c.BasePath))
 	checkPositive(configErrs, "media_api.max_file_size_bytes", int64(c.MaxFileSizeBytes))
+	checkPositive(configErrs, "media_api.default_user_quota_bytes", int64(c.DefaultUserQuotaBytes))
 	checkPositive(configErrs, "media_api.max_thumbnail_generators", int64(c.MaxThumbnailGenerators))
 
 	for i, size := range c.ThumbnailSizes {
 		checkPositive(configErrs, fmt.Sprintf("media_api.thumbnail_sizes[%d].width", i), int64(size.Width))
 		checkPositive(configErrs, fmt.Sprintf("media_api.thumbnail_sizes[%d].height", i), int64(size.Height))
 	}
+	c.Storage.Verify(configErrs)
+	c.URLPreviews.Verify(configErrs)
+	c.Retention.Verify(configErrs)
 
 	if c.Matrix.DatabaseOptions.ConnectionString == "" {
 		checkNotEmpty(configErrs, "media_api.database.connection_string", string(c.Database.ConnectionString))
@@ -1,5 +1,13 @@
 package config
 
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
 type SyncAPI struct {
 	Matrix *Global `yaml:"-"`
 
@@ -8,10 +16,13 @@ type SyncAPI struct {
 	RealIPHeader string `yaml:"real_ip_header"`
 
 	Fulltext Fulltext `yaml:"search"`
+
+	MultiRoom MultiRoom `yaml:"multiroom"`
 }
 
 func (c *SyncAPI) Defaults(opts DefaultOpts) {
 	c.Fulltext.Defaults(opts)
+	c.MultiRoom.Defaults()
 	if opts.Generate {
 		if !opts.SingleDatabase {
 			c.Database.ConnectionString = "file:syncapi.db"
@@ -21,6 +32,7 @@ func (c *SyncAPI) Defaults(opts DefaultOpts) {
 
 func (c *SyncAPI) Verify(configErrs *ConfigErrors) {
 	c.Fulltext.Verify(configErrs)
+	c.MultiRoom.Verify(configErrs)
 	if c.Matrix.DatabaseOptions.ConnectionString == "" {
 		checkNotEmpty(configErrs, "sync_api.database", string(c.Database.ConnectionString))
 	}
@@ -46,3 +58,87 @@ func (f *Fulltext) Verify(configErrs *ConfigErrors) {
 	checkNotEmpty(configErrs, "syncapi.search.index_path", string(f.IndexPath))
 	checkNotEmpty(configErrs, "syncapi.search.language", f.Language)
 }
+
+type MultiRoom struct {
+	// Reject multiroom data of types which aren't listed in DataTypes.
+	Strict bool `yaml:"strict"`
+	// The maximum size in bytes of a multiroom payload, unless the data type
+	// sets its own limit.
+	MaxSize int64 `yaml:"max_size"`
+	// Per data type settings for multiroom data sent through /multiroom/{dataType}.
+	DataTypes []MultiRoomDataType `yaml:"data_types"`
+}
+
+type MultiRoomDataType struct {
+	// The multiroom data type, e.g. "connect.multiroom.location".
+	Type string `yaml:"type"`
+	// How long to keep a history of payloads for, in addition to the latest
+	// payload of each user. History is disabled when this is zero.
+	HistoryMaxAge time.Duration `yaml:"history_max_age"`
+	// Whether to evaluate payloads of this type against the connect.geofence state
+	// of the rooms the data is visible in. Payloads must contain a location.
+	Geofencing bool `yaml:"geofencing"`
+	// A JSON Schema which payloads of this type must validate against.
+	Schema string `yaml:"schema"`
+	// The maximum size in bytes of a payload, overrides the global max_size if set.
+	MaxSize int64 `yaml:"max_size"`
+	// Per user rate limiting of payloads of this type.
+	RateLimiting RateLimiting `yaml:"rate_limiting"`
+}
+
+func (c *MultiRoom) Defaults() {
+	c.MaxSize = 64 * 1024
+}
+
+func (c *MultiRoom) Verify(configErrs *ConfigErrors) {
+	checkPositive(configErrs, "sync_api.multiroom.max_size", c.MaxSize)
+	seen := make(map[string]bool, len(c.DataTypes))
+	for i, dataType := range c.DataTypes {
+		key := fmt.Sprintf("sync_api.multiroom.data_types[%d]", i)
+		checkNotEmpty(configErrs, key+".type", dataType.Type)
+		if seen[dataType.Type] {
+			configErrs.Add(fmt.Sprintf("duplicate multiroom data type %q in sync_api.multiroom.data_types", dataType.Type))
+		}
+		seen[dataType.Type] = true
+		checkPositive(configErrs, key+".history_max_age", int64(dataType.HistoryMaxAge))
+		checkPositive(configErrs, key+".max_size", dataType.MaxSize)
+		if dataType.Schema != "" {
+			if _, err := dataType.CompileSchema(); err != nil {
+				configErrs.Add(fmt.Sprintf("invalid value for config key %q: %s", key+".schema", err))
+			}
+		}
+		if dataType.RateLimiting.Enabled {
+			checkPositive(configErrs, key+".rate_limiting.threshold", dataType.RateLimiting.Threshold)
+			checkPositive(configErrs, key+".rate_limiting.cooloff_ms", dataType.RateLimiting.CooloffMS)
+		}
+	}
+}
+
+// CompileSchema compiles the JSON Schema of the data type.
+func (c *MultiRoomDataType) CompileSchema() (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	url := "multiroom/" + c.Type + ".json"
+	if err := compiler.AddResource(url, strings.NewReader(c.Schema)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(url)
+}
+
+// MaxSizeFor returns the maximum payload size for the given data type.
+func (c *MultiRoom) MaxSizeFor(dataType string) int64 {
+	if dt := c.DataType(dataType); dt != nil && dt.MaxSize > 0 {
+		return dt.MaxSize
+	}
+	return c.MaxSize
+}
+
+// DataType returns the settings for the given multiroom data type, or nil if
+// the data type isn't configured.
+func (c *MultiRoom) DataType(dataType string) *MultiRoomDataType {
+	for i := range c.DataTypes {
+		if c.DataTypes[i].Type == dataType {
+			return &c.DataTypes[i]
+		}
+	}
+	return nil
+}
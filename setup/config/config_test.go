@@ -16,6 +16,7 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"reflect"
 	"testing"
 
@@ -271,6 +272,44 @@ func TestUnmarshalDataUnit(t *testing.T) {
 	}
 }
 
+func TestUnmarshalIdentityProvider(t *testing.T) {
+	var sso SSO
+	input := `
+enabled: true
+callback_url: https://matrix.test/_matrix/client/v3/login/sso/callback
+providers:
+  - id: test
+    discovery_url: https://idp.test/.well-known/openid-configuration
+    client_id: dendrite
+  - id: linked
+    discovery_url: https://idp.test/.well-known/openid-configuration
+    client_id: dendrite
+    allow_existing_users: true
+`
+	if err := yaml.Unmarshal([]byte(input), &sso); err != nil {
+		t.Fatal(err)
+	}
+	if p := sso.Providers[0]; p.Name != "test" || p.Claims.Subject != "sub" || p.Claims.Localpart != "preferred_username" {
+		t.Fatalf("defaults not set: %+v", p)
+	}
+	// The localpart claim must be chosen explicitly when linking existing accounts.
+	if p := sso.Providers[1]; p.Claims.Localpart != "" {
+		t.Fatalf("unexpected localpart claim %q", p.Claims.Localpart)
+	}
+	var errs ConfigErrors
+	sso.Verify(&errs)
+	if len(errs) != 1 {
+		t.Fatalf("expected one config error, got %v", errs)
+	}
+
+	sso.Providers[1].Claims.Localpart = "sub"
+	errs = nil
+	sso.Verify(&errs)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected config errors: %v", errs)
+	}
+}
+
 func Test_SigningIdentityFor(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -322,3 +361,48 @@ func Test_SigningIdentityFor(t *testing.T) {
 		})
 	}
 }
+
+func TestURLPreviewsDefaultDeniedIPRanges(t *testing.T) {
+	var c URLPreviews
+	c.Defaults()
+	c.Enabled = true
+	errs := &ConfigErrors{}
+	c.Verify(errs)
+	if len(*errs) > 0 {
+		t.Fatalf("unexpected config errors: %v", *errs)
+	}
+
+	tests := []struct {
+		ip     string
+		denied bool
+	}{
+		{ip: "10.1.2.3", denied: true},
+		{ip: "127.0.0.1", denied: true},
+		{ip: "169.254.169.254", denied: true},
+		{ip: "192.168.1.1", denied: true},
+		{ip: "192.88.99.1", denied: true},
+		{ip: "::1", denied: true},
+		{ip: "64:ff9b::7f00:1", denied: true},
+		{ip: "2001:db8::1", denied: true},
+		{ip: "fd00::1", denied: true},
+		{ip: "fe80::1", denied: true},
+		{ip: "fec0::1", denied: true},
+		{ip: "93.184.216.34", denied: false},
+		{ip: "2606:2800:220:1::1", denied: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			denied := false
+			for _, ipNet := range c.DeniedIPNets {
+				if ipNet.Contains(ip) {
+					denied = true
+					break
+				}
+			}
+			if denied != tt.denied {
+				t.Fatalf("got denied %v for %s, want %v", denied, tt.ip, tt.denied)
+			}
+		})
+	}
+}
@@ -1,9 +1,16 @@
 package config
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 type UserAPI struct {
 	Matrix *Global `yaml:"-"`
+	// The LDAP directory of the client API, which the LDAP sync reads.
+	Ldap *Ldap `yaml:"-"`
 
 	// The cost when hashing passwords.
 	BCryptCost int `yaml:"bcrypt_cost"`
@@ -11,6 +18,10 @@ type UserAPI struct {
 	// The length of time an OpenID token is condidered valid in milliseconds
 	OpenIDTokenLifetimeMS int64 `yaml:"openid_token_lifetime_ms"`
 
+	// The length of time an access token issued with a refresh token is
+	// considered valid in milliseconds
+	RefreshableAccessTokenLifetimeMS int64 `yaml:"refreshable_access_token_lifetime_ms"`
+
 	// Disable TLS validation on HTTPS calls to push gatways. NOT RECOMMENDED!
 	PushGatewayDisableTLSValidation bool `yaml:"push_gateway_disable_tls_validation"`
 
@@ -25,14 +36,77 @@ type UserAPI struct {
 	// The number of workers to start for the DeviceListUpdater. Defaults to 8.
 	// This only needs updating if the "InputDeviceListUpdate" stream keeps growing indefinitely.
 	WorkerCount int `yaml:"worker_count"`
+
+	// Periodically sync accounts with the LDAP directory configured in client_api.ldap.
+	LdapSync LdapSync `yaml:"ldap_sync"`
+}
+
+// LdapSync configures the synchronisation of accounts with the LDAP directory.
+type LdapSync struct {
+	Enabled bool `yaml:"enabled"`
+	// How often the directory is synced.
+	Interval time.Duration `yaml:"interval"`
+	// The filter matching all users of the directory. The username of a user
+	// is read from client_api.ldap.search_attribute.
+	UserFilter string `yaml:"user_filter"`
+	// The attribute holding the display name of a user. Display names aren't
+	// synced if empty.
+	DisplayNameAttribute string `yaml:"displayname_attribute"`
+	// Whether to create accounts for users of the directory without one.
+	CreateAccounts bool `yaml:"create_accounts"`
+	// Whether to deactivate the accounts of users removed from the directory.
+	// Only accounts previously seen in the directory are deactivated.
+	DeactivateRemoved bool `yaml:"deactivate_removed"`
+	// The rooms and spaces the members of LDAP groups are joined to.
+	GroupRooms []LdapGroupRooms `yaml:"group_rooms"`
+}
+
+// LdapGroupRooms maps the members of an LDAP group to room memberships. The
+// rooms must be joinable by the members, e.g. public or restricted to a space.
+type LdapGroupRooms struct {
+	// The DN of the group.
+	GroupDn string `yaml:"group_dn"`
+	// The attribute of the group listing its members, either by DN or by
+	// username. Defaults to "member".
+	MemberAttribute string `yaml:"member_attribute"`
+	// The IDs or aliases of the rooms the members are joined to.
+	Rooms []string `yaml:"rooms"`
+	// Whether users of the directory who aren't members of the group anymore
+	// leave the rooms.
+	LeaveRemoved bool `yaml:"leave_removed"`
+}
+
+func (c *LdapSync) Defaults() {
+	c.Interval = time.Hour
+}
+
+func (c *LdapSync) Verify(configErrs *ConfigErrors, ldap *Ldap) {
+	if !c.Enabled {
+		return
+	}
+	if ldap == nil || !ldap.Enabled || !ldap.AdminBindEnabled {
+		configErrs.Add("user_api.ldap_sync requires client_api.ldap with admin_bind_enabled")
+	}
+	checkPositive(configErrs, "user_api.ldap_sync.interval", int64(c.Interval))
+	checkNotEmpty(configErrs, "user_api.ldap_sync.user_filter", c.UserFilter)
+	for _, group := range c.GroupRooms {
+		checkNotEmpty(configErrs, "user_api.ldap_sync.group_rooms.group_dn", group.GroupDn)
+		if len(group.Rooms) == 0 {
+			configErrs.Add(fmt.Sprintf("missing rooms for config key \"user_api.ldap_sync.group_rooms\" of group %q", group.GroupDn))
+		}
+	}
 }
 
 const DefaultOpenIDTokenLifetimeMS = 3600000 // 60 minutes
 
+const DefaultRefreshableAccessTokenLifetimeMS = 300000 // 5 minutes
+
 func (c *UserAPI) Defaults(opts DefaultOpts) {
 	c.BCryptCost = bcrypt.DefaultCost
 	c.OpenIDTokenLifetimeMS = DefaultOpenIDTokenLifetimeMS
+	c.RefreshableAccessTokenLifetimeMS = DefaultRefreshableAccessTokenLifetimeMS
 	c.WorkerCount = 8
+	c.LdapSync.Defaults()
 	if opts.Generate {
 		if !opts.SingleDatabase {
 			c.AccountDatabase.ConnectionString = "file:userapi_accounts.db"
@@ -42,7 +116,9 @@ func (c *UserAPI) Defaults(opts DefaultOpts) {
 
 func (c *UserAPI) Verify(configErrs *ConfigErrors) {
 	checkPositive(configErrs, "user_api.openid_token_lifetime_ms", c.OpenIDTokenLifetimeMS)
+	checkPositive(configErrs, "user_api.refreshable_access_token_lifetime_ms", c.RefreshableAccessTokenLifetimeMS)
 	if c.Matrix.DatabaseOptions.ConnectionString == "" {
 		checkNotEmpty(configErrs, "user_api.account_database.connection_string", string(c.AccountDatabase.ConnectionString))
 	}
+	c.LdapSync.Verify(configErrs, c.Ldap)
 }
@@ -16,23 +16,25 @@ const (
 )
 
 var (
-	InputRoomEvent          = "InputRoomEvent"
-	InputDeviceListUpdate   = "InputDeviceListUpdate"
-	InputSigningKeyUpdate   = "InputSigningKeyUpdate"
-	OutputRoomEvent         = "OutputRoomEvent"
-	OutputAppserviceEvent   = "OutputAppserviceEvent"
-	OutputSendToDeviceEvent = "OutputSendToDeviceEvent"
-	OutputKeyChangeEvent    = "OutputKeyChangeEvent"
-	OutputTypingEvent       = "OutputTypingEvent"
-	OutputClientData        = "OutputClientData"
-	OutputNotificationData  = "OutputNotificationData"
-	OutputReceiptEvent      = "OutputReceiptEvent"
-	OutputStreamEvent       = "OutputStreamEvent"
-	OutputReadUpdate        = "OutputReadUpdate"
-	RequestPresence         = "GetPresence"
-	OutputPresenceEvent     = "OutputPresenceEvent"
-	InputFulltextReindex    = "InputFulltextReindex"
-	OutputMultiRoomCast     = "OutputMultiRoomCast"
+	InputRoomEvent            = "InputRoomEvent"
+	InputDeviceListUpdate     = "InputDeviceListUpdate"
+	InputSigningKeyUpdate     = "InputSigningKeyUpdate"
+	OutputRoomEvent           = "OutputRoomEvent"
+	OutputAppserviceEvent     = "OutputAppserviceEvent"
+	OutputSendToDeviceEvent   = "OutputSendToDeviceEvent"
+	OutputKeyChangeEvent      = "OutputKeyChangeEvent"
+	OutputTypingEvent         = "OutputTypingEvent"
+	OutputClientData          = "OutputClientData"
+	OutputNotificationData    = "OutputNotificationData"
+	OutputReceiptEvent        = "OutputReceiptEvent"
+	OutputStreamEvent         = "OutputStreamEvent"
+	OutputReadUpdate          = "OutputReadUpdate"
+	RequestPresence           = "GetPresence"
+	OutputPresenceEvent       = "OutputPresenceEvent"
+	InputFulltextReindex      = "InputFulltextReindex"
+	OutputMultiRoomCast       = "OutputMultiRoomCast"
+	OutputMultiRoomFederation = "OutputMultiRoomFederation"
+	OutputAccountDeactivation = "OutputAccountDeactivation"
 )
 
 var safeCharacters = regexp.MustCompile("[^A-Za-z0-9$]+")
@@ -114,4 +116,14 @@ var streams = []*nats.StreamConfig{
 		Retention: nats.InterestPolicy,
 		Storage:   nats.FileStorage,
 	},
+	{
+		Name:      OutputMultiRoomFederation,
+		Retention: nats.InterestPolicy,
+		Storage:   nats.FileStorage,
+	},
+	{
+		Name:      OutputAccountDeactivation,
+		Retention: nats.InterestPolicy,
+		Storage:   nats.FileStorage,
+	},
 }
@@ -16,18 +16,31 @@ package consumers
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/getsentry/sentry-go"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/gomatrixserverlib/spec"
 	"github.com/nats-io/nats.go"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/matrix-org/dendrite/internal/eventutil"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	rstypes "github.com/matrix-org/dendrite/roomserver/types"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/setup/jetstream"
 	"github.com/matrix-org/dendrite/setup/process"
 	"github.com/matrix-org/dendrite/syncapi/notifier"
+	"github.com/matrix-org/dendrite/syncapi/producers"
+	"github.com/matrix-org/dendrite/syncapi/storage"
 	"github.com/matrix-org/dendrite/syncapi/storage/mrd"
 	"github.com/matrix-org/dendrite/syncapi/streams"
+	"github.com/matrix-org/dendrite/syncapi/synctypes"
 	"github.com/matrix-org/dendrite/syncapi/types"
 )
 
@@ -37,9 +50,13 @@ type OutputMultiRoomDataConsumer struct {
 	jetstream nats.JetStreamContext
 	durable   string
 	topic     string
-	db        *mrd.Queries
+	db        mrd.Querier
+	syncDB    storage.Database
+	rsAPI     api.SyncRoomserverAPI
+	cfg       *config.SyncAPI
 	stream    streams.StreamProvider
 	notifier  *notifier.Notifier
+	producer  *producers.FederationAPIMultiRoomProducer
 }
 
 // NewOutputMultiRoomDataConsumer creates a new OutputMultiRoomDataConsumer consumer. Call Start() to begin consuming from room servers.
@@ -47,9 +64,12 @@ func NewOutputMultiRoomDataConsumer(
 	process *process.ProcessContext,
 	cfg *config.SyncAPI,
 	js nats.JetStreamContext,
-	q *mrd.Queries,
+	q mrd.Querier,
+	syncDB storage.Database,
+	rsAPI api.SyncRoomserverAPI,
 	notifier *notifier.Notifier,
 	stream streams.StreamProvider,
+	producer *producers.FederationAPIMultiRoomProducer,
 ) *OutputMultiRoomDataConsumer {
 	return &OutputMultiRoomDataConsumer{
 		ctx:       process.Context(),
@@ -57,8 +77,12 @@ func NewOutputMultiRoomDataConsumer(
 		topic:     cfg.Matrix.JetStream.Prefixed(jetstream.OutputMultiRoomCast),
 		durable:   cfg.Matrix.JetStream.Durable("SyncAPIMultiRoomDataConsumer"),
 		db:        q,
+		syncDB:    syncDB,
+		rsAPI:     rsAPI,
+		cfg:       cfg,
 		notifier:  notifier,
 		stream:    stream,
+		producer:  producer,
 	}
 }
 
@@ -73,17 +97,49 @@ func (s *OutputMultiRoomDataConsumer) onMessage(ctx context.Context, msgs []*nat
 	msg := msgs[0]
 	userID := msg.Header.Get(jetstream.UserID)
 	dataType := msg.Header.Get("type")
+	var err error
 
 	log.WithFields(log.Fields{
 		"type":    dataType,
 		"user_id": userID,
 	}).Debug("Received multiroom data from client API server")
 
-	pos, err := s.db.InsertMultiRoomData(ctx, mrd.InsertMultiRoomDataParams{
-		UserID: userID,
-		Type:   dataType,
-		Data:   msg.Data,
-	})
+	// Remote data carries the timestamp of its origin, local data is stored as of now.
+	ts := time.Now().UnixMilli()
+	if header := msg.Header.Get("timestamp"); header != "" {
+		if ts, err = strconv.ParseInt(header, 10, 64); err != nil {
+			log.WithError(err).Errorf("output log: message parse failure")
+			return true
+		}
+	}
+
+	dt := s.cfg.MultiRoom.DataType(dataType)
+
+	// Geofences are evaluated by the server of the user, remote servers send
+	// the resulting events over federation.
+	var previous []byte
+	var geofencing bool
+	if dt != nil && dt.Geofencing {
+		if _, domain, err := gomatrixserverlib.SplitID('@', userID); err == nil && s.cfg.Matrix.IsLocalServerName(domain) {
+			geofencing = true
+			previous, err = s.db.SelectMultiRoomDataForUser(ctx, mrd.SelectMultiRoomDataForUserParams{
+				UserID: userID,
+				Type:   dataType,
+			})
+			if err != nil && err != sql.ErrNoRows {
+				log.WithFields(log.Fields{
+					"type":    dataType,
+					"user_id": userID,
+				}).WithError(err).Errorf("failed to select previous multi room data")
+				return false
+			}
+		}
+	}
+
+	// The data and its history are stored together, so that a redelivery after
+	// a failure doesn't store the data twice.
+	history := dt != nil && dt.HistoryMaxAge > 0
+	pos, err := s.syncDB.StoreMultiRoomData(ctx, userID, dataType, msg.Data, ts, history)
 	if err != nil {
 		sentry.CaptureException(err)
 		log.WithFields(log.Fields{
@@ -109,5 +165,147 @@ func (s *OutputMultiRoomDataConsumer) onMessage(ctx context.Context, msgs []*nat
 	s.stream.Advance(types.StreamPosition(pos))
 	s.notifier.OnNewMultiRoomData(types.StreamingToken{MultiRoomDataPosition: types.StreamPosition(pos)}, rooms)
 
+	// Only data of our own users is federated, remote data has already been sent to
+	// every server sharing a room with the user by its origin.
+	if len(rooms) > 0 && s.producer != nil {
+		_, domain, err := gomatrixserverlib.SplitID('@', userID)
+		if err == nil && s.cfg.Matrix.IsLocalServerName(domain) {
+			if err = s.producer.SendMultiRoom(userID, dataType, rooms, msg.Data, spec.AsTimestamp(time.Now())); err != nil {
+				log.WithFields(log.Fields{
+					"type":    dataType,
+					"user_id": userID,
+				}).WithError(err).Errorf("failed to send multi room data to federation API")
+			}
+		}
+	}
+
+	if geofencing {
+		s.evaluateGeofences(ctx, userID, dataType, previous, msg.Data)
+	}
+
 	return true
 }
+
+// evaluateGeofences sends a geofence event into every room the data is visible in, for
+// each geofence of the room that the user moved into or out of since the previous payload.
+// If there is no previous location, the user is treated as having been outside.
+func (s *OutputMultiRoomDataConsumer) evaluateGeofences(
+	ctx context.Context, userID, dataType string, previous, current []byte,
+) {
+	logger := log.WithFields(log.Fields{
+		"type":    dataType,
+		"user_id": userID,
+	})
+	var from *types.GeoPoint
+	if p, ok := types.ParseGeoPoint(previous); ok {
+		from = &p
+	}
+	to, ok := types.ParseGeoPoint(current)
+	if !ok {
+		logger.Debug("multi room data has no location, not evaluating geofences")
+		return
+	}
+
+	rooms, err := s.db.SelectMultiRoomVisibilityRoomsByType(ctx, mrd.SelectMultiRoomVisibilityRoomsByTypeParams{
+		UserID:   userID,
+		Type:     dataType + ".visibility",
+		ExpireTs: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		logger.WithError(err).Error("failed to select multi room visibility for geofencing")
+		return
+	}
+
+	for _, roomID := range rooms {
+		geofences, err := s.selectGeofences(ctx, roomID)
+		if err != nil {
+			logger.WithError(err).WithField("room_id", roomID).Error("failed to select geofences")
+			continue
+		}
+		for geofenceID, geofence := range geofences {
+			evType := geofence.Crossed(from, to)
+			if evType == "" {
+				continue
+			}
+			if err = s.sendGeofenceEvent(ctx, userID, roomID, evType, geofenceID, geofence); err != nil {
+				logger.WithError(err).WithFields(log.Fields{
+					"room_id":     roomID,
+					"geofence_id": geofenceID,
+				}).Error("failed to send geofence event")
+			}
+		}
+	}
+}
+
+// selectGeofences returns the valid geofences of the room by geofence ID.
+func (s *OutputMultiRoomDataConsumer) selectGeofences(ctx context.Context, roomID string) (map[string]*types.Geofence, error) {
+	snapshot, err := s.syncDB.NewDatabaseSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var succeeded bool
+	defer sqlutil.EndTransactionWithCheck(snapshot, &succeeded, &err)
+
+	events, err := snapshot.GetStateEventsForRoom(ctx, roomID, &synctypes.StateFilter{
+		Types: &[]string{types.MGeofence},
+	})
+	if err != nil {
+		return nil, err
+	}
+	succeeded = true
+
+	geofences := make(map[string]*types.Geofence, len(events))
+	for _, ev := range events {
+		if ev.StateKey() == nil {
+			continue
+		}
+		var geofence types.Geofence
+		if err := json.Unmarshal(ev.Content(), &geofence); err != nil || !geofence.Valid() {
+			continue
+		}
+		geofences[*ev.StateKey()] = &geofence
+	}
+	return geofences, nil
+}
+
+func (s *OutputMultiRoomDataConsumer) sendGeofenceEvent(
+	ctx context.Context, userID, roomID, evType, geofenceID string, geofence *types.Geofence,
+) error {
+	fullUserID, err := spec.NewUserID(userID, true)
+	if err != nil {
+		return err
+	}
+	validRoomID, err := spec.NewRoomID(roomID)
+	if err != nil {
+		return err
+	}
+	senderID, err := s.rsAPI.QuerySenderIDForUser(ctx, *validRoomID, *fullUserID)
+	if err != nil {
+		return err
+	} else if senderID == nil {
+		return fmt.Errorf("user is not joined to the room")
+	}
+
+	proto := gomatrixserverlib.ProtoEvent{
+		SenderID: string(*senderID),
+		RoomID:   roomID,
+		Type:     evType,
+	}
+	if err = proto.SetContent(map[string]interface{}{
+		"geofence_id": geofenceID,
+		"name":        geofence.Name,
+	}); err != nil {
+		return err
+	}
+
+	identity, err := s.rsAPI.SigningIdentityFor(ctx, *validRoomID, *fullUserID)
+	if err != nil {
+		return err
+	}
+	var queryRes api.QueryLatestEventsAndStateResponse
+	event, err := eventutil.QueryAndBuildEvent(ctx, &proto, &identity, time.Now(), s.rsAPI, &queryRes)
+	if err != nil {
+		return err
+	}
+	return api.SendEvents(ctx, s.rsAPI, api.KindNew, []*rstypes.HeaderedEvent{event}, fullUserID.Domain(), fullUserID.Domain(), fullUserID.Domain(), nil, true)
+}
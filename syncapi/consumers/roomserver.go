@@ -134,6 +134,10 @@ func (s *OutputRoomEventConsumer) onMessage(ctx context.Context, msgs []*nats.Ms
 		s.onNewInviteEvent(s.ctx, *output.NewInviteEvent)
 	case api.OutputTypeRetireInviteEvent:
 		s.onRetireInviteEvent(s.ctx, *output.RetireInviteEvent)
+	case api.OutputTypeNewKnockEvent:
+		s.onNewKnockEvent(s.ctx, *output.NewKnockEvent)
+	case api.OutputTypeRetireKnockEvent:
+		s.onRetireKnockEvent(s.ctx, *output.RetireKnockEvent)
 	case api.OutputTypeNewPeek:
 		s.onNewPeek(s.ctx, *output.NewPeek)
 	case api.OutputTypeRetirePeek:
@@ -485,6 +489,57 @@ func (s *OutputRoomEventConsumer) onRetireInviteEvent(
 	s.notifier.OnNewInvite(types.StreamingToken{InvitePosition: pduPos}, userID.String())
 }
 
+func (s *OutputRoomEventConsumer) onNewKnockEvent(
+	ctx context.Context, msg api.OutputNewKnockEvent,
+) {
+	if msg.Event.StateKey() == nil {
+		return
+	}
+	// Knocking isn't supported in rooms with pseudo IDs, so the state key is the user ID.
+	userID, err := spec.NewUserID(*msg.Event.StateKey(), true)
+	if err != nil || !s.cfg.Matrix.IsLocalServerName(userID.Domain()) {
+		return
+	}
+
+	msg.Event.UserID = *userID
+
+	pduPos, err := s.db.AddInviteEvent(ctx, msg.Event)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"event_id":   msg.Event.EventID(),
+			"pdupos":     pduPos,
+			log.ErrorKey: err,
+		}).Errorf("roomserver output log: write knock failure")
+		return
+	}
+
+	s.inviteStream.Advance(pduPos)
+	s.notifier.OnNewInvite(types.StreamingToken{InvitePosition: pduPos}, userID.String())
+}
+
+func (s *OutputRoomEventConsumer) onRetireKnockEvent(
+	ctx context.Context, msg api.OutputRetireKnockEvent,
+) {
+	pduPos, err := s.db.RetireKnockEvent(ctx, msg.RoomID, msg.UserID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"room_id":    msg.RoomID,
+			"user_id":    msg.UserID,
+			log.ErrorKey: err,
+		}).Errorf("roomserver output log: remove knock failure")
+		return
+	}
+
+	// As with invites, the PDU stream tells clients about the user joining, so
+	// only notify them if the knock was rejected or rescinded.
+	if pduPos == 0 || msg.Membership == spec.Join {
+		return
+	}
+
+	s.inviteStream.Advance(pduPos)
+	s.notifier.OnNewInvite(types.StreamingToken{InvitePosition: pduPos}, msg.UserID)
+}
+
 func (s *OutputRoomEventConsumer) onNewPeek(
 	ctx context.Context, msg api.OutputNewPeek,
 ) {
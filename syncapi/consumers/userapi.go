@@ -90,7 +90,7 @@ func (s *OutputNotificationDataConsumer) onMessage(ctx context.Context, msgs []*
 		return true
 	}
 
-	streamPos, err := s.db.UpsertRoomUnreadNotificationCounts(ctx, userID, data.RoomID, data.UnreadNotificationCount, data.UnreadHighlightCount)
+	streamPos, err := s.db.UpsertRoomUnreadNotificationCounts(ctx, userID, &data)
 	if err != nil {
 		sentry.CaptureException(err)
 		log.WithFields(log.Fields{
@@ -111,3 +111,59 @@ func (s *OutputNotificationDataConsumer) onMessage(ctx context.Context, msgs []*
 
 	return true
 }
+
+// OutputAccountDeactivationConsumer consumes account deactivations from the
+// user API and deletes the multiroom data of the deactivated users.
+type OutputAccountDeactivationConsumer struct {
+	ctx       context.Context
+	jetstream nats.JetStreamContext
+	durable   string
+	topic     string
+	db        storage.Database
+}
+
+// NewOutputAccountDeactivationConsumer creates a new consumer. Call
+// Start() to begin consuming.
+func NewOutputAccountDeactivationConsumer(
+	process *process.ProcessContext,
+	cfg *config.SyncAPI,
+	js nats.JetStreamContext,
+	store storage.Database,
+) *OutputAccountDeactivationConsumer {
+	return &OutputAccountDeactivationConsumer{
+		ctx:       process.Context(),
+		jetstream: js,
+		durable:   cfg.Matrix.JetStream.Durable("SyncAPIAccountDeactivationConsumer"),
+		topic:     cfg.Matrix.JetStream.Prefixed(jetstream.OutputAccountDeactivation),
+		db:        store,
+	}
+}
+
+// Start starts consumption.
+func (s *OutputAccountDeactivationConsumer) Start() error {
+	return jetstream.JetStreamConsumer(
+		s.ctx, s.jetstream, s.topic, s.durable, 1,
+		s.onMessage, nats.DeliverAll(), nats.ManualAck(),
+	)
+}
+
+func (s *OutputAccountDeactivationConsumer) onMessage(ctx context.Context, msgs []*nats.Msg) bool {
+	msg := msgs[0] // Guaranteed to exist if onMessage is called
+	userID := msg.Header.Get(jetstream.UserID)
+
+	purged, err := s.db.PurgeMultiRoomDataForUser(ctx, userID)
+	if err != nil {
+		sentry.CaptureException(err)
+		log.WithField("user_id", userID).WithError(err).Error("Failed to purge multiroom data of deactivated account")
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"user_id":    userID,
+		"data":       purged.Data,
+		"visibility": purged.Visibility,
+		"history":    purged.History,
+	}).Info("Purged multiroom data of deactivated account")
+
+	return true
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producers
+
+import (
+	"strconv"
+
+	"github.com/matrix-org/dendrite/setup/jetstream"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/nats-io/nats.go"
+)
+
+// FederationAPIMultiRoomProducer produces multiroom data for the federation API server to send to remote servers
+type FederationAPIMultiRoomProducer struct {
+	Topic     string
+	JetStream nats.JetStreamContext
+}
+
+// SendMultiRoom sends the multiroom data of a local user, along with the rooms it is visible in.
+func (f *FederationAPIMultiRoomProducer) SendMultiRoom(
+	userID, dataType string, roomIDs []string, data []byte, ts spec.Timestamp,
+) error {
+	msg := nats.NewMsg(f.Topic)
+	msg.Header.Set(jetstream.UserID, userID)
+	msg.Header.Set("type", dataType)
+	msg.Header.Set("origin_server_ts", strconv.FormatUint(uint64(ts), 10))
+	for _, roomID := range roomIDs {
+		msg.Header.Add(jetstream.RoomID, roomID)
+	}
+	msg.Data = data
+
+	_, err := f.JetStream.PublishMsg(msg)
+	return err
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/matrix-org/dendrite/internal/httputil"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+)
+
+type adminMultiRoomData struct {
+	Type           string                 `json:"type"`
+	Content        types.MultiRoomContent `json:"content,omitempty"`
+	Size           int                    `json:"size"`
+	OriginServerTs int64                  `json:"origin_server_ts"`
+}
+
+type adminMultiRoomResponse struct {
+	UserID     string                      `json:"user_id"`
+	Data       []adminMultiRoomData        `json:"data"`
+	Visibility []types.MultiRoomVisibility `json:"visibility"`
+	History    []adminMultiRoomData        `json:"history,omitempty"`
+}
+
+// AdminListMultiRoomData lists the multiroom data types stored for a user, without their content,
+// and the rooms they are visible in.
+func AdminListMultiRoomData(req *http.Request, syncDB storage.Database) util.JSONResponse {
+	return adminGetMultiRoomData(req, syncDB, false)
+}
+
+// AdminExportMultiRoomData returns all multiroom data, visibility and history stored for a user,
+// as a JSON file download.
+func AdminExportMultiRoomData(req *http.Request, syncDB storage.Database) util.JSONResponse {
+	res := adminGetMultiRoomData(req, syncDB, true)
+	if res.Code == http.StatusOK {
+		localpart, _, _ := parseAdminUserID(req)
+		res.Headers = map[string]string{
+			"Content-Disposition": fmt.Sprintf(`attachment; filename="multiroom-%s.json"`, localpart),
+		}
+	}
+	return res
+}
+
+// AdminPurgeMultiRoomData deletes all multiroom data, visibility and history stored for a user.
+func AdminPurgeMultiRoomData(req *http.Request, syncDB storage.Database) util.JSONResponse {
+	_, userID, errRes := parseAdminUserID(req)
+	if errRes != nil {
+		return *errRes
+	}
+	purged, err := syncDB.PurgeMultiRoomDataForUser(req.Context(), userID)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).WithField("user_id", userID).Error("Failed to purge multiroom data")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	util.GetLogger(req.Context()).WithField("user_id", userID).Warn("Purged multiroom data")
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"purged": purged,
+		},
+	}
+}
+
+func adminGetMultiRoomData(req *http.Request, syncDB storage.Database, export bool) util.JSONResponse {
+	_, userID, errRes := parseAdminUserID(req)
+	if errRes != nil {
+		return *errRes
+	}
+
+	snapshot, err := syncDB.NewDatabaseSnapshot(req.Context())
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("Failed to get snapshot for multiroom data")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	defer snapshot.Rollback() // nolint: errcheck
+
+	stored, err := snapshot.SelectMultiRoomDataForUser(req.Context(), userID, export)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).WithField("user_id", userID).Error("Failed to select multiroom data")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	res := adminMultiRoomResponse{
+		UserID:     userID,
+		Data:       toAdminMultiRoomData(stored.Data, export),
+		Visibility: stored.Visibility,
+		History:    toAdminMultiRoomData(stored.History, export),
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+func toAdminMultiRoomData(rows []*types.MultiRoomDataRow, withContent bool) []adminMultiRoomData {
+	data := make([]adminMultiRoomData, 0, len(rows))
+	for _, row := range rows {
+		d := adminMultiRoomData{
+			Type:           row.Type,
+			Size:           len(row.Data),
+			OriginServerTs: row.Timestamp,
+		}
+		if withContent {
+			d.Content = row.Data
+		}
+		data = append(data, d)
+	}
+	return data
+}
+
+// parseAdminUserID returns the localpart and user ID of the userID path parameter.
+func parseAdminUserID(req *http.Request) (string, string, *util.JSONResponse) {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		res := util.ErrorResponse(err)
+		return "", "", &res
+	}
+	userID, err := spec.NewUserID(vars["userID"], true)
+	if err != nil {
+		return "", "", &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("userID is invalid"),
+		}
+	}
+	return userID.Local(), userID.String(), nil
+}
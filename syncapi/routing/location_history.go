@@ -0,0 +1,170 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+)
+
+const (
+	defaultLocationHistoryLimit = 100
+	maxLocationHistoryLimit     = 1000
+)
+
+type getLocationHistoryResponse struct {
+	History   types.MultiRoomHistory `json:"history"`
+	NextBatch string                 `json:"next_batch,omitempty"`
+}
+
+// GetLocationHistory returns the retained multiroom history of each VISIBLE user in the room.
+// Only data types with history enabled in the config are retained.
+//
+// The "from" and "to" parameters are timestamps in milliseconds, where "from" is inclusive and
+// "to" is exclusive. The "next_batch" of the response can be passed as "from" to get the next page.
+func GetLocationHistory(
+	req *http.Request, device *userapi.Device, roomID string,
+	syncDB storage.Database, rsAPI api.SyncRoomserverAPI,
+) util.JSONResponse {
+	filter, err := parseLocationHistoryFilter(req)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam(err.Error()),
+		}
+	}
+
+	userID, err := spec.NewUserID(device.UserID, true)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("Device UserID is invalid"),
+		}
+	}
+	queryReq := api.QueryMembershipForUserRequest{
+		RoomID: roomID,
+		UserID: *userID,
+	}
+	var queryRes api.QueryMembershipForUserResponse
+	if err = rsAPI.QueryMembershipForUser(req.Context(), &queryReq, &queryRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryMembershipForUser failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if !queryRes.IsInRoom {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.Forbidden("You aren't a member of the room."),
+		}
+	}
+
+	snapshot, err := syncDB.NewDatabaseSnapshot(req.Context())
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("Failed to get snapshot for location history")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	defer snapshot.Rollback() // nolint: errcheck
+
+	rows, err := snapshot.SelectMultiRoomHistoryInRoom(req.Context(), roomID, filter)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("failed to select multiroom history for room")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	res := getLocationHistoryResponse{
+		History: make(types.MultiRoomHistory),
+	}
+	for _, row := range rows {
+		if res.History[row.UserId] == nil {
+			res.History[row.UserId] = make(map[string][]types.MultiRoomData)
+		}
+		res.History[row.UserId][row.Type] = append(res.History[row.UserId][row.Type], types.MultiRoomData{
+			Content:        row.Data,
+			OriginServerTs: row.Timestamp,
+		})
+	}
+	if len(rows) == filter.Limit {
+		last := rows[len(rows)-1]
+		res.NextBatch = fmt.Sprintf("%d_%d", last.Timestamp, last.ID)
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+func parseLocationHistoryFilter(req *http.Request) (*types.MultiRoomHistoryFilter, error) {
+	query := req.URL.Query()
+	filter := &types.MultiRoomHistoryFilter{
+		UserID: query.Get("user_id"),
+		ToTs:   math.MaxInt64,
+		Limit:  defaultLocationHistoryLimit,
+	}
+	if filter.UserID != "" {
+		if _, err := spec.NewUserID(filter.UserID, true); err != nil {
+			return nil, fmt.Errorf("user_id is invalid")
+		}
+	}
+	// "from" is either a timestamp or a "timestamp_id" token from a previous response.
+	if from := query.Get("from"); from != "" {
+		tsStr, idStr, isToken := strings.Cut(from, "_")
+		ts, err := strconv.ParseInt(tsStr, 10, 64)
+		if err != nil || ts < 0 {
+			return nil, fmt.Errorf("from is invalid")
+		}
+		filter.FromTs = ts
+		if isToken {
+			if filter.FromID, err = strconv.ParseInt(idStr, 10, 64); err != nil {
+				return nil, fmt.Errorf("from is invalid")
+			}
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		ts, err := strconv.ParseInt(to, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("to is invalid")
+		}
+		filter.ToTs = ts
+	}
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			return nil, fmt.Errorf("limit is invalid")
+		}
+		if l > maxLocationHistoryLimit {
+			l = maxLocationHistoryLimit
+		}
+		filter.Limit = l
+	}
+	return filter, nil
+}
@@ -37,7 +37,7 @@ import (
 // applied:
 // nolint: gocyclo
 func Setup(
-	csMux *mux.Router, srp *sync.RequestPool, syncDB storage.Database,
+	csMux, dendriteAdminRouter *mux.Router, srp *sync.RequestPool, syncDB storage.Database,
 	userAPI userapi.SyncUserAPI,
 	rsAPI api.SyncRoomserverAPI,
 	cfg *config.SyncAPI,
@@ -53,6 +53,11 @@ func Setup(
 		return srp.OnIncomingSyncRequest(req, device)
 	}, httputil.WithAllowGuests())).Methods(http.MethodGet, http.MethodOptions)
 
+	unstableMux := csMux.PathPrefix("/unstable/").Subrouter()
+	unstableMux.Handle("/org.matrix.simplified_msc3575/sync", httputil.MakeAuthAPI("sliding_sync", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+		return srp.OnIncomingSlidingSyncRequest(req, device)
+	})).Methods(http.MethodPost, http.MethodOptions)
+
 	v3mux.Handle("/rooms/{roomID}/messages", httputil.MakeAuthAPI("room_messages", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 		// not specced, but ensure we're rate limiting requests to this endpoint
 		if r := rateLimits.Limit(req, device); r != nil {
@@ -157,6 +162,17 @@ func Setup(
 		}, httputil.WithAllowGuests()),
 	).Methods(http.MethodGet, http.MethodOptions)
 
+	v1unstablemux.Handle("/rooms/{roomId}/threads",
+		httputil.MakeAuthAPI("threads", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+
+			return Threads(req, device, syncDB, rsAPI, vars["roomId"])
+		}, httputil.WithAllowGuests()),
+	).Methods(http.MethodGet, http.MethodOptions)
+
 	v3mux.Handle("/search",
 		httputil.MakeAuthAPI("search", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			if !cfg.Fulltext.Enabled {
@@ -210,4 +226,32 @@ func Setup(
 			return GetLocationSync(req, device, vars["roomID"], syncDB, rsAPI)
 		}),
 	).Methods(http.MethodGet, http.MethodOptions)
+
+	v3mux.Handle("/rooms/{roomID}/location_history",
+		httputil.MakeAuthAPI("location_history", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+			if err != nil {
+				return util.ErrorResponse(err)
+			}
+			return GetLocationHistory(req, device, vars["roomID"], syncDB, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/multiroom/{userID}",
+		httputil.MakeAdminAPI("admin_multiroom_list", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminListMultiRoomData(req, syncDB)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/multiroom/{userID}/export",
+		httputil.MakeAdminAPI("admin_multiroom_export", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminExportMultiRoomData(req, syncDB)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/multiroom/{userID}/purge",
+		httputil.MakeAdminAPI("admin_multiroom_purge", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminPurgeMultiRoomData(req, syncDB)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
 }
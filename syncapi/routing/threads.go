@@ -0,0 +1,174 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/sjson"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	rstypes "github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/dendrite/syncapi/internal"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/synctypes"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+type ThreadsResponse struct {
+	Chunk     []synctypes.ClientEvent `json:"chunk"`
+	NextBatch string                  `json:"next_batch,omitempty"`
+}
+
+type threadSummary struct {
+	LatestEvent             *synctypes.ClientEvent `json:"latest_event,omitempty"`
+	Count                   int                    `json:"count"`
+	CurrentUserParticipated bool                   `json:"current_user_participated"`
+}
+
+// Threads implements GET /rooms/{roomID}/threads
+// nolint:gocyclo
+func Threads(
+	req *http.Request, device *userapi.Device,
+	syncDB storage.Database,
+	rsAPI api.SyncRoomserverAPI,
+	rawRoomID string,
+) util.JSONResponse {
+	roomID, err := spec.NewRoomID(rawRoomID)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid room ID"),
+		}
+	}
+
+	userID, err := spec.NewUserID(device.UserID, true)
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("device.UserID invalid")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.Unknown("internal server error"),
+		}
+	}
+
+	var from types.StreamPosition
+	var limit int
+	query := req.URL.Query()
+	if f := query.Get("from"); f != "" {
+		if from, err = types.NewStreamPositionFromString(f); err != nil {
+			return util.ErrorResponse(err)
+		}
+	}
+	if l := query.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			return util.ErrorResponse(err)
+		}
+	}
+	if limit <= 0 || limit > 50 {
+		limit = 50
+	}
+	include := query.Get("include")
+	if include == "" {
+		include = "all"
+	}
+	if include != "all" && include != "participated" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("Bad include query parameter (should be either 'all' or 'participated')"),
+		}
+	}
+
+	snapshot, err := syncDB.NewDatabaseSnapshot(req.Context())
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get snapshot for threads")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	var succeeded bool
+	defer sqlutil.EndTransactionWithCheck(snapshot, &succeeded, &err)
+
+	res := &ThreadsResponse{
+		Chunk: []synctypes.ClientEvent{},
+	}
+	threads, events, nextBatch, err := snapshot.ThreadsFor(
+		req.Context(), roomID.String(), userID.String(), include == "participated", from, limit,
+	)
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	res.NextBatch = nextBatch
+
+	headeredEvents := make([]*rstypes.HeaderedEvent, 0, len(events))
+	for _, event := range events {
+		headeredEvents = append(headeredEvents, event.HeaderedEvent)
+	}
+
+	// Apply history visibility to the root and latest events.
+	filteredEvents, err := internal.ApplyHistoryVisibilityFilter(req.Context(), snapshot, rsAPI, headeredEvents, nil, *userID, "threads")
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	clientEvents := make(map[string]*synctypes.ClientEvent, len(filteredEvents))
+	for _, event := range filteredEvents {
+		clientEvent, err := synctypes.ToClientEvent(event.PDU, synctypes.FormatAll, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+			return rsAPI.QueryUserIDForSender(req.Context(), roomID, senderID)
+		})
+		if err != nil {
+			util.GetLogger(req.Context()).WithError(err).WithField("senderID", event.SenderID()).WithField("roomID", *roomID).Error("Failed converting to ClientEvent")
+			continue
+		}
+		clientEvents[event.EventID()] = clientEvent
+	}
+
+	// Return the thread roots in order, with the thread summary bundled in.
+	for _, thread := range threads {
+		root, ok := clientEvents[thread.RootEventID]
+		if !ok {
+			continue
+		}
+		summary := threadSummary{
+			LatestEvent:             clientEvents[thread.LatestEventID],
+			Count:                   thread.Count,
+			CurrentUserParticipated: thread.Participated,
+		}
+		unsigned := []byte(root.Unsigned)
+		if len(unsigned) == 0 {
+			unsigned = []byte("{}")
+		}
+		if unsigned, err = sjson.SetBytes(unsigned, "m\\.relations.m\\.thread", summary); err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("Failed to add thread summary")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		root.Unsigned = unsigned
+		res.Chunk = append(res.Chunk, *root)
+	}
+
+	succeeded = true
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
@@ -113,8 +113,14 @@ type DatabaseTransaction interface {
 	GetPresences(ctx context.Context, userID []string) ([]*types.PresenceInternal, error)
 	PresenceAfter(ctx context.Context, after types.StreamPosition, filter synctypes.EventFilter) (map[string]*types.PresenceInternal, error)
 	RelationsFor(ctx context.Context, roomID, eventID, relType, eventType string, from, to types.StreamPosition, backwards bool, limit int) (events []types.StreamEvent, prevBatch, nextBatch string, err error)
-	SelectMultiRoomData(ctx context.Context, r *types.Range, joinedRooms []string) (types.MultiRoom, error)
+	ThreadsFor(ctx context.Context, roomID, userID string, participatedOnly bool, from types.StreamPosition, limit int) (threads []types.ThreadEntry, events map[string]types.StreamEvent, nextBatch string, err error)
+	SelectMultiRoomData(ctx context.Context, r *types.Range, joinedRooms []string, filter *synctypes.MultiRoomFilter) (types.MultiRoom, types.StreamPosition, error)
 	SelectAllMultiRoomDataInRoom(ctx context.Context, roomId string) (types.MultiRoom, error)
+	// SelectMultiRoomHistoryInRoom returns a page of the retained multiroom history of users visible in the room.
+	SelectMultiRoomHistoryInRoom(ctx context.Context, roomId string, filter *types.MultiRoomHistoryFilter) ([]*types.MultiRoomDataRow, error)
+	// SelectMultiRoomDataForUser returns the multiroom data and visibility stored for the user, and
+	// the retained history if includeHistory is set.
+	SelectMultiRoomDataForUser(ctx context.Context, userID string, includeHistory bool) (*types.MultiRoomUserData, error)
 }
 
 type Database interface {
@@ -142,6 +148,11 @@ type Database interface {
 	PurgeRoomState(ctx context.Context, roomID string) error
 	// PurgeRoom entirely eliminates a room from the sync API, timeline, state and all.
 	PurgeRoom(ctx context.Context, roomID string) error
+	// PurgeMultiRoomDataForUser deletes all multiroom data, visibility and history of the user.
+	PurgeMultiRoomDataForUser(ctx context.Context, userID string) (types.MultiRoomPurgeResult, error)
+	// StoreMultiRoomData stores the latest data of the type sent by the user at ts, in milliseconds,
+	// and adds it to the history of the user if history is set. Returns the stream position of the data.
+	StoreMultiRoomData(ctx context.Context, userID, dataType string, data []byte, ts int64, history bool) (types.StreamPosition, error)
 	// UpsertAccountData keeps track of new or updated account data, by saving the type
 	// of the new/updated data, and the user ID and room ID the data is related to (empty)
 	// room ID means the data isn't specific to any room)
@@ -149,13 +160,18 @@ type Database interface {
 	// creates a new row, else update the existing one
 	// Returns an error if there was an issue with the upsert
 	UpsertAccountData(ctx context.Context, userID, roomID, dataType string) (types.StreamPosition, error)
-	// AddInviteEvent stores a new invite event for a user.
+	// AddInviteEvent stores a new invite or knock event for a user, replacing any
+	// invite or knock which is still pending for the user in the room.
 	// If the invite was successfully stored this returns the stream ID it was stored at.
 	// Returns an error if there was a problem communicating with the database.
 	AddInviteEvent(ctx context.Context, inviteEvent *rstypes.HeaderedEvent) (types.StreamPosition, error)
 	// RetireInviteEvent removes an old invite event from the database. Returns the new position of the retired invite.
 	// Returns an error if there was a problem communicating with the database.
 	RetireInviteEvent(ctx context.Context, inviteEventID string) (types.StreamPosition, error)
+	// RetireKnockEvent removes the pending knock of a user from the database. Returns the new position of the
+	// retired knock, or zero if there was no pending knock.
+	// Returns an error if there was a problem communicating with the database.
+	RetireKnockEvent(ctx context.Context, roomID, userID string) (types.StreamPosition, error)
 	// AddPeek adds a new peek to our DB for a given room by a given user's device.
 	// Returns an error if there was a problem communicating with the database.
 	AddPeek(ctx context.Context, RoomID, UserID, DeviceID string) (types.StreamPosition, error)
@@ -181,7 +197,7 @@ type Database interface {
 	// RedactEvent wipes an event in the database and sets the unsigned.redacted_because key to the redaction event
 	RedactEvent(ctx context.Context, redactedEventID string, redactedBecause *rstypes.HeaderedEvent, querier api.QuerySenderIDAPI) error
 	// StoreReceipt stores new receipt events
-	StoreReceipt(ctx context.Context, roomId, receiptType, userId, eventId string, timestamp spec.Timestamp) (pos types.StreamPosition, err error)
+	StoreReceipt(ctx context.Context, roomId, receiptType, userId, eventId, threadID string, timestamp spec.Timestamp) (pos types.StreamPosition, err error)
 	UpdateIgnoresForUser(ctx context.Context, userID string, ignores *types.IgnoredUsers) error
 	ReIndex(ctx context.Context, limit, afterID int64) (map[int64]rstypes.HeaderedEvent, error)
 	UpdateRelations(ctx context.Context, event *rstypes.HeaderedEvent) error
@@ -209,5 +225,5 @@ type SharedUsers interface {
 
 type Notifications interface {
 	// UpsertRoomUnreadNotificationCounts updates the notification statistics about a (user, room) key.
-	UpsertRoomUnreadNotificationCounts(ctx context.Context, userID, roomID string, notificationCount, highlightCount int) (types.StreamPosition, error)
+	UpsertRoomUnreadNotificationCounts(ctx context.Context, userID string, data *eventutil.NotificationData) (types.StreamPosition, error)
 }
@@ -24,6 +24,9 @@ func New(db DBTX) *Queries {
 func Prepare(ctx context.Context, db DBTX) (*Queries, error) {
 	q := Queries{db: db}
 	var err error
+	if q.deleteMultiRoomHistoryByTSStmt, err = db.PrepareContext(ctx, deleteMultiRoomHistoryByTS); err != nil {
+		return nil, fmt.Errorf("error preparing query DeleteMultiRoomHistoryByTS: %w", err)
+	}
 	if q.deleteMultiRoomVisibilityStmt, err = db.PrepareContext(ctx, deleteMultiRoomVisibility); err != nil {
 		return nil, fmt.Errorf("error preparing query DeleteMultiRoomVisibility: %w", err)
 	}
@@ -33,20 +36,34 @@ func Prepare(ctx context.Context, db DBTX) (*Queries, error) {
 	if q.insertMultiRoomDataStmt, err = db.PrepareContext(ctx, insertMultiRoomData); err != nil {
 		return nil, fmt.Errorf("error preparing query InsertMultiRoomData: %w", err)
 	}
+	if q.insertMultiRoomHistoryStmt, err = db.PrepareContext(ctx, insertMultiRoomHistory); err != nil {
+		return nil, fmt.Errorf("error preparing query InsertMultiRoomHistory: %w", err)
+	}
 	if q.insertMultiRoomVisibilityStmt, err = db.PrepareContext(ctx, insertMultiRoomVisibility); err != nil {
 		return nil, fmt.Errorf("error preparing query InsertMultiRoomVisibility: %w", err)
 	}
 	if q.selectMaxIdStmt, err = db.PrepareContext(ctx, selectMaxId); err != nil {
 		return nil, fmt.Errorf("error preparing query SelectMaxId: %w", err)
 	}
+	if q.selectMultiRoomDataForUserStmt, err = db.PrepareContext(ctx, selectMultiRoomDataForUser); err != nil {
+		return nil, fmt.Errorf("error preparing query SelectMultiRoomDataForUser: %w", err)
+	}
 	if q.selectMultiRoomVisibilityRoomsStmt, err = db.PrepareContext(ctx, selectMultiRoomVisibilityRooms); err != nil {
 		return nil, fmt.Errorf("error preparing query SelectMultiRoomVisibilityRooms: %w", err)
 	}
+	if q.selectMultiRoomVisibilityRoomsByTypeStmt, err = db.PrepareContext(ctx, selectMultiRoomVisibilityRoomsByType); err != nil {
+		return nil, fmt.Errorf("error preparing query SelectMultiRoomVisibilityRoomsByType: %w", err)
+	}
 	return &q, nil
 }
 
 func (q *Queries) Close() error {
 	var err error
+	if q.deleteMultiRoomHistoryByTSStmt != nil {
+		if cerr := q.deleteMultiRoomHistoryByTSStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing deleteMultiRoomHistoryByTSStmt: %w", cerr)
+		}
+	}
 	if q.deleteMultiRoomVisibilityStmt != nil {
 		if cerr := q.deleteMultiRoomVisibilityStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing deleteMultiRoomVisibilityStmt: %w", cerr)
@@ -62,6 +79,11 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing insertMultiRoomDataStmt: %w", cerr)
 		}
 	}
+	if q.insertMultiRoomHistoryStmt != nil {
+		if cerr := q.insertMultiRoomHistoryStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing insertMultiRoomHistoryStmt: %w", cerr)
+		}
+	}
 	if q.insertMultiRoomVisibilityStmt != nil {
 		if cerr := q.insertMultiRoomVisibilityStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing insertMultiRoomVisibilityStmt: %w", cerr)
@@ -72,11 +94,21 @@ func (q *Queries) Close() error {
 			err = fmt.Errorf("error closing selectMaxIdStmt: %w", cerr)
 		}
 	}
+	if q.selectMultiRoomDataForUserStmt != nil {
+		if cerr := q.selectMultiRoomDataForUserStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing selectMultiRoomDataForUserStmt: %w", cerr)
+		}
+	}
 	if q.selectMultiRoomVisibilityRoomsStmt != nil {
 		if cerr := q.selectMultiRoomVisibilityRoomsStmt.Close(); cerr != nil {
 			err = fmt.Errorf("error closing selectMultiRoomVisibilityRoomsStmt: %w", cerr)
 		}
 	}
+	if q.selectMultiRoomVisibilityRoomsByTypeStmt != nil {
+		if cerr := q.selectMultiRoomVisibilityRoomsByTypeStmt.Close(); cerr != nil {
+			err = fmt.Errorf("error closing selectMultiRoomVisibilityRoomsByTypeStmt: %w", cerr)
+		}
+	}
 	return err
 }
 
@@ -114,25 +146,33 @@ func (q *Queries) queryRow(ctx context.Context, stmt *sql.Stmt, query string, ar
 }
 
 type Queries struct {
-	db                                      DBTX
-	tx                                      *sql.Tx
-	deleteMultiRoomVisibilityStmt           *sql.Stmt
-	deleteMultiRoomVisibilityByExpireTSStmt *sql.Stmt
-	insertMultiRoomDataStmt                 *sql.Stmt
-	insertMultiRoomVisibilityStmt           *sql.Stmt
-	selectMaxIdStmt                         *sql.Stmt
-	selectMultiRoomVisibilityRoomsStmt      *sql.Stmt
+	db                                       DBTX
+	tx                                       *sql.Tx
+	deleteMultiRoomHistoryByTSStmt           *sql.Stmt
+	deleteMultiRoomVisibilityStmt            *sql.Stmt
+	deleteMultiRoomVisibilityByExpireTSStmt  *sql.Stmt
+	insertMultiRoomDataStmt                  *sql.Stmt
+	insertMultiRoomHistoryStmt               *sql.Stmt
+	insertMultiRoomVisibilityStmt            *sql.Stmt
+	selectMaxIdStmt                          *sql.Stmt
+	selectMultiRoomDataForUserStmt           *sql.Stmt
+	selectMultiRoomVisibilityRoomsStmt       *sql.Stmt
+	selectMultiRoomVisibilityRoomsByTypeStmt *sql.Stmt
 }
 
 func (q *Queries) WithTx(tx *sql.Tx) *Queries {
 	return &Queries{
-		db:                                      tx,
-		tx:                                      tx,
-		deleteMultiRoomVisibilityStmt:           q.deleteMultiRoomVisibilityStmt,
-		deleteMultiRoomVisibilityByExpireTSStmt: q.deleteMultiRoomVisibilityByExpireTSStmt,
-		insertMultiRoomDataStmt:                 q.insertMultiRoomDataStmt,
-		insertMultiRoomVisibilityStmt:           q.insertMultiRoomVisibilityStmt,
-		selectMaxIdStmt:                         q.selectMaxIdStmt,
-		selectMultiRoomVisibilityRoomsStmt:      q.selectMultiRoomVisibilityRoomsStmt,
+		db:                                       tx,
+		tx:                                       tx,
+		deleteMultiRoomHistoryByTSStmt:           q.deleteMultiRoomHistoryByTSStmt,
+		deleteMultiRoomVisibilityStmt:            q.deleteMultiRoomVisibilityStmt,
+		deleteMultiRoomVisibilityByExpireTSStmt:  q.deleteMultiRoomVisibilityByExpireTSStmt,
+		insertMultiRoomDataStmt:                  q.insertMultiRoomDataStmt,
+		insertMultiRoomHistoryStmt:               q.insertMultiRoomHistoryStmt,
+		insertMultiRoomVisibilityStmt:            q.insertMultiRoomVisibilityStmt,
+		selectMaxIdStmt:                          q.selectMaxIdStmt,
+		selectMultiRoomDataForUserStmt:           q.selectMultiRoomDataForUserStmt,
+		selectMultiRoomVisibilityRoomsStmt:       q.selectMultiRoomVisibilityRoomsStmt,
+		selectMultiRoomVisibilityRoomsByTypeStmt: q.selectMultiRoomVisibilityRoomsByTypeStmt,
 	}
 }
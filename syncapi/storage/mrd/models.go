@@ -16,9 +16,18 @@ type SyncapiMultiroomDatum struct {
 	Ts     time.Time `json:"ts"`
 }
 
+type SyncapiMultiroomHistory struct {
+	ID     int64  `json:"id"`
+	UserID string `json:"user_id"`
+	Type   string `json:"type"`
+	Data   []byte `json:"data"`
+	Ts     int64  `json:"ts"`
+}
+
 type SyncapiMultiroomVisibility struct {
 	UserID   string `json:"user_id"`
 	Type     string `json:"type"`
 	RoomID   string `json:"room_id"`
 	ExpireTs int64  `json:"expire_ts"`
+	StartTs  int64  `json:"start_ts"`
 }
@@ -16,19 +16,32 @@ INSERT INTO syncapi_multiroom_visibility (
     user_id,
     type,
     room_id,
-    expire_ts
+    expire_ts,
+    start_ts
 ) VALUES (
     $1,
     $2,
     $3,
-    $4
-) ON CONFLICT (user_id, type, room_id) DO UPDATE SET expire_ts = $4;
+    $4,
+    $5
+) ON CONFLICT (user_id, type, room_id) DO UPDATE SET expire_ts = $4,
+    start_ts = CASE WHEN syncapi_multiroom_visibility.expire_ts <= $5 THEN $5 ELSE syncapi_multiroom_visibility.start_ts END;
 
 -- name: SelectMultiRoomVisibilityRooms :many
 SELECT room_id FROM syncapi_multiroom_visibility
 WHERE user_id = $1 
 AND expire_ts > $2;
 
+-- name: SelectMultiRoomVisibilityRoomsByType :many
+SELECT room_id FROM syncapi_multiroom_visibility
+WHERE user_id = $1
+AND type = $2
+AND expire_ts > $3;
+
+-- name: SelectMultiRoomDataForUser :one
+SELECT data FROM syncapi_multiroom_data
+WHERE user_id = $1
+AND type = $2;
 
 -- name: SelectMaxId :one
 SELECT MAX(id) FROM syncapi_multiroom_data;
@@ -41,4 +54,22 @@ AND room_id = $3;
 
 -- name: DeleteMultiRoomVisibilityByExpireTS :execrows
 DELETE FROM syncapi_multiroom_visibility
-WHERE expire_ts <= $1;
\ No newline at end of file
+WHERE expire_ts <= $1;
+
+-- name: InsertMultiRoomHistory :exec
+INSERT INTO syncapi_multiroom_history (
+    user_id,
+    type,
+    data,
+    ts
+) VALUES (
+    $1,
+    $2,
+    $3,
+    $4
+);
+
+-- name: DeleteMultiRoomHistoryByTS :execrows
+DELETE FROM syncapi_multiroom_history
+WHERE type = $1
+AND ts < $2;
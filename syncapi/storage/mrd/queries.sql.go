@@ -9,6 +9,25 @@ import (
 	"context"
 )
 
+const deleteMultiRoomHistoryByTS = `-- name: DeleteMultiRoomHistoryByTS :execrows
+DELETE FROM syncapi_multiroom_history
+WHERE type = $1
+AND ts < $2
+`
+
+type DeleteMultiRoomHistoryByTSParams struct {
+	Type string `json:"type"`
+	Ts   int64  `json:"ts"`
+}
+
+func (q *Queries) DeleteMultiRoomHistoryByTS(ctx context.Context, arg DeleteMultiRoomHistoryByTSParams) (int64, error) {
+	result, err := q.exec(ctx, q.deleteMultiRoomHistoryByTSStmt, deleteMultiRoomHistoryByTS, arg.Type, arg.Ts)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const deleteMultiRoomVisibility = `-- name: DeleteMultiRoomVisibility :exec
 DELETE FROM syncapi_multiroom_visibility
 WHERE user_id = $1
@@ -66,18 +85,52 @@ func (q *Queries) InsertMultiRoomData(ctx context.Context, arg InsertMultiRoomDa
 	return id, err
 }
 
+const insertMultiRoomHistory = `-- name: InsertMultiRoomHistory :exec
+INSERT INTO syncapi_multiroom_history (
+    user_id,
+    type,
+    data,
+    ts
+) VALUES (
+    $1,
+    $2,
+    $3,
+    $4
+)
+`
+
+type InsertMultiRoomHistoryParams struct {
+	UserID string `json:"user_id"`
+	Type   string `json:"type"`
+	Data   []byte `json:"data"`
+	Ts     int64  `json:"ts"`
+}
+
+func (q *Queries) InsertMultiRoomHistory(ctx context.Context, arg InsertMultiRoomHistoryParams) error {
+	_, err := q.exec(ctx, q.insertMultiRoomHistoryStmt, insertMultiRoomHistory,
+		arg.UserID,
+		arg.Type,
+		arg.Data,
+		arg.Ts,
+	)
+	return err
+}
+
 const insertMultiRoomVisibility = `-- name: InsertMultiRoomVisibility :exec
 INSERT INTO syncapi_multiroom_visibility (
     user_id,
     type,
     room_id,
-    expire_ts
+    expire_ts,
+    start_ts
 ) VALUES (
     $1,
     $2,
     $3,
-    $4
-) ON CONFLICT (user_id, type, room_id) DO UPDATE SET expire_ts = $4
+    $4,
+    $5
+) ON CONFLICT (user_id, type, room_id) DO UPDATE SET expire_ts = $4,
+    start_ts = CASE WHEN syncapi_multiroom_visibility.expire_ts <= $5 THEN $5 ELSE syncapi_multiroom_visibility.start_ts END
 `
 
 type InsertMultiRoomVisibilityParams struct {
@@ -85,6 +138,7 @@ type InsertMultiRoomVisibilityParams struct {
 	Type     string `json:"type"`
 	RoomID   string `json:"room_id"`
 	ExpireTs int64  `json:"expire_ts"`
+	StartTs  int64  `json:"start_ts"`
 }
 
 func (q *Queries) InsertMultiRoomVisibility(ctx context.Context, arg InsertMultiRoomVisibilityParams) error {
@@ -93,6 +147,7 @@ func (q *Queries) InsertMultiRoomVisibility(ctx context.Context, arg InsertMulti
 		arg.Type,
 		arg.RoomID,
 		arg.ExpireTs,
+		arg.StartTs,
 	)
 	return err
 }
@@ -108,6 +163,24 @@ func (q *Queries) SelectMaxId(ctx context.Context) (interface{}, error) {
 	return max, err
 }
 
+const selectMultiRoomDataForUser = `-- name: SelectMultiRoomDataForUser :one
+SELECT data FROM syncapi_multiroom_data
+WHERE user_id = $1
+AND type = $2
+`
+
+type SelectMultiRoomDataForUserParams struct {
+	UserID string `json:"user_id"`
+	Type   string `json:"type"`
+}
+
+func (q *Queries) SelectMultiRoomDataForUser(ctx context.Context, arg SelectMultiRoomDataForUserParams) ([]byte, error) {
+	row := q.queryRow(ctx, q.selectMultiRoomDataForUserStmt, selectMultiRoomDataForUser, arg.UserID, arg.Type)
+	var data []byte
+	err := row.Scan(&data)
+	return data, err
+}
+
 const selectMultiRoomVisibilityRooms = `-- name: SelectMultiRoomVisibilityRooms :many
 SELECT room_id FROM syncapi_multiroom_visibility
 WHERE user_id = $1 
@@ -141,3 +214,39 @@ func (q *Queries) SelectMultiRoomVisibilityRooms(ctx context.Context, arg Select
 	}
 	return items, nil
 }
+
+const selectMultiRoomVisibilityRoomsByType = `-- name: SelectMultiRoomVisibilityRoomsByType :many
+SELECT room_id FROM syncapi_multiroom_visibility
+WHERE user_id = $1
+AND type = $2
+AND expire_ts > $3
+`
+
+type SelectMultiRoomVisibilityRoomsByTypeParams struct {
+	UserID   string `json:"user_id"`
+	Type     string `json:"type"`
+	ExpireTs int64  `json:"expire_ts"`
+}
+
+func (q *Queries) SelectMultiRoomVisibilityRoomsByType(ctx context.Context, arg SelectMultiRoomVisibilityRoomsByTypeParams) ([]string, error) {
+	rows, err := q.query(ctx, q.selectMultiRoomVisibilityRoomsByTypeStmt, selectMultiRoomVisibilityRoomsByType, arg.UserID, arg.Type, arg.ExpireTs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var room_id string
+		if err := rows.Scan(&room_id); err != nil {
+			return nil, err
+		}
+		items = append(items, room_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
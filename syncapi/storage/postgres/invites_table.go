@@ -59,6 +59,9 @@ const selectInviteEventsInRangeSQL = "" +
 	" WHERE target_user_id = $1 AND id > $2 AND id <= $3" +
 	" ORDER BY id DESC"
 
+const selectPendingInviteEventIDsSQL = "" +
+	"SELECT event_id FROM syncapi_invite_events WHERE room_id = $1 AND target_user_id = $2 AND deleted=FALSE"
+
 const selectMaxInviteIDSQL = "" +
 	"SELECT MAX(id) FROM syncapi_invite_events"
 
@@ -66,11 +69,12 @@ const purgeInvitesSQL = "" +
 	"DELETE FROM syncapi_invite_events WHERE room_id = $1"
 
 type inviteEventsStatements struct {
-	insertInviteEventStmt         *sql.Stmt
-	selectInviteEventsInRangeStmt *sql.Stmt
-	deleteInviteEventStmt         *sql.Stmt
-	selectMaxInviteIDStmt         *sql.Stmt
-	purgeInvitesStmt              *sql.Stmt
+	insertInviteEventStmt           *sql.Stmt
+	selectInviteEventsInRangeStmt   *sql.Stmt
+	deleteInviteEventStmt           *sql.Stmt
+	selectPendingInviteEventIDsStmt *sql.Stmt
+	selectMaxInviteIDStmt           *sql.Stmt
+	purgeInvitesStmt                *sql.Stmt
 }
 
 func NewPostgresInvitesTable(db *sql.DB) (tables.Invites, error) {
@@ -83,6 +87,7 @@ func NewPostgresInvitesTable(db *sql.DB) (tables.Invites, error) {
 		{&s.insertInviteEventStmt, insertInviteEventSQL},
 		{&s.selectInviteEventsInRangeStmt, selectInviteEventsInRangeSQL},
 		{&s.deleteInviteEventStmt, deleteInviteEventSQL},
+		{&s.selectPendingInviteEventIDsStmt, selectPendingInviteEventIDsSQL},
 		{&s.selectMaxInviteIDStmt, selectMaxInviteIDSQL},
 		{&s.purgeInvitesStmt, purgeInvitesSQL},
 	}.Prepare(db)
@@ -168,6 +173,27 @@ func (s *inviteEventsStatements) SelectInviteEventsInRange(
 	return result, retired, lastPos, rows.Err()
 }
 
+// SelectPendingInviteEventIDs returns the IDs of the invite and knock events
+// for the target user in the room which haven't been retired.
+func (s *inviteEventsStatements) SelectPendingInviteEventIDs(
+	ctx context.Context, txn *sql.Tx, roomID, targetUserID string,
+) ([]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectPendingInviteEventIDsStmt).QueryContext(ctx, roomID, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectPendingInviteEventIDs: rows.close() failed")
+	var eventIDs []string
+	for rows.Next() {
+		var eventID string
+		if err = rows.Scan(&eventID); err != nil {
+			return nil, err
+		}
+		eventIDs = append(eventIDs, eventID)
+	}
+	return eventIDs, rows.Err()
+}
+
 func (s *inviteEventsStatements) SelectMaxInviteID(
 	ctx context.Context, txn *sql.Tx,
 ) (id int64, err error) {
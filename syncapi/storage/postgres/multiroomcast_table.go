@@ -5,25 +5,36 @@ import (
 	"database/sql"
 	_ "embed"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
 	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/postgres/deltas"
 	"github.com/matrix-org/dendrite/syncapi/storage/tables"
+	"github.com/matrix-org/dendrite/syncapi/synctypes"
 	"github.com/matrix-org/dendrite/syncapi/types"
 )
 
 //go:embed schema.sql
 var schema string
 
-var selectMultiRoomCastSQL = `SELECT d.user_id, d.type, d.data, d.ts FROM syncapi_multiroom_data AS d
-JOIN syncapi_multiroom_visibility AS v
-ON d.user_id = v.user_id
-AND concat(d.type, '.visibility') = v.type
-WHERE v.room_id = ANY($1)
-AND id > $2
-AND id <= $3`
+const selectMultiRoomCastSQL = `SELECT d.id, d.user_id, d.type, d.data, d.ts FROM syncapi_multiroom_data AS d
+WHERE EXISTS (
+	SELECT 1 FROM syncapi_multiroom_visibility AS v
+	WHERE v.user_id = d.user_id
+	AND v.type = concat(d.type, '.visibility')
+	AND v.room_id = ANY($1)
+)
+AND d.id > $2
+AND d.id <= $3
+AND ( $4::text[] IS NULL OR     d.type LIKE ANY($4)  )
+AND ( $5::text[] IS NULL OR NOT(d.type LIKE ANY($5)) )
+AND ( $6::text[] IS NULL OR     d.user_id = ANY($6)  )
+AND ( $7::text[] IS NULL OR NOT(d.user_id = ANY($7)) )
+ORDER BY d.id ASC
+LIMIT $8`
 
 const selectAllMultiRoomCastInRoomSQL = `SELECT d.user_id, d.type, d.data, d.ts FROM syncapi_multiroom_data AS d
 JOIN syncapi_multiroom_visibility AS v
@@ -31,9 +42,71 @@ ON d.user_id = v.user_id
 AND concat(d.type, '.visibility') = v.type
 WHERE v.room_id = $1`
 
+const selectMultiRoomHistoryInRoomSQL = `SELECT h.id, h.user_id, h.type, h.data, h.ts FROM syncapi_multiroom_history AS h
+JOIN syncapi_multiroom_visibility AS v
+ON h.user_id = v.user_id
+AND concat(h.type, '.visibility') = v.type
+WHERE v.room_id = $1
+AND h.ts >= v.start_ts
+AND h.ts <= v.expire_ts
+AND ($2 = '' OR h.user_id = $2)
+AND (h.ts > $3 OR (h.ts = $3 AND h.id > $4))
+AND h.ts < $5
+ORDER BY h.ts ASC, h.id ASC
+LIMIT $6`
+
+const selectMultiRoomDataByUserSQL = `SELECT id, user_id, type, data, ts FROM syncapi_multiroom_data
+WHERE user_id = $1
+ORDER BY type ASC`
+
+const selectMultiRoomVisibilityByUserSQL = `SELECT type, room_id, expire_ts FROM syncapi_multiroom_visibility
+WHERE user_id = $1
+ORDER BY type ASC, room_id ASC`
+
+const selectMultiRoomHistoryByUserSQL = `SELECT id, user_id, type, data, ts FROM syncapi_multiroom_history
+WHERE user_id = $1
+ORDER BY ts ASC, id ASC`
+
+// The timestamp is stored in UTC, which is how the driver reads a TIMESTAMP.
+const upsertMultiRoomDataSQL = `INSERT INTO syncapi_multiroom_data (user_id, type, data, ts)
+VALUES ($1, $2, $3, to_timestamp($4::float8 / 1000) AT TIME ZONE 'UTC')
+ON CONFLICT (user_id, type) DO UPDATE SET id = nextval('syncapi_multiroom_id'), data = excluded.data, ts = excluded.ts
+RETURNING id`
+
+const appendMultiRoomHistorySQL = `INSERT INTO syncapi_multiroom_history (user_id, type, data, ts)
+VALUES ($1, $2, $3, $4)`
+
+const upsertMultiRoomVisibilitySQL = `INSERT INTO syncapi_multiroom_visibility (user_id, type, room_id, expire_ts, start_ts)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id, type, room_id) DO UPDATE SET expire_ts = $4,
+start_ts = CASE WHEN syncapi_multiroom_visibility.expire_ts <= $5 THEN $5 ELSE syncapi_multiroom_visibility.start_ts END`
+
+const removeMultiRoomVisibilitySQL = `DELETE FROM syncapi_multiroom_visibility
+WHERE user_id = $1 AND type = $2 AND room_id = $3`
+
+const purgeMultiRoomDataForUserSQL = `DELETE FROM syncapi_multiroom_data WHERE user_id = $1`
+
+const purgeMultiRoomVisibilityForUserSQL = `DELETE FROM syncapi_multiroom_visibility WHERE user_id = $1`
+
+const purgeMultiRoomHistoryForUserSQL = `DELETE FROM syncapi_multiroom_history WHERE user_id = $1`
+
+const purgeMultiRoomVisibilitySQL = `DELETE FROM syncapi_multiroom_visibility WHERE room_id = $1`
+
 type multiRoomStatements struct {
-	selectMultiRoomCast          *sql.Stmt
-	selectAllMultiRoomCastInRoom *sql.Stmt
+	selectMultiRoomCast             *sql.Stmt
+	selectAllMultiRoomCastInRoom    *sql.Stmt
+	selectMultiRoomHistoryInRoom    *sql.Stmt
+	selectMultiRoomDataByUser       *sql.Stmt
+	selectMultiRoomVisibilityByUser *sql.Stmt
+	selectMultiRoomHistoryByUser    *sql.Stmt
+	purgeMultiRoomDataForUser       *sql.Stmt
+	purgeMultiRoomVisibilityForUser *sql.Stmt
+	purgeMultiRoomHistoryForUser    *sql.Stmt
+	purgeMultiRoomVisibility        *sql.Stmt
+	upsertMultiRoomData             *sql.Stmt
+	appendMultiRoomHistory          *sql.Stmt
+	upsertMultiRoomVisibility       *sql.Stmt
+	removeMultiRoomVisibility       *sql.Stmt
 }
 
 func NewPostgresMultiRoomCastTable(db *sql.DB) (tables.MultiRoom, error) {
@@ -42,14 +115,53 @@ func NewPostgresMultiRoomCastTable(db *sql.DB) (tables.MultiRoom, error) {
 	if err != nil {
 		return nil, err
 	}
+	m := sqlutil.NewMigrator(db)
+	m.AddMigrations(sqlutil.Migration{
+		Version: "syncapi: add start_ts to multiroom visibility",
+		Up:      deltas.UpAddMultiRoomVisibilityStart,
+	})
+	if err = m.Up(context.Background()); err != nil {
+		return nil, err
+	}
 	return r, sqlutil.StatementList{
 		{&r.selectMultiRoomCast, selectMultiRoomCastSQL},
 		{&r.selectAllMultiRoomCastInRoom, selectAllMultiRoomCastInRoomSQL},
+		{&r.selectMultiRoomHistoryInRoom, selectMultiRoomHistoryInRoomSQL},
+		{&r.selectMultiRoomDataByUser, selectMultiRoomDataByUserSQL},
+		{&r.selectMultiRoomVisibilityByUser, selectMultiRoomVisibilityByUserSQL},
+		{&r.selectMultiRoomHistoryByUser, selectMultiRoomHistoryByUserSQL},
+		{&r.purgeMultiRoomDataForUser, purgeMultiRoomDataForUserSQL},
+		{&r.purgeMultiRoomVisibilityForUser, purgeMultiRoomVisibilityForUserSQL},
+		{&r.purgeMultiRoomHistoryForUser, purgeMultiRoomHistoryForUserSQL},
+		{&r.purgeMultiRoomVisibility, purgeMultiRoomVisibilitySQL},
+		{&r.upsertMultiRoomData, upsertMultiRoomDataSQL},
+		{&r.appendMultiRoomHistory, appendMultiRoomHistorySQL},
+		{&r.upsertMultiRoomVisibility, upsertMultiRoomVisibilitySQL},
+		{&r.removeMultiRoomVisibility, removeMultiRoomVisibilitySQL},
 	}.Prepare(db)
 }
 
-func (s *multiRoomStatements) SelectMultiRoomData(ctx context.Context, r *types.Range, joinedRooms []string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
-	rows, err := sqlutil.TxStmt(txn, s.selectMultiRoomCast).QueryContext(ctx, pq.StringArray(joinedRooms), r.Low(), r.High())
+func (s *multiRoomStatements) SelectMultiRoomData(ctx context.Context, r *types.Range, joinedRooms []string, filter *synctypes.MultiRoomFilter, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
+	var senders, notSenders []string
+	if filter.Senders != nil {
+		senders = *filter.Senders
+	}
+	if filter.NotSenders != nil {
+		notSenders = *filter.NotSenders
+	}
+	// A NULL limit returns all rows.
+	var limit *int
+	if filter.Limit > 0 {
+		limit = &filter.Limit
+	}
+	rows, err := sqlutil.TxStmt(txn, s.selectMultiRoomCast).QueryContext(
+		ctx, pq.StringArray(joinedRooms), r.Low(), r.High(),
+		pq.StringArray(filterConvertTypeWildcardToSQL(filter.Types)),
+		pq.StringArray(filterConvertTypeWildcardToSQL(filter.NotTypes)),
+		pq.StringArray(senders),
+		pq.StringArray(notSenders),
+		limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +170,7 @@ func (s *multiRoomStatements) SelectMultiRoomData(ctx context.Context, r *types.
 	var t time.Time
 	for rows.Next() {
 		r := types.MultiRoomDataRow{}
-		err = rows.Scan(&r.UserId, &r.Type, &r.Data, &t)
+		err = rows.Scan(&r.ID, &r.UserId, &r.Type, &r.Data, &t)
 		r.Timestamp = t.UnixMilli()
 		if err != nil {
 			return nil, fmt.Errorf("rows scan: %w", err)
@@ -87,3 +199,123 @@ func (s *multiRoomStatements) SelectAllMultiRoomDataInRoom(ctx context.Context,
 	}
 	return data, rows.Err()
 }
+
+func (s *multiRoomStatements) SelectMultiRoomHistoryInRoom(ctx context.Context, roomId string, filter *types.MultiRoomHistoryFilter, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectMultiRoomHistoryInRoom).QueryContext(
+		ctx, roomId, filter.UserID, filter.FromTs, filter.FromID, filter.ToTs, filter.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]*types.MultiRoomDataRow, 0)
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMultiRoomHistoryInRoom: rows.close() failed")
+	for rows.Next() {
+		r := types.MultiRoomDataRow{}
+		if err = rows.Scan(&r.ID, &r.UserId, &r.Type, &r.Data, &r.Timestamp); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		data = append(data, &r)
+	}
+	return data, rows.Err()
+}
+
+func (s *multiRoomStatements) SelectMultiRoomDataByUser(ctx context.Context, userID string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectMultiRoomDataByUser).QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]*types.MultiRoomDataRow, 0)
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMultiRoomDataByUser: rows.close() failed")
+	var t time.Time
+	for rows.Next() {
+		r := types.MultiRoomDataRow{}
+		if err = rows.Scan(&r.ID, &r.UserId, &r.Type, &r.Data, &t); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		r.Timestamp = t.UnixMilli()
+		data = append(data, &r)
+	}
+	return data, rows.Err()
+}
+
+func (s *multiRoomStatements) SelectMultiRoomVisibilityByUser(ctx context.Context, userID string, txn *sql.Tx) ([]types.MultiRoomVisibility, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectMultiRoomVisibilityByUser).QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	visibility := make([]types.MultiRoomVisibility, 0)
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMultiRoomVisibilityByUser: rows.close() failed")
+	for rows.Next() {
+		v := types.MultiRoomVisibility{}
+		if err = rows.Scan(&v.Type, &v.RoomID, &v.ExpireTs); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		v.Type = strings.TrimSuffix(v.Type, ".visibility")
+		visibility = append(visibility, v)
+	}
+	return visibility, rows.Err()
+}
+
+func (s *multiRoomStatements) SelectMultiRoomHistoryByUser(ctx context.Context, userID string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectMultiRoomHistoryByUser).QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]*types.MultiRoomDataRow, 0)
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMultiRoomHistoryByUser: rows.close() failed")
+	for rows.Next() {
+		r := types.MultiRoomDataRow{}
+		if err = rows.Scan(&r.ID, &r.UserId, &r.Type, &r.Data, &r.Timestamp); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		data = append(data, &r)
+	}
+	return data, rows.Err()
+}
+
+func (s *multiRoomStatements) PurgeMultiRoomDataForUser(ctx context.Context, txn *sql.Tx, userID string) (types.MultiRoomPurgeResult, error) {
+	var res types.MultiRoomPurgeResult
+	for _, purge := range []struct {
+		stmt     *sql.Stmt
+		affected *int64
+	}{
+		{s.purgeMultiRoomDataForUser, &res.Data},
+		{s.purgeMultiRoomVisibilityForUser, &res.Visibility},
+		{s.purgeMultiRoomHistoryForUser, &res.History},
+	} {
+		result, err := sqlutil.TxStmt(txn, purge.stmt).ExecContext(ctx, userID)
+		if err != nil {
+			return res, err
+		}
+		if *purge.affected, err = result.RowsAffected(); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func (s *multiRoomStatements) PurgeMultiRoomVisibility(ctx context.Context, txn *sql.Tx, roomID string) error {
+	_, err := sqlutil.TxStmt(txn, s.purgeMultiRoomVisibility).ExecContext(ctx, roomID)
+	return err
+}
+
+func (s *multiRoomStatements) UpsertMultiRoomData(ctx context.Context, txn *sql.Tx, userID, dataType string, data []byte, ts int64) (types.StreamPosition, error) {
+	var id types.StreamPosition
+	err := sqlutil.TxStmt(txn, s.upsertMultiRoomData).QueryRowContext(ctx, userID, dataType, data, ts).Scan(&id)
+	return id, err
+}
+
+func (s *multiRoomStatements) AppendMultiRoomHistory(ctx context.Context, txn *sql.Tx, userID, dataType string, data []byte, ts int64) error {
+	_, err := sqlutil.TxStmt(txn, s.appendMultiRoomHistory).ExecContext(ctx, userID, dataType, data, ts)
+	return err
+}
+
+func (s *multiRoomStatements) UpsertMultiRoomVisibility(ctx context.Context, txn *sql.Tx, userID, visibilityType, roomID string, expireTs, startTs int64) error {
+	_, err := sqlutil.TxStmt(txn, s.upsertMultiRoomVisibility).ExecContext(ctx, userID, visibilityType, roomID, expireTs, startTs)
+	return err
+}
+
+func (s *multiRoomStatements) RemoveMultiRoomVisibility(ctx context.Context, txn *sql.Tx, userID, visibilityType, roomID string) error {
+	_, err := sqlutil.TxStmt(txn, s.removeMultiRoomVisibility).ExecContext(ctx, userID, visibilityType, roomID)
+	return err
+}
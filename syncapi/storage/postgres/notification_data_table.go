@@ -17,12 +17,14 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 
 	"github.com/lib/pq"
 
 	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/eventutil"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/postgres/deltas"
 	"github.com/matrix-org/dendrite/syncapi/storage/tables"
 	"github.com/matrix-org/dendrite/syncapi/types"
 )
@@ -32,6 +34,14 @@ func NewPostgresNotificationDataTable(db *sql.DB) (tables.NotificationData, erro
 	if err != nil {
 		return nil, err
 	}
+	m := sqlutil.NewMigrator(db)
+	m.AddMigrations(sqlutil.Migration{
+		Version: "syncapi: add thread counts to notification data",
+		Up:      deltas.UpAddNotificationDataThreadCounts,
+	})
+	if err = m.Up(context.Background()); err != nil {
+		return nil, err
+	}
 	r := &notificationDataStatements{}
 	return r, sqlutil.StatementList{
 		{&r.upsertRoomUnreadCounts, upsertRoomUnreadNotificationCountsSQL},
@@ -55,17 +65,19 @@ CREATE TABLE IF NOT EXISTS syncapi_notification_data (
 	room_id TEXT NOT NULL,
 	notification_count BIGINT NOT NULL DEFAULT 0,
 	highlight_count BIGINT NOT NULL DEFAULT 0,
+	-- JSON encoded unread counts per thread root, included in the counts above
+	thread_counts TEXT NOT NULL DEFAULT '',
 	CONSTRAINT syncapi_notification_data_unique UNIQUE (user_id, room_id)
 );`
 
 const upsertRoomUnreadNotificationCountsSQL = `INSERT INTO syncapi_notification_data
-  (user_id, room_id, notification_count, highlight_count)
-  VALUES ($1, $2, $3, $4)
+  (user_id, room_id, notification_count, highlight_count, thread_counts)
+  VALUES ($1, $2, $3, $4, $5)
   ON CONFLICT (user_id, room_id)
-  DO UPDATE SET id = nextval('syncapi_notification_data_id_seq'), notification_count = $3, highlight_count = $4
+  DO UPDATE SET id = nextval('syncapi_notification_data_id_seq'), notification_count = $3, highlight_count = $4, thread_counts = $5
   RETURNING id`
 
-const selectUserUnreadNotificationsForRooms = `SELECT room_id, notification_count, highlight_count
+const selectUserUnreadNotificationsForRooms = `SELECT room_id, notification_count, highlight_count, thread_counts
 	FROM syncapi_notification_data
 	WHERE user_id = $1 AND
 	      room_id = ANY($2)`
@@ -75,8 +87,12 @@ const selectMaxNotificationIDSQL = `SELECT CASE COUNT(*) WHEN 0 THEN 0 ELSE MAX(
 const purgeNotificationDataSQL = "" +
 	"DELETE FROM syncapi_notification_data WHERE room_id = $1"
 
-func (r *notificationDataStatements) UpsertRoomUnreadCounts(ctx context.Context, txn *sql.Tx, userID, roomID string, notificationCount, highlightCount int) (pos types.StreamPosition, err error) {
-	err = sqlutil.TxStmt(txn, r.upsertRoomUnreadCounts).QueryRowContext(ctx, userID, roomID, notificationCount, highlightCount).Scan(&pos)
+func (r *notificationDataStatements) UpsertRoomUnreadCounts(ctx context.Context, txn *sql.Tx, userID string, data *eventutil.NotificationData) (pos types.StreamPosition, err error) {
+	threadCounts, err := marshalThreadCounts(data.Threads)
+	if err != nil {
+		return 0, err
+	}
+	err = sqlutil.TxStmt(txn, r.upsertRoomUnreadCounts).QueryRowContext(ctx, userID, data.RoomID, data.UnreadNotificationCount, data.UnreadHighlightCount, threadCounts).Scan(&pos)
 	return
 }
 
@@ -92,8 +108,9 @@ func (r *notificationDataStatements) SelectUserUnreadCountsForRooms(
 	roomCounts := map[string]*eventutil.NotificationData{}
 	var roomID string
 	var notificationCount, highlightCount int
+	var threadCounts string
 	for rows.Next() {
-		if err = rows.Scan(&roomID, &notificationCount, &highlightCount); err != nil {
+		if err = rows.Scan(&roomID, &notificationCount, &highlightCount, &threadCounts); err != nil {
 			return nil, err
 		}
 
@@ -102,6 +119,11 @@ func (r *notificationDataStatements) SelectUserUnreadCountsForRooms(
 			UnreadNotificationCount: notificationCount,
 			UnreadHighlightCount:    highlightCount,
 		}
+		if threadCounts != "" {
+			if err = json.Unmarshal([]byte(threadCounts), &roomCounts[roomID].Threads); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return roomCounts, rows.Err()
 }
@@ -118,3 +140,13 @@ func (s *notificationDataStatements) PurgeNotificationData(
 	_, err := sqlutil.TxStmt(txn, s.purgeNotificationData).ExecContext(ctx, roomID)
 	return err
 }
+
+// marshalThreadCounts encodes the per-thread counts for storage. Rooms
+// without unread threads are stored as an empty string.
+func marshalThreadCounts(threads map[string]*eventutil.ThreadNotificationData) (string, error) {
+	if len(threads) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(threads)
+	return string(b), err
+}
@@ -63,6 +63,25 @@ const selectRelationsInRangeDescSQL = "" +
 	" AND id >= $5 AND id < $6" +
 	" ORDER BY id DESC LIMIT $7"
 
+// selectThreadsSQL returns the threads in a room, most recently active first.
+// A user participated in a thread if they sent the root or any of the replies.
+const selectThreadsSQL = "" +
+	"SELECT t.event_id, r.child_event_id, t.latest_id, t.reply_count," +
+	" (t.participated > 0 OR COALESCE(root.sender, '') = $1) AS participated" +
+	" FROM (" +
+	"  SELECT rel.event_id, MAX(rel.id) AS latest_id, COUNT(*) AS reply_count," +
+	"  SUM(CASE WHEN e.sender = $2 THEN 1 ELSE 0 END) AS participated" +
+	"  FROM syncapi_relations rel" +
+	"  LEFT JOIN syncapi_output_room_events e ON e.event_id = rel.child_event_id" +
+	"  WHERE rel.room_id = $3 AND rel.rel_type = 'm.thread'" +
+	"  GROUP BY rel.event_id" +
+	" ) t" +
+	" JOIN syncapi_relations r ON r.id = t.latest_id" +
+	" LEFT JOIN syncapi_output_room_events root ON root.event_id = t.event_id" +
+	" WHERE t.latest_id < $4" +
+	" AND (NOT $5 OR t.participated > 0 OR COALESCE(root.sender, '') = $6)" +
+	" ORDER BY t.latest_id DESC LIMIT $7"
+
 const selectMaxRelationIDSQL = "" +
 	"SELECT COALESCE(MAX(id), 0) FROM syncapi_relations"
 
@@ -72,6 +91,7 @@ type relationsStatements struct {
 	selectRelationsInRangeDescStmt *sql.Stmt
 	deleteRelationStmt             *sql.Stmt
 	selectMaxRelationIDStmt        *sql.Stmt
+	selectThreadsStmt              *sql.Stmt
 }
 
 func NewPostgresRelationsTable(db *sql.DB) (tables.Relations, error) {
@@ -86,6 +106,7 @@ func NewPostgresRelationsTable(db *sql.DB) (tables.Relations, error) {
 		{&s.selectRelationsInRangeDescStmt, selectRelationsInRangeDescSQL},
 		{&s.deleteRelationStmt, deleteRelationSQL},
 		{&s.selectMaxRelationIDStmt, selectMaxRelationIDSQL},
+		{&s.selectThreadsStmt, selectThreadsSQL},
 	}.Prepare(db)
 }
 
@@ -149,6 +170,32 @@ func (s *relationsStatements) SelectRelationsInRange(
 	return result, lastPos, rows.Err()
 }
 
+// SelectThreads returns the threads in the room which were last active before
+// the given position, optionally only those the user participated in.
+func (s *relationsStatements) SelectThreads(
+	ctx context.Context, txn *sql.Tx, roomID, userID string, participatedOnly bool,
+	from types.StreamPosition, limit int,
+) ([]types.ThreadEntry, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectThreadsStmt).QueryContext(
+		ctx, userID, userID, roomID, from, participatedOnly, userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectThreads: rows.close() failed")
+	var result []types.ThreadEntry
+	for rows.Next() {
+		var entry types.ThreadEntry
+		if err = rows.Scan(
+			&entry.RootEventID, &entry.LatestEventID, &entry.Position, &entry.Count, &entry.Participated,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+	return result, rows.Err()
+}
+
 func (s *relationsStatements) SelectMaxRelationID(
 	ctx context.Context, txn *sql.Tx,
 ) (id int64, err error) {
@@ -15,5 +15,18 @@ CREATE TABLE IF NOT EXISTS syncapi_multiroom_visibility (
 	type TEXT NOT NULL,
 	room_id TEXT NOT NULL,
 	expire_ts BIGINT NOT NULL DEFAULT 0,
+	start_ts BIGINT NOT NULL DEFAULT 0,
 	PRIMARY KEY(user_id, type, room_id)
-)
+);
+
+CREATE SEQUENCE IF NOT EXISTS syncapi_multiroom_history_id;
+
+CREATE TABLE IF NOT EXISTS syncapi_multiroom_history (
+	id BIGINT PRIMARY KEY DEFAULT nextval('syncapi_multiroom_history_id'),
+	user_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	data BYTEA NOT NULL,
+	ts BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS syncapi_multiroom_history_user_id_type_ts_idx ON syncapi_multiroom_history(user_id, type, ts);
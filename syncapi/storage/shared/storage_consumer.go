@@ -59,7 +59,7 @@ type Database struct {
 	Ignores             tables.Ignores
 	Presence            tables.Presence
 	Relations           tables.Relations
-	MultiRoomQ          *mrd.Queries
+	MultiRoomQ          mrd.Querier
 	MultiRoom           tables.MultiRoom
 }
 
@@ -147,6 +147,10 @@ func (d *Database) AddInviteEvent(
 	ctx context.Context, inviteEvent *rstypes.HeaderedEvent,
 ) (sp types.StreamPosition, err error) {
 	_ = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		// An invite replaces a knock by the same user, so retire it first.
+		if _, err = d.retirePendingInvites(ctx, txn, inviteEvent.RoomID().String(), inviteEvent.UserID.String()); err != nil {
+			return err
+		}
 		sp, err = d.Invites.InsertInviteEvent(ctx, txn, inviteEvent)
 		return err
 	})
@@ -165,6 +169,35 @@ func (d *Database) RetireInviteEvent(
 	return
 }
 
+// RetireKnockEvent removes the pending knock of a user from the database.
+// Returns an error if there was a problem communicating with the database.
+func (d *Database) RetireKnockEvent(
+	ctx context.Context, roomID, userID string,
+) (sp types.StreamPosition, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		sp, err = d.retirePendingInvites(ctx, txn, roomID, userID)
+		return err
+	})
+	return
+}
+
+// retirePendingInvites retires the pending invites and knocks of the user in the
+// room, and returns the position of the last one retired.
+func (d *Database) retirePendingInvites(
+	ctx context.Context, txn *sql.Tx, roomID, userID string,
+) (sp types.StreamPosition, err error) {
+	eventIDs, err := d.Invites.SelectPendingInviteEventIDs(ctx, txn, roomID, userID)
+	if err != nil {
+		return 0, fmt.Errorf("d.Invites.SelectPendingInviteEventIDs: %w", err)
+	}
+	for _, eventID := range eventIDs {
+		if sp, err = d.Invites.DeleteInviteEvent(ctx, txn, eventID); err != nil {
+			return 0, fmt.Errorf("d.Invites.DeleteInviteEvent: %w", err)
+		}
+	}
+	return sp, nil
+}
+
 // AddPeek tracks the fact that a user has started peeking.
 // If the peek was successfully stored this returns the stream ID it was stored at.
 // Returns an error if there was a problem communicating with the database.
@@ -336,9 +369,8 @@ func (d *Database) updateRoomState(
 		}
 
 		if strings.HasPrefix(event.Type(), "connect.multiroom") {
-			err := d.UpdateMultiRoomVisibility(ctx, event)
-			if err != nil {
-				logrus.WithError(err).WithField("event_id", event.EventID()).Error("failed to update multi room visibility")
+			if err := d.updateMultiRoomVisibility(ctx, txn, event); err != nil {
+				return fmt.Errorf("d.updateMultiRoomVisibility: %w", err)
 			}
 		}
 
@@ -543,17 +575,17 @@ func getMembershipFromEvent(ctx context.Context, ev gomatrixserverlib.PDU, userI
 }
 
 // StoreReceipt stores user receipts
-func (d *Database) StoreReceipt(ctx context.Context, roomId, receiptType, userId, eventId string, timestamp spec.Timestamp) (pos types.StreamPosition, err error) {
+func (d *Database) StoreReceipt(ctx context.Context, roomId, receiptType, userId, eventId, threadID string, timestamp spec.Timestamp) (pos types.StreamPosition, err error) {
 	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		pos, err = d.Receipts.UpsertReceipt(ctx, txn, roomId, receiptType, userId, eventId, timestamp)
+		pos, err = d.Receipts.UpsertReceipt(ctx, txn, roomId, receiptType, userId, eventId, threadID, timestamp)
 		return err
 	})
 	return
 }
 
-func (d *Database) UpsertRoomUnreadNotificationCounts(ctx context.Context, userID, roomID string, notificationCount, highlightCount int) (pos types.StreamPosition, err error) {
+func (d *Database) UpsertRoomUnreadNotificationCounts(ctx context.Context, userID string, data *eventutil.NotificationData) (pos types.StreamPosition, err error) {
 	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		pos, err = d.NotificationData.UpsertRoomUnreadCounts(ctx, txn, userID, roomID, notificationCount, highlightCount)
+		pos, err = d.NotificationData.UpsertRoomUnreadCounts(ctx, txn, userID, data)
 		return err
 	})
 	return
@@ -663,30 +695,22 @@ func (s *Database) UpdateLastActive(ctx context.Context, userId string, lastActi
 	return s.Presence.UpdateLastActive(ctx, userId, lastActiveTs)
 }
 
-func (d *Database) UpdateMultiRoomVisibility(ctx context.Context, event *rstypes.HeaderedEvent) error {
+// updateMultiRoomVisibility updates the multiroom visibility of the sender of the state
+// event, in the same transaction as the room state. Events with invalid content are ignored.
+func (d *Database) updateMultiRoomVisibility(ctx context.Context, txn *sql.Tx, event *rstypes.HeaderedEvent) error {
 	var mrdEv mrd.StateEvent
-	err := json.Unmarshal(event.Content(), &mrdEv)
-	if err != nil {
-		return fmt.Errorf("unmarshal multiroom visibility failed: %w", err)
+	if err := json.Unmarshal(event.Content(), &mrdEv); err != nil {
+		logrus.WithError(err).WithField("event_id", event.EventID()).Warn("invalid multiroom visibility event")
+		return nil
 	}
+	userID, roomID := string(event.SenderID()), event.RoomID().String()
 	if mrdEv.Hidden {
-		err = d.MultiRoomQ.DeleteMultiRoomVisibility(ctx, mrd.DeleteMultiRoomVisibilityParams{
-			UserID: string(event.SenderID()),
-			Type:   event.Type(),
-			RoomID: event.RoomID().String(),
-		})
-		if err != nil {
+		if err := d.MultiRoom.RemoveMultiRoomVisibility(ctx, txn, userID, event.Type(), roomID); err != nil {
 			return fmt.Errorf("delete multiroom visibility failed: %w", err)
 		}
 	}
 	if mrdEv.ExpireTs > 0 {
-		err = d.MultiRoomQ.InsertMultiRoomVisibility(ctx, mrd.InsertMultiRoomVisibilityParams{
-			UserID:   string(event.SenderID()),
-			Type:     event.Type(),
-			RoomID:   event.RoomID().String(),
-			ExpireTs: mrdEv.ExpireTs,
-		})
-		if err != nil {
+		if err := d.MultiRoom.UpsertMultiRoomVisibility(ctx, txn, userID, event.Type(), roomID, mrdEv.ExpireTs, int64(event.OriginServerTS())); err != nil {
 			return fmt.Errorf("insert multiroom visibility failed: %w", err)
 		}
 	}
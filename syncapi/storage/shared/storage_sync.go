@@ -691,8 +691,34 @@ func (d *Database) PurgeRoom(ctx context.Context, roomID string) error {
 		if err := d.Receipts.PurgeReceipts(ctx, txn, roomID); err != nil {
 			return fmt.Errorf("failed to purge receipts: %w", err)
 		}
+		if err := d.MultiRoom.PurgeMultiRoomVisibility(ctx, txn, roomID); err != nil {
+			return fmt.Errorf("failed to purge multiroom visibility: %w", err)
+		}
+		return nil
+	})
+}
+
+func (d *Database) StoreMultiRoomData(
+	ctx context.Context, userID, dataType string, data []byte, ts int64, history bool,
+) (pos types.StreamPosition, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		if pos, err = d.MultiRoom.UpsertMultiRoomData(ctx, txn, userID, dataType, data, ts); err != nil {
+			return err
+		}
+		if history {
+			return d.MultiRoom.AppendMultiRoomHistory(ctx, txn, userID, dataType, data, ts)
+		}
 		return nil
 	})
+	return
+}
+
+func (d *Database) PurgeMultiRoomDataForUser(ctx context.Context, userID string) (res types.MultiRoomPurgeResult, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		res, err = d.MultiRoom.PurgeMultiRoomDataForUser(ctx, txn, userID)
+		return err
+	})
+	return
 }
 
 func (d *Database) PurgeRoomState(
@@ -812,10 +838,53 @@ func (d *DatabaseTransaction) RelationsFor(ctx context.Context, roomID, eventID,
 	return events, prevBatch, nextBatch, nil
 }
 
-func (d *DatabaseTransaction) SelectMultiRoomData(ctx context.Context, r *types.Range, joinedRooms []string) (types.MultiRoom, error) {
-	rows, err := d.MultiRoom.SelectMultiRoomData(ctx, r, joinedRooms, d.txn)
+// ThreadsFor returns the threads in a room, most recently active first, along with
+// the root and latest events of the returned threads.
+func (d *DatabaseTransaction) ThreadsFor(ctx context.Context, roomID, userID string, participatedOnly bool, from types.StreamPosition, limit int) (
+	threads []types.ThreadEntry, events map[string]types.StreamEvent, nextBatch string, err error,
+) {
+	if from == 0 {
+		// Start from the latest relation, adding one so that it's included.
+		if from, err = d.MaxStreamPositionForRelations(ctx); err != nil {
+			return nil, nil, "", fmt.Errorf("d.MaxStreamPositionForRelations: %w", err)
+		}
+		from++
+	}
+
+	// Request one more thread than needed, so that we know whether to set "next_batch".
+	threads, err = d.Relations.SelectThreads(ctx, d.txn, roomID, userID, participatedOnly, from, limit+1)
 	if err != nil {
-		return nil, fmt.Errorf("select multi room data: %w", err)
+		return nil, nil, "", fmt.Errorf("d.Relations.SelectThreads: %w", err)
+	}
+	if len(threads) == 0 {
+		return nil, nil, "", nil
+	}
+	if len(threads) > limit {
+		threads = threads[:limit]
+		nextBatch = fmt.Sprintf("%d", threads[len(threads)-1].Position)
+	}
+
+	eventIDs := make([]string, 0, len(threads)*2)
+	for _, thread := range threads {
+		eventIDs = append(eventIDs, thread.RootEventID, thread.LatestEventID)
+	}
+	streamEvents, err := d.OutputEvents.SelectEvents(ctx, d.txn, eventIDs, nil, false)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("d.OutputEvents.SelectEvents: %w", err)
+	}
+	events = make(map[string]types.StreamEvent, len(streamEvents))
+	for _, event := range streamEvents {
+		events[event.EventID()] = event
+	}
+	return threads, events, nextBatch, nil
+}
+
+// SelectMultiRoomData returns the multiroom data in the range which matches the filter. If the
+// filter limit was reached, the returned position is that of the last returned data.
+func (d *DatabaseTransaction) SelectMultiRoomData(ctx context.Context, r *types.Range, joinedRooms []string, filter *synctypes.MultiRoomFilter) (types.MultiRoom, types.StreamPosition, error) {
+	rows, err := d.MultiRoom.SelectMultiRoomData(ctx, r, joinedRooms, filter, d.txn)
+	if err != nil {
+		return nil, 0, fmt.Errorf("select multi room data: %w", err)
 	}
 	mr := make(types.MultiRoom, 3)
 	for _, row := range rows {
@@ -827,8 +896,27 @@ func (d *DatabaseTransaction) SelectMultiRoomData(ctx context.Context, r *types.
 			OriginServerTs: row.Timestamp,
 		}
 	}
-	return mr, nil
+	if filter.Limit > 0 && len(rows) == filter.Limit {
+		return mr, types.StreamPosition(rows[len(rows)-1].ID), nil
+	}
+	return mr, r.High(), nil
+}
 
+func (d *DatabaseTransaction) SelectMultiRoomDataForUser(ctx context.Context, userID string, includeHistory bool) (*types.MultiRoomUserData, error) {
+	var err error
+	res := &types.MultiRoomUserData{}
+	if res.Data, err = d.MultiRoom.SelectMultiRoomDataByUser(ctx, userID, d.txn); err != nil {
+		return nil, fmt.Errorf("select multi room data by user: %w", err)
+	}
+	if res.Visibility, err = d.MultiRoom.SelectMultiRoomVisibilityByUser(ctx, userID, d.txn); err != nil {
+		return nil, fmt.Errorf("select multi room visibility by user: %w", err)
+	}
+	if includeHistory {
+		if res.History, err = d.MultiRoom.SelectMultiRoomHistoryByUser(ctx, userID, d.txn); err != nil {
+			return nil, fmt.Errorf("select multi room history by user: %w", err)
+		}
+	}
+	return res, nil
 }
 
 func (d *DatabaseTransaction) SelectAllMultiRoomDataInRoom(ctx context.Context, roomId string) (types.MultiRoom, error) {
@@ -849,3 +937,11 @@ func (d *DatabaseTransaction) SelectAllMultiRoomDataInRoom(ctx context.Context,
 	return mr, nil
 
 }
+
+func (d *DatabaseTransaction) SelectMultiRoomHistoryInRoom(ctx context.Context, roomId string, filter *types.MultiRoomHistoryFilter) ([]*types.MultiRoomDataRow, error) {
+	rows, err := d.MultiRoom.SelectMultiRoomHistoryInRoom(ctx, roomId, filter, d.txn)
+	if err != nil {
+		return nil, fmt.Errorf("select multi room history in room: %w", err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// UpAddReceiptThreadID adds the thread ID to receipts, so that a user can
+// have one receipt per thread as well as one for the whole room.
+func UpAddReceiptThreadID(ctx context.Context, tx *sql.Tx) error {
+	// SQLite doesn't have "if exists", so check if the column exists. If the query doesn't return an error, it already exists.
+	if rows, err := tx.QueryContext(ctx, "SELECT thread_id FROM syncapi_receipts LIMIT 1"); err == nil {
+		return rows.Close()
+	}
+	// SQLite can't alter constraints, so the table has to be recreated.
+	_, err := tx.ExecContext(ctx, `
+		ALTER TABLE syncapi_receipts RENAME TO syncapi_receipts_tmp;
+		CREATE TABLE syncapi_receipts (
+			id BIGINT,
+			room_id TEXT NOT NULL,
+			receipt_type TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			receipt_ts BIGINT NOT NULL,
+			thread_id TEXT NOT NULL DEFAULT '',
+			CONSTRAINT syncapi_receipts_unique UNIQUE (room_id, receipt_type, user_id, thread_id)
+		);
+		INSERT INTO syncapi_receipts (id, room_id, receipt_type, user_id, event_id, receipt_ts)
+			SELECT id, room_id, receipt_type, user_id, event_id, receipt_ts FROM syncapi_receipts_tmp;
+		DROP TABLE syncapi_receipts_tmp;
+		CREATE INDEX IF NOT EXISTS syncapi_receipts_room_id_idx ON syncapi_receipts(room_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
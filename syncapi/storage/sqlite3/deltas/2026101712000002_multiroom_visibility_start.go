@@ -0,0 +1,37 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// UpAddMultiRoomVisibilityStart adds the time from which the multiroom history
+// of a user is visible in a room. Existing visibility keeps showing all history.
+func UpAddMultiRoomVisibilityStart(ctx context.Context, tx *sql.Tx) error {
+	// SQLite doesn't have "if exists", so check if the column exists. If the query doesn't return an error, it already exists.
+	if rows, err := tx.QueryContext(ctx, "SELECT start_ts FROM syncapi_multiroom_visibility LIMIT 1"); err == nil {
+		return rows.Close()
+	}
+	_, err := tx.ExecContext(ctx, `
+		ALTER TABLE syncapi_multiroom_visibility ADD COLUMN start_ts BIGINT NOT NULL DEFAULT 0;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
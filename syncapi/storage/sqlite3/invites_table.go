@@ -54,6 +54,9 @@ const selectInviteEventsInRangeSQL = "" +
 	" WHERE target_user_id = $1 AND id > $2 AND id <= $3" +
 	" ORDER BY id DESC"
 
+const selectPendingInviteEventIDsSQL = "" +
+	"SELECT event_id FROM syncapi_invite_events WHERE room_id = $1 AND target_user_id = $2 AND deleted=false"
+
 const selectMaxInviteIDSQL = "" +
 	"SELECT MAX(id) FROM syncapi_invite_events"
 
@@ -61,13 +64,14 @@ const purgeInvitesSQL = "" +
 	"DELETE FROM syncapi_invite_events WHERE room_id = $1"
 
 type inviteEventsStatements struct {
-	db                            *sql.DB
-	streamIDStatements            *StreamIDStatements
-	insertInviteEventStmt         *sql.Stmt
-	selectInviteEventsInRangeStmt *sql.Stmt
-	deleteInviteEventStmt         *sql.Stmt
-	selectMaxInviteIDStmt         *sql.Stmt
-	purgeInvitesStmt              *sql.Stmt
+	db                              *sql.DB
+	streamIDStatements              *StreamIDStatements
+	insertInviteEventStmt           *sql.Stmt
+	selectInviteEventsInRangeStmt   *sql.Stmt
+	deleteInviteEventStmt           *sql.Stmt
+	selectPendingInviteEventIDsStmt *sql.Stmt
+	selectMaxInviteIDStmt           *sql.Stmt
+	purgeInvitesStmt                *sql.Stmt
 }
 
 func NewSqliteInvitesTable(db *sql.DB, streamID *StreamIDStatements) (tables.Invites, error) {
@@ -83,6 +87,7 @@ func NewSqliteInvitesTable(db *sql.DB, streamID *StreamIDStatements) (tables.Inv
 		{&s.insertInviteEventStmt, insertInviteEventSQL},
 		{&s.selectInviteEventsInRangeStmt, selectInviteEventsInRangeSQL},
 		{&s.deleteInviteEventStmt, deleteInviteEventSQL},
+		{&s.selectPendingInviteEventIDsStmt, selectPendingInviteEventIDsSQL},
 		{&s.selectMaxInviteIDStmt, selectMaxInviteIDSQL},
 		{&s.purgeInvitesStmt, purgeInvitesSQL},
 	}.Prepare(db)
@@ -179,6 +184,27 @@ func (s *inviteEventsStatements) SelectInviteEventsInRange(
 	return result, retired, lastPos, rows.Err()
 }
 
+// SelectPendingInviteEventIDs returns the IDs of the invite and knock events
+// for the target user in the room which haven't been retired.
+func (s *inviteEventsStatements) SelectPendingInviteEventIDs(
+	ctx context.Context, txn *sql.Tx, roomID, targetUserID string,
+) ([]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectPendingInviteEventIDsStmt).QueryContext(ctx, roomID, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectPendingInviteEventIDs: rows.close() failed")
+	var eventIDs []string
+	for rows.Next() {
+		var eventID string
+		if err = rows.Scan(&eventID); err != nil {
+			return nil, err
+		}
+		eventIDs = append(eventIDs, eventID)
+	}
+	return eventIDs, rows.Err()
+}
+
 func (s *inviteEventsStatements) SelectMaxInviteID(
 	ctx context.Context, txn *sql.Tx,
 ) (id int64, err error) {
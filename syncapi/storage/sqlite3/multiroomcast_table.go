@@ -0,0 +1,504 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/mrd"
+	"github.com/matrix-org/dendrite/syncapi/storage/sqlite3/deltas"
+	"github.com/matrix-org/dendrite/syncapi/storage/tables"
+	"github.com/matrix-org/dendrite/syncapi/synctypes"
+	"github.com/matrix-org/dendrite/syncapi/types"
+)
+
+// The timestamp is stored as milliseconds since the epoch, as SQLite has no
+// native timestamp type and the drivers disagree on how to scan one.
+const multiRoomSchema = `
+CREATE TABLE IF NOT EXISTS syncapi_multiroom_data (
+	id BIGINT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	data BLOB NOT NULL,
+	ts BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS syncapi_multiroom_data_user_id_type_idx ON syncapi_multiroom_data(user_id, type);
+
+CREATE TABLE IF NOT EXISTS syncapi_multiroom_visibility (
+	user_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	room_id TEXT NOT NULL,
+	expire_ts BIGINT NOT NULL DEFAULT 0,
+	start_ts BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY(user_id, type, room_id)
+);
+
+CREATE TABLE IF NOT EXISTS syncapi_multiroom_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	data BLOB NOT NULL,
+	ts BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS syncapi_multiroom_history_user_id_type_ts_idx ON syncapi_multiroom_history(user_id, type, ts);
+`
+
+// The room IDs are expanded into ($3) by SelectMultiRoomData. The filters, ORDER BY and LIMIT
+// are appended by prepareWithFilters, which expects the user ID to be named sender. SQLite binds
+// the parameters in the order they first appear, so they must appear in ascending order.
+const selectMultiRoomCastSQL = "" +
+	"SELECT id, sender, type, data, ts FROM (" +
+	"SELECT d.id, d.user_id AS sender, d.type, d.data, d.ts FROM syncapi_multiroom_data AS d" +
+	" WHERE d.id > $1" +
+	" AND d.id <= $2" +
+	" AND EXISTS (" +
+	"SELECT 1 FROM syncapi_multiroom_visibility AS v" +
+	" WHERE v.user_id = d.user_id" +
+	" AND v.type = d.type || '.visibility'" +
+	" AND v.room_id IN ($3))" +
+	") WHERE 1 = 1"
+
+const selectAllMultiRoomCastInRoomSQL = "" +
+	"SELECT d.user_id, d.type, d.data, d.ts FROM syncapi_multiroom_data AS d" +
+	" JOIN syncapi_multiroom_visibility AS v" +
+	" ON d.user_id = v.user_id" +
+	" AND d.type || '.visibility' = v.type" +
+	" WHERE v.room_id = $1"
+
+const selectMultiRoomHistoryInRoomSQL = "" +
+	"SELECT h.id, h.user_id, h.type, h.data, h.ts FROM syncapi_multiroom_history AS h" +
+	" JOIN syncapi_multiroom_visibility AS v" +
+	" ON h.user_id = v.user_id" +
+	" AND h.type || '.visibility' = v.type" +
+	" WHERE v.room_id = $1" +
+	" AND h.ts >= v.start_ts" +
+	" AND h.ts <= v.expire_ts" +
+	" AND ($2 = '' OR h.user_id = $2)" +
+	" AND (h.ts > $3 OR (h.ts = $3 AND h.id > $4))" +
+	" AND h.ts < $5" +
+	" ORDER BY h.ts ASC, h.id ASC" +
+	" LIMIT $6"
+
+const insertMultiRoomDataSQL = "" +
+	"INSERT INTO syncapi_multiroom_data (id, user_id, type, data, ts)" +
+	" VALUES ($1, $2, $3, $4, $5)" +
+	" ON CONFLICT (user_id, type) DO UPDATE SET id = $1, data = $4, ts = $5"
+
+const insertMultiRoomVisibilitySQL = "" +
+	"INSERT INTO syncapi_multiroom_visibility (user_id, type, room_id, expire_ts, start_ts)" +
+	" VALUES ($1, $2, $3, $4, $5)" +
+	" ON CONFLICT (user_id, type, room_id) DO UPDATE SET expire_ts = $4," +
+	" start_ts = CASE WHEN syncapi_multiroom_visibility.expire_ts <= $5 THEN $5 ELSE syncapi_multiroom_visibility.start_ts END"
+
+const selectMultiRoomVisibilityRoomsSQL = "" +
+	"SELECT room_id FROM syncapi_multiroom_visibility" +
+	" WHERE user_id = $1 AND expire_ts > $2"
+
+const selectMultiRoomVisibilityRoomsByTypeSQL = "" +
+	"SELECT room_id FROM syncapi_multiroom_visibility" +
+	" WHERE user_id = $1 AND type = $2 AND expire_ts > $3"
+
+const selectMultiRoomDataForUserSQL = "" +
+	"SELECT data FROM syncapi_multiroom_data WHERE user_id = $1 AND type = $2"
+
+const selectMaxMultiRoomDataIDSQL = "" +
+	"SELECT MAX(id) FROM syncapi_multiroom_data"
+
+const insertMultiRoomHistorySQL = "" +
+	"INSERT INTO syncapi_multiroom_history (user_id, type, data, ts)" +
+	" VALUES ($1, $2, $3, $4)"
+
+const deleteMultiRoomHistoryByTSSQL = "" +
+	"DELETE FROM syncapi_multiroom_history WHERE type = $1 AND ts < $2"
+
+const deleteMultiRoomVisibilitySQL = "" +
+	"DELETE FROM syncapi_multiroom_visibility" +
+	" WHERE user_id = $1 AND type = $2 AND room_id = $3"
+
+const deleteMultiRoomVisibilityByExpireTSSQL = "" +
+	"DELETE FROM syncapi_multiroom_visibility WHERE expire_ts <= $1"
+
+const selectMultiRoomDataByUserSQL = "" +
+	"SELECT id, user_id, type, data, ts FROM syncapi_multiroom_data" +
+	" WHERE user_id = $1" +
+	" ORDER BY type ASC"
+
+const selectMultiRoomVisibilityByUserSQL = "" +
+	"SELECT type, room_id, expire_ts FROM syncapi_multiroom_visibility" +
+	" WHERE user_id = $1" +
+	" ORDER BY type ASC, room_id ASC"
+
+const selectMultiRoomHistoryByUserSQL = "" +
+	"SELECT id, user_id, type, data, ts FROM syncapi_multiroom_history" +
+	" WHERE user_id = $1" +
+	" ORDER BY ts ASC, id ASC"
+
+const purgeMultiRoomDataForUserSQL = "" +
+	"DELETE FROM syncapi_multiroom_data WHERE user_id = $1"
+
+const purgeMultiRoomVisibilityForUserSQL = "" +
+	"DELETE FROM syncapi_multiroom_visibility WHERE user_id = $1"
+
+const purgeMultiRoomHistoryForUserSQL = "" +
+	"DELETE FROM syncapi_multiroom_history WHERE user_id = $1"
+
+const purgeMultiRoomVisibilitySQL = "" +
+	"DELETE FROM syncapi_multiroom_visibility WHERE room_id = $1"
+
+type multiRoomStatements struct {
+	db                                       *sql.DB
+	writer                                   sqlutil.Writer
+	streamIDStatements                       *StreamIDStatements
+	selectAllMultiRoomCastInRoomStmt         *sql.Stmt
+	selectMultiRoomHistoryInRoomStmt         *sql.Stmt
+	insertMultiRoomDataStmt                  *sql.Stmt
+	insertMultiRoomVisibilityStmt            *sql.Stmt
+	selectMultiRoomVisibilityRoomsStmt       *sql.Stmt
+	selectMultiRoomVisibilityRoomsByTypeStmt *sql.Stmt
+	selectMultiRoomDataForUserStmt           *sql.Stmt
+	selectMaxMultiRoomDataIDStmt             *sql.Stmt
+	insertMultiRoomHistoryStmt               *sql.Stmt
+	deleteMultiRoomHistoryByTSStmt           *sql.Stmt
+	deleteMultiRoomVisibilityStmt            *sql.Stmt
+	deleteMultiRoomVisibilityByExpireTSStmt  *sql.Stmt
+	selectMultiRoomDataByUserStmt            *sql.Stmt
+	selectMultiRoomVisibilityByUserStmt      *sql.Stmt
+	selectMultiRoomHistoryByUserStmt         *sql.Stmt
+	purgeMultiRoomDataForUserStmt            *sql.Stmt
+	purgeMultiRoomVisibilityForUserStmt      *sql.Stmt
+	purgeMultiRoomHistoryForUserStmt         *sql.Stmt
+	purgeMultiRoomVisibilityStmt             *sql.Stmt
+}
+
+// NewSqliteMultiRoomCastTable creates the multiroom tables. The returned
+// statements implement both tables.MultiRoom and the mrd.Querier interface,
+// which sqlc only generates a PostgreSQL implementation for.
+func NewSqliteMultiRoomCastTable(db *sql.DB, writer sqlutil.Writer, streamID *StreamIDStatements) (tables.MultiRoom, mrd.Querier, error) {
+	s := &multiRoomStatements{
+		db:                 db,
+		writer:             writer,
+		streamIDStatements: streamID,
+	}
+	_, err := db.Exec(multiRoomSchema)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := sqlutil.NewMigrator(db)
+	m.AddMigrations(sqlutil.Migration{
+		Version: "syncapi: add start_ts to multiroom visibility",
+		Up:      deltas.UpAddMultiRoomVisibilityStart,
+	})
+	if err = m.Up(context.Background()); err != nil {
+		return nil, nil, err
+	}
+	return s, s, sqlutil.StatementList{
+		{&s.selectAllMultiRoomCastInRoomStmt, selectAllMultiRoomCastInRoomSQL},
+		{&s.selectMultiRoomHistoryInRoomStmt, selectMultiRoomHistoryInRoomSQL},
+		{&s.insertMultiRoomDataStmt, insertMultiRoomDataSQL},
+		{&s.insertMultiRoomVisibilityStmt, insertMultiRoomVisibilitySQL},
+		{&s.selectMultiRoomVisibilityRoomsStmt, selectMultiRoomVisibilityRoomsSQL},
+		{&s.selectMultiRoomVisibilityRoomsByTypeStmt, selectMultiRoomVisibilityRoomsByTypeSQL},
+		{&s.selectMultiRoomDataForUserStmt, selectMultiRoomDataForUserSQL},
+		{&s.selectMaxMultiRoomDataIDStmt, selectMaxMultiRoomDataIDSQL},
+		{&s.insertMultiRoomHistoryStmt, insertMultiRoomHistorySQL},
+		{&s.deleteMultiRoomHistoryByTSStmt, deleteMultiRoomHistoryByTSSQL},
+		{&s.deleteMultiRoomVisibilityStmt, deleteMultiRoomVisibilitySQL},
+		{&s.deleteMultiRoomVisibilityByExpireTSStmt, deleteMultiRoomVisibilityByExpireTSSQL},
+		{&s.selectMultiRoomDataByUserStmt, selectMultiRoomDataByUserSQL},
+		{&s.selectMultiRoomVisibilityByUserStmt, selectMultiRoomVisibilityByUserSQL},
+		{&s.selectMultiRoomHistoryByUserStmt, selectMultiRoomHistoryByUserSQL},
+		{&s.purgeMultiRoomDataForUserStmt, purgeMultiRoomDataForUserSQL},
+		{&s.purgeMultiRoomVisibilityForUserStmt, purgeMultiRoomVisibilityForUserSQL},
+		{&s.purgeMultiRoomHistoryForUserStmt, purgeMultiRoomHistoryForUserSQL},
+		{&s.purgeMultiRoomVisibilityStmt, purgeMultiRoomVisibilitySQL},
+	}.Prepare(db)
+}
+
+func (s *multiRoomStatements) SelectMultiRoomData(ctx context.Context, r *types.Range, joinedRooms []string, filter *synctypes.MultiRoomFilter, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
+	data := make([]*types.MultiRoomDataRow, 0)
+	if len(joinedRooms) == 0 {
+		return data, nil
+	}
+	selectSQL := strings.Replace(selectMultiRoomCastSQL, "($3)", sqlutil.QueryVariadicOffset(len(joinedRooms), 2), 1)
+	params := make([]interface{}, 0, len(joinedRooms)+2)
+	params = append(params, r.Low(), r.High())
+	for _, roomID := range joinedRooms {
+		params = append(params, roomID)
+	}
+	stmt, params, err := prepareWithFilters(
+		s.db, txn, selectSQL, params,
+		filter.Senders, filter.NotSenders,
+		filter.Types, filter.NotTypes,
+		nil, nil, filter.Limit, FilterOrderAsc,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("s.prepareWithFilters: %w", err)
+	}
+	defer internal.CloseAndLogIfError(ctx, stmt, "SelectMultiRoomData: stmt.close() failed")
+	rows, err := stmt.QueryContext(ctx, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMultiRoomData: rows.close() failed")
+	for rows.Next() {
+		r := types.MultiRoomDataRow{}
+		if err = rows.Scan(&r.ID, &r.UserId, &r.Type, &r.Data, &r.Timestamp); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		data = append(data, &r)
+	}
+	return data, rows.Err()
+}
+
+func (s *multiRoomStatements) SelectAllMultiRoomDataInRoom(ctx context.Context, roomId string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectAllMultiRoomCastInRoomStmt).QueryContext(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]*types.MultiRoomDataRow, 0)
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectAllMultiRoomDataInRoom: rows.close() failed")
+	for rows.Next() {
+		r := types.MultiRoomDataRow{}
+		if err = rows.Scan(&r.UserId, &r.Type, &r.Data, &r.Timestamp); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		data = append(data, &r)
+	}
+	return data, rows.Err()
+}
+
+func (s *multiRoomStatements) SelectMultiRoomHistoryInRoom(ctx context.Context, roomId string, filter *types.MultiRoomHistoryFilter, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectMultiRoomHistoryInRoomStmt).QueryContext(
+		ctx, roomId, filter.UserID, filter.FromTs, filter.FromID, filter.ToTs, filter.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]*types.MultiRoomDataRow, 0)
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMultiRoomHistoryInRoom: rows.close() failed")
+	for rows.Next() {
+		r := types.MultiRoomDataRow{}
+		if err = rows.Scan(&r.ID, &r.UserId, &r.Type, &r.Data, &r.Timestamp); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		data = append(data, &r)
+	}
+	return data, rows.Err()
+}
+
+func (s *multiRoomStatements) SelectMultiRoomDataByUser(ctx context.Context, userID string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
+	return s.selectMultiRoomRowsByUser(ctx, s.selectMultiRoomDataByUserStmt, userID, txn)
+}
+
+func (s *multiRoomStatements) SelectMultiRoomHistoryByUser(ctx context.Context, userID string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
+	return s.selectMultiRoomRowsByUser(ctx, s.selectMultiRoomHistoryByUserStmt, userID, txn)
+}
+
+// selectMultiRoomRowsByUser runs a query for the user on the data or history table, which
+// both store the timestamp in milliseconds.
+func (s *multiRoomStatements) selectMultiRoomRowsByUser(ctx context.Context, stmt *sql.Stmt, userID string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error) {
+	rows, err := sqlutil.TxStmt(txn, stmt).QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]*types.MultiRoomDataRow, 0)
+	defer internal.CloseAndLogIfError(ctx, rows, "selectMultiRoomRowsByUser: rows.close() failed")
+	for rows.Next() {
+		r := types.MultiRoomDataRow{}
+		if err = rows.Scan(&r.ID, &r.UserId, &r.Type, &r.Data, &r.Timestamp); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		data = append(data, &r)
+	}
+	return data, rows.Err()
+}
+
+func (s *multiRoomStatements) SelectMultiRoomVisibilityByUser(ctx context.Context, userID string, txn *sql.Tx) ([]types.MultiRoomVisibility, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectMultiRoomVisibilityByUserStmt).QueryContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	visibility := make([]types.MultiRoomVisibility, 0)
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMultiRoomVisibilityByUser: rows.close() failed")
+	for rows.Next() {
+		v := types.MultiRoomVisibility{}
+		if err = rows.Scan(&v.Type, &v.RoomID, &v.ExpireTs); err != nil {
+			return nil, fmt.Errorf("rows scan: %w", err)
+		}
+		v.Type = strings.TrimSuffix(v.Type, ".visibility")
+		visibility = append(visibility, v)
+	}
+	return visibility, rows.Err()
+}
+
+func (s *multiRoomStatements) PurgeMultiRoomDataForUser(ctx context.Context, txn *sql.Tx, userID string) (types.MultiRoomPurgeResult, error) {
+	var res types.MultiRoomPurgeResult
+	for _, purge := range []struct {
+		stmt     *sql.Stmt
+		affected *int64
+	}{
+		{s.purgeMultiRoomDataForUserStmt, &res.Data},
+		{s.purgeMultiRoomVisibilityForUserStmt, &res.Visibility},
+		{s.purgeMultiRoomHistoryForUserStmt, &res.History},
+	} {
+		result, err := sqlutil.TxStmt(txn, purge.stmt).ExecContext(ctx, userID)
+		if err != nil {
+			return res, err
+		}
+		if *purge.affected, err = result.RowsAffected(); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func (s *multiRoomStatements) PurgeMultiRoomVisibility(ctx context.Context, txn *sql.Tx, roomID string) error {
+	_, err := sqlutil.TxStmt(txn, s.purgeMultiRoomVisibilityStmt).ExecContext(ctx, roomID)
+	return err
+}
+
+func (s *multiRoomStatements) UpsertMultiRoomData(ctx context.Context, txn *sql.Tx, userID, dataType string, data []byte, ts int64) (types.StreamPosition, error) {
+	pos, err := s.streamIDStatements.nextMultiRoomID(ctx, txn)
+	if err != nil {
+		return 0, err
+	}
+	_, err = sqlutil.TxStmt(txn, s.insertMultiRoomDataStmt).ExecContext(ctx, pos, userID, dataType, data, ts)
+	return pos, err
+}
+
+func (s *multiRoomStatements) AppendMultiRoomHistory(ctx context.Context, txn *sql.Tx, userID, dataType string, data []byte, ts int64) error {
+	_, err := sqlutil.TxStmt(txn, s.insertMultiRoomHistoryStmt).ExecContext(ctx, userID, dataType, data, ts)
+	return err
+}
+
+func (s *multiRoomStatements) InsertMultiRoomData(ctx context.Context, arg mrd.InsertMultiRoomDataParams) (id int64, err error) {
+	err = s.writer.Do(s.db, nil, func(txn *sql.Tx) error {
+		pos, err := s.UpsertMultiRoomData(ctx, txn, arg.UserID, arg.Type, arg.Data, time.Now().UnixMilli())
+		id = int64(pos)
+		return err
+	})
+	return
+}
+
+func (s *multiRoomStatements) UpsertMultiRoomVisibility(ctx context.Context, txn *sql.Tx, userID, visibilityType, roomID string, expireTs, startTs int64) error {
+	_, err := sqlutil.TxStmt(txn, s.insertMultiRoomVisibilityStmt).ExecContext(ctx, userID, visibilityType, roomID, expireTs, startTs)
+	return err
+}
+
+func (s *multiRoomStatements) RemoveMultiRoomVisibility(ctx context.Context, txn *sql.Tx, userID, visibilityType, roomID string) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteMultiRoomVisibilityStmt).ExecContext(ctx, userID, visibilityType, roomID)
+	return err
+}
+
+func (s *multiRoomStatements) InsertMultiRoomVisibility(ctx context.Context, arg mrd.InsertMultiRoomVisibilityParams) error {
+	return s.writer.Do(s.db, nil, func(txn *sql.Tx) error {
+		return s.UpsertMultiRoomVisibility(ctx, txn, arg.UserID, arg.Type, arg.RoomID, arg.ExpireTs, arg.StartTs)
+	})
+}
+
+func (s *multiRoomStatements) SelectMultiRoomVisibilityRooms(ctx context.Context, arg mrd.SelectMultiRoomVisibilityRoomsParams) ([]string, error) {
+	rows, err := s.selectMultiRoomVisibilityRoomsStmt.QueryContext(ctx, arg.UserID, arg.ExpireTs)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMultiRoomVisibilityRooms: rows.close() failed")
+	var items []string
+	for rows.Next() {
+		var roomID string
+		if err = rows.Scan(&roomID); err != nil {
+			return nil, err
+		}
+		items = append(items, roomID)
+	}
+	return items, rows.Err()
+}
+
+func (s *multiRoomStatements) SelectMultiRoomVisibilityRoomsByType(ctx context.Context, arg mrd.SelectMultiRoomVisibilityRoomsByTypeParams) ([]string, error) {
+	rows, err := s.selectMultiRoomVisibilityRoomsByTypeStmt.QueryContext(ctx, arg.UserID, arg.Type, arg.ExpireTs)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectMultiRoomVisibilityRoomsByType: rows.close() failed")
+	var items []string
+	for rows.Next() {
+		var roomID string
+		if err = rows.Scan(&roomID); err != nil {
+			return nil, err
+		}
+		items = append(items, roomID)
+	}
+	return items, rows.Err()
+}
+
+func (s *multiRoomStatements) SelectMultiRoomDataForUser(ctx context.Context, arg mrd.SelectMultiRoomDataForUserParams) ([]byte, error) {
+	var data []byte
+	err := s.selectMultiRoomDataForUserStmt.QueryRowContext(ctx, arg.UserID, arg.Type).Scan(&data)
+	return data, err
+}
+
+func (s *multiRoomStatements) SelectMaxId(ctx context.Context) (interface{}, error) {
+	var id sql.NullInt64
+	if err := s.selectMaxMultiRoomDataIDStmt.QueryRowContext(ctx).Scan(&id); err != nil {
+		return nil, err
+	}
+	if !id.Valid {
+		return nil, nil
+	}
+	return id.Int64, nil
+}
+
+func (s *multiRoomStatements) InsertMultiRoomHistory(ctx context.Context, arg mrd.InsertMultiRoomHistoryParams) error {
+	return s.writer.Do(s.db, nil, func(txn *sql.Tx) error {
+		return s.AppendMultiRoomHistory(ctx, txn, arg.UserID, arg.Type, arg.Data, arg.Ts)
+	})
+}
+
+func (s *multiRoomStatements) DeleteMultiRoomHistoryByTS(ctx context.Context, arg mrd.DeleteMultiRoomHistoryByTSParams) (affected int64, err error) {
+	err = s.writer.Do(s.db, nil, func(txn *sql.Tx) error {
+		res, err := sqlutil.TxStmt(txn, s.deleteMultiRoomHistoryByTSStmt).ExecContext(ctx, arg.Type, arg.Ts)
+		if err != nil {
+			return err
+		}
+		affected, err = res.RowsAffected()
+		return err
+	})
+	return
+}
+
+func (s *multiRoomStatements) DeleteMultiRoomVisibility(ctx context.Context, arg mrd.DeleteMultiRoomVisibilityParams) error {
+	return s.writer.Do(s.db, nil, func(txn *sql.Tx) error {
+		return s.RemoveMultiRoomVisibility(ctx, txn, arg.UserID, arg.Type, arg.RoomID)
+	})
+}
+
+func (s *multiRoomStatements) DeleteMultiRoomVisibilityByExpireTS(ctx context.Context, expireTs int64) (affected int64, err error) {
+	err = s.writer.Do(s.db, nil, func(txn *sql.Tx) error {
+		res, err := sqlutil.TxStmt(txn, s.deleteMultiRoomVisibilityByExpireTSStmt).ExecContext(ctx, expireTs)
+		if err != nil {
+			return err
+		}
+		affected, err = res.RowsAffected()
+		return err
+	})
+	return
+}
@@ -17,11 +17,13 @@ package sqlite3
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"strings"
 
 	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/eventutil"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/syncapi/storage/sqlite3/deltas"
 	"github.com/matrix-org/dendrite/syncapi/storage/tables"
 	"github.com/matrix-org/dendrite/syncapi/types"
 )
@@ -31,6 +33,14 @@ func NewSqliteNotificationDataTable(db *sql.DB, streamID *StreamIDStatements) (t
 	if err != nil {
 		return nil, err
 	}
+	m := sqlutil.NewMigrator(db)
+	m.AddMigrations(sqlutil.Migration{
+		Version: "syncapi: add thread counts to notification data",
+		Up:      deltas.UpAddNotificationDataThreadCounts,
+	})
+	if err = m.Up(context.Background()); err != nil {
+		return nil, err
+	}
 	r := &notificationDataStatements{
 		streamIDStatements: streamID,
 		db:                 db,
@@ -59,16 +69,18 @@ CREATE TABLE IF NOT EXISTS syncapi_notification_data (
 	room_id TEXT NOT NULL,
 	notification_count BIGINT NOT NULL DEFAULT 0,
 	highlight_count BIGINT NOT NULL DEFAULT 0,
+	-- JSON encoded unread counts per thread root, included in the counts above
+	thread_counts TEXT NOT NULL DEFAULT '',
 	CONSTRAINT syncapi_notifications_unique UNIQUE (user_id, room_id)
 );`
 
 const upsertRoomUnreadNotificationCountsSQL = `INSERT INTO syncapi_notification_data
-  (user_id, room_id, notification_count, highlight_count)
-  VALUES ($1, $2, $3, $4)
+  (user_id, room_id, notification_count, highlight_count, thread_counts)
+  VALUES ($1, $2, $3, $4, $5)
   ON CONFLICT (user_id, room_id)
-  DO UPDATE SET id = $5, notification_count = $6, highlight_count = $7`
+  DO UPDATE SET id = $6, notification_count = $7, highlight_count = $8, thread_counts = $9`
 
-const selectUserUnreadNotificationsForRooms = `SELECT room_id, notification_count, highlight_count
+const selectUserUnreadNotificationsForRooms = `SELECT room_id, notification_count, highlight_count, thread_counts
 	FROM syncapi_notification_data
 	WHERE user_id = $1 AND
 	      room_id IN ($2)`
@@ -78,12 +90,19 @@ const selectMaxNotificationIDSQL = `SELECT CASE COUNT(*) WHEN 0 THEN 0 ELSE MAX(
 const purgeNotificationDataSQL = "" +
 	"DELETE FROM syncapi_notification_data WHERE room_id = $1"
 
-func (r *notificationDataStatements) UpsertRoomUnreadCounts(ctx context.Context, txn *sql.Tx, userID, roomID string, notificationCount, highlightCount int) (pos types.StreamPosition, err error) {
+func (r *notificationDataStatements) UpsertRoomUnreadCounts(ctx context.Context, txn *sql.Tx, userID string, data *eventutil.NotificationData) (pos types.StreamPosition, err error) {
+	threadCounts, err := marshalThreadCounts(data.Threads)
+	if err != nil {
+		return 0, err
+	}
 	pos, err = r.streamIDStatements.nextNotificationID(ctx, nil)
 	if err != nil {
 		return
 	}
-	_, err = r.upsertRoomUnreadCounts.ExecContext(ctx, userID, roomID, notificationCount, highlightCount, pos, notificationCount, highlightCount)
+	_, err = r.upsertRoomUnreadCounts.ExecContext(
+		ctx, userID, data.RoomID, data.UnreadNotificationCount, data.UnreadHighlightCount, threadCounts,
+		pos, data.UnreadNotificationCount, data.UnreadHighlightCount, threadCounts,
+	)
 	return
 }
 
@@ -110,8 +129,9 @@ func (r *notificationDataStatements) SelectUserUnreadCountsForRooms(
 	roomCounts := map[string]*eventutil.NotificationData{}
 	var roomID string
 	var notificationCount, highlightCount int
+	var threadCounts string
 	for rows.Next() {
-		if err = rows.Scan(&roomID, &notificationCount, &highlightCount); err != nil {
+		if err = rows.Scan(&roomID, &notificationCount, &highlightCount, &threadCounts); err != nil {
 			return nil, err
 		}
 
@@ -120,6 +140,11 @@ func (r *notificationDataStatements) SelectUserUnreadCountsForRooms(
 			UnreadNotificationCount: notificationCount,
 			UnreadHighlightCount:    highlightCount,
 		}
+		if threadCounts != "" {
+			if err = json.Unmarshal([]byte(threadCounts), &roomCounts[roomID].Threads); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return roomCounts, rows.Err()
 }
@@ -136,3 +161,13 @@ func (s *notificationDataStatements) PurgeNotificationData(
 	_, err := sqlutil.TxStmt(txn, s.purgeNotificationData).ExecContext(ctx, roomID)
 	return err
 }
+
+// marshalThreadCounts encodes the per-thread counts for storage. Rooms
+// without unread threads are stored as an empty string.
+func marshalThreadCounts(threads map[string]*eventutil.ThreadNotificationData) (string, error) {
+	if len(threads) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(threads)
+	return string(b), err
+}
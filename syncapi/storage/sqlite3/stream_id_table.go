@@ -30,6 +30,8 @@ INSERT INTO syncapi_stream_id (stream_name, stream_id) VALUES ("notification", 0
   ON CONFLICT DO NOTHING;
 INSERT INTO syncapi_stream_id (stream_name, stream_id) VALUES ("relation", 0)
   ON CONFLICT DO NOTHING;
+INSERT INTO syncapi_stream_id (stream_name, stream_id) VALUES ("multiroom", 0)
+  ON CONFLICT DO NOTHING;
 `
 
 const increaseStreamIDStmt = "" +
@@ -93,3 +95,9 @@ func (s *StreamIDStatements) nextRelationID(ctx context.Context, txn *sql.Tx) (p
 	err = increaseStmt.QueryRowContext(ctx, "relation").Scan(&pos)
 	return
 }
+
+func (s *StreamIDStatements) nextMultiRoomID(ctx context.Context, txn *sql.Tx) (pos types.StreamPosition, err error) {
+	increaseStmt := sqlutil.TxStmt(txn, s.increaseStreamIDStmt)
+	err = increaseStmt.QueryRowContext(ctx, "multiroom").Scan(&pos)
+	return
+}
@@ -127,6 +127,10 @@ func (d *SyncServerDatasource) prepare(ctx context.Context) (err error) {
 	if err != nil {
 		return err
 	}
+	mr, mrq, err := NewSqliteMultiRoomCastTable(d.db, d.writer, &d.streamID)
+	if err != nil {
+		return err
+	}
 
 	// apply migrations which need multiple tables
 	m := sqlutil.NewMigrator(d.db)
@@ -158,6 +162,8 @@ func (d *SyncServerDatasource) prepare(ctx context.Context) (err error) {
 		Ignores:             ignores,
 		Presence:            presence,
 		Relations:           relations,
+		MultiRoom:           mr,
+		MultiRoomQ:          mrq,
 	}
 	return nil
 }
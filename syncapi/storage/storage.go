@@ -29,18 +29,20 @@ import (
 )
 
 // NewSyncServerDatasource opens a database connection.
-func NewSyncServerDatasource(ctx context.Context, conMan *sqlutil.Connections, dbProperties *config.DatabaseOptions) (Database, *mrd.Queries, error) {
+func NewSyncServerDatasource(ctx context.Context, conMan *sqlutil.Connections, dbProperties *config.DatabaseOptions) (Database, mrd.Querier, error) {
 	switch {
 	case dbProperties.ConnectionString.IsSQLite():
 		ds, err := sqlite3.NewDatabase(ctx, conMan, dbProperties)
-		return ds, nil, err
+		if err != nil {
+			return nil, nil, err
+		}
+		return ds, ds.MultiRoomQ, nil
 	case dbProperties.ConnectionString.IsPostgres():
 		ds, err := postgres.NewDatabase(ctx, conMan, dbProperties)
 		if err != nil {
 			return nil, nil, err
 		}
-		mrq := mrd.New(ds.DB)
-		return ds, mrq, nil
+		return ds, ds.MultiRoomQ, nil
 	default:
 		return nil, nil, fmt.Errorf("unexpected database type")
 	}
@@ -3,17 +3,20 @@ package storage_test
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"math"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/roomserver/api"
 	rstypes "github.com/matrix-org/dendrite/roomserver/types"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/storage/mrd"
 	"github.com/matrix-org/dendrite/syncapi/synctypes"
 	"github.com/matrix-org/dendrite/syncapi/types"
 	"github.com/matrix-org/dendrite/test"
@@ -1066,3 +1069,497 @@ func TestRedaction(t *testing.T) {
 		}
 	})
 }
+
+func TestKnockBehaviour(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	room := test.NewRoom(t, alice, test.RoomVersion(gomatrixserverlib.RoomVersionV10))
+	room.CreateAndInsert(t, alice, spec.MRoomJoinRules, map[string]interface{}{"join_rule": spec.KnockRestricted, "allow": []interface{}{}}, test.WithStateKey(""))
+	knockEvent := room.CreateEvent(t, bob, spec.MRoomMember, map[string]interface{}{"membership": spec.Knock}, test.WithStateKey(bob.ID))
+	inviteEvent := room.CreateEvent(t, alice, spec.MRoomMember, map[string]interface{}{"membership": spec.Invite}, test.WithStateKey(bob.ID))
+	bobUserID, err := spec.NewUserID(bob.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knockEvent.UserID = *bobUserID
+	inviteEvent.UserID = *bobUserID
+
+	// pendingEvents returns the pending invites and knocks, and the retired ones.
+	pendingEvents := func(t *testing.T, db storage.Database) (map[string]*rstypes.HeaderedEvent, map[string]*rstypes.HeaderedEvent) {
+		t.Helper()
+		snapshot, err := db.NewDatabaseSnapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snapshot.Rollback() // nolint: errcheck
+		invites, retired, _, err := snapshot.InviteEventsInRange(ctx, bob.ID, types.Range{From: 0, To: math.MaxInt64})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return invites, retired
+	}
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		db, close := MustCreateDatabase(t, dbType)
+		t.Cleanup(close)
+
+		// the knock is pending
+		if _, err = db.AddInviteEvent(ctx, knockEvent); err != nil {
+			t.Fatalf("failed to add knock: %s", err)
+		}
+		invites, _ := pendingEvents(t, db)
+		if ev, ok := invites[room.ID]; !ok || ev.EventID() != knockEvent.EventID() {
+			t.Fatalf("expected the knock to be pending, got %v", invites)
+		}
+
+		// an invite replaces the knock
+		if _, err = db.AddInviteEvent(ctx, inviteEvent); err != nil {
+			t.Fatalf("failed to add invite: %s", err)
+		}
+		invites, retired := pendingEvents(t, db)
+		if ev, ok := invites[room.ID]; !ok || ev.EventID() != inviteEvent.EventID() || len(retired) != 0 {
+			t.Fatalf("expected the invite to replace the knock, got %v, retired %v", invites, retired)
+		}
+
+		// a new knock can be retired
+		if _, err = db.AddInviteEvent(ctx, knockEvent); err != nil {
+			t.Fatalf("failed to add knock: %s", err)
+		}
+		pos, err := db.RetireKnockEvent(ctx, room.ID, bob.ID)
+		if err != nil {
+			t.Fatalf("failed to retire knock: %s", err)
+		}
+		if pos == 0 {
+			t.Fatalf("expected the knock to be retired")
+		}
+		invites, retired = pendingEvents(t, db)
+		if _, ok := retired[room.ID]; !ok || len(invites) != 0 {
+			t.Fatalf("expected the knock to be retired, got %v, retired %v", invites, retired)
+		}
+
+		// retiring again is a no-op
+		if pos, err = db.RetireKnockEvent(ctx, room.ID, bob.ID); err != nil || pos != 0 {
+			t.Fatalf("expected no knock to retire, got position %d: %v", pos, err)
+		}
+	})
+}
+
+func TestMultiRoomData(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	const dataType = "connect.multiroom.location"
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		room := test.NewRoom(t, alice)
+		otherRoom := test.NewRoom(t, alice)
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		t.Cleanup(close)
+		cm := sqlutil.NewConnectionManager(nil, config.DatabaseOptions{})
+		db, mrq, err := storage.NewSyncServerDatasource(ctx, cm, &config.DatabaseOptions{
+			ConnectionString: config.DataSource(connStr),
+		})
+		if err != nil {
+			t.Fatalf("NewSyncServerDatasource returned %s", err)
+		}
+
+		// Nothing has been written yet.
+		maxID, err := mrq.SelectMaxId(ctx)
+		assert.NoError(t, err)
+		assert.Nil(t, maxID)
+
+		// Alice makes her location visible in the room by sending a state event.
+		expireTs := time.Now().Add(time.Hour).UnixMilli()
+		room.CreateAndInsert(t, alice, dataType+".visibility", map[string]interface{}{
+			"expire_ts": expireTs,
+		}, test.WithStateKey(alice.ID))
+		MustWriteEvents(t, db, room.Events())
+		MustWriteEvents(t, db, otherRoom.Events())
+
+		// Bob is visible in the room too, but his visibility has already expired.
+		err = mrq.InsertMultiRoomVisibility(ctx, mrd.InsertMultiRoomVisibilityParams{
+			UserID:   bob.ID,
+			Type:     dataType + ".visibility",
+			RoomID:   room.ID,
+			ExpireTs: time.Now().Add(-time.Minute).UnixMilli(),
+		})
+		assert.NoError(t, err)
+
+		rooms, err := mrq.SelectMultiRoomVisibilityRooms(ctx, mrd.SelectMultiRoomVisibilityRoomsParams{
+			UserID:   alice.ID,
+			ExpireTs: time.Now().UnixMilli(),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{room.ID}, rooms)
+
+		rooms, err = mrq.SelectMultiRoomVisibilityRoomsByType(ctx, mrd.SelectMultiRoomVisibilityRoomsByTypeParams{
+			UserID:   alice.ID,
+			Type:     dataType + ".visibility",
+			ExpireTs: time.Now().UnixMilli(),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{room.ID}, rooms)
+		rooms, err = mrq.SelectMultiRoomVisibilityRoomsByType(ctx, mrd.SelectMultiRoomVisibilityRoomsByTypeParams{
+			UserID:   alice.ID,
+			Type:     "connect.multiroom.other.visibility",
+			ExpireTs: time.Now().UnixMilli(),
+		})
+		assert.NoError(t, err)
+		assert.Empty(t, rooms)
+
+		_, err = mrq.SelectMultiRoomDataForUser(ctx, mrd.SelectMultiRoomDataForUserParams{UserID: alice.ID, Type: dataType})
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		firstPos, err := mrq.InsertMultiRoomData(ctx, mrd.InsertMultiRoomDataParams{
+			UserID: alice.ID,
+			Type:   dataType,
+			Data:   []byte(`{"lat":1}`),
+		})
+		assert.NoError(t, err)
+		// Replacing the data must move it to a new stream position.
+		latestPos, err := mrq.InsertMultiRoomData(ctx, mrd.InsertMultiRoomDataParams{
+			UserID: alice.ID,
+			Type:   dataType,
+			Data:   []byte(`{"lat":2}`),
+		})
+		assert.NoError(t, err)
+		assert.Greater(t, latestPos, firstPos)
+
+		data, err := mrq.SelectMultiRoomDataForUser(ctx, mrd.SelectMultiRoomDataForUserParams{UserID: alice.ID, Type: dataType})
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(`{"lat":2}`), data)
+
+		maxID, err = mrq.SelectMaxId(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, latestPos, maxID)
+
+		WithSnapshot(t, db, func(snapshot storage.DatabaseTransaction) {
+			mr, pos, err := snapshot.SelectMultiRoomData(ctx, &types.Range{From: 0, To: types.StreamPosition(latestPos)}, []string{room.ID, otherRoom.ID}, &synctypes.MultiRoomFilter{})
+			assert.NoError(t, err)
+			assert.Equal(t, types.StreamPosition(latestPos), pos)
+			assert.Len(t, mr, 1)
+			assert.Equal(t, types.MultiRoomContent(`{"lat":2}`), mr[alice.ID][dataType].Content)
+			assert.NotZero(t, mr[alice.ID][dataType].OriginServerTs)
+
+			// The data is not visible in rooms without a visibility entry.
+			mr, _, err = snapshot.SelectMultiRoomData(ctx, &types.Range{From: 0, To: types.StreamPosition(latestPos)}, []string{otherRoom.ID}, &synctypes.MultiRoomFilter{})
+			assert.NoError(t, err)
+			assert.Len(t, mr, 0)
+
+			// Nothing new after the latest position.
+			mr, _, err = snapshot.SelectMultiRoomData(ctx, &types.Range{From: types.StreamPosition(latestPos), To: types.StreamPosition(latestPos)}, []string{room.ID}, &synctypes.MultiRoomFilter{})
+			assert.NoError(t, err)
+			assert.Len(t, mr, 0)
+
+			mr, err = snapshot.SelectAllMultiRoomDataInRoom(ctx, room.ID)
+			assert.NoError(t, err)
+			assert.Len(t, mr, 1)
+			assert.Equal(t, types.MultiRoomContent(`{"lat":2}`), mr[alice.ID][dataType].Content)
+		})
+
+		// Only Bob's expired visibility is swept.
+		affected, err := mrq.DeleteMultiRoomVisibilityByExpireTS(ctx, time.Now().UnixMilli())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+
+		// Hiding the location removes the visibility entry.
+		room.CreateAndInsert(t, alice, dataType+".visibility", map[string]interface{}{
+			"hidden": true,
+		}, test.WithStateKey(alice.ID))
+		MustWriteEvents(t, db, room.Events()[len(room.Events())-1:])
+		WithSnapshot(t, db, func(snapshot storage.DatabaseTransaction) {
+			mr, err := snapshot.SelectAllMultiRoomDataInRoom(ctx, room.ID)
+			assert.NoError(t, err)
+			assert.Len(t, mr, 0)
+		})
+	})
+}
+
+func TestMultiRoomDataFilter(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	const locationType = "connect.multiroom.location"
+	const statusType = "connect.multiroom.status"
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		room := test.NewRoom(t, alice)
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		t.Cleanup(close)
+		cm := sqlutil.NewConnectionManager(nil, config.DatabaseOptions{})
+		db, mrq, err := storage.NewSyncServerDatasource(ctx, cm, &config.DatabaseOptions{
+			ConnectionString: config.DataSource(connStr),
+		})
+		if err != nil {
+			t.Fatalf("NewSyncServerDatasource returned %s", err)
+		}
+
+		var latestPos int64
+		for _, user := range []*test.User{alice, bob} {
+			for _, dataType := range []string{locationType, statusType} {
+				err = mrq.InsertMultiRoomVisibility(ctx, mrd.InsertMultiRoomVisibilityParams{
+					UserID:   user.ID,
+					Type:     dataType + ".visibility",
+					RoomID:   room.ID,
+					ExpireTs: time.Now().Add(time.Hour).UnixMilli(),
+				})
+				assert.NoError(t, err)
+				latestPos, err = mrq.InsertMultiRoomData(ctx, mrd.InsertMultiRoomDataParams{
+					UserID: user.ID,
+					Type:   dataType,
+					Data:   []byte(`{}`),
+				})
+				assert.NoError(t, err)
+			}
+		}
+		r := &types.Range{From: 0, To: types.StreamPosition(latestPos)}
+
+		WithSnapshot(t, db, func(snapshot storage.DatabaseTransaction) {
+			mr, pos, err := snapshot.SelectMultiRoomData(ctx, r, []string{room.ID}, &synctypes.MultiRoomFilter{
+				Types: &[]string{locationType},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, r.To, pos)
+			assert.Len(t, mr, 2)
+			assert.Len(t, mr[alice.ID], 1)
+			assert.Contains(t, mr[alice.ID], locationType)
+
+			mr, _, err = snapshot.SelectMultiRoomData(ctx, r, []string{room.ID}, &synctypes.MultiRoomFilter{
+				NotTypes: &[]string{locationType},
+				Senders:  &[]string{bob.ID},
+			})
+			assert.NoError(t, err)
+			assert.Len(t, mr, 1)
+			assert.Len(t, mr[bob.ID], 1)
+			assert.Contains(t, mr[bob.ID], statusType)
+
+			mr, _, err = snapshot.SelectMultiRoomData(ctx, r, []string{room.ID}, &synctypes.MultiRoomFilter{
+				NotSenders: &[]string{alice.ID, bob.ID},
+			})
+			assert.NoError(t, err)
+			assert.Len(t, mr, 0)
+
+			// Hitting the limit returns the position of the last item, so the
+			// remaining items are returned by the next sync.
+			mr, pos, err = snapshot.SelectMultiRoomData(ctx, r, []string{room.ID}, &synctypes.MultiRoomFilter{Limit: 3})
+			assert.NoError(t, err)
+			assert.Less(t, pos, r.To)
+			assert.Len(t, mr[alice.ID], 2)
+			assert.Len(t, mr[bob.ID], 1)
+			mr, pos, err = snapshot.SelectMultiRoomData(ctx, &types.Range{From: pos, To: r.To}, []string{room.ID}, &synctypes.MultiRoomFilter{Limit: 3})
+			assert.NoError(t, err)
+			assert.Equal(t, r.To, pos)
+			assert.Len(t, mr, 1)
+			assert.Contains(t, mr[bob.ID], statusType)
+		})
+	})
+}
+
+func TestMultiRoomDataForUser(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	const dataType = "connect.multiroom.location"
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		t.Cleanup(close)
+		cm := sqlutil.NewConnectionManager(nil, config.DatabaseOptions{})
+		db, mrq, err := storage.NewSyncServerDatasource(ctx, cm, &config.DatabaseOptions{
+			ConnectionString: config.DataSource(connStr),
+		})
+		if err != nil {
+			t.Fatalf("NewSyncServerDatasource returned %s", err)
+		}
+
+		room := test.NewRoom(t, alice)
+		otherRoom := test.NewRoom(t, alice)
+		for _, user := range []*test.User{alice, bob} {
+			for _, roomID := range []string{room.ID, otherRoom.ID} {
+				err = mrq.InsertMultiRoomVisibility(ctx, mrd.InsertMultiRoomVisibilityParams{
+					UserID:   user.ID,
+					Type:     dataType + ".visibility",
+					RoomID:   roomID,
+					ExpireTs: time.Now().Add(time.Hour).UnixMilli(),
+				})
+				assert.NoError(t, err)
+			}
+			_, err = mrq.InsertMultiRoomData(ctx, mrd.InsertMultiRoomDataParams{
+				UserID: user.ID,
+				Type:   dataType,
+				Data:   []byte(`{"geo_uri":"geo:1,2"}`),
+			})
+			assert.NoError(t, err)
+			for _, ts := range []int64{1000, 2000} {
+				err = mrq.InsertMultiRoomHistory(ctx, mrd.InsertMultiRoomHistoryParams{
+					UserID: user.ID,
+					Type:   dataType,
+					Data:   []byte(fmt.Sprintf(`{"ts":%d}`, ts)),
+					Ts:     ts,
+				})
+				assert.NoError(t, err)
+			}
+		}
+
+		WithSnapshot(t, db, func(snapshot storage.DatabaseTransaction) {
+			stored, err := snapshot.SelectMultiRoomDataForUser(ctx, alice.ID, false)
+			assert.NoError(t, err)
+			assert.Len(t, stored.Data, 1)
+			assert.Equal(t, `{"geo_uri":"geo:1,2"}`, string(stored.Data[0].Data))
+			assert.Len(t, stored.Visibility, 2)
+			assert.Equal(t, dataType, stored.Visibility[0].Type)
+			assert.Nil(t, stored.History)
+
+			stored, err = snapshot.SelectMultiRoomDataForUser(ctx, alice.ID, true)
+			assert.NoError(t, err)
+			assert.Len(t, stored.History, 2)
+			assert.Equal(t, int64(1000), stored.History[0].Timestamp)
+		})
+
+		// Purging a room only deletes the visibility in that room.
+		err = db.PurgeRoom(ctx, room.ID)
+		assert.NoError(t, err)
+		WithSnapshot(t, db, func(snapshot storage.DatabaseTransaction) {
+			stored, err := snapshot.SelectMultiRoomDataForUser(ctx, bob.ID, false)
+			assert.NoError(t, err)
+			assert.Len(t, stored.Data, 1)
+			assert.Equal(t, []types.MultiRoomVisibility{{Type: dataType, RoomID: otherRoom.ID, ExpireTs: stored.Visibility[0].ExpireTs}}, stored.Visibility)
+		})
+
+		purged, err := db.PurgeMultiRoomDataForUser(ctx, alice.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, types.MultiRoomPurgeResult{Data: 1, Visibility: 1, History: 2}, purged)
+		WithSnapshot(t, db, func(snapshot storage.DatabaseTransaction) {
+			stored, err := snapshot.SelectMultiRoomDataForUser(ctx, alice.ID, true)
+			assert.NoError(t, err)
+			assert.Len(t, stored.Data, 0)
+			assert.Len(t, stored.Visibility, 0)
+			assert.Len(t, stored.History, 0)
+
+			// Other users are not affected.
+			stored, err = snapshot.SelectMultiRoomDataForUser(ctx, bob.ID, true)
+			assert.NoError(t, err)
+			assert.Len(t, stored.Data, 1)
+			assert.Len(t, stored.History, 2)
+		})
+	})
+}
+
+func TestMultiRoomHistory(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	const dataType = "connect.multiroom.location"
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		connStr, close := test.PrepareDBConnectionString(t, dbType)
+		t.Cleanup(close)
+		cm := sqlutil.NewConnectionManager(nil, config.DatabaseOptions{})
+		db, mrq, err := storage.NewSyncServerDatasource(ctx, cm, &config.DatabaseOptions{
+			ConnectionString: config.DataSource(connStr),
+		})
+		if err != nil {
+			t.Fatalf("NewSyncServerDatasource returned %s", err)
+		}
+
+		room := test.NewRoom(t, alice)
+		expireTs := int64(5000)
+		for _, user := range []*test.User{alice, bob} {
+			err = mrq.InsertMultiRoomVisibility(ctx, mrd.InsertMultiRoomVisibilityParams{
+				UserID:   user.ID,
+				Type:     dataType + ".visibility",
+				RoomID:   room.ID,
+				ExpireTs: expireTs,
+			})
+			assert.NoError(t, err)
+		}
+		for _, ts := range []int64{1000, 2000, 2000, 3000, 6000} {
+			for _, user := range []*test.User{alice, bob} {
+				err = mrq.InsertMultiRoomHistory(ctx, mrd.InsertMultiRoomHistoryParams{
+					UserID: user.ID,
+					Type:   dataType,
+					Data:   []byte(fmt.Sprintf(`{"ts":%d}`, ts)),
+					Ts:     ts,
+				})
+				assert.NoError(t, err)
+			}
+		}
+
+		WithSnapshot(t, db, func(snapshot storage.DatabaseTransaction) {
+			// The payload sent after the visibility expired is not returned.
+			rows, err := snapshot.SelectMultiRoomHistoryInRoom(ctx, room.ID, &types.MultiRoomHistoryFilter{
+				UserID: alice.ID,
+				ToTs:   math.MaxInt64,
+				Limit:  100,
+			})
+			assert.NoError(t, err)
+			assert.Len(t, rows, 4)
+			for _, row := range rows {
+				assert.Equal(t, alice.ID, row.UserId)
+				assert.LessOrEqual(t, row.Timestamp, expireTs)
+			}
+
+			// Paginate through both users, two rows at a time, without losing rows with the same timestamp.
+			filter := &types.MultiRoomHistoryFilter{ToTs: math.MaxInt64, Limit: 2}
+			var seen []int64
+			for {
+				rows, err = snapshot.SelectMultiRoomHistoryInRoom(ctx, room.ID, filter)
+				assert.NoError(t, err)
+				for _, row := range rows {
+					seen = append(seen, row.Timestamp)
+				}
+				if len(rows) < filter.Limit {
+					break
+				}
+				filter.FromTs, filter.FromID = rows[len(rows)-1].Timestamp, rows[len(rows)-1].ID
+			}
+			assert.Equal(t, []int64{1000, 1000, 2000, 2000, 2000, 2000, 3000, 3000}, seen)
+
+			// The upper bound is exclusive.
+			rows, err = snapshot.SelectMultiRoomHistoryInRoom(ctx, room.ID, &types.MultiRoomHistoryFilter{
+				FromTs: 2000,
+				ToTs:   3000,
+				Limit:  100,
+			})
+			assert.NoError(t, err)
+			assert.Len(t, rows, 4)
+		})
+
+		// History from before the visibility was granted is not returned. Extending
+		// the visibility before it expired keeps its start.
+		lateRoom := test.NewRoom(t, alice)
+		for _, v := range []struct{ startTs, expireTs int64 }{{2500, expireTs}, {4000, 7000}} {
+			err = mrq.InsertMultiRoomVisibility(ctx, mrd.InsertMultiRoomVisibilityParams{
+				UserID:   bob.ID,
+				Type:     dataType + ".visibility",
+				RoomID:   lateRoom.ID,
+				ExpireTs: v.expireTs,
+				StartTs:  v.startTs,
+			})
+			assert.NoError(t, err)
+		}
+		// The data and its history are stored together.
+		_, err = db.StoreMultiRoomData(ctx, bob.ID, dataType, []byte(`{"ts":6500}`), 6500, true)
+		assert.NoError(t, err)
+		WithSnapshot(t, db, func(snapshot storage.DatabaseTransaction) {
+			rows, err := snapshot.SelectMultiRoomHistoryInRoom(ctx, lateRoom.ID, &types.MultiRoomHistoryFilter{
+				ToTs:  math.MaxInt64,
+				Limit: 100,
+			})
+			assert.NoError(t, err)
+			var seen []int64
+			for _, row := range rows {
+				seen = append(seen, row.Timestamp)
+			}
+			assert.Equal(t, []int64{3000, 6000, 6500}, seen)
+
+			data, err := snapshot.SelectMultiRoomDataForUser(ctx, bob.ID, false)
+			assert.NoError(t, err)
+			assert.Len(t, data.Data, 1)
+			assert.Equal(t, int64(6500), data.Data[0].Timestamp)
+		})
+
+		affected, err := mrq.DeleteMultiRoomHistoryByTS(ctx, mrd.DeleteMultiRoomHistoryByTSParams{
+			Type: dataType,
+			Ts:   2500,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(6), affected)
+	})
+}
@@ -41,6 +41,8 @@ type Invites interface {
 	// SelectInviteEventsInRange returns a map of room ID to invite events. If multiple invite/retired invites exist in the given range, return the latest value
 	// for the room.
 	SelectInviteEventsInRange(ctx context.Context, txn *sql.Tx, targetUserID string, r types.Range) (invites map[string]*rstypes.HeaderedEvent, retired map[string]*rstypes.HeaderedEvent, maxID types.StreamPosition, err error)
+	// SelectPendingInviteEventIDs returns the IDs of the invite and knock events for the target user in the room which haven't been retired.
+	SelectPendingInviteEventIDs(ctx context.Context, txn *sql.Tx, roomID, targetUserID string) ([]string, error)
 	SelectMaxInviteID(ctx context.Context, txn *sql.Tx) (id int64, err error)
 	PurgeInvites(ctx context.Context, txn *sql.Tx, roomID string) error
 }
@@ -185,7 +187,7 @@ type Filter interface {
 }
 
 type Receipts interface {
-	UpsertReceipt(ctx context.Context, txn *sql.Tx, roomId, receiptType, userId, eventId string, timestamp spec.Timestamp) (pos types.StreamPosition, err error)
+	UpsertReceipt(ctx context.Context, txn *sql.Tx, roomId, receiptType, userId, eventId, threadID string, timestamp spec.Timestamp) (pos types.StreamPosition, err error)
 	SelectRoomReceiptsAfter(ctx context.Context, txn *sql.Tx, roomIDs []string, streamPos types.StreamPosition) (types.StreamPosition, []types.OutputReceiptEvent, error)
 	SelectMaxReceiptID(ctx context.Context, txn *sql.Tx) (id int64, err error)
 	PurgeReceipts(ctx context.Context, txn *sql.Tx, roomID string) error
@@ -204,7 +206,7 @@ type Memberships interface {
 }
 
 type NotificationData interface {
-	UpsertRoomUnreadCounts(ctx context.Context, txn *sql.Tx, userID, roomID string, notificationCount, highlightCount int) (types.StreamPosition, error)
+	UpsertRoomUnreadCounts(ctx context.Context, txn *sql.Tx, userID string, data *eventutil.NotificationData) (types.StreamPosition, error)
 	SelectUserUnreadCountsForRooms(ctx context.Context, txn *sql.Tx, userID string, roomIDs []string) (map[string]*eventutil.NotificationData, error)
 	SelectMaxID(ctx context.Context, txn *sql.Tx) (int64, error)
 	PurgeNotificationData(ctx context.Context, txn *sql.Tx, roomID string) error
@@ -237,6 +239,10 @@ type Relations interface {
 	// will be returned, inclusive of the "to" position but excluding the "from" position. The stream
 	// position returned is the maximum position of the returned results.
 	SelectRelationsInRange(ctx context.Context, txn *sql.Tx, roomID, eventID, relType, eventType string, r types.Range, limit int) (map[string][]types.RelationEntry, types.StreamPosition, error)
+	// SelectThreads returns the threads in a room, ordered by their latest reply with the most
+	// recent first. Only threads with a latest reply before the "from" position are returned. If
+	// participatedOnly is set, only threads which the user started or replied to are returned.
+	SelectThreads(ctx context.Context, txn *sql.Tx, roomID, userID string, participatedOnly bool, from types.StreamPosition, limit int) ([]types.ThreadEntry, error)
 	// SelectMaxRelationID returns the maximum ID of all relations, used to determine what the boundaries
 	// should be if there are no boundaries supplied (i.e. we want to work backwards but don't have a
 	// "from" or want to work forwards and don't have a "to").
@@ -244,6 +250,28 @@ type Relations interface {
 }
 
 type MultiRoom interface {
-	SelectMultiRoomData(ctx context.Context, r *types.Range, joinedRooms []string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error)
+	SelectMultiRoomData(ctx context.Context, r *types.Range, joinedRooms []string, filter *synctypes.MultiRoomFilter, txn *sql.Tx) ([]*types.MultiRoomDataRow, error)
 	SelectAllMultiRoomDataInRoom(ctx context.Context, roomId string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error)
+	// SelectMultiRoomHistoryInRoom returns the retained history of users who are visible in the room,
+	// limited to payloads sent before their visibility expires.
+	SelectMultiRoomHistoryInRoom(ctx context.Context, roomId string, filter *types.MultiRoomHistoryFilter, txn *sql.Tx) ([]*types.MultiRoomDataRow, error)
+	// SelectMultiRoomDataByUser returns the latest payload of each data type sent by the user.
+	SelectMultiRoomDataByUser(ctx context.Context, userID string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error)
+	// SelectMultiRoomVisibilityByUser returns the visibility of the user's data types, including expired rows.
+	SelectMultiRoomVisibilityByUser(ctx context.Context, userID string, txn *sql.Tx) ([]types.MultiRoomVisibility, error)
+	// SelectMultiRoomHistoryByUser returns the retained history of the user in chronological order.
+	SelectMultiRoomHistoryByUser(ctx context.Context, userID string, txn *sql.Tx) ([]*types.MultiRoomDataRow, error)
+	// PurgeMultiRoomDataForUser deletes the data, visibility and history of the user.
+	PurgeMultiRoomDataForUser(ctx context.Context, txn *sql.Tx, userID string) (types.MultiRoomPurgeResult, error)
+	// PurgeMultiRoomVisibility deletes the visibility of all users in the room.
+	PurgeMultiRoomVisibility(ctx context.Context, txn *sql.Tx, roomID string) error
+	// UpsertMultiRoomData replaces the data of the type sent by the user, and returns its new stream position.
+	UpsertMultiRoomData(ctx context.Context, txn *sql.Tx, userID, dataType string, data []byte, ts int64) (types.StreamPosition, error)
+	// AppendMultiRoomHistory adds the data to the history of the user.
+	AppendMultiRoomHistory(ctx context.Context, txn *sql.Tx, userID, dataType string, data []byte, ts int64) error
+	// UpsertMultiRoomVisibility makes the data type of the user visible in the room from startTs until expireTs.
+	// The start of a visibility which hasn't expired yet is kept.
+	UpsertMultiRoomVisibility(ctx context.Context, txn *sql.Tx, userID, visibilityType, roomID string, expireTs, startTs int64) error
+	// RemoveMultiRoomVisibility hides the data type of the user in the room.
+	RemoveMultiRoomVisibility(ctx context.Context, txn *sql.Tx, userID, visibilityType, roomID string) error
 }
@@ -3,9 +3,14 @@ package tables_test
 import (
 	"context"
 	"database/sql"
+	"reflect"
 	"testing"
 
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	rstypes "github.com/matrix-org/dendrite/roomserver/types"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/syncapi/storage/postgres"
 	"github.com/matrix-org/dendrite/syncapi/storage/sqlite3"
@@ -14,7 +19,7 @@ import (
 	"github.com/matrix-org/dendrite/test"
 )
 
-func newRelationsTable(t *testing.T, dbType test.DBType) (tables.Relations, *sql.DB, func()) {
+func newRelationsTable(t *testing.T, dbType test.DBType) (tables.Relations, tables.Events, *sql.DB, func()) {
 	t.Helper()
 	connStr, close := test.PrepareDBConnectionString(t, dbType)
 	db, err := sqlutil.Open(&config.DatabaseOptions{
@@ -24,21 +29,27 @@ func newRelationsTable(t *testing.T, dbType test.DBType) (tables.Relations, *sql
 		t.Fatalf("failed to open db: %s", err)
 	}
 
+	// The events table is needed to find out who participated in threads.
 	var tab tables.Relations
+	var events tables.Events
 	switch dbType {
 	case test.DBTypePostgres:
-		tab, err = postgres.NewPostgresRelationsTable(db)
+		if events, err = postgres.NewPostgresEventsTable(db); err == nil {
+			tab, err = postgres.NewPostgresRelationsTable(db)
+		}
 	case test.DBTypeSQLite:
 		var stream sqlite3.StreamIDStatements
 		if err = stream.Prepare(db); err != nil {
 			t.Fatalf("failed to prepare stream stmts: %s", err)
 		}
-		tab, err = sqlite3.NewSqliteRelationsTable(db, &stream)
+		if events, err = sqlite3.NewSqliteEventsTable(db, &stream); err == nil {
+			tab, err = sqlite3.NewSqliteRelationsTable(db, &stream)
+		}
 	}
 	if err != nil {
 		t.Fatalf("failed to make new table: %s", err)
 	}
-	return tab, db, close
+	return tab, events, db, close
 }
 
 func compareRelationsToExpected(t *testing.T, tab tables.Relations, r types.Range, expected []types.RelationEntry) {
@@ -66,7 +77,7 @@ const relType = "m.reaction"
 func TestRelationsTable(t *testing.T) {
 	ctx := context.Background()
 	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
-		tab, _, close := newRelationsTable(t, dbType)
+		tab, _, _, close := newRelationsTable(t, dbType)
 		defer close()
 
 		// Insert some relations
@@ -184,3 +195,98 @@ func TestRelationsTable(t *testing.T) {
 		}
 	})
 }
+
+func TestRelationsTableThreads(t *testing.T) {
+	ctx := context.Background()
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	charlie := test.NewUser(t)
+	room := test.NewRoom(t, alice)
+	for _, user := range []*test.User{bob, charlie} {
+		room.CreateAndInsert(t, user, spec.MRoomMember, map[string]interface{}{"membership": "join"}, test.WithStateKey(user.ID))
+	}
+
+	type relation struct {
+		event   *rstypes.HeaderedEvent
+		parent  string
+		relType string
+	}
+	var relations []relation
+	relate := func(sender *test.User, parent *rstypes.HeaderedEvent, relType string) *rstypes.HeaderedEvent {
+		ev := room.CreateAndInsert(t, sender, "m.room.message", map[string]interface{}{
+			"body": "reply",
+			"m.relates_to": map[string]interface{}{
+				"rel_type": relType,
+				"event_id": parent.EventID(),
+			},
+		})
+		relations = append(relations, relation{event: ev, parent: parent.EventID(), relType: relType})
+		return ev
+	}
+	root1 := room.CreateAndInsert(t, alice, "m.room.message", map[string]interface{}{"body": "root1"})
+	root2 := room.CreateAndInsert(t, bob, "m.room.message", map[string]interface{}{"body": "root2"})
+	reply1 := relate(bob, root1, "m.thread")
+	relate(bob, root2, "m.thread")
+	relate(alice, root2, "m.annotation") // not part of the thread
+	reply2 := relate(charlie, root2, "m.thread")
+
+	thread1 := types.ThreadEntry{Position: 1, RootEventID: root1.EventID(), LatestEventID: reply1.EventID(), Count: 1}
+	thread2 := types.ThreadEntry{Position: 4, RootEventID: root2.EventID(), LatestEventID: reply2.EventID(), Count: 2}
+	participated := func(entry types.ThreadEntry) types.ThreadEntry {
+		entry.Participated = true
+		return entry
+	}
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		tab, events, db, close := newRelationsTable(t, dbType)
+		defer close()
+
+		err := sqlutil.WithTransaction(db, func(txn *sql.Tx) error {
+			for _, ev := range room.Events() {
+				// The sender is stored as the user ID, which the roomserver normally sets.
+				userID, err := spec.NewUserID(string(ev.SenderID()), true)
+				if err != nil {
+					return err
+				}
+				ev.UserID = *userID
+				if _, err = events.InsertEvent(ctx, txn, ev, nil, nil, nil, false, gomatrixserverlib.HistoryVisibilityShared); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rel := range relations {
+			if err := tab.InsertRelation(ctx, nil, room.ID, rel.parent, rel.event.EventID(), rel.event.Type(), rel.relType); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for name, tc := range map[string]struct {
+			senderID         string
+			participatedOnly bool
+			from             types.StreamPosition
+			limit            int
+			want             []types.ThreadEntry
+		}{
+			"all threads":                      {senderID: alice.ID, from: 10, limit: 10, want: []types.ThreadEntry{thread2, participated(thread1)}},
+			"paginated":                        {senderID: alice.ID, from: 4, limit: 10, want: []types.ThreadEntry{participated(thread1)}},
+			"limited":                          {senderID: alice.ID, from: 10, limit: 1, want: []types.ThreadEntry{thread2}},
+			"participated by sending the root": {senderID: alice.ID, participatedOnly: true, from: 10, limit: 10, want: []types.ThreadEntry{participated(thread1)}},
+			"participated by replying":         {senderID: charlie.ID, participatedOnly: true, from: 10, limit: 10, want: []types.ThreadEntry{participated(thread2)}},
+			"participated in both threads":     {senderID: bob.ID, participatedOnly: true, from: 10, limit: 10, want: []types.ThreadEntry{participated(thread2), participated(thread1)}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				got, err := tab.SelectThreads(ctx, nil, room.ID, tc.senderID, tc.participatedOnly, tc.from, tc.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tc.want) {
+					t.Fatalf("SelectThreads\ngot  %+v\nwant %+v", got, tc.want)
+				}
+			})
+		}
+	})
+}
@@ -79,6 +79,16 @@ func (p *InviteStreamProvider) IncrementalSync(
 		if _, ok := req.IgnoredUsers.List[user.String()]; ok {
 			continue
 		}
+		// Knocks are stored alongside invites, but are returned in their own section.
+		if membership, _ := inviteEvent.Membership(); membership == spec.Knock {
+			kr, err := types.NewKnockResponse(ctx, p.rsAPI, inviteEvent, eventFormat)
+			if err != nil {
+				req.Log.WithError(err).Error("failed creating knock response")
+				continue
+			}
+			req.Response.Rooms.Knock[roomID] = kr
+			continue
+		}
 		ir, err := types.NewInviteResponse(ctx, p.rsAPI, inviteEvent, eventFormat)
 		if err != nil {
 			req.Log.WithError(err).Error("failed creating invite response")
@@ -21,13 +21,14 @@ import (
 	"github.com/matrix-org/dendrite/syncapi/notifier"
 	"github.com/matrix-org/dendrite/syncapi/storage"
 	"github.com/matrix-org/dendrite/syncapi/storage/mrd"
+	"github.com/matrix-org/dendrite/syncapi/synctypes"
 	"github.com/matrix-org/dendrite/syncapi/types"
 )
 
 type MultiRoomDataStreamProvider struct {
 	DefaultStreamProvider
 	notifier *notifier.Notifier
-	mrdDb    *mrd.Queries
+	mrdDb    mrd.Querier
 }
 
 func (p *MultiRoomDataStreamProvider) Setup(ctx context.Context, snapshot storage.DatabaseTransaction) {
@@ -60,13 +61,43 @@ func (p *MultiRoomDataStreamProvider) IncrementalSync(
 	req *types.SyncRequest,
 	from, to types.StreamPosition,
 ) types.StreamPosition {
-	mr, err := snapshot.SelectMultiRoomData(ctx, &types.Range{From: from, To: to}, req.JoinedRooms)
+	filter := &req.Filter.MultiRoom
+	rooms := filterMultiRoomRooms(req.JoinedRooms, filter)
+	mr, pos, err := snapshot.SelectMultiRoomData(ctx, &types.Range{From: from, To: to}, rooms, filter)
 	if err != nil {
-		req.Log.WithError(err).Error("GetUserUnreadNotificationCountsForRooms failed")
+		req.Log.WithError(err).Error("SelectMultiRoomData failed")
 		return from
 	}
 	req.Log.Tracef("MultiRoomDataStreamProvider IncrementalSync: %+v", mr)
 	req.Response.MultiRoom = mr
-	return to
+	return pos
+}
 
+// filterMultiRoomRooms returns the joined rooms allowed by the rooms and not_rooms of the filter.
+func filterMultiRoomRooms(joinedRooms []string, filter *synctypes.MultiRoomFilter) []string {
+	if filter.Rooms == nil && filter.NotRooms == nil {
+		return joinedRooms
+	}
+	included := make(map[string]bool, len(joinedRooms))
+	if filter.Rooms != nil {
+		for _, roomID := range *filter.Rooms {
+			included[roomID] = true
+		}
+	} else {
+		for _, roomID := range joinedRooms {
+			included[roomID] = true
+		}
+	}
+	if filter.NotRooms != nil {
+		for _, roomID := range *filter.NotRooms {
+			delete(included, roomID)
+		}
+	}
+	rooms := make([]string, 0, len(joinedRooms))
+	for _, roomID := range joinedRooms {
+		if included[roomID] {
+			rooms = append(rooms, roomID)
+		}
+	}
+	return rooms
 }
@@ -60,6 +60,19 @@ func (p *NotificationDataStreamProvider) IncrementalSync(
 			HighlightCount:    counts.UnreadHighlightCount,
 			NotificationCount: counts.UnreadNotificationCount,
 		}
+		// If the client wants thread notifications separately, the room
+		// counts only cover the main timeline.
+		if req.Filter.Room.Timeline.UnreadThreadNotifications && len(counts.Threads) > 0 {
+			jr.UnreadThreadNotifications = make(map[string]*types.UnreadNotifications, len(counts.Threads))
+			for threadID, threadCounts := range counts.Threads {
+				jr.UnreadThreadNotifications[threadID] = &types.UnreadNotifications{
+					HighlightCount:    threadCounts.UnreadHighlightCount,
+					NotificationCount: threadCounts.UnreadNotificationCount,
+				}
+				jr.UnreadNotifications.HighlightCount -= threadCounts.UnreadHighlightCount
+				jr.UnreadNotifications.NotificationCount -= threadCounts.UnreadNotificationCount
+			}
+		}
 		req.Response.Rooms.Join[roomID] = jr
 	}
 
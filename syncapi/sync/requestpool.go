@@ -53,6 +53,8 @@ type RequestPool struct {
 	Notifier *notifier.Notifier
 	producer PresencePublisher
 	consumer PresenceConsumer
+	// slidingConns holds the state of sliding sync connections
+	slidingConns *sync.Map
 }
 
 type PresencePublisher interface {
@@ -87,8 +89,11 @@ func NewRequestPool(
 		Notifier: notifier,
 		producer: producer,
 		consumer: consumer,
+
+		slidingConns: &sync.Map{},
 	}
 	go rp.cleanLastSeen()
+	go rp.cleanSlidingSyncConnections()
 	// go rp.cleanPresence(db, time.Minute*5)
 	return rp
 }
@@ -0,0 +1,818 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/matrix-org/util"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+
+	"github.com/matrix-org/dendrite/clientapi/httputil"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	rstypes "github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/dendrite/syncapi/internal"
+	"github.com/matrix-org/dendrite/syncapi/notifier"
+	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/synctypes"
+	"github.com/matrix-org/dendrite/syncapi/types"
+	userapi "github.com/matrix-org/dendrite/userapi/api"
+)
+
+const (
+	// maxSlidingTimelineLimit caps the timeline_limit a client may ask for.
+	maxSlidingTimelineLimit = 100
+	// slidingSyncConnectionExpiry is how long an unused connection is kept
+	// around before the client has to start again from scratch.
+	slidingSyncConnectionExpiry = time.Minute * 30
+	// errUnknownPos tells the client to drop its pos and start a new connection.
+	errUnknownPos spec.MatrixErrorCode = "M_UNKNOWN_POS"
+)
+
+// slidingSyncConnection remembers, per room, the PDU position up to which a
+// sliding sync connection has been sent the room, so that subsequent requests
+// only need to send what changed since.
+type slidingSyncConnection struct {
+	sync.Mutex
+	pos      string
+	rooms    map[string]types.StreamPosition
+	lastUsed time.Time
+	// requests counts the requests on the connection, so that a request
+	// which was waiting for updates can tell it was superseded.
+	requests uint64
+}
+
+// slidingRoom is a room the user has a membership in, along with the stream
+// position of its latest event, which is used to sort lists by recency.
+type slidingRoom struct {
+	roomID     string
+	membership string
+	bumpStamp  types.StreamPosition
+	invite     *rstypes.HeaderedEvent
+	isDM       bool
+}
+
+func (rp *RequestPool) cleanSlidingSyncConnections() {
+	for {
+		rp.slidingConns.Range(func(key interface{}, v interface{}) bool {
+			conn := v.(*slidingSyncConnection)
+			conn.Lock()
+			expired := time.Since(conn.lastUsed) > slidingSyncConnectionExpiry
+			conn.Unlock()
+			if expired {
+				rp.slidingConns.Delete(key)
+			}
+			return true
+		})
+		time.Sleep(time.Minute)
+	}
+}
+
+// OnIncomingSlidingSyncRequest implements POST /org.matrix.simplified_msc3575/sync
+// nolint:gocyclo
+func (rp *RequestPool) OnIncomingSlidingSyncRequest(req *http.Request, device *userapi.Device) util.JSONResponse {
+	var ssReq types.SlidingSyncRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &ssReq); resErr != nil {
+		return *resErr
+	}
+	for name, list := range ssReq.Lists {
+		for _, r := range list.Ranges {
+			if r[0] < 0 || r[1] < r[0] {
+				return util.JSONResponse{
+					Code: http.StatusBadRequest,
+					JSON: spec.InvalidParam(fmt.Sprintf("list %q has an invalid range", name)),
+				}
+			}
+		}
+	}
+
+	query := req.URL.Query()
+	timeout := getTimeout(query.Get("timeout"))
+	posStr := query.Get("pos")
+	since := types.StreamingToken{}
+	if posStr != "" {
+		var err error
+		if since, err = types.NewStreamTokenFromString(posStr); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam(err.Error()),
+			}
+		}
+	}
+
+	// Look up the connection. A client may only continue from the last pos
+	// we handed out on this connection, since the per-room positions have
+	// moved on since any earlier one.
+	connKey := device.UserID + "|" + device.ID + "|" + ssReq.ConnID
+	var conn *slidingSyncConnection
+	if posStr == "" {
+		conn = &slidingSyncConnection{rooms: map[string]types.StreamPosition{}}
+		rp.slidingConns.Store(connKey, conn)
+	} else if v, ok := rp.slidingConns.Load(connKey); ok {
+		conn = v.(*slidingSyncConnection)
+	}
+	if conn == nil {
+		return unknownPosResponse()
+	}
+	conn.Lock()
+	if conn.pos != posStr {
+		conn.Unlock()
+		return unknownPosResponse()
+	}
+	// A newer request on the connection supersedes any request still waiting
+	// for updates, as the client has given up on that one.
+	conn.requests++
+	request := conn.requests
+	conn.lastUsed = time.Now()
+	conn.Unlock()
+
+	// The to-device extension has its own position, as clients may only enable
+	// it on one of their connections. Messages are deleted once the client
+	// acknowledges them by sending their next_batch as since.
+	toDevice := ssReq.Extensions.ToDevice
+	var toDeviceSince types.StreamPosition
+	if toDevice.IsEnabled() && toDevice.Since != "" {
+		p, err := strconv.ParseInt(toDevice.Since, 10, 64)
+		if err != nil || p < 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("invalid to_device since"),
+			}
+		}
+		toDeviceSince = types.StreamPosition(p)
+	}
+
+	activeSyncRequests.Inc()
+	defer activeSyncRequests.Dec()
+
+	rp.updateLastSeen(req, device)
+	rp.updatePresence(rp.db, "", device.UserID)
+
+	waitingSyncRequests.Inc()
+	defer waitingSyncRequests.Dec()
+
+	logger := util.GetLogger(req.Context()).WithFields(logrus.Fields{
+		"user_id":   device.UserID,
+		"device_id": device.ID,
+		"conn_id":   ssReq.ConnID,
+		"pos":       posStr,
+		"timeout":   timeout,
+	})
+
+	if toDeviceSince > 0 {
+		if err := rp.db.CleanSendToDeviceUpdates(req.Context(), device.UserID, device.ID, toDeviceSince); err != nil {
+			logger.WithError(err).Error("p.DB.CleanSendToDeviceUpdates failed")
+		}
+	}
+
+	giveup := func() util.JSONResponse {
+		logger.Debugln("Responding to sliding sync since client gave up or timeout was reached")
+		res := types.NewSlidingSyncResponse()
+		res.Pos = posStr
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: res,
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var userStreamListener *notifier.UserDeviceStreamListener
+
+	// loop until we get some data
+	for {
+		startTime := time.Now()
+		currentPos := rp.Notifier.CurrentPosition()
+
+		// if the pos matches the current positions, wait via the notifier
+		toDeviceWaiting := toDevice.IsEnabled() && toDeviceSince < currentPos.SendToDevicePosition
+		if !since.IsEmpty() && !currentPos.IsAfter(since) && !toDeviceWaiting && timeout > 0 {
+			if userStreamListener == nil {
+				listener := rp.Notifier.GetListener(types.SyncRequest{Context: req.Context(), Device: device})
+				defer listener.Close()
+				userStreamListener = &listener
+			}
+
+			select {
+			case <-req.Context().Done(): // Caller gave up
+				return giveup()
+
+			case <-timer.C: // Timeout reached
+				return giveup()
+
+			case <-userStreamListener.GetNotifyChannel(since):
+				currentPos.ApplyUpdates(userStreamListener.GetSyncPosition())
+			}
+		}
+
+		conn.Lock()
+		if conn.requests != request || conn.pos != posStr {
+			conn.Unlock()
+			return giveup()
+		}
+		res, newPos, rooms, err := rp.slidingSync(req.Context(), logger, device, &ssReq, conn, since, toDeviceSince, currentPos)
+		if err != nil {
+			conn.Unlock()
+			logger.WithError(err).Error("Failed to process sliding sync request")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+
+		// As with /sync, don't return a no-op response if there might still
+		// be time to wait for something more interesting to happen.
+		if !since.IsEmpty() && !res.HasUpdates() && timeout > 0 {
+			timeout -= time.Since(startTime)
+			if timeout > 0 {
+				conn.Unlock()
+				since = newPos
+				if toDevice.IsEnabled() {
+					toDeviceSince = newPos.SendToDevicePosition
+				}
+				continue
+			}
+		}
+
+		for roomID, pos := range rooms {
+			if pos == 0 {
+				delete(conn.rooms, roomID)
+				continue
+			}
+			conn.rooms[roomID] = pos
+		}
+		res.Pos = newPos.String()
+		conn.pos = res.Pos
+		conn.lastUsed = time.Now()
+		conn.Unlock()
+		return util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: res,
+		}
+	}
+}
+
+func unknownPosResponse() util.JSONResponse {
+	return util.JSONResponse{
+		Code: http.StatusBadRequest,
+		JSON: spec.MatrixError{
+			ErrCode: errUnknownPos,
+			Err:     "Unknown or expired pos, start a new connection",
+		},
+	}
+}
+
+// slidingSync calculates a sliding sync response between since and currentPos.
+// It returns the response, the next pos, and the updated per-room positions
+// for the connection, where a position of zero means the room was dropped.
+// nolint:gocyclo
+func (rp *RequestPool) slidingSync(
+	ctx context.Context, logger *logrus.Entry, device *userapi.Device,
+	ssReq *types.SlidingSyncRequest, conn *slidingSyncConnection,
+	since types.StreamingToken, toDeviceSince types.StreamPosition, currentPos types.StreamingToken,
+) (res *types.SlidingSyncResponse, newPos types.StreamingToken, sent map[string]types.StreamPosition, err error) {
+	snapshot, err := rp.db.NewDatabaseSnapshot(ctx)
+	if err != nil {
+		return nil, since, nil, err
+	}
+	var succeeded bool
+	defer sqlutil.EndTransactionWithCheck(snapshot, &succeeded, &err)
+
+	res = types.NewSlidingSyncResponse()
+	newPos = currentPos
+	sent = map[string]types.StreamPosition{}
+
+	syncReq := &types.SyncRequest{
+		Context:           ctx,
+		Log:               logger,
+		Device:            device,
+		Response:          types.NewResponse(),
+		Filter:            synctypes.DefaultFilter(),
+		Since:             since,
+		Rooms:             make(map[string]string),
+		MembershipChanges: make(map[string]struct{}),
+	}
+	if ignores, ierr := snapshot.IgnoresForUser(ctx, device.UserID); ierr == nil {
+		syncReq.IgnoredUsers = *ignores
+	} else if ierr != sql.ErrNoRows {
+		return nil, since, nil, ierr
+	}
+
+	rooms, err := rp.slidingRooms(ctx, snapshot, syncReq, ssReq, conn, currentPos)
+	if err != nil {
+		return nil, since, nil, err
+	}
+
+	// Work out which rooms are visible through the lists and subscriptions,
+	// merging the room configs where a room is visible more than once.
+	wanted := map[string]*types.SlidingRoomConfig{}
+	want := func(roomID string, cfg types.SlidingRoomConfig) {
+		merged, ok := wanted[roomID]
+		if !ok {
+			merged = &types.SlidingRoomConfig{}
+			wanted[roomID] = merged
+		}
+		if cfg.TimelineLimit > merged.TimelineLimit {
+			merged.TimelineLimit = cfg.TimelineLimit
+		}
+		merged.RequiredState = append(merged.RequiredState, cfg.RequiredState...)
+	}
+	for name, list := range ssReq.Lists {
+		filtered := make([]*slidingRoom, 0, len(rooms))
+		for _, room := range rooms {
+			if room.membership != spec.Join && room.membership != spec.Invite {
+				continue
+			}
+			if list.Filters != nil {
+				if list.Filters.IsInvite != nil && *list.Filters.IsInvite != (room.membership == spec.Invite) {
+					continue
+				}
+				if list.Filters.IsDM != nil && *list.Filters.IsDM != room.isDM {
+					continue
+				}
+			}
+			filtered = append(filtered, room)
+		}
+		sort.SliceStable(filtered, func(i, j int) bool {
+			if filtered[i].bumpStamp != filtered[j].bumpStamp {
+				return filtered[i].bumpStamp > filtered[j].bumpStamp
+			}
+			return filtered[i].roomID < filtered[j].roomID
+		})
+		res.Lists[name] = types.SlidingListResult{Count: len(filtered)}
+		for _, r := range list.Ranges {
+			for i := r[0]; i <= r[1] && i < len(filtered); i++ {
+				want(filtered[i].roomID, list.SlidingRoomConfig)
+			}
+		}
+	}
+	for roomID, cfg := range ssReq.RoomSubscriptions {
+		if room, ok := rooms[roomID]; ok && room.membership != spec.Leave {
+			want(roomID, cfg)
+		}
+	}
+	// Rooms which the client has seen before but which the user has since left
+	// are sent one last time, so the client sees the leave.
+	for roomID, room := range rooms {
+		if room.membership == spec.Leave {
+			want(roomID, types.SlidingRoomConfig{TimelineLimit: 1})
+		}
+	}
+
+	for roomID, cfg := range wanted {
+		room := rooms[roomID]
+		from, seen := conn.rooms[roomID]
+		if seen && room.bumpStamp <= from {
+			if room.membership == spec.Leave {
+				sent[roomID] = 0
+			}
+			continue
+		}
+		var result *types.SlidingRoomResult
+		if room.membership == spec.Invite {
+			if seen {
+				continue
+			}
+			result = slidingInviteRoom(room)
+		} else {
+			result, err = rp.slidingJoinedRoom(ctx, snapshot, syncReq, room, cfg, from, !seen, currentPos.PDUPosition)
+			if err != nil {
+				return nil, since, nil, err
+			}
+		}
+		res.Rooms[roomID] = result
+		if room.membership == spec.Leave {
+			sent[roomID] = 0
+		} else {
+			sent[roomID] = currentPos.PDUPosition
+		}
+	}
+
+	if err = rp.slidingNotificationCounts(ctx, snapshot, device.UserID, res); err != nil {
+		return nil, since, nil, err
+	}
+	rp.slidingExtensions(ctx, snapshot, syncReq, ssReq, res, since, toDeviceSince, currentPos, &newPos)
+
+	succeeded = true
+	return res, newPos, sent, nil
+}
+
+// slidingRooms returns all rooms the user is joined or invited to, as well as
+// any rooms previously sent on this connection which the user has since left.
+func (rp *RequestPool) slidingRooms(
+	ctx context.Context, snapshot storage.DatabaseTransaction, syncReq *types.SyncRequest,
+	ssReq *types.SlidingSyncRequest, conn *slidingSyncConnection, currentPos types.StreamingToken,
+) (map[string]*slidingRoom, error) {
+	userID := syncReq.Device.UserID
+	rooms := map[string]*slidingRoom{}
+
+	joinedRoomIDs, err := snapshot.RoomIDsWithMembership(ctx, userID, spec.Join)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot.RoomIDsWithMembership: %w", err)
+	}
+	for _, roomID := range joinedRoomIDs {
+		rooms[roomID] = &slidingRoom{roomID: roomID, membership: spec.Join}
+		syncReq.Rooms[roomID] = spec.Join
+	}
+
+	invites, _, _, err := snapshot.InviteEventsInRange(ctx, userID, types.Range{To: currentPos.InvitePosition})
+	if err != nil {
+		return nil, fmt.Errorf("snapshot.InviteEventsInRange: %w", err)
+	}
+	for roomID, inviteEvent := range invites {
+		if _, ok := rooms[roomID]; ok {
+			continue
+		}
+		room := &slidingRoom{roomID: roomID, membership: spec.Invite, invite: inviteEvent}
+		if _, spos, perr := snapshot.PositionInTopology(ctx, inviteEvent.EventID()); perr == nil {
+			room.bumpStamp = spos
+		}
+		rooms[roomID] = room
+		syncReq.Rooms[roomID] = spec.Invite
+	}
+
+	for roomID := range conn.rooms {
+		if _, ok := rooms[roomID]; !ok {
+			rooms[roomID] = &slidingRoom{roomID: roomID, membership: spec.Leave}
+			syncReq.Rooms[roomID] = spec.Leave
+		}
+	}
+
+	// Sort joined and left rooms by their latest event.
+	var roomIDs []string
+	for roomID, room := range rooms {
+		if room.membership != spec.Invite {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	if len(roomIDs) > 0 {
+		filter := synctypes.DefaultRoomEventFilter()
+		filter.Limit = 1
+		latest, err := snapshot.RecentEvents(ctx, roomIDs, types.Range{To: currentPos.PDUPosition}, &filter, false, true)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot.RecentEvents: %w", err)
+		}
+		for roomID, recent := range latest {
+			if len(recent.Events) > 0 {
+				rooms[roomID].bumpStamp = recent.Events[0].StreamPosition
+			}
+		}
+	}
+
+	// Only look up m.direct if a list actually filters on it.
+	for _, list := range ssReq.Lists {
+		if list.Filters == nil || list.Filters.IsDM == nil {
+			continue
+		}
+		dataReq := userapi.QueryAccountDataRequest{UserID: userID, DataType: "m.direct"}
+		dataRes := userapi.QueryAccountDataResponse{}
+		if err = rp.userAPI.QueryAccountData(ctx, &dataReq, &dataRes); err != nil {
+			return nil, fmt.Errorf("rp.userAPI.QueryAccountData: %w", err)
+		}
+		direct := map[string][]string{}
+		if data, ok := dataRes.GlobalAccountData["m.direct"]; ok {
+			if err = json.Unmarshal(data, &direct); err != nil {
+				syncReq.Log.WithError(err).Warn("Failed to parse m.direct account data")
+			}
+		}
+		for _, dmRoomIDs := range direct {
+			for _, roomID := range dmRoomIDs {
+				if room, ok := rooms[roomID]; ok {
+					room.isDM = true
+				}
+			}
+		}
+		break
+	}
+	for _, room := range rooms {
+		if room.invite != nil && gjson.GetBytes(room.invite.Content(), "is_direct").Bool() {
+			room.isDM = true
+		}
+	}
+
+	return rooms, nil
+}
+
+// slidingInviteRoom returns the stripped state of an invite.
+func slidingInviteRoom(room *slidingRoom) *types.SlidingRoomResult {
+	result := &types.SlidingRoomResult{
+		Initial:   true,
+		BumpStamp: room.bumpStamp,
+	}
+	for _, ev := range gjson.GetBytes(room.invite.Unsigned(), "invite_room_state").Array() {
+		result.InviteState = append(result.InviteState, json.RawMessage(ev.Raw))
+		if ev.Get("type").Str == spec.MRoomName && ev.Get("state_key").Str == "" {
+			result.Name = ev.Get("content.name").Str
+		}
+	}
+	inviteEvent, err := json.Marshal(synctypes.ClientEvent{
+		Type:     room.invite.Type(),
+		Sender:   string(room.invite.SenderID()),
+		StateKey: room.invite.StateKey(),
+		Content:  room.invite.Content(),
+	})
+	if err == nil {
+		result.InviteState = append(result.InviteState, inviteEvent)
+	}
+	return result
+}
+
+// slidingJoinedRoom calculates the timeline and required state for a room,
+// either from scratch if initial is set, or since the from position.
+// nolint:gocyclo
+func (rp *RequestPool) slidingJoinedRoom(
+	ctx context.Context, snapshot storage.DatabaseTransaction, syncReq *types.SyncRequest,
+	room *slidingRoom, cfg *types.SlidingRoomConfig,
+	from types.StreamPosition, initial bool, to types.StreamPosition,
+) (*types.SlidingRoomResult, error) {
+	userID := syncReq.Device.UserID
+	result := &types.SlidingRoomResult{
+		Initial:   initial,
+		BumpStamp: room.bumpStamp,
+	}
+
+	limit := cfg.TimelineLimit
+	if limit > maxSlidingTimelineLimit {
+		limit = maxSlidingTimelineLimit
+	}
+	var events []*rstypes.HeaderedEvent
+	if limit > 0 {
+		filter := synctypes.DefaultRoomEventFilter()
+		filter.Limit = limit
+		if len(syncReq.IgnoredUsers.List) > 0 {
+			notSenders := make([]string, 0, len(syncReq.IgnoredUsers.List))
+			for ignored := range syncReq.IgnoredUsers.List {
+				notSenders = append(notSenders, ignored)
+			}
+			filter.NotSenders = &notSenders
+		}
+		recent, err := snapshot.RecentEvents(ctx, []string{room.roomID}, types.Range{From: from, To: to}, &filter, true, true)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot.RecentEvents: %w", err)
+		}
+		recentEvents := snapshot.StreamEventsToEvents(ctx, syncReq.Device, recent[room.roomID].Events, rp.rsAPI)
+		parsedUserID, err := spec.NewUserID(userID, true)
+		if err != nil {
+			return nil, err
+		}
+		events, err = internal.ApplyHistoryVisibilityFilter(ctx, snapshot, rp.rsAPI, recentEvents, nil, *parsedUserID, "sliding_sync")
+		if err != nil {
+			return nil, err
+		}
+		result.Limited = recent[room.roomID].Limited
+		if !initial {
+			result.NumLive = len(events)
+		}
+		if result.Limited && len(events) > 0 {
+			prevBatch, err := snapshot.GetBackwardTopologyPos(ctx, events)
+			if err != nil {
+				return nil, fmt.Errorf("snapshot.GetBackwardTopologyPos: %w", err)
+			}
+			result.PrevBatch = prevBatch.String()
+		}
+		result.Timeline = rp.toClientEvents(ctx, room.roomID, events)
+	}
+
+	// For an initial sync, the required state comes from the current state
+	// of the room. Otherwise only state changes in the timeline are sent.
+	var stateEvents []*rstypes.HeaderedEvent
+	if initial {
+		var err error
+		stateFilter := synctypes.DefaultStateFilter()
+		stateEvents, err = snapshot.CurrentState(ctx, room.roomID, &stateFilter, nil)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot.CurrentState: %w", err)
+		}
+	} else {
+		for _, ev := range events {
+			if ev.StateKey() != nil {
+				stateEvents = append(stateEvents, ev)
+			}
+		}
+	}
+	requiredState := filterRequiredState(stateEvents, cfg.RequiredState, userID, events)
+	result.RequiredState = rp.toClientEvents(ctx, room.roomID, requiredState)
+	for _, ev := range stateEvents {
+		if ev.Type() == spec.MRoomName && ev.StateKeyEquals("") {
+			result.Name = gjson.GetBytes(ev.Content(), "name").Str
+		}
+	}
+
+	summary, err := snapshot.GetRoomSummary(ctx, room.roomID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot.GetRoomSummary: %w", err)
+	}
+	if summary.JoinedMemberCount != nil {
+		result.JoinedCount = *summary.JoinedMemberCount
+	}
+	if summary.InvitedMemberCount != nil {
+		result.InvitedCount = *summary.InvitedMemberCount
+	}
+	return result, nil
+}
+
+// filterRequiredState returns the state events matching the required_state
+// tuples. "*" matches any type or state key, "$ME" matches the user and
+// "$LAZY" matches the senders of the timeline events.
+func filterRequiredState(
+	stateEvents []*rstypes.HeaderedEvent, requiredState [][2]string,
+	userID string, timeline []*rstypes.HeaderedEvent,
+) []*rstypes.HeaderedEvent {
+	if len(requiredState) == 0 {
+		return nil
+	}
+	lazyMembers := map[string]struct{}{}
+	for _, ev := range timeline {
+		lazyMembers[string(ev.SenderID())] = struct{}{}
+	}
+	var filtered []*rstypes.HeaderedEvent
+	for _, ev := range stateEvents {
+		stateKey := *ev.StateKey()
+		for _, required := range requiredState {
+			if required[0] != "*" && required[0] != ev.Type() {
+				continue
+			}
+			match := false
+			switch required[1] {
+			case "*":
+				match = true
+			case "$ME":
+				match = stateKey == userID
+			case "$LAZY":
+				_, match = lazyMembers[stateKey]
+			default:
+				match = stateKey == required[1]
+			}
+			if match {
+				filtered = append(filtered, ev)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+func (rp *RequestPool) toClientEvents(ctx context.Context, roomID string, events []*rstypes.HeaderedEvent) []synctypes.ClientEvent {
+	return synctypes.ToClientEvents(gomatrixserverlib.ToPDUs(events), synctypes.FormatSync, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+		return rp.rsAPI.QueryUserIDForSender(ctx, roomID, senderID)
+	})
+}
+
+// slidingNotificationCounts decorates the joined rooms in the response with
+// their unread notification counts.
+func (rp *RequestPool) slidingNotificationCounts(
+	ctx context.Context, snapshot storage.DatabaseTransaction, userID string, res *types.SlidingSyncResponse,
+) error {
+	if len(res.Rooms) == 0 {
+		return nil
+	}
+	rooms := make(map[string]string, len(res.Rooms))
+	for roomID := range res.Rooms {
+		rooms[roomID] = spec.Join
+	}
+	counts, err := snapshot.GetUserUnreadNotificationCountsForRooms(ctx, userID, rooms)
+	if err != nil {
+		return fmt.Errorf("snapshot.GetUserUnreadNotificationCountsForRooms: %w", err)
+	}
+	for roomID, roomCounts := range counts {
+		if result, ok := res.Rooms[roomID]; ok && roomCounts != nil {
+			result.NotificationCount = roomCounts.UnreadNotificationCount
+			result.HighlightCount = roomCounts.UnreadHighlightCount
+		}
+	}
+	return nil
+}
+
+// slidingExtensions runs the stream providers for the enabled extensions and
+// moves their results into the sliding sync response.
+// nolint:gocyclo
+func (rp *RequestPool) slidingExtensions(
+	ctx context.Context, snapshot storage.DatabaseTransaction, syncReq *types.SyncRequest,
+	ssReq *types.SlidingSyncRequest, res *types.SlidingSyncResponse,
+	since types.StreamingToken, toDeviceSince types.StreamPosition,
+	currentPos types.StreamingToken, newPos *types.StreamingToken,
+) {
+	ext := ssReq.Extensions
+	// Device list tracking depends on seeing our own membership changes, so
+	// put the rooms we're sending into the /sync response the providers use.
+	for roomID, room := range res.Rooms {
+		if syncReq.Rooms[roomID] == spec.Leave {
+			syncReq.Response.Rooms.Leave[roomID] = types.NewLeaveResponse()
+			continue
+		}
+		jr := types.NewJoinResponse()
+		jr.Timeline.Events = room.Timeline
+		syncReq.Response.Rooms.Join[roomID] = jr
+	}
+
+	if ext.E2EE.IsEnabled() {
+		if since.IsEmpty() {
+			newPos.DeviceListPosition = rp.streams.DeviceListStreamProvider.CompleteSync(ctx, snapshot, syncReq)
+			if err := internal.DeviceOTKCounts(ctx, rp.userAPI, syncReq.Device.UserID, syncReq.Device.ID, syncReq.Response); err != nil {
+				syncReq.Log.WithError(err).Warn("failed to get OTK counts")
+			}
+		} else {
+			newPos.DeviceListPosition = rp.streams.DeviceListStreamProvider.IncrementalSync(
+				ctx, snapshot, syncReq, since.DeviceListPosition, currentPos.DeviceListPosition,
+			)
+		}
+		res.Extensions.E2EE = &types.SlidingE2EEResponse{
+			DeviceLists:                  syncReq.Response.DeviceLists,
+			DeviceOneTimeKeysCount:       syncReq.Response.DeviceListsOTKCount,
+			DeviceUnusedFallbackKeyTypes: []string{},
+		}
+	}
+
+	if ext.ToDevice.IsEnabled() {
+		newPos.SendToDevicePosition = rp.streams.SendToDeviceStreamProvider.IncrementalSync(
+			ctx, snapshot, syncReq, toDeviceSince, currentPos.SendToDevicePosition,
+		)
+		res.Extensions.ToDevice = &types.SlidingToDeviceResponse{
+			NextBatch: strconv.FormatInt(int64(newPos.SendToDevicePosition), 10),
+			Events:    syncReq.Response.ToDevice.Events,
+		}
+		if res.Extensions.ToDevice.Events == nil {
+			res.Extensions.ToDevice.Events = []gomatrixserverlib.SendToDeviceEvent{}
+		}
+	}
+
+	// The remaining providers add to the joined rooms, so forget about the
+	// timelines we put there above.
+	for roomID := range syncReq.Response.Rooms.Join {
+		syncReq.Response.Rooms.Join[roomID] = types.NewJoinResponse()
+	}
+
+	if ext.AccountData.IsEnabled() {
+		filter := synctypes.DefaultEventFilter()
+		if since.IsEmpty() {
+			filter.Limit = math.MaxInt32
+		}
+		syncReq.Filter.AccountData = filter
+		newPos.AccountDataPosition = rp.streams.AccountDataStreamProvider.IncrementalSync(
+			ctx, snapshot, syncReq, since.AccountDataPosition, currentPos.AccountDataPosition,
+		)
+		accountData := &types.SlidingAccountDataResponse{
+			Global: syncReq.Response.AccountData.Events,
+			Rooms:  map[string][]synctypes.ClientEvent{},
+		}
+		for roomID, jr := range syncReq.Response.Rooms.Join {
+			if len(jr.AccountData.Events) > 0 {
+				accountData.Rooms[roomID] = jr.AccountData.Events
+			}
+		}
+		res.Extensions.AccountData = accountData
+	}
+
+	if ext.Receipts.IsEnabled() {
+		newPos.ReceiptPosition = rp.streams.ReceiptStreamProvider.IncrementalSync(
+			ctx, snapshot, syncReq, since.ReceiptPosition, currentPos.ReceiptPosition,
+		)
+		res.Extensions.Receipts = ephemeralByRoom(syncReq.Response, spec.MReceipt)
+	}
+
+	if ext.Typing.IsEnabled() {
+		newPos.TypingPosition = rp.streams.TypingStreamProvider.IncrementalSync(
+			ctx, snapshot, syncReq, since.TypingPosition, currentPos.TypingPosition,
+		)
+		res.Extensions.Typing = ephemeralByRoom(syncReq.Response, spec.MTyping)
+	}
+}
+
+// ephemeralByRoom collects the ephemeral events of the given type, which the
+// stream providers have added to the joined rooms of the response.
+func ephemeralByRoom(res *types.Response, eventType string) *types.SlidingRoomEventsResponse {
+	result := &types.SlidingRoomEventsResponse{
+		Rooms: map[string]synctypes.ClientEvent{},
+	}
+	for roomID, jr := range res.Rooms.Join {
+		for _, ev := range jr.Ephemeral.Events {
+			if ev.Type == eventType {
+				result.Rooms[roomID] = ev
+			}
+		}
+	}
+	return result
+}
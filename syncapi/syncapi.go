@@ -36,6 +36,7 @@ import (
 	"github.com/matrix-org/dendrite/syncapi/producers"
 	"github.com/matrix-org/dendrite/syncapi/routing"
 	"github.com/matrix-org/dendrite/syncapi/storage"
+	"github.com/matrix-org/dendrite/syncapi/storage/mrd"
 	"github.com/matrix-org/dendrite/syncapi/streams"
 	"github.com/matrix-org/dendrite/syncapi/sync"
 	"github.com/matrix-org/dendrite/syncapi/types"
@@ -69,6 +70,19 @@ func AddPublicRoutes(
 				logrus.WithError(err).Error("failed to expire multiroom visibility")
 			}
 			logrus.WithField("rows", affected).Info("expired multiroom visibility")
+			for _, dataType := range dendriteCfg.SyncAPI.MultiRoom.DataTypes {
+				if dataType.HistoryMaxAge <= 0 {
+					continue
+				}
+				affected, err = mrq.DeleteMultiRoomHistoryByTS(context.Background(), mrd.DeleteMultiRoomHistoryByTSParams{
+					Type: dataType.Type,
+					Ts:   time.Now().Add(-dataType.HistoryMaxAge).UnixMilli(),
+				})
+				if err != nil {
+					logrus.WithError(err).WithField("type", dataType.Type).Error("failed to expire multiroom history")
+				}
+				logrus.WithField("rows", affected).WithField("type", dataType.Type).Info("expired multiroom history")
+			}
 			time.Sleep(time.Minute)
 		}
 	}()
@@ -165,8 +179,20 @@ func AddPublicRoutes(
 		logrus.WithError(err).Panicf("failed to start receipts consumer")
 	}
 
+	accountDeactivationConsumer := consumers.NewOutputAccountDeactivationConsumer(
+		processContext, &dendriteCfg.SyncAPI, js, syncDB,
+	)
+	if err = accountDeactivationConsumer.Start(); err != nil {
+		logrus.WithError(err).Panicf("failed to start account deactivation consumer")
+	}
+
+	federationMultiRoomProducer := &producers.FederationAPIMultiRoomProducer{
+		Topic:     dendriteCfg.Global.JetStream.Prefixed(jetstream.OutputMultiRoomFederation),
+		JetStream: js,
+	}
 	multiRoomConsumer := consumers.NewOutputMultiRoomDataConsumer(
-		processContext, &dendriteCfg.SyncAPI, js, mrq, notifier, streams.MultiRoomStreamProvider,
+		processContext, &dendriteCfg.SyncAPI, js, mrq, syncDB, rsAPI, notifier, streams.MultiRoomStreamProvider,
+		federationMultiRoomProducer,
 	)
 	if err = multiRoomConsumer.Start(); err != nil {
 		logrus.WithError(err).Panicf("failed to start multiroom consumer")
@@ -174,7 +200,7 @@ func AddPublicRoutes(
 	rateLimits := httputil.NewRateLimits(&dendriteCfg.ClientAPI.RateLimiting)
 
 	routing.Setup(
-		routers.Client, requestPool, syncDB, userAPI,
+		routers.Client, routers.DendriteAdmin, requestPool, syncDB, userAPI,
 		rsAPI, &dendriteCfg.SyncAPI, caches, fts,
 		rateLimits,
 	)
@@ -1239,6 +1239,181 @@ func testContext(t *testing.T, dbType test.DBType) {
 	}
 }
 
+func TestSlidingSync(t *testing.T) {
+	test.WithPostgresAndSQLite(t, testSlidingSync)
+}
+
+func testSlidingSync(t *testing.T, dbType test.DBType) {
+	user := test.NewUser(t)
+	alice := userapi.Device{
+		ID:          "ALICEID",
+		UserID:      user.ID,
+		AccessToken: "ALICE_BEARER_TOKEN",
+		DisplayName: "Alice",
+		AccountType: userapi.AccountTypeUser,
+	}
+
+	cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+	routers := httputil.NewRouters()
+	cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+	caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+	defer close()
+
+	natsInstance := jetstream.NATSInstance{}
+	rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+	rsAPI.SetFederationAPI(nil, nil)
+
+	AddPublicRoutes(processCtx, routers, cfg, cm, &natsInstance, &syncUserAPI{accounts: []userapi.Device{alice}}, rsAPI, caches, caching.DisableMetrics)
+
+	jsctx, _ := natsInstance.Prepare(processCtx, &cfg.Global.JetStream)
+	defer jetstream.DeleteAllStreams(jsctx, &cfg.Global.JetStream)
+
+	room1 := test.NewRoom(t, user)
+	room2 := test.NewRoom(t, user)
+	for _, room := range []*test.Room{room1, room2} {
+		if err := api.SendEvents(context.Background(), rsAPI, api.KindNew, room.Events(), "test", "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+	}
+	lastEvent := room2.Events()[len(room2.Events())-1]
+	syncUntil(t, routers, alice.AccessToken, false, func(syncBody string) bool {
+		path := fmt.Sprintf(`rooms.join.%s.timeline.events.#(event_id=="%s")`, room2.ID, lastEvent.EventID())
+		return gjson.Get(syncBody, path).Exists()
+	})
+
+	slidingSync := func(pos string, wantCode int) gjson.Result {
+		t.Helper()
+		w := httptest.NewRecorder()
+		routers.Client.ServeHTTP(w, test.NewRequest(t, "POST", "/_matrix/client/unstable/org.matrix.simplified_msc3575/sync",
+			test.WithQueryParams(map[string]string{
+				"access_token": alice.AccessToken,
+				"timeout":      "0",
+				"pos":          pos,
+			}),
+			test.WithJSONBody(t, map[string]interface{}{
+				"lists": map[string]interface{}{
+					"all": map[string]interface{}{
+						"ranges":         [][2]int{{0, 0}},
+						"timeline_limit": 1,
+						"required_state": [][2]string{{spec.MRoomCreate, ""}},
+					},
+				},
+			}),
+		))
+		if w.Code != wantCode {
+			t.Fatalf("got HTTP %d want %d: %s", w.Code, wantCode, w.Body.String())
+		}
+		return gjson.ParseBytes(w.Body.Bytes())
+	}
+
+	// The initial request only returns the most recent room.
+	res := slidingSync("", http.StatusOK)
+	if count := res.Get("lists.all.count").Int(); count != 2 {
+		t.Fatalf("expected 2 rooms in the list, got %d", count)
+	}
+	if !res.Get("rooms." + gjson.Escape(room2.ID)).Exists() {
+		t.Fatalf("expected room %s in the response: %s", room2.ID, res.Raw)
+	}
+	if res.Get("rooms." + gjson.Escape(room1.ID)).Exists() {
+		t.Fatalf("did not expect room %s in the response", room1.ID)
+	}
+	room := res.Get("rooms." + gjson.Escape(room2.ID))
+	if !room.Get("initial").Bool() {
+		t.Fatalf("expected the room to be initial")
+	}
+	if got := room.Get("timeline.0.event_id").Str; got != lastEvent.EventID() {
+		t.Fatalf("expected timeline event %s, got %s", lastEvent.EventID(), got)
+	}
+	if got := room.Get("required_state.0.type").Str; got != spec.MRoomCreate {
+		t.Fatalf("expected required state %s, got %s", spec.MRoomCreate, got)
+	}
+	pos := res.Get("pos").Str
+
+	// Nothing has changed, so nothing is returned.
+	res = slidingSync(pos, http.StatusOK)
+	if n := len(res.Get("rooms").Map()); n != 0 {
+		t.Fatalf("expected no rooms, got %d", n)
+	}
+	pos = res.Get("pos").Str
+
+	// A new message in the other room moves it to the top of the list.
+	msg := room1.CreateAndInsert(t, user, "m.room.message", map[string]interface{}{"body": "hello"})
+	if err := api.SendEvents(context.Background(), rsAPI, api.KindNew, []*rstypes.HeaderedEvent{msg}, "test", "test", "test", nil, false); err != nil {
+		t.Fatalf("failed to send events: %v", err)
+	}
+	syncUntil(t, routers, alice.AccessToken, false, func(syncBody string) bool {
+		path := fmt.Sprintf(`rooms.join.%s.timeline.events.#(event_id=="%s")`, room1.ID, msg.EventID())
+		return gjson.Get(syncBody, path).Exists()
+	})
+	res = slidingSync(pos, http.StatusOK)
+	room = res.Get("rooms." + gjson.Escape(room1.ID))
+	if !room.Exists() {
+		t.Fatalf("expected room %s in the response: %s", room1.ID, res.Raw)
+	}
+	if got := room.Get("timeline.0.event_id").Str; got != msg.EventID() {
+		t.Fatalf("expected timeline event %s, got %s", msg.EventID(), got)
+	}
+
+	// An unknown pos makes the client start again.
+	res = slidingSync("s1000_0_0_0_0_0_0_0_0_0", http.StatusBadRequest)
+	if errcode := res.Get("errcode").Str; errcode != "M_UNKNOWN_POS" {
+		t.Fatalf("expected M_UNKNOWN_POS, got %s", errcode)
+	}
+
+	// To-device messages are only sent, and deleted, on the connection which
+	// enables the to_device extension, like the encryption connection of Element X.
+	toDeviceSync := func(pos, since string) gjson.Result {
+		t.Helper()
+		w := httptest.NewRecorder()
+		routers.Client.ServeHTTP(w, test.NewRequest(t, "POST", "/_matrix/client/unstable/org.matrix.simplified_msc3575/sync",
+			test.WithQueryParams(map[string]string{
+				"access_token": alice.AccessToken,
+				"timeout":      "0",
+				"pos":          pos,
+			}),
+			test.WithJSONBody(t, map[string]interface{}{
+				"conn_id": "encryption",
+				"extensions": map[string]interface{}{
+					"to_device": map[string]interface{}{"enabled": true, "since": since},
+				},
+			}),
+		))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got HTTP %d want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		return gjson.ParseBytes(w.Body.Bytes())
+	}
+	producer := producers.SyncAPIProducer{
+		TopicSendToDeviceEvent: cfg.Global.JetStream.Prefixed(jetstream.OutputSendToDeviceEvent),
+		JetStream:              jsctx,
+	}
+	if err := producer.SendToDevice(context.Background(), user.ID, user.ID, alice.ID, "m.dendrite.test", json.RawMessage(`{"dummy":"message"}`)); err != nil {
+		t.Fatalf("unable to send to device message: %v", err)
+	}
+	// wait for the message to reach the notifier, which sliding sync takes its positions from
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if toDeviceSync("", "").Get("extensions.to_device.events.#").Int() > 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the to-device message")
+		}
+	}
+	res = slidingSync("", http.StatusOK)
+	res = slidingSync(res.Get("pos").Str, http.StatusOK)
+	if res.Get("extensions.to_device").Exists() {
+		t.Fatalf("did not expect to-device messages without the extension: %s", res.Raw)
+	}
+	res = toDeviceSync("", "")
+	if got := res.Get("extensions.to_device.events.#.content.dummy").Array(); len(got) != 1 || got[0].Str != "message" {
+		t.Fatalf("expected the to-device message, got %s", res.Raw)
+	}
+	nextBatch := res.Get("extensions.to_device.next_batch").Str
+	res = toDeviceSync(res.Get("pos").Str, nextBatch)
+	if n := len(res.Get("extensions.to_device.events").Array()); n != 0 {
+		t.Fatalf("expected acknowledged to-device messages to be deleted, got %s", res.Raw)
+	}
+}
+
 func TestUpdateRelations(t *testing.T) {
 	testCases := []struct {
 		name         string
@@ -21,11 +21,12 @@ import (
 // Filter is used by clients to specify how the server should filter responses to e.g. sync requests
 // Specified by: https://spec.matrix.org/v1.6/client-server-api/#filtering
 type Filter struct {
-	EventFields []string    `json:"event_fields,omitempty"`
-	EventFormat string      `json:"event_format,omitempty"`
-	Presence    EventFilter `json:"presence,omitempty"`
-	AccountData EventFilter `json:"account_data,omitempty"`
-	Room        RoomFilter  `json:"room,omitempty"`
+	EventFields []string        `json:"event_fields,omitempty"`
+	EventFormat string          `json:"event_format,omitempty"`
+	Presence    EventFilter     `json:"presence,omitempty"`
+	AccountData EventFilter     `json:"account_data,omitempty"`
+	Room        RoomFilter      `json:"room,omitempty"`
+	MultiRoom   MultiRoomFilter `json:"multiroom,omitempty"`
 }
 
 // EventFilter is used to define filtering rules for events
@@ -37,6 +38,19 @@ type EventFilter struct {
 	Types      *[]string `json:"types,omitempty"`
 }
 
+// MultiRoomFilter is used to define filtering rules for multiroom data. Types and senders
+// match the data type and user ID of the data, rooms restrict the data to users visible
+// in those rooms. A limit of zero returns all data.
+type MultiRoomFilter struct {
+	Limit      int       `json:"limit,omitempty"`
+	NotSenders *[]string `json:"not_senders,omitempty"`
+	NotTypes   *[]string `json:"not_types,omitempty"`
+	Senders    *[]string `json:"senders,omitempty"`
+	Types      *[]string `json:"types,omitempty"`
+	NotRooms   *[]string `json:"not_rooms,omitempty"`
+	Rooms      *[]string `json:"rooms,omitempty"`
+}
+
 // RoomFilter is used to define filtering rules for room-related events
 type RoomFilter struct {
 	NotRooms     *[]string       `json:"not_rooms,omitempty"`
@@ -88,6 +102,9 @@ func (filter *Filter) Validate() error {
 	if filter.EventFormat != "" && filter.EventFormat != EventFormatClient && filter.EventFormat != EventFormatFederation {
 		return errors.New("Bad event_format value. Must be one of [\"client\", \"federation\"]")
 	}
+	if filter.MultiRoom.Limit < 0 {
+		return errors.New("Bad multiroom.limit value. Must not be negative")
+	}
 	return nil
 }
 
@@ -108,6 +125,7 @@ func DefaultFilter() Filter {
 			State:        DefaultStateFilter(),
 			Timeline:     DefaultRoomEventFilter(),
 		},
+		MultiRoom: MultiRoomFilter{},
 	}
 }
 
@@ -0,0 +1,141 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MGeofence is the state event defining a geofence in a room, the state key is the geofence ID.
+	MGeofence = "connect.geofence"
+	// MGeofenceEnter is sent into the room when a user moves into a geofence.
+	MGeofenceEnter = "connect.geofence.enter"
+	// MGeofenceExit is sent into the room when a user moves out of a geofence.
+	MGeofenceExit = "connect.geofence.exit"
+)
+
+const earthRadiusMeters = 6371008.8
+
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// ParseGeoPoint extracts the position of a location payload. The position is either
+// a geo URI (RFC 5870) in "geo_uri", or numeric "latitude" and "longitude" fields.
+func ParseGeoPoint(content []byte) (GeoPoint, bool) {
+	var payload struct {
+		GeoURI    string   `json:"geo_uri"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+	}
+	if err := json.Unmarshal(content, &payload); err != nil {
+		return GeoPoint{}, false
+	}
+	var p GeoPoint
+	switch {
+	case payload.GeoURI != "":
+		coords, ok := strings.CutPrefix(payload.GeoURI, "geo:")
+		if !ok {
+			return GeoPoint{}, false
+		}
+		// Drop the parameters, e.g. ";u=35", and the altitude if any.
+		coords, _, _ = strings.Cut(coords, ";")
+		parts := strings.Split(coords, ",")
+		if len(parts) < 2 {
+			return GeoPoint{}, false
+		}
+		var err error
+		if p.Latitude, err = strconv.ParseFloat(parts[0], 64); err != nil {
+			return GeoPoint{}, false
+		}
+		if p.Longitude, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			return GeoPoint{}, false
+		}
+	case payload.Latitude != nil && payload.Longitude != nil:
+		p.Latitude, p.Longitude = *payload.Latitude, *payload.Longitude
+	default:
+		return GeoPoint{}, false
+	}
+	if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+		return GeoPoint{}, false
+	}
+	return p, true
+}
+
+// Geofence is the content of a connect.geofence state event. A geofence is either a
+// circle, given by its center and radius in meters, or a polygon of at least 3 points.
+type Geofence struct {
+	Name    string     `json:"name,omitempty"`
+	Center  *GeoPoint  `json:"center,omitempty"`
+	Radius  float64    `json:"radius,omitempty"`
+	Polygon []GeoPoint `json:"polygon,omitempty"`
+}
+
+// Valid returns whether the geofence describes an area. Geofences are removed from
+// a room by replacing the state event with empty content, which isn't valid.
+func (g *Geofence) Valid() bool {
+	if g.Center != nil {
+		return g.Radius > 0
+	}
+	return len(g.Polygon) >= 3
+}
+
+// Contains returns whether the point lies within the geofence.
+func (g *Geofence) Contains(p GeoPoint) bool {
+	if g.Center != nil {
+		return distanceMeters(*g.Center, p) <= g.Radius
+	}
+	// Ray casting, geofences are assumed to be small enough to treat
+	// latitude and longitude as planar coordinates.
+	inside := false
+	for i, j := 0, len(g.Polygon)-1; i < len(g.Polygon); j, i = i, i+1 {
+		a, b := g.Polygon[i], g.Polygon[j]
+		if (a.Latitude > p.Latitude) != (b.Latitude > p.Latitude) &&
+			p.Longitude < (b.Longitude-a.Longitude)*(p.Latitude-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Crossed returns the event type to send when a user moves from the previous to the
+// current location, or "" if the geofence wasn't crossed. A user without a previous
+// location is outside of every geofence, so the first location can enter one.
+func (g *Geofence) Crossed(previous *GeoPoint, current GeoPoint) string {
+	wasInside := previous != nil && g.Contains(*previous)
+	isInside := g.Contains(current)
+	switch {
+	case !wasInside && isInside:
+		return MGeofenceEnter
+	case wasInside && !isInside:
+		return MGeofenceExit
+	default:
+		return ""
+	}
+}
+
+// distanceMeters returns the great-circle distance between two points.
+func distanceMeters(a, b GeoPoint) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
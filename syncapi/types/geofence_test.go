@@ -0,0 +1,76 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParseGeoPoint(t *testing.T) {
+	tests := []struct {
+		content string
+		want    GeoPoint
+		wantOK  bool
+	}{
+		{content: `{"geo_uri":"geo:51.5008,0.1247;u=35"}`, want: GeoPoint{51.5008, 0.1247}, wantOK: true},
+		{content: `{"geo_uri":"geo:-33.8688,151.2093,10"}`, want: GeoPoint{-33.8688, 151.2093}, wantOK: true},
+		{content: `{"latitude":48.8584,"longitude":2.2945}`, want: GeoPoint{48.8584, 2.2945}, wantOK: true},
+		{content: `{"geo_uri":"51.5008,0.1247"}`},
+		{content: `{"geo_uri":"geo:91,0"}`},
+		{content: `{"latitude":48.8584}`},
+		{content: `{"foo":"bar"}`},
+		{content: `not json`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.content, func(t *testing.T) {
+			is := is.New(t)
+			p, ok := ParseGeoPoint([]byte(tc.content))
+			is.Equal(ok, tc.wantOK)
+			is.Equal(p, tc.want)
+		})
+	}
+}
+
+func TestGeofenceContains(t *testing.T) {
+	is := is.New(t)
+
+	circle := Geofence{Center: &GeoPoint{51.5008, 0.1247}, Radius: 100}
+	is.True(circle.Valid())
+	is.True(circle.Contains(GeoPoint{51.5008, 0.1247}))
+	is.True(circle.Contains(GeoPoint{51.5015, 0.1247}))  // ~78m north
+	is.True(!circle.Contains(GeoPoint{51.5020, 0.1247})) // ~133m north
+
+	square := Geofence{Polygon: []GeoPoint{{0, 0}, {0, 1}, {1, 1}, {1, 0}}}
+	is.True(square.Valid())
+	is.True(square.Contains(GeoPoint{0.5, 0.5}))
+	is.True(!square.Contains(GeoPoint{1.5, 0.5}))
+	is.True(!square.Contains(GeoPoint{0.5, -0.5}))
+
+	is.True(!(&Geofence{Center: &GeoPoint{0, 0}}).Valid())
+	is.True(!(&Geofence{Polygon: []GeoPoint{{0, 0}, {1, 1}}}).Valid())
+	is.True(!(&Geofence{}).Valid())
+}
+
+func TestGeofenceCrossed(t *testing.T) {
+	square := Geofence{Polygon: []GeoPoint{{0, 0}, {0, 1}, {1, 1}, {1, 0}}}
+	inside, outside := GeoPoint{0.5, 0.5}, GeoPoint{1.5, 0.5}
+	tests := []struct {
+		name     string
+		previous *GeoPoint
+		current  GeoPoint
+		want     string
+	}{
+		{name: "first location inside", current: inside, want: MGeofenceEnter},
+		{name: "first location outside", current: outside},
+		{name: "moved in", previous: &outside, current: inside, want: MGeofenceEnter},
+		{name: "moved out", previous: &inside, current: outside, want: MGeofenceExit},
+		{name: "stayed inside", previous: &inside, current: inside},
+		{name: "stayed outside", previous: &outside, current: outside},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(square.Crossed(tc.previous, tc.current), tc.want)
+		})
+	}
+}
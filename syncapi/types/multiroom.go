@@ -2,6 +2,9 @@ package types
 
 type MultiRoom map[string]map[string]MultiRoomData
 
+// MultiRoomHistory maps user ID -> data type -> payloads in chronological order.
+type MultiRoomHistory map[string]map[string][]MultiRoomData
+
 type MultiRoomContent []byte
 
 type MultiRoomData struct {
@@ -14,8 +17,41 @@ func (d MultiRoomContent) MarshalJSON() ([]byte, error) {
 }
 
 type MultiRoomDataRow struct {
+	ID        int64
 	Data      []byte
 	Type      string
 	UserId    string
 	Timestamp int64
 }
+
+// MultiRoomHistoryFilter selects a page of multiroom history. Rows are ordered by
+// timestamp and ID, and the page starts strictly after the (FromTs, FromID) position.
+type MultiRoomHistoryFilter struct {
+	UserID string
+	FromTs int64
+	FromID int64
+	ToTs   int64
+	Limit  int
+}
+
+// MultiRoomVisibility is a room the data type of a user is visible in, until ExpireTs.
+// The type is the data type, without the ".visibility" suffix of the visibility rows.
+type MultiRoomVisibility struct {
+	Type     string `json:"type"`
+	RoomID   string `json:"room_id"`
+	ExpireTs int64  `json:"expire_ts"`
+}
+
+// MultiRoomUserData is all multiroom data stored for a user.
+type MultiRoomUserData struct {
+	Data       []*MultiRoomDataRow
+	Visibility []MultiRoomVisibility
+	History    []*MultiRoomDataRow
+}
+
+// MultiRoomPurgeResult is the number of rows deleted from each multiroom table.
+type MultiRoomPurgeResult struct {
+	Data       int64 `json:"data"`
+	Visibility int64 `json:"visibility"`
+	History    int64 `json:"history"`
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+
+	"github.com/matrix-org/gomatrixserverlib"
+
+	"github.com/matrix-org/dendrite/syncapi/synctypes"
+)
+
+// SlidingSyncRequest is the body of a simplified sliding sync (MSC4186) request.
+type SlidingSyncRequest struct {
+	ConnID            string                       `json:"conn_id,omitempty"`
+	Lists             map[string]SlidingListConfig `json:"lists,omitempty"`
+	RoomSubscriptions map[string]SlidingRoomConfig `json:"room_subscriptions,omitempty"`
+	Extensions        SlidingSyncExtensionsRequest `json:"extensions"`
+}
+
+// SlidingRoomConfig describes which data the client wants for a room.
+type SlidingRoomConfig struct {
+	RequiredState [][2]string `json:"required_state,omitempty"`
+	TimelineLimit int         `json:"timeline_limit,omitempty"`
+}
+
+// SlidingListConfig describes a sorted, windowed list of rooms.
+type SlidingListConfig struct {
+	SlidingRoomConfig
+	Ranges  [][2]int           `json:"ranges,omitempty"`
+	Filters *SlidingListFilter `json:"filters,omitempty"`
+}
+
+// SlidingListFilter restricts which rooms are part of a list.
+type SlidingListFilter struct {
+	IsDM     *bool `json:"is_dm,omitempty"`
+	IsInvite *bool `json:"is_invite,omitempty"`
+}
+
+// SlidingExtensionRequest is the common part of every extension request.
+type SlidingExtensionRequest struct {
+	Enabled *bool `json:"enabled,omitempty"`
+	// The next_batch of the previous response, for extensions which have their
+	// own position, i.e. to_device.
+	Since string `json:"since,omitempty"`
+}
+
+// IsEnabled returns whether the client asked for this extension.
+func (r *SlidingExtensionRequest) IsEnabled() bool {
+	return r != nil && r.Enabled != nil && *r.Enabled
+}
+
+type SlidingSyncExtensionsRequest struct {
+	E2EE        *SlidingExtensionRequest `json:"e2ee,omitempty"`
+	ToDevice    *SlidingExtensionRequest `json:"to_device,omitempty"`
+	AccountData *SlidingExtensionRequest `json:"account_data,omitempty"`
+	Receipts    *SlidingExtensionRequest `json:"receipts,omitempty"`
+	Typing      *SlidingExtensionRequest `json:"typing,omitempty"`
+}
+
+// SlidingSyncResponse is the response to a simplified sliding sync request.
+type SlidingSyncResponse struct {
+	Pos        string                        `json:"pos"`
+	Lists      map[string]SlidingListResult  `json:"lists"`
+	Rooms      map[string]*SlidingRoomResult `json:"rooms"`
+	Extensions SlidingSyncExtensionsResponse `json:"extensions"`
+}
+
+// NewSlidingSyncResponse creates an empty response.
+func NewSlidingSyncResponse() *SlidingSyncResponse {
+	return &SlidingSyncResponse{
+		Lists: map[string]SlidingListResult{},
+		Rooms: map[string]*SlidingRoomResult{},
+	}
+}
+
+// HasUpdates returns whether the response carries anything for the client.
+func (r *SlidingSyncResponse) HasUpdates() bool {
+	return len(r.Rooms) > 0 || r.Extensions.HasUpdates()
+}
+
+type SlidingListResult struct {
+	Count int `json:"count"`
+}
+
+type SlidingRoomResult struct {
+	Name              string                  `json:"name,omitempty"`
+	Initial           bool                    `json:"initial,omitempty"`
+	RequiredState     []synctypes.ClientEvent `json:"required_state,omitempty"`
+	InviteState       []json.RawMessage       `json:"invite_state,omitempty"`
+	Timeline          []synctypes.ClientEvent `json:"timeline,omitempty"`
+	PrevBatch         string                  `json:"prev_batch,omitempty"`
+	Limited           bool                    `json:"limited,omitempty"`
+	NumLive           int                     `json:"num_live,omitempty"`
+	BumpStamp         StreamPosition          `json:"bump_stamp,omitempty"`
+	JoinedCount       int                     `json:"joined_count"`
+	InvitedCount      int                     `json:"invited_count"`
+	NotificationCount int                     `json:"notification_count"`
+	HighlightCount    int                     `json:"highlight_count"`
+}
+
+type SlidingSyncExtensionsResponse struct {
+	E2EE        *SlidingE2EEResponse        `json:"e2ee,omitempty"`
+	ToDevice    *SlidingToDeviceResponse    `json:"to_device,omitempty"`
+	AccountData *SlidingAccountDataResponse `json:"account_data,omitempty"`
+	Receipts    *SlidingRoomEventsResponse  `json:"receipts,omitempty"`
+	Typing      *SlidingRoomEventsResponse  `json:"typing,omitempty"`
+}
+
+// HasUpdates returns whether any of the extensions carry data which isn't
+// sent on every response anyway.
+func (r SlidingSyncExtensionsResponse) HasUpdates() bool {
+	if r.E2EE != nil && r.E2EE.DeviceLists != nil &&
+		(len(r.E2EE.DeviceLists.Changed) > 0 || len(r.E2EE.DeviceLists.Left) > 0) {
+		return true
+	}
+	if r.ToDevice != nil && len(r.ToDevice.Events) > 0 {
+		return true
+	}
+	if r.AccountData != nil && (len(r.AccountData.Global) > 0 || len(r.AccountData.Rooms) > 0) {
+		return true
+	}
+	if r.Receipts != nil && len(r.Receipts.Rooms) > 0 {
+		return true
+	}
+	return r.Typing != nil && len(r.Typing.Rooms) > 0
+}
+
+type SlidingE2EEResponse struct {
+	DeviceLists                  *DeviceLists   `json:"device_lists,omitempty"`
+	DeviceOneTimeKeysCount       map[string]int `json:"device_one_time_keys_count,omitempty"`
+	DeviceUnusedFallbackKeyTypes []string       `json:"device_unused_fallback_key_types"`
+}
+
+type SlidingToDeviceResponse struct {
+	NextBatch string                                `json:"next_batch"`
+	Events    []gomatrixserverlib.SendToDeviceEvent `json:"events"`
+}
+
+type SlidingAccountDataResponse struct {
+	Global []synctypes.ClientEvent            `json:"global,omitempty"`
+	Rooms  map[string][]synctypes.ClientEvent `json:"rooms,omitempty"`
+}
+
+// SlidingRoomEventsResponse is used by the receipts and typing extensions,
+// which both carry a single ephemeral event per room.
+type SlidingRoomEventsResponse struct {
+	Rooms map[string]synctypes.ClientEvent `json:"rooms,omitempty"`
+}
@@ -360,6 +360,7 @@ type RoomsResponse struct {
 	Join   map[string]*JoinResponse   `json:"join,omitempty"`
 	Peek   map[string]*JoinResponse   `json:"peek,omitempty"`
 	Invite map[string]*InviteResponse `json:"invite,omitempty"`
+	Knock  map[string]*KnockResponse  `json:"knock,omitempty"`
 	Leave  map[string]*LeaveResponse  `json:"leave,omitempty"`
 }
 
@@ -395,7 +396,8 @@ func (r Response) MarshalJSON() ([]byte, error) {
 	}
 	if r.Rooms != nil {
 		if len(r.Rooms.Join) == 0 && len(r.Rooms.Peek) == 0 &&
-			len(r.Rooms.Invite) == 0 && len(r.Rooms.Leave) == 0 {
+			len(r.Rooms.Invite) == 0 && len(r.Rooms.Knock) == 0 &&
+			len(r.Rooms.Leave) == 0 {
 			a.Rooms = nil
 		}
 	}
@@ -410,6 +412,7 @@ func (r *Response) HasUpdates() bool {
 	return (len(r.AccountData.Events) > 0 ||
 		len(r.Presence.Events) > 0 ||
 		len(r.Rooms.Invite) > 0 ||
+		len(r.Rooms.Knock) > 0 ||
 		len(r.Rooms.Join) > 0 ||
 		len(r.Rooms.Leave) > 0 ||
 		len(r.Rooms.Peek) > 0 ||
@@ -428,6 +431,7 @@ func NewResponse() *Response {
 		Join:   map[string]*JoinResponse{},
 		Peek:   map[string]*JoinResponse{},
 		Invite: map[string]*InviteResponse{},
+		Knock:  map[string]*KnockResponse{},
 		Leave:  map[string]*LeaveResponse{},
 	}
 
@@ -449,6 +453,7 @@ func NewResponse() *Response {
 func (r *Response) IsEmpty() bool {
 	return len(r.Rooms.Join) == 0 &&
 		len(r.Rooms.Invite) == 0 &&
+		len(r.Rooms.Knock) == 0 &&
 		len(r.Rooms.Leave) == 0 &&
 		len(r.AccountData.Events) == 0 &&
 		len(r.Presence.Events) == 0 &&
@@ -484,6 +489,9 @@ type JoinResponse struct {
 	Ephemeral            *ClientEvents `json:"ephemeral,omitempty"`
 	AccountData          *ClientEvents `json:"account_data,omitempty"`
 	*UnreadNotifications `json:"unread_notifications,omitempty"`
+	// UnreadThreadNotifications is only set when the filter enables
+	// unread_thread_notifications, keyed by thread root event ID.
+	UnreadThreadNotifications map[string]*UnreadNotifications `json:"unread_thread_notifications,omitempty"`
 }
 
 func (jr JoinResponse) MarshalJSON() ([]byte, error) {
@@ -521,6 +529,7 @@ func (jr JoinResponse) MarshalJSON() ([]byte, error) {
 		// if everything else is nil, also remove UnreadNotifications
 		if a.State == nil && a.Ephemeral == nil && a.AccountData == nil && a.Timeline == nil && a.Summary == nil {
 			a.UnreadNotifications = nil
+			a.UnreadThreadNotifications = nil
 		}
 	}
 	return json.Marshal(a)
@@ -592,6 +601,39 @@ func NewInviteResponse(ctx context.Context, rsAPI api.QuerySenderIDAPI, event *t
 	return &res, nil
 }
 
+// KnockResponse represents a /sync response for a room which is under the 'knock' key.
+type KnockResponse struct {
+	KnockState struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"knock_state"`
+}
+
+// NewKnockResponse creates a response from the knock event, with the partial room
+// state from the knock_room_state in the unsigned key of the event.
+func NewKnockResponse(ctx context.Context, rsAPI api.QuerySenderIDAPI, event *types.HeaderedEvent, eventFormat synctypes.ClientEventFormat) (*KnockResponse, error) {
+	res := KnockResponse{}
+	res.KnockState.Events = []json.RawMessage{}
+	if knockRoomState := gjson.GetBytes(event.Unsigned(), "knock_room_state"); knockRoomState.Exists() {
+		_ = json.Unmarshal([]byte(knockRoomState.Raw), &res.KnockState.Events)
+	}
+
+	eventNoUnsigned, err := event.SetUnsigned(nil)
+	if err != nil {
+		return nil, err
+	}
+	knockEvent, err := synctypes.ToClientEvent(eventNoUnsigned, eventFormat, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+		return rsAPI.QueryUserIDForSender(ctx, roomID, senderID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	knockEvent.Unsigned = nil
+	if ev, err := json.Marshal(*knockEvent); err == nil {
+		res.KnockState.Events = append(res.KnockState.Events, ev)
+	}
+	return &res, nil
+}
+
 // LeaveResponse represents a /sync response for a room which is under the 'leave' key.
 type LeaveResponse struct {
 	State    *ClientEvents `json:"state,omitempty"`
@@ -644,6 +686,7 @@ type OutputReceiptEvent struct {
 	EventID   string         `json:"event_id"`
 	Type      string         `json:"type"`
 	Timestamp spec.Timestamp `json:"timestamp"`
+	ThreadID  string         `json:"thread_id,omitempty"`
 }
 
 // OutputSendToDeviceEvent is an entry in the send-to-device output kafka log.
@@ -663,3 +706,13 @@ type RelationEntry struct {
 	Position StreamPosition
 	EventID  string
 }
+
+// ThreadEntry describes a thread in a room. The position is that of the
+// latest reply, which is used to order and paginate threads.
+type ThreadEntry struct {
+	Position      StreamPosition
+	RootEventID   string
+	LatestEventID string
+	Count         int
+	Participated  bool
+}
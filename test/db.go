@@ -177,3 +177,18 @@ func WithAllDatabases(t *testing.T, testFn func(t *testing.T, db DBType)) {
 		})
 	}
 }
+
+// Creates subtests for postgres and sqlite, for tests which also cover the sqlite backend
+func WithPostgresAndSQLite(t *testing.T, testFn func(t *testing.T, db DBType)) {
+	dbs := map[string]DBType{
+		"postgres": DBTypePostgres,
+		"sqlite":   DBTypeSQLite,
+	}
+	for dbName, dbType := range dbs {
+		dbt := dbType
+		t.Run(dbName, func(tt *testing.T) {
+			tt.Parallel()
+			testFn(tt, dbt)
+		})
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"strings"
 	"time"
 
@@ -104,11 +105,18 @@ type ClientUserAPI interface {
 	PerformDeviceCreation(ctx context.Context, req *PerformDeviceCreationRequest, res *PerformDeviceCreationResponse) error
 	PerformDeviceUpdate(ctx context.Context, req *PerformDeviceUpdateRequest, res *PerformDeviceUpdateResponse) error
 	PerformDeviceDeletion(ctx context.Context, req *PerformDeviceDeletionRequest, res *PerformDeviceDeletionResponse) error
+	PerformTokenRefresh(ctx context.Context, req *PerformTokenRefreshRequest, res *PerformTokenRefreshResponse) error
 	PerformPasswordUpdate(ctx context.Context, req *PerformPasswordUpdateRequest, res *PerformPasswordUpdateResponse) error
+	PerformAccountTypeUpdate(ctx context.Context, req *PerformAccountTypeUpdateRequest) error
+	// PerformAdminAccountUpdate locks/unlocks and shadow-bans/unbans an account.
+	PerformAdminAccountUpdate(ctx context.Context, req *PerformAdminAccountUpdateRequest) error
+	// QueryAdminAccounts returns the accounts matching the request, along with the total number of matching accounts.
+	QueryAdminAccounts(ctx context.Context, req *QueryAdminAccountsRequest) ([]Account, int64, error)
 	PerformPusherDeletion(ctx context.Context, req *PerformPusherDeletionRequest, res *struct{}) error
 	PerformPusherSet(ctx context.Context, req *PerformPusherSetRequest, res *struct{}) error
 	PerformPushRulesPut(ctx context.Context, userID string, ruleSets *pushrules.AccountRuleSets) error
 	PerformAccountDeactivation(ctx context.Context, req *PerformAccountDeactivationRequest, res *PerformAccountDeactivationResponse) error
+	PerformLDAPSync(ctx context.Context, req *PerformLDAPSyncRequest, res *PerformLDAPSyncResponse) error
 	PerformOpenIDTokenCreation(ctx context.Context, req *PerformOpenIDTokenCreationRequest, res *PerformOpenIDTokenCreationResponse) error
 	QueryNotifications(ctx context.Context, req *QueryNotificationsRequest, res *QueryNotificationsResponse) error
 	InputAccountData(ctx context.Context, req *InputAccountDataRequest, res *InputAccountDataResponse) error
@@ -117,6 +125,9 @@ type ClientUserAPI interface {
 	QueryLocalpartForThreePID(ctx context.Context, req *QueryLocalpartForThreePIDRequest, res *QueryLocalpartForThreePIDResponse) error
 	PerformForgetThreePID(ctx context.Context, req *PerformForgetThreePIDRequest, res *struct{}) error
 	PerformSaveThreePIDAssociation(ctx context.Context, req *PerformSaveThreePIDAssociationRequest, res *struct{}) error
+
+	QueryLocalpartForSSOIdentity(ctx context.Context, req *QueryLocalpartForSSOIdentityRequest, res *QueryLocalpartForSSOIdentityResponse) error
+	PerformSaveSSOIdentityAssociation(ctx context.Context, req *PerformSaveSSOIdentityAssociationRequest, res *struct{}) error
 }
 
 type KeyBackupAPI interface {
@@ -281,6 +292,8 @@ type QueryAccessTokenRequest struct {
 type QueryAccessTokenResponse struct {
 	Device *Device
 	Err    string // e.g ErrorForbidden
+	// Expired is true if the token was valid, but has expired and must be refreshed.
+	Expired bool
 }
 
 // QueryAccountDataRequest is the request for QueryAccountData
@@ -352,6 +365,54 @@ type PerformPasswordUpdateRequest struct {
 	LogoutDevices bool            // Optional: Whether to log out all user devices.
 }
 
+// PerformAccountTypeUpdateRequest is the request for PerformAccountTypeUpdate
+type PerformAccountTypeUpdateRequest struct {
+	Localpart   string          // Required: The localpart for this account.
+	ServerName  spec.ServerName // Required: The domain for this account.
+	AccountType AccountType     // Required: The new account type, a user or admin.
+}
+
+// PerformAdminAccountUpdateRequest is the request for PerformAdminAccountUpdate.
+// Flags which are nil are left unchanged.
+type PerformAdminAccountUpdateRequest struct {
+	Localpart    string
+	ServerName   spec.ServerName
+	Locked       *bool
+	ShadowBanned *bool
+}
+
+// QueryAdminAccountsRequest is the request for QueryAdminAccounts.
+type QueryAdminAccountsRequest struct {
+	// Name only returns accounts whose localpart or display name contain it, if not empty.
+	Name string
+	// Admin only returns admin or non-admin accounts, if set.
+	Admin *bool
+	// Deactivated only returns deactivated or active accounts, if set.
+	Deactivated *bool
+	From        uint64
+	Limit       uint64
+}
+
+// ErrLDAPSyncDisabled is returned by PerformLDAPSync if the LDAP sync isn't enabled.
+var ErrLDAPSyncDisabled = errors.New("LDAP sync is not enabled")
+
+// PerformLDAPSyncRequest is the request for PerformLDAPSync
+type PerformLDAPSyncRequest struct {
+	DryRun bool // Optional: Only report the changes the sync would make.
+}
+
+// PerformLDAPSyncResponse is the response for PerformLDAPSync, listing the changes
+// made, or which would be made in a dry run, to sync accounts with the LDAP directory.
+type PerformLDAPSyncResponse struct {
+	DryRun       bool                `json:"dry_run"`
+	Created      []string            `json:"created"`      // User IDs of the created accounts
+	Deactivated  []string            `json:"deactivated"`  // User IDs of the deactivated accounts
+	DisplayNames map[string]string   `json:"displaynames"` // New display names, keyed by user ID
+	Joined       map[string][]string `json:"joined"`       // User IDs joined, keyed by room
+	Left         map[string][]string `json:"left"`         // User IDs which left, keyed by room
+	Errors       []string            `json:"errors,omitempty"`
+}
+
 // PerformAccountCreationResponse is the response for PerformAccountCreation
 type PerformPasswordUpdateResponse struct {
 	PasswordUpdated bool
@@ -392,6 +453,8 @@ type PerformDeviceCreationRequest struct {
 	// FromRegistration determines if this request comes from registering a new account
 	// and is in most cases false.
 	FromRegistration bool
+	// optional: if set, the access token expires and can be renewed with this refresh token.
+	RefreshToken string
 }
 
 // PerformDeviceCreationResponse is the response for PerformDeviceCreation
@@ -400,6 +463,20 @@ type PerformDeviceCreationResponse struct {
 	Device        *Device
 }
 
+// PerformTokenRefreshRequest is the request for PerformTokenRefresh
+type PerformTokenRefreshRequest struct {
+	RefreshToken string
+	// The tokens replacing the current access and refresh token of the device.
+	NewAccessToken  string
+	NewRefreshToken string
+}
+
+// PerformTokenRefreshResponse is the response for PerformTokenRefresh
+type PerformTokenRefreshResponse struct {
+	// The device with its new tokens, or nil if the refresh token is unknown.
+	Device *Device
+}
+
 // PerformAccountDeactivationRequest is the request for PerformAccountDeactivation
 type PerformAccountDeactivationRequest struct {
 	Localpart  string
@@ -447,10 +524,16 @@ type Device struct {
 	LastSeenTS  int64
 	LastSeenIP  string
 	UserAgent   string
+	// When the access token expires, as a unix timestamp (ms resolution).
+	// 0 if the access token never expires.
+	AccessTokenExpiresTS int64
 	// If the device is for an appservice user,
 	// this is the appservice ID.
 	AppserviceID string
 	AccountType  AccountType
+	// Whether the account of the device is locked or shadow-banned, see Account.
+	AccountLocked       bool
+	AccountShadowBanned bool
 }
 
 func (d *Device) UserDomain() spec.ServerName {
@@ -472,6 +555,13 @@ type Account struct {
 	ServerName   spec.ServerName
 	AppServiceID string
 	AccountType  AccountType
+	// When the account was created, as a unix timestamp (ms resolution).
+	CreatedTS   int64
+	Deactivated bool
+	// A locked account can't use the client API until it is unlocked by an admin.
+	Locked bool
+	// Events sent by a shadow-banned account are silently dropped.
+	ShadowBanned bool
 	// TODO: Associations (e.g. with application services)
 }
 
@@ -653,6 +743,26 @@ type PerformSaveThreePIDAssociationRequest struct {
 	Medium     string
 }
 
+// QueryLocalpartForSSOIdentityRequest identifies a user of an SSO identity provider.
+type QueryLocalpartForSSOIdentityRequest struct {
+	IDPID   string
+	Subject string
+}
+
+// QueryLocalpartForSSOIdentityResponse is the account linked to the user of the
+// identity provider. The localpart is empty if there is none.
+type QueryLocalpartForSSOIdentityResponse struct {
+	Localpart  string
+	ServerName spec.ServerName
+}
+
+type PerformSaveSSOIdentityAssociationRequest struct {
+	IDPID      string
+	Subject    string
+	Localpart  string
+	ServerName spec.ServerName
+}
+
 type QueryAccountByLocalpartRequest struct {
 	Localpart  string
 	ServerName spec.ServerName
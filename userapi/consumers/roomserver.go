@@ -573,7 +573,7 @@ func (s *OutputRoomEventConsumer) notifyLocal(ctx context.Context, event *rstype
 		RoomID:     event.RoomID().String(),
 		TS:         spec.AsTimestamp(time.Now()),
 	}
-	if err = s.db.InsertNotification(ctx, mem.Localpart, mem.Domain, event.EventID(), streamPos, tweaks, n); err != nil {
+	if err = s.db.InsertNotification(ctx, mem.Localpart, mem.Domain, event.EventID(), threadRootID(event), streamPos, tweaks, n); err != nil {
 		return fmt.Errorf("s.db.InsertNotification: %w", err)
 	}
 
@@ -876,3 +876,16 @@ func (s *OutputRoomEventConsumer) deleteRejectedPushers(ctx context.Context, dev
 		}
 	}
 }
+
+// threadRootID returns the thread root if the event is part of a thread,
+// so that notifications can be counted and read per thread.
+func threadRootID(event *rstypes.HeaderedEvent) string {
+	var content gomatrixserverlib.RelationContent
+	if err := json.Unmarshal(event.Content(), &content); err != nil {
+		return ""
+	}
+	if content.Relations == nil || content.Relations.RelationType != "m.thread" {
+		return ""
+	}
+	return content.Relations.EventID
+}
@@ -0,0 +1,470 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+	"github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/clientapi/userutil"
+	dendriteInternal "github.com/matrix-org/dendrite/internal"
+	rsapi "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/setup/process"
+	"github.com/matrix-org/dendrite/userapi/api"
+)
+
+// ldapPageSize is the page size of searches listing all users of the directory.
+const ldapPageSize = 500
+
+// LDAPUser is a user of the LDAP directory.
+type LDAPUser struct {
+	DN          string
+	Username    string
+	DisplayName string
+}
+
+// LDAPDirectory reads the users and groups of an LDAP directory.
+type LDAPDirectory interface {
+	// Users returns all users of the directory.
+	Users(ctx context.Context) ([]LDAPUser, error)
+	// GroupMembers returns the values of the member attribute of a group.
+	GroupMembers(ctx context.Context, groupDN, attribute string) ([]string, error)
+}
+
+// LDAPSyncer syncs accounts with an LDAP directory: it creates accounts for users of
+// the directory, deactivates the accounts of users removed from it, syncs display
+// names and joins the members of LDAP groups to rooms.
+type LDAPSyncer struct {
+	API       *UserInternalAPI
+	Config    *config.LdapSync
+	Directory LDAPDirectory
+	// Only one sync runs at a time.
+	mu sync.Mutex
+}
+
+func NewLDAPSyncer(userAPI *UserInternalAPI, cfg *config.UserAPI) *LDAPSyncer {
+	return &LDAPSyncer{
+		API:       userAPI,
+		Config:    &cfg.LdapSync,
+		Directory: &ldapDirectory{cfg: cfg.Ldap, syncCfg: &cfg.LdapSync},
+	}
+}
+
+// Start syncs the directory every configured interval until the process shuts down.
+func (s *LDAPSyncer) Start(processCtx *process.ProcessContext) {
+	go func() {
+		ticker := time.NewTicker(s.Config.Interval)
+		defer ticker.Stop()
+		for {
+			res, err := s.Sync(processCtx.Context(), false)
+			if err != nil {
+				logrus.WithError(err).Error("LDAP sync failed")
+			} else {
+				logrus.WithFields(logrus.Fields{
+					"created":      len(res.Created),
+					"deactivated":  len(res.Deactivated),
+					"displaynames": len(res.DisplayNames),
+					"errors":       len(res.Errors),
+				}).Info("LDAP sync finished")
+			}
+			select {
+			case <-processCtx.WaitForShutdown():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Sync syncs the accounts with the directory. If dryRun is set, the changes are only
+// reported. Failures of single changes are reported in the response, and don't stop
+// the sync.
+func (s *LDAPSyncer) Sync(ctx context.Context, dryRun bool) (*api.PerformLDAPSyncResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	serverName := s.API.Config.Matrix.ServerName
+	users, err := s.Directory.Users(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list LDAP users: %w", err)
+	}
+	tracked, err := s.API.DB.GetLDAPUsers(ctx, serverName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get synced LDAP users: %w", err)
+	}
+	if len(users) == 0 && len(tracked) > 0 && s.Config.DeactivateRemoved {
+		// Most likely the directory or the user filter is broken, rather than
+		// all users having been removed.
+		return nil, fmt.Errorf("the LDAP directory returned no users, refusing to deactivate %d accounts", len(tracked))
+	}
+
+	res := &api.PerformLDAPSyncResponse{
+		DryRun:       dryRun,
+		Created:      []string{},
+		Deactivated:  []string{},
+		DisplayNames: map[string]string{},
+		Joined:       map[string][]string{},
+		Left:         map[string][]string{},
+	}
+	reportErr := func(err error) {
+		logrus.WithError(err).Warn("LDAP sync")
+		res.Errors = append(res.Errors, err.Error())
+	}
+
+	// The localparts of the directory users, of those with an account, and by DN.
+	seen := make(map[string]bool, len(users))
+	accounts := make(map[string]bool, len(users))
+	byDN := make(map[string]string, len(users))
+	for _, user := range users {
+		localpart := user.Username
+		if err = dendriteInternal.ValidateUsername(localpart, serverName); err != nil {
+			reportErr(fmt.Errorf("user %q: %w", user.DN, err))
+			continue
+		}
+		seen[localpart] = true
+		byDN[strings.ToLower(user.DN)] = localpart
+		userID := userutil.MakeUserID(localpart, serverName)
+
+		displayName := localpart
+		_, err = s.API.DB.GetAccountByLocalpart(ctx, localpart, serverName)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			if !s.Config.CreateAccounts {
+				continue
+			}
+			res.Created = append(res.Created, userID)
+			if !dryRun {
+				if err = s.createAccount(ctx, localpart, serverName); err != nil {
+					reportErr(fmt.Errorf("failed to create account %s: %w", userID, err))
+					continue
+				}
+			}
+		case err != nil:
+			reportErr(fmt.Errorf("failed to get account %s: %w", userID, err))
+			continue
+		default:
+			profile, perr := s.API.DB.GetProfileByLocalpart(ctx, localpart, serverName)
+			if perr != nil && !errors.Is(perr, sql.ErrNoRows) {
+				reportErr(fmt.Errorf("failed to get profile of %s: %w", userID, perr))
+				continue
+			}
+			if profile != nil {
+				displayName = profile.DisplayName
+			}
+		}
+		accounts[localpart] = true
+
+		if !dryRun {
+			if err = s.API.DB.StoreLDAPUser(ctx, localpart, serverName, user.DN); err != nil {
+				reportErr(fmt.Errorf("failed to store LDAP user %s: %w", userID, err))
+			}
+		}
+		if s.Config.DisplayNameAttribute != "" && user.DisplayName != "" && user.DisplayName != displayName {
+			res.DisplayNames[userID] = user.DisplayName
+			if !dryRun {
+				if _, _, err = s.API.DB.SetDisplayName(ctx, localpart, serverName, user.DisplayName); err != nil {
+					reportErr(fmt.Errorf("failed to set display name of %s: %w", userID, err))
+				}
+			}
+		}
+	}
+
+	if s.Config.DeactivateRemoved {
+		removed := make([]string, 0, len(tracked))
+		for localpart := range tracked {
+			if !seen[localpart] {
+				removed = append(removed, localpart)
+			}
+		}
+		sort.Strings(removed)
+		for _, localpart := range removed {
+			userID := userutil.MakeUserID(localpart, serverName)
+			res.Deactivated = append(res.Deactivated, userID)
+			if dryRun {
+				continue
+			}
+			if err = s.API.PerformAccountDeactivation(ctx, &api.PerformAccountDeactivationRequest{
+				Localpart:  localpart,
+				ServerName: serverName,
+			}, &api.PerformAccountDeactivationResponse{}); err != nil {
+				reportErr(fmt.Errorf("failed to deactivate account %s: %w", userID, err))
+				continue
+			}
+			if err = s.API.DB.RemoveLDAPUser(ctx, localpart, serverName); err != nil {
+				reportErr(fmt.Errorf("failed to remove LDAP user %s: %w", userID, err))
+			}
+		}
+	}
+
+	s.syncGroupRooms(ctx, dryRun, res, seen, accounts, byDN, reportErr)
+	return res, nil
+}
+
+// syncGroupRooms joins the members of the configured groups to their rooms, and
+// removes directory users who aren't members anymore if configured.
+func (s *LDAPSyncer) syncGroupRooms(
+	ctx context.Context, dryRun bool, res *api.PerformLDAPSyncResponse,
+	seen, accounts map[string]bool, byDN map[string]string, reportErr func(error),
+) {
+	if len(s.Config.GroupRooms) == 0 {
+		return
+	}
+	serverName := s.API.Config.Matrix.ServerName
+
+	// The members of all groups mapped to a room, as room memberships may be
+	// configured for several groups.
+	members := map[string]map[string]bool{}
+	leaveRemoved := map[string]bool{}
+	// Rooms of groups which couldn't be looked up, nobody is removed from them.
+	failedRooms := map[string]bool{}
+	rooms := []string{}
+	for _, group := range s.Config.GroupRooms {
+		attribute := group.MemberAttribute
+		if attribute == "" {
+			attribute = "member"
+		}
+		values, err := s.Directory.GroupMembers(ctx, group.GroupDn, attribute)
+		if err != nil {
+			reportErr(fmt.Errorf("failed to get members of group %q: %w", group.GroupDn, err))
+			// Don't remove anyone from the rooms if a group is unavailable.
+			for _, roomID := range group.Rooms {
+				failedRooms[roomID] = true
+			}
+			continue
+		}
+		for _, roomID := range group.Rooms {
+			if _, ok := members[roomID]; !ok {
+				members[roomID] = map[string]bool{}
+				leaveRemoved[roomID] = group.LeaveRemoved
+				rooms = append(rooms, roomID)
+			}
+			leaveRemoved[roomID] = leaveRemoved[roomID] && group.LeaveRemoved
+			for _, value := range values {
+				// Members are listed either by DN or by username.
+				localpart, ok := byDN[strings.ToLower(value)]
+				if !ok && !strings.Contains(value, "=") {
+					localpart = value
+				}
+				if accounts[localpart] {
+					members[roomID][localpart] = true
+				}
+			}
+		}
+	}
+
+	for _, roomID := range rooms {
+		var memberships rsapi.QueryMembershipsForRoomResponse
+		if err := s.API.RSAPI.QueryMembershipsForRoom(ctx, &rsapi.QueryMembershipsForRoomRequest{
+			JoinedOnly: true,
+			LocalOnly:  true,
+			RoomID:     roomID,
+		}, &memberships); err != nil {
+			reportErr(fmt.Errorf("failed to get members of room %s: %w", roomID, err))
+			continue
+		}
+		parsedRoomID, err := spec.NewRoomID(roomID)
+		if err != nil {
+			reportErr(fmt.Errorf("invalid room ID %q: %w", roomID, err))
+			continue
+		}
+		joined := map[string]bool{}
+		for _, ev := range memberships.JoinEvents {
+			if ev.StateKey == nil {
+				continue
+			}
+			userID, err := s.API.RSAPI.QueryUserIDForSender(ctx, *parsedRoomID, spec.SenderID(*ev.StateKey))
+			if err != nil || userID == nil || !s.API.Config.Matrix.IsLocalServerName(userID.Domain()) {
+				continue
+			}
+			joined[userID.Local()] = true
+		}
+
+		join := sortedKeys(members[roomID], joined, false)
+		for _, localpart := range join {
+			userID := userutil.MakeUserID(localpart, serverName)
+			res.Joined[roomID] = append(res.Joined[roomID], userID)
+			if dryRun {
+				continue
+			}
+			content := map[string]interface{}{"displayname": localpart}
+			if profile, err := s.API.DB.GetProfileByLocalpart(ctx, localpart, serverName); err == nil {
+				content["displayname"] = profile.DisplayName
+				if profile.AvatarURL != "" {
+					content["avatar_url"] = profile.AvatarURL
+				}
+			}
+			if _, _, err = s.API.RSAPI.PerformJoin(ctx, &rsapi.PerformJoinRequest{
+				RoomIDOrAlias: roomID,
+				UserID:        userID,
+				Content:       content,
+			}); err != nil {
+				reportErr(fmt.Errorf("failed to join %s to %s: %w", userID, roomID, err))
+			}
+		}
+
+		if !leaveRemoved[roomID] || failedRooms[roomID] {
+			continue
+		}
+		// Only users of the directory leave, so that e.g. the local admin
+		// accounts managing the room stay.
+		leave := []string{}
+		for _, localpart := range sortedKeys(joined, members[roomID], false) {
+			if seen[localpart] {
+				leave = append(leave, localpart)
+			}
+		}
+		for _, localpart := range leave {
+			userID, err := spec.NewUserID(userutil.MakeUserID(localpart, serverName), true)
+			if err != nil {
+				continue
+			}
+			res.Left[roomID] = append(res.Left[roomID], userID.String())
+			if dryRun {
+				continue
+			}
+			if err = s.API.RSAPI.PerformLeave(ctx, &rsapi.PerformLeaveRequest{
+				RoomID: roomID,
+				Leaver: *userID,
+			}, &rsapi.PerformLeaveResponse{}); err != nil {
+				reportErr(fmt.Errorf("failed to remove %s from %s: %w", userID.String(), roomID, err))
+			}
+		}
+	}
+}
+
+// sortedKeys returns the sorted keys of a which are (or, if in is false, aren't) keys of b.
+func sortedKeys(a, b map[string]bool, in bool) []string {
+	keys := []string{}
+	for k := range a {
+		if b[k] == in {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// createAccount creates an account for a user of the directory, the same way as
+// the first LDAP login of the user does.
+func (s *LDAPSyncer) createAccount(ctx context.Context, localpart string, serverName spec.ServerName) error {
+	var created api.PerformAccountCreationResponse
+	return s.API.PerformAccountCreation(ctx, &api.PerformAccountCreationRequest{
+		AppServiceID: "ldap",
+		Localpart:    localpart,
+		ServerName:   serverName,
+		Password:     uuid.New().String(),
+		AccountType:  api.AccountTypeUser,
+		OnConflict:   api.ConflictAbort,
+	}, &created)
+}
+
+// PerformLDAPSync syncs accounts with the LDAP directory, or reports the changes
+// a sync would make.
+func (a *UserInternalAPI) PerformLDAPSync(ctx context.Context, req *api.PerformLDAPSyncRequest, res *api.PerformLDAPSyncResponse) error {
+	if a.LDAPSyncer == nil {
+		return api.ErrLDAPSyncDisabled
+	}
+	syncRes, err := a.LDAPSyncer.Sync(ctx, req.DryRun)
+	if err != nil {
+		return err
+	}
+	*res = *syncRes
+	return nil
+}
+
+// ldapDirectory reads the directory configured for LDAP login.
+type ldapDirectory struct {
+	cfg     *config.Ldap
+	syncCfg *config.LdapSync
+}
+
+func (d *ldapDirectory) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(d.cfg.Uri)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to ldap: %w", err)
+	}
+	if err = conn.Bind(d.cfg.AdminBindDn, d.cfg.AdminBindPassword); err != nil {
+		conn.Close() // nolint: errcheck
+		return nil, fmt.Errorf("unable to bind to ldap: %w", err)
+	}
+	return conn, nil
+}
+
+func (d *ldapDirectory) Users(ctx context.Context) ([]LDAPUser, error) {
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	// nolint: errcheck
+	defer conn.Close()
+
+	attributes := []string{d.cfg.SearchAttribute}
+	if d.syncCfg.DisplayNameAttribute != "" {
+		attributes = append(attributes, d.syncCfg.DisplayNameAttribute)
+	}
+	result, err := conn.SearchWithPaging(ldap.NewSearchRequest(
+		d.cfg.BaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, d.syncCfg.UserFilter, attributes, nil,
+	), ldapPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("unable to search ldap: %w", err)
+	}
+	users := make([]LDAPUser, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		username := entry.GetAttributeValue(d.cfg.SearchAttribute)
+		if username == "" {
+			continue
+		}
+		user := LDAPUser{DN: entry.DN, Username: username}
+		if d.syncCfg.DisplayNameAttribute != "" {
+			user.DisplayName = entry.GetAttributeValue(d.syncCfg.DisplayNameAttribute)
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (d *ldapDirectory) GroupMembers(ctx context.Context, groupDN, attribute string) ([]string, error) {
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	// nolint: errcheck
+	defer conn.Close()
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		groupDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", []string{attribute}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("unable to search ldap: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, fmt.Errorf("group not found")
+	}
+	return result.Entries[0].GetAttributeValues(attribute), nil
+}
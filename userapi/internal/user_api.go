@@ -62,6 +62,7 @@ type UserInternalAPI struct {
 	PgClient    pushgateway.Client
 	FedClient   fedsenderapi.KeyserverFederationAPI
 	Updater     *DeviceListUpdater
+	LDAPSyncer  *LDAPSyncer
 }
 
 func (a *UserInternalAPI) PerformAdminCreateRegistrationToken(ctx context.Context, registrationToken *clientapi.RegistrationToken) (bool, error) {
@@ -282,6 +283,37 @@ func (a *UserInternalAPI) PerformPasswordUpdate(ctx context.Context, req *api.Pe
 	return nil
 }
 
+func (a *UserInternalAPI) PerformAccountTypeUpdate(ctx context.Context, req *api.PerformAccountTypeUpdateRequest) error {
+	if !a.Config.Matrix.IsLocalServerName(req.ServerName) {
+		return fmt.Errorf("server name %s is not local", req.ServerName)
+	}
+	if req.AccountType != api.AccountTypeUser && req.AccountType != api.AccountTypeAdmin {
+		return fmt.Errorf("account type %d can't be set", req.AccountType)
+	}
+	return a.DB.SetAccountType(ctx, req.Localpart, req.ServerName, req.AccountType)
+}
+
+func (a *UserInternalAPI) PerformAdminAccountUpdate(ctx context.Context, req *api.PerformAdminAccountUpdateRequest) error {
+	if !a.Config.Matrix.IsLocalServerName(req.ServerName) {
+		return fmt.Errorf("server name %s is not local", req.ServerName)
+	}
+	if req.Locked != nil {
+		if err := a.DB.SetAccountLocked(ctx, req.Localpart, req.ServerName, *req.Locked); err != nil {
+			return fmt.Errorf("a.DB.SetAccountLocked: %w", err)
+		}
+	}
+	if req.ShadowBanned != nil {
+		if err := a.DB.SetAccountShadowBanned(ctx, req.Localpart, req.ServerName, *req.ShadowBanned); err != nil {
+			return fmt.Errorf("a.DB.SetAccountShadowBanned: %w", err)
+		}
+	}
+	return nil
+}
+
+func (a *UserInternalAPI) QueryAdminAccounts(ctx context.Context, req *api.QueryAdminAccountsRequest) ([]api.Account, int64, error) {
+	return a.DB.GetAccounts(ctx, req.Name, req.Admin, req.Deactivated, req.From, req.Limit)
+}
+
 func (a *UserInternalAPI) PerformDeviceCreation(ctx context.Context, req *api.PerformDeviceCreationRequest, res *api.PerformDeviceCreationResponse) error {
 	serverName := req.ServerName
 	if serverName == "" {
@@ -306,7 +338,11 @@ func (a *UserInternalAPI) PerformDeviceCreation(ctx context.Context, req *api.Pe
 		"device_id":    req.DeviceID,
 		"display_name": req.DeviceDisplayName,
 	}).Info("PerformDeviceCreation")
-	dev, err := a.DB.CreateDevice(ctx, req.Localpart, serverName, req.DeviceID, req.AccessToken, req.DeviceDisplayName, req.IPAddr, req.UserAgent)
+	var expiresTS int64
+	if req.RefreshToken != "" {
+		expiresTS = a.refreshableAccessTokenExpiry()
+	}
+	dev, err := a.DB.CreateDevice(ctx, req.Localpart, serverName, req.DeviceID, req.AccessToken, req.DeviceDisplayName, req.IPAddr, req.UserAgent, req.RefreshToken, expiresTS)
 	if err != nil {
 		return err
 	}
@@ -319,6 +355,25 @@ func (a *UserInternalAPI) PerformDeviceCreation(ctx context.Context, req *api.Pe
 	return a.deviceListUpdate(dev.UserID, []string{dev.ID}, req.FromRegistration)
 }
 
+// PerformTokenRefresh replaces the access and refresh token of the device the
+// refresh token was issued to. The new access token expires after the configured lifetime.
+func (a *UserInternalAPI) PerformTokenRefresh(ctx context.Context, req *api.PerformTokenRefreshRequest, res *api.PerformTokenRefreshResponse) error {
+	dev, err := a.DB.RefreshDeviceTokens(ctx, req.RefreshToken, req.NewAccessToken, req.NewRefreshToken, a.refreshableAccessTokenExpiry())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	res.Device = dev
+	return nil
+}
+
+// refreshableAccessTokenExpiry returns when an access token issued now with a refresh token expires.
+func (a *UserInternalAPI) refreshableAccessTokenExpiry() int64 {
+	return time.Now().Add(time.Duration(a.Config.RefreshableAccessTokenLifetimeMS) * time.Millisecond).UnixMilli()
+}
+
 func (a *UserInternalAPI) PerformDeviceDeletion(ctx context.Context, req *api.PerformDeviceDeletionRequest, res *api.PerformDeviceDeletionResponse) error {
 	util.GetLogger(ctx).WithField("user_id", req.UserID).WithField("devices", req.DeviceIDs).Info("PerformDeviceDeletion")
 	local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
@@ -586,6 +641,10 @@ func (a *UserInternalAPI) QueryAccessToken(ctx context.Context, req *api.QueryAc
 		}
 		return err
 	}
+	if device.AccessTokenExpiresTS != 0 && time.Now().UnixMilli() >= device.AccessTokenExpiresTS {
+		res.Expired = true
+		return nil
+	}
 	localPart, domain, err := gomatrixserverlib.SplitID('@', device.UserID)
 	if err != nil {
 		return err
@@ -598,6 +657,8 @@ func (a *UserInternalAPI) QueryAccessToken(ctx context.Context, req *api.QueryAc
 		return err
 	}
 	device.AccountType = acc.AccountType
+	device.AccountLocked = acc.Locked
+	device.AccountShadowBanned = acc.ShadowBanned
 	res.Device = device
 	return nil
 }
@@ -699,7 +760,14 @@ func (a *UserInternalAPI) PerformAccountDeactivation(ctx context.Context, req *a
 
 	err = a.DB.DeactivateAccount(ctx, req.Localpart, serverName)
 	res.AccountDeactivated = err == nil
-	return err
+	if err != nil {
+		return err
+	}
+
+	if err = a.SyncProducer.SendAccountDeactivation(userID); err != nil {
+		logrus.WithError(err).WithField("userID", userID).Errorf("Failed to notify sync API of account deactivation")
+	}
+	return nil
 }
 
 // PerformOpenIDTokenCreation creates a new token that a relying party uses to authenticate a user
@@ -993,4 +1061,18 @@ func (a *UserInternalAPI) PerformSaveThreePIDAssociation(ctx context.Context, re
 	return a.DB.SaveThreePIDAssociation(ctx, req.ThreePID, req.Localpart, req.ServerName, req.Medium)
 }
 
+func (a *UserInternalAPI) QueryLocalpartForSSOIdentity(ctx context.Context, req *api.QueryLocalpartForSSOIdentityRequest, res *api.QueryLocalpartForSSOIdentityResponse) error {
+	localpart, domain, err := a.DB.GetLocalpartForSSOIdentity(ctx, req.IDPID, req.Subject)
+	if err != nil {
+		return err
+	}
+	res.Localpart = localpart
+	res.ServerName = domain
+	return nil
+}
+
+func (a *UserInternalAPI) PerformSaveSSOIdentityAssociation(ctx context.Context, req *api.PerformSaveSSOIdentityAssociationRequest, res *struct{}) error {
+	return a.DB.SaveSSOIdentity(ctx, req.IDPID, req.Subject, req.Localpart, req.ServerName)
+}
+
 const pushRulesAccountDataType = "m.push_rules"
@@ -19,18 +19,20 @@ type JetStreamPublisher interface {
 
 // SyncAPI produces messages for the Sync API server to consume.
 type SyncAPI struct {
-	db                    storage.Notification
-	producer              JetStreamPublisher
-	clientDataTopic       string
-	notificationDataTopic string
+	db                       storage.Notification
+	producer                 JetStreamPublisher
+	clientDataTopic          string
+	notificationDataTopic    string
+	accountDeactivationTopic string
 }
 
-func NewSyncAPI(db storage.UserDatabase, js JetStreamPublisher, clientDataTopic string, notificationDataTopic string) *SyncAPI {
+func NewSyncAPI(db storage.UserDatabase, js JetStreamPublisher, clientDataTopic, notificationDataTopic, accountDeactivationTopic string) *SyncAPI {
 	return &SyncAPI{
-		db:                    db,
-		producer:              js,
-		clientDataTopic:       clientDataTopic,
-		notificationDataTopic: notificationDataTopic,
+		db:                       db,
+		producer:                 js,
+		clientDataTopic:          clientDataTopic,
+		notificationDataTopic:    notificationDataTopic,
+		accountDeactivationTopic: accountDeactivationTopic,
 	}
 }
 
@@ -71,10 +73,16 @@ func (p *SyncAPI) GetAndSendNotificationData(ctx context.Context, userID, roomID
 		return err
 	}
 
+	threads, err := p.db.GetRoomThreadNotificationCounts(ctx, localpart, domain, roomID)
+	if err != nil {
+		return err
+	}
+
 	return p.sendNotificationData(userID, &eventutil.NotificationData{
 		RoomID:                  roomID,
 		UnreadHighlightCount:    int(nhighlight),
 		UnreadNotificationCount: int(ntotal),
+		Threads:                 threads,
 	})
 }
 
@@ -100,3 +108,18 @@ func (p *SyncAPI) sendNotificationData(userID string, data *eventutil.Notificati
 	_, err = p.producer.PublishMsg(m)
 	return err
 }
+
+// SendAccountDeactivation tells the Sync API server that the account of the user was
+// deactivated, so it can delete the data it stores for the user.
+func (p *SyncAPI) SendAccountDeactivation(userID string) error {
+	m := &nats.Msg{
+		Subject: p.accountDeactivationTopic,
+		Header:  nats.Header{},
+	}
+	m.Header.Set(jetstream.UserID, userID)
+
+	log.WithField("user_id", userID).Tracef("Producing to topic '%s'", p.accountDeactivationTopic)
+
+	_, err := p.producer.PublishMsg(m)
+	return err
+}
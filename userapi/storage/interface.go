@@ -25,6 +25,7 @@ import (
 
 	clientapi "github.com/matrix-org/dendrite/clientapi/api"
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	"github.com/matrix-org/dendrite/internal/eventutil"
 	"github.com/matrix-org/dendrite/internal/pushrules"
 	"github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/dendrite/userapi/storage/tables"
@@ -58,6 +59,11 @@ type Account interface {
 	GetAccountByLocalpart(ctx context.Context, localpart string, serverName spec.ServerName) (*api.Account, error)
 	DeactivateAccount(ctx context.Context, localpart string, serverName spec.ServerName) (err error)
 	SetPassword(ctx context.Context, localpart string, serverName spec.ServerName, plaintextPassword string) error
+	SetAccountType(ctx context.Context, localpart string, serverName spec.ServerName, accountType api.AccountType) error
+	SetAccountLocked(ctx context.Context, localpart string, serverName spec.ServerName, locked bool) error
+	SetAccountShadowBanned(ctx context.Context, localpart string, serverName spec.ServerName, shadowBanned bool) error
+	// GetAccounts returns the accounts matching the filters, along with the total number of matching accounts.
+	GetAccounts(ctx context.Context, name string, admin, deactivated *bool, from, limit uint64) ([]api.Account, int64, error)
 }
 
 type AccountData interface {
@@ -81,10 +87,16 @@ type Device interface {
 	// and replaced with the given accessToken. If the given accessToken is already in use for another device,
 	// an error will be returned.
 	// If no device ID is given one is generated.
+	// If a refresh token is given, the access token expires at accessTokenExpiresTS and
+	// can be renewed with the refresh token.
 	// Returns the device on success.
-	CreateDevice(ctx context.Context, localpart string, serverName spec.ServerName, deviceID *string, accessToken string, displayName *string, ipAddr, userAgent string) (dev *api.Device, returnErr error)
+	CreateDevice(ctx context.Context, localpart string, serverName spec.ServerName, deviceID *string, accessToken string, displayName *string, ipAddr, userAgent string, refreshToken string, accessTokenExpiresTS int64) (dev *api.Device, returnErr error)
 	UpdateDevice(ctx context.Context, localpart string, serverName spec.ServerName, deviceID string, displayName *string) error
 	UpdateDeviceLastSeen(ctx context.Context, localpart string, serverName spec.ServerName, deviceID, ipAddr, userAgent string) error
+	// RefreshDeviceTokens replaces the access and refresh token of the device the
+	// refresh token was issued to. The refresh token stays valid until the new access
+	// or refresh token is used. Returns sql.ErrNoRows if the refresh token is unknown.
+	RefreshDeviceTokens(ctx context.Context, refreshToken, newAccessToken, newRefreshToken string, expiresTS int64) (*api.Device, error)
 	RemoveDevices(ctx context.Context, localpart string, serverName spec.ServerName, devices []string) error
 	// RemoveAllDevices deleted all devices for this user. Returns the devices deleted.
 	RemoveAllDevices(ctx context.Context, localpart string, serverName spec.ServerName, exceptDeviceID string) (devices []api.Device, err error)
@@ -133,25 +145,39 @@ type ThreePID interface {
 }
 
 type Notification interface {
-	InsertNotification(ctx context.Context, localpart string, serverName spec.ServerName, eventID string, pos uint64, tweaks map[string]interface{}, n *api.Notification) error
+	InsertNotification(ctx context.Context, localpart string, serverName spec.ServerName, eventID, threadID string, pos uint64, tweaks map[string]interface{}, n *api.Notification) error
 	DeleteNotificationsUpTo(ctx context.Context, localpart string, serverName spec.ServerName, roomID string, pos uint64) (affected bool, err error)
-	SetNotificationsRead(ctx context.Context, localpart string, serverName spec.ServerName, roomID string, pos uint64, read bool) (affected bool, err error)
+	SetNotificationsRead(ctx context.Context, localpart string, serverName spec.ServerName, roomID, threadID string, pos uint64, read bool) (affected bool, err error)
 	GetNotifications(ctx context.Context, localpart string, serverName spec.ServerName, fromID int64, limit int, filter tables.NotificationFilter) ([]*api.Notification, int64, error)
 	GetNotificationCount(ctx context.Context, localpart string, serverName spec.ServerName, filter tables.NotificationFilter) (int64, error)
 	GetRoomNotificationCounts(ctx context.Context, localpart string, serverName spec.ServerName, roomID string) (total int64, highlight int64, _ error)
+	GetRoomThreadNotificationCounts(ctx context.Context, localpart string, serverName spec.ServerName, roomID string) (map[string]*eventutil.ThreadNotificationData, error)
 	DeleteOldNotifications(ctx context.Context) error
 }
 
+type LDAPUsers interface {
+	StoreLDAPUser(ctx context.Context, localpart string, serverName spec.ServerName, dn string) error
+	GetLDAPUsers(ctx context.Context, serverName spec.ServerName) (map[string]string, error)
+	RemoveLDAPUser(ctx context.Context, localpart string, serverName spec.ServerName) error
+}
+
+type SSOIdentities interface {
+	SaveSSOIdentity(ctx context.Context, idpID, subject, localpart string, serverName spec.ServerName) error
+	GetLocalpartForSSOIdentity(ctx context.Context, idpID, subject string) (string, spec.ServerName, error)
+}
+
 type UserDatabase interface {
 	Account
 	AccountData
 	Device
 	KeyBackup
+	LDAPUsers
 	LoginToken
 	Notification
 	OpenID
 	Profile
 	Pusher
+	SSOIdentities
 	Statistics
 	ThreePID
 	RegistrationTokens
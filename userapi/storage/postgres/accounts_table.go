@@ -18,9 +18,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/matrix-org/dendrite/clientapi/userutil"
+	"github.com/matrix-org/dendrite/internal"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/userapi/api"
 	"github.com/matrix-org/dendrite/userapi/storage/postgres/deltas"
@@ -45,7 +47,11 @@ CREATE TABLE IF NOT EXISTS userapi_accounts (
     -- If the account is currently active
     is_deactivated BOOLEAN DEFAULT FALSE,
 	-- The account_type (user = 1, guest = 2, admin = 3, appservice = 4)
-	account_type SMALLINT NOT NULL
+	account_type SMALLINT NOT NULL,
+    -- If the account is locked and can't use the client API
+    is_locked BOOLEAN NOT NULL DEFAULT FALSE,
+    -- If the events sent by the account are silently dropped
+    is_shadow_banned BOOLEAN NOT NULL DEFAULT FALSE
     -- TODO:
     -- upgraded_ts, devices, any email reset stuff?
 );
@@ -59,11 +65,37 @@ const insertAccountSQL = "" +
 const updatePasswordSQL = "" +
 	"UPDATE userapi_accounts SET password_hash = $1 WHERE localpart = $2 AND server_name = $3"
 
+const updateAccountTypeSQL = "" +
+	"UPDATE userapi_accounts SET account_type = $1 WHERE localpart = $2 AND server_name = $3"
+
 const deactivateAccountSQL = "" +
 	"UPDATE userapi_accounts SET is_deactivated = TRUE WHERE localpart = $1 AND server_name = $2"
 
-const selectAccountByLocalpartSQL = "" +
-	"SELECT localpart, server_name, appservice_id, account_type FROM userapi_accounts WHERE localpart = $1 AND server_name = $2"
+const updateAccountLockedSQL = "" +
+	"UPDATE userapi_accounts SET is_locked = $1 WHERE localpart = $2 AND server_name = $3"
+
+const updateAccountShadowBannedSQL = "" +
+	"UPDATE userapi_accounts SET is_shadow_banned = $1 WHERE localpart = $2 AND server_name = $3"
+
+const accountColumns = "" +
+	"SELECT a.localpart, a.server_name, a.appservice_id, a.account_type, a.created_ts, a.is_deactivated, a.is_locked, a.is_shadow_banned" +
+	" FROM userapi_accounts a"
+
+const selectAccountByLocalpartSQL = accountColumns + " WHERE a.localpart = $1 AND a.server_name = $2"
+
+// The name filter is a LIKE pattern and ignored if empty, the admin and deactivated
+// filters are one of the accountsFilter* constants.
+const accountsFilterSQL = "" +
+	" LEFT JOIN userapi_profiles p ON p.localpart = a.localpart AND p.server_name = a.server_name" +
+	" WHERE ($1 = '' OR a.localpart ILIKE $1 OR p.display_name ILIKE $1)" +
+	" AND ($2 = 0 OR ($2 = 1 AND a.account_type <> 3) OR ($2 = 2 AND a.account_type = 3))" +
+	" AND ($3 = 0 OR ($3 = 1 AND a.is_deactivated = FALSE) OR ($3 = 2 AND a.is_deactivated = TRUE))"
+
+const selectAccountsSQL = accountColumns + accountsFilterSQL +
+	" ORDER BY a.localpart, a.server_name LIMIT $4 OFFSET $5"
+
+const selectAccountsCountSQL = "" +
+	"SELECT COUNT(*) FROM userapi_accounts a" + accountsFilterSQL
 
 const selectPasswordHashSQL = "" +
 	"SELECT password_hash FROM userapi_accounts WHERE localpart = $1 AND server_name = $2 AND is_deactivated = FALSE"
@@ -74,8 +106,13 @@ const selectNewNumericLocalpartSQL = "" +
 type accountsStatements struct {
 	insertAccountStmt             *sql.Stmt
 	updatePasswordStmt            *sql.Stmt
+	updateAccountTypeStmt         *sql.Stmt
 	deactivateAccountStmt         *sql.Stmt
+	updateAccountLockedStmt       *sql.Stmt
+	updateAccountShadowBannedStmt *sql.Stmt
 	selectAccountByLocalpartStmt  *sql.Stmt
+	selectAccountsStmt            *sql.Stmt
+	selectAccountsCountStmt       *sql.Stmt
 	selectPasswordHashStmt        *sql.Stmt
 	selectNewNumericLocalpartStmt *sql.Stmt
 	serverName                    spec.ServerName
@@ -106,6 +143,11 @@ func NewPostgresAccountsTable(db *sql.DB, serverName spec.ServerName) (tables.Ac
 			Up:      deltas.UpNoGuests,
 			Down:    deltas.DownNoGuests,
 		},
+		{
+			Version: "userapi: add locked and shadow-banned accounts",
+			Up:      deltas.UpAccountModeration,
+			Down:    deltas.DownAccountModeration,
+		},
 	}...)
 	err = m.Up(context.Background())
 	if err != nil {
@@ -114,8 +156,13 @@ func NewPostgresAccountsTable(db *sql.DB, serverName spec.ServerName) (tables.Ac
 	return s, sqlutil.StatementList{
 		{&s.insertAccountStmt, insertAccountSQL},
 		{&s.updatePasswordStmt, updatePasswordSQL},
+		{&s.updateAccountTypeStmt, updateAccountTypeSQL},
 		{&s.deactivateAccountStmt, deactivateAccountSQL},
+		{&s.updateAccountLockedStmt, updateAccountLockedSQL},
+		{&s.updateAccountShadowBannedStmt, updateAccountShadowBannedSQL},
 		{&s.selectAccountByLocalpartStmt, selectAccountByLocalpartSQL},
+		{&s.selectAccountsStmt, selectAccountsSQL},
+		{&s.selectAccountsCountStmt, selectAccountsCountSQL},
 		{&s.selectPasswordHashStmt, selectPasswordHashSQL},
 		{&s.selectNewNumericLocalpartStmt, selectNewNumericLocalpartSQL},
 	}.Prepare(db)
@@ -148,6 +195,7 @@ func (s *accountsStatements) InsertAccount(
 		ServerName:   serverName,
 		AppServiceID: appserviceID,
 		AccountType:  accountType,
+		CreatedTS:    createdTimeMS,
 	}, nil
 }
 
@@ -159,6 +207,14 @@ func (s *accountsStatements) UpdatePassword(
 	return
 }
 
+func (s *accountsStatements) UpdateAccountType(
+	ctx context.Context, localpart string, serverName spec.ServerName,
+	accountType api.AccountType,
+) (err error) {
+	_, err = s.updateAccountTypeStmt.ExecContext(ctx, accountType, localpart, serverName)
+	return
+}
+
 func (s *accountsStatements) DeactivateAccount(
 	ctx context.Context, localpart string, serverName spec.ServerName,
 ) (err error) {
@@ -176,21 +232,91 @@ func (s *accountsStatements) SelectPasswordHash(
 func (s *accountsStatements) SelectAccountByLocalpart(
 	ctx context.Context, localpart string, serverName spec.ServerName,
 ) (*api.Account, error) {
-	var appserviceIDPtr sql.NullString
-	var acc api.Account
-
 	stmt := s.selectAccountByLocalpartStmt
-	err := stmt.QueryRowContext(ctx, localpart, serverName).Scan(&acc.Localpart, &acc.ServerName, &appserviceIDPtr, &acc.AccountType)
+	acc, err := scanAccount(stmt.QueryRowContext(ctx, localpart, serverName))
 	if err != nil {
 		if err != sql.ErrNoRows {
 			log.WithError(err).Error("Unable to retrieve user from the db")
 		}
 		return nil, err
 	}
-	if appserviceIDPtr.Valid {
-		acc.AppServiceID = appserviceIDPtr.String
+	return acc, nil
+}
+
+func (s *accountsStatements) UpdateAccountLocked(
+	ctx context.Context, localpart string, serverName spec.ServerName, locked bool,
+) (err error) {
+	_, err = s.updateAccountLockedStmt.ExecContext(ctx, locked, localpart, serverName)
+	return
+}
+
+func (s *accountsStatements) UpdateAccountShadowBanned(
+	ctx context.Context, localpart string, serverName spec.ServerName, shadowBanned bool,
+) (err error) {
+	_, err = s.updateAccountShadowBannedStmt.ExecContext(ctx, shadowBanned, localpart, serverName)
+	return
+}
+
+func (s *accountsStatements) SelectAccounts(
+	ctx context.Context, name string, admin, deactivated *bool, from, limit uint64,
+) ([]api.Account, int64, error) {
+	namePattern := ""
+	if name != "" {
+		namePattern = "%" + strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(name) + "%"
+	}
+	adminFilter, deactivatedFilter := accountsFilter(admin), accountsFilter(deactivated)
+
+	var total int64
+	if err := s.selectAccountsCountStmt.QueryRowContext(ctx, namePattern, adminFilter, deactivatedFilter).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.selectAccountsStmt.QueryContext(ctx, namePattern, adminFilter, deactivatedFilter, limit, from)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectAccounts: rows.close() failed")
+
+	var accounts []api.Account
+	for rows.Next() {
+		acc, err := scanAccount(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		accounts = append(accounts, *acc)
 	}
+	return accounts, total, rows.Err()
+}
+
+const (
+	accountsFilterAny = iota
+	accountsFilterNo
+	accountsFilterYes
+)
 
+func accountsFilter(value *bool) int {
+	switch {
+	case value == nil:
+		return accountsFilterAny
+	case *value:
+		return accountsFilterYes
+	default:
+		return accountsFilterNo
+	}
+}
+
+func scanAccount(row interface{ Scan(...interface{}) error }) (*api.Account, error) {
+	var appserviceIDPtr sql.NullString
+	var deactivated sql.NullBool
+	var acc api.Account
+	if err := row.Scan(
+		&acc.Localpart, &acc.ServerName, &appserviceIDPtr, &acc.AccountType,
+		&acc.CreatedTS, &deactivated, &acc.Locked, &acc.ShadowBanned,
+	); err != nil {
+		return nil, err
+	}
+	acc.AppServiceID = appserviceIDPtr.String
+	acc.Deactivated = deactivated.Bool
 	acc.UserID = userutil.MakeUserID(acc.Localpart, acc.ServerName)
 	return &acc, nil
 }
@@ -61,7 +61,14 @@ CREATE TABLE IF NOT EXISTS userapi_devices (
 	-- The last seen IP address of this device
 	ip TEXT,
 	-- User agent of this device
-	user_agent TEXT
+	user_agent TEXT,
+	-- The refresh token the access token can be renewed with, if any.
+	refresh_token TEXT,
+	-- The refresh token which was used to obtain the current tokens. It stays valid
+	-- until the new access or refresh token is used, in case the response was lost.
+	previous_refresh_token TEXT,
+	-- When the access token expires, as a unix timestamp (ms resolution). 0 if it never expires.
+	access_token_expires_ts BIGINT NOT NULL DEFAULT 0
                                           
     -- TODO: device keys, device display names, token restrictions (if 3rd-party OAuth app)
 );
@@ -75,7 +82,10 @@ const insertDeviceSQL = "" +
 	" RETURNING session_id"
 
 const selectDeviceByTokenSQL = "" +
-	"SELECT session_id, device_id, localpart, server_name FROM userapi_devices WHERE access_token = $1"
+	"SELECT session_id, device_id, localpart, server_name, access_token_expires_ts, previous_refresh_token IS NOT NULL FROM userapi_devices WHERE access_token = $1"
+
+const selectDeviceByRefreshTokenSQL = "" +
+	"SELECT access_token, session_id, device_id, localpart, server_name FROM userapi_devices WHERE refresh_token = $1 OR previous_refresh_token = $1"
 
 const selectDeviceByIDSQL = "" +
 	"SELECT display_name, last_seen_ts, ip FROM userapi_devices WHERE localpart = $1 AND server_name = $2 AND device_id = $3"
@@ -101,18 +111,27 @@ const selectDevicesByIDSQL = "" +
 const updateDeviceLastSeen = "" +
 	"UPDATE userapi_devices SET last_seen_ts = $1, ip = $2, user_agent = $3 WHERE localpart = $4 AND server_name = $5 AND device_id = $6"
 
+const updateDeviceTokensSQL = "" +
+	"UPDATE userapi_devices SET access_token = $1, refresh_token = $2, previous_refresh_token = $3, access_token_expires_ts = $4 WHERE access_token = $5"
+
+const deletePreviousRefreshTokenSQL = "" +
+	"UPDATE userapi_devices SET previous_refresh_token = NULL WHERE access_token = $1"
+
 type devicesStatements struct {
-	insertDeviceStmt             *sql.Stmt
-	selectDeviceByTokenStmt      *sql.Stmt
-	selectDeviceByIDStmt         *sql.Stmt
-	selectDevicesByLocalpartStmt *sql.Stmt
-	selectDevicesByIDStmt        *sql.Stmt
-	updateDeviceNameStmt         *sql.Stmt
-	updateDeviceLastSeenStmt     *sql.Stmt
-	deleteDeviceStmt             *sql.Stmt
-	deleteDevicesByLocalpartStmt *sql.Stmt
-	deleteDevicesStmt            *sql.Stmt
-	serverName                   spec.ServerName
+	insertDeviceStmt               *sql.Stmt
+	selectDeviceByTokenStmt        *sql.Stmt
+	selectDeviceByRefreshTokenStmt *sql.Stmt
+	selectDeviceByIDStmt           *sql.Stmt
+	selectDevicesByLocalpartStmt   *sql.Stmt
+	selectDevicesByIDStmt          *sql.Stmt
+	updateDeviceNameStmt           *sql.Stmt
+	updateDeviceLastSeenStmt       *sql.Stmt
+	updateDeviceTokensStmt         *sql.Stmt
+	deletePreviousRefreshTokenStmt *sql.Stmt
+	deleteDeviceStmt               *sql.Stmt
+	deleteDevicesByLocalpartStmt   *sql.Stmt
+	deleteDevicesStmt              *sql.Stmt
+	serverName                     spec.ServerName
 }
 
 func NewPostgresDevicesTable(db *sql.DB, serverName spec.ServerName) (tables.DevicesTable, error) {
@@ -127,6 +146,9 @@ func NewPostgresDevicesTable(db *sql.DB, serverName spec.ServerName) (tables.Dev
 	m.AddMigrations(sqlutil.Migration{
 		Version: "userapi: add last_seen_ts",
 		Up:      deltas.UpLastSeenTSIP,
+	}, sqlutil.Migration{
+		Version: "userapi: add refresh tokens",
+		Up:      deltas.UpRefreshTokens,
 	})
 	err = m.Up(context.Background())
 	if err != nil {
@@ -135,6 +157,7 @@ func NewPostgresDevicesTable(db *sql.DB, serverName spec.ServerName) (tables.Dev
 	return s, sqlutil.StatementList{
 		{&s.insertDeviceStmt, insertDeviceSQL},
 		{&s.selectDeviceByTokenStmt, selectDeviceByTokenSQL},
+		{&s.selectDeviceByRefreshTokenStmt, selectDeviceByRefreshTokenSQL},
 		{&s.selectDeviceByIDStmt, selectDeviceByIDSQL},
 		{&s.selectDevicesByLocalpartStmt, selectDevicesByLocalpartSQL},
 		{&s.updateDeviceNameStmt, updateDeviceNameSQL},
@@ -143,6 +166,8 @@ func NewPostgresDevicesTable(db *sql.DB, serverName spec.ServerName) (tables.Dev
 		{&s.deleteDevicesStmt, deleteDevicesSQL},
 		{&s.selectDevicesByIDStmt, selectDevicesByIDSQL},
 		{&s.updateDeviceLastSeenStmt, updateDeviceLastSeen},
+		{&s.updateDeviceTokensStmt, updateDeviceTokensSQL},
+		{&s.deletePreviousRefreshTokenStmt, deletePreviousRefreshTokenSQL},
 	}.Prepare(db)
 }
 
@@ -229,16 +254,32 @@ func (s *devicesStatements) UpdateDeviceName(
 
 func (s *devicesStatements) SelectDeviceByToken(
 	ctx context.Context, accessToken string,
-) (*api.Device, error) {
-	var dev api.Device
+) (dev *api.Device, hasPreviousRefreshToken bool, err error) {
+	dev = &api.Device{}
 	var localpart string
 	var serverName spec.ServerName
 	stmt := s.selectDeviceByTokenStmt
-	err := stmt.QueryRowContext(ctx, accessToken).Scan(&dev.SessionID, &dev.ID, &localpart, &serverName)
+	err = stmt.QueryRowContext(ctx, accessToken).Scan(&dev.SessionID, &dev.ID, &localpart, &serverName, &dev.AccessTokenExpiresTS, &hasPreviousRefreshToken)
 	if err == nil {
 		dev.UserID = userutil.MakeUserID(localpart, serverName)
 		dev.AccessToken = accessToken
 	}
+	return dev, hasPreviousRefreshToken, err
+}
+
+// SelectDeviceByRefreshToken retrieves the device the given refresh token was issued to,
+// or which was obtained with it and hasn't been used yet.
+func (s *devicesStatements) SelectDeviceByRefreshToken(
+	ctx context.Context, txn *sql.Tx, refreshToken string,
+) (*api.Device, error) {
+	var dev api.Device
+	var localpart string
+	var serverName spec.ServerName
+	stmt := sqlutil.TxStmt(txn, s.selectDeviceByRefreshTokenStmt)
+	err := stmt.QueryRowContext(ctx, refreshToken).Scan(&dev.AccessToken, &dev.SessionID, &dev.ID, &localpart, &serverName)
+	if err == nil {
+		dev.UserID = userutil.MakeUserID(localpart, serverName)
+	}
 	return &dev, err
 }
 
@@ -348,3 +389,26 @@ func (s *devicesStatements) UpdateDeviceLastSeen(ctx context.Context, txn *sql.T
 	_, err := stmt.ExecContext(ctx, lastSeenTs, ipAddr, userAgent, localpart, serverName, deviceID)
 	return err
 }
+
+// UpdateDeviceTokens replaces the access token of a device, along with its refresh
+// token, the refresh token which was used to obtain them, if any, and the time the
+// new access token expires.
+func (s *devicesStatements) UpdateDeviceTokens(
+	ctx context.Context, txn *sql.Tx,
+	oldAccessToken, accessToken, refreshToken, previousRefreshToken string, expiresTS int64,
+) error {
+	previous := sql.NullString{String: previousRefreshToken, Valid: previousRefreshToken != ""}
+	stmt := sqlutil.TxStmt(txn, s.updateDeviceTokensStmt)
+	_, err := stmt.ExecContext(ctx, accessToken, refreshToken, previous, expiresTS, oldAccessToken)
+	return err
+}
+
+// DeletePreviousRefreshToken revokes the refresh token which was used to obtain
+// the given access token.
+func (s *devicesStatements) DeletePreviousRefreshToken(
+	ctx context.Context, txn *sql.Tx, accessToken string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deletePreviousRefreshTokenStmt)
+	_, err := stmt.ExecContext(ctx, accessToken)
+	return err
+}
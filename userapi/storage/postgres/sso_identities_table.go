@@ -0,0 +1,82 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/storage/tables"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+)
+
+const ssoIdentitiesSchema = `
+-- Stores the accounts linked to users of SSO identity providers.
+CREATE TABLE IF NOT EXISTS userapi_sso_identities (
+	-- The ID of the identity provider, as configured in client_api.sso.providers
+	idp_id TEXT NOT NULL,
+	-- The subject identifying the user at the identity provider
+	subject TEXT NOT NULL,
+	-- The Matrix user ID localpart of the linked account
+	localpart TEXT NOT NULL,
+	server_name TEXT NOT NULL,
+	-- When the account was linked, as a unix timestamp (ms resolution).
+	created_ts BIGINT NOT NULL,
+	PRIMARY KEY (idp_id, subject)
+);
+`
+
+const insertSSOIdentitySQL = "" +
+	"INSERT INTO userapi_sso_identities(idp_id, subject, localpart, server_name, created_ts) VALUES ($1, $2, $3, $4, $5)"
+
+const selectLocalpartForSSOIdentitySQL = "" +
+	"SELECT localpart, server_name FROM userapi_sso_identities WHERE idp_id = $1 AND subject = $2"
+
+type ssoIdentitiesStatements struct {
+	insertSSOIdentityStmt             *sql.Stmt
+	selectLocalpartForSSOIdentityStmt *sql.Stmt
+}
+
+func NewPostgresSSOIdentitiesTable(db *sql.DB) (tables.SSOIdentitiesTable, error) {
+	s := &ssoIdentitiesStatements{}
+	_, err := db.Exec(ssoIdentitiesSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.insertSSOIdentityStmt, insertSSOIdentitySQL},
+		{&s.selectLocalpartForSSOIdentityStmt, selectLocalpartForSSOIdentitySQL},
+	}.Prepare(db)
+}
+
+func (s *ssoIdentitiesStatements) InsertSSOIdentity(
+	ctx context.Context, txn *sql.Tx, idpID, subject, localpart string, serverName spec.ServerName, createdTS int64,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.insertSSOIdentityStmt).ExecContext(ctx, idpID, subject, localpart, serverName, createdTS)
+	return err
+}
+
+// SelectLocalpartForSSOIdentity returns the account linked to the user of the identity
+// provider, or an empty localpart if there is none.
+func (s *ssoIdentitiesStatements) SelectLocalpartForSSOIdentity(
+	ctx context.Context, txn *sql.Tx, idpID, subject string,
+) (localpart string, serverName spec.ServerName, err error) {
+	err = sqlutil.TxStmt(txn, s.selectLocalpartForSSOIdentityStmt).QueryRowContext(ctx, idpID, subject).Scan(&localpart, &serverName)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return
+}
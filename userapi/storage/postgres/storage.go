@@ -61,6 +61,11 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresRegistrationsTokenTable: %w", err)
 	}
+	// The accounts table refers to the profiles table, so create it first.
+	profilesTable, err := NewPostgresProfilesTable(db, serverNoticesLocalpart)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresProfilesTable: %w", err)
+	}
 	accountsTable, err := NewPostgresAccountsTable(db, serverName)
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresAccountsTable: %w", err)
@@ -89,10 +94,6 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresOpenIDTable: %w", err)
 	}
-	profilesTable, err := NewPostgresProfilesTable(db, serverNoticesLocalpart)
-	if err != nil {
-		return nil, fmt.Errorf("NewPostgresProfilesTable: %w", err)
-	}
 	threePIDTable, err := NewPostgresThreePIDTable(db)
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresThreePIDTable: %w", err)
@@ -110,6 +111,15 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 		return nil, fmt.Errorf("NewPostgresStatsTable: %w", err)
 	}
 
+	ldapUsersTable, err := NewPostgresLDAPUsersTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresLDAPUsersTable: %w", err)
+	}
+	ssoIdentitiesTable, err := NewPostgresSSOIdentitiesTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresSSOIdentitiesTable: %w", err)
+	}
+
 	m = sqlutil.NewMigrator(db)
 	m.AddMigrations(sqlutil.Migration{
 		Version: "userapi: server names populate",
@@ -135,6 +145,8 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 		Notifications:         notificationsTable,
 		RegistrationTokens:    registationTokensTable,
 		Stats:                 statsTable,
+		LDAPUsers:             ldapUsersTable,
+		SSOIdentities:         ssoIdentitiesTable,
 		ServerName:            serverName,
 		DB:                    db,
 		Writer:                writer,
@@ -33,6 +33,7 @@ import (
 
 	clientapi "github.com/matrix-org/dendrite/clientapi/api"
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	"github.com/matrix-org/dendrite/internal/eventutil"
 	"github.com/matrix-org/dendrite/internal/pushrules"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
 	"github.com/matrix-org/dendrite/userapi/api"
@@ -57,6 +58,8 @@ type Database struct {
 	Notifications         tables.NotificationTable
 	Pushers               tables.PusherTable
 	Stats                 tables.StatsTable
+	LDAPUsers             tables.LDAPUsersTable
+	SSOIdentities         tables.SSOIdentitiesTable
 	LoginTokenLifetime    time.Duration
 	ServerName            spec.ServerName
 	BcryptCost            int
@@ -424,6 +427,41 @@ func (d *Database) SearchProfiles(ctx context.Context, searchString string, limi
 	return d.Profiles.SelectProfilesBySearch(ctx, searchString, limit)
 }
 
+// SetAccountType changes the account type of an existing account.
+func (d *Database) SetAccountType(
+	ctx context.Context, localpart string, serverName spec.ServerName,
+	accountType api.AccountType,
+) error {
+	return d.Writer.Do(nil, nil, func(txn *sql.Tx) error {
+		return d.Accounts.UpdateAccountType(ctx, localpart, serverName, accountType)
+	})
+}
+
+// SetAccountLocked locks or unlocks an existing account.
+func (d *Database) SetAccountLocked(
+	ctx context.Context, localpart string, serverName spec.ServerName, locked bool,
+) error {
+	return d.Writer.Do(nil, nil, func(txn *sql.Tx) error {
+		return d.Accounts.UpdateAccountLocked(ctx, localpart, serverName, locked)
+	})
+}
+
+// SetAccountShadowBanned shadow-bans or unbans an existing account.
+func (d *Database) SetAccountShadowBanned(
+	ctx context.Context, localpart string, serverName spec.ServerName, shadowBanned bool,
+) error {
+	return d.Writer.Do(nil, nil, func(txn *sql.Tx) error {
+		return d.Accounts.UpdateAccountShadowBanned(ctx, localpart, serverName, shadowBanned)
+	})
+}
+
+// GetAccounts returns the accounts matching the filters, along with the total number of matching accounts.
+func (d *Database) GetAccounts(
+	ctx context.Context, name string, admin, deactivated *bool, from, limit uint64,
+) ([]api.Account, int64, error) {
+	return d.Accounts.SelectAccounts(ctx, name, admin, deactivated, from, limit)
+}
+
 // DeactivateAccount deactivates the user's account, removing all ability for the user to login again.
 func (d *Database) DeactivateAccount(ctx context.Context, localpart string, serverName spec.ServerName) (err error) {
 	return d.Writer.Do(nil, nil, func(txn *sql.Tx) error {
@@ -603,7 +641,16 @@ func (d *Database) UpsertBackupKeys(
 func (d *Database) GetDeviceByAccessToken(
 	ctx context.Context, token string,
 ) (*api.Device, error) {
-	return d.Devices.SelectDeviceByToken(ctx, token)
+	dev, hasPreviousRefreshToken, err := d.Devices.SelectDeviceByToken(ctx, token)
+	if err != nil || !hasPreviousRefreshToken {
+		return dev, err
+	}
+	// The access token was obtained by refreshing, so the refresh token used for
+	// that can be revoked now that the client is known to have received it.
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.Devices.DeletePreviousRefreshToken(ctx, txn, token)
+	})
+	return dev, err
 }
 
 // GetDeviceByID returns the device matching the given ID.
@@ -637,6 +684,7 @@ func (d *Database) GetDevicesByID(ctx context.Context, deviceIDs []string) ([]ap
 func (d *Database) CreateDevice(
 	ctx context.Context, localpart string, serverName spec.ServerName,
 	deviceID *string, accessToken string, displayName *string, ipAddr, userAgent string,
+	refreshToken string, accessTokenExpiresTS int64,
 ) (dev *api.Device, returnErr error) {
 	if deviceID != nil && *deviceID != "" {
 		_, ok := d.Writer.(*sqlutil.ExclusiveWriter)
@@ -650,7 +698,10 @@ func (d *Database) CreateDevice(
 				// No devices yet, only create a new one
 				if len(devices) == 0 {
 					dev, err = d.Devices.InsertDevice(ctx, txn, *deviceID, localpart, serverName, accessToken, displayName, ipAddr, userAgent)
-					return err
+					if err != nil {
+						return err
+					}
+					return d.setDeviceRefreshToken(ctx, txn, dev, refreshToken, accessTokenExpiresTS)
 				}
 				sessionID := devices[0].SessionID + 1
 
@@ -660,7 +711,10 @@ func (d *Database) CreateDevice(
 				}
 				// Create a new device with the session ID incremented
 				dev, err = d.Devices.InsertDeviceWithSessionID(ctx, txn, *deviceID, localpart, serverName, accessToken, displayName, ipAddr, userAgent, sessionID)
-				return err
+				if err != nil {
+					return err
+				}
+				return d.setDeviceRefreshToken(ctx, txn, dev, refreshToken, accessTokenExpiresTS)
 			})
 		} else {
 			returnErr = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
@@ -671,7 +725,10 @@ func (d *Database) CreateDevice(
 				}
 
 				dev, err = d.Devices.InsertDevice(ctx, txn, *deviceID, localpart, serverName, accessToken, displayName, ipAddr, userAgent)
-				return err
+				if err != nil {
+					return err
+				}
+				return d.setDeviceRefreshToken(ctx, txn, dev, refreshToken, accessTokenExpiresTS)
 			})
 		}
 	} else {
@@ -687,7 +744,10 @@ func (d *Database) CreateDevice(
 			returnErr = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
 				var err error
 				dev, err = d.Devices.InsertDevice(ctx, txn, newDeviceID, localpart, serverName, accessToken, displayName, ipAddr, userAgent)
-				return err
+				if err != nil {
+					return err
+				}
+				return d.setDeviceRefreshToken(ctx, txn, dev, refreshToken, accessTokenExpiresTS)
 			})
 			if returnErr == nil {
 				return dev, nil
@@ -766,6 +826,42 @@ func (d *Database) UpdateDeviceLastSeen(ctx context.Context, localpart string, s
 	})
 }
 
+// setDeviceRefreshToken makes the access token of a newly created device expire at
+// expiresTS, and allows it to be renewed with the refresh token, if one is given.
+func (d *Database) setDeviceRefreshToken(ctx context.Context, txn *sql.Tx, dev *api.Device, refreshToken string, expiresTS int64) error {
+	if refreshToken == "" {
+		return nil
+	}
+	if err := d.Devices.UpdateDeviceTokens(ctx, txn, dev.AccessToken, dev.AccessToken, refreshToken, "", expiresTS); err != nil {
+		return err
+	}
+	dev.AccessTokenExpiresTS = expiresTS
+	return nil
+}
+
+// RefreshDeviceTokens replaces the access and refresh token of the device the
+// refresh token was issued to. The previous access token can't be used afterwards.
+// The refresh token stays valid until the new access or refresh token is used, so
+// that clients which didn't receive the response can retry, as per MSC2918.
+// Returns sql.ErrNoRows if the refresh token is unknown.
+func (d *Database) RefreshDeviceTokens(
+	ctx context.Context, refreshToken, newAccessToken, newRefreshToken string, expiresTS int64,
+) (dev *api.Device, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		dev, err = d.Devices.SelectDeviceByRefreshToken(ctx, txn, refreshToken)
+		if err != nil {
+			return err
+		}
+		if err = d.Devices.UpdateDeviceTokens(ctx, txn, dev.AccessToken, newAccessToken, newRefreshToken, refreshToken, expiresTS); err != nil {
+			return err
+		}
+		dev.AccessToken = newAccessToken
+		dev.AccessTokenExpiresTS = expiresTS
+		return nil
+	})
+	return
+}
+
 // CreateLoginToken generates a token, stores and returns it. The lifetime is
 // determined by the loginTokenLifetime given to the Database constructor.
 func (d *Database) CreateLoginToken(ctx context.Context, data *api.LoginTokenData) (*api.LoginTokenMetadata, error) {
@@ -810,9 +906,9 @@ func (d *Database) GetLoginTokenDataByToken(ctx context.Context, token string) (
 	return d.LoginTokens.SelectLoginToken(ctx, token)
 }
 
-func (d *Database) InsertNotification(ctx context.Context, localpart string, serverName spec.ServerName, eventID string, pos uint64, tweaks map[string]interface{}, n *api.Notification) error {
+func (d *Database) InsertNotification(ctx context.Context, localpart string, serverName spec.ServerName, eventID, threadID string, pos uint64, tweaks map[string]interface{}, n *api.Notification) error {
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		return d.Notifications.Insert(ctx, txn, localpart, serverName, eventID, pos, pushrules.BoolTweakOr(tweaks, pushrules.HighlightTweak, false), n)
+		return d.Notifications.Insert(ctx, txn, localpart, serverName, eventID, threadID, pos, pushrules.BoolTweakOr(tweaks, pushrules.HighlightTweak, false), n)
 	})
 }
 
@@ -824,9 +920,9 @@ func (d *Database) DeleteNotificationsUpTo(ctx context.Context, localpart string
 	return
 }
 
-func (d *Database) SetNotificationsRead(ctx context.Context, localpart string, serverName spec.ServerName, roomID string, pos uint64, b bool) (affected bool, err error) {
+func (d *Database) SetNotificationsRead(ctx context.Context, localpart string, serverName spec.ServerName, roomID, threadID string, pos uint64, b bool) (affected bool, err error) {
 	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		affected, err = d.Notifications.UpdateRead(ctx, txn, localpart, serverName, roomID, pos, b)
+		affected, err = d.Notifications.UpdateRead(ctx, txn, localpart, serverName, roomID, threadID, pos, b)
 		return err
 	})
 	return
@@ -844,6 +940,10 @@ func (d *Database) GetRoomNotificationCounts(ctx context.Context, localpart stri
 	return d.Notifications.SelectRoomCounts(ctx, nil, localpart, serverName, roomID)
 }
 
+func (d *Database) GetRoomThreadNotificationCounts(ctx context.Context, localpart string, serverName spec.ServerName, roomID string) (map[string]*eventutil.ThreadNotificationData, error) {
+	return d.Notifications.SelectRoomThreadCounts(ctx, nil, localpart, serverName, roomID)
+}
+
 func (d *Database) DeleteOldNotifications(ctx context.Context) error {
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
 		return d.Notifications.Clean(ctx, txn)
@@ -1149,3 +1249,35 @@ func (d *KeyDatabase) DeleteStaleDeviceLists(
 		return d.StaleDeviceListsTable.DeleteStaleDeviceLists(ctx, txn, userIDs)
 	})
 }
+
+// StoreLDAPUser records that the account was seen in the LDAP directory with the given DN.
+func (d *Database) StoreLDAPUser(ctx context.Context, localpart string, serverName spec.ServerName, dn string) error {
+	return d.Writer.Do(nil, nil, func(txn *sql.Tx) error {
+		return d.LDAPUsers.UpsertLDAPUser(ctx, txn, localpart, serverName, dn, time.Now().UnixMilli())
+	})
+}
+
+// GetLDAPUsers returns the DNs of the accounts seen in the LDAP directory, keyed by localpart.
+func (d *Database) GetLDAPUsers(ctx context.Context, serverName spec.ServerName) (map[string]string, error) {
+	return d.LDAPUsers.SelectLDAPUsers(ctx, nil, serverName)
+}
+
+// RemoveLDAPUser forgets that the account was seen in the LDAP directory.
+func (d *Database) RemoveLDAPUser(ctx context.Context, localpart string, serverName spec.ServerName) error {
+	return d.Writer.Do(nil, nil, func(txn *sql.Tx) error {
+		return d.LDAPUsers.DeleteLDAPUser(ctx, txn, localpart, serverName)
+	})
+}
+
+// SaveSSOIdentity links the account to the user of the identity provider.
+func (d *Database) SaveSSOIdentity(ctx context.Context, idpID, subject, localpart string, serverName spec.ServerName) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.SSOIdentities.InsertSSOIdentity(ctx, txn, idpID, subject, localpart, serverName, time.Now().UnixMilli())
+	})
+}
+
+// GetLocalpartForSSOIdentity returns the account linked to the user of the identity
+// provider, or an empty localpart if there is none.
+func (d *Database) GetLocalpartForSSOIdentity(ctx context.Context, idpID, subject string) (string, spec.ServerName, error) {
+	return d.SSOIdentities.SelectLocalpartForSSOIdentity(ctx, nil, idpID, subject)
+}
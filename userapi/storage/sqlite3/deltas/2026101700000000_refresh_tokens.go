@@ -0,0 +1,30 @@
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+func UpRefreshTokens(ctx context.Context, tx *sql.Tx) error {
+	// SQLite doesn't have "if not exists" for columns, so check if the column exists first.
+	// If the query doesn't return an error, the table was created with the new columns.
+	if rows, err := tx.QueryContext(ctx, "SELECT refresh_token FROM userapi_devices LIMIT 1"); err == nil {
+		_ = rows.Close()
+	} else {
+		_, err = tx.ExecContext(ctx, `
+ALTER TABLE userapi_devices ADD COLUMN refresh_token TEXT;
+ALTER TABLE userapi_devices ADD COLUMN previous_refresh_token TEXT;
+ALTER TABLE userapi_devices ADD COLUMN access_token_expires_ts BIGINT NOT NULL DEFAULT 0;`)
+		if err != nil {
+			return fmt.Errorf("failed to execute upgrade: %w", err)
+		}
+	}
+	_, err := tx.ExecContext(ctx, `
+CREATE UNIQUE INDEX IF NOT EXISTS userapi_device_refresh_token_idx ON userapi_devices(refresh_token);
+CREATE INDEX IF NOT EXISTS userapi_device_previous_refresh_token_idx ON userapi_devices(previous_refresh_token);`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
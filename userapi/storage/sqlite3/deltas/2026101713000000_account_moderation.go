@@ -0,0 +1,32 @@
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+func UpAccountModeration(ctx context.Context, tx *sql.Tx) error {
+	// SQLite doesn't have "if not exists" for columns, so check if the columns exist first.
+	// If the query doesn't return an error, the table was created with the new columns.
+	if rows, err := tx.QueryContext(ctx, "SELECT is_locked, is_shadow_banned FROM userapi_accounts LIMIT 1"); err == nil {
+		return rows.Close()
+	}
+	_, err := tx.ExecContext(ctx, `
+ALTER TABLE userapi_accounts ADD COLUMN is_locked BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE userapi_accounts ADD COLUMN is_shadow_banned BOOLEAN NOT NULL DEFAULT 0;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownAccountModeration(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+ALTER TABLE userapi_accounts DROP COLUMN is_locked;
+ALTER TABLE userapi_accounts DROP COLUMN is_shadow_banned;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/matrix-org/dendrite/internal"
+	"github.com/matrix-org/dendrite/internal/sqlutil"
+	"github.com/matrix-org/dendrite/userapi/storage/tables"
+	"github.com/matrix-org/gomatrixserverlib/spec"
+)
+
+const ldapUsersSchema = `
+-- Stores the accounts which were seen in the LDAP directory by the LDAP sync.
+CREATE TABLE IF NOT EXISTS userapi_ldap_users (
+	-- The Matrix user ID localpart of the account
+	localpart TEXT NOT NULL,
+	server_name TEXT NOT NULL,
+	-- The DN of the LDAP entry of the account
+	dn TEXT NOT NULL,
+	-- When the entry was last seen in the directory, as a unix timestamp (ms resolution).
+	last_seen_ts BIGINT NOT NULL,
+	PRIMARY KEY (localpart, server_name)
+);
+`
+
+const upsertLDAPUserSQL = "" +
+	"INSERT INTO userapi_ldap_users(localpart, server_name, dn, last_seen_ts) VALUES ($1, $2, $3, $4)" +
+	" ON CONFLICT (localpart, server_name) DO UPDATE SET dn = $3, last_seen_ts = $4"
+
+const selectLDAPUsersSQL = "" +
+	"SELECT localpart, dn FROM userapi_ldap_users WHERE server_name = $1"
+
+const deleteLDAPUserSQL = "" +
+	"DELETE FROM userapi_ldap_users WHERE localpart = $1 AND server_name = $2"
+
+type ldapUsersStatements struct {
+	upsertLDAPUserStmt  *sql.Stmt
+	selectLDAPUsersStmt *sql.Stmt
+	deleteLDAPUserStmt  *sql.Stmt
+}
+
+func NewSQLiteLDAPUsersTable(db *sql.DB) (tables.LDAPUsersTable, error) {
+	s := &ldapUsersStatements{}
+	_, err := db.Exec(ldapUsersSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.upsertLDAPUserStmt, upsertLDAPUserSQL},
+		{&s.selectLDAPUsersStmt, selectLDAPUsersSQL},
+		{&s.deleteLDAPUserStmt, deleteLDAPUserSQL},
+	}.Prepare(db)
+}
+
+func (s *ldapUsersStatements) UpsertLDAPUser(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, dn string, lastSeenTS int64,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.upsertLDAPUserStmt).ExecContext(ctx, localpart, serverName, dn, lastSeenTS)
+	return err
+}
+
+// SelectLDAPUsers returns the DNs of all accounts seen in the directory, keyed by localpart.
+func (s *ldapUsersStatements) SelectLDAPUsers(
+	ctx context.Context, txn *sql.Tx, serverName spec.ServerName,
+) (map[string]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectLDAPUsersStmt).QueryContext(ctx, serverName)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectLDAPUsers: rows.close() failed")
+	users := make(map[string]string)
+	for rows.Next() {
+		var localpart, dn string
+		if err = rows.Scan(&localpart, &dn); err != nil {
+			return nil, err
+		}
+		users[localpart] = dn
+	}
+	return users, rows.Err()
+}
+
+func (s *ldapUsersStatements) DeleteLDAPUser(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteLDAPUserStmt).ExecContext(ctx, localpart, serverName)
+	return err
+}
@@ -54,6 +54,11 @@ func NewUserDatabase(ctx context.Context, conMan *sqlutil.Connections, dbPropert
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteRegistrationsTokenTable: %w", err)
 	}
+	// The accounts table refers to the profiles table, so create it first.
+	profilesTable, err := NewSQLiteProfilesTable(db, serverNoticesLocalpart)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteProfilesTable: %w", err)
+	}
 	accountsTable, err := NewSQLiteAccountsTable(db, serverName)
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteAccountsTable: %w", err)
@@ -82,10 +87,6 @@ func NewUserDatabase(ctx context.Context, conMan *sqlutil.Connections, dbPropert
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteOpenIDTable: %w", err)
 	}
-	profilesTable, err := NewSQLiteProfilesTable(db, serverNoticesLocalpart)
-	if err != nil {
-		return nil, fmt.Errorf("NewSQLiteProfilesTable: %w", err)
-	}
 	threePIDTable, err := NewSQLiteThreePIDTable(db)
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteThreePIDTable: %w", err)
@@ -103,6 +104,15 @@ func NewUserDatabase(ctx context.Context, conMan *sqlutil.Connections, dbPropert
 		return nil, fmt.Errorf("NewSQLiteStatsTable: %w", err)
 	}
 
+	ldapUsersTable, err := NewSQLiteLDAPUsersTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteLDAPUsersTable: %w", err)
+	}
+	ssoIdentitiesTable, err := NewSQLiteSSOIdentitiesTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteSSOIdentitiesTable: %w", err)
+	}
+
 	m = sqlutil.NewMigrator(db)
 	m.AddMigrations(sqlutil.Migration{
 		Version: "userapi: server names populate",
@@ -127,6 +137,8 @@ func NewUserDatabase(ctx context.Context, conMan *sqlutil.Connections, dbPropert
 		Pushers:               pusherTable,
 		Notifications:         notificationsTable,
 		Stats:                 statsTable,
+		LDAPUsers:             ldapUsersTable,
+		SSOIdentities:         ssoIdentitiesTable,
 		ServerName:            serverName,
 		DB:                    db,
 		Writer:                writer,
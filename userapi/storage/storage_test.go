@@ -3,6 +3,7 @@ package storage_test
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -20,6 +21,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	"github.com/matrix-org/dendrite/internal/eventutil"
 	"github.com/matrix-org/dendrite/internal/pushrules"
 	"github.com/matrix-org/dendrite/setup/config"
 	"github.com/matrix-org/dendrite/test"
@@ -161,6 +163,71 @@ func Test_Accounts(t *testing.T) {
 	})
 }
 
+func Test_AccountModeration(t *testing.T) {
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+		domain := spec.ServerName("localhost")
+		_, err := db.CreateAccount(ctx, "alice", domain, "", "", api.AccountTypeAdmin)
+		assert.NoError(t, err)
+		_, err = db.CreateAccount(ctx, "bob", domain, "", "", api.AccountTypeUser)
+		assert.NoError(t, err)
+		_, err = db.CreateAccount(ctx, "charlie", domain, "", "", api.AccountTypeUser)
+		assert.NoError(t, err)
+		_, _, err = db.SetDisplayName(ctx, "charlie", domain, "Charlie Brown")
+		assert.NoError(t, err)
+		assert.NoError(t, db.DeactivateAccount(ctx, "bob", domain))
+
+		// lock and shadow-ban an account
+		assert.NoError(t, db.SetAccountLocked(ctx, "charlie", domain, true))
+		assert.NoError(t, db.SetAccountShadowBanned(ctx, "charlie", domain, true))
+		acc, err := db.GetAccountByLocalpart(ctx, "charlie", domain)
+		assert.NoError(t, err)
+		assert.True(t, acc.Locked)
+		assert.True(t, acc.ShadowBanned)
+		assert.NoError(t, db.SetAccountLocked(ctx, "charlie", domain, false))
+		acc, err = db.GetAccountByLocalpart(ctx, "charlie", domain)
+		assert.NoError(t, err)
+		assert.False(t, acc.Locked)
+		assert.True(t, acc.ShadowBanned)
+
+		yes, no := true, false
+		userIDs := func(accounts []api.Account) (ids []string) {
+			for _, acc := range accounts {
+				ids = append(ids, acc.Localpart)
+			}
+			return ids
+		}
+		testCases := []struct {
+			name        string
+			filter      string
+			admin       *bool
+			deactivated *bool
+			from, limit uint64
+			want        []string
+			wantTotal   int64
+		}{
+			{name: "all accounts", limit: 10, want: []string{"alice", "bob", "charlie"}, wantTotal: 3},
+			{name: "paginated", from: 1, limit: 1, want: []string{"bob"}, wantTotal: 3},
+			{name: "admins", admin: &yes, limit: 10, want: []string{"alice"}, wantTotal: 1},
+			{name: "non-admins", admin: &no, limit: 10, want: []string{"bob", "charlie"}, wantTotal: 2},
+			{name: "deactivated", deactivated: &yes, limit: 10, want: []string{"bob"}, wantTotal: 1},
+			{name: "active", deactivated: &no, limit: 10, want: []string{"alice", "charlie"}, wantTotal: 2},
+			{name: "by localpart", filter: "LIC", limit: 10, want: []string{"alice"}, wantTotal: 1},
+			{name: "by display name", filter: "brown", limit: 10, want: []string{"charlie"}, wantTotal: 1},
+			{name: "wildcards are escaped", filter: "%", limit: 10, wantTotal: 0},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				accounts, total, err := db.GetAccounts(ctx, tc.filter, tc.admin, tc.deactivated, tc.from, tc.limit)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.want, userIDs(accounts))
+				assert.Equal(t, tc.wantTotal, total)
+			})
+		}
+	})
+}
+
 func Test_Devices(t *testing.T) {
 	alice := test.NewUser(t)
 	localpart, domain, err := gomatrixserverlib.SplitID('@', alice.ID)
@@ -172,7 +239,7 @@ func Test_Devices(t *testing.T) {
 		db, close := mustCreateUserDatabase(t, dbType)
 		defer close()
 
-		deviceWithID, err := db.CreateDevice(ctx, localpart, domain, &deviceID, accessToken, nil, "", "")
+		deviceWithID, err := db.CreateDevice(ctx, localpart, domain, &deviceID, accessToken, nil, "", "", "", 0)
 		assert.NoError(t, err, "unable to create deviceWithoutID")
 
 		gotDevice, err := db.GetDeviceByID(ctx, localpart, domain, deviceID)
@@ -185,7 +252,7 @@ func Test_Devices(t *testing.T) {
 
 		// create a device without existing device ID
 		accessToken = util.RandomString(16)
-		deviceWithoutID, err := db.CreateDevice(ctx, localpart, domain, nil, accessToken, nil, "", "")
+		deviceWithoutID, err := db.CreateDevice(ctx, localpart, domain, nil, accessToken, nil, "", "", "", 0)
 		assert.NoError(t, err, "unable to create deviceWithoutID")
 		gotDeviceWithoutID, err := db.GetDeviceByID(ctx, localpart, domain, deviceWithoutID.ID)
 		assert.NoError(t, err, "unable to get device by id")
@@ -224,7 +291,7 @@ func Test_Devices(t *testing.T) {
 		// create one more device and remove the devices step by step
 		newDeviceID := util.RandomString(16)
 		accessToken = util.RandomString(16)
-		_, err = db.CreateDevice(ctx, localpart, domain, &newDeviceID, accessToken, nil, "", "")
+		_, err = db.CreateDevice(ctx, localpart, domain, &newDeviceID, accessToken, nil, "", "", "", 0)
 		assert.NoError(t, err, "unable to create new device")
 
 		devices, err = db.GetDevicesByLocalpart(ctx, localpart, domain)
@@ -244,6 +311,109 @@ func Test_Devices(t *testing.T) {
 	})
 }
 
+func Test_DeviceRefreshTokens(t *testing.T) {
+	alice := test.NewUser(t)
+	localpart, domain, err := gomatrixserverlib.SplitID('@', alice.ID)
+	assert.NoError(t, err)
+	deviceID := util.RandomString(8)
+	accessToken := util.RandomString(16)
+	refreshToken := util.RandomString(16)
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+
+		_, err := db.CreateDevice(ctx, localpart, domain, &deviceID, accessToken, nil, "", "", "", 0)
+		assert.NoError(t, err, "unable to create device")
+		dev, err := db.GetDeviceByAccessToken(ctx, accessToken)
+		assert.NoError(t, err, "unable to get device by access token")
+		assert.Equal(t, int64(0), dev.AccessTokenExpiresTS)
+
+		// unknown refresh tokens can't be used
+		_, err = db.RefreshDeviceTokens(ctx, refreshToken, util.RandomString(16), util.RandomString(16), 1)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		// creating the device again with a refresh token makes the access token expire
+		expiresTS := time.Now().Add(time.Minute).UnixMilli()
+		dev, err = db.CreateDevice(ctx, localpart, domain, &deviceID, accessToken, nil, "", "", refreshToken, expiresTS)
+		assert.NoError(t, err, "unable to create device with refresh token")
+		assert.Equal(t, expiresTS, dev.AccessTokenExpiresTS)
+		dev, err = db.GetDeviceByAccessToken(ctx, accessToken)
+		assert.NoError(t, err, "unable to get device by access token")
+		assert.Equal(t, expiresTS, dev.AccessTokenExpiresTS)
+
+		// refreshing replaces both tokens
+		newAccessToken, newRefreshToken := util.RandomString(16), util.RandomString(16)
+		dev, err = db.RefreshDeviceTokens(ctx, refreshToken, newAccessToken, newRefreshToken, expiresTS+1)
+		assert.NoError(t, err, "unable to refresh tokens")
+		assert.Equal(t, deviceID, dev.ID)
+		assert.Equal(t, alice.ID, dev.UserID)
+		assert.Equal(t, newAccessToken, dev.AccessToken)
+
+		_, err = db.GetDeviceByAccessToken(ctx, accessToken)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		// the old refresh token can be used again until the new tokens are used,
+		// in case the client didn't receive them
+		retriedAccessToken, retriedRefreshToken := util.RandomString(16), util.RandomString(16)
+		dev, err = db.RefreshDeviceTokens(ctx, refreshToken, retriedAccessToken, retriedRefreshToken, expiresTS+1)
+		assert.NoError(t, err, "unable to refresh tokens again")
+		assert.Equal(t, retriedAccessToken, dev.AccessToken)
+		_, err = db.GetDeviceByAccessToken(ctx, newAccessToken)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = db.RefreshDeviceTokens(ctx, newRefreshToken, util.RandomString(16), util.RandomString(16), 1)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		// using the new access token revokes the old refresh token
+		dev, err = db.GetDeviceByAccessToken(ctx, retriedAccessToken)
+		assert.NoError(t, err, "unable to get device by new access token")
+		assert.Equal(t, deviceID, dev.ID)
+		assert.Equal(t, expiresTS+1, dev.AccessTokenExpiresTS)
+		_, err = db.RefreshDeviceTokens(ctx, refreshToken, util.RandomString(16), util.RandomString(16), 1)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		// using the new refresh token revokes the one it was obtained with
+		latestAccessToken, latestRefreshToken := util.RandomString(16), util.RandomString(16)
+		_, err = db.RefreshDeviceTokens(ctx, retriedRefreshToken, latestAccessToken, latestRefreshToken, expiresTS+2)
+		assert.NoError(t, err, "unable to refresh tokens")
+		_, err = db.RefreshDeviceTokens(ctx, latestRefreshToken, util.RandomString(16), util.RandomString(16), expiresTS+3)
+		assert.NoError(t, err, "unable to refresh tokens without using the access token")
+		_, err = db.RefreshDeviceTokens(ctx, retriedRefreshToken, util.RandomString(16), util.RandomString(16), 1)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func Test_SSOIdentities(t *testing.T) {
+	alice := test.NewUser(t)
+	localpart, domain, err := gomatrixserverlib.SplitID('@', alice.ID)
+	assert.NoError(t, err)
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+
+		gotLocalpart, _, err := db.GetLocalpartForSSOIdentity(ctx, "idp", "subject")
+		assert.NoError(t, err)
+		assert.Equal(t, "", gotLocalpart)
+
+		err = db.SaveSSOIdentity(ctx, "idp", "subject", localpart, domain)
+		assert.NoError(t, err, "unable to save SSO identity")
+		gotLocalpart, gotDomain, err := db.GetLocalpartForSSOIdentity(ctx, "idp", "subject")
+		assert.NoError(t, err)
+		assert.Equal(t, localpart, gotLocalpart)
+		assert.Equal(t, domain, gotDomain)
+
+		// subjects are scoped to their identity provider
+		gotLocalpart, _, err = db.GetLocalpartForSSOIdentity(ctx, "other", "subject")
+		assert.NoError(t, err)
+		assert.Equal(t, "", gotLocalpart)
+
+		// a subject can only be linked once
+		err = db.SaveSSOIdentity(ctx, "idp", "subject", "bob", domain)
+		assert.Error(t, err)
+	})
+}
+
 func Test_KeyBackup(t *testing.T) {
 	alice := test.NewUser(t)
 	room := test.NewRoom(t, alice)
@@ -373,6 +543,36 @@ func Test_OpenID(t *testing.T) {
 	})
 }
 
+func Test_LDAPUsers(t *testing.T) {
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+
+		err := db.StoreLDAPUser(ctx, "alice", "localhost", "uid=alice,ou=people,dc=example,dc=com")
+		assert.NoError(t, err, "unable to store LDAP user")
+		err = db.StoreLDAPUser(ctx, "bob", "localhost", "uid=bob,ou=people,dc=example,dc=com")
+		assert.NoError(t, err, "unable to store LDAP user")
+		// Storing a user again updates the DN
+		err = db.StoreLDAPUser(ctx, "bob", "localhost", "uid=bob,ou=staff,dc=example,dc=com")
+		assert.NoError(t, err, "unable to store LDAP user")
+		err = db.StoreLDAPUser(ctx, "charlie", "otherserver", "uid=charlie,ou=people,dc=example,dc=com")
+		assert.NoError(t, err, "unable to store LDAP user")
+
+		users, err := db.GetLDAPUsers(ctx, "localhost")
+		assert.NoError(t, err, "unable to get LDAP users")
+		assert.Equal(t, map[string]string{
+			"alice": "uid=alice,ou=people,dc=example,dc=com",
+			"bob":   "uid=bob,ou=staff,dc=example,dc=com",
+		}, users)
+
+		err = db.RemoveLDAPUser(ctx, "alice", "localhost")
+		assert.NoError(t, err, "unable to remove LDAP user")
+		users, err = db.GetLDAPUsers(ctx, "localhost")
+		assert.NoError(t, err, "unable to get LDAP users")
+		assert.Equal(t, map[string]string{"bob": "uid=bob,ou=staff,dc=example,dc=com"}, users)
+	})
+}
+
 func Test_Profile(t *testing.T) {
 	alice := test.NewUser(t)
 	aliceLocalpart, aliceDomain, err := gomatrixserverlib.SplitID('@', alice.ID)
@@ -541,7 +741,7 @@ func Test_Notification(t *testing.T) {
 				RoomID: roomID,
 				TS:     spec.AsTimestamp(ts),
 			}
-			err = db.InsertNotification(ctx, aliceLocalpart, aliceDomain, eventID, uint64(i+1), nil, notification)
+			err = db.InsertNotification(ctx, aliceLocalpart, aliceDomain, eventID, "", uint64(i+1), nil, notification)
 			assert.NoError(t, err, "unable to insert notification")
 		}
 
@@ -559,7 +759,7 @@ func Test_Notification(t *testing.T) {
 		assert.Equal(t, int64(4), total)
 
 		// mark notification as read
-		affected, err := db.SetNotificationsRead(ctx, aliceLocalpart, aliceDomain, room2.ID, 7, true)
+		affected, err := db.SetNotificationsRead(ctx, aliceLocalpart, aliceDomain, room2.ID, "", 7, true)
 		assert.NoError(t, err, "unable to set notifications read")
 		assert.True(t, affected)
 
@@ -583,6 +783,71 @@ func Test_Notification(t *testing.T) {
 	})
 }
 
+func Test_ThreadNotifications(t *testing.T) {
+	alice := test.NewUser(t)
+	aliceLocalpart, aliceDomain, err := gomatrixserverlib.SplitID('@', alice.ID)
+	assert.NoError(t, err)
+	room := test.NewRoom(t, alice)
+	ctx := context.Background()
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+		// two notifications in the main timeline, three in thread1 and one in thread2
+		threadIDs := []string{"", "", "$thread1", "$thread1", "$thread1", "$thread2"}
+		for i, threadID := range threadIDs {
+			notification := &api.Notification{
+				Event: synctypes.ClientEvent{
+					Content: spec.RawJSON("{}"),
+				},
+				RoomID: room.ID,
+				TS:     spec.AsTimestamp(time.Now()),
+			}
+			tweaks := map[string]interface{}{"highlight": i == 4}
+			err = db.InsertNotification(ctx, aliceLocalpart, aliceDomain, util.RandomString(16), threadID, uint64(i+1), tweaks, notification)
+			assert.NoError(t, err, "unable to insert notification")
+		}
+
+		total, highlight, err := db.GetRoomNotificationCounts(ctx, aliceLocalpart, aliceDomain, room.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(6), total)
+		assert.Equal(t, int64(1), highlight)
+		threads, err := db.GetRoomThreadNotificationCounts(ctx, aliceLocalpart, aliceDomain, room.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]*eventutil.ThreadNotificationData{
+			"$thread1": {UnreadNotificationCount: 3, UnreadHighlightCount: 1},
+			"$thread2": {UnreadNotificationCount: 1},
+		}, threads)
+
+		// a threaded receipt only marks that thread as read
+		affected, err := db.SetNotificationsRead(ctx, aliceLocalpart, aliceDomain, room.ID, "$thread1", 10, true)
+		assert.NoError(t, err)
+		assert.True(t, affected)
+		total, highlight, err = db.GetRoomNotificationCounts(ctx, aliceLocalpart, aliceDomain, room.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), total)
+		assert.Equal(t, int64(0), highlight)
+
+		// a receipt for the main timeline doesn't touch threads
+		_, err = db.SetNotificationsRead(ctx, aliceLocalpart, aliceDomain, room.ID, "main", 10, true)
+		assert.NoError(t, err)
+		total, _, err = db.GetRoomNotificationCounts(ctx, aliceLocalpart, aliceDomain, room.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+		threads, err = db.GetRoomThreadNotificationCounts(ctx, aliceLocalpart, aliceDomain, room.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]*eventutil.ThreadNotificationData{
+			"$thread2": {UnreadNotificationCount: 1},
+		}, threads)
+
+		// an unthreaded receipt marks everything as read
+		_, err = db.SetNotificationsRead(ctx, aliceLocalpart, aliceDomain, room.ID, "", 10, true)
+		assert.NoError(t, err)
+		total, _, err = db.GetRoomNotificationCounts(ctx, aliceLocalpart, aliceDomain, room.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), total)
+	})
+}
+
 func mustCreateKeyDatabase(t *testing.T, dbType test.DBType) (storage.KeyDatabase, func()) {
 	cfg, processCtx, close := testrig.CreateConfig(t, dbType)
 	cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
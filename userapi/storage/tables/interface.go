@@ -27,6 +27,7 @@ import (
 
 	clientapi "github.com/matrix-org/dendrite/clientapi/api"
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
+	"github.com/matrix-org/dendrite/internal/eventutil"
 	"github.com/matrix-org/dendrite/userapi/types"
 )
 
@@ -48,10 +49,16 @@ type AccountDataTable interface {
 type AccountsTable interface {
 	InsertAccount(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, hash, appserviceID string, accountType api.AccountType) (*api.Account, error)
 	UpdatePassword(ctx context.Context, localpart string, serverName spec.ServerName, passwordHash string) (err error)
+	UpdateAccountType(ctx context.Context, localpart string, serverName spec.ServerName, accountType api.AccountType) (err error)
 	DeactivateAccount(ctx context.Context, localpart string, serverName spec.ServerName) (err error)
 	SelectPasswordHash(ctx context.Context, localpart string, serverName spec.ServerName) (hash string, err error)
 	SelectAccountByLocalpart(ctx context.Context, localpart string, serverName spec.ServerName) (*api.Account, error)
 	SelectNewNumericLocalpart(ctx context.Context, txn *sql.Tx, serverName spec.ServerName) (id int64, err error)
+	UpdateAccountLocked(ctx context.Context, localpart string, serverName spec.ServerName, locked bool) (err error)
+	UpdateAccountShadowBanned(ctx context.Context, localpart string, serverName spec.ServerName, shadowBanned bool) (err error)
+	// SelectAccounts returns the accounts whose localpart or display name contain the name, if given,
+	// filtered by being an admin or deactivated if set, along with the total number of matching accounts.
+	SelectAccounts(ctx context.Context, name string, admin, deactivated *bool, from, limit uint64) ([]api.Account, int64, error)
 }
 
 type DevicesTable interface {
@@ -61,11 +68,14 @@ type DevicesTable interface {
 	DeleteDevices(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, devices []string) error
 	DeleteDevicesByLocalpart(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, exceptDeviceID string) error
 	UpdateDeviceName(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, deviceID string, displayName *string) error
-	SelectDeviceByToken(ctx context.Context, accessToken string) (*api.Device, error)
+	SelectDeviceByToken(ctx context.Context, accessToken string) (dev *api.Device, hasPreviousRefreshToken bool, err error)
+	SelectDeviceByRefreshToken(ctx context.Context, txn *sql.Tx, refreshToken string) (*api.Device, error)
 	SelectDeviceByID(ctx context.Context, localpart string, serverName spec.ServerName, deviceID string) (*api.Device, error)
 	SelectDevicesByLocalpart(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, exceptDeviceID string) ([]api.Device, error)
 	SelectDevicesByID(ctx context.Context, deviceIDs []string) ([]api.Device, error)
 	UpdateDeviceLastSeen(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, deviceID, ipAddr, userAgent string) error
+	UpdateDeviceTokens(ctx context.Context, txn *sql.Tx, oldAccessToken, accessToken, refreshToken, previousRefreshToken string, expiresTS int64) error
+	DeletePreviousRefreshToken(ctx context.Context, txn *sql.Tx, accessToken string) error
 }
 
 type KeyBackupTable interface {
@@ -91,6 +101,17 @@ type LoginTokenTable interface {
 	SelectLoginToken(ctx context.Context, token string) (*api.LoginTokenData, error)
 }
 
+type SSOIdentitiesTable interface {
+	InsertSSOIdentity(ctx context.Context, txn *sql.Tx, idpID, subject, localpart string, serverName spec.ServerName, createdTS int64) error
+	SelectLocalpartForSSOIdentity(ctx context.Context, txn *sql.Tx, idpID, subject string) (localpart string, serverName spec.ServerName, err error)
+}
+
+type LDAPUsersTable interface {
+	UpsertLDAPUser(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, dn string, lastSeenTS int64) error
+	SelectLDAPUsers(ctx context.Context, txn *sql.Tx, serverName spec.ServerName) (map[string]string, error)
+	DeleteLDAPUser(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName) error
+}
+
 type OpenIDTable interface {
 	InsertOpenIDToken(ctx context.Context, txn *sql.Tx, token, localpart string, serverName spec.ServerName, expiresAtMS int64) (err error)
 	SelectOpenIDTokenAtrributes(ctx context.Context, token string) (*api.OpenIDTokenAttributes, error)
@@ -120,12 +141,13 @@ type PusherTable interface {
 
 type NotificationTable interface {
 	Clean(ctx context.Context, txn *sql.Tx) error
-	Insert(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, eventID string, pos uint64, highlight bool, n *api.Notification) error
+	Insert(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, eventID, threadID string, pos uint64, highlight bool, n *api.Notification) error
 	DeleteUpTo(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, roomID string, pos uint64) (affected bool, _ error)
-	UpdateRead(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, roomID string, pos uint64, v bool) (affected bool, _ error)
+	UpdateRead(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, roomID, threadID string, pos uint64, v bool) (affected bool, _ error)
 	Select(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, fromID int64, limit int, filter NotificationFilter) ([]*api.Notification, int64, error)
 	SelectCount(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, filter NotificationFilter) (int64, error)
 	SelectRoomCounts(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, roomID string) (total int64, highlight int64, _ error)
+	SelectRoomThreadCounts(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, roomID string) (map[string]*eventutil.ThreadNotificationData, error)
 }
 
 type StatsTable interface {
@@ -43,6 +43,10 @@ func mustMakeDBs(t *testing.T, dbType test.DBType) (
 
 	switch dbType {
 	case test.DBTypeSQLite:
+		// The accounts table refers to the profiles table.
+		if _, err = sqlite3.NewSQLiteProfilesTable(db, ""); err != nil {
+			t.Fatalf("unable to create profiles db: %v", err)
+		}
 		accTable, err = sqlite3.NewSQLiteAccountsTable(db, "localhost")
 		if err != nil {
 			t.Fatalf("unable to create acc db: %v", err)
@@ -56,6 +60,10 @@ func mustMakeDBs(t *testing.T, dbType test.DBType) (
 			t.Fatalf("unable to open stats db: %v", err)
 		}
 	case test.DBTypePostgres:
+		// The accounts table refers to the profiles table.
+		if _, err = postgres.NewPostgresProfilesTable(db, ""); err != nil {
+			t.Fatalf("unable to create profiles db: %v", err)
+		}
 		accTable, err = postgres.NewPostgresAccountsTable(db, "localhost")
 		if err != nil {
 			t.Fatalf("unable to create acc db: %v", err)
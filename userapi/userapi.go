@@ -83,6 +83,7 @@ func NewInternalAPI(
 		// here.
 		dendriteCfg.Global.JetStream.Prefixed(jetstream.OutputClientData),
 		dendriteCfg.Global.JetStream.Prefixed(jetstream.OutputNotificationData),
+		dendriteCfg.Global.JetStream.Prefixed(jetstream.OutputAccountDeactivation),
 	)
 	keyChangeProducer := &producers.KeyChange{
 		Topic:     dendriteCfg.Global.JetStream.Prefixed(jetstream.OutputKeyChangeEvent),
@@ -154,6 +155,11 @@ func NewInternalAPI(
 	}
 	time.AfterFunc(time.Minute, cleanOldNotifs)
 
+	if dendriteCfg.UserAPI.LdapSync.Enabled {
+		userAPI.LDAPSyncer = internal.NewLDAPSyncer(userAPI, &dendriteCfg.UserAPI)
+		userAPI.LDAPSyncer.Start(processContext)
+	}
+
 	if dendriteCfg.Global.ReportStats.Enabled {
 		go util.StartPhoneHomeCollector(time.Now(), dendriteCfg, db)
 	}
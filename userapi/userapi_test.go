@@ -25,6 +25,8 @@ import (
 	api2 "github.com/matrix-org/dendrite/appservice/api"
 	"github.com/matrix-org/dendrite/clientapi/auth/authtypes"
 	"github.com/matrix-org/dendrite/internal/sqlutil"
+	rsapi "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/syncapi/synctypes"
 	"github.com/matrix-org/dendrite/userapi/producers"
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/gomatrixserverlib/fclient"
@@ -97,7 +99,7 @@ func MustMakeInternalAPI(t *testing.T, opts apiTestOpts, dbType test.DBType, pub
 		publisher = &dummyProducer{t: t}
 	}
 
-	syncProducer := producers.NewSyncAPI(accountDB, publisher, "client_data", "notification_data")
+	syncProducer := producers.NewSyncAPI(accountDB, publisher, "client_data", "notification_data", "account_deactivation")
 	keyChangeProducer := &producers.KeyChange{DB: keyDB, JetStream: publisher, Topic: "keychange"}
 	return &internal.UserInternalAPI{
 			DB:                accountDB,
@@ -665,3 +667,361 @@ func TestDeviceIDReuse(t *testing.T) {
 		})
 	})
 }
+
+func TestTokenRefresh(t *testing.T) {
+	ctx := context.Background()
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		intAPI, _, close := MustMakeInternalAPI(t, apiTestOpts{serverName: "test"}, dbType, nil)
+		defer close()
+
+		accRes := api.PerformAccountCreationResponse{}
+		err := intAPI.PerformAccountCreation(ctx, &api.PerformAccountCreationRequest{
+			AccountType: api.AccountTypeUser,
+			Localpart:   "alice",
+			ServerName:  "test",
+			Password:    "password",
+		}, &accRes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		devRes := api.PerformDeviceCreationResponse{}
+		err = intAPI.PerformDeviceCreation(ctx, &api.PerformDeviceCreationRequest{
+			Localpart:          "alice",
+			ServerName:         "test",
+			AccessToken:        "access1",
+			RefreshToken:       "refresh1",
+			NoDeviceListUpdate: true,
+		}, &devRes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if devRes.Device.AccessTokenExpiresTS <= time.Now().UnixMilli() {
+			t.Fatalf("expected access token to expire in the future, got %d", devRes.Device.AccessTokenExpiresTS)
+		}
+
+		queryRes := api.QueryAccessTokenResponse{}
+		if err = intAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: "access1"}, &queryRes); err != nil {
+			t.Fatal(err)
+		}
+		if queryRes.Device == nil || queryRes.Expired {
+			t.Fatalf("expected access token to be valid, got %+v", queryRes)
+		}
+
+		// unknown refresh tokens are rejected
+		refreshRes := api.PerformTokenRefreshResponse{}
+		err = intAPI.PerformTokenRefresh(ctx, &api.PerformTokenRefreshRequest{
+			RefreshToken: "unknown", NewAccessToken: "access2", NewRefreshToken: "refresh2",
+		}, &refreshRes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refreshRes.Device != nil {
+			t.Fatalf("expected no device for unknown refresh token")
+		}
+
+		// refreshing rotates both tokens
+		err = intAPI.PerformTokenRefresh(ctx, &api.PerformTokenRefreshRequest{
+			RefreshToken: "refresh1", NewAccessToken: "access2", NewRefreshToken: "refresh2",
+		}, &refreshRes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refreshRes.Device == nil || refreshRes.Device.ID != devRes.Device.ID || refreshRes.Device.AccessToken != "access2" {
+			t.Fatalf("unexpected refreshed device %+v", refreshRes.Device)
+		}
+		queryRes = api.QueryAccessTokenResponse{}
+		if err = intAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: "access1"}, &queryRes); err != nil {
+			t.Fatal(err)
+		}
+		if queryRes.Device != nil || queryRes.Expired {
+			t.Fatalf("expected old access token to be unknown, got %+v", queryRes)
+		}
+
+		// expired access tokens are rejected, but can still be refreshed
+		intAPI.(*internal.UserInternalAPI).Config.RefreshableAccessTokenLifetimeMS = -1000
+		err = intAPI.PerformTokenRefresh(ctx, &api.PerformTokenRefreshRequest{
+			RefreshToken: "refresh2", NewAccessToken: "access3", NewRefreshToken: "refresh3",
+		}, &refreshRes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queryRes = api.QueryAccessTokenResponse{}
+		if err = intAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: "access3"}, &queryRes); err != nil {
+			t.Fatal(err)
+		}
+		if queryRes.Device != nil || !queryRes.Expired {
+			t.Fatalf("expected access token to be expired, got %+v", queryRes)
+		}
+		refreshRes = api.PerformTokenRefreshResponse{}
+		err = intAPI.PerformTokenRefresh(ctx, &api.PerformTokenRefreshRequest{
+			RefreshToken: "refresh3", NewAccessToken: "access4", NewRefreshToken: "refresh4",
+		}, &refreshRes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refreshRes.Device == nil {
+			t.Fatalf("expected expired access token to be refreshable")
+		}
+	})
+}
+
+type fakeLDAPDirectory struct {
+	users  []internal.LDAPUser
+	groups map[string][]string
+	// unavailable groups fail to be looked up
+	unavailable map[string]bool
+}
+
+func (d *fakeLDAPDirectory) Users(ctx context.Context) ([]internal.LDAPUser, error) {
+	return d.users, nil
+}
+
+func (d *fakeLDAPDirectory) GroupMembers(ctx context.Context, groupDN, attribute string) ([]string, error) {
+	if d.unavailable[groupDN] {
+		return nil, fmt.Errorf("group %s is unavailable", groupDN)
+	}
+	return d.groups[groupDN], nil
+}
+
+type fakeLDAPRoomserverAPI struct {
+	rsapi.UserRoomserverAPI
+	joined map[string]map[string]bool
+}
+
+func (f *fakeLDAPRoomserverAPI) QueryMembershipsForRoom(ctx context.Context, req *rsapi.QueryMembershipsForRoomRequest, res *rsapi.QueryMembershipsForRoomResponse) error {
+	for userID := range f.joined[req.RoomID] {
+		stateKey := userID
+		res.JoinEvents = append(res.JoinEvents, synctypes.ClientEvent{Type: spec.MRoomMember, StateKey: &stateKey})
+	}
+	return nil
+}
+
+func (f *fakeLDAPRoomserverAPI) QueryUserIDForSender(ctx context.Context, roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+	return spec.NewUserID(string(senderID), true)
+}
+
+func (f *fakeLDAPRoomserverAPI) PerformJoin(ctx context.Context, req *rsapi.PerformJoinRequest) (string, spec.ServerName, error) {
+	f.joined[req.RoomIDOrAlias][req.UserID] = true
+	return req.RoomIDOrAlias, serverName, nil
+}
+
+func (f *fakeLDAPRoomserverAPI) PerformLeave(ctx context.Context, req *rsapi.PerformLeaveRequest, res *rsapi.PerformLeaveResponse) error {
+	delete(f.joined[req.RoomID], req.Leaver.String())
+	return nil
+}
+
+func (f *fakeLDAPRoomserverAPI) PerformAdminEvacuateUser(ctx context.Context, userID string) ([]string, error) {
+	for _, joined := range f.joined {
+		delete(joined, userID)
+	}
+	return nil, nil
+}
+
+func TestLDAPSync(t *testing.T) {
+	ctx := context.Background()
+	roomID := "!staff:example.com"
+	userID := func(localpart string) string {
+		return fmt.Sprintf("@%s:%s", localpart, serverName)
+	}
+
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		intAPI, db, close := MustMakeInternalAPI(t, apiTestOpts{}, dbType, nil)
+		defer close()
+		userAPI := intAPI.(*internal.UserInternalAPI)
+		rsAPI := &fakeLDAPRoomserverAPI{joined: map[string]map[string]bool{
+			// The room admin isn't an LDAP user, and mustn't be removed.
+			roomID: {userID("admin"): true},
+		}}
+		userAPI.RSAPI = rsAPI
+
+		// bob already has an account, but doesn't have a display name yet
+		if _, err := db.CreateAccount(ctx, "bob", serverName, "", "", api.AccountTypeUser); err != nil {
+			t.Fatal(err)
+		}
+		directory := &fakeLDAPDirectory{
+			users: []internal.LDAPUser{
+				{DN: "uid=alice,ou=people,dc=example,dc=com", Username: "alice", DisplayName: "Alice"},
+				{DN: "uid=bob,ou=people,dc=example,dc=com", Username: "bob", DisplayName: "Bob"},
+				{DN: "uid=Invalid,ou=people,dc=example,dc=com", Username: "Invalid!"},
+			},
+			groups: map[string][]string{
+				// Members may be listed by DN or by username
+				"cn=staff,ou=groups,dc=example,dc=com": {"UID=alice,ou=people,dc=example,dc=com", "bob"},
+			},
+		}
+		cfg := &config.LdapSync{
+			Enabled:              true,
+			DisplayNameAttribute: "displayName",
+			CreateAccounts:       true,
+			DeactivateRemoved:    true,
+			GroupRooms: []config.LdapGroupRooms{{
+				GroupDn:      "cn=staff,ou=groups,dc=example,dc=com",
+				Rooms:        []string{roomID},
+				LeaveRemoved: true,
+			}},
+		}
+		userAPI.LDAPSyncer = &internal.LDAPSyncer{API: userAPI, Config: cfg, Directory: directory}
+
+		// A dry run only reports the changes.
+		var res api.PerformLDAPSyncResponse
+		if err := userAPI.PerformLDAPSync(ctx, &api.PerformLDAPSyncRequest{DryRun: true}, &res); err != nil {
+			t.Fatal(err)
+		}
+		want := api.PerformLDAPSyncResponse{
+			DryRun:       true,
+			Created:      []string{userID("alice")},
+			Deactivated:  []string{},
+			DisplayNames: map[string]string{userID("alice"): "Alice", userID("bob"): "Bob"},
+			Joined:       map[string][]string{roomID: {userID("alice"), userID("bob")}},
+			Left:         map[string][]string{},
+		}
+		if len(res.Errors) != 1 {
+			t.Fatalf("expected the invalid username to be reported, got %v", res.Errors)
+		}
+		res.Errors = nil
+		if !reflect.DeepEqual(res, want) {
+			t.Fatalf("unexpected dry run result:\n%+v\nwant\n%+v", res, want)
+		}
+		if _, err := db.GetAccountByLocalpart(ctx, "alice", serverName); err == nil {
+			t.Fatalf("dry run created an account")
+		}
+
+		res = api.PerformLDAPSyncResponse{}
+		if err := userAPI.PerformLDAPSync(ctx, &api.PerformLDAPSyncRequest{}, &res); err != nil {
+			t.Fatal(err)
+		}
+		want.DryRun = false
+		res.Errors = nil
+		if !reflect.DeepEqual(res, want) {
+			t.Fatalf("unexpected sync result:\n%+v\nwant\n%+v", res, want)
+		}
+		profile, err := db.GetProfileByLocalpart(ctx, "alice", serverName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile.DisplayName != "Alice" {
+			t.Fatalf("expected display name Alice, got %q", profile.DisplayName)
+		}
+		if !rsAPI.joined[roomID][userID("alice")] || !rsAPI.joined[roomID][userID("bob")] {
+			t.Fatalf("expected group members to be joined, got %v", rsAPI.joined[roomID])
+		}
+
+		// bob is removed from the group, and alice from the directory.
+		directory.users = directory.users[1:2]
+		directory.groups["cn=staff,ou=groups,dc=example,dc=com"] = nil
+		res = api.PerformLDAPSyncResponse{}
+		if err = userAPI.PerformLDAPSync(ctx, &api.PerformLDAPSyncRequest{}, &res); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res.Deactivated, []string{userID("alice")}) {
+			t.Fatalf("expected alice to be deactivated, got %v", res.Deactivated)
+		}
+		if !reflect.DeepEqual(res.Left, map[string][]string{roomID: {userID("bob")}}) {
+			t.Fatalf("expected bob to leave, got %v", res.Left)
+		}
+		if !reflect.DeepEqual(rsAPI.joined[roomID], map[string]bool{userID("admin"): true}) {
+			t.Fatalf("unexpected room members %v", rsAPI.joined[roomID])
+		}
+		tracked, err := db.GetLDAPUsers(ctx, serverName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := tracked["alice"]; ok {
+			t.Fatalf("deactivated account is still tracked")
+		}
+
+		// Nobody is removed from a room while one of its groups is unavailable,
+		// even if a later group of the room removes members.
+		rsAPI.joined[roomID][userID("bob")] = true
+		directory.unavailable = map[string]bool{"cn=unavailable,ou=groups,dc=example,dc=com": true}
+		cfg.GroupRooms = []config.LdapGroupRooms{{
+			GroupDn: "cn=unavailable,ou=groups,dc=example,dc=com",
+			Rooms:   []string{roomID},
+		}, {
+			GroupDn:      "cn=staff,ou=groups,dc=example,dc=com",
+			Rooms:        []string{roomID},
+			LeaveRemoved: true,
+		}}
+		res = api.PerformLDAPSyncResponse{}
+		if err = userAPI.PerformLDAPSync(ctx, &api.PerformLDAPSyncRequest{}, &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Errors) != 1 {
+			t.Fatalf("expected the unavailable group to be reported, got %v", res.Errors)
+		}
+		if len(res.Left) != 0 || !rsAPI.joined[roomID][userID("bob")] {
+			t.Fatalf("expected nobody to leave, got %v", res.Left)
+		}
+
+		// An empty directory doesn't deactivate everyone.
+		directory.users = nil
+		if err = userAPI.PerformLDAPSync(ctx, &api.PerformLDAPSyncRequest{}, &res); err == nil {
+			t.Fatalf("expected sync of an empty directory to fail")
+		}
+	})
+}
+
+func TestAdminAccountUpdate(t *testing.T) {
+	ctx := context.Background()
+	test.WithPostgresAndSQLite(t, func(t *testing.T, dbType test.DBType) {
+		intAPI, _, close := MustMakeInternalAPI(t, apiTestOpts{serverName: "test"}, dbType, nil)
+		defer close()
+
+		accRes := api.PerformAccountCreationResponse{}
+		err := intAPI.PerformAccountCreation(ctx, &api.PerformAccountCreationRequest{
+			AccountType: api.AccountTypeUser,
+			Localpart:   "alice",
+			ServerName:  "test",
+			Password:    "password",
+		}, &accRes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		devRes := api.PerformDeviceCreationResponse{}
+		err = intAPI.PerformDeviceCreation(ctx, &api.PerformDeviceCreationRequest{
+			Localpart:          "alice",
+			ServerName:         "test",
+			AccessToken:        "access1",
+			NoDeviceListUpdate: true,
+		}, &devRes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Flags which aren't set are left unchanged.
+		locked, shadowBanned := true, false
+		for _, req := range []api.PerformAdminAccountUpdateRequest{
+			{Localpart: "alice", ServerName: "test", ShadowBanned: &shadowBanned},
+			{Localpart: "alice", ServerName: "test", Locked: &locked},
+		} {
+			req := req
+			if err = intAPI.PerformAdminAccountUpdate(ctx, &req); err != nil {
+				t.Fatal(err)
+			}
+		}
+		queryRes := api.QueryAccessTokenResponse{}
+		if err = intAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: "access1"}, &queryRes); err != nil {
+			t.Fatal(err)
+		}
+		if queryRes.Device == nil || !queryRes.Device.AccountLocked || queryRes.Device.AccountShadowBanned {
+			t.Fatalf("expected the device of a locked account, got %+v", queryRes.Device)
+		}
+
+		// Remote accounts can't be updated.
+		err = intAPI.PerformAdminAccountUpdate(ctx, &api.PerformAdminAccountUpdateRequest{
+			Localpart: "alice", ServerName: "remote", Locked: &locked,
+		})
+		if err == nil {
+			t.Fatalf("expected an error updating a remote account")
+		}
+
+		accounts, total, err := intAPI.QueryAdminAccounts(ctx, &api.QueryAdminAccountsRequest{Name: "ali", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 1 || len(accounts) != 1 || accounts[0].UserID != "@alice:test" || !accounts[0].Locked {
+			t.Fatalf("unexpected accounts %+v (total %d)", accounts, total)
+		}
+	})
+}
@@ -114,7 +114,7 @@ func TestNotifyUserCountsAsync(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		if err := db.InsertNotification(ctx, aliceLocalpart, serverName, dummyEvent.EventID(), 0, nil, &api.Notification{
+		if err := db.InsertNotification(ctx, aliceLocalpart, serverName, dummyEvent.EventID(), "", 0, nil, &api.Notification{
 			Event: *ev,
 		}); err != nil {
 			t.Error(err)